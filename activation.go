@@ -0,0 +1,84 @@
+package stunner
+
+import (
+	"net"
+	"os"
+	"strconv"
+
+	"github.com/l7mp/stunner/internal/systemd"
+)
+
+// activatedSocket is a listening socket passed by systemd socket activation
+type activatedSocket struct {
+	network string // udp or tcp
+	ip      net.IP
+	port    int
+	file    *os.File
+}
+
+// activateSockets loads the listening sockets passed by systemd socket activation on the first
+// reconciliation. The sockets are kept open for the lifetime of the process: systemd holds them
+// bound, so the listeners at their addresses get a duplicate of the socket on each start instead
+// of binding the address again
+func (s *Stunner) activateSockets() {
+	if !s.options.SocketActivation || s.socketsActivated {
+		return
+	}
+	s.socketsActivated = true
+
+	for _, f := range systemd.ListenFiles() {
+		sock := activatedSocket{file: f}
+		if c, err := net.FilePacketConn(f); err == nil {
+			if addr, ok := c.LocalAddr().(*net.UDPAddr); ok {
+				sock.network, sock.ip, sock.port = "udp", addr.IP, addr.Port
+			}
+			c.Close()
+		} else if ln, err := net.FileListener(f); err == nil {
+			if addr, ok := ln.Addr().(*net.TCPAddr); ok {
+				sock.network, sock.ip, sock.port = "tcp", addr.IP, addr.Port
+			}
+			ln.Close()
+		}
+		if sock.network == "" {
+			s.log.Warnf("ignoring socket %s passed by socket activation: not a UDP or TCP "+
+				"listening socket", f.Name())
+			f.Close()
+			continue
+		}
+
+		s.log.Infof("socket activation: %s socket at %s", sock.network,
+			net.JoinHostPort(sock.ip.String(), strconv.Itoa(sock.port)))
+		s.activated = append(s.activated, sock)
+	}
+}
+
+// activatedSocket returns the socket passed by socket activation at an address, or nil if none.
+// The wildcard addresses of IPv4 and IPv6 match each other, as systemd binds the dual-stack
+// wildcard address for the ports given without an address
+func (s *Stunner) activatedSocket(network, addr string) *os.File {
+	host, p, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil
+	}
+	ip := net.ParseIP(host)
+	port, err := strconv.Atoi(p)
+	if err != nil || ip == nil {
+		return nil
+	}
+
+	for _, sock := range s.activated {
+		if sock.network == network && sock.port == port &&
+			(sock.ip.Equal(ip) || sock.ip.IsUnspecified() && ip.IsUnspecified()) {
+			return sock.file
+		}
+	}
+	return nil
+}
+
+// closeActivatedSockets closes the sockets passed by socket activation
+func (s *Stunner) closeActivatedSockets() {
+	for _, sock := range s.activated {
+		sock.file.Close()
+	}
+	s.activated = nil
+}
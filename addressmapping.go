@@ -0,0 +1,102 @@
+package stunner
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/l7mp/stunner/internal/addrmap"
+	"github.com/l7mp/stunner/internal/api"
+	"github.com/l7mp/stunner/pkg/apis/v1alpha1"
+)
+
+// AddressMappingStatus is an entry of the response of the /api/v1/addressmapping admin API
+type AddressMappingStatus struct {
+	// External is the peer address the clients use, with the port if the mapping is for a
+	// single port
+	External string `json:"external"`
+	// Internal is the address the peer is reached at
+	Internal string `json:"internal"`
+	// Source is "static" or "hairpin"
+	Source string `json:"source"`
+	// Listener is the listener of a hairpin mapping
+	Listener string `json:"listener,omitempty"`
+}
+
+// reconcileAddressMapping sets the address-mapping table of the relay transports from the admin
+// config and the public addresses of the listeners, or clears the table if no address mapping is
+// configured
+func (s *Stunner) reconcileAddressMapping() {
+	req := s.GetAdmin().AddressMapping
+	if req == nil {
+		s.addressMap.SetMappings(nil)
+		return
+	}
+
+	mappings := []addrmap.Mapping{}
+	for _, m := range req.Mappings {
+		// validated
+		ext, extPort, err := v1alpha1.ParseMappedAddress(m.External)
+		if err != nil {
+			continue
+		}
+		in, inPort, err := v1alpha1.ParseMappedAddress(m.Internal)
+		if err != nil {
+			continue
+		}
+		mappings = append(mappings, addrmap.Mapping{External: ext, ExternalPort: extPort,
+			Internal: in, InternalPort: inPort, Source: addrmap.SourceStatic})
+	}
+
+	if req.Hairpin {
+		for _, name := range s.listenerManager.Keys() {
+			l := s.GetListener(name)
+			if l == nil || l.PublicAddr == "" {
+				continue
+			}
+			public := net.ParseIP(l.PublicAddr)
+			if public == nil || public.Equal(l.Addr) {
+				continue
+			}
+			// the peers cannot be reached at a wildcard address
+			if l.Addr.IsUnspecified() {
+				s.log.Debugf("no hairpin address mapping for listener %q: listener address "+
+					"%s unspecified", l.Name, l.Addr)
+				continue
+			}
+			mappings = append(mappings, addrmap.Mapping{External: public, Internal: l.Addr,
+				Source: addrmap.SourceHairpin, Listener: l.Name})
+			if l.PublicPort != 0 && l.PublicPort != l.Port {
+				mappings = append(mappings, addrmap.Mapping{External: public,
+					ExternalPort: l.PublicPort, Internal: l.Addr, InternalPort: l.Port,
+					Source: addrmap.SourceHairpin, Listener: l.Name})
+			}
+		}
+	}
+
+	s.addressMap.SetMappings(mappings)
+}
+
+// GET /api/v1/addressmapping: show the address-mapping table of the relay transports
+func (s *Stunner) handleAddressMapping(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	ret := []AddressMappingStatus{}
+	for _, m := range s.addressMap.Mappings() {
+		status := AddressMappingStatus{
+			External: m.External.String(),
+			Internal: m.Internal.String(),
+			Source:   m.Source,
+			Listener: m.Listener,
+		}
+		if m.ExternalPort != 0 {
+			status.External = (&net.UDPAddr{IP: m.External, Port: m.ExternalPort}).String()
+			status.Internal = (&net.UDPAddr{IP: m.Internal, Port: m.InternalPort}).String()
+		}
+		ret = append(ret, status)
+	}
+
+	api.WriteJSON(w, http.StatusOK, ret)
+}
@@ -0,0 +1,191 @@
+package stunner
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/l7mp/stunner/internal/api"
+	"github.com/l7mp/stunner/internal/logger"
+	"github.com/l7mp/stunner/internal/object"
+	"github.com/l7mp/stunner/pkg/adminapi"
+	"github.com/l7mp/stunner/pkg/apis/v1alpha1"
+)
+
+// registerAdminRPC registers the gRPC admin API with the admin API server
+func (s *Stunner) registerAdminRPC() {
+	g := grpc.NewServer()
+	adminapi.RegisterServer(g, &adminRPCServer{s})
+
+	s.apiServer.Handle("/"+adminapi.ServiceName+"/", http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			// the admin API server checks the token, but the gRPC API must not be open to
+			// anyone when no token is set
+			if len(s.adminManager.Keys()) == 0 || s.GetAdmin().APIToken == "" {
+				api.WriteError(w, http.StatusForbidden,
+					errors.New("the gRPC admin API requires an API token"))
+				return
+			}
+			g.ServeHTTP(w, r)
+		}))
+}
+
+// adminRPCServer implements the gRPC admin API on top of reconciliation
+type adminRPCServer struct {
+	s *Stunner
+}
+
+// update applies a change to the running config and converts errors into gRPC status codes. The
+// method and the peer of the call are recorded in the audit trail
+func (a *adminRPCServer) update(ctx context.Context, update func(c *v1alpha1.StunnerConfig) error) (*adminapi.Response, error) {
+	src := ConfigSource{Kind: ConfigSourceAdminRPC}
+	src.Origin, _ = grpc.Method(ctx)
+	if p, ok := peer.FromContext(ctx); ok {
+		var state *tls.ConnectionState
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok {
+			state = &info.State
+		}
+		src.Peer = peerIdentity(p.Addr.String(), state)
+	}
+
+	err := a.s.updateConfig(src, func(c *v1alpha1.StunnerConfig) error {
+		if err := update(c); err != nil {
+			return err
+		}
+		if err := ValidateConfig(c); err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+		return nil
+	})
+
+	switch {
+	case err == nil:
+		return &adminapi.Response{}, nil
+	case errors.Is(err, v1alpha1.ErrRestartRequired):
+		return &adminapi.Response{Restarted: true}, nil
+	case errors.Is(err, v1alpha1.ErrRestartRefused):
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	if _, ok := status.FromError(err); ok {
+		return nil, err
+	}
+	return nil, status.Error(codes.Aborted, fmt.Sprintf("could not apply change: %s", err.Error()))
+}
+
+func findListener(c *v1alpha1.StunnerConfig, name string) int {
+	for i := range c.Listeners {
+		if c.Listeners[i].Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func findCluster(c *v1alpha1.StunnerConfig, name string) int {
+	for i := range c.Clusters {
+		if c.Clusters[i].Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func (a *adminRPCServer) CreateListener(ctx context.Context, req *adminapi.ListenerRequest) (*adminapi.Response, error) {
+	return a.update(ctx, func(c *v1alpha1.StunnerConfig) error {
+		if findListener(c, req.Listener.Name) >= 0 {
+			return status.Errorf(codes.AlreadyExists, "listener %q exists", req.Listener.Name)
+		}
+		c.Listeners = append(c.Listeners, req.Listener)
+		return nil
+	})
+}
+
+func (a *adminRPCServer) UpdateListener(ctx context.Context, req *adminapi.ListenerRequest) (*adminapi.Response, error) {
+	return a.update(ctx, func(c *v1alpha1.StunnerConfig) error {
+		i := findListener(c, req.Listener.Name)
+		if i < 0 {
+			return status.Errorf(codes.NotFound, "listener %q not found", req.Listener.Name)
+		}
+		c.Listeners[i] = req.Listener
+		return nil
+	})
+}
+
+func (a *adminRPCServer) DeleteListener(ctx context.Context, req *adminapi.DeleteRequest) (*adminapi.Response, error) {
+	return a.update(ctx, func(c *v1alpha1.StunnerConfig) error {
+		i := findListener(c, req.Name)
+		if i < 0 {
+			return status.Errorf(codes.NotFound, "listener %q not found", req.Name)
+		}
+		c.Listeners = append(c.Listeners[:i], c.Listeners[i+1:]...)
+		return nil
+	})
+}
+
+func (a *adminRPCServer) CreateCluster(ctx context.Context, req *adminapi.ClusterRequest) (*adminapi.Response, error) {
+	return a.update(ctx, func(c *v1alpha1.StunnerConfig) error {
+		if findCluster(c, req.Cluster.Name) >= 0 {
+			return status.Errorf(codes.AlreadyExists, "cluster %q exists", req.Cluster.Name)
+		}
+		c.Clusters = append(c.Clusters, req.Cluster)
+		return nil
+	})
+}
+
+func (a *adminRPCServer) UpdateCluster(ctx context.Context, req *adminapi.ClusterRequest) (*adminapi.Response, error) {
+	return a.update(ctx, func(c *v1alpha1.StunnerConfig) error {
+		i := findCluster(c, req.Cluster.Name)
+		if i < 0 {
+			return status.Errorf(codes.NotFound, "cluster %q not found", req.Cluster.Name)
+		}
+		c.Clusters[i] = req.Cluster
+		return nil
+	})
+}
+
+func (a *adminRPCServer) DeleteCluster(ctx context.Context, req *adminapi.DeleteRequest) (*adminapi.Response, error) {
+	return a.update(ctx, func(c *v1alpha1.StunnerConfig) error {
+		i := findCluster(c, req.Name)
+		if i < 0 {
+			return status.Errorf(codes.NotFound, "cluster %q not found", req.Name)
+		}
+		c.Clusters = append(c.Clusters[:i], c.Clusters[i+1:]...)
+		return nil
+	})
+}
+
+func (a *adminRPCServer) SetLogLevel(ctx context.Context, req *adminapi.LogLevelRequest) (*adminapi.Response, error) {
+	return a.update(ctx, func(c *v1alpha1.StunnerConfig) error {
+		if err := logger.CheckLevel(req.LogLevel); err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+		c.Admin.LogLevel = req.LogLevel
+		return nil
+	})
+}
+
+func (a *adminRPCServer) DrainListener(ctx context.Context, req *adminapi.DrainRequest) (*adminapi.Response, error) {
+	if req.Timeout < 0 {
+		return nil, status.Error(codes.InvalidArgument, "negative timeout")
+	}
+
+	l := a.s.GetListener(req.Name)
+	if l == nil {
+		return nil, status.Errorf(codes.NotFound, "listener %q not found", req.Name)
+	}
+
+	n, err := a.s.drainListeners(ctx, []*object.Listener{l}, time.Duration(req.Timeout)*time.Second)
+	if err != nil {
+		return nil, status.FromContextError(err).Err()
+	}
+	return &adminapi.Response{Allocations: n}, nil
+}
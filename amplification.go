@@ -0,0 +1,21 @@
+package stunner
+
+import (
+	"github.com/l7mp/stunner/internal/amplification"
+)
+
+// reconcileAmplification sets the amplification protections from the admin config, or disables
+// the protections if none is configured
+func (s *Stunner) reconcileAmplification() {
+	req := s.GetAdmin().Amplification
+	if req == nil {
+		s.amplification.SetConfig(nil)
+		return
+	}
+
+	s.amplification.SetConfig(&amplification.Config{
+		Rate:          req.ResponseRate,
+		RatePerSource: req.ResponseRatePerSource,
+		RequireCookie: req.RequireCookie,
+	})
+}
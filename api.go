@@ -0,0 +1,533 @@
+package stunner
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/l7mp/stunner/internal/api"
+	"github.com/l7mp/stunner/internal/conntrack"
+	"github.com/l7mp/stunner/internal/logger"
+	"github.com/l7mp/stunner/internal/object"
+	"github.com/l7mp/stunner/pkg/apis/v1alpha1"
+)
+
+// registerAPIHandlers registers the admin REST API handlers
+func (s *Stunner) registerAPIHandlers() {
+	s.apiServer.Handle("/api/v1/config", http.HandlerFunc(s.handleConfig))
+	s.apiServer.HandlePublic("/api/v1/status", http.HandlerFunc(s.handleStatus))
+	s.apiServer.Handle("/api/v1/conntrack", http.HandlerFunc(s.handleConntrack))
+	s.apiServer.Handle("/api/v1/allocations", http.HandlerFunc(s.handleAllocations))
+	s.apiServer.Handle("/api/v1/loglevel", http.HandlerFunc(s.handleLogLevel))
+	s.apiServer.Handle("/api/v1/captures", http.HandlerFunc(s.handleCaptures))
+	s.apiServer.Handle("/api/v1/config/plan", http.HandlerFunc(s.handleConfigPlan))
+	s.apiServer.Handle("/api/v1/config/diff", http.HandlerFunc(s.handleConfigDiff))
+	s.apiServer.Handle("/api/v1/config/audit", http.HandlerFunc(s.handleConfigAudit))
+	s.apiServer.Handle("/api/v1/events", http.HandlerFunc(s.handleEvents))
+	s.apiServer.Handle("/api/v1/selftest", http.HandlerFunc(s.handleSelfTest))
+	s.apiServer.Handle("/api/v1/dump", http.HandlerFunc(s.handleDumpState))
+	s.apiServer.Handle("/api/v1/bans", http.HandlerFunc(s.handleBans))
+	s.apiServer.Handle("/api/v1/malformed", http.HandlerFunc(s.handleMalformed))
+	s.apiServer.Handle("/api/v1/drain", http.HandlerFunc(s.handleDrain))
+	s.apiServer.Handle("/api/v1/replication", http.HandlerFunc(s.handleReplication))
+	s.apiServer.Handle("/api/v1/hashring", http.HandlerFunc(s.handleHashRing))
+	s.apiServer.Handle("/api/v1/standby", http.HandlerFunc(s.handleStandby))
+	s.apiServer.Handle("/api/v1/standby/release", http.HandlerFunc(s.handleStandbyRelease))
+	s.apiServer.Handle("/api/v1/addressmapping", http.HandlerFunc(s.handleAddressMapping))
+	s.apiServer.Handle("/api/v1/policy", http.HandlerFunc(s.handlePolicy))
+	s.registerAdminRPC()
+}
+
+// GET /api/v1/conntrack: list the flows in the connection tracking table
+func (s *Stunner) handleConntrack(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	api.WriteJSON(w, http.StatusOK, s.conntrack.Flows())
+}
+
+// GET /api/v1/allocations: list the active allocations with their permissions and channel
+// bindings, filtered by the "session_id", "username", "listener" and "peer" (an IP address or a
+// prefix) query parameters
+func (s *Stunner) handleAllocations(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	filter := conntrack.FlowFilter{SessionID: q.Get("session_id"), Username: q.Get("username"),
+		Listener: q.Get("listener")}
+	if peer := q.Get("peer"); peer != "" {
+		prefix, err := parsePrefix(peer)
+		if err != nil {
+			api.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+		filter.Peer = prefix
+	}
+
+	api.WriteJSON(w, http.StatusOK, s.conntrack.FilterFlows(filter))
+}
+
+// BanClearResponse is the response of the DELETE /api/v1/bans admin API
+type BanClearResponse struct {
+	// Cleared is the number of bans lifted
+	Cleared int `json:"cleared"`
+}
+
+// GET /api/v1/bans: list the client sources currently banned
+// DELETE /api/v1/bans: lift the ban of the source given in the "source" query parameter, or all
+// bans if no source is given
+func (s *Stunner) handleBans(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		api.WriteJSON(w, http.StatusOK, s.GetBans())
+	case http.MethodDelete:
+		source := r.URL.Query().Get("source")
+		if source != "" && net.ParseIP(source) == nil {
+			api.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid source: %q", source))
+			return
+		}
+		api.WriteJSON(w, http.StatusOK, BanClearResponse{Cleared: s.ClearBans(source)})
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// GET /api/v1/malformed: list the client sources that sent malformed packets
+func (s *Stunner) handleMalformed(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	api.WriteJSON(w, http.StatusOK, s.GetMalformedSources())
+}
+
+// DrainRequest is the request of the /api/v1/drain admin API
+type DrainRequest struct {
+	// Listener is the name of the listener to drain, empty means all listeners
+	Listener string `json:"listener,omitempty"`
+	// Timeout is the maximum time to wait for the active allocations to close, in seconds. Zero
+	// makes the call return right away
+	Timeout int `json:"timeout,omitempty"`
+}
+
+// DrainStatus is the response of the /api/v1/drain admin API
+type DrainStatus struct {
+	// Allocations is the number of allocations still active on the drained listeners
+	Allocations int `json:"allocations"`
+}
+
+// maxAPIDrainRequestSize limits the size of the drain requests posted to the admin API
+const maxAPIDrainRequestSize = 64 << 10
+
+// POST /api/v1/drain: make a listener, or all listeners, refuse new allocations and wait until the
+// active allocations are closed or the timeout expires. The listeners keep refusing new
+// allocations until updated or deleted
+func (s *Stunner) handleDrain(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	req := DrainRequest{}
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxAPIDrainRequestSize)).Decode(&req); err != nil && err != io.EOF {
+		api.WriteError(w, http.StatusBadRequest, fmt.Errorf("could not parse request: %w", err))
+		return
+	}
+	if req.Timeout < 0 {
+		api.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid timeout: %d", req.Timeout))
+		return
+	}
+
+	ls := []*object.Listener{}
+	if req.Listener != "" {
+		l := s.GetListener(req.Listener)
+		if l == nil {
+			api.WriteError(w, http.StatusNotFound, fmt.Errorf("unknown listener: %q",
+				req.Listener))
+			return
+		}
+		ls = append(ls, l)
+	} else {
+		for _, name := range s.listenerManager.Keys() {
+			ls = append(ls, s.GetListener(name))
+		}
+	}
+
+	n, err := s.drainListeners(r.Context(), ls, time.Duration(req.Timeout)*time.Second)
+	if err != nil {
+		api.WriteError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	api.WriteJSON(w, http.StatusOK, DrainStatus{Allocations: n})
+}
+
+// parsePrefix parses a prefix in CIDR notation, or an IP address as a host prefix
+func parsePrefix(p string) (*net.IPNet, error) {
+	if _, prefix, err := net.ParseCIDR(p); err == nil {
+		return prefix, nil
+	}
+	ip := net.ParseIP(p)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid peer prefix: %q", p)
+	}
+	if ip.To4() != nil {
+		return &net.IPNet{IP: ip.To4(), Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+// LogLevel is the request and the response of the /api/v1/loglevel admin API
+type LogLevel struct {
+	// LogLevel is the log level specification, e.g., "all:INFO,stunner-cluster-*:TRACE"
+	LogLevel string `json:"loglevel"`
+}
+
+// maxAPILogLevelSize limits the size of the log levels posted to the admin API
+const maxAPILogLevelSize = 64 << 10
+
+// GET /api/v1/loglevel: show the effective log level
+// PUT /api/v1/loglevel: change the log level in the running config, without a restart
+func (s *Stunner) handleLogLevel(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		if len(s.adminManager.Keys()) == 0 {
+			api.WriteError(w, http.StatusNotFound, fmt.Errorf("no configuration applied yet"))
+			return
+		}
+		api.WriteJSON(w, http.StatusOK, LogLevel{LogLevel: s.GetAdmin().LogLevel})
+	case http.MethodPut:
+		req := LogLevel{}
+		if err := json.NewDecoder(io.LimitReader(r.Body, maxAPILogLevelSize)).Decode(&req); err != nil {
+			api.WriteError(w, http.StatusBadRequest, fmt.Errorf("could not parse request: %w", err))
+			return
+		}
+		if err := logger.CheckLevel(req.LogLevel); err != nil {
+			api.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		src := ConfigSource{Kind: ConfigSourceAdminAPI, Origin: "PUT /api/v1/loglevel",
+			Peer: peerIdentity(r.RemoteAddr, r.TLS)}
+		err := s.updateConfig(src, func(c *v1alpha1.StunnerConfig) error {
+			c.Admin.LogLevel = req.LogLevel
+			return nil
+		})
+		if err != nil && !errors.Is(err, v1alpha1.ErrRestartRequired) {
+			api.WriteError(w, http.StatusInternalServerError,
+				fmt.Errorf("could not set log level: %w", err))
+			return
+		}
+
+		s.log.Infof("log level set to %q via the admin API", req.LogLevel)
+		api.WriteJSON(w, http.StatusOK, LogLevel{LogLevel: s.GetAdmin().LogLevel})
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// CaptureRequest is the request of the /api/v1/captures admin API
+type CaptureRequest struct {
+	// Listener is the name of the listener to capture on, empty means all listeners
+	Listener string `json:"listener,omitempty"`
+	// Client is the address of the client to capture, an IP address captures all ports
+	Client string `json:"client"`
+	// Payload enables recording the headers of the data messages (default: false)
+	Payload bool `json:"payload,omitempty"`
+	// MaxSize is the size limit of the capture file in bytes (default: 10 MB)
+	MaxSize int64 `json:"max_size,omitempty"`
+	// Duration is the time limit of the capture in seconds (default: 60)
+	Duration int `json:"duration,omitempty"`
+}
+
+// maxAPICaptureRequestSize limits the size of the capture requests posted to the admin API
+const maxAPICaptureRequestSize = 64 << 10
+
+// captureTimeFormat is the timestamp in the name of the capture files
+const captureTimeFormat = "20060102T150405.000"
+
+// GET /api/v1/captures: list the running and the recently finished packet captures
+// POST /api/v1/captures: start capturing the STUN/TURN messages of a client session to a pcapng
+// file in the capture directory
+// DELETE /api/v1/captures?id=<id>: stop a running capture
+func (s *Stunner) handleCaptures(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		api.WriteJSON(w, http.StatusOK, s.conntrack.Captures())
+	case http.MethodPost:
+		if len(s.adminManager.Keys()) == 0 {
+			api.WriteError(w, http.StatusNotFound, fmt.Errorf("no configuration applied yet"))
+			return
+		}
+
+		req := CaptureRequest{}
+		if err := json.NewDecoder(io.LimitReader(r.Body, maxAPICaptureRequestSize)).Decode(&req); err != nil {
+			api.WriteError(w, http.StatusBadRequest, fmt.Errorf("could not parse request: %w", err))
+			return
+		}
+		if req.Listener != "" {
+			if _, found := s.listenerManager.Get(req.Listener); !found {
+				api.WriteError(w, http.StatusBadRequest, fmt.Errorf("unknown listener: %q",
+					req.Listener))
+				return
+			}
+		}
+		if req.MaxSize < 0 || req.Duration < 0 {
+			api.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid capture limits: "+
+				"max size: %d, duration: %d", req.MaxSize, req.Duration))
+			return
+		}
+
+		dir := s.GetAdmin().CaptureDir
+		if dir == "" {
+			dir = os.TempDir()
+		}
+		name := strings.NewReplacer(":", "_", "[", "", "]", "").Replace(req.Client)
+		path := filepath.Join(dir, fmt.Sprintf("stunner-capture-%s-%s.pcapng", name,
+			time.Now().UTC().Format(captureTimeFormat)))
+
+		status, err := s.conntrack.StartCapture(conntrack.CaptureConfig{
+			Listener: req.Listener,
+			Client:   req.Client,
+			Payload:  req.Payload,
+			Path:     path,
+			MaxSize:  req.MaxSize,
+			Duration: time.Duration(req.Duration) * time.Second,
+		})
+		if err != nil {
+			api.WriteError(w, http.StatusBadRequest, err)
+			return
+		}
+
+		api.WriteJSON(w, http.StatusCreated, status)
+	case http.MethodDelete:
+		id, err := strconv.Atoi(r.URL.Query().Get("id"))
+		if err != nil {
+			api.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid capture id: %q",
+				r.URL.Query().Get("id")))
+			return
+		}
+		status, err := s.conntrack.StopCapture(id)
+		if err != nil {
+			api.WriteError(w, http.StatusNotFound, err)
+			return
+		}
+		api.WriteJSON(w, http.StatusOK, status)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// GET /api/v1/config: show the effective running config, with secrets redacted, and the status
+// of each object
+func (s *Stunner) handleConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	api.WriteJSON(w, http.StatusOK, s.GetRunningConfig())
+}
+
+// GET /api/v1/status: show the aggregated status of the gateway and the status of each object,
+// with status 503 if the gateway is not ready
+func (s *Stunner) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	st := s.GetGatewayStatus()
+	code := http.StatusOK
+	if !st.Ready {
+		code = http.StatusServiceUnavailable
+	}
+	api.WriteJSON(w, code, st)
+}
+
+// POST /api/v1/selftest: run a connectivity self-test against the running gateway and return the
+// report, with status 503 if any of the checks failed
+func (s *Stunner) handleSelfTest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	if len(s.adminManager.Keys()) == 0 {
+		api.WriteError(w, http.StatusNotFound, fmt.Errorf("no configuration applied yet"))
+		return
+	}
+
+	report := s.SelfTest()
+	code := http.StatusOK
+	if !report.Passed {
+		code = http.StatusServiceUnavailable
+	}
+	api.WriteJSON(w, code, report)
+}
+
+// GET /api/v1/dump: download a snapshot of the state of the daemon for bug reports as a gzipped
+// tar archive, with credentials redacted
+func (s *Stunner) handleDumpState(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	buf := &bytes.Buffer{}
+	if err := s.DumpState(buf); err != nil {
+		api.WriteError(w, http.StatusInternalServerError,
+			fmt.Errorf("could not dump state: %w", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q",
+		stateDumpName(time.Now())+".tar.gz"))
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes()) //nolint:errcheck
+}
+
+// GET /api/v1/config/diff: show the changes made by the last successful reconciliation
+func (s *Stunner) handleConfigDiff(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	diff := s.GetLastConfigDiff()
+	if diff == nil {
+		api.WriteError(w, http.StatusNotFound, fmt.Errorf("no configuration applied yet"))
+		return
+	}
+
+	api.WriteJSON(w, http.StatusOK, diff)
+}
+
+// GET /api/v1/config/audit: list the records of the config audit trail, oldest first, filtered by
+// the "source" (the kind of the config source), "since" (an RFC 3339 time) and "limit" (the
+// number of most recent records) query parameters
+func (s *Stunner) handleConfigAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	q := r.URL.Query()
+	filter := AuditFilter{Source: q.Get("source")}
+	if since := q.Get("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			api.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid time: %q", since))
+			return
+		}
+		filter.Since = t
+	}
+	if limit := q.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n < 0 {
+			api.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid limit: %q", limit))
+			return
+		}
+		filter.Limit = n
+	}
+
+	api.WriteJSON(w, http.StatusOK, s.GetAuditRecords(filter))
+}
+
+// GET /api/v1/events: stream the config change events as newline-delimited JSON, until the client
+// disconnects
+func (s *Stunner) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		api.WriteError(w, http.StatusInternalServerError, fmt.Errorf("streaming not supported"))
+		return
+	}
+
+	ch, cancel := s.SubscribeEvents()
+	defer cancel()
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	enc := json.NewEncoder(w)
+	for {
+		select {
+		case e := <-ch:
+			if err := enc.Encode(e); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// maxAPIConfigSize limits the size of configs posted to the admin API
+const maxAPIConfigSize = 4 << 20
+
+// POST /api/v1/config/plan: report the changes reconciling the posted config (YAML or JSON) would
+// make to the running daemon, without applying it
+func (s *Stunner) handleConfigPlan(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	raw, err := io.ReadAll(io.LimitReader(r.Body, maxAPIConfigSize))
+	if err != nil {
+		api.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	c, err := parseConfig(raw, s.options.StrictConfig)
+	if report := (*ValidationError)(nil); errors.As(err, &report) {
+		// unknown fields in strict mode
+		api.WriteJSON(w, http.StatusUnprocessableEntity, report)
+		return
+	}
+	if err != nil {
+		api.WriteError(w, http.StatusBadRequest, fmt.Errorf("could not parse config: %w", err))
+		return
+	}
+
+	if err := ResolveSecrets(c, ""); err != nil {
+		api.WriteError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := ValidateConfig(c); err != nil {
+		api.WriteJSON(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	plan, err := s.PlanReconcile(*c)
+	if err != nil {
+		api.WriteError(w, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	api.WriteJSON(w, http.StatusOK, plan)
+}
@@ -0,0 +1,258 @@
+package stunner
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/l7mp/stunner/internal/logger"
+	"github.com/l7mp/stunner/pkg/apis/v1alpha1"
+)
+
+// Kinds of config sources
+const (
+	// ConfigSourceFile: the config was loaded from a config file
+	ConfigSourceFile = "file"
+	// ConfigSourceURI: the default config was generated from a TURN URI
+	ConfigSourceURI = "uri"
+	// ConfigSourceURL: the config was fetched from a config URL
+	ConfigSourceURL = "url"
+	// ConfigSourceCDS: the config was received from the config discovery service of a control
+	// plane
+	ConfigSourceCDS = "cds"
+	// ConfigSourceAdminAPI: the config was changed via the admin REST API
+	ConfigSourceAdminAPI = "admin-api"
+	// ConfigSourceAdminRPC: the config was changed via the gRPC admin API
+	ConfigSourceAdminRPC = "admin-grpc"
+	// ConfigSourceRollback: the last-known-good config was restored after a failed
+	// reconciliation
+	ConfigSourceRollback = "rollback"
+	// ConfigSourceLibrary: the config was reconciled by the program embedding STUNner
+	ConfigSourceLibrary = "library"
+	// ConfigSourceStandby: the config was mirrored from the active gateway of a hot-standby
+	// pair
+	ConfigSourceStandby = "standby"
+)
+
+// auditHistoryLen is the number of audit records kept in memory for the admin API
+const auditHistoryLen = 1024
+
+// ConfigSource tells who or what pushed a config
+type ConfigSource struct {
+	// Kind is the kind of the source, e.g., "file" or "admin-api"
+	Kind string `json:"kind"`
+	// Origin is where the config came from: the path of the config file, the TURN URI, the
+	// config URL, the address of the CDS server or the admin API call
+	Origin string `json:"origin,omitempty"`
+	// Peer identifies the client that pushed the config via the admin APIs: the remote address
+	// and, over mTLS, the subject of the client certificate
+	Peer string `json:"peer,omitempty"`
+}
+
+// String stringifies the source
+func (src ConfigSource) String() string {
+	ret := src.Kind
+	if src.Origin != "" {
+		ret += " " + src.Origin
+	}
+	if src.Peer != "" {
+		ret += " by " + src.Peer
+	}
+	return ret
+}
+
+// peerIdentity returns the identity of an admin API client from the remote address and the TLS
+// state of the connection
+func peerIdentity(addr string, state *tls.ConnectionState) string {
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return addr
+	}
+	subject := state.PeerCertificates[0].Subject
+	if subject.CommonName != "" {
+		return fmt.Sprintf("%s (CN=%s)", addr, subject.CommonName)
+	}
+	return fmt.Sprintf("%s (%s)", addr, subject.String())
+}
+
+// AuditRecord is a record of the config audit trail, written for each applied config
+type AuditRecord struct {
+	// Time is the time the config was applied
+	Time time.Time `json:"time"`
+	// Node is the name of the daemon as set in the applied config
+	Node string `json:"node,omitempty"`
+	// Generation is the generation of the applied config
+	Generation int64 `json:"generation"`
+	// Source tells who or what pushed the config
+	Source ConfigSource `json:"source"`
+	// Summary is the number of objects added, changed and removed
+	Summary string `json:"summary"`
+	// Changes lists the added, removed and changed objects, with secrets redacted
+	Changes []ObjectDiff `json:"changes"`
+}
+
+// AuditFilter selects the records of the audit trail, zero values match all records
+type AuditFilter struct {
+	// Source is the kind of the config source
+	Source string
+	// Since selects the records written at or after the given time
+	Since time.Time
+	// Limit is the maximum number of records returned, the most recent ones are kept
+	Limit int
+}
+
+// auditTrail keeps the last records of the audit trail and writes each record as a JSON line
+// to the audit log, if set
+type auditTrail struct {
+	lock    sync.Mutex
+	records []AuditRecord
+	limit   int
+	w       io.Writer
+}
+
+func newAuditTrail() *auditTrail {
+	return &auditTrail{records: []AuditRecord{}, limit: auditHistoryLen}
+}
+
+// setLimit sets the number of records kept in memory, the oldest records over the limit are
+// dropped
+func (a *auditTrail) setLimit(limit int) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.limit = limit
+	a.trim()
+}
+
+// trim drops the oldest records over the limit, must be called with the lock held
+func (a *auditTrail) trim() {
+	if len(a.records) > a.limit {
+		a.records = append([]AuditRecord{}, a.records[len(a.records)-a.limit:]...)
+	}
+}
+
+func (a *auditTrail) setWriter(w io.Writer) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.w = w
+}
+
+// record appends a record to the audit trail and writes it to the audit log
+func (a *auditTrail) record(r AuditRecord) error {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	a.records = append(a.records, r)
+	a.trim()
+
+	if a.w == nil {
+		return nil
+	}
+	line, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	_, err = a.w.Write(append(line, '\n'))
+	return err
+}
+
+func (a *auditTrail) filter(f AuditFilter) []AuditRecord {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	ret := []AuditRecord{}
+	for _, r := range a.records {
+		if f.Source != "" && r.Source.Kind != f.Source {
+			continue
+		}
+		if !f.Since.IsZero() && r.Time.Before(f.Since) {
+			continue
+		}
+		ret = append(ret, r)
+	}
+	if f.Limit > 0 && len(ret) > f.Limit {
+		ret = ret[len(ret)-f.Limit:]
+	}
+	return ret
+}
+
+// GetAuditRecords returns the records of the config audit trail kept in memory that match the
+// filter, oldest first
+func (s *Stunner) GetAuditRecords(f AuditFilter) []AuditRecord {
+	return s.audit.filter(f)
+}
+
+// ReconcileFrom reconciles STUNner for a config like Reconcile, and records the source of the
+// config in the audit trail if the config is applied
+func (s *Stunner) ReconcileFrom(req v1alpha1.StunnerConfig, src ConfigSource) error {
+	s.reconcileLock.Lock()
+	defer s.reconcileLock.Unlock()
+
+	s.configSource = src
+	s.publishEvent(ConfigEventReceived, &req, "")
+	return s.reconcile(req, s.reconcileMode())
+}
+
+// auditConfig writes the audit record of an applied config
+func (s *Stunner) auditConfig(diff *ConfigDiff, src ConfigSource) {
+	added, changed, removed := 0, 0, 0
+	for _, d := range diff.Changes {
+		switch d.Operation {
+		case "add":
+			added++
+		case "change":
+			changed++
+		case "remove":
+			removed++
+		}
+	}
+
+	r := AuditRecord{
+		Time:       diff.Timestamp,
+		Node:       s.GetAdmin().Name,
+		Generation: diff.Generation,
+		Source:     src,
+		Summary:    fmt.Sprintf("%d added, %d changed, %d removed", added, changed, removed),
+		Changes:    diff.Changes,
+	}
+	if err := s.audit.record(r); err != nil {
+		s.log.Errorf("could not write audit record for config generation %d: %s",
+			diff.Generation, err.Error())
+	}
+}
+
+// reconcileAuditLog sets the sink of the audit trail to the audit log set in the running config
+func (s *Stunner) reconcileAuditLog() error {
+	admin := s.GetAdmin()
+
+	// keep the audit log file open unless the path changes
+	var w io.Writer
+	old := s.auditLogFile
+	switch admin.AuditLog {
+	case "":
+		s.auditLogFile = nil
+	case "stdout":
+		w, s.auditLogFile = os.Stdout, nil
+	case "stderr":
+		w, s.auditLogFile = os.Stderr, nil
+	default:
+		if old != nil && old.Path() == admin.AuditLog {
+			w, old = old, nil
+			break
+		}
+		// the audit log is append-only: never rotate it
+		f, err := logger.NewFileWriter(admin.AuditLog, 0, 0, 0)
+		if err != nil {
+			return fmt.Errorf("could not open audit log: %w", err)
+		}
+		w, s.auditLogFile = f, f
+	}
+	s.audit.setWriter(w)
+
+	if old != nil {
+		old.Close()
+	}
+	return nil
+}
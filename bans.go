@@ -0,0 +1,67 @@
+package stunner
+
+import (
+	"net"
+	"time"
+
+	"github.com/l7mp/stunner/internal/ban"
+	"github.com/l7mp/stunner/pkg/apis/v1alpha1"
+)
+
+// banExpiryInterval is the period the expired bans are lifted at
+const banExpiryInterval = 10 * time.Second
+
+// reconcileBans sets the thresholds of the ban table from the admin config, or disables banning
+// and lifts all bans if banning is not configured
+func (s *Stunner) reconcileBans() {
+	req := s.GetAdmin().Ban
+	if req == nil {
+		s.bans.SetConfig(nil)
+		return
+	}
+
+	conf := &ban.Config{
+		Window:   time.Duration(req.Window) * time.Second,
+		Duration: time.Duration(req.Duration) * time.Second,
+		Thresholds: map[string]int{
+			ban.ReasonAuthFailure:      req.AuthFailures,
+			ban.ReasonMalformedPacket:  req.MalformedPackets,
+			ban.ReasonPermissionDenied: req.PermissionDenials,
+		},
+		Exempt: []*net.IPNet{},
+	}
+	for _, c := range req.ExemptCIDRs {
+		// validated
+		if n, err := v1alpha1.ParseSourceCIDR(c); err == nil {
+			conf.Exempt = append(conf.Exempt, n)
+		}
+	}
+	s.bans.SetConfig(conf)
+}
+
+// GetBans returns the client sources currently banned
+func (s *Stunner) GetBans() []ban.Ban {
+	return s.bans.Bans()
+}
+
+// ClearBans lifts the ban of a client source, or all bans if the source is empty, and returns the
+// number of bans lifted
+func (s *Stunner) ClearBans(source string) int {
+	return s.bans.Clear(source)
+}
+
+// runBans lifts the expired bans periodically. The sweep runs on the system clock, the expiry is
+// checked on the clock of the gateway
+func (s *Stunner) runBans() {
+	ticker := time.NewTicker(banExpiryInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.bans.Expire(s.clock.Now())
+		case <-s.done:
+			return
+		}
+	}
+}
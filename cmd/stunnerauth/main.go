@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	flag "github.com/spf13/pflag"
+
+	"github.com/l7mp/stunner"
+	"github.com/l7mp/stunner/pkg/apis/v1alpha1"
+	"github.com/l7mp/stunner/pkg/auth"
+)
+
+const usage = "stunnerauth [-c|--config <file>] [-s|--secret <secret>] [-t|--tenant <tenant>] [--ttl <duration>] [--ice]\n\tgenerates TURN credentials, or with --ice the iceServers of the WebRTC RTCConfiguration,\n\tfrom a stunnerd config file or from a longterm shared secret\n"
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprint(os.Stderr, usage)
+		flag.PrintDefaults()
+	}
+
+	os.Args[0] = "stunnerauth"
+	var config = flag.StringP("config", "c", "", "Config file of the gateway (default: none).")
+	var secret = flag.StringP("secret", "s", "", "Shared secret of the longterm authentication mode, overrides the config (default: none).")
+	var tenant = flag.StringP("tenant", "t", "", "Tenant to generate the credentials for (default: none).")
+	var ttl = flag.Duration("ttl", auth.DefaultTTL, "Lifetime of the longterm credentials.")
+	var ice = flag.Bool("ice", false, "Print the iceServers of the WebRTC RTCConfiguration, requires a config file (default: false).")
+	flag.Parse()
+
+	if flag.NArg() != 0 || (*config == "" && *secret == "") || (*ice && *config == "") {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	conf := &v1alpha1.StunnerConfig{}
+	if *config != "" {
+		c, err := stunner.LoadConfig(*config)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "could not load config: %s\n", err.Error())
+			os.Exit(1)
+		}
+		conf = c
+	}
+	if *secret != "" {
+		conf.Auth = v1alpha1.AuthConfig{Type: v1alpha1.AuthTypeLongTerm.String(),
+			Credentials: map[string]string{"secret": *secret}}
+	}
+
+	var out interface{}
+	var err error
+	if *ice {
+		out, err = auth.NewICEConfiguration(conf, *tenant, *ttl)
+	} else {
+		out, err = auth.Generate(&conf.Auth, *tenant, *ttl)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "could not generate credentials: %s\n", err.Error())
+		os.Exit(1)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(out); err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import "github.com/pion/logging"
+
+// startService is a no-op on platforms other than Windows: stunnerd is stopped with a signal
+func startService(log logging.LeveledLogger) (<-chan struct{}, func()) {
+	return nil, func() {}
+}
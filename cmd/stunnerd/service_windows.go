@@ -0,0 +1,69 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/pion/logging"
+	"golang.org/x/sys/windows/svc"
+)
+
+// service runs stunnerd as a Windows service: the stop and shutdown requests of the service
+// control manager are passed on to the main loop, and the service is reported stopped once the
+// main loop is done
+type service struct {
+	stop     chan struct{}
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func (s *service) Execute(args []string, reqs <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.StartPending}
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case r := <-reqs:
+			switch r.Cmd {
+			case svc.Interrogate:
+				status <- r.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				s.stopOnce.Do(func() { close(s.stop) })
+				<-s.done
+				return false, 0
+			}
+		case <-s.done:
+			// exited on its own, e.g., after a fatal error
+			return false, 0
+		}
+	}
+}
+
+// startService reports to the service control manager if stunnerd runs as a Windows service.
+// Returns a channel that is closed when the service is to be stopped, and a function to be called
+// once stunnerd is shut down
+func startService(log logging.LeveledLogger) (<-chan struct{}, func()) {
+	isService, err := svc.IsWindowsService()
+	if err != nil {
+		log.Warnf("cannot detect Windows service: %s", err.Error())
+		return nil, func() {}
+	}
+	if !isService {
+		return nil, func() {}
+	}
+
+	s := &service{stop: make(chan struct{}), done: make(chan struct{})}
+	exited := make(chan struct{})
+	go func() {
+		// the service name is ignored for services running in their own process
+		if err := svc.Run("stunnerd", s); err != nil {
+			log.Errorf("Windows service failed: %s", err.Error())
+		}
+		close(exited)
+	}()
+
+	log.Info("running as a Windows service")
+	return s.stop, func() {
+		close(s.done)
+		<-exited
+	}
+}
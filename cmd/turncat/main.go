@@ -11,7 +11,6 @@ import (
 	"time"
 
 	"github.com/pion/logging"
-	"github.com/pion/turn/v2"
 	flag "github.com/spf13/pflag"
 
 	corev1 "k8s.io/api/core/v1"
@@ -22,6 +21,7 @@ import (
 	"github.com/l7mp/stunner"
 	"github.com/l7mp/stunner/internal/logger"
 	stunnerv1alpha1 "github.com/l7mp/stunner/pkg/apis/v1alpha1"
+	"github.com/l7mp/stunner/pkg/auth"
 )
 
 const usage = "turncat [-l|--log <level>] [-i|--insecure] client server peer\n\tclient: <udp|tcp|unix>://<listener_addr>:<listener_port>\n\tserver: <turn://<auth>@<server_addr>:<server_port> | <k8s://<namesspace>/<name>:listener\n\tpeer: udp://<peer_addr>:<peer_port>\n\tauth: <username:password|secret>\n"
@@ -199,42 +199,15 @@ func getStunnerConfFromCLI(def string) (*stunnerv1alpha1.StunnerConfig, error) {
 }
 
 func getAuth(config *stunnerv1alpha1.StunnerConfig) (stunner.AuthGen, error) {
-	auth := config.Auth
-	atype, err := stunnerv1alpha1.NewAuthType(auth.Type)
-	if err != nil {
+	// fail early on a broken auth config
+	if _, err := auth.Generate(&config.Auth, "", defaultDuration); err != nil {
 		return nil, err
 	}
 
-	switch atype {
-	case stunnerv1alpha1.AuthTypeLongTerm:
-		s, found := auth.Credentials["secret"]
-		if !found {
-			return nil, fmt.Errorf("cannot find shared secret for %s authentication",
-				auth.Type)
-		}
-		return func() (string, string, error) {
-			return turn.GenerateLongTermCredentials(s, defaultDuration)
-		}, nil
-
-	case stunnerv1alpha1.AuthTypePlainText:
-		u, found := auth.Credentials["username"]
-		if !found {
-			return nil, fmt.Errorf("cannot find username for %s authentication",
-				auth.Type)
-		}
-
-		p, found := auth.Credentials["password"]
-		if !found {
-			return nil, fmt.Errorf("cannot find password for %s authentication",
-				auth.Type)
-		}
-
-		return func() (string, string, error) { return u, p, nil }, nil
-
-	default:
-		return nil, fmt.Errorf("unknown authentication type %q",
-			auth.Type)
-	}
+	return func() (string, string, error) {
+		c, err := auth.Generate(&config.Auth, "", defaultDuration)
+		return c.Username, c.Password, err
+	}, nil
 }
 
 func getStunnerURI(config *stunnerv1alpha1.StunnerConfig) (string, error) {
@@ -2,15 +2,22 @@ package stunner
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"reflect"
 	"regexp"
+	"sort"
 	"strconv"
+	"strings"
+	"sync/atomic"
 
 	// "github.com/pion/logging"
 	// "github.com/pion/turn/v2"
 	"sigs.k8s.io/yaml"
 
+	v1 "github.com/l7mp/stunner/pkg/apis/v1"
 	"github.com/l7mp/stunner/pkg/apis/v1alpha1"
 )
 
@@ -63,13 +70,30 @@ func NewDefaultConfig(uri string) (*v1alpha1.StunnerConfig, error) {
 }
 
 // LoadConfig loads a configuration from a file, substituting environment variables for
-// placeholders in the configuration file. Returns the new configuration or error if load fails
+// placeholders in the configuration file (see ExpandEnv). This makes it possible to inject, e.g.,
+// the public address from the Kubernetes downward API or a shared secret from a Secret through the
+// pod environment. Files with the extension ".toml" are parsed as TOML, using the same keys as
+// YAML, and all other files as YAML or JSON. A YAML file may contain multiple documents, and each
+// document may list further files to be merged before it in the top-level "include" key, either
+// as file names or glob patterns relative to the directory of the including file. Documents are
+// merged in order as described in MergeConfigDocs. Secret references and encrypted credentials
+// are resolved with ResolveSecrets. Returns the new configuration or error if load fails
 func LoadConfig(config string) (*v1alpha1.StunnerConfig, error) {
-	c, err := os.ReadFile(config)
-	if err != nil {
-		return nil, fmt.Errorf("could not read config: %s\n", err.Error())
-	}
+	c, _, err := loadConfig(config, false)
+	return c, err
+}
 
+// LoadConfigStrict is like LoadConfig but fails on unknown fields, like misspelled keys, that
+// LoadConfig silently ignores. The error is a *ValidationError listing the path of each unknown
+// field, e.g., "clusters[0].endpionts"
+func LoadConfigStrict(config string) (*v1alpha1.StunnerConfig, error) {
+	c, _, err := loadConfig(config, true)
+	return c, err
+}
+
+// loadConfig loads a config file with all its includes and returns the config and a hash of the
+// raw content of all the files read, or nil if some file could not be read
+func loadConfig(config string, strict bool) (*v1alpha1.StunnerConfig, []byte, error) {
 	// substitute environtment variables
 	// default port: STUNNER_PUBLIC_PORT -> STUNNER_PORT
 	re := regexp.MustCompile(`^[0-9]+$`)
@@ -85,20 +109,261 @@ func LoadConfig(config string) (*v1alpha1.StunnerConfig, error) {
 		os.Setenv("STUNNER_PORT", fmt.Sprintf("%d", publicPort))
 	}
 
-	e := os.ExpandEnv(string(c))
+	l := newConfigLoader()
+	if err := l.load(config); err != nil {
+		var hash []byte
+		if !errors.Is(err, os.ErrNotExist) {
+			hash = l.hash.Sum(nil)
+		}
+		return nil, hash, err
+	}
+
+	conf, err := parseConfigDocs(l.docs, strict)
+	if err != nil {
+		return nil, l.hash.Sum(nil), fmt.Errorf("could not parse config file at '%s': %w",
+			config, err)
+	}
+
+	if err := ResolveSecrets(conf, filepath.Dir(config)); err != nil {
+		return nil, l.hash.Sum(nil), fmt.Errorf("could not resolve secrets in config file at "+
+			"'%s': %s", config, err.Error())
+	}
+
+	return conf, l.hash.Sum(nil), nil
+}
+
+// ExpandEnv replaces $VAR and ${VAR} placeholders in a string with the value of the corresponding
+// environment variable, using the shell syntax for defaults and required variables:
+//   - ${VAR:-default} expands to default if VAR is unset or empty,
+//   - ${VAR-default} expands to default if VAR is unset,
+//   - ${VAR:?message} fails with message if VAR is unset or empty,
+//   - ${VAR?message} fails with message if VAR is unset.
+//
+// Unset variables with no default expand to the empty string.
+func ExpandEnv(s string) (string, error) {
+	errs := []string{}
+	e := os.Expand(s, func(v string) string {
+		i := strings.IndexAny(v, ":-?")
+		if i < 0 {
+			return os.Getenv(v)
+		}
+
+		name, op := v[:i], v[i:]
+		value, set := os.LookupEnv(name)
+		// the colon variants treat empty variables as unset
+		if strings.HasPrefix(op, ":") {
+			set = set && value != ""
+			op = op[1:]
+		}
+		if set {
+			return value
+		}
+
+		switch {
+		case strings.HasPrefix(op, "-"):
+			return op[1:]
+		case strings.HasPrefix(op, "?"):
+			msg := op[1:]
+			if msg == "" {
+				msg = "not set"
+			}
+			errs = append(errs, fmt.Sprintf("%s: %s", name, msg))
+		default:
+			errs = append(errs, fmt.Sprintf("invalid placeholder: ${%s}", v))
+		}
+		return ""
+	})
+
+	if len(errs) > 0 {
+		return "", fmt.Errorf("%s", strings.Join(errs, ", "))
+	}
+	return e, nil
+}
+
+// ParseConfig parses a configuration in YAML or JSON format. Configurations using the v1 API are
+// validated and converted to v1alpha1, the API version implemented by the daemon. Multi-document
+// YAML configs are merged as described in MergeConfigDocs, includes are supported only by
+// LoadConfig
+func ParseConfig(c []byte) (*v1alpha1.StunnerConfig, error) {
+	return parseConfig(c, false)
+}
+
+// ParseConfigStrict is like ParseConfig but fails on unknown fields, see LoadConfigStrict
+func ParseConfigStrict(c []byte) (*v1alpha1.StunnerConfig, error) {
+	return parseConfig(c, true)
+}
+
+func parseConfig(c []byte, strict bool) (*v1alpha1.StunnerConfig, error) {
+	if configDocSeparator.Match(c) {
+		docs, err := splitConfig(c)
+		if err != nil {
+			return nil, err
+		}
+		for _, doc := range docs {
+			if _, ok := doc[configIncludeKey]; ok {
+				return nil, fmt.Errorf("includes are supported only in config files")
+			}
+		}
+		if len(docs) > 1 {
+			return parseConfigDocs(docs, strict)
+		}
+	}
+
+	version := struct {
+		ApiVersion string `json:"version"`
+	}{}
+	isV1 := unmarshal(c, &version) == nil && version.ApiVersion == v1.ApiVersion
+
+	if strict {
+		var t reflect.Type = reflect.TypeOf(v1alpha1.StunnerConfig{})
+		if isV1 {
+			t = reflect.TypeOf(v1.StunnerConfig{})
+		}
+		if err := checkConfigFields(c, t); err != nil {
+			return nil, err
+		}
+	}
+
+	if isV1 {
+		s := v1.StunnerConfig{}
+		if err := unmarshal(c, &s); err != nil {
+			return nil, err
+		}
+		if err := s.Validate(); err != nil {
+			return nil, fmt.Errorf("invalid %s config: %s", v1.ApiVersion, err.Error())
+		}
+		return v1.ConvertV1ToV1Alpha1(&s)
+	}
 
 	s := v1alpha1.StunnerConfig{}
+	if err := unmarshal(c, &s); err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+// unmarshal parses a config in YAML or JSON format
+func unmarshal(c []byte, v interface{}) error {
 	// try YAML first
-	if err = yaml.Unmarshal([]byte(e), &s); err != nil {
-		// if it fails, try to json
-		if errJ := json.Unmarshal([]byte(e), &s); err != nil {
-			return nil, fmt.Errorf("could not parse config file at '%s': "+
-				"YAML parse error: %s, JSON parse error: %s\n",
-				config, err.Error(), errJ.Error())
+	err := yaml.Unmarshal(c, v)
+	if err == nil {
+		return nil
+	}
+
+	// if it fails, try to json
+	errJ := json.Unmarshal(c, v)
+	if errJ == nil {
+		return nil
+	}
+
+	return fmt.Errorf("YAML parse error: %s, JSON parse error: %s", err.Error(), errJ.Error())
+}
+
+// configFieldKinds maps the top-level config fields to the kind of the objects they hold
+var configFieldKinds = map[string]string{"admin": "admin", "auth": "auth", "listeners": "listener",
+	"clusters": "cluster"}
+
+// checkConfigFields returns a *ValidationError listing the fields of a YAML or JSON config that
+// are not defined in the config type t, or nil if there are none
+func checkConfigFields(c []byte, t reflect.Type) error {
+	j, err := yaml.YAMLToJSON(c)
+	if err != nil {
+		return err
+	}
+	var doc interface{}
+	if err := json.Unmarshal(j, &doc); err != nil {
+		return err
+	}
+
+	report := &ValidationError{}
+	unknownFields(doc, t, "", func(path string) {
+		// report the object the field belongs to
+		kind, name := "config", ""
+		top := strings.FieldsFunc(path, func(r rune) bool { return r == '.' || r == '[' })[0]
+		if k, ok := configFieldKinds[top]; ok && top != path {
+			kind = k
+		}
+		if obj, ok := objectAt(doc, path); ok {
+			name, _ = obj["name"].(string)
+		}
+		report.Errors = append(report.Errors, ConfigError{Kind: kind, Name: name,
+			Message: fmt.Sprintf("unknown field %q", path)})
+	})
+
+	if len(report.Errors) > 0 {
+		return report
+	}
+	return nil
+}
+
+// unknownFields walks a decoded JSON document along the config type t and calls report with the
+// path of each field not defined in t
+func unknownFields(v interface{}, t reflect.Type, path string, report func(string)) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch d := v.(type) {
+	case map[string]interface{}:
+		if t.Kind() != reflect.Struct {
+			// maps, like credentials, may have any keys
+			return
+		}
+		fields := map[string]reflect.Type{}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name := strings.Split(f.Tag.Get("json"), ",")[0]
+			if name == "" {
+				name = f.Name
+			}
+			if name != "-" {
+				fields[name] = f.Type
+			}
+		}
+
+		keys := make([]string, 0, len(d))
+		for k := range d {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			p := k
+			if path != "" {
+				p = path + "." + k
+			}
+			ft, ok := fields[k]
+			if !ok {
+				report(p)
+				continue
+			}
+			unknownFields(d[k], ft, p, report)
+		}
+
+	case []interface{}:
+		if t.Kind() != reflect.Slice && t.Kind() != reflect.Array {
+			return
+		}
+		for i := range d {
+			unknownFields(d[i], t.Elem(), fmt.Sprintf("%s[%d]", path, i), report)
 		}
 	}
+}
 
-	return &s, nil
+// objectAt returns the listener or cluster holding the field at the given path
+func objectAt(doc interface{}, path string) (map[string]interface{}, bool) {
+	j, i := strings.Index(path, "["), strings.Index(path, "]")
+	if j < 0 || i < j {
+		return nil, false
+	}
+	top, _ := doc.(map[string]interface{})
+	list, _ := top[path[:j]].([]interface{})
+	n, err := strconv.Atoi(path[j+1 : i])
+	if err != nil || n >= len(list) {
+		return nil, false
+	}
+	obj, ok := list[n].(map[string]interface{})
+	return obj, ok
 }
 
 // GetConfig returns the configuration of the running STUNner daemon
@@ -122,6 +387,7 @@ func (s *Stunner) GetConfig() *v1alpha1.StunnerConfig {
 
 	c := v1alpha1.StunnerConfig{
 		ApiVersion: s.version,
+		Generation: atomic.LoadInt64(&s.generation),
 		Admin:      adminConf,
 		Auth:       authConf,
 		Listeners:  make([]v1alpha1.ListenerConfig, len(listeners)),
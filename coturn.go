@@ -0,0 +1,380 @@
+package stunner
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/l7mp/stunner/pkg/apis/v1alpha1"
+)
+
+// coturn defaults
+const (
+	coturnDefaultPort    = 3478
+	coturnDefaultTLSPort = 5349
+	coturnDefaultMinPort = 49152
+	coturnDefaultMaxPort = 65535
+)
+
+// coturnPeerCluster is the name of the cluster holding the peers allowed by the coturn config
+const coturnPeerCluster = "coturn-peers"
+
+// coturnIgnoredOptions have no effect on the STUNner config and are dropped silently
+var coturnIgnoredOptions = map[string]bool{
+	"lt-cred-mech": true, "fingerprint": true, "use-auth-secret": true,
+	"no-loopback-peers": true, "no-cli": true, "no-stdout-log": true, "simple-log": true,
+	"log-file": true, "syslog": true, "pidfile": true, "new-log-timestamp": true,
+}
+
+// ImportCoturnConfigFile reads a coturn config file (turnserver.conf) and converts it into an
+// equivalent STUNner config, see ImportCoturnConfig
+func ImportCoturnConfigFile(file string) (*v1alpha1.StunnerConfig, []string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not read coturn config file %q: %s", file, err.Error())
+	}
+	defer f.Close()
+
+	return ImportCoturnConfig(f)
+}
+
+// ImportCoturnConfig converts a coturn config (turnserver.conf) into an equivalent STUNner
+// config. Each listening IP gets a UDP, TCP, TLS and DTLS listener (unless disabled), the relay
+// port range, the realm and the users or the auth secret are taken over, and the peers allowed by
+// the allowed-peer-ip and denied-peer-ip rules are collected into a single STATIC cluster. Like in
+// the default STUNner config, IPv6 peers are allowed only if the coturn config has IPv6 peer rules.
+// Options with no STUNner equivalent are ignored and reported in the returned warnings.
+func ImportCoturnConfig(r io.Reader) (*v1alpha1.StunnerConfig, []string, error) {
+	warnings := []string{}
+	warnf := func(format string, args ...interface{}) {
+		warnings = append(warnings, fmt.Sprintf(format, args...))
+	}
+
+	port, tlsPort := coturnDefaultPort, coturnDefaultTLSPort
+	minPort, maxPort := coturnDefaultMinPort, coturnDefaultMaxPort
+	ips, externalIPs, users := []string{}, []string{}, []string{}
+	var realm, secret, cert, key, logLevel string
+	disabled := map[string]bool{}
+	denied, allowed := []string{}, []string{}
+	allowLoopback, denyMulticast, ipv6 := false, false, false
+
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		opt, val := line, ""
+		if i := strings.IndexAny(line, "= \t"); i >= 0 {
+			opt, val = line[:i], strings.TrimSpace(line[i+1:])
+		}
+		opt = strings.TrimPrefix(opt, "--")
+		val = strings.Trim(val, `"`)
+
+		intVal := func() int {
+			v, err := strconv.Atoi(val)
+			if err != nil || v <= 0 || v > 65535 {
+				warnf("line %d: invalid port %q for option %q, ignoring", n, val, opt)
+				return 0
+			}
+			return v
+		}
+
+		switch opt {
+		case "listening-port":
+			if v := intVal(); v > 0 {
+				port = v
+			}
+		case "tls-listening-port":
+			if v := intVal(); v > 0 {
+				tlsPort = v
+			}
+		case "min-port":
+			if v := intVal(); v > 0 {
+				minPort = v
+			}
+		case "max-port":
+			if v := intVal(); v > 0 {
+				maxPort = v
+			}
+		case "listening-ip":
+			ips = append(ips, val)
+		case "external-ip":
+			// public[/private]
+			externalIPs = append(externalIPs, strings.SplitN(val, "/", 2)[0])
+		case "no-udp", "no-tcp", "no-tls", "no-dtls":
+			disabled[strings.TrimPrefix(opt, "no-")] = true
+		case "cert":
+			cert = val
+		case "pkey":
+			key = val
+		case "realm":
+			realm = val
+		case "user":
+			users = append(users, val)
+		case "static-auth-secret":
+			secret = val
+		case "denied-peer-ip":
+			denied = append(denied, val)
+			ipv6 = ipv6 || strings.Contains(val, ":")
+		case "allowed-peer-ip":
+			allowed = append(allowed, val)
+			ipv6 = ipv6 || strings.Contains(val, ":")
+		case "allow-loopback-peers":
+			allowLoopback = true
+		case "no-multicast-peers":
+			denyMulticast = true
+		case "verbose", "v":
+			logLevel = "all:INFO"
+		case "Verbose", "V":
+			logLevel = "all:DEBUG"
+		default:
+			if !coturnIgnoredOptions[opt] {
+				warnf("line %d: option %q has no STUNner equivalent, ignoring", n, opt)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("could not read coturn config: %s", err.Error())
+	}
+
+	c := &v1alpha1.StunnerConfig{
+		ApiVersion: v1alpha1.ApiVersion,
+		Admin:      v1alpha1.AdminConfig{LogLevel: logLevel},
+		Auth:       v1alpha1.AuthConfig{Realm: realm, Credentials: map[string]string{}},
+		Listeners:  []v1alpha1.ListenerConfig{},
+		Clusters:   []v1alpha1.ClusterConfig{},
+	}
+
+	// auth: the auth secret takes precedence over the users, as in coturn
+	switch {
+	case secret != "":
+		c.Auth.Type = "longterm"
+		c.Auth.Credentials["secret"] = secret
+		warnf("STUNner expects time-windowed usernames without the user-id part " +
+			"(\"<timestamp>\" instead of \"<timestamp>:<user>\")")
+	case len(users) > 0:
+		u := strings.SplitN(users[0], ":", 2)
+		if len(u) != 2 || u[0] == "" || u[1] == "" {
+			return nil, nil, fmt.Errorf("invalid coturn user %q", users[0])
+		}
+		if strings.HasPrefix(u[1], "0x") {
+			return nil, nil, fmt.Errorf("coturn user %q: hashed passwords are not supported",
+				u[0])
+		}
+		c.Auth.Type = "plaintext"
+		c.Auth.Credentials["username"] = u[0]
+		c.Auth.Credentials["password"] = u[1]
+		if len(users) > 1 {
+			warnf("STUNner supports a single plaintext user: using %q, ignoring %d "+
+				"more user(s)", u[0], len(users)-1)
+		}
+	default:
+		return nil, nil, fmt.Errorf("no user or static-auth-secret found in coturn config")
+	}
+
+	// listeners
+	if len(ips) == 0 {
+		ips = []string{""}
+	}
+	if (!disabled["tls"] || !disabled["dtls"]) && (cert == "" || key == "") {
+		warnf("no cert/pkey set: skipping TLS and DTLS listeners")
+		disabled["tls"], disabled["dtls"] = true, true
+	}
+	for i, ip := range ips {
+		for _, proto := range []string{"udp", "tcp", "tls", "dtls"} {
+			if disabled[proto] {
+				continue
+			}
+			name := proto
+			if len(ips) > 1 {
+				name = fmt.Sprintf("%s-%d", proto, i+1)
+			}
+			l := v1alpha1.ListenerConfig{
+				Name:         name,
+				Protocol:     strings.ToUpper(proto),
+				Addr:         ip,
+				Port:         port,
+				MinRelayPort: minPort,
+				MaxRelayPort: maxPort,
+				Routes:       []string{coturnPeerCluster},
+			}
+			if proto == "tls" || proto == "dtls" {
+				l.Port, l.Cert, l.Key = tlsPort, cert, key
+			}
+			if i < len(externalIPs) {
+				l.PublicAddr = externalIPs[i]
+			}
+			c.Listeners = append(c.Listeners, l)
+		}
+	}
+	if len(c.Listeners) == 0 {
+		return nil, nil, fmt.Errorf("all listeners are disabled in coturn config")
+	}
+
+	// peers
+	if !allowLoopback {
+		denied = append(denied, "127.0.0.0/8", "::1")
+	}
+	if denyMulticast {
+		denied = append(denied, "224.0.0.0/4", "ff00::/8")
+	}
+	endpoints, err := coturnPeerEndpoints(denied, allowed, ipv6)
+	if err != nil {
+		return nil, nil, err
+	}
+	c.Clusters = append(c.Clusters, v1alpha1.ClusterConfig{
+		Name:      coturnPeerCluster,
+		Type:      "STATIC",
+		Endpoints: endpoints,
+	})
+
+	return c, warnings, nil
+}
+
+// ipRange is an inclusive range of IP addresses of the same family
+type ipRange struct {
+	lo, hi *big.Int
+}
+
+// coturnPeerEndpoints converts the coturn peer rules into the list of allowed subnets: all
+// addresses are allowed except the denied ones, but the explicitly allowed ones override the
+// denied ones. IPv6 addresses are considered only if ipv6 is set
+func coturnPeerEndpoints(denied, allowed []string, ipv6 bool) ([]string, error) {
+	families := []int{32}
+	if ipv6 {
+		families = append(families, 128)
+	}
+
+	endpoints := []string{}
+	for _, bits := range families {
+		deny, allow := []ipRange{}, []ipRange{}
+		for _, rules := range []struct {
+			list []string
+			dst  *[]ipRange
+		}{{denied, &deny}, {allowed, &allow}} {
+			for _, rule := range rules.list {
+				r, b, err := parseIPRange(rule)
+				if err != nil {
+					return nil, err
+				}
+				if b == bits {
+					*rules.dst = append(*rules.dst, r)
+				}
+			}
+		}
+
+		ranges := mergeIPRanges(append(complementIPRanges(mergeIPRanges(deny), bits), allow...))
+		for _, r := range ranges {
+			endpoints = append(endpoints, ipRangeToCIDRs(r, bits)...)
+		}
+	}
+
+	return endpoints, nil
+}
+
+// parseIPRange parses an IP address, a CIDR subnet or an "ip1-ip2" range and returns the range and
+// the address length in bits
+func parseIPRange(s string) (ipRange, int, error) {
+	ipBits := func(ip net.IP) (*big.Int, int) {
+		if ip4 := ip.To4(); ip4 != nil {
+			return new(big.Int).SetBytes(ip4), 32
+		}
+		return new(big.Int).SetBytes(ip.To16()), 128
+	}
+
+	if _, n, err := net.ParseCIDR(s); err == nil {
+		lo, bits := ipBits(n.IP)
+		ones, _ := n.Mask.Size()
+		size := new(big.Int).Lsh(big.NewInt(1), uint(bits-ones))
+		return ipRange{lo: lo, hi: size.Add(size, lo).Sub(size, big.NewInt(1))}, bits, nil
+	}
+
+	parts := strings.SplitN(s, "-", 2)
+	if len(parts) == 1 {
+		parts = append(parts, parts[0])
+	}
+	from, to := net.ParseIP(strings.TrimSpace(parts[0])), net.ParseIP(strings.TrimSpace(parts[1]))
+	if from == nil || to == nil {
+		return ipRange{}, 0, fmt.Errorf("invalid coturn peer address range %q", s)
+	}
+	lo, bits := ipBits(from)
+	hi, bitsHi := ipBits(to)
+	if bits != bitsHi || lo.Cmp(hi) > 0 {
+		return ipRange{}, 0, fmt.Errorf("invalid coturn peer address range %q", s)
+	}
+
+	return ipRange{lo: lo, hi: hi}, bits, nil
+}
+
+// mergeIPRanges sorts the ranges and merges the overlapping and adjacent ones
+func mergeIPRanges(ranges []ipRange) []ipRange {
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].lo.Cmp(ranges[j].lo) < 0 })
+
+	ret := []ipRange{}
+	for _, r := range ranges {
+		if len(ret) > 0 {
+			last := &ret[len(ret)-1]
+			if next := new(big.Int).Add(last.hi, big.NewInt(1)); r.lo.Cmp(next) <= 0 {
+				if r.hi.Cmp(last.hi) > 0 {
+					last.hi = r.hi
+				}
+				continue
+			}
+		}
+		ret = append(ret, ipRange{lo: r.lo, hi: r.hi})
+	}
+
+	return ret
+}
+
+// complementIPRanges returns the addresses not covered by the sorted and merged ranges
+func complementIPRanges(ranges []ipRange, bits int) []ipRange {
+	ret := []ipRange{}
+	next := big.NewInt(0)
+	max := new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), uint(bits)), big.NewInt(1))
+	for _, r := range ranges {
+		if r.lo.Cmp(next) > 0 {
+			ret = append(ret, ipRange{lo: next, hi: new(big.Int).Sub(r.lo, big.NewInt(1))})
+		}
+		next = new(big.Int).Add(r.hi, big.NewInt(1))
+	}
+	if next.Cmp(max) <= 0 {
+		ret = append(ret, ipRange{lo: next, hi: max})
+	}
+
+	return ret
+}
+
+// ipRangeToCIDRs splits a range into the minimal list of CIDR subnets
+func ipRangeToCIDRs(r ipRange, bits int) []string {
+	ret := []string{}
+	lo := new(big.Int).Set(r.lo)
+	for lo.Cmp(r.hi) <= 0 {
+		// the largest block aligned at lo that fits into the range
+		size := bits
+		if lo.Sign() > 0 {
+			size = int(lo.TrailingZeroBits())
+		}
+		for ; size > 0; size-- {
+			last := new(big.Int).Lsh(big.NewInt(1), uint(size))
+			if last.Add(last, lo).Sub(last, big.NewInt(1)).Cmp(r.hi) <= 0 {
+				break
+			}
+		}
+
+		ip := make(net.IP, bits/8)
+		lo.FillBytes(ip)
+		ret = append(ret, fmt.Sprintf("%s/%d", ip.String(), bits-size))
+		lo.Add(lo, new(big.Int).Lsh(big.NewInt(1), uint(size)))
+	}
+
+	return ret
+}
@@ -0,0 +1,243 @@
+package stunner
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/l7mp/stunner/internal/monitoring"
+	"github.com/l7mp/stunner/pkg/apis/v1alpha1"
+)
+
+// FieldDiff is a change of a single config field
+type FieldDiff struct {
+	// Field is the name of the field, nested fields are separated with a dot
+	Field string `json:"field"`
+	// Old is the JSON encoded old value, empty if the field was unset
+	Old string `json:"old,omitempty"`
+	// New is the JSON encoded new value, empty if the field was unset
+	New string `json:"new,omitempty"`
+}
+
+// ObjectDiff describes how an object changed between two configs
+type ObjectDiff struct {
+	// Kind is the kind of the object: "admin", "auth", "listener" or "cluster"
+	Kind string `json:"kind"`
+	// Name is the name of the object
+	Name string `json:"name"`
+	// Operation is the change: "add", "remove" or "change"
+	Operation string `json:"operation"`
+	// Fields lists the changed fields
+	Fields []FieldDiff `json:"fields,omitempty"`
+}
+
+// String stringifies the diff
+func (d ObjectDiff) String() string {
+	fields := make([]string, len(d.Fields))
+	for i, f := range d.Fields {
+		fields[i] = fmt.Sprintf("%s: %s -> %s", f.Field, diffValue(f.Old), diffValue(f.New))
+	}
+	return fmt.Sprintf("%s %s %q: [%s]", d.Operation, d.Kind, d.Name, strings.Join(fields, ", "))
+}
+
+func diffValue(v string) string {
+	if v == "" {
+		return "<unset>"
+	}
+	return v
+}
+
+// ConfigDiff is the structured diff of a reconciliation
+type ConfigDiff struct {
+	// Timestamp is the time of the reconciliation
+	Timestamp time.Time `json:"timestamp"`
+	// Generation is the generation of the reconciled config
+	Generation int64 `json:"generation"`
+	// Changes lists the added, removed and changed objects
+	Changes []ObjectDiff `json:"changes"`
+}
+
+// redactedFields lists the fields whose values are never shown in a diff
+var redactedFields = map[string]bool{"credentials.password": true, "credentials.secret": true,
+	"api_token": true}
+
+// isRedacted returns true if the value of a field is never shown in a diff, including the
+// credentials of the tenants flattened as tenants.<tenant>.credentials.<key>
+func isRedacted(field string) bool {
+	if strings.HasPrefix(field, "tenants.") {
+		if i := strings.Index(field[len("tenants."):], "."); i >= 0 {
+			field = field[len("tenants.")+i+1:]
+		}
+	}
+	return redactedFields[field]
+}
+
+// DiffConfig computes the per-object, per-field structured diff between two configs. Secrets are
+// redacted. Configs should be validated so that defaults and list orders do not show up as changes.
+func DiffConfig(old, new *v1alpha1.StunnerConfig) []ObjectDiff {
+	diffs := []ObjectDiff{}
+	diffs = append(diffs, diffObjects("admin", []v1alpha1.Config{&old.Admin},
+		[]v1alpha1.Config{&new.Admin})...)
+	diffs = append(diffs, diffObjects("auth", []v1alpha1.Config{&old.Auth},
+		[]v1alpha1.Config{&new.Auth})...)
+
+	oldL, newL := []v1alpha1.Config{}, []v1alpha1.Config{}
+	for i := range old.Listeners {
+		oldL = append(oldL, &old.Listeners[i])
+	}
+	for i := range new.Listeners {
+		newL = append(newL, &new.Listeners[i])
+	}
+	diffs = append(diffs, diffObjects("listener", oldL, newL)...)
+
+	oldC, newC := []v1alpha1.Config{}, []v1alpha1.Config{}
+	for i := range old.Clusters {
+		oldC = append(oldC, &old.Clusters[i])
+	}
+	for i := range new.Clusters {
+		newC = append(newC, &new.Clusters[i])
+	}
+	diffs = append(diffs, diffObjects("cluster", oldC, newC)...)
+
+	return diffs
+}
+
+func diffObjects(kind string, old, new []v1alpha1.Config) []ObjectDiff {
+	oldMap := map[string]v1alpha1.Config{}
+	for _, c := range old {
+		oldMap[c.ConfigName()] = c
+	}
+	newMap := map[string]v1alpha1.Config{}
+	names := []string{}
+	for _, c := range new {
+		newMap[c.ConfigName()] = c
+		names = append(names, c.ConfigName())
+	}
+	for _, c := range old {
+		if _, ok := newMap[c.ConfigName()]; !ok {
+			names = append(names, c.ConfigName())
+		}
+	}
+	sort.Strings(names)
+
+	diffs := []ObjectDiff{}
+	for _, name := range names {
+		o, n := oldMap[name], newMap[name]
+		d := ObjectDiff{Kind: kind, Name: name, Operation: "change",
+			Fields: diffFields(flattenConfig(o), flattenConfig(n))}
+		switch {
+		case o == nil:
+			d.Operation = "add"
+		case n == nil:
+			d.Operation = "remove"
+		case len(d.Fields) == 0:
+			continue
+		}
+		diffs = append(diffs, d)
+	}
+	return diffs
+}
+
+// flattenConfig returns the JSON encoded fields of a config, with nested objects flattened. The
+// credentials of the tenants in the auth config are flattened per tenant
+func flattenConfig(c v1alpha1.Config) map[string]string {
+	ret := map[string]string{}
+	if c == nil {
+		return ret
+	}
+
+	raw, err := json.Marshal(c)
+	if err != nil {
+		return ret
+	}
+	m := map[string]interface{}{}
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return ret
+	}
+
+	var flatten func(prefix string, m map[string]interface{})
+	flatten = func(prefix string, m map[string]interface{}) {
+		for k, v := range m {
+			if nested, ok := v.(map[string]interface{}); ok {
+				flatten(prefix+k+".", nested)
+				continue
+			}
+			if list, ok := v.([]interface{}); ok && prefix == "" && k == "tenants" {
+				for _, t := range list {
+					if nested, ok := t.(map[string]interface{}); ok {
+						name, _ := nested["tenant"].(string)
+						delete(nested, "tenant")
+						flatten("tenants."+name+".", nested)
+					}
+				}
+				continue
+			}
+			raw, _ := json.Marshal(v)
+			ret[prefix+k] = string(raw)
+		}
+	}
+	flatten("", m)
+
+	return ret
+}
+
+func diffFields(old, new map[string]string) []FieldDiff {
+	keys := []string{}
+	for k := range old {
+		keys = append(keys, k)
+	}
+	for k := range new {
+		if _, ok := old[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	fields := []FieldDiff{}
+	for _, k := range keys {
+		o, n := old[k], new[k]
+		if o == n {
+			continue
+		}
+		if isRedacted(k) {
+			if o != "" {
+				o = `"<redacted>"`
+			}
+			if n != "" {
+				n = `"<redacted>"`
+			}
+		}
+		fields = append(fields, FieldDiff{Field: k, Old: o, New: n})
+	}
+	return fields
+}
+
+// GetLastConfigDiff returns the diff of the last successful reconciliation, or nil if STUNner has
+// not been reconciled yet
+func (s *Stunner) GetLastConfigDiff() *ConfigDiff {
+	d, _ := s.lastDiff.Load().(*ConfigDiff)
+	return d
+}
+
+// commitConfig records the generation of the applied config and the running config as the
+// last-known-good config after a successful reconciliation, and logs and stores the diff to the
+// previous config, along with the source of the config, in the audit trail
+func (s *Stunner) commitConfig(prev *v1alpha1.StunnerConfig, generation int64, src ConfigSource) {
+	atomic.StoreInt64(&s.generation, generation)
+	monitoring.ConfigGenerationGauge.Set(float64(generation))
+	s.log.Infof("applied config generation %d from %s", generation, src.String())
+
+	s.lastGoodConfig = s.GetConfig()
+	s.restartRefused.Store("")
+
+	diff := &ConfigDiff{Timestamp: time.Now(), Generation: generation,
+		Changes: DiffConfig(prev, s.lastGoodConfig)}
+	for _, d := range diff.Changes {
+		s.log.Infof("config diff: %s", d.String())
+	}
+	s.lastDiff.Store(diff)
+	s.auditConfig(diff, src)
+}
@@ -0,0 +1,116 @@
+package stunner
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/l7mp/stunner/internal/monitoring"
+	"github.com/l7mp/stunner/pkg/apis/v1alpha1"
+)
+
+// dnsHealthCheckInterval is the period the DNS resolution of the STRICT_DNS clusters is checked at
+const dnsHealthCheckInterval = 5 * time.Second
+
+// dnsHealth holds the STRICT_DNS clusters whose resolution has been failing for longer than the
+// threshold
+type dnsHealth struct {
+	lock    sync.Mutex
+	failing map[string]string // cluster -> description
+}
+
+func newDNSHealth() *dnsHealth {
+	return &dnsHealth{failing: map[string]string{}}
+}
+
+// Failing returns the descriptions of the clusters whose resolution is failing, sorted
+func (d *dnsHealth) Failing() []string {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	ret := make([]string, 0, len(d.failing))
+	for _, desc := range d.failing {
+		ret = append(ret, desc)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// runDNSHealth checks the DNS resolution of the clusters periodically
+func (s *Stunner) runDNSHealth() {
+	ticker := time.NewTicker(dnsHealthCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.checkDNSHealth()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// checkDNSHealth exports the domains of the STRICT_DNS clusters whose resolution is failing, and
+// marks the clusters whose domains have all been failing for longer than the threshold set in the
+// admin config as failing, logging a warning when a cluster starts failing
+func (s *Stunner) checkDNSHealth() {
+	monitoring.DNSResolutionFailingGauge.Reset()
+
+	var conf *v1alpha1.DNSHealthConfig
+	if len(s.adminManager.Keys()) > 0 {
+		conf = s.GetAdmin().DNSHealth
+	}
+
+	now := time.Now()
+	failing := map[string]string{}
+	for _, name := range s.clusterManager.Keys() {
+		c := s.GetCluster(name)
+		if c.Type != v1alpha1.ClusterTypeStrictDNS || len(c.Domains) == 0 {
+			continue
+		}
+
+		domains := c.FailingDomains()
+		latest := time.Time{}
+		for _, d := range c.Domains {
+			since, ok := domains[d]
+			if !ok {
+				monitoring.DNSResolutionFailingGauge.WithLabelValues(name, d).Set(0)
+				continue
+			}
+			monitoring.DNSResolutionFailingGauge.WithLabelValues(name, d).Set(1)
+			if since.After(latest) {
+				latest = since
+			}
+		}
+
+		if conf == nil || len(domains) < len(c.Domains) {
+			continue
+		}
+		if d := now.Sub(latest); d >= time.Duration(conf.FailureThreshold)*time.Second {
+			names := append([]string{}, c.Domains...)
+			sort.Strings(names)
+			failing[name] = fmt.Sprintf("cluster %s: resolution of all domains failing for %s: %s",
+				name, d.Truncate(time.Second), strings.Join(names, ", "))
+		}
+	}
+
+	h := s.dnsHealth
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	for name, desc := range failing {
+		if _, ok := h.failing[name]; !ok {
+			s.log.Warnf("DNS resolution failing, all traffic to the cluster is denied: %s",
+				desc)
+		}
+	}
+	for name := range h.failing {
+		if _, ok := failing[name]; !ok {
+			s.log.Infof("DNS resolution no longer failing for cluster %s", name)
+		}
+	}
+	h.failing = failing
+}
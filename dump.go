@@ -0,0 +1,241 @@
+package stunner
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/expfmt"
+
+	"github.com/l7mp/stunner/pkg/apis/v1alpha1"
+)
+
+// StateVersions lists the versions of STUNner and of its main dependencies
+type StateVersions struct {
+	// APIVersion is the STUNner API version
+	APIVersion string `json:"api_version"`
+	// Module is the version of the main module of the binary, e.g., "(devel)" for local builds
+	Module string `json:"module,omitempty"`
+	// GoVersion is the version of the Go runtime
+	GoVersion string `json:"go_version"`
+	// Platform is the OS and the architecture, e.g., "linux/amd64"
+	Platform string `json:"platform"`
+	// Dependencies lists the versions of the TURN, STUN and DTLS stacks
+	Dependencies map[string]string `json:"dependencies,omitempty"`
+}
+
+// ListenerAllocations summarizes the allocations of a listener
+type ListenerAllocations struct {
+	// Listener is the name of the listener
+	Listener string `json:"listener"`
+	// Allocations is the number of active allocations
+	Allocations int `json:"allocations"`
+	// Permissions is the number of active permissions
+	Permissions int `json:"permissions"`
+	// Channels is the number of active channel bindings
+	Channels int `json:"channels"`
+	// Peers is the number of peers the allocations have sent traffic to or received traffic from
+	Peers int `json:"peers"`
+	// TxBytes and RxBytes are the bytes relayed from the clients to the peers and from the peers
+	// to the clients
+	TxBytes uint64 `json:"tx_bytes"`
+	RxBytes uint64 `json:"rx_bytes"`
+}
+
+// ResolvedDomain is the state of the resolution of a domain of a STRICT_DNS cluster
+type ResolvedDomain struct {
+	// Cluster is the name of the cluster
+	Cluster string `json:"cluster"`
+	// Domain is the domain name
+	Domain string `json:"domain"`
+	// Addresses are the addresses cached by the resolver
+	Addresses []string `json:"addresses"`
+	// LastResolved is the time of the last successful resolution, if any
+	LastResolved *time.Time `json:"last_resolved,omitempty"`
+	// FailingSince is the time of the first failed resolution since the last successful one, if
+	// the resolution is failing
+	FailingSince *time.Time `json:"failing_since,omitempty"`
+	// LastError is the error of the last failed resolution
+	LastError string `json:"last_error,omitempty"`
+}
+
+// stateDumpModules are the dependencies whose versions are included in the state dump
+var stateDumpModules = []string{"github.com/pion/turn/v2", "github.com/l7mp/turn/v2",
+	"github.com/pion/stun", "github.com/pion/dtls/v2", "github.com/pion/transport"}
+
+// DumpState writes a snapshot of the state of the daemon for bug reports to w, as a gzipped tar
+// archive: the versions, the effective config and the object statuses with secrets redacted, the
+// gateway status, a summary of the allocations per listener, the current metrics and the resolver
+// cache. The archive holds no credentials, client addresses or usernames
+func (s *Stunner) DumpState(w io.Writer) error {
+	now := time.Now()
+	dir := stateDumpName(now)
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	add := func(name string, data []byte) error {
+		hdr := &tar.Header{Name: dir + "/" + name, Mode: 0644, Size: int64(len(data)),
+			ModTime: now}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+	addJSON := func(name string, v interface{}) error {
+		data, err := json.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		return add(name, append(data, '\n'))
+	}
+
+	if err := addJSON("versions.json", s.stateVersions()); err != nil {
+		return err
+	}
+	if err := addJSON("config.json", s.GetRunningConfig()); err != nil {
+		return err
+	}
+	if err := addJSON("status.json", s.GetGatewayStatus()); err != nil {
+		return err
+	}
+	if err := addJSON("allocations.json", s.allocationSummary()); err != nil {
+		return err
+	}
+	if err := addJSON("resolver.json", s.resolverCache()); err != nil {
+		return err
+	}
+	metrics, err := s.gatherMetrics()
+	if err != nil {
+		metrics = []byte(fmt.Sprintf("# could not gather metrics: %s\n", err.Error()))
+	}
+	if err := add("metrics.txt", metrics); err != nil {
+		return err
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func (s *Stunner) stateVersions() StateVersions {
+	v := StateVersions{
+		APIVersion:   s.GetVersion(),
+		GoVersion:    runtime.Version(),
+		Platform:     runtime.GOOS + "/" + runtime.GOARCH,
+		Dependencies: map[string]string{},
+	}
+
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return v
+	}
+	v.Module = info.Main.Version
+	for _, d := range info.Deps {
+		for _, m := range stateDumpModules {
+			if d.Path != m {
+				continue
+			}
+			version := d.Version
+			if d.Replace != nil {
+				version = fmt.Sprintf("%s => %s %s", version, d.Replace.Path,
+					d.Replace.Version)
+			}
+			v.Dependencies[d.Path] = version
+		}
+	}
+	return v
+}
+
+// allocationSummary returns the number of allocations, permissions, channels and peers and the
+// traffic per listener, so that no client address or username is exposed
+func (s *Stunner) allocationSummary() []ListenerAllocations {
+	summary := map[string]*ListenerAllocations{}
+	for _, name := range s.listenerManager.Keys() {
+		summary[name] = &ListenerAllocations{Listener: name}
+	}
+	for _, f := range s.conntrack.Flows() {
+		l, ok := summary[f.Listener]
+		if !ok {
+			l = &ListenerAllocations{Listener: f.Listener}
+			summary[f.Listener] = l
+		}
+		l.Allocations++
+		l.Permissions += len(f.Permissions)
+		l.Channels += len(f.Channels)
+		l.Peers += len(f.Peers)
+		l.TxBytes += f.TxBytes
+		l.RxBytes += f.RxBytes
+	}
+
+	ret := make([]ListenerAllocations, 0, len(summary))
+	for _, l := range summary {
+		ret = append(ret, *l)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Listener < ret[j].Listener })
+	return ret
+}
+
+// resolverCache returns the addresses and the health of the domains of the STRICT_DNS clusters
+func (s *Stunner) resolverCache() []ResolvedDomain {
+	ret := []ResolvedDomain{}
+	for _, name := range s.clusterManager.Keys() {
+		c := s.GetCluster(name)
+		if c.Type != v1alpha1.ClusterTypeStrictDNS {
+			continue
+		}
+		for _, d := range c.Domains {
+			r := ResolvedDomain{Cluster: name, Domain: d, Addresses: []string{}}
+			if ips, err := c.Resolver.Lookup(d); err == nil {
+				for _, ip := range ips {
+					r.Addresses = append(r.Addresses, ip.String())
+				}
+			}
+			st := c.Resolver.Status(d)
+			if !st.LastResolved.IsZero() {
+				r.LastResolved = &st.LastResolved
+			}
+			if !st.FailingSince.IsZero() {
+				r.FailingSince = &st.FailingSince
+				r.LastError = st.LastError
+			}
+			ret = append(ret, r)
+		}
+	}
+	return ret
+}
+
+// gatherMetrics returns the metrics of the metrics registry in the Prometheus text format
+func (s *Stunner) gatherMetrics() ([]byte, error) {
+	g, ok := s.metricsRegistry.(prometheus.Gatherer)
+	if !ok {
+		return nil, fmt.Errorf("the metrics registry cannot be gathered")
+	}
+	families, err := g.Gather()
+	if err != nil && len(families) == 0 {
+		return nil, err
+	}
+
+	buf := &bytes.Buffer{}
+	for _, mf := range families {
+		if _, err := expfmt.MetricFamilyToText(buf, mf); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// stateDumpName returns the name of the state dump taken at the given time
+func stateDumpName(now time.Time) string {
+	return "stunner-state-" + now.UTC().Format("20060102T150405Z")
+}
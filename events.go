@@ -0,0 +1,165 @@
+package stunner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/l7mp/stunner/pkg/apis/v1alpha1"
+)
+
+// ConfigEventType is the type of a config change event
+type ConfigEventType string
+
+const (
+	// ConfigEventReceived is published when a new config is submitted for reconciliation
+	ConfigEventReceived ConfigEventType = "received"
+	// ConfigEventValidated is published when a new config passed validation
+	ConfigEventValidated ConfigEventType = "validated"
+	// ConfigEventRestartRequired is published when a new config requires a server restart,
+	// right before the restart
+	ConfigEventRestartRequired ConfigEventType = "restart-required"
+	// ConfigEventApplied is published when a new config has been applied
+	ConfigEventApplied ConfigEventType = "applied"
+	// ConfigEventFailed is published when a new config could not be applied, the message
+	// tells the reason
+	ConfigEventFailed ConfigEventType = "failed"
+)
+
+// ConfigEvent reports a step in the lifecycle of a reconciliation
+type ConfigEvent struct {
+	// Type is the type of the event
+	Type ConfigEventType `json:"type"`
+	// Node is the name of the daemon as set in the new config
+	Node string `json:"node,omitempty"`
+	// Generation is the generation of the new config
+	Generation int64 `json:"generation"`
+	// Time is the time of the event
+	Time time.Time `json:"time"`
+	// Message is a human-readable description of the event
+	Message string `json:"message,omitempty"`
+	// webhook is the event webhook set in the config
+	webhook string
+}
+
+// eventQueueLen is the number of events queued for each subscriber, events are dropped for slow
+// subscribers
+const eventQueueLen = 64
+
+// eventWebhookTimeout is the timeout for posting an event to the webhook
+const eventWebhookTimeout = 5 * time.Second
+
+// eventBroker fans out config events to the subscribers
+type eventBroker struct {
+	lock sync.Mutex
+	subs map[chan ConfigEvent]struct{}
+}
+
+func newEventBroker() *eventBroker {
+	return &eventBroker{subs: map[chan ConfigEvent]struct{}{}}
+}
+
+func (b *eventBroker) subscribe() (<-chan ConfigEvent, func()) {
+	ch := make(chan ConfigEvent, eventQueueLen)
+
+	b.lock.Lock()
+	b.subs[ch] = struct{}{}
+	b.lock.Unlock()
+
+	return ch, func() {
+		b.lock.Lock()
+		defer b.lock.Unlock()
+		if _, ok := b.subs[ch]; ok {
+			delete(b.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+func (b *eventBroker) publish(e ConfigEvent) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for ch := range b.subs {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+// SubscribeEvents returns a channel that receives the config change events and a function to
+// cancel the subscription. Events are dropped if the channel is not drained fast enough
+func (s *Stunner) SubscribeEvents() (<-chan ConfigEvent, func()) {
+	return s.events.subscribe()
+}
+
+// publishEvent publishes a config change event for a config, failures are also posted to the
+// notifier
+func (s *Stunner) publishEvent(t ConfigEventType, req *v1alpha1.StunnerConfig, msg string) {
+	if t == ConfigEventFailed {
+		s.notify(OperationalEvent{Type: v1alpha1.NotifierEventReconcileFailed,
+			Message: fmt.Sprintf("generation %d: %s", req.Generation, msg)})
+	}
+
+	s.events.publish(ConfigEvent{
+		Type:       t,
+		Node:       req.Admin.Name,
+		Generation: req.Generation,
+		Time:       time.Now(),
+		Message:    msg,
+		webhook:    req.Admin.EventWebhook,
+	})
+}
+
+// runEventWebhook posts the config change events received on the subscription to the event
+// webhook set in the config the event is about, so that the events of a config that sets (or
+// removes) the webhook are posted to the new webhook
+func (s *Stunner) runEventWebhook(ch <-chan ConfigEvent, cancel func()) {
+	defer cancel()
+
+	client := &http.Client{Timeout: eventWebhookTimeout}
+	for {
+		select {
+		case e := <-ch:
+			if e.webhook == "" {
+				continue
+			}
+			if err := postEvent(client, e.webhook, e); err != nil {
+				s.log.Warnf("could not post %s event to webhook %s: %s", e.Type,
+					e.webhook, err.Error())
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func postEvent(client *http.Client, url string, e ConfigEvent) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), eventWebhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package stunner
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+
+	"github.com/l7mp/stunner/internal/certs"
+	"github.com/l7mp/stunner/internal/object"
+	"github.com/l7mp/stunner/pkg/apis/v1alpha1"
+)
+
+// fipsCipherSuites are the FIPS-approved TLS 1.2 cipher suites
+var fipsCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+}
+
+// fipsCurves are the FIPS-approved curves for the key exchange
+var fipsCurves = []tls.CurveID{tls.CurveP256, tls.CurveP384, tls.CurveP521}
+
+// fipsMode returns true if STUNner runs in FIPS mode: always in boringcrypto builds, otherwise if
+// set in the admin config
+func (s *Stunner) fipsMode() bool {
+	return fipsBuild || s.GetAdmin().FIPSMode
+}
+
+// checkFIPS refuses a config that cannot be applied in FIPS mode, see v1alpha1.ValidateFIPS, or
+// that sets a TLS listener with a certificate not approved in FIPS mode
+func (s *Stunner) checkFIPS(req *v1alpha1.StunnerConfig) error {
+	if !fipsBuild && !req.Admin.FIPSMode {
+		return nil
+	}
+
+	if err := v1alpha1.ValidateFIPS(req); err != nil {
+		return err
+	}
+
+	for _, l := range req.Listeners {
+		proto, err := v1alpha1.NewListenerProtocol(l.Protocol)
+		if err != nil || (proto != v1alpha1.ListenerProtocolTLS &&
+			proto != v1alpha1.ListenerProtocolWSS) {
+			continue
+		}
+		if l.SDSSecret != "" {
+			// SDS secrets not approved in FIPS mode are refused by the certificate store
+			continue
+		}
+		cert, err := tls.LoadX509KeyPair(l.Cert, l.Key)
+		if err != nil {
+			return fmt.Errorf("cannot load cert/key pair for listener %q: %s", l.Name,
+				err.Error())
+		}
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return fmt.Errorf("cannot parse certificate for listener %q: %s", l.Name,
+				err.Error())
+		}
+		if err := certs.CheckFIPS(leaf); err != nil {
+			return fmt.Errorf("certificate for listener %q: %s", l.Name, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// newTLSConfig returns the TLS config of a TLS or WSS listener serving the certificates of a
+// store, with OCSP stapling and client certificate verification as set for the listener. The
+// config is restricted to TLS 1.2 with FIPS-approved cipher suites and curves in FIPS mode (the
+// TLS 1.3 cipher suites cannot be restricted). Certificates not approved in FIPS mode are not
+// loaded on rotation
+func (s *Stunner) newTLSConfig(l *object.Listener, store *certs.Store) (*tls.Config, error) {
+	conf := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: store.GetCertificate,
+	}
+
+	clientCAs, verify, err := s.newClientAuth(l)
+	if err != nil {
+		return nil, err
+	}
+	if clientCAs != nil {
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+		conf.ClientCAs = clientCAs
+		conf.VerifyPeerCertificate = verify
+	}
+
+	if l.OCSPStapling {
+		store.EnableOCSPStapling(&http.Client{Timeout: certs.DefaultOCSPTimeout},
+			s.options.CertReloadInterval)
+	}
+
+	if !s.fipsMode() {
+		return conf, nil
+	}
+
+	if err := store.SetVerify(certs.CheckFIPS); err != nil {
+		return nil, err
+	}
+	conf.MaxVersion = tls.VersionTLS12
+	conf.CipherSuites = fipsCipherSuites
+	conf.CurvePreferences = fipsCurves
+	return conf, nil
+}
@@ -0,0 +1,12 @@
+//go:build boringcrypto
+// +build boringcrypto
+
+package stunner
+
+import (
+	// restricts crypto/tls to FIPS-approved settings
+	_ "crypto/tls/fipsonly"
+)
+
+// fipsBuild is true in boringcrypto builds, which always run in FIPS mode
+const fipsBuild = true
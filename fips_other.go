@@ -0,0 +1,7 @@
+//go:build !boringcrypto
+// +build !boringcrypto
+
+package stunner
+
+// fipsBuild is true in boringcrypto builds, which always run in FIPS mode
+const fipsBuild = false
@@ -1,13 +1,14 @@
 package stunner
 
 import (
-	"crypto/hmac"
-	"crypto/sha1" //nolint:gosec,gci
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/base64"
+	"errors"
+	"fmt"
 	"net"
 	"strconv"
-	"time"
-	// "fmt"
 	// "net"
 	// "strings"
 
@@ -15,10 +16,14 @@ import (
 	"github.com/pion/turn/v2"
 	// "github.com/pion/transport/vnet"
 
+	"github.com/l7mp/stunner/internal/ban"
+	"github.com/l7mp/stunner/internal/nat64"
 	"github.com/l7mp/stunner/internal/object"
 	"github.com/l7mp/stunner/internal/util"
 
 	"github.com/l7mp/stunner/pkg/apis/v1alpha1"
+	"github.com/l7mp/stunner/pkg/auth"
+	"github.com/l7mp/stunner/pkg/policy"
 )
 
 // NewAuthHandler returns an authentication handler callback for STUNner, suitable to be used with the TURN server for authenticating clients
@@ -27,50 +32,84 @@ func (s *Stunner) NewAuthHandler() turn.AuthHandler {
 
 	return func(username string, realm string, srcAddr net.Addr) ([]byte, bool) {
 		// dynamic: authHandler might have changed behind ur back
-		auth := s.GetAuth()
+		// the clients of the listeners of a tenant use the credentials of the tenant
+		auth := s.GetAuth().ForTenant(s.tenants.Lookup(srcAddr))
 
 		switch auth.Type {
 		case v1alpha1.AuthTypePlainText:
 			auth.Log.Infof("plaintext auth request: username=%q realm=%q srcAddr=%v\n",
 				username, realm, srcAddr)
-
-			key := turn.GenerateAuthKey(auth.Username, auth.Realm, auth.Password)
-			if username == auth.Username {
-				return key, true
-			}
-
-			return nil, false
-
 		case v1alpha1.AuthTypeLongTerm:
 			auth.Log.Infof("longterm auth request: username=%q realm=%q srcAddr=%v",
 				username, realm, srcAddr)
+		}
 
-			t, err := strconv.Atoi(username)
-			if err != nil {
-				auth.Log.Errorf("invalid time-windowed username %q", username)
-				return nil, false
-			}
+		// unknown users get a decoy key, so that the TURN server fails their integrity check
+		// just like a bad password, with the same error response and in the same time
+		key, err := s.authKey(auth, username, realm)
+		switch {
+		case err == nil, err == errUnknownUser:
+			return key, true
+		case key != nil:
+			auth.Log.Error(err.Error())
+			return key, true
+		default:
+			auth.Log.Error(err.Error())
+			return nil, false
+		}
+	}
+}
 
-			if int64(t) < time.Now().Unix() {
-				auth.Log.Errorf("expired time-windowed username %q", username)
-				return nil, false
-			}
+// errUnknownUser is returned for a username unknown to the plaintext auth config
+var errUnknownUser = errors.New("unknown user")
 
-			mac := hmac.New(sha1.New, []byte(auth.Secret))
-			_, err = mac.Write([]byte(username))
-			if err != nil {
-				auth.Log.Errorf("failed to hash username: %w", err.Error())
-				return nil, false
-			}
-			password := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+// authDecoyPassword is the password of the decoy keys of the unknown users, random per process
+var authDecoyPassword = func() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic("cannot generate decoy password: " + err.Error())
+	}
+	return base64.StdEncoding.EncodeToString(b)
+}()
+
+// authKey returns the long-term key of a user under an auth config, without logging, e.g., for
+// checking the integrity of each TURN Send indication. The realm is the realm of the request,
+// used for the key only with a generic realm. For unknown or invalid usernames a decoy key is
+// returned along with the error: each call derives exactly one key and the usernames are compared
+// in constant time, so that the time taken does not tell whether a username exists. Time-windowed
+// usernames expire according to the clock of STUNner
+func (s *Stunner) authKey(a *object.Auth, username, realm string) ([]byte, error) {
+	if !a.GenericRealm {
+		realm = a.Realm
+	}
 
-			return turn.GenerateAuthKey(username, auth.Realm, password), true
+	switch a.Type {
+	case v1alpha1.AuthTypePlainText:
+		// compare digests so that the time taken does not depend on the length either
+		u, want := sha256.Sum256([]byte(username)), sha256.Sum256([]byte(a.Username))
+		if subtle.ConstantTimeCompare(u[:], want[:]) != 1 {
+			return turn.GenerateAuthKey(username, realm, authDecoyPassword), errUnknownUser
+		}
+		return turn.GenerateAuthKey(username, realm, a.Password), nil
 
-		default:
-			auth.Log.Errorf("internal error: unknown authentication mode %q",
-				auth.Type.String())
-			return nil, false
+	case v1alpha1.AuthTypeLongTerm:
+		password := auth.LongTermPassword(a.Secret, username)
+
+		t, err := strconv.Atoi(username)
+		if err != nil {
+			err = fmt.Errorf("invalid time-windowed username %q", username)
+		} else if int64(t) < s.clock.Now().Unix() {
+			err = fmt.Errorf("expired time-windowed username %q", username)
+		}
+		if err != nil {
+			password = authDecoyPassword
 		}
+
+		return turn.GenerateAuthKey(username, realm, password), err
+
+	default:
+		return nil, fmt.Errorf("internal error: unknown authentication mode %q",
+			a.Type.String())
 	}
 }
 
@@ -79,30 +118,124 @@ func (s *Stunner) NewPermissionHandler(l *object.Listener) turn.PermissionHandle
 	s.log.Trace("NewPermissionHandler")
 
 	return func(src net.Addr, peer net.IP) bool {
-		// need auth for logging
-		// dynamic: authHandler might have changed behind ur back
-		auth := s.GetAuth()
-
-		peerIP := peer.String()
-		auth.Log.Debugf("permission handler for listener %q: client %q, peer %q",
-			l.Name, src.String(), peerIP)
-		clusters := s.clusterManager.Keys()
-
-		for _, r := range l.Routes {
-			auth.Log.Tracef("considering route to cluster %q", r)
-			if util.Member(clusters, r) {
-				auth.Log.Tracef("considering cluster %q", r)
-				c := s.GetCluster(r)
-				if c.Route(peer) {
+		allowed := s.routePermission(l, src, peer)
+		if s.policy.Hooked(policy.KindPermission) {
+			allowed = s.policy.Decide(s.newPermissionRequest(l, src, peer, allowed))
+		}
+		if !allowed {
+			s.bans.Report(l.Name, src.String(), ban.ReasonPermissionDenied)
+		}
+		return allowed
+	}
+}
+
+// routePermission is the built-in routing policy: returns true if a client of a listener may
+// access a peer
+func (s *Stunner) routePermission(l *object.Listener, src net.Addr, peer net.IP) bool {
+	// need auth for logging
+	// dynamic: authHandler might have changed behind ur back
+	auth := s.GetAuth()
+
+	peerIP := peer.String()
+	session := s.conntrack.ClientSessionID(l.Name, src)
+	auth.Log.Debugf("permission handler for listener %q: client %q, session %s, peer %q",
+		l.Name, src.String(), session, peerIP)
+	clusters := s.clusterManager.Keys()
+
+	// peers in the NAT64 prefix reach the embedded IPv4 address, and the peers with an
+	// address mapping reach the internal addresses
+	sensitive, isSensitive := object.SensitivePeer(peer)
+	if v4, ok := nat64.Extract(peer, s.GetAdmin().NAT64Prefix); ok && !isSensitive {
+		sensitive, isSensitive = object.SensitivePeer(v4)
+	}
+	mapped := s.addressMap.InternalIPs(peer)
+	for _, ip := range mapped {
+		if !isSensitive {
+			sensitive, isSensitive = object.SensitivePeer(ip)
+		}
+	}
+
+	routed := false
+	for _, r := range l.Routes {
+		auth.Log.Tracef("considering route to cluster %q", r)
+		if util.Member(clusters, r) {
+			auth.Log.Tracef("considering cluster %q", r)
+			routed = true
+			c := s.GetCluster(r)
+			if isSensitive && !c.AllowSensitivePeers {
+				auth.Log.Tracef("cluster %q: peer %s is in the sensitive range %s",
+					c.Name, peerIP, sensitive)
+				continue
+			}
+			if c.Route(peer) {
+				auth.Log.Infof("permission granted on listener %q for client "+
+					"%q (session %s) to peer %s via cluster %q", l.Name,
+					src.String(), session, peerIP, c.Name)
+				return true
+			}
+			if v4, ok := nat64.Extract(peer, s.GetAdmin().NAT64Prefix); ok && c.Route(v4) {
+				auth.Log.Infof("permission granted on listener %q for client "+
+					"%q (session %s) to NAT64 peer %s (%s) via cluster %q", l.Name,
+					src.String(), session, peerIP, v4.String(), c.Name)
+				return true
+			}
+			for _, ip := range mapped {
+				if c.Route(ip) {
 					auth.Log.Infof("permission granted on listener %q for client "+
-						"%q to peer %s via cluster %q", l.Name, src.String(),
-						peerIP, c.Name)
+						"%q (session %s) to mapped peer %s (%s) via cluster %q",
+						l.Name, src.String(), session, peerIP, ip.String(), c.Name)
 					return true
 				}
 			}
 		}
-		auth.Log.Debugf("permission denied on listener %q for client %q to peer %s: no route to endpoint",
-			l.Name, src.String(), peerIP)
+	}
+
+	// listeners with no routes fall back to the default route, while the routes to missing
+	// clusters, e.g., a misspelled cluster name, do not grant access to any peer
+	if len(l.Routes) == 0 {
+		if isSensitive {
+			auth.Log.Debugf("permission denied on listener %q for client %q (session %s) "+
+				"to peer %s via the default route: peer in the sensitive range %s",
+				l.Name, src.String(), session, peerIP, sensitive)
+			return false
+		}
+		if c := s.otherTenantCluster(l.Tenant, peer); c != nil {
+			auth.Log.Debugf("permission denied on listener %q for client %q (session %s) "+
+				"to peer %s via the default route: peer in cluster %q of tenant %q",
+				l.Name, src.String(), session, peerIP, c.Name, c.Tenant)
+			return false
+		}
+		if s.GetAdmin().DefaultRoute == v1alpha1.DefaultRouteAllow {
+			auth.Log.Infof("permission granted on listener %q for client %q (session %s) "+
+				"to peer %s via the default route", l.Name, src.String(), session, peerIP)
+			return true
+		}
+		auth.Log.Debugf("permission denied on listener %q for client %q (session %s) to "+
+			"peer %s: no routes and the default route is %q", l.Name,
+			src.String(), session, peerIP, v1alpha1.DefaultRouteDeny.String())
+		return false
+	}
+
+	if !routed {
+		auth.Log.Debugf("permission denied on listener %q for client %q (session %s) to "+
+			"peer %s: none of the clusters %q exists", l.Name, src.String(), session, peerIP,
+			l.Routes)
 		return false
 	}
+
+	auth.Log.Debugf("permission denied on listener %q for client %q (session %s) to peer %s: "+
+		"no route to endpoint", l.Name, src.String(), session, peerIP)
+	return false
+}
+
+// otherTenantCluster returns the cluster of a tenant other than the given tenant that routes to
+// the peer, if any, so that the default route does not cross the isolation of the tenants
+func (s *Stunner) otherTenantCluster(tenant string, peer net.IP) *object.Cluster {
+	for _, name := range s.clusterManager.Keys() {
+		c := s.GetCluster(name)
+		if c != nil && c.Tenant != "" && c.Tenant != tenant && c.Route(peer) {
+			return c
+		}
+	}
+	return nil
 }
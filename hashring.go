@@ -0,0 +1,70 @@
+package stunner
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+
+	"github.com/l7mp/stunner/internal/api"
+	"github.com/l7mp/stunner/internal/hashring"
+)
+
+// HashRingStatus is the response of the /api/v1/hashring admin API
+type HashRingStatus struct {
+	// Replicas lists the IP addresses of the replicas in the hash ring
+	Replicas []string `json:"replicas"`
+	// Self is the IP address of this replica
+	Self string `json:"self"`
+	// Mode is "hint" or "redirect"
+	Mode string `json:"mode"`
+	// Client is the IP address of the client queried, if any
+	Client string `json:"client,omitempty"`
+	// Owner is the IP address of the replica owning the client, if any
+	Owner string `json:"owner,omitempty"`
+}
+
+// reconcileHashRing sets the hash ring of the replicas from the admin config, or disables the ring
+// if none is configured
+func (s *Stunner) reconcileHashRing() {
+	req := s.GetAdmin().ConsistentHashing
+	if req == nil {
+		s.hashRing.SetConfig(nil)
+		return
+	}
+
+	conf := &hashring.Config{Self: net.ParseIP(req.Self), Redirect: req.Mode == "redirect"}
+	for _, r := range req.Replicas {
+		conf.Replicas = append(conf.Replicas, net.ParseIP(r))
+	}
+	s.hashRing.SetConfig(conf)
+}
+
+// GET /api/v1/hashring: show the hash ring of the replicas, and the replica owning the client
+// given in the "client" query parameter, if any
+func (s *Stunner) handleHashRing(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	req := s.GetAdmin().ConsistentHashing
+	if req == nil {
+		api.WriteError(w, http.StatusNotFound, fmt.Errorf("consistent hashing disabled"))
+		return
+	}
+
+	status := HashRingStatus{Replicas: req.Replicas, Self: req.Self, Mode: req.Mode}
+	if client := r.URL.Query().Get("client"); client != "" {
+		ip := net.ParseIP(client)
+		if ip == nil {
+			api.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid client: %q", client))
+			return
+		}
+		status.Client = ip.String()
+		if owner := s.hashRing.Owner(ip); owner != nil {
+			status.Owner = owner.String()
+		}
+	}
+
+	api.WriteJSON(w, http.StatusOK, status)
+}
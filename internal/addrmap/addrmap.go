@@ -0,0 +1,131 @@
+// Package addrmap translates the peer addresses of the relay transports with an address-mapping
+// table, so that the peers the clients know by a translated address are reached at their internal
+// address. This covers the hairpin case, where the peer sits behind the same gateway and the
+// clients address it with the public address of the gateway that the NAT in front of the gateway
+// may not loop back, and the peers reachable only via a static NAT. Packets sent to an external
+// address go out to the internal address, and the packets received from the internal address are
+// reported as coming from the external address the client used, so that the TURN permissions keep
+// matching.
+package addrmap
+
+import (
+	"net"
+	"sort"
+	"sync/atomic"
+
+	"github.com/pion/logging"
+)
+
+const (
+	// SourceStatic marks the mappings set in the admin config
+	SourceStatic = "static"
+	// SourceHairpin marks the mappings of the public addresses of the listeners
+	SourceHairpin = "hairpin"
+)
+
+// Mapping translates an external peer address to an internal address. Zero ports map all ports
+// of the external address to the same ports of the internal address
+type Mapping struct {
+	External     net.IP
+	ExternalPort int
+	Internal     net.IP
+	InternalPort int
+	// Source is SourceStatic or SourceHairpin
+	Source string
+	// Listener is the listener of a hairpin mapping
+	Listener string
+}
+
+// Translate returns the internal address of an external peer address, or false if the mapping
+// does not apply to the address
+func (m *Mapping) Translate(addr *net.UDPAddr) (*net.UDPAddr, bool) {
+	if !m.External.Equal(addr.IP) || (m.ExternalPort != 0 && m.ExternalPort != addr.Port) {
+		return nil, false
+	}
+	port := addr.Port
+	if m.InternalPort != 0 {
+		port = m.InternalPort
+	}
+	return &net.UDPAddr{IP: m.Internal, Port: port}, true
+}
+
+// Reverse returns the external address of an internal peer address, or false if the mapping does
+// not apply to the address
+func (m *Mapping) Reverse(addr *net.UDPAddr) (*net.UDPAddr, bool) {
+	if !m.Internal.Equal(addr.IP) || (m.InternalPort != 0 && m.InternalPort != addr.Port) {
+		return nil, false
+	}
+	port := addr.Port
+	if m.ExternalPort != 0 {
+		port = m.ExternalPort
+	}
+	return &net.UDPAddr{IP: m.External, Port: port}, true
+}
+
+// Table is the address-mapping table applied by the relay transports
+type Table struct {
+	mappings atomic.Value // []Mapping, the mappings with a port first
+	log      logging.LeveledLogger
+}
+
+// NewTable creates an empty address-mapping table
+func NewTable(logger logging.LoggerFactory) *Table {
+	t := &Table{log: logger.NewLogger("addrmap")}
+	t.mappings.Store([]Mapping(nil))
+	return t
+}
+
+// SetMappings sets the mappings of the table. The mappings apply to the existing relay transports
+// too
+func (t *Table) SetMappings(mappings []Mapping) {
+	ms := append([]Mapping(nil), mappings...)
+	// the mappings of a single port take precedence
+	sort.SliceStable(ms, func(i, j int) bool {
+		return ms[i].ExternalPort != 0 && ms[j].ExternalPort == 0
+	})
+	t.mappings.Store(ms)
+}
+
+// Mappings returns the mappings of the table
+func (t *Table) Mappings() []Mapping {
+	return append([]Mapping(nil), t.mappings.Load().([]Mapping)...)
+}
+
+// Translate returns the internal address of a peer address and the mapping applied, or nil if no
+// mapping applies to the address
+func (t *Table) Translate(addr net.Addr) (*net.UDPAddr, *Mapping) {
+	a, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return nil, nil
+	}
+	ms := t.mappings.Load().([]Mapping)
+	for i := range ms {
+		if ret, ok := ms[i].Translate(a); ok {
+			return ret, &ms[i]
+		}
+	}
+	return nil, nil
+}
+
+// InternalIPs returns the internal IP addresses the mappings translate a peer IP address to on any
+// port, used to check the permissions that are installed per peer IP address
+func (t *Table) InternalIPs(ip net.IP) []net.IP {
+	ret := []net.IP{}
+	for _, m := range t.mappings.Load().([]Mapping) {
+		if m.External.Equal(ip) {
+			ret = append(ret, m.Internal)
+		}
+	}
+	return ret
+}
+
+// ExternalAddrs returns the external addresses the mappings translate to an internal peer address
+func (t *Table) ExternalAddrs(addr *net.UDPAddr) []*net.UDPAddr {
+	ret := []*net.UDPAddr{}
+	for _, m := range t.mappings.Load().([]Mapping) {
+		if ext, ok := m.Reverse(addr); ok {
+			ret = append(ret, ext)
+		}
+	}
+	return ret
+}
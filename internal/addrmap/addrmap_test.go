@@ -0,0 +1,105 @@
+package addrmap
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pion/logging"
+	"github.com/pion/transport/vnet"
+	"github.com/pion/turn/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTable(t *testing.T) {
+	table := NewTable(logging.NewDefaultLoggerFactory())
+	peer := &net.UDPAddr{IP: net.ParseIP("203.0.113.10"), Port: 5000}
+	_, m := table.Translate(peer)
+	assert.Nil(t, m, "empty table")
+
+	table.SetMappings([]Mapping{
+		{External: net.ParseIP("203.0.113.10"), Internal: net.ParseIP("10.0.0.1"),
+			Source: SourceHairpin, Listener: "udp"},
+		{External: net.ParseIP("203.0.113.10"), ExternalPort: 80,
+			Internal: net.ParseIP("10.0.0.2"), InternalPort: 8080, Source: SourceStatic},
+	})
+	assert.Equal(t, SourceStatic, table.Mappings()[0].Source, "port mappings first")
+
+	internal, m := table.Translate(peer)
+	assert.NotNil(t, m, "mapped")
+	assert.Equal(t, "10.0.0.1:5000", internal.String(), "port kept")
+	assert.Equal(t, SourceHairpin, m.Source, "source")
+
+	internal, m = table.Translate(&net.UDPAddr{IP: net.ParseIP("203.0.113.10"), Port: 80})
+	assert.NotNil(t, m, "mapped")
+	assert.Equal(t, "10.0.0.2:8080", internal.String(), "port mapping takes precedence")
+
+	_, m = table.Translate(&net.UDPAddr{IP: net.ParseIP("203.0.113.11"), Port: 80})
+	assert.Nil(t, m, "not mapped")
+
+	assert.Len(t, table.InternalIPs(net.ParseIP("203.0.113.10")), 2, "internal IPs")
+	assert.Len(t, table.InternalIPs(net.ParseIP("10.0.0.1")), 0, "no internal IPs")
+
+	ext := table.ExternalAddrs(&net.UDPAddr{IP: net.ParseIP("10.0.0.2"), Port: 8080})
+	assert.Len(t, ext, 1, "external address")
+	assert.Equal(t, "203.0.113.10:80", ext[0].String(), "reversed")
+	assert.Len(t, table.ExternalAddrs(&net.UDPAddr{IP: net.ParseIP("10.0.0.2"), Port: 80}), 0,
+		"port not mapped")
+
+	table.SetMappings(nil)
+	_, m = table.Translate(peer)
+	assert.Nil(t, m, "mappings removed")
+}
+
+func TestRelayConn(t *testing.T) {
+	table := NewTable(logging.NewDefaultLoggerFactory())
+	gen := table.NewRelayAddressGenerator(&turn.RelayAddressGeneratorStatic{
+		RelayAddress: net.ParseIP("127.0.0.1"),
+		Address:      "127.0.0.1",
+		Net:          vnet.NewNet(nil),
+	}, "udp")
+	relay, _, err := gen.AllocatePacketConn("udp4", 0)
+	assert.NoError(t, err, "allocate")
+	defer relay.Close()
+
+	peer, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	assert.NoError(t, err, "peer")
+	defer peer.Close()
+	port := peer.LocalAddr().(*net.UDPAddr).Port
+	external := &net.UDPAddr{IP: net.ParseIP("203.0.113.10"), Port: 3478}
+
+	// send returns true if the peer receives a packet sent to an address
+	send := func(addr net.Addr) bool {
+		// loopback sockets cannot send to external addresses
+		if _, err := relay.WriteTo([]byte("ping"), addr); err != nil {
+			return false
+		}
+		buf := make([]byte, 16)
+		peer.SetReadDeadline(time.Now().Add(50 * time.Millisecond)) //nolint:errcheck
+		_, _, err = peer.ReadFrom(buf)
+		return err == nil
+	}
+	// receive returns the source of a packet sent by the peer
+	receive := func() string {
+		_, err := peer.WriteTo([]byte("pong"), relay.LocalAddr())
+		assert.NoError(t, err, "write")
+		buf := make([]byte, 16)
+		relay.SetReadDeadline(time.Now().Add(time.Second)) //nolint:errcheck
+		_, from, err := relay.ReadFrom(buf)
+		assert.NoError(t, err, "read")
+		return from.String()
+	}
+
+	assert.True(t, send(peer.LocalAddr()), "direct: send")
+	assert.Equal(t, peer.LocalAddr().String(), receive(), "direct: receive")
+
+	table.SetMappings([]Mapping{{External: external.IP, ExternalPort: external.Port,
+		Internal: net.ParseIP("127.0.0.1"), InternalPort: port, Source: SourceStatic}})
+	assert.Equal(t, peer.LocalAddr().String(), receive(), "not yet sent via the mapping")
+	assert.True(t, send(external), "mapped: send")
+	assert.Equal(t, external.String(), receive(), "mapped: receive")
+
+	table.SetMappings(nil)
+	assert.Equal(t, peer.LocalAddr().String(), receive(), "mapping removed: receive")
+	assert.False(t, send(external), "mapping removed: send")
+}
@@ -0,0 +1,96 @@
+package addrmap
+
+import (
+	"net"
+	"sync"
+
+	"github.com/pion/turn/v2"
+
+	"github.com/l7mp/stunner/internal/monitoring"
+)
+
+// relayAddressGenerator wraps a TURN relay address generator so that the relay transports it
+// allocates translate the peer addresses with the table
+type relayAddressGenerator struct {
+	turn.RelayAddressGenerator
+	listener string
+	table    *Table
+}
+
+// NewRelayAddressGenerator wraps the relay address generator of a listener
+func (t *Table) NewRelayAddressGenerator(gen turn.RelayAddressGenerator, listener string) turn.RelayAddressGenerator {
+	return &relayAddressGenerator{RelayAddressGenerator: gen, listener: listener, table: t}
+}
+
+func (r *relayAddressGenerator) AllocatePacketConn(network string, requestedPort int) (net.PacketConn, net.Addr, error) {
+	conn, addr, err := r.RelayAddressGenerator.AllocatePacketConn(network, requestedPort)
+	if err != nil {
+		return conn, addr, err
+	}
+	return &relayConn{PacketConn: conn, listener: r.listener, table: r.table,
+		peers: make(map[string]*net.UDPAddr)}, addr, nil
+}
+
+// relayConn translates the peer addresses: packets sent to an external address go out to the
+// internal address, and packets received from an internal address the client has sent to via
+// its external address are reported as coming from the external address. Packets from internal
+// addresses the client uses directly are left alone, so that a peer can be reached both ways
+type relayConn struct {
+	net.PacketConn
+	listener string
+	table    *Table
+	lock     sync.RWMutex
+	peers    map[string]*net.UDPAddr // internal peer -> external peer
+}
+
+func (c *relayConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if internal, m := c.table.Translate(addr); m != nil {
+		key := internal.String()
+
+		c.lock.RLock()
+		_, found := c.peers[key]
+		c.lock.RUnlock()
+		if !found {
+			c.lock.Lock()
+			c.peers[key] = addr.(*net.UDPAddr)
+			c.lock.Unlock()
+			c.table.log.Debugf("relaying to peer %s at %s on listener %s (%s mapping)",
+				addr, key, c.listener, m.Source)
+		}
+
+		monitoring.AddressMappingCounter.WithLabelValues(c.listener, "outbound", m.Source).Inc()
+		addr = internal
+	}
+
+	return c.PacketConn.WriteTo(b, addr)
+}
+
+func (c *relayConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, err := c.PacketConn.ReadFrom(b)
+	if err != nil {
+		return n, addr, err
+	}
+
+	a, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return n, addr, nil
+	}
+	c.lock.RLock()
+	external, found := c.peers[a.String()]
+	c.lock.RUnlock()
+	if !found {
+		return n, addr, nil
+	}
+
+	// the mapping may have been removed since
+	internal, m := c.table.Translate(external)
+	if m == nil || !internal.IP.Equal(a.IP) || internal.Port != a.Port {
+		c.lock.Lock()
+		delete(c.peers, a.String())
+		c.lock.Unlock()
+		return n, addr, nil
+	}
+
+	monitoring.AddressMappingCounter.WithLabelValues(c.listener, "inbound", m.Source).Inc()
+	return n, external, nil
+}
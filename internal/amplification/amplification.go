@@ -0,0 +1,233 @@
+// Package amplification protects the gateway from being abused as a UDP amplifier: the requests
+// the TURN server answers without authentication (Binding requests and the first Allocate request
+// of a client, which is challenged for credentials) received on UDP listeners from client sources
+// that hold no allocation, and hence whose address may be spoofed, are rate-limited, gateway-wide
+// and per source IP address. Optionally, Binding requests are answered only to sources holding an
+// allocation, i.e., that have proven to receive the responses of the gateway by returning the nonce
+// of an authentication challenge as a cookie. Only the requests whose MESSAGE-INTEGRITY checks out
+// with the long-term key of the user are exempt from the limits, since anyone can add an integrity
+// attribute to a spoofed request. Suppressed requests are dropped at the socket layer of the
+// listeners, so that no response is sent.
+package amplification
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/logging"
+	"github.com/pion/stun"
+
+	"github.com/l7mp/stunner/internal/monitoring"
+	"github.com/l7mp/stunner/internal/stunmsg"
+	"github.com/l7mp/stunner/internal/tokenbucket"
+	"github.com/l7mp/stunner/internal/util"
+	"github.com/l7mp/stunner/pkg/clock"
+)
+
+// Reasons a request is suppressed for
+const (
+	// RateLimited is a request over the response rate, gateway-wide or of the source
+	RateLimited = "rate-limited"
+	// CookieRequired is a Binding request of a source that holds no allocation
+	CookieRequired = "cookie-required"
+)
+
+const (
+	// maxSources bounds the number of sources whose response rate is tracked
+	maxSources = 1 << 16
+)
+
+// Config sets the limits, zero means no limit
+type Config struct {
+	// Rate is the number of responses per second to unauthenticated requests on the gateway
+	Rate int
+	// RatePerSource is the number of responses per second to unauthenticated requests of a
+	// source
+	RatePerSource int
+	// RequireCookie suppresses the Binding requests of the sources that hold no allocation
+	RequireCookie bool
+}
+
+// Counter reports the allocations in use
+type Counter interface {
+	// SourceLen returns the number of allocations of a client source IP address
+	SourceLen(ip net.IP) int
+}
+
+// Limiter suppresses the unauthenticated requests over the limits
+type Limiter struct {
+	lock    sync.Mutex
+	conf    *Config
+	enabled int32 // atomic, so that the sockets skip the checks if no limit is set
+	counter Counter
+	global  *tokenbucket.Bucket
+	sources map[string]*tokenbucket.Bucket
+	log     logging.LeveledLogger
+	clock   clock.Clock
+}
+
+// NewLimiter creates a limiter that takes the allocations in use from the counter, disabled until
+// a config is set
+func NewLimiter(counter Counter, logger logging.LoggerFactory) *Limiter {
+	return &Limiter{
+		counter: counter,
+		sources: map[string]*tokenbucket.Bucket{},
+		clock:   clock.Real,
+		log:     logger.NewLogger("amplification"),
+	}
+}
+
+// SetClock sets the clock the response rates are metered with. Must be called before the limiter is
+// used
+func (l *Limiter) SetClock(c clock.Clock) {
+	l.clock = c
+}
+
+// SetConfig sets the limits, nil disables the limiter. The response rates are reset if the limits
+// change
+func (l *Limiter) SetConfig(conf *Config) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if conf != nil && l.conf != nil && *conf == *l.conf {
+		return
+	}
+	l.conf = conf
+	l.global = nil
+	l.sources = map[string]*tokenbucket.Bucket{}
+
+	enabled := int32(0)
+	if conf != nil {
+		enabled = 1
+		if conf.Rate > 0 {
+			l.global = tokenbucket.New(l.clock.Now(), conf.Rate)
+		}
+	}
+	atomic.StoreInt32(&l.enabled, enabled)
+}
+
+// Suppress checks a message received from a client, with the header h, and returns the reason the
+// message is to be dropped for, or an empty string if the message is passed to the TURN server.
+// The integrity of the authenticated requests over the limits is checked with the keys returned by
+// the key function
+func (l *Limiter) Suppress(b []byte, h stunmsg.Header, client net.Addr, key stunmsg.KeyFunc) string {
+	if atomic.LoadInt32(&l.enabled) == 0 || h.Kind != stunmsg.STUN {
+		return ""
+	}
+	var typ stun.MessageType
+	typ.ReadValue(h.Type)
+	if typ.Class != stun.ClassRequest {
+		return ""
+	}
+	ip := util.AddrIP(client)
+	if ip == nil {
+		return ""
+	}
+	// the source has returned a nonce
+	if l.counter.SourceLen(ip) > 0 {
+		return ""
+	}
+
+	if reason := l.limit(ip, typ); reason != "" {
+		// the source has proven to know the key of a user: checked only for the requests to be
+		// dropped, as this is the costly check
+		if authenticated(b, client, key) {
+			return ""
+		}
+		return reason
+	}
+	return ""
+}
+
+// limit takes a token for a request from a source and returns the reason the request is over the
+// limits for, if any
+func (l *Limiter) limit(ip net.IP, typ stun.MessageType) string {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	conf := l.conf
+	if conf == nil {
+		return ""
+	}
+	if conf.RequireCookie && typ.Method == stun.MethodBinding {
+		return CookieRequired
+	}
+
+	now := l.clock.Now()
+	if conf.RatePerSource > 0 && !l.takeSource(now, ip.String(), conf.RatePerSource) {
+		return RateLimited
+	}
+	if l.global != nil && !l.global.Take(now, conf.Rate) {
+		return RateLimited
+	}
+	return ""
+}
+
+// takeSource takes a token from the bucket of a source, must be called with the lock held
+func (l *Limiter) takeSource(now time.Time, source string, rate int) bool {
+	b := util.Bucket(l.sources, source, now, rate, maxSources)
+	if b == nil {
+		l.log.Debugf("too many sources, not limiting the response rate of %s", source)
+		return true
+	}
+	return b.Take(now, rate)
+}
+
+// authenticated returns true if a STUN message carries a MESSAGE-INTEGRITY attribute that checks
+// out with the long-term key of the user in the USERNAME and REALM attributes
+func authenticated(b []byte, client net.Addr, key stunmsg.KeyFunc) bool {
+	if key == nil {
+		return false
+	}
+	m := &stun.Message{Raw: append([]byte(nil), b...)}
+	if err := m.Decode(); err != nil || !m.Contains(stun.AttrMessageIntegrity) {
+		return false
+	}
+	var username stun.Username
+	var realm stun.Realm
+	if username.GetFrom(m) != nil || realm.GetFrom(m) != nil {
+		return false
+	}
+	k, ok := key(username.String(), realm.String(), client)
+	if !ok {
+		return false
+	}
+	return stun.MessageIntegrity(k).Check(m) == nil
+}
+
+// NewPacketConn wraps the socket of a UDP listener so that the unauthenticated requests over the
+// limits are dropped before reaching the TURN server, checking the integrity of the authenticated
+// requests with the keys returned by the key function
+func (l *Limiter) NewPacketConn(conn net.PacketConn, listener string, key stunmsg.KeyFunc) net.PacketConn {
+	return &packetConn{PacketConn: conn, limiter: l, listener: listener, key: key}
+}
+
+type packetConn struct {
+	net.PacketConn
+	limiter  *Limiter
+	listener string
+	key      stunmsg.KeyFunc
+}
+
+func (c *packetConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, _, err := c.ReadMessage(b)
+	return n, addr, err
+}
+
+func (c *packetConn) ReadMessage(b []byte) (int, net.Addr, stunmsg.Header, error) {
+	for {
+		n, addr, h, err := stunmsg.ReadMessage(c.PacketConn, b)
+		if err != nil {
+			return n, addr, h, err
+		}
+		reason := c.limiter.Suppress(b[:n], h, addr, c.key)
+		if reason == "" {
+			return n, addr, h, err
+		}
+		monitoring.AmplificationSuppressedCounter.WithLabelValues(c.listener, reason).Inc()
+		c.limiter.log.Tracef("suppressing unauthenticated request from %s on listener %s: %s",
+			addr, c.listener, reason)
+	}
+}
@@ -0,0 +1,148 @@
+package amplification
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pion/logging"
+	"github.com/pion/stun"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/l7mp/stunner/internal/stunmsg"
+	"github.com/l7mp/stunner/pkg/clock"
+)
+
+type testCounter map[string]int
+
+func (c testCounter) SourceLen(ip net.IP) int { return c[ip.String()] }
+
+var (
+	binding  = stun.MustBuild(stun.TransactionID, stun.BindingRequest).Raw
+	allocate = stun.MustBuild(stun.TransactionID, stun.NewType(stun.MethodAllocate,
+		stun.ClassRequest)).Raw
+	authenticatedAllocate = stun.MustBuild(stun.TransactionID, stun.NewType(stun.MethodAllocate,
+		stun.ClassRequest), stun.NewUsername("user1"), stun.NewRealm("realm"), stun.NewNonce("nonce"),
+		stun.NewLongTermIntegrity("user1", "realm", "passwd1")).Raw
+	forgedAllocate = stun.MustBuild(stun.TransactionID, stun.NewType(stun.MethodAllocate,
+		stun.ClassRequest), stun.NewUsername("user1"), stun.NewRealm("realm"), stun.NewNonce("nonce"),
+		stun.NewLongTermIntegrity("user1", "realm", "wrong")).Raw
+	indication = stun.MustBuild(stun.TransactionID, stun.NewType(stun.MethodSend,
+		stun.ClassIndication)).Raw
+)
+
+func testKey(username, realm string, _ net.Addr) ([]byte, bool) {
+	return stun.NewLongTermIntegrity("user1", "realm", "passwd1"), username == "user1"
+}
+
+func TestAmplificationDisabled(t *testing.T) {
+	l := NewLimiter(testCounter{}, logging.NewDefaultLoggerFactory())
+	client := &net.UDPAddr{IP: net.ParseIP("1.2.3.4"), Port: 1234}
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, "", l.Suppress(binding, stunmsg.Parse(binding), client, testKey), "no limit")
+	}
+
+	l.SetConfig(&Config{RequireCookie: true})
+	assert.Equal(t, CookieRequired, l.Suppress(binding, stunmsg.Parse(binding), client, testKey), "cookie required")
+
+	l.SetConfig(nil)
+	assert.Equal(t, "", l.Suppress(binding, stunmsg.Parse(binding), client, testKey), "protections removed")
+}
+
+func TestAmplificationRate(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	c := testCounter{}
+	l := NewLimiter(c, logging.NewDefaultLoggerFactory())
+	l.SetClock(clk)
+	l.SetConfig(&Config{Rate: 3, RatePerSource: 1})
+
+	client := &net.UDPAddr{IP: net.ParseIP("1.2.3.4"), Port: 1234}
+	assert.Equal(t, "", l.Suppress(binding, stunmsg.Parse(binding), client, testKey), "answered")
+	assert.Equal(t, "", l.Suppress(allocate, stunmsg.Parse(allocate), client, testKey), "burst")
+	assert.Equal(t, RateLimited, l.Suppress(binding, stunmsg.Parse(binding), client, testKey), "source rate exceeded")
+
+	// authenticated requests and indications are left to the TURN server, but the requests with
+	// an integrity attribute not matching the key of the user are not
+	assert.Equal(t, "", l.Suppress(authenticatedAllocate, stunmsg.Parse(authenticatedAllocate), client, testKey), "authenticated")
+	assert.Equal(t, RateLimited, l.Suppress(forgedAllocate, stunmsg.Parse(forgedAllocate), client, testKey), "forged integrity")
+	assert.Equal(t, RateLimited, l.Suppress(authenticatedAllocate, stunmsg.Parse(authenticatedAllocate), client, nil), "no key")
+	assert.Equal(t, "", l.Suppress(indication, stunmsg.Parse(indication), client, testKey), "indication")
+	channelData := []byte{0x40, 0, 0, 4, 0, 0, 0, 0}
+	assert.Equal(t, "", l.Suppress(channelData, stunmsg.Parse(channelData), client, testKey), "channel data")
+
+	// sources holding an allocation have returned a nonce
+	c["1.2.3.4"] = 1
+	assert.Equal(t, "", l.Suppress(binding, stunmsg.Parse(binding), client, testKey), "validated source")
+
+	// the gateway-wide bucket holds 6 tokens, 2 taken
+	for i := 5; i < 9; i++ {
+		assert.Equal(t, "", l.Suppress(binding, stunmsg.Parse(binding), &net.UDPAddr{IP: net.IPv4(1, 2, 3, byte(i)),
+			Port: 1}, testKey), "answered")
+	}
+	assert.Equal(t, RateLimited, l.Suppress(binding, stunmsg.Parse(binding), &net.UDPAddr{IP: net.IPv4(1, 2, 3, 10),
+		Port: 1}, testKey), "gateway rate exceeded")
+
+	// the buckets are refilled
+	clk.Advance(time.Second)
+	other := &net.UDPAddr{IP: net.IPv4(1, 2, 3, 10), Port: 1}
+	assert.Equal(t, "", l.Suppress(binding, stunmsg.Parse(binding), other, testKey), "refilled")
+}
+
+func TestAmplificationIntegrityCheck(t *testing.T) {
+	l := NewLimiter(testCounter{}, logging.NewDefaultLoggerFactory())
+	l.SetClock(clock.NewFake(time.Now()))
+	l.SetConfig(&Config{RatePerSource: 1})
+
+	// the integrity is checked only for the requests over the limits
+	lookups := 0
+	key := func(username, realm string, client net.Addr) ([]byte, bool) {
+		lookups++
+		return testKey(username, realm, client)
+	}
+	client := &net.UDPAddr{IP: net.ParseIP("1.2.3.4"), Port: 1234}
+	for i := 0; i < 2; i++ {
+		assert.Equal(t, "", l.Suppress(authenticatedAllocate, stunmsg.Parse(authenticatedAllocate), client, key), "within the limits")
+	}
+	assert.Equal(t, 0, lookups, "no integrity check")
+	assert.Equal(t, "", l.Suppress(authenticatedAllocate, stunmsg.Parse(authenticatedAllocate), client, key), "authenticated")
+	assert.Equal(t, 1, lookups, "integrity checked")
+}
+
+func TestAmplificationRequireCookie(t *testing.T) {
+	c := testCounter{}
+	l := NewLimiter(c, logging.NewDefaultLoggerFactory())
+	l.SetConfig(&Config{RequireCookie: true})
+
+	client := &net.UDPAddr{IP: net.ParseIP("1.2.3.4"), Port: 1234}
+	assert.Equal(t, CookieRequired, l.Suppress(binding, stunmsg.Parse(binding), client, testKey), "binding")
+	// the challenge of the Allocate request carries the cookie
+	assert.Equal(t, "", l.Suppress(allocate, stunmsg.Parse(allocate), client, testKey), "allocate")
+
+	c["1.2.3.4"] = 1
+	assert.Equal(t, "", l.Suppress(binding, stunmsg.Parse(binding), client, testKey), "validated source")
+}
+
+func TestAmplificationPacketConn(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err, "server socket")
+	defer server.Close()
+	client, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err, "client socket")
+	defer client.Close()
+
+	l := NewLimiter(testCounter{}, logging.NewDefaultLoggerFactory())
+	l.SetConfig(&Config{RequireCookie: true})
+	conn := l.NewPacketConn(server, "udp", testKey)
+
+	_, err = client.WriteTo(binding, server.LocalAddr())
+	assert.NoError(t, err, "send binding")
+	_, err = client.WriteTo(allocate, server.LocalAddr())
+	assert.NoError(t, err, "send allocate")
+
+	// the Binding request is dropped
+	buf := make([]byte, 1500)
+	assert.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)), "deadline")
+	n, _, err := conn.ReadFrom(buf)
+	assert.NoError(t, err, "read")
+	assert.Equal(t, allocate, buf[:n], "allocate request")
+}
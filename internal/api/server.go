@@ -0,0 +1,320 @@
+// Package api implements the HTTP server for the STUNner admin REST API.
+package api
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pion/logging"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+
+	"github.com/l7mp/stunner/internal/util"
+	"github.com/l7mp/stunner/pkg/apis/v1alpha1"
+)
+
+// Server serves the admin REST API. Handlers are registered once and survive endpoint changes.
+type Server interface {
+	// Reconcile restarts the server if the endpoint changes, an empty endpoint stops it
+	Reconcile(endpoint string) error
+	// SetToken sets the bearer token required for accessing the API. With an empty token only
+	// the public paths are served, the rest of the API is refused
+	SetToken(token string)
+	// Handle registers an API handler for the given path pattern
+	Handle(pattern string, handler http.Handler)
+	// HandlePublic registers an API handler for the given path pattern served without
+	// authentication, meant for the health and readiness checks
+	HandlePublic(pattern string, handler http.Handler)
+	// Handler returns the HTTP handler serving all registered API paths, with authentication
+	Handler() http.Handler
+	// ReconcileSocket restarts the server on the local Unix socket if the path changes, an empty
+	// path stops it
+	ReconcileSocket(path string) error
+	// Stop shuts down the server, the local Unix socket is served until closed with
+	// ReconcileSocket
+	Stop()
+	// GetEndpoint returns the current endpoint
+	GetEndpoint() string
+	// Running returns true if the server is serving requests at the current endpoint
+	Running() bool
+}
+
+type serverImpl struct {
+	lock       sync.Mutex
+	httpServer *http.Server
+	cancel     context.CancelFunc // cancels the requests in progress, e.g., streams
+	mux        *http.ServeMux
+	public     map[string]bool
+	token      string
+	Endpoint   string
+	unixServer *http.Server
+	SocketPath string
+	dryRun     bool
+	log        logging.LeveledLogger
+}
+
+// NewServer creates a new admin API server. In dry-run mode the server is never started.
+func NewServer(endpoint string, dryRun bool, logger logging.LoggerFactory) Server {
+	log := logger.NewLogger("admin-api")
+	log.Tracef("NewServer")
+
+	return &serverImpl{
+		mux:      http.NewServeMux(),
+		public:   map[string]bool{},
+		Endpoint: endpoint,
+		dryRun:   dryRun,
+		log:      log,
+	}
+}
+
+// Reconcile (re)starts the API server at the given endpoint. Only configuration errors are
+// reported: we don't want to rollback the config just because the HTTP server cannot bind
+func (s *serverImpl) Reconcile(endpoint string) error {
+	s.log.Tracef("Reconcile: %s", endpoint)
+
+	if endpoint == "" {
+		s.Stop()
+		s.Endpoint = ""
+		return nil
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return err
+	}
+
+	addr := u.Hostname()
+	port := u.Port()
+	if port == "" {
+		port = strconv.Itoa(v1alpha1.DefaultAPIPort)
+	}
+	addr = addr + ":" + port
+
+	if s.dryRun {
+		s.Endpoint = endpoint
+		return nil
+	}
+
+	if s.Endpoint == endpoint && s.running() {
+		return nil
+	}
+
+	s.Stop()
+
+	s.lock.Lock()
+	s.Endpoint = endpoint
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	// cleartext HTTP/2 is needed for the gRPC admin API
+	s.httpServer = &http.Server{
+		Addr:        addr,
+		Handler:     h2c.NewHandler(s.Handler(), &http2.Server{}),
+		BaseContext: func(net.Listener) context.Context { return ctx },
+	}
+	server := s.httpServer
+	s.lock.Unlock()
+
+	s.log.Infof("starting admin API server at %s", addr)
+	go func() {
+		err := server.ListenAndServe()
+		if errors.Is(err, http.ErrServerClosed) {
+			s.log.Tracef("admin API server: normal shutdown")
+		} else if err != nil {
+			s.log.Warnf("cannot start admin API server: %s", err.Error())
+			s.lock.Lock()
+			if s.httpServer == server {
+				s.httpServer = nil
+			}
+			s.lock.Unlock()
+		}
+	}()
+
+	return nil
+}
+
+func (s *serverImpl) running() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.httpServer != nil
+}
+
+// Running returns true if the server is serving requests, in dry-run mode it is considered to be
+// running whenever the endpoint is set
+func (s *serverImpl) Running() bool {
+	if s.dryRun {
+		return s.Endpoint != ""
+	}
+	return s.running()
+}
+
+func (s *serverImpl) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
+func (s *serverImpl) HandlePublic(pattern string, handler http.Handler) {
+	s.lock.Lock()
+	s.public[pattern] = true
+	s.lock.Unlock()
+	s.mux.Handle(pattern, handler)
+}
+
+func (s *serverImpl) SetToken(token string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.token = token
+}
+
+func (s *serverImpl) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, pattern := s.mux.Handler(r)
+		s.lock.Lock()
+		token, public := s.token, s.public[pattern]
+		s.lock.Unlock()
+
+		switch {
+		case public:
+		case token == "":
+			WriteError(w, http.StatusForbidden,
+				errors.New("no API token set: use the admin socket to access the gateway"))
+			return
+		case !authenticate(r, token):
+			w.Header().Set("WWW-Authenticate", `Bearer realm="stunner"`)
+			WriteError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+			return
+		}
+		s.mux.ServeHTTP(w, r)
+	})
+}
+
+// authenticate checks the bearer token of a request
+func authenticate(r *http.Request, token string) bool {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(token)) == 1
+}
+
+func (s *serverImpl) Stop() {
+	s.lock.Lock()
+	server := s.httpServer
+	s.httpServer = nil
+	cancel := s.cancel
+	s.cancel = nil
+	s.lock.Unlock()
+
+	if server == nil {
+		return
+	}
+
+	// long-running requests would block the shutdown
+	cancel()
+
+	s.log.Tracef("stopping admin API server at %s", s.Endpoint)
+	if err := server.Shutdown(context.Background()); err != nil {
+		s.log.Warnf("error stopping admin API server: %s", err.Error())
+	}
+}
+
+// ReconcileSocket (re)starts serving the API on a local Unix socket at the given path. No bearer
+// token is required on the socket: access is controlled by the permissions of the socket file,
+// which is accessible to the owner of the process only
+func (s *serverImpl) ReconcileSocket(path string) error {
+	s.log.Tracef("ReconcileSocket: %s", path)
+
+	s.lock.Lock()
+	running := s.unixServer != nil
+	s.lock.Unlock()
+	if s.dryRun || (s.SocketPath == path && running) {
+		s.SocketPath = path
+		return nil
+	}
+
+	s.stopSocket()
+	s.SocketPath = path
+	if path == "" {
+		return nil
+	}
+
+	// remove the socket left behind by a previous run, but nothing else
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return fmt.Errorf("cannot create admin socket at %s: file exists", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return fmt.Errorf("cannot remove stale admin socket at %s: %w", path, err)
+		}
+	}
+
+	// the socket file is created with the permissions set by the umask
+	mask := util.Umask(0o177)
+	l, err := net.Listen("unix", path)
+	util.Umask(mask)
+	if err != nil {
+		return fmt.Errorf("cannot create admin socket at %s: %w", path, err)
+	}
+
+	server := &http.Server{Handler: s.mux}
+	s.lock.Lock()
+	s.unixServer = server
+	s.lock.Unlock()
+
+	s.log.Infof("serving admin API on local socket %s", path)
+	go func() {
+		if err := server.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.log.Warnf("admin socket server: %s", err.Error())
+		}
+	}()
+
+	return nil
+}
+
+// stopSocket stops serving the API on the local Unix socket and removes the socket file
+func (s *serverImpl) stopSocket() {
+	s.lock.Lock()
+	server := s.unixServer
+	s.unixServer = nil
+	s.lock.Unlock()
+
+	if server == nil {
+		return
+	}
+
+	s.log.Tracef("stopping admin socket server at %s", s.SocketPath)
+	// long-running requests, e.g., event streams, are not waited for
+	if err := server.Close(); err != nil {
+		s.log.Warnf("error stopping admin socket server: %s", err.Error())
+	}
+	// the listener removes the socket file on close
+}
+
+func (s *serverImpl) GetEndpoint() string {
+	if s == nil {
+		return ""
+	}
+	return s.Endpoint
+}
+
+// WriteJSON is a helper to write a JSON response
+func WriteJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(v)
+}
+
+// WriteError is a helper to write a JSON error response
+func WriteError(w http.ResponseWriter, code int, err error) {
+	WriteJSON(w, code, map[string]string{"error": err.Error()})
+}
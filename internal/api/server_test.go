@@ -0,0 +1,65 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pion/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHandlerAuth(t *testing.T) {
+	s := NewServer("", true, logging.NewDefaultLoggerFactory())
+	ok := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) { w.WriteHeader(http.StatusOK) })
+	s.HandlePublic("/api/v1/status", ok)
+	s.Handle("/api/v1/allocations", ok)
+	s.Handle("/api/v1/loglevel", ok)
+
+	call := func(method, path, token string) int {
+		req := httptest.NewRequest(method, path, nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		s.Handler().ServeHTTP(w, req)
+		return w.Code
+	}
+
+	for _, tc := range []struct {
+		name, method, path, token string
+		noToken, withToken        int
+	}{
+		{"status", http.MethodGet, "/api/v1/status", "", http.StatusOK, http.StatusOK},
+		{"status with token", http.MethodGet, "/api/v1/status", "secret", http.StatusOK, http.StatusOK},
+		{"get", http.MethodGet, "/api/v1/allocations", "", http.StatusForbidden,
+			http.StatusUnauthorized},
+		{"get with token", http.MethodGet, "/api/v1/allocations", "secret", http.StatusForbidden,
+			http.StatusOK},
+		{"get with wrong token", http.MethodGet, "/api/v1/allocations", "wrong",
+			http.StatusForbidden, http.StatusUnauthorized},
+		{"head", http.MethodHead, "/api/v1/allocations", "", http.StatusForbidden,
+			http.StatusUnauthorized},
+		{"put", http.MethodPut, "/api/v1/loglevel", "", http.StatusForbidden,
+			http.StatusUnauthorized},
+		{"put with token", http.MethodPut, "/api/v1/loglevel", "secret", http.StatusForbidden,
+			http.StatusOK},
+		{"unknown path", http.MethodGet, "/api/v1/dummy", "", http.StatusForbidden,
+			http.StatusUnauthorized},
+		{"unknown path with token", http.MethodGet, "/api/v1/dummy", "secret",
+			http.StatusForbidden, http.StatusNotFound},
+	} {
+		s.SetToken("")
+		assert.Equal(t, tc.noToken, call(tc.method, tc.path, tc.token), "%s: no token set", tc.name)
+		s.SetToken("secret")
+		assert.Equal(t, tc.withToken, call(tc.method, tc.path, tc.token), "%s: token set", tc.name)
+	}
+
+	// the challenge names the scheme
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/allocations", nil)
+	req.Header.Set("Authorization", "Basic c2VjcmV0")
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, req)
+	assert.Equal(t, http.StatusUnauthorized, w.Code, "basic auth")
+	assert.Equal(t, `Bearer realm="stunner"`, w.Header().Get("WWW-Authenticate"), "challenge")
+}
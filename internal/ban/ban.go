@@ -0,0 +1,296 @@
+// Package ban temporarily bans the client sources that behave abusively, fail2ban-style: a source
+// that exceeds the threshold of auth failures, malformed packets or permission denials within a
+// window is dropped at the socket layer of the listeners for the ban period.
+package ban
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/logging"
+
+	"github.com/l7mp/stunner/internal/monitoring"
+	"github.com/l7mp/stunner/internal/util"
+	"github.com/l7mp/stunner/pkg/clock"
+)
+
+// Reasons a source is banned for
+const (
+	// ReasonAuthFailure is a failed authentication
+	ReasonAuthFailure = "auth-failure"
+	// ReasonMalformedPacket is a packet received on a UDP listener that is neither a STUN
+	// message nor a TURN ChannelData message
+	ReasonMalformedPacket = "malformed-packet"
+	// ReasonPermissionDenied is a permission request to a peer not reachable via the listener
+	ReasonPermissionDenied = "permission-denied"
+)
+
+// maxOffenders bounds the number of sources whose offenses are counted
+const maxOffenders = 1 << 16
+
+// Config sets the thresholds of the offenses a source is banned for
+type Config struct {
+	// Window is the period the offenses are counted in
+	Window time.Duration
+	// Duration is the ban period
+	Duration time.Duration
+	// Thresholds is the number of offenses per reason within the window a source is banned
+	// for, reasons with no threshold do not ban sources
+	Thresholds map[string]int
+	// Exempt lists the prefixes of the sources never banned
+	Exempt []*net.IPNet
+}
+
+// Ban is a banned source
+type Ban struct {
+	// Source is the IP address of the banned client
+	Source string `json:"source"`
+	// Reason is the offense the source was banned for
+	Reason string `json:"reason"`
+	// Listener is the listener the last offense was committed on
+	Listener string `json:"listener,omitempty"`
+	// Count is the number of offenses within the window
+	Count int `json:"count"`
+	// Since is the start of the ban
+	Since time.Time `json:"since"`
+	// Until is the end of the ban
+	Until time.Time `json:"until"`
+}
+
+// offender counts the offenses of a source in a fixed window
+type offender struct {
+	start  time.Time
+	counts map[string]int
+}
+
+// Table holds the offenses and the bans of the client sources
+type Table struct {
+	lock      sync.RWMutex
+	conf      *Config
+	offenders map[string]*offender
+	bans      map[string]*Ban
+	banned    int32 // atomic, the number of bans, so that the sockets skip the lookup if zero
+	log       logging.LeveledLogger
+	clock     clock.Clock
+}
+
+// NewTable creates an empty ban table, disabled until a config is set
+func NewTable(logger logging.LoggerFactory) *Table {
+	return &Table{
+		offenders: map[string]*offender{},
+		bans:      map[string]*Ban{},
+		clock:     clock.Real,
+		log:       logger.NewLogger("ban"),
+	}
+}
+
+// SetClock sets the clock the offense windows and the bans are timed with. Must be called before
+// the table is used
+func (t *Table) SetClock(c clock.Clock) {
+	t.clock = c
+}
+
+// SetConfig sets the thresholds of the table, nil disables banning and lifts all bans
+func (t *Table) SetConfig(conf *Config) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.conf = conf
+	t.offenders = map[string]*offender{}
+	if conf == nil {
+		t.bans = map[string]*Ban{}
+		t.updateBanned()
+	}
+}
+
+// Report counts an offense of a client, given by its transport address, and bans the source of the
+// client if the threshold of the offense is exceeded within the window
+func (t *Table) Report(listener, client, reason string) {
+	host, _, err := net.SplitHostPort(client)
+	if err != nil {
+		return
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.conf == nil {
+		return
+	}
+	threshold := t.conf.Thresholds[reason]
+	if threshold <= 0 {
+		return
+	}
+	for _, n := range t.conf.Exempt {
+		if n.Contains(ip) {
+			return
+		}
+	}
+
+	source := ip.String()
+	if _, banned := t.bans[source]; banned {
+		return
+	}
+
+	now := t.clock.Now()
+	o, ok := t.offenders[source]
+	if !ok || now.Sub(o.start) > t.conf.Window {
+		if !ok && len(t.offenders) >= maxOffenders {
+			t.log.Debugf("too many offenders, not counting %s offense of %s", reason, source)
+			return
+		}
+		o = &offender{start: now, counts: map[string]int{}}
+		t.offenders[source] = o
+	}
+	o.counts[reason]++
+	if o.counts[reason] < threshold {
+		return
+	}
+
+	b := &Ban{Source: source, Reason: reason, Listener: listener, Count: o.counts[reason],
+		Since: now, Until: now.Add(t.conf.Duration)}
+	t.bans[source] = b
+	delete(t.offenders, source)
+	t.updateBanned()
+
+	monitoring.BanCounter.WithLabelValues(reason).Inc()
+	t.log.Warnf("banning source %s until %s: %d %s offenses in %s on listener %s", source,
+		b.Until.Format(time.RFC3339), b.Count, reason, t.conf.Window, listener)
+}
+
+// Banned returns true if the source of a client is banned
+func (t *Table) Banned(client net.Addr) bool {
+	if atomic.LoadInt32(&t.banned) == 0 {
+		return false
+	}
+	ip := util.AddrIP(client)
+	if ip == nil {
+		return false
+	}
+
+	t.lock.RLock()
+	b, ok := t.bans[ip.String()]
+	t.lock.RUnlock()
+	return ok && t.clock.Now().Before(b.Until)
+}
+
+// Bans returns the active bans, sorted by source
+func (t *Table) Bans() []Ban {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	now := t.clock.Now()
+	ret := []Ban{}
+	for _, b := range t.bans {
+		if now.Before(b.Until) {
+			ret = append(ret, *b)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Source < ret[j].Source })
+	return ret
+}
+
+// Clear lifts the ban of a source, or all bans if the source is empty, and returns the number of
+// bans lifted
+func (t *Table) Clear(source string) int {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	n := 0
+	if source == "" {
+		n = len(t.bans)
+		t.bans = map[string]*Ban{}
+	} else if ip := net.ParseIP(source); ip != nil {
+		if _, ok := t.bans[ip.String()]; ok {
+			delete(t.bans, ip.String())
+			n = 1
+		}
+	}
+	t.updateBanned()
+
+	if n > 0 {
+		t.log.Infof("lifted %d ban(s)", n)
+	}
+	return n
+}
+
+// Expire lifts the expired bans and forgets the offenses counted in past windows
+func (t *Table) Expire(now time.Time) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	for source, b := range t.bans {
+		if !now.Before(b.Until) {
+			t.log.Infof("ban of source %s expired", source)
+			delete(t.bans, source)
+		}
+	}
+	t.updateBanned()
+
+	if t.conf == nil {
+		return
+	}
+	for source, o := range t.offenders {
+		if now.Sub(o.start) > t.conf.Window {
+			delete(t.offenders, source)
+		}
+	}
+}
+
+// updateBanned updates the number of bans, must be called with the lock held
+func (t *Table) updateBanned() {
+	atomic.StoreInt32(&t.banned, int32(len(t.bans)))
+	monitoring.BannedSourcesGauge.Set(float64(len(t.bans)))
+}
+
+// NewPacketConn wraps the socket of a packet listener so that the packets of banned sources are
+// dropped before any STUN/TURN processing
+func (t *Table) NewPacketConn(conn net.PacketConn, listener string) net.PacketConn {
+	return &packetConn{PacketConn: conn, table: t, listener: listener}
+}
+
+type packetConn struct {
+	net.PacketConn
+	table    *Table
+	listener string
+}
+
+func (c *packetConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		n, addr, err := c.PacketConn.ReadFrom(b)
+		if err != nil || !c.table.Banned(addr) {
+			return n, addr, err
+		}
+		monitoring.BanDropCounter.WithLabelValues(c.listener).Inc()
+	}
+}
+
+// NewListener wraps the socket of a stream listener so that the connections of banned sources are
+// closed before any STUN/TURN processing
+func (t *Table) NewListener(l net.Listener, listener string) net.Listener {
+	return &streamListener{Listener: l, table: t, listener: listener}
+}
+
+type streamListener struct {
+	net.Listener
+	table    *Table
+	listener string
+}
+
+func (l *streamListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil || !l.table.Banned(conn.RemoteAddr()) {
+			return conn, err
+		}
+		monitoring.BanDropCounter.WithLabelValues(l.listener).Inc()
+		conn.Close()
+	}
+}
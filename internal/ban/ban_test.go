@@ -0,0 +1,159 @@
+package ban
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pion/logging"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/l7mp/stunner/pkg/clock"
+)
+
+func newTestTable(exempt ...string) *Table {
+	t := NewTable(logging.NewDefaultLoggerFactory())
+	conf := &Config{
+		Window:   time.Minute,
+		Duration: time.Hour,
+		Thresholds: map[string]int{
+			ReasonAuthFailure:      3,
+			ReasonPermissionDenied: -1,
+		},
+	}
+	for _, e := range exempt {
+		_, n, _ := net.ParseCIDR(e)
+		conf.Exempt = append(conf.Exempt, n)
+	}
+	t.SetConfig(conf)
+	return t
+}
+
+func TestBanThreshold(t *testing.T) {
+	table := newTestTable()
+	client := &net.UDPAddr{IP: net.ParseIP("1.2.3.4"), Port: 1234}
+
+	for i := 0; i < 2; i++ {
+		table.Report("udp", "1.2.3.4:1234", ReasonAuthFailure)
+	}
+	assert.False(t, table.Banned(client), "below threshold")
+
+	// other ports of the same source count too
+	table.Report("udp", "1.2.3.4:5678", ReasonAuthFailure)
+	assert.True(t, table.Banned(client), "banned")
+	assert.True(t, table.Banned(&net.TCPAddr{IP: net.ParseIP("1.2.3.4"), Port: 1}), "source banned")
+	assert.False(t, table.Banned(&net.UDPAddr{IP: net.ParseIP("1.2.3.5"), Port: 1234}), "other source")
+
+	bans := table.Bans()
+	assert.Len(t, bans, 1, "bans")
+	assert.Equal(t, "1.2.3.4", bans[0].Source, "source")
+	assert.Equal(t, ReasonAuthFailure, bans[0].Reason, "reason")
+	assert.Equal(t, "udp", bans[0].Listener, "listener")
+	assert.Equal(t, 3, bans[0].Count, "count")
+	assert.Equal(t, time.Hour, bans[0].Until.Sub(bans[0].Since), "duration")
+
+	// disabled and unknown reasons never ban
+	for i := 0; i < 10; i++ {
+		table.Report("udp", "1.2.3.5:1234", ReasonPermissionDenied)
+		table.Report("udp", "1.2.3.5:1234", ReasonMalformedPacket)
+	}
+	assert.Len(t, table.Bans(), 1, "no new bans")
+}
+
+func TestBanExempt(t *testing.T) {
+	table := newTestTable("10.0.0.0/8")
+	for i := 0; i < 10; i++ {
+		table.Report("udp", "10.1.2.3:1234", ReasonAuthFailure)
+	}
+	assert.Empty(t, table.Bans(), "exempt")
+}
+
+func TestBanClock(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	table := newTestTable()
+	table.SetClock(clk)
+	client := &net.UDPAddr{IP: net.ParseIP("1.2.3.4"), Port: 1}
+
+	// offenses in separate windows do not add up
+	table.Report("udp", client.String(), ReasonAuthFailure)
+	table.Report("udp", client.String(), ReasonAuthFailure)
+	clk.Advance(2 * time.Minute)
+	table.Report("udp", client.String(), ReasonAuthFailure)
+	assert.False(t, table.Banned(client), "window expired")
+
+	table.Report("udp", client.String(), ReasonAuthFailure)
+	table.Report("udp", client.String(), ReasonAuthFailure)
+	assert.True(t, table.Banned(client), "banned")
+	bans := table.Bans()
+	assert.Len(t, bans, 1, "bans")
+	assert.Equal(t, clk.Now(), bans[0].Since, "since")
+
+	// the ban is lifted once the duration is over, even before the expired bans are swept
+	clk.Advance(time.Hour)
+	assert.False(t, table.Banned(client), "ban over")
+	assert.Empty(t, table.Bans(), "no active bans")
+	table.Expire(clk.Now())
+	assert.Equal(t, 0, table.Clear(""), "swept")
+}
+
+func TestBanClearAndExpire(t *testing.T) {
+	table := newTestTable()
+	for _, src := range []string{"1.2.3.4:1", "1.2.3.5:1", "[2001:db8::1]:1"} {
+		for i := 0; i < 3; i++ {
+			table.Report("udp", src, ReasonAuthFailure)
+		}
+	}
+	assert.Len(t, table.Bans(), 3, "bans")
+
+	assert.Equal(t, 1, table.Clear("2001:db8::1"), "clear one")
+	assert.Equal(t, 0, table.Clear("2001:db8::1"), "clear again")
+	assert.Len(t, table.Bans(), 2, "bans")
+
+	table.Expire(time.Now().Add(2 * time.Hour))
+	assert.Empty(t, table.Bans(), "expired")
+	assert.False(t, table.Banned(&net.UDPAddr{IP: net.ParseIP("1.2.3.4"), Port: 1}), "lifted")
+
+	for i := 0; i < 3; i++ {
+		table.Report("udp", "1.2.3.4:1", ReasonAuthFailure)
+	}
+	assert.Equal(t, 1, table.Clear(""), "clear all")
+
+	// disabling lifts all bans
+	for i := 0; i < 3; i++ {
+		table.Report("udp", "1.2.3.4:1", ReasonAuthFailure)
+	}
+	table.SetConfig(nil)
+	assert.Empty(t, table.Bans(), "disabled")
+}
+
+func TestBanPacketConn(t *testing.T) {
+	table := newTestTable()
+
+	server, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	assert.NoError(t, err, "server")
+	conn := table.NewPacketConn(server, "udp")
+	defer conn.Close()
+
+	banned, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	assert.NoError(t, err, "client")
+	defer banned.Close()
+	for i := 0; i < 3; i++ {
+		table.Report("udp", banned.LocalAddr().String(), ReasonAuthFailure)
+	}
+
+	// the source is banned: the packet is dropped until the ban is lifted
+	_, err = banned.WriteTo([]byte("dropped"), conn.LocalAddr())
+	assert.NoError(t, err, "write")
+	conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond)) //nolint:errcheck
+	_, _, err = conn.ReadFrom(make([]byte, 100))
+	assert.Error(t, err, "dropped")
+
+	table.Clear("")
+	_, err = banned.WriteTo([]byte("passed"), conn.LocalAddr())
+	assert.NoError(t, err, "write")
+	conn.SetReadDeadline(time.Now().Add(time.Second)) //nolint:errcheck
+	buf := make([]byte, 100)
+	n, _, err := conn.ReadFrom(buf)
+	assert.NoError(t, err, "read")
+	assert.Equal(t, "passed", string(buf[:n]), "passed")
+}
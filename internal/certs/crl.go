@@ -0,0 +1,208 @@
+package certs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/logging"
+
+	"github.com/l7mp/stunner/internal/monitoring"
+)
+
+// LoadCertPool loads a PEM bundle of CA certificates, e.g., the client CAs of a listener
+func LoadCertPool(file string) (*x509.CertPool, []*x509.Certificate, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	pool := x509.NewCertPool()
+	cas := []*x509.Certificate{}
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		ca, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, nil, fmt.Errorf("cannot parse CA certificate: %w", err)
+		}
+		pool.AddCert(ca)
+		cas = append(cas, ca)
+	}
+	if len(cas) == 0 {
+		return nil, nil, fmt.Errorf("no CA certificate in %s", file)
+	}
+	return pool, cas, nil
+}
+
+// CRL holds the certificate revocation lists issued by the client CAs of a listener. The CRL file
+// is polled and reloaded on changes, like the certificate files of a Store.
+type CRL struct {
+	name, file string
+	cas        []*x509.Certificate
+	revoked    atomic.Value // map[string]bool, keyed by the issuer and the serial number
+	hash       [sha256.Size]byte
+	done       chan struct{}
+	closeOnce  sync.Once
+	log        logging.LeveledLogger
+}
+
+// NewCRL loads the revocation lists from the given file, which must be signed by one of the CAs,
+// and starts watching the file for changes, checking every interval (zero means
+// DefaultReloadInterval, negative disables reloading)
+func NewCRL(name, file string, cas []*x509.Certificate, interval time.Duration, logger logging.LoggerFactory) (*CRL, error) {
+	c := &CRL{
+		name: name,
+		file: file,
+		cas:  cas,
+		done: make(chan struct{}),
+		log:  logger.NewLogger("certs"),
+	}
+
+	if err := c.reload(); err != nil {
+		return nil, err
+	}
+
+	if interval == 0 {
+		interval = DefaultReloadInterval
+	}
+	if interval > 0 {
+		go c.watch(interval)
+	}
+
+	return c, nil
+}
+
+// Revoked returns true if a certificate is revoked by the CRL
+func (c *CRL) Revoked(cert *x509.Certificate) bool {
+	revoked := c.revoked.Load().(map[string]bool)
+	return revoked[revocationKey(cert.RawIssuer, cert.SerialNumber)]
+}
+
+// VerifyPeerCertificate refuses the peers whose certificate, or an intermediate CA certificate in
+// the verified chain, is revoked, for use as tls.Config.VerifyPeerCertificate
+func (c *CRL) VerifyPeerCertificate(_ [][]byte, verifiedChains [][]*x509.Certificate) error {
+	for _, chain := range verifiedChains {
+		// the root is trusted
+		for i := 0; i < len(chain)-1; i++ {
+			if c.Revoked(chain[i]) {
+				monitoring.ClientCertRevokedCounter.WithLabelValues(c.name).Inc()
+				c.log.Infof("refusing revoked client certificate on listener %q: subject %q, "+
+					"serial %s", c.name, chain[i].Subject.String(), chain[i].SerialNumber.String())
+				return errors.New("client certificate revoked")
+			}
+		}
+	}
+	return nil
+}
+
+// Close stops watching the CRL file
+func (c *CRL) Close() {
+	c.closeOnce.Do(func() { close(c.done) })
+}
+
+func (c *CRL) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.reload(); err != nil {
+				// keep the old CRL: the file may be mid-update
+				c.log.Warnf("cannot reload CRL for listener %q: %s", c.name, err.Error())
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// reload reads the CRL file and swaps in the new revocation lists if the file changed, must not be
+// called concurrently
+func (c *CRL) reload() error {
+	data, err := os.ReadFile(c.file)
+	if err != nil {
+		return err
+	}
+
+	hash := sha256.Sum256(data)
+	if c.revoked.Load() != nil && hash == c.hash {
+		return nil
+	}
+
+	crls, err := parseCRLs(data)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	revoked := map[string]bool{}
+	for _, crl := range crls {
+		var issuer *x509.Certificate
+		for _, ca := range c.cas {
+			if ca.CheckCRLSignature(crl) == nil {
+				issuer = ca
+				break
+			}
+		}
+		if issuer == nil {
+			return fmt.Errorf("CRL of %q is not signed by a client CA",
+				crl.TBSCertList.Issuer.String())
+		}
+		if crl.HasExpired(now) {
+			c.log.Warnf("CRL of %q for listener %q has expired", issuer.Subject.String(), c.name)
+		}
+		for _, r := range crl.TBSCertList.RevokedCertificates {
+			revoked[revocationKey(issuer.RawSubject, r.SerialNumber)] = true
+		}
+	}
+
+	c.hash = hash
+	c.revoked.Store(revoked)
+	c.log.Infof("loaded %d CRL(s) for listener %q", len(crls), c.name)
+	return nil
+}
+
+// revocationKey returns the key of a certificate in the revocation map: the raw subject of the
+// issuer and the serial number
+func revocationKey(rawIssuer []byte, serial *big.Int) string {
+	return string(rawIssuer) + "/" + serial.String()
+}
+
+// parseCRLs parses the PEM-encoded revocation lists in a file, or a single DER-encoded list
+func parseCRLs(data []byte) ([]*pkix.CertificateList, error) {
+	if !bytes.Contains(data, []byte("-----BEGIN")) {
+		crl, err := x509.ParseDERCRL(data)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse CRL: %w", err)
+		}
+		return []*pkix.CertificateList{crl}, nil
+	}
+
+	crls := []*pkix.CertificateList{}
+	for block, rest := pem.Decode(data); block != nil; block, rest = pem.Decode(rest) {
+		if block.Type != "X509 CRL" {
+			continue
+		}
+		crl, err := x509.ParseDERCRL(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse CRL: %w", err)
+		}
+		crls = append(crls, crl)
+	}
+	if len(crls) == 0 {
+		return nil, errors.New("no CRL in file")
+	}
+	return crls, nil
+}
@@ -0,0 +1,77 @@
+package certs
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pion/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCRL(t *testing.T) {
+	ca, caKey := newCA(t)
+	dir := t.TempDir()
+
+	caFile := filepath.Join(dir, "ca.crt")
+	assert.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE",
+		Bytes: ca.Raw}), 0600), "write CA")
+	_, cas, err := LoadCertPool(caFile)
+	assert.NoError(t, err, "load CA")
+	assert.Len(t, cas, 1, "CAs")
+
+	crlFile := filepath.Join(dir, "ca.crl")
+	writeCRL := func(serials ...int64) {
+		revoked := []pkix.RevokedCertificate{}
+		for _, s := range serials {
+			revoked = append(revoked, pkix.RevokedCertificate{SerialNumber: big.NewInt(s),
+				RevocationTime: time.Now()})
+		}
+		der, err := ca.CreateCRL(rand.Reader, caKey, revoked, time.Now(), time.Now().Add(time.Hour))
+		assert.NoError(t, err, "create CRL")
+		assert.NoError(t, os.WriteFile(crlFile, pem.EncodeToMemory(&pem.Block{Type: "X509 CRL",
+			Bytes: der}), 0600), "write CRL")
+	}
+	writeCRL(2)
+
+	crl, err := NewCRL("test", crlFile, cas, 10*time.Millisecond, logging.NewDefaultLoggerFactory())
+	assert.NoError(t, err, "new CRL")
+	defer crl.Close()
+
+	check := func(serial int64) error {
+		issue(t, dir, ca, caKey, serial, "http://localhost")
+		_, certs, err := LoadCertPool(filepath.Join(dir, "tls.crt"))
+		assert.NoError(t, err, "load cert")
+		return crl.VerifyPeerCertificate(nil, [][]*x509.Certificate{{certs[0], ca}})
+	}
+
+	assert.EqualError(t, check(2), "client certificate revoked", "revoked")
+	assert.NoError(t, check(3), "not revoked")
+
+	// the CRL is reloaded on changes
+	writeCRL(3)
+	assert.Eventually(t, func() bool { return check(3) != nil }, 2*time.Second,
+		10*time.Millisecond, "reloaded CRL")
+	assert.NoError(t, check(2), "no longer revoked")
+
+	// a broken update keeps the old CRL
+	assert.NoError(t, os.WriteFile(crlFile, []byte("garbage"), 0600), "write garbage")
+	time.Sleep(50 * time.Millisecond)
+	assert.Error(t, check(3), "CRL after failed reload")
+
+	// CRLs not signed by a client CA are refused
+	other, _ := newCA(t)
+	_, err = NewCRL("test", crlFile, []*x509.Certificate{other}, -1,
+		logging.NewDefaultLoggerFactory())
+	assert.Error(t, err, "garbage CRL")
+	writeCRL(2)
+	_, err = NewCRL("test", crlFile, []*x509.Certificate{other}, -1,
+		logging.NewDefaultLoggerFactory())
+	assert.ErrorContains(t, err, "not signed by a client CA", "foreign CRL")
+}
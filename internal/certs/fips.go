@@ -0,0 +1,42 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+)
+
+// fipsMinRSABits is the minimum size of the RSA keys approved in FIPS mode
+const fipsMinRSABits = 2048
+
+// CheckFIPS returns an error if a certificate is not approved in FIPS mode: the key must be an RSA
+// key of at least 2048 bits or an ECDSA key on the P-256, P-384 or P-521 curves, and the
+// certificate must not be signed with MD5 or SHA-1
+func CheckFIPS(cert *x509.Certificate) error {
+	switch k := cert.PublicKey.(type) {
+	case *rsa.PublicKey:
+		if k.N.BitLen() < fipsMinRSABits {
+			return fmt.Errorf("%d-bit RSA key not approved in FIPS mode", k.N.BitLen())
+		}
+	case *ecdsa.PublicKey:
+		switch k.Curve {
+		case elliptic.P256(), elliptic.P384(), elliptic.P521():
+		default:
+			return fmt.Errorf("ECDSA key on curve %s not approved in FIPS mode",
+				k.Curve.Params().Name)
+		}
+	default:
+		return fmt.Errorf("%s key not approved in FIPS mode", cert.PublicKeyAlgorithm.String())
+	}
+
+	switch cert.SignatureAlgorithm {
+	case x509.MD2WithRSA, x509.MD5WithRSA, x509.SHA1WithRSA, x509.DSAWithSHA1,
+		x509.ECDSAWithSHA1:
+		return fmt.Errorf("%s signature not approved in FIPS mode",
+			cert.SignatureAlgorithm.String())
+	}
+
+	return nil
+}
@@ -0,0 +1,54 @@
+package certs
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func selfSigned(t *testing.T, pub crypto.PublicKey, priv crypto.Signer) *x509.Certificate {
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "fips"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, pub, priv)
+	assert.NoError(t, err, "create certificate")
+	cert, err := x509.ParseCertificate(der)
+	assert.NoError(t, err, "parse certificate")
+	return cert
+}
+
+func TestCheckFIPS(t *testing.T) {
+	p256, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err, "generate key")
+	assert.NoError(t, CheckFIPS(selfSigned(t, &p256.PublicKey, p256)), "P-256")
+
+	p224, err := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	assert.NoError(t, err, "generate key")
+	assert.Error(t, CheckFIPS(selfSigned(t, &p224.PublicKey, p224)), "P-224")
+
+	rsa1024, err := rsa.GenerateKey(rand.Reader, 1024)
+	assert.NoError(t, err, "generate key")
+	assert.Error(t, CheckFIPS(selfSigned(t, &rsa1024.PublicKey, rsa1024)), "RSA-1024")
+
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err, "generate key")
+	assert.Error(t, CheckFIPS(selfSigned(t, pub, priv)), "Ed25519")
+
+	// SHA-1 signatures
+	cert := selfSigned(t, &p256.PublicKey, p256)
+	cert.SignatureAlgorithm = x509.ECDSAWithSHA1
+	assert.Error(t, CheckFIPS(cert), "SHA-1 signature")
+}
@@ -0,0 +1,160 @@
+package certs
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+
+	"github.com/l7mp/stunner/internal/monitoring"
+)
+
+const (
+	// DefaultOCSPTimeout is the default timeout of the requests to the OCSP responders
+	DefaultOCSPTimeout = 10 * time.Second
+	// ocspRetryInterval is the period of retrying failed OCSP requests
+	ocspRetryInterval = time.Minute
+	// ocspRefreshInterval is the period of refreshing OCSP responses with no next update time
+	ocspRefreshInterval = time.Hour
+	// maxOCSPResponseSize bounds the size of the OCSP responses
+	maxOCSPResponseSize = 1 << 20
+)
+
+// staple is an OCSP response fetched for a certificate
+type staple struct {
+	cert       *tls.Certificate // the certificate the response was fetched for
+	stapled    *tls.Certificate // a copy of the certificate with the response stapled
+	nextUpdate time.Time
+}
+
+// EnableOCSPStapling makes the store staple an OCSP response, fetched from the OCSP responder of
+// the certificate with the given client, to the certificates returned by GetCertificate. The
+// response is refreshed halfway to its next update time, and after each rotation, which is checked
+// for every interval (zero means DefaultReloadInterval). Certificates are served without a staple
+// until a good response is fetched.
+func (s *Store) EnableOCSPStapling(client *http.Client, interval time.Duration) {
+	if interval <= 0 {
+		interval = DefaultReloadInterval
+	}
+	s.stapleOnce.Do(func() { go s.watchStaple(client, interval) })
+}
+
+// stapled returns the certificate with the OCSP response stapled, or nil if there is no valid
+// response for the certificate
+func (s *Store) stapled(cert *tls.Certificate) *tls.Certificate {
+	st, ok := s.staple.Load().(*staple)
+	if !ok || st.cert != cert {
+		return nil
+	}
+	if !st.nextUpdate.IsZero() && !time.Now().Before(st.nextUpdate) {
+		return nil
+	}
+	return st.stapled
+}
+
+func (s *Store) watchStaple(client *http.Client, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var fetched *tls.Certificate
+	var refresh time.Time
+	for {
+		if cert := s.Certificate(); cert != fetched || !time.Now().Before(refresh) {
+			refresh = s.updateStaple(client, cert)
+			fetched = cert
+		}
+
+		select {
+		case <-ticker.C:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// updateStaple fetches the OCSP response for a certificate and returns the time of the next
+// update. On error the current response is kept while it is valid
+func (s *Store) updateStaple(client *http.Client, cert *tls.Certificate) time.Time {
+	now := time.Now()
+	resp, raw, err := fetchOCSP(client, cert)
+	if err != nil {
+		s.log.Warnf("cannot fetch OCSP response for listener %q: %s", s.name, err.Error())
+		monitoring.OCSPStapleUpdateCounter.WithLabelValues(s.name, "error").Inc()
+		return now.Add(ocspRetryInterval)
+	}
+
+	if resp.Status != ocsp.Good {
+		status := "unknown"
+		if resp.Status == ocsp.Revoked {
+			status = "revoked"
+		}
+		s.log.Errorf("OCSP responder reports the certificate for listener %q as %s", s.name,
+			status)
+		monitoring.OCSPStapleUpdateCounter.WithLabelValues(s.name, status).Inc()
+		monitoring.OCSPStapleNextUpdateGauge.DeleteLabelValues(s.name)
+		s.staple.Store(&staple{})
+		return now.Add(ocspRetryInterval)
+	}
+
+	stapled := *cert
+	stapled.OCSPStaple = raw
+	s.staple.Store(&staple{cert: cert, stapled: &stapled, nextUpdate: resp.NextUpdate})
+	monitoring.OCSPStapleUpdateCounter.WithLabelValues(s.name, "success").Inc()
+
+	if resp.NextUpdate.IsZero() {
+		monitoring.OCSPStapleNextUpdateGauge.DeleteLabelValues(s.name)
+		s.log.Debugf("stapled OCSP response for listener %q", s.name)
+		return now.Add(ocspRefreshInterval)
+	}
+	monitoring.OCSPStapleNextUpdateGauge.WithLabelValues(s.name).Set(
+		float64(resp.NextUpdate.Unix()))
+	s.log.Debugf("stapled OCSP response for listener %q, next update %s", s.name,
+		resp.NextUpdate.Format(time.RFC3339))
+	return resp.ThisUpdate.Add(resp.NextUpdate.Sub(resp.ThisUpdate) / 2)
+}
+
+// fetchOCSP queries the OCSP responder of a certificate and returns the parsed and the raw
+// response. The issuer is taken from the chain of the certificate
+func fetchOCSP(client *http.Client, cert *tls.Certificate) (*ocsp.Response, []byte, error) {
+	leaf := cert.Leaf
+	if len(leaf.OCSPServer) == 0 {
+		return nil, nil, errors.New("no OCSP responder in certificate")
+	}
+	if len(cert.Certificate) < 2 {
+		return nil, nil, errors.New("no issuer certificate after the leaf in the certificate file")
+	}
+	issuer, err := x509.ParseCertificate(cert.Certificate[1])
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot parse issuer certificate: %w", err)
+	}
+
+	req, err := ocsp.CreateRequest(leaf, issuer, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot create OCSP request: %w", err)
+	}
+	res, err := client.Post(leaf.OCSPServer[0], "application/ocsp-request", bytes.NewReader(req))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, nil, fmt.Errorf("OCSP responder %s: HTTP status %s", leaf.OCSPServer[0],
+			res.Status)
+	}
+	raw, err := io.ReadAll(io.LimitReader(res.Body, maxOCSPResponseSize))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	resp, err := ocsp.ParseResponseForCert(raw, leaf, issuer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid OCSP response: %w", err)
+	}
+	return resp, raw, nil
+}
@@ -0,0 +1,179 @@
+package certs
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pion/logging"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ocsp"
+)
+
+func newCA(t *testing.T) (*x509.Certificate, crypto.Signer) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err, "generate key")
+
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	assert.NoError(t, err, "create CA certificate")
+	ca, err := x509.ParseCertificate(der)
+	assert.NoError(t, err, "parse CA certificate")
+	return ca, key
+}
+
+// issue writes a certificate signed by the CA, followed by the CA certificate, and the key
+func issue(t *testing.T, dir string, ca *x509.Certificate, caKey crypto.Signer, serial int64, ocspURL string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err, "generate key")
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		OCSPServer:   []string{ocspURL},
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, ca, &key.PublicKey, caKey)
+	assert.NoError(t, err, "create certificate")
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err, "marshal key")
+
+	certPEM := append(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Raw})...)
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "tls.crt"), certPEM, 0600), "write cert")
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "tls.key"), keyPEM, 0600), "write key")
+}
+
+// responder is an OCSP responder that reports the serials in revoked as revoked
+type responder struct {
+	ca      *x509.Certificate
+	key     crypto.Signer
+	lock    sync.Mutex
+	revoked map[int64]bool
+}
+
+func (r *responder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	ocspReq, err := ocsp.ParseRequest(body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	r.lock.Lock()
+	status := ocsp.Good
+	if r.revoked[ocspReq.SerialNumber.Int64()] {
+		status = ocsp.Revoked
+	}
+	r.lock.Unlock()
+
+	now := time.Now().Truncate(time.Second)
+	res, err := ocsp.CreateResponse(r.ca, r.ca, ocsp.Response{
+		Status:       status,
+		SerialNumber: ocspReq.SerialNumber,
+		ThisUpdate:   now,
+		NextUpdate:   now.Add(time.Hour),
+		RevokedAt:    now,
+	}, r.key)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/ocsp-response")
+	w.Write(res) //nolint:errcheck
+}
+
+func TestStoreOCSPStapling(t *testing.T) {
+	ca, caKey := newCA(t)
+	r := &responder{ca: ca, key: caKey, revoked: map[int64]bool{}}
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	dir := t.TempDir()
+	issue(t, dir, ca, caKey, 2, srv.URL)
+
+	s, err := NewStore("test", filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"),
+		10*time.Millisecond, nil, logging.NewDefaultLoggerFactory())
+	assert.NoError(t, err, "new store")
+	defer s.Close()
+
+	c, err := s.GetCertificate(nil)
+	assert.NoError(t, err, "get certificate")
+	assert.Nil(t, c.OCSPStaple, "no staple before enabling stapling")
+
+	s.EnableOCSPStapling(srv.Client(), 10*time.Millisecond)
+
+	stapled := func() *ocsp.Response {
+		c, err := s.GetCertificate(nil)
+		assert.NoError(t, err, "get certificate")
+		if c.OCSPStaple == nil {
+			return nil
+		}
+		res, err := ocsp.ParseResponseForCert(c.OCSPStaple, c.Leaf, ca)
+		assert.NoError(t, err, "parse staple")
+		return res
+	}
+
+	assert.Eventually(t, func() bool { return stapled() != nil }, 2*time.Second,
+		10*time.Millisecond, "staple")
+	res := stapled()
+	assert.Equal(t, ocsp.Good, res.Status, "good status")
+	assert.Equal(t, int64(2), res.SerialNumber.Int64(), "serial")
+
+	// the staple is refreshed for a rotated certificate
+	issue(t, dir, ca, caKey, 3, srv.URL)
+	assert.Eventually(t, func() bool {
+		res := stapled()
+		return res != nil && res.SerialNumber.Int64() == 3
+	}, 2*time.Second, 10*time.Millisecond, "staple for rotated certificate")
+
+	// revoked certificates are served without a staple
+	r.lock.Lock()
+	r.revoked[4] = true
+	r.lock.Unlock()
+	issue(t, dir, ca, caKey, 4, srv.URL)
+	assert.Eventually(t, func() bool {
+		return s.Certificate().Leaf.SerialNumber.Int64() == 4 && stapled() == nil
+	}, 2*time.Second, 10*time.Millisecond, "no staple for revoked certificate")
+}
+
+func TestFetchOCSPNoIssuer(t *testing.T) {
+	dir := t.TempDir()
+	writeCert(t, dir, "self-signed")
+
+	s, err := NewStore("test", filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), -1,
+		nil, logging.NewDefaultLoggerFactory())
+	assert.NoError(t, err, "new store")
+	defer s.Close()
+
+	_, _, err = fetchOCSP(http.DefaultClient, s.Certificate())
+	assert.EqualError(t, err, "no OCSP responder in certificate", "no responder")
+}
@@ -0,0 +1,248 @@
+package certs
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pion/logging"
+	"google.golang.org/grpc"
+
+	"github.com/l7mp/stunner/pkg/sds"
+)
+
+// DefaultSecretTimeout is the time the first version of a secret is waited for
+const DefaultSecretTimeout = 10 * time.Second
+
+const (
+	// sdsMinBackoff and sdsMaxBackoff bound the delay between reconnection attempts to the
+	// secret discovery server
+	sdsMinBackoff = 1 * time.Second
+	sdsMaxBackoff = 30 * time.Second
+)
+
+// SecretClient subscribes the certificate stores to their secrets at a secret discovery service
+// (SDS), see the sds package. New versions of the secrets are loaded into the stores and ACKed,
+// versions failing to load into a store are NACKed with the reason. The stream is reestablished
+// with exponential backoff if it fails, the stores keep serving their certificates meanwhile.
+type SecretClient struct {
+	addr, node string
+	timeout    time.Duration
+	lock       sync.Mutex             // protects the maps, held while loading the secrets
+	stores     map[string][]*Store    // the subscribed stores by secret name
+	secrets    map[string]*sds.Secret // the last version of the secrets
+	kick       chan struct{}          // signals that the secret names changed
+	cancel     context.CancelFunc
+	log        logging.LeveledLogger
+}
+
+// NewSecretClient connects to the SDS server at addr, e.g., "127.0.0.1:9091" or
+// "unix:///var/run/stunner/sds.sock", the node name identifies the daemon to the server. The
+// connection is unencrypted: the server must be reached over a unix socket or the loopback.
+func NewSecretClient(addr, node string, logger logging.LoggerFactory) *SecretClient {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &SecretClient{
+		addr:    addr,
+		node:    node,
+		timeout: DefaultSecretTimeout,
+		stores:  map[string][]*Store{},
+		secrets: map[string]*sds.Secret{},
+		kick:    make(chan struct{}, 1),
+		cancel:  cancel,
+		log:     logger.NewLogger("certs"),
+	}
+	go c.run(ctx)
+	return c
+}
+
+// Addr returns the address of the SDS server
+func (c *SecretClient) Addr() string {
+	return c.addr
+}
+
+// Close closes the stream to the SDS server, the stores keep serving their certificates
+func (c *SecretClient) Close() {
+	c.cancel()
+}
+
+// subscribe subscribes a store to a secret, the last version of the secret is loaded right away
+func (c *SecretClient) subscribe(name string, s *Store) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	_, known := c.stores[name]
+	c.stores[name] = append(c.stores[name], s)
+	if !known {
+		c.notify()
+	}
+	if secret, ok := c.secrets[name]; ok {
+		_ = s.update(secret)
+	}
+}
+
+// unsubscribe unsubscribes a store from a secret
+func (c *SecretClient) unsubscribe(name string, s *Store) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	stores := []*Store{}
+	for _, store := range c.stores[name] {
+		if store != s {
+			stores = append(stores, store)
+		}
+	}
+	if len(stores) > 0 {
+		c.stores[name] = stores
+		return
+	}
+	delete(c.stores, name)
+	delete(c.secrets, name)
+	c.notify()
+}
+
+// notify signals the stream that the secret names changed, must be called with the lock held
+func (c *SecretClient) notify() {
+	select {
+	case c.kick <- struct{}{}:
+	default:
+	}
+}
+
+// names returns the names of the subscribed secrets
+func (c *SecretClient) names() []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	names := make([]string, 0, len(c.stores))
+	for name := range c.stores {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// apply loads the secrets of a response into the subscribed stores, returns the first error
+func (c *SecretClient) apply(resp *sds.DiscoveryResponse) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	var errFirst error
+	for i := range resp.Secrets {
+		secret := &resp.Secrets[i]
+		stores, ok := c.stores[secret.Name]
+		if !ok {
+			continue
+		}
+		c.secrets[secret.Name] = secret
+		for _, s := range stores {
+			if err := s.update(secret); err != nil && errFirst == nil {
+				errFirst = fmt.Errorf("secret %q: %w", secret.Name, err)
+			}
+		}
+	}
+	return errFirst
+}
+
+func (c *SecretClient) run(ctx context.Context) {
+	version := ""
+	backoff := sdsMinBackoff
+	for {
+		err := c.stream(ctx, &version)
+		if ctx.Err() != nil {
+			return
+		}
+		c.log.Warnf("secret stream to SDS server %q failed, retrying in %s: %s", c.addr,
+			backoff, err.Error())
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > sdsMaxBackoff {
+			backoff = sdsMaxBackoff
+		}
+	}
+}
+
+// stream runs a secret stream until it fails, the version is the last accepted version
+func (c *SecretClient) stream(ctx context.Context, version *string) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	conn, err := grpc.DialContext(ctx, c.addr, grpc.WithInsecure())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	client, err := sds.StreamSecrets(ctx, conn)
+	if err != nil {
+		return err
+	}
+	defer client.CloseSend() //nolint:errcheck
+
+	resps, errs := make(chan *sds.DiscoveryResponse), make(chan error, 1)
+	go func() {
+		for {
+			resp, err := client.Recv()
+			if err != nil {
+				errs <- err
+				return
+			}
+			select {
+			case resps <- resp:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	nonce, names := "", []string{}
+	send := func(errorDetail string) error {
+		names = c.names()
+		return client.Send(&sds.DiscoveryRequest{Node: c.node, ResourceNames: names,
+			VersionInfo: *version, ResponseNonce: nonce, ErrorDetail: errorDetail})
+	}
+
+	if err := send(""); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-c.kick:
+			if reflect.DeepEqual(names, c.names()) {
+				continue
+			}
+			if err := send(""); err != nil {
+				return err
+			}
+		case resp := <-resps:
+			c.log.Tracef("received secrets version %q from SDS server %q", resp.VersionInfo,
+				c.addr)
+			nonce = resp.Nonce
+			if err := c.apply(resp); err != nil {
+				c.log.Warnf("rejecting secrets version %q from SDS server %q: %s",
+					resp.VersionInfo, c.addr, err.Error())
+				if err := send(err.Error()); err != nil {
+					return err
+				}
+				continue
+			}
+			*version = resp.VersionInfo
+			c.log.Infof("new secrets version %q available from SDS server %q", *version,
+				c.addr)
+			if err := send(""); err != nil {
+				return err
+			}
+		case err := <-errs:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package certs
+
+import (
+	"crypto/tls"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pion/logging"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+
+	"github.com/l7mp/stunner/pkg/sds"
+)
+
+// secretServer is an SDS server pushing the responses sent to it by the tests
+type secretServer struct {
+	reqs  chan *sds.DiscoveryRequest
+	resps chan *sds.DiscoveryResponse
+}
+
+func (s *secretServer) StreamSecrets(stream sds.ServerStream) error {
+	go func() {
+		for {
+			req, err := stream.Recv()
+			if err != nil {
+				return
+			}
+			s.reqs <- req
+		}
+	}()
+	for {
+		select {
+		case resp := <-s.resps:
+			if err := stream.Send(resp); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+func newSecretServer(t *testing.T) (*secretServer, string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err, "listen")
+	srv := &secretServer{reqs: make(chan *sds.DiscoveryRequest, 10),
+		resps: make(chan *sds.DiscoveryResponse)}
+	g := grpc.NewServer()
+	sds.RegisterServer(g, srv)
+	go g.Serve(l) //nolint:errcheck
+	t.Cleanup(g.Stop)
+	return srv, l.Addr().String()
+}
+
+func newSecret(t *testing.T, name, cn string) sds.Secret {
+	certPEM, keyPEM := newCertPEM(t, cn)
+	return sds.Secret{Name: name, CertificateChain: string(certPEM), PrivateKey: string(keyPEM)}
+}
+
+func recvRequest(t *testing.T, srv *secretServer) *sds.DiscoveryRequest {
+	select {
+	case req := <-srv.reqs:
+		return req
+	case <-time.After(2 * time.Second):
+		t.Fatal("no request from the SDS client")
+		return nil
+	}
+}
+
+func TestSecretStore(t *testing.T) {
+	srv, addr := newSecretServer(t)
+	client := NewSecretClient(addr, "stunner-1", logging.NewDefaultLoggerFactory())
+	defer client.Close()
+
+	rotated := make(chan *tls.Certificate, 1)
+	type result struct {
+		s   *Store
+		err error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		s, err := NewSecretStore("test", "tls", client, -1,
+			func(c *tls.Certificate) { rotated <- c }, logging.NewDefaultLoggerFactory())
+		ch <- result{s, err}
+	}()
+
+	// the subscription
+	for {
+		req := recvRequest(t, srv)
+		assert.Equal(t, "stunner-1", req.Node, "node")
+		if len(req.ResourceNames) > 0 {
+			assert.Equal(t, []string{"tls"}, req.ResourceNames, "secret names")
+			break
+		}
+	}
+
+	srv.resps <- &sds.DiscoveryResponse{VersionInfo: "v1", Nonce: "n1",
+		Secrets: []sds.Secret{newSecret(t, "tls", "first")}}
+	r := <-ch
+	assert.NoError(t, r.err, "new store")
+	s := r.s
+	assert.Equal(t, "first", s.Certificate().Leaf.Subject.CommonName, "initial certificate")
+	req := recvRequest(t, srv)
+	assert.Equal(t, "v1", req.VersionInfo, "ACK version")
+	assert.Equal(t, "n1", req.ResponseNonce, "ACK nonce")
+	assert.Empty(t, req.ErrorDetail, "ACK")
+
+	srv.resps <- &sds.DiscoveryResponse{VersionInfo: "v2", Nonce: "n2",
+		Secrets: []sds.Secret{newSecret(t, "tls", "second")}}
+	select {
+	case c := <-rotated:
+		assert.Equal(t, "second", c.Leaf.Subject.CommonName, "rotated certificate")
+	case <-time.After(2 * time.Second):
+		t.Fatal("certificate not rotated")
+	}
+	req = recvRequest(t, srv)
+	assert.Equal(t, "v2", req.VersionInfo, "ACK version")
+	assert.Empty(t, req.ErrorDetail, "ACK")
+
+	// a broken secret is NACKed and the old certificate is kept
+	srv.resps <- &sds.DiscoveryResponse{VersionInfo: "v3", Nonce: "n3",
+		Secrets: []sds.Secret{{Name: "tls", CertificateChain: "garbage", PrivateKey: "garbage"}}}
+	req = recvRequest(t, srv)
+	assert.Equal(t, "v2", req.VersionInfo, "NACK version")
+	assert.Equal(t, "n3", req.ResponseNonce, "NACK nonce")
+	assert.NotEmpty(t, req.ErrorDetail, "NACK")
+	assert.Equal(t, "second", s.Certificate().Leaf.Subject.CommonName, "certificate after NACK")
+
+	// closing the store unsubscribes from the secret
+	s.Close()
+	req = recvRequest(t, srv)
+	assert.Empty(t, req.ResourceNames, "unsubscribed")
+}
+
+func TestSecretStoreSwitch(t *testing.T) {
+	_, addr := newSecretServer(t)
+	client := NewSecretClient(addr, "stunner-1", logging.NewDefaultLoggerFactory())
+	defer client.Close()
+	client.timeout = 50 * time.Millisecond
+
+	_, err := NewSecretStore("test", "tls", client, -1, nil, logging.NewDefaultLoggerFactory())
+	assert.Error(t, err, "no secret")
+
+	dir := t.TempDir()
+	writeCert(t, dir, "first")
+	s, err := NewStore("test", filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"), -1,
+		nil, logging.NewDefaultLoggerFactory())
+	assert.NoError(t, err, "new store")
+	defer s.Close()
+
+	// no secret: keep the certificate from the files
+	assert.Error(t, s.SetSecret(client, "tls"), "set unknown secret")
+	assert.Equal(t, "first", s.Certificate().Leaf.Subject.CommonName, "old certificate")
+
+	// a known secret is loaded right away
+	client.lock.Lock()
+	secret := newSecret(t, "tls", "second")
+	client.secrets["tls"] = &secret
+	client.stores["tls"] = []*Store{}
+	client.lock.Unlock()
+	assert.NoError(t, s.SetSecret(client, "tls"), "set secret")
+	assert.Equal(t, "second", s.Certificate().Leaf.Subject.CommonName, "certificate from secret")
+
+	// and back to the files
+	assert.NoError(t, s.SetFiles(filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")),
+		"set files")
+	assert.Equal(t, "first", s.Certificate().Leaf.Subject.CommonName, "certificate from files")
+	client.lock.Lock()
+	assert.Empty(t, client.stores["tls"], "unsubscribed")
+	client.lock.Unlock()
+}
@@ -0,0 +1,354 @@
+// Package certs implements certificate stores that load listener TLS certificates from files
+// (e.g., a cert-manager CSI volume or a mounted Kubernetes secret) or from a secret discovery
+// service (SDS), and rotate them atomically when the files or the secrets change.
+package certs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/logging"
+
+	"github.com/l7mp/stunner/internal/monitoring"
+	"github.com/l7mp/stunner/pkg/sds"
+)
+
+// DefaultReloadInterval is the default period for checking certificate files for changes
+const DefaultReloadInterval = 10 * time.Second
+
+// Store holds the current certificate of a listener. The certificate files are polled instead of
+// watched with inotify, since CSI drivers and the kubelet update mounted volumes by atomically
+// swapping a symlink to a new directory, which file watches do not survive. Certificates sourced
+// from an SDS server are pushed by the server instead.
+type Store struct {
+	name, certFile, keyFile string
+	secret                  string
+	sds                     *SecretClient
+	ready                   chan struct{} // closed when the secret is first loaded
+	lastErr                 error         // the last error loading the secret
+	cert                    atomic.Value  // *tls.Certificate
+	hash                    [sha256.Size]byte
+	onRotate                func(*tls.Certificate)
+	verify                  func(*x509.Certificate) error
+	staple                  atomic.Value // *staple
+	stapleOnce              sync.Once
+	lock                    sync.Mutex // protects the sources, the hash and the verifier
+	done                    chan struct{}
+	closeOnce               sync.Once
+	log                     logging.LeveledLogger
+}
+
+// NewStore loads the certificate/key pair from the given files and starts watching them for
+// changes, checking every interval (zero means DefaultReloadInterval, negative disables
+// reloading). The onRotate callback, if not nil, is called after each successful rotation.
+func NewStore(name, certFile, keyFile string, interval time.Duration, onRotate func(*tls.Certificate), logger logging.LoggerFactory) (*Store, error) {
+	s := &Store{
+		name:     name,
+		certFile: certFile,
+		keyFile:  keyFile,
+		onRotate: onRotate,
+		done:     make(chan struct{}),
+		log:      logger.NewLogger("certs"),
+	}
+
+	if _, err := s.reload(); err != nil {
+		return nil, err
+	}
+
+	if interval == 0 {
+		interval = DefaultReloadInterval
+	}
+	if interval > 0 {
+		go s.watch(interval)
+	}
+
+	return s, nil
+}
+
+// NewSecretStore loads the certificate/key pair from the named secret of an SDS server and
+// rotates the certificate as the server pushes new versions of the secret. The first version of
+// the secret is waited for, see DefaultSecretTimeout. The interval is the period the files are
+// checked at if the store is switched to files, see NewStore.
+func NewSecretStore(name, secret string, client *SecretClient, interval time.Duration, onRotate func(*tls.Certificate), logger logging.LoggerFactory) (*Store, error) {
+	s := &Store{
+		name:     name,
+		secret:   secret,
+		sds:      client,
+		onRotate: onRotate,
+		done:     make(chan struct{}),
+		log:      logger.NewLogger("certs"),
+	}
+
+	if err := s.subscribe(client, secret); err != nil {
+		return nil, err
+	}
+
+	if interval == 0 {
+		interval = DefaultReloadInterval
+	}
+	if interval > 0 {
+		go s.watch(interval)
+	}
+
+	return s, nil
+}
+
+// Certificate returns the current certificate
+func (s *Store) Certificate() *tls.Certificate {
+	return s.cert.Load().(*tls.Certificate)
+}
+
+// GetCertificate returns the current certificate, with the OCSP response stapled if OCSP stapling
+// is enabled, for use as tls.Config.GetCertificate
+func (s *Store) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cert := s.Certificate()
+	if stapled := s.stapled(cert); stapled != nil {
+		return stapled, nil
+	}
+	return cert, nil
+}
+
+// Name returns the name of the listener the store belongs to
+func (s *Store) Name() string {
+	return s.name
+}
+
+// SetFiles switches the store to a new certificate/key file pair, e.g., after the listener
+// configuration has changed. On error the store keeps serving the certificate from the old files.
+func (s *Store) SetFiles(certFile, keyFile string) error {
+	s.lock.Lock()
+	if s.secret == "" && s.certFile == certFile && s.keyFile == keyFile {
+		s.lock.Unlock()
+		return nil
+	}
+
+	oldCert, oldKey, oldSecret := s.certFile, s.keyFile, s.secret
+	s.certFile, s.keyFile, s.secret = certFile, keyFile, ""
+	rotated, err := s.reload()
+	if err != nil {
+		s.certFile, s.keyFile, s.secret = oldCert, oldKey, oldSecret
+	}
+	client := s.sds
+	if err == nil {
+		s.sds = nil
+	}
+	s.lock.Unlock()
+
+	if err != nil {
+		return err
+	}
+	if client != nil && oldSecret != "" {
+		client.unsubscribe(oldSecret, s)
+	}
+
+	if rotated {
+		monitoring.CertReloadCounter.WithLabelValues(s.name, "success").Inc()
+		if s.onRotate != nil {
+			s.onRotate(s.Certificate())
+		}
+	}
+	return nil
+}
+
+// SetSecret switches the store to a secret of an SDS server, e.g., after the listener
+// configuration has changed. On error the store keeps serving the certificate from the old source.
+func (s *Store) SetSecret(client *SecretClient, secret string) error {
+	s.lock.Lock()
+	if s.sds == client && s.secret == secret {
+		s.lock.Unlock()
+		return nil
+	}
+	oldClient, oldSecret := s.sds, s.secret
+	s.sds, s.secret = client, secret
+	s.lock.Unlock()
+
+	if err := s.subscribe(client, secret); err != nil {
+		s.lock.Lock()
+		s.sds, s.secret = oldClient, oldSecret
+		s.lock.Unlock()
+		return err
+	}
+
+	if oldClient != nil && oldSecret != "" {
+		oldClient.unsubscribe(oldSecret, s)
+	}
+	return nil
+}
+
+// SetVerify sets a check for the certificates: the current certificate is checked right away, and
+// certificates failing the check are not loaded on rotation
+func (s *Store) SetVerify(verify func(*x509.Certificate) error) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if err := verify(s.Certificate().Leaf); err != nil {
+		return fmt.Errorf("certificate for listener %q: %w", s.name, err)
+	}
+	s.verify = verify
+	return nil
+}
+
+// Close stops watching the certificate files, or the secret
+func (s *Store) Close() {
+	s.closeOnce.Do(func() {
+		close(s.done)
+		s.lock.Lock()
+		client, secret := s.sds, s.secret
+		s.lock.Unlock()
+		if client != nil && secret != "" {
+			client.unsubscribe(secret, s)
+		}
+		monitoring.CertExpiryGauge.DeleteLabelValues(s.name)
+		monitoring.OCSPStapleNextUpdateGauge.DeleteLabelValues(s.name)
+	})
+}
+
+func (s *Store) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.lock.Lock()
+			if s.secret != "" {
+				// the secret is pushed by the SDS server
+				s.lock.Unlock()
+				continue
+			}
+			rotated, err := s.reload()
+			s.lock.Unlock()
+			if err != nil {
+				// keep serving the old certificate: the files may be mid-update
+				s.log.Warnf("cannot reload certificate for listener %q: %s", s.name, err.Error())
+				monitoring.CertReloadCounter.WithLabelValues(s.name, "error").Inc()
+				continue
+			}
+			if rotated {
+				monitoring.CertReloadCounter.WithLabelValues(s.name, "success").Inc()
+				if s.onRotate != nil {
+					s.onRotate(s.Certificate())
+				}
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// subscribe subscribes the store to a secret and waits until the secret is loaded
+func (s *Store) subscribe(client *SecretClient, secret string) error {
+	ready := make(chan struct{})
+	s.lock.Lock()
+	s.ready, s.lastErr = ready, nil
+	s.lock.Unlock()
+
+	client.subscribe(secret, s)
+	select {
+	case <-ready:
+		return nil
+	case <-time.After(client.timeout):
+	}
+	client.unsubscribe(secret, s)
+
+	s.lock.Lock()
+	err := s.lastErr
+	s.lock.Unlock()
+	if err != nil {
+		return fmt.Errorf("cannot load secret %q: %w", secret, err)
+	}
+	return fmt.Errorf("no secret %q received from SDS server %s", secret, client.Addr())
+}
+
+// update loads a new version of the secret of the store, called by the SDS client
+func (s *Store) update(secret *sds.Secret) error {
+	s.lock.Lock()
+	if secret.Name != s.secret {
+		// the store has been switched to another source meanwhile
+		s.lock.Unlock()
+		return nil
+	}
+	loaded := s.cert.Load() != nil
+	rotated, err := s.load([]byte(secret.CertificateChain), []byte(secret.PrivateKey))
+	s.lastErr = err
+	if err == nil {
+		select {
+		case <-s.ready:
+		default:
+			close(s.ready)
+		}
+	}
+	s.lock.Unlock()
+
+	if err != nil {
+		s.log.Warnf("cannot load secret %q for listener %q: %s", secret.Name, s.name,
+			err.Error())
+		monitoring.CertReloadCounter.WithLabelValues(s.name, "error").Inc()
+		return err
+	}
+	if rotated && loaded {
+		monitoring.CertReloadCounter.WithLabelValues(s.name, "success").Inc()
+		if s.onRotate != nil {
+			s.onRotate(s.Certificate())
+		}
+	}
+	return nil
+}
+
+// reload reads the certificate files and swaps in the new certificate if they changed, must be
+// called with the lock held
+func (s *Store) reload() (bool, error) {
+	certPEM, err := os.ReadFile(s.certFile)
+	if err != nil {
+		return false, err
+	}
+	keyPEM, err := os.ReadFile(s.keyFile)
+	if err != nil {
+		return false, err
+	}
+	return s.load(certPEM, keyPEM)
+}
+
+// load swaps in the certificate/key pair if it changed, must be called with the lock held
+func (s *Store) load(certPEM, keyPEM []byte) (bool, error) {
+	hash := sha256.Sum256(bytes.Join([][]byte{certPEM, keyPEM}, nil))
+	if s.cert.Load() != nil && hash == s.hash {
+		return false, nil
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return false, err
+	}
+
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return false, fmt.Errorf("cannot parse certificate: %w", err)
+	}
+	cert.Leaf = leaf
+
+	if s.verify != nil {
+		if err := s.verify(leaf); err != nil {
+			return false, err
+		}
+	}
+
+	s.hash = hash
+	s.cert.Store(&cert)
+	monitoring.CertExpiryGauge.WithLabelValues(s.name).Set(float64(leaf.NotAfter.Unix()))
+
+	s.log.Infof("loaded certificate for listener %q: subject %q, expires %s", s.name,
+		leaf.Subject.String(), leaf.NotAfter.Format(time.RFC3339))
+	if time.Until(leaf.NotAfter) < 0 {
+		s.log.Warnf("certificate for listener %q has expired", s.name)
+	}
+
+	return true, nil
+}
@@ -0,0 +1,106 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pion/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func newCertPEM(t *testing.T, cn string) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoError(t, err, "generate key")
+
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	assert.NoError(t, err, "create certificate")
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	assert.NoError(t, err, "marshal key")
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	return certPEM, keyPEM
+}
+
+func writeCert(t *testing.T, dir, cn string) {
+	certPEM, keyPEM := newCertPEM(t, cn)
+
+	// the kubelet writes new files and then swaps them in atomically
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "tls.crt.tmp"), certPEM, 0600), "write cert")
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "tls.key.tmp"), keyPEM, 0600), "write key")
+	assert.NoError(t, os.Rename(filepath.Join(dir, "tls.crt.tmp"), filepath.Join(dir, "tls.crt")), "rename cert")
+	assert.NoError(t, os.Rename(filepath.Join(dir, "tls.key.tmp"), filepath.Join(dir, "tls.key")), "rename key")
+}
+
+func TestStoreRotation(t *testing.T) {
+	dir := t.TempDir()
+	writeCert(t, dir, "first")
+
+	rotated := make(chan *tls.Certificate, 1)
+	s, err := NewStore("test", filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key"),
+		10*time.Millisecond, func(c *tls.Certificate) { rotated <- c },
+		logging.NewDefaultLoggerFactory())
+	assert.NoError(t, err, "new store")
+	defer s.Close()
+
+	c, err := s.GetCertificate(nil)
+	assert.NoError(t, err, "get certificate")
+	assert.Equal(t, "first", c.Leaf.Subject.CommonName, "initial certificate")
+
+	writeCert(t, dir, "second")
+
+	select {
+	case c := <-rotated:
+		assert.Equal(t, "second", c.Leaf.Subject.CommonName, "rotated certificate")
+	case <-time.After(2 * time.Second):
+		t.Fatal("certificate not rotated")
+	}
+	assert.Equal(t, "second", s.Certificate().Leaf.Subject.CommonName, "current certificate")
+
+	// a broken update keeps the old certificate
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "tls.crt"), []byte("garbage"), 0600), "write garbage")
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, "second", s.Certificate().Leaf.Subject.CommonName, "certificate after failed reload")
+}
+
+func TestStoreMissingFiles(t *testing.T) {
+	_, err := NewStore("test", "/nonexistent/tls.crt", "/nonexistent/tls.key", -1, nil,
+		logging.NewDefaultLoggerFactory())
+	assert.Error(t, err, "missing files")
+}
+
+func TestStoreSetFiles(t *testing.T) {
+	dir1, dir2 := t.TempDir(), t.TempDir()
+	writeCert(t, dir1, "first")
+	writeCert(t, dir2, "second")
+
+	s, err := NewStore("test", filepath.Join(dir1, "tls.crt"), filepath.Join(dir1, "tls.key"),
+		-1, nil, logging.NewDefaultLoggerFactory())
+	assert.NoError(t, err, "new store")
+	defer s.Close()
+
+	// invalid files: keep the old certificate
+	assert.Error(t, s.SetFiles(filepath.Join(dir2, "dummy.crt"), filepath.Join(dir2, "tls.key")),
+		"set invalid files")
+	assert.Equal(t, "first", s.Certificate().Leaf.Subject.CommonName, "old certificate")
+
+	assert.NoError(t, s.SetFiles(filepath.Join(dir2, "tls.crt"), filepath.Join(dir2, "tls.key")),
+		"set files")
+	assert.Equal(t, "second", s.Certificate().Leaf.Subject.CommonName, "new certificate")
+}
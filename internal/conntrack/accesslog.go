@@ -0,0 +1,227 @@
+package conntrack
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Access log formats
+const (
+	// AccessLogJSON writes a JSON object per record
+	AccessLogJSON = "json"
+	// AccessLogCLF writes a line per record in a format modeled after the Common Log Format
+	AccessLogCLF = "clf"
+)
+
+// clfTimeFormat is the timestamp format of the Common Log Format
+const clfTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+// AccessLogRecord is written to the access log when an allocation starts or stops
+type AccessLogRecord struct {
+	// Time is the time of the event
+	Time time.Time `json:"time"`
+	// Event is "start" or "stop"
+	Event string `json:"event"`
+	// SessionID is the session ID of the allocation, it also appears in the log lines about the
+	// allocation
+	SessionID string `json:"session_id,omitempty"`
+	// Username is the username of the client
+	Username string `json:"username,omitempty"`
+	// Client is the address of the client
+	Client string `json:"client,omitempty"`
+	// Listener is the name of the listener the client connected to
+	Listener string `json:"listener"`
+	// Relay is the relay address of the allocation
+	Relay string `json:"relay"`
+	// Peers lists the peers contacted via the allocation
+	Peers []string `json:"peers"`
+	// TxBytes is the number of bytes sent from the client to the peers
+	TxBytes uint64 `json:"tx_bytes"`
+	// RxBytes is the number of bytes sent from the peers to the client
+	RxBytes uint64 `json:"rx_bytes"`
+	// Duration is the lifetime of the allocation in seconds
+	Duration float64 `json:"duration"`
+	// PeakTxBitrate is the peak bitrate from the client to the peers in bits per second
+	PeakTxBitrate uint64 `json:"peak_tx_bitrate,omitempty"`
+	// PeakRxBitrate is the peak bitrate from the peers to the client in bits per second
+	PeakRxBitrate uint64 `json:"peak_rx_bitrate,omitempty"`
+	// Reason is the teardown reason of "stop" records: "expiry", "client", "drain" or "error"
+	Reason string `json:"reason,omitempty"`
+	// TraceID is the ID of the trace of the client session, if tracing is enabled and the session
+	// is sampled
+	TraceID string `json:"trace_id,omitempty"`
+}
+
+// AccessLog writes a record per allocation start and stop to a sink
+type AccessLog struct {
+	w      io.Writer
+	format string
+	lock   sync.Mutex
+}
+
+// NewAccessLog creates an access log writing to the given sink in the given format, AccessLogJSON
+// or AccessLogCLF
+func NewAccessLog(w io.Writer, format string) (*AccessLog, error) {
+	switch format {
+	case AccessLogJSON, AccessLogCLF:
+	default:
+		return nil, fmt.Errorf("unknown access log format: %q", format)
+	}
+	return &AccessLog{w: w, format: format}, nil
+}
+
+// Writer returns the sink of the access log
+func (a *AccessLog) Writer() io.Writer {
+	return a.w
+}
+
+// Format returns the format of the access log
+func (a *AccessLog) Format() string {
+	return a.format
+}
+
+// Write writes a record to the sink
+func (a *AccessLog) Write(r AccessLogRecord) error {
+	var line []byte
+	switch a.format {
+	case AccessLogJSON:
+		out, err := json.Marshal(r)
+		if err != nil {
+			return err
+		}
+		line = append(out, '\n')
+	default:
+		// the session ID takes the place of the identity of the client
+		client, session, user := r.Client, r.SessionID, r.Username
+		if client == "" {
+			client = "-"
+		}
+		if session == "" {
+			session = "-"
+		}
+		if user == "" {
+			user = "-"
+		}
+		peers := "-"
+		if len(r.Peers) > 0 {
+			peers = strings.Join(r.Peers, ",")
+		}
+		line = []byte(fmt.Sprintf("%s %s %s [%s] \"%s %s %s\" %d %d %.3f %s\n", client, session, user,
+			r.Time.Format(clfTimeFormat), strings.ToUpper(r.Event), r.Listener, r.Relay,
+			r.TxBytes, r.RxBytes, r.Duration, peers))
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	_, err := a.w.Write(line)
+	return err
+}
+
+// SetAccessLog sets the access log of the table, nil disables access logging
+func (t *Table) SetAccessLog(a *AccessLog) {
+	t.accessLog.Store(accessLogHolder{a})
+}
+
+// GetAccessLog returns the access log of the table, or nil if access logging is disabled
+func (t *Table) GetAccessLog() *AccessLog {
+	h, ok := t.accessLog.Load().(accessLogHolder)
+	if !ok {
+		return nil
+	}
+	return h.log
+}
+
+// accessLogHolder wraps the access log so that atomic.Value can store nil
+type accessLogHolder struct{ log *AccessLog }
+
+// ObserverFunc is called with the record of each allocation start ("start") and stop ("stop"),
+// with a record carrying the username, the client and the listener of each failed authentication
+// ("auth-failure"), and with a record carrying the client and the listener of each malformed
+// packet received on a UDP listener ("malformed")
+type ObserverFunc func(r AccessLogRecord)
+
+// observerHolder wraps the observer so that atomic.Value can store nil
+type observerHolder struct{ observer ObserverFunc }
+
+// SetObserver sets the observer of the table, nil removes the observer
+func (t *Table) SetObserver(o ObserverFunc) {
+	t.observer.Store(observerHolder{o})
+}
+
+func (t *Table) getObserver() ObserverFunc {
+	h, ok := t.observer.Load().(observerHolder)
+	if !ok {
+		return nil
+	}
+	return h.observer
+}
+
+// reportAuthFailure reports a failed authentication to the observer
+func (t *Table) reportAuthFailure(listener, username string, client net.Addr) {
+	t.log.Debugf("authentication failed: listener %q, client %s, username %q", listener,
+		client, username)
+
+	if o := t.getObserver(); o != nil {
+		o(AccessLogRecord{Time: t.clock.Now(), Event: "auth-failure", Username: username,
+			Client: client.String(), Listener: listener, Peers: []string{}})
+	}
+}
+
+// reportMalformed reports a malformed packet to the observer
+func (t *Table) reportMalformed(listener string, client net.Addr) {
+	if o := t.getObserver(); o != nil {
+		o(AccessLogRecord{Time: t.clock.Now(), Event: "malformed", Client: client.String(),
+			Listener: listener, Peers: []string{}})
+	}
+}
+
+// accessLogged returns true if access logging is enabled or there is an observer
+func (t *Table) accessLogged() bool {
+	return t.GetAccessLog() != nil || t.getObserver() != nil
+}
+
+// newRecord returns the access log record of a flow
+func (t *Table) newRecord(f *Flow, event string, now time.Time) AccessLogRecord {
+	s := f.Status()
+	r := AccessLogRecord{
+		Time:          now,
+		Event:         event,
+		SessionID:     s.SessionID,
+		Username:      s.Username,
+		Client:        s.Client,
+		Listener:      s.Listener,
+		Relay:         s.Relay,
+		Peers:         make([]string, len(s.Peers)),
+		TxBytes:       s.TxBytes,
+		RxBytes:       s.RxBytes,
+		Duration:      now.Sub(f.created).Seconds(),
+		PeakTxBitrate: s.PeakTxBitrate,
+		PeakRxBitrate: s.PeakRxBitrate,
+	}
+	for i, p := range s.Peers {
+		r.Peers[i] = p.Peer
+	}
+	if tr := t.GetTracer(); tr != nil && s.Client != "" {
+		r.TraceID = tr.TraceID(sessionKey(s.Listener, s.Client))
+	}
+	return r
+}
+
+// logAccess writes an access log record, if access logging is enabled, and reports it to the
+// observer
+func (t *Table) logAccess(r AccessLogRecord) {
+	a, o := t.GetAccessLog(), t.getObserver()
+	if a != nil {
+		if err := a.Write(r); err != nil {
+			t.log.Warnf("could not write access log: %s", err.Error())
+		}
+	}
+	if o != nil {
+		o(r)
+	}
+}
@@ -0,0 +1,376 @@
+package conntrack
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/stun"
+
+	"github.com/l7mp/stunner/internal/pcapng"
+	"github.com/l7mp/stunner/internal/stunmsg"
+)
+
+const (
+	// DefaultCaptureMaxSize is the default size limit of a capture file
+	DefaultCaptureMaxSize = 10 * 1024 * 1024
+	// DefaultCaptureDuration is the default time limit of a capture
+	DefaultCaptureDuration = time.Minute
+	// maxFinishedCaptures is the number of finished captures remembered
+	maxFinishedCaptures = 16
+	// captureQueueSize is the number of packets queued for writing to a capture file, packets
+	// over the queue are dropped so that a slow disk does not stall the sockets
+	captureQueueSize = 1024
+)
+
+var (
+	sendIndication = stun.NewType(stun.MethodSend, stun.ClassIndication).Value()
+	dataIndication = stun.NewType(stun.MethodData, stun.ClassIndication).Value()
+)
+
+// CaptureConfig selects the session to capture and the limits of the capture
+type CaptureConfig struct {
+	// Listener is the name of the listener, empty matches all listeners
+	Listener string
+	// Client is the address of the client, an IP address matches all ports of the client
+	Client string
+	// Payload enables recording the headers of the ChannelData messages and of the Send and
+	// Data indications, the payload itself is never recorded
+	Payload bool
+	// Path is the path of the capture file
+	Path string
+	// MaxSize is the size limit of the capture file in bytes
+	MaxSize int64
+	// Duration is the time limit of the capture
+	Duration time.Duration
+}
+
+// CaptureStatus describes a running or finished capture
+type CaptureStatus struct {
+	// ID identifies the capture
+	ID int `json:"id"`
+	// Listener is the listener the capture is limited to, if any
+	Listener string `json:"listener,omitempty"`
+	// Client is the address of the client captured
+	Client string `json:"client"`
+	// Payload is true if the headers of the data messages are recorded
+	Payload bool `json:"payload"`
+	// Path is the path of the capture file
+	Path string `json:"path"`
+	// MaxSize is the size limit of the capture file in bytes
+	MaxSize int64 `json:"max_size"`
+	// Duration is the time limit of the capture in seconds
+	Duration float64 `json:"duration"`
+	// Started is the start time of the capture
+	Started time.Time `json:"started"`
+	// Stopped is the time the capture stopped, if it is finished
+	Stopped *time.Time `json:"stopped,omitempty"`
+	// Reason tells why the capture stopped, if it is finished
+	Reason string `json:"reason,omitempty"`
+	// Packets is the number of packets recorded
+	Packets uint64 `json:"packets"`
+	// Dropped is the number of packets not recorded because the capture file could not keep up
+	Dropped uint64 `json:"dropped,omitempty"`
+	// Size is the size of the capture file in bytes
+	Size int64 `json:"size"`
+}
+
+// capture is a running or finished capture of the messages of a client session. The packets are
+// written to the capture file by a goroutine of the capture, off the packet path
+type capture struct {
+	id      int
+	config  CaptureConfig
+	ip      net.IP
+	port    int
+	started time.Time
+	stopped time.Time
+	reason  string
+	file    *os.File
+	buf     *bufio.Writer
+	writer  *pcapng.Writer
+	timer   *time.Timer
+	queue   chan capturePacket
+	done    chan struct{} // closed once the capture file is closed
+	packets uint64
+	dropped uint64
+	size    int64
+}
+
+// capturePacket is a packet queued for writing to a capture file
+type capturePacket struct {
+	time    time.Time
+	dir     pcapng.Direction
+	pkt     []byte
+	origLen int
+}
+
+// matches returns true if a message on the given listener with the given client is to be captured
+func (c *capture) matches(listener string, client net.Addr) bool {
+	if !c.stopped.IsZero() || (c.config.Listener != "" && c.config.Listener != listener) {
+		return false
+	}
+	host, port, err := net.SplitHostPort(client.String())
+	if err != nil || !c.ip.Equal(net.ParseIP(host)) {
+		return false
+	}
+	return c.port == 0 || strconv.Itoa(c.port) == port
+}
+
+func (c *capture) status() CaptureStatus {
+	s := CaptureStatus{
+		ID:       c.id,
+		Listener: c.config.Listener,
+		Client:   c.config.Client,
+		Payload:  c.config.Payload,
+		Path:     c.config.Path,
+		MaxSize:  c.config.MaxSize,
+		Duration: c.config.Duration.Seconds(),
+		Started:  c.started,
+		Reason:   c.reason,
+		Packets:  c.packets,
+		Dropped:  c.dropped,
+		Size:     c.size,
+	}
+	if !c.stopped.IsZero() {
+		stopped := c.stopped
+		s.Stopped = &stopped
+	}
+	return s
+}
+
+// stop finishes the capture, must be called with the capture lock of the table held. The capture
+// file is closed once the queued packets are written
+func (c *capture) stop(reason string) {
+	if !c.stopped.IsZero() {
+		return
+	}
+	c.stopped, c.reason = time.Now(), reason
+	c.timer.Stop()
+	close(c.queue)
+}
+
+// write writes the queued packets to the capture file until the capture is stopped, stopping the
+// capture on a write error
+func (c *capture) write(t *Table) {
+	var writeErr error
+	for p := range c.queue {
+		if writeErr != nil {
+			continue
+		}
+		if writeErr = c.writer.WritePacket(p.time, p.dir, p.pkt, p.origLen); writeErr != nil {
+			t.captureLock.Lock()
+			t.stopCapture(c, fmt.Sprintf("write error: %s", writeErr.Error()))
+			t.captureLock.Unlock()
+		}
+	}
+	c.buf.Flush()
+	c.file.Close()
+	close(c.done)
+}
+
+// StartCapture starts recording the STUN/TURN control messages of a client session on the
+// listener sockets to a pcapng file, until the size or the time limit is reached or the capture
+// is stopped
+func (t *Table) StartCapture(conf CaptureConfig) (CaptureStatus, error) {
+	c := &capture{config: conf}
+
+	if host, port, err := net.SplitHostPort(conf.Client); err == nil {
+		c.ip = net.ParseIP(host)
+		c.port, err = strconv.Atoi(port)
+		if err != nil || c.port <= 0 || c.port > 65535 {
+			return CaptureStatus{}, fmt.Errorf("invalid client port: %q", conf.Client)
+		}
+	} else {
+		c.ip = net.ParseIP(conf.Client)
+	}
+	if c.ip == nil {
+		return CaptureStatus{}, fmt.Errorf("invalid client address: %q", conf.Client)
+	}
+	if c.config.MaxSize <= 0 {
+		c.config.MaxSize = DefaultCaptureMaxSize
+	}
+	if c.config.Duration <= 0 {
+		c.config.Duration = DefaultCaptureDuration
+	}
+
+	file, err := os.OpenFile(conf.Path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if err != nil {
+		return CaptureStatus{}, fmt.Errorf("could not create capture file: %w", err)
+	}
+	c.file, c.buf = file, bufio.NewWriter(file)
+	c.writer, err = pcapng.NewWriter(c.buf)
+	if err != nil {
+		file.Close()
+		return CaptureStatus{}, fmt.Errorf("could not write capture file: %w", err)
+	}
+	c.size = pcapng.HeaderSize
+	c.queue, c.done = make(chan capturePacket, captureQueueSize), make(chan struct{})
+
+	t.captureLock.Lock()
+	defer t.captureLock.Unlock()
+
+	t.nextCaptureID++
+	c.id = t.nextCaptureID
+	c.started = time.Now()
+	c.timer = time.AfterFunc(c.config.Duration, func() {
+		t.captureLock.Lock()
+		defer t.captureLock.Unlock()
+		t.stopCapture(c, "time limit reached")
+	})
+	t.captures = append(t.captures, c)
+	atomic.AddInt32(&t.activeCaptures, 1)
+	go c.write(t)
+
+	t.log.Infof("capture %d started: listener %q, client %s, file %s", c.id, conf.Listener,
+		conf.Client, conf.Path)
+
+	return c.status(), nil
+}
+
+// StopCapture stops a running capture, returning once the capture file is written
+func (t *Table) StopCapture(id int) (CaptureStatus, error) {
+	t.captureLock.Lock()
+	var found *capture
+	for _, c := range t.captures {
+		if c.id == id {
+			found = c
+			t.stopCapture(c, "stopped")
+			break
+		}
+	}
+	t.captureLock.Unlock()
+
+	if found == nil {
+		return CaptureStatus{}, fmt.Errorf("capture %d not found", id)
+	}
+	<-found.done
+
+	t.captureLock.Lock()
+	defer t.captureLock.Unlock()
+	return found.status(), nil
+}
+
+// StopCaptures stops all running captures, returning once the capture files are written
+func (t *Table) StopCaptures() {
+	t.captureLock.Lock()
+	captures := append([]*capture(nil), t.captures...)
+	for _, c := range captures {
+		t.stopCapture(c, "stopped")
+	}
+	t.captureLock.Unlock()
+
+	for _, c := range captures {
+		<-c.done
+	}
+}
+
+// Captures returns the status of the running and the recently finished captures
+func (t *Table) Captures() []CaptureStatus {
+	t.captureLock.Lock()
+	defer t.captureLock.Unlock()
+
+	ret := make([]CaptureStatus, len(t.captures))
+	for i, c := range t.captures {
+		ret[i] = c.status()
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].ID < ret[j].ID })
+	return ret
+}
+
+// stopCapture stops a capture and forgets the oldest finished captures, must be called with the
+// capture lock held
+func (t *Table) stopCapture(c *capture, reason string) {
+	if !c.stopped.IsZero() {
+		return
+	}
+	c.stop(reason)
+	atomic.AddInt32(&t.activeCaptures, -1)
+
+	t.log.Infof("capture %d stopped: %s, %d packets, %d bytes", c.id, reason, c.packets, c.size)
+
+	finished := 0
+	for _, c := range t.captures {
+		if !c.stopped.IsZero() {
+			finished++
+		}
+	}
+	// the callers may be ranging over the captures, so the list is not changed in place
+	captures := make([]*capture, 0, len(t.captures))
+	for _, c := range t.captures {
+		if !c.stopped.IsZero() && finished > maxFinishedCaptures {
+			finished--
+			continue
+		}
+		captures = append(captures, c)
+	}
+	t.captures = captures
+}
+
+// capture records a message sent or received on a listener socket to the matching captures
+func (t *tracker) capture(b []byte, h stunmsg.Header, client net.Addr, dir pcapng.Direction) {
+	if atomic.LoadInt32(&t.table.activeCaptures) == 0 || client == nil {
+		return
+	}
+
+	// control messages are recorded in full, data messages only up to the payload
+	snap, data := captureSnapLen(b, h)
+	if snap == 0 {
+		return
+	}
+
+	src, dst := client, t.local
+	if dir == pcapng.Outbound {
+		src, dst = t.local, client
+	}
+
+	now := time.Now()
+	table := t.table
+	table.captureLock.Lock()
+	defer table.captureLock.Unlock()
+
+	for _, c := range table.captures {
+		if !c.matches(t.listener, client) || (data && !c.config.Payload) {
+			continue
+		}
+
+		pkt, origLen := pcapng.UDPPacket(src, dst, b, snap)
+		size := int64(pcapng.BlockSize(len(pkt)))
+		if c.size+size > c.config.MaxSize {
+			table.stopCapture(c, "size limit reached")
+			continue
+		}
+		select {
+		case c.queue <- capturePacket{time: now, dir: dir, pkt: pkt, origLen: origLen}:
+			c.packets++
+			c.size += size
+		default:
+			c.dropped++
+		}
+	}
+}
+
+// captureSnapLen returns the number of bytes of a message to capture, the entire message for
+// STUN/TURN control messages and the headers for ChannelData messages and Send and Data
+// indications, along with whether the message is a data message. Returns zero for messages not
+// to be captured
+func captureSnapLen(b []byte, h stunmsg.Header) (int, bool) {
+	switch {
+	case h.Kind == stunmsg.ChannelData:
+		return stunmsg.ChannelDataHeaderSize, true
+	case h.Kind != stunmsg.STUN:
+		return 0, false
+	case h.Type != sendIndication && h.Type != dataIndication:
+		return -1, false
+	}
+
+	// record the attributes up to the value of the DATA attribute
+	if off, _, ok := stunmsg.Attribute(b, uint16(stun.AttrData)); ok {
+		return off, true
+	}
+	return stunmsg.HeaderSize, true
+}
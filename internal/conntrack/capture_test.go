@@ -0,0 +1,73 @@
+package conntrack
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pion/logging"
+	"github.com/pion/stun"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/l7mp/stunner/internal/pcapng"
+	"github.com/l7mp/stunner/internal/stunmsg"
+)
+
+func TestCaptureStop(t *testing.T) {
+	table := NewTable(logging.NewDefaultLoggerFactory())
+	dir := t.TempDir()
+
+	// more captures than remembered once finished, stopped while ranging over the captures
+	n := maxFinishedCaptures + 4
+	for i := 0; i < n; i++ {
+		_, err := table.StartCapture(CaptureConfig{Client: "1.2.3.4",
+			Path: filepath.Join(dir, fmt.Sprintf("capture-%d.pcapng", i))})
+		assert.NoError(t, err, "start capture")
+	}
+	table.StopCaptures()
+
+	captures := table.Captures()
+	assert.Len(t, captures, maxFinishedCaptures, "finished captures remembered")
+	for i, c := range captures {
+		assert.Equal(t, n-maxFinishedCaptures+i+1, c.ID, "newest kept")
+		assert.NotNil(t, c.Stopped, "stopped")
+		assert.Equal(t, "stopped", c.Reason, "reason")
+	}
+	_, err := table.StopCapture(1)
+	assert.Error(t, err, "forgotten capture")
+}
+
+func TestCaptureWrite(t *testing.T) {
+	table := NewTable(logging.NewDefaultLoggerFactory())
+	path := filepath.Join(t.TempDir(), "capture.pcapng")
+	msg := stun.MustBuild(stun.TransactionID, stun.BindingRequest).Raw
+	// IPv4 and UDP headers
+	block := int64(pcapng.BlockSize(28 + len(msg)))
+
+	// room for two packets
+	status, err := table.StartCapture(CaptureConfig{Client: "1.2.3.4:5678", Path: path,
+		MaxSize: pcapng.HeaderSize + 2*block})
+	assert.NoError(t, err, "start capture")
+
+	tr := newTracker(table, "udp", &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 3478})
+	client := &net.UDPAddr{IP: net.ParseIP("1.2.3.4"), Port: 5678}
+	h := stunmsg.Parse(msg)
+	tr.capture(msg, h, &net.UDPAddr{IP: net.ParseIP("1.2.3.4"), Port: 1}, pcapng.Inbound)
+	tr.capture(msg, h, client, pcapng.Inbound)
+	tr.capture(msg, h, client, pcapng.Outbound)
+	tr.capture(msg, h, client, pcapng.Inbound)
+
+	captures := table.Captures()
+	assert.Len(t, captures, 1, "captures")
+	assert.Equal(t, "size limit reached", captures[0].Reason, "size limit")
+	assert.Equal(t, uint64(2), captures[0].Packets, "packets")
+
+	// the file is complete once the capture is stopped
+	status, err = table.StopCapture(status.ID)
+	assert.NoError(t, err, "stop capture")
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err, "read capture file")
+	assert.Equal(t, status.Size, int64(len(content)), "capture size")
+}
@@ -0,0 +1,329 @@
+package conntrack
+
+import (
+	"net"
+	"sync"
+
+	"github.com/pion/stun"
+
+	"github.com/l7mp/stunner/internal/monitoring"
+	"github.com/l7mp/stunner/internal/pcapng"
+	"github.com/l7mp/stunner/internal/stunmsg"
+	"github.com/l7mp/stunner/internal/tracing"
+	"github.com/l7mp/stunner/internal/util"
+)
+
+// maxPendingTransactions bounds the number of requests waiting for a response
+const maxPendingTransactions = 4096
+
+var (
+	allocateRequest    = stun.NewType(stun.MethodAllocate, stun.ClassRequest).Value()
+	allocateResponse   = stun.NewType(stun.MethodAllocate, stun.ClassSuccessResponse).Value()
+	allocateError      = stun.NewType(stun.MethodAllocate, stun.ClassErrorResponse).Value()
+	permissionRequest  = stun.NewType(stun.MethodCreatePermission, stun.ClassRequest).Value()
+	permissionResponse = stun.NewType(stun.MethodCreatePermission, stun.ClassSuccessResponse).Value()
+	channelRequest     = stun.NewType(stun.MethodChannelBind, stun.ClassRequest).Value()
+	channelResponse    = stun.NewType(stun.MethodChannelBind, stun.ClassSuccessResponse).Value()
+	permissionError    = stun.NewType(stun.MethodCreatePermission, stun.ClassErrorResponse).Value()
+	channelError       = stun.NewType(stun.MethodChannelBind, stun.ClassErrorResponse).Value()
+)
+
+// tracker follows Allocate transactions on a listener socket: it remembers the username of
+// Allocate requests and binds the flow to the client once the success response is sent. It also
+// follows the CreatePermission and ChannelBind transactions, to record the permissions and the
+// channels of the flow once granted, and the Refresh transactions, to tell why the allocation is
+// deleted. If tracing is enabled, it also reports the TURN transactions as spans, it logs the
+// messages selected by the message trace, and it records the messages of the captured sessions
+type tracker struct {
+	table    *Table
+	listener string
+	local    net.Addr
+	lock     sync.Mutex
+	pending  map[[stun.TransactionIDSize]byte]string
+	grants   map[[stun.TransactionIDSize]byte]grant
+	spans    map[[stun.TransactionIDSize]byte]*tracing.Span
+}
+
+func newTracker(table *Table, listener string, local net.Addr) *tracker {
+	return &tracker{
+		table:    table,
+		listener: listener,
+		local:    local,
+		pending:  make(map[[stun.TransactionIDSize]byte]string),
+		grants:   make(map[[stun.TransactionIDSize]byte]grant),
+		spans:    make(map[[stun.TransactionIDSize]byte]*tracing.Span),
+	}
+}
+
+// traced returns true if a message of the given type is to be reported to the tracer
+func (t *tracker) traced(typ uint16) bool {
+	if t.table.GetTracer() == nil {
+		return false
+	}
+	var mt stun.MessageType
+	mt.ReadValue(typ)
+	return tracedMethods[mt.Method]
+}
+
+// inbound inspects a message, with the header h, received from a client
+func (t *tracker) inbound(b []byte, h stunmsg.Header, client net.Addr) {
+	t.capture(b, h, client, pcapng.Inbound)
+
+	if h.Kind != stunmsg.STUN {
+		return
+	}
+	typ := h.Type
+	if mt := t.table.GetMessageTrace(); mt != nil && typ != sendIndication {
+		t.traceMessage(mt, b, client, "in")
+	}
+	traced := t.traced(typ)
+	if typ != allocateRequest && typ != permissionRequest && typ != channelRequest &&
+		typ != refreshRequest && !traced {
+		return
+	}
+
+	// the message is decoded from a pooled copy, nothing may keep a reference to it
+	buf := util.DefaultBufferPool.Copy(b)
+	defer util.DefaultBufferPool.Put(buf)
+	m := &stun.Message{Raw: *buf}
+	if err := m.Decode(); err != nil {
+		return
+	}
+
+	if traced && m.Type.Class == stun.ClassRequest {
+		t.startSpan(m, client)
+	}
+	if typ == permissionRequest || typ == channelRequest {
+		t.requestGrant(m)
+		return
+	}
+	if typ == refreshRequest {
+		t.teardownRequested(m, client)
+		return
+	}
+	if typ != allocateRequest {
+		return
+	}
+
+	var username stun.Username
+	if err := username.GetFrom(m); err != nil {
+		// first Allocate request without credentials: will be challenged
+		return
+	}
+
+	t.lock.Lock()
+	if len(t.pending) >= maxPendingTransactions {
+		// clients not waiting for a response: forget the backlog
+		t.pending = make(map[[stun.TransactionIDSize]byte]string)
+	}
+	t.pending[m.TransactionID] = username.String()
+	t.lock.Unlock()
+}
+
+// outbound inspects a message sent to a client, send writes to the same client
+func (t *tracker) outbound(b []byte, client net.Addr, send func([]byte) error) {
+	h := stunmsg.Parse(b)
+	t.capture(b, h, client, pcapng.Outbound)
+
+	if h.Kind != stunmsg.STUN {
+		return
+	}
+	typ := h.Type
+	if mt := t.table.GetMessageTrace(); mt != nil && typ != dataIndication {
+		t.traceMessage(mt, b, client, "out")
+	}
+	traced := t.traced(typ)
+	granting := typ == permissionResponse || typ == channelResponse ||
+		typ == permissionError || typ == channelError
+	if typ != allocateResponse && typ != allocateError && typ != refreshResponse && !granting &&
+		!traced {
+		return
+	}
+
+	buf := util.DefaultBufferPool.Copy(b)
+	defer util.DefaultBufferPool.Put(buf)
+	m := &stun.Message{Raw: *buf}
+	if err := m.Decode(); err != nil {
+		return
+	}
+
+	if traced && (m.Type.Class == stun.ClassSuccessResponse ||
+		m.Type.Class == stun.ClassErrorResponse) {
+		t.endSpan(m)
+	}
+	if granting {
+		t.commitGrant(m, client)
+		return
+	}
+	if typ == allocateError {
+		t.allocateFailed(m, client)
+		return
+	}
+	if typ == refreshResponse {
+		t.refreshed(m, client)
+		return
+	}
+	if typ != allocateResponse {
+		return
+	}
+
+	var relay stun.XORMappedAddress
+	if err := relay.GetFromAs(m, stun.AttrXORRelayedAddress); err != nil {
+		return
+	}
+
+	t.lock.Lock()
+	username, found := t.pending[m.TransactionID]
+	delete(t.pending, m.TransactionID)
+	t.lock.Unlock()
+
+	if found {
+		monitoring.ObserveRequest(t.listener, monitoring.MethodAllocate, monitoring.ResultSuccess)
+	}
+
+	t.table.bind(&net.UDPAddr{IP: relay.IP, Port: relay.Port}, client, username, send)
+	t.refreshed(m, client)
+}
+
+// allocateFailed counts the rejection of an Allocate request that carried credentials and reports
+// it as a failed authentication if the credentials were rejected
+func (t *tracker) allocateFailed(m *stun.Message, client net.Addr) {
+	t.lock.Lock()
+	username, found := t.pending[m.TransactionID]
+	delete(t.pending, m.TransactionID)
+	t.lock.Unlock()
+
+	if !found {
+		// the challenge of a request without credentials
+		return
+	}
+
+	var code stun.ErrorCodeAttribute
+	if err := code.GetFrom(m); err != nil {
+		return
+	}
+	monitoring.ObserveRequest(t.listener, monitoring.MethodAllocate,
+		monitoring.ResultForCode(int(code.Code)))
+
+	// the TURN server answers unknown users and integrity check failures with a 400
+	switch code.Code {
+	case stun.CodeBadRequest, stun.CodeUnauthorized, stun.CodeWrongCredentials:
+		t.table.reportAuthFailure(t.listener, username, client)
+	}
+}
+
+// packetConn is a listener-side packet socket that feeds the conntrack table
+type packetConn struct {
+	net.PacketConn
+	tracker *tracker
+}
+
+// NewPacketConn wraps a packet socket of a listener so that the Allocate transactions it carries
+// are tracked in the table
+func (t *Table) NewPacketConn(conn net.PacketConn, listener string) net.PacketConn {
+	return &packetConn{PacketConn: conn, tracker: newTracker(t, listener, conn.LocalAddr())}
+}
+
+// ReadFrom drops the ChannelData messages on expired channel bindings
+func (c *packetConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, _, err := c.ReadMessage(b)
+	return n, addr, err
+}
+
+func (c *packetConn) ReadMessage(b []byte) (int, net.Addr, stunmsg.Header, error) {
+	for {
+		n, addr, h, err := stunmsg.ReadMessage(c.PacketConn, b)
+		if err != nil {
+			return n, addr, h, err
+		}
+		if !h.WellFormed(n) && c.tracker.table.getObserver() != nil {
+			c.tracker.table.reportMalformed(c.tracker.listener, addr)
+		}
+		c.tracker.inbound(b[:n], h, addr)
+		if c.tracker.channelExpired(h, addr) {
+			continue
+		}
+		return n, addr, h, err
+	}
+}
+
+func (c *packetConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	c.tracker.outbound(b, addr, func(m []byte) error {
+		_, err := c.PacketConn.WriteTo(m, addr)
+		return err
+	})
+	return c.PacketConn.WriteTo(b, addr)
+}
+
+// listener wraps a stream listener so that the accepted connections feed the conntrack table
+type listener struct {
+	net.Listener
+	table *Table
+	name  string
+}
+
+// NewListener wraps the socket of a listener (TCP, TLS or DTLS) so that the Allocate transactions
+// carried by the accepted connections are tracked in the table
+func (t *Table) NewListener(l net.Listener, name string) net.Listener {
+	return &listener{Listener: l, table: t, name: name}
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return conn, err
+	}
+	return &streamConn{Conn: conn, tracker: newTracker(l.table, l.name, conn.LocalAddr())}, nil
+}
+
+// streamConn is an accepted stream connection. The TURN server writes each message in a single
+// Write call so outbound tracking is exact; inbound tracking is best-effort as a Read may return
+// a partial message
+type streamConn struct {
+	net.Conn
+	tracker *tracker
+}
+
+// Read drops the ChannelData messages on expired channel bindings at the beginning of a Read
+func (c *streamConn) Read(b []byte) (int, error) {
+	for {
+		n, err := c.Conn.Read(b)
+		if err != nil {
+			return n, err
+		}
+		h := stunmsg.Parse(b[:n])
+		c.tracker.inbound(b[:n], h, c.Conn.RemoteAddr())
+		size := channelDataSize(h, n)
+		if size == 0 || !c.tracker.channelExpired(h, c.Conn.RemoteAddr()) {
+			return n, err
+		}
+		if n > size {
+			return copy(b, b[size:n]), nil
+		}
+	}
+}
+
+// channelDataSize returns the size of the ChannelData message, with the header h, at the beginning
+// of a buffer of n bytes, including the padding to 4 bytes of stream transports if present, or 0
+// if the buffer does not start with a whole ChannelData message
+func channelDataSize(h stunmsg.Header, n int) int {
+	if h.Kind != stunmsg.ChannelData {
+		return 0
+	}
+	size := h.Size()
+	if padded := stunmsg.ChannelDataHeaderSize + (h.Length+3)&^3; padded <= n {
+		size = padded
+	}
+	if size > n {
+		return 0
+	}
+	return size
+}
+
+func (c *streamConn) Write(b []byte) (int, error) {
+	c.tracker.outbound(b, c.Conn.RemoteAddr(), func(m []byte) error {
+		_, err := c.Conn.Write(m)
+		return err
+	})
+	return c.Conn.Write(b)
+}
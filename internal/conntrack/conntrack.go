@@ -0,0 +1,468 @@
+// Package conntrack implements the STUNner connection tracking table, which maintains the
+// client<->relay<->peer mappings of active TURN allocations along with traffic statistics.
+package conntrack
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/logging"
+
+	"github.com/l7mp/stunner/pkg/clock"
+)
+
+// Table is the connection tracking table. Flows are created when the TURN server allocates a
+// relay transport, bound to the client when the corresponding Allocate success response is sent
+// on the listener socket, and removed when the relay transport is closed (i.e., when the
+// allocation is deleted or expires).
+type Table struct {
+	lock      sync.RWMutex
+	flows     map[string]*Flow
+	clients   map[string]*Flow
+	accessLog atomic.Value // accessLogHolder
+	tracer    atomic.Value // tracerHolder
+	observer  atomic.Value // observerHolder
+	msgTrace  atomic.Value // messageTraceHolder
+	lifetimes atomic.Value // lifetimeHolder
+	log       logging.LeveledLogger
+	msgLog    logging.LeveledLogger
+	sources   map[string]int // client source IP -> number of bound flows
+	clock     clock.Clock
+
+	captureLock    sync.Mutex
+	captures       []*capture
+	nextCaptureID  int
+	activeCaptures int32 // atomic
+}
+
+// NewTable creates an empty connection tracking table
+func NewTable(logger logging.LoggerFactory) *Table {
+	return &Table{
+		flows:   make(map[string]*Flow),
+		clients: make(map[string]*Flow),
+		sources: make(map[string]int),
+		clock:   clock.Real,
+		log:     logger.NewLogger("conntrack"),
+		msgLog:  logger.NewLogger("stun-trace"),
+	}
+}
+
+// SetClock sets the clock the lifetimes of the allocations, the permissions and the channel
+// bindings are tracked with. Must be called before the table is used
+func (t *Table) SetClock(c clock.Clock) {
+	t.clock = c
+}
+
+// Flow is a conntrack entry for a single TURN allocation. Each flow has a short random session ID
+// that correlates the log lines, the metric exemplars and the access log records of the
+// allocation
+type Flow struct {
+	id       string
+	listener string
+	relay    net.Addr
+	created  time.Time
+	clock    clock.Clock
+
+	lock        sync.Mutex
+	client      net.Addr
+	send        func([]byte) error // writes a message to the client on the listener socket
+	username    string
+	peers       map[peerKey]*peerStats
+	permissions map[string]*permission // peer IP -> permission
+	channels    map[uint16]*channel
+	lastActive  int64 // unix nanos, atomic
+
+	expires     time.Time // the allocation expires unless refreshed
+	refreshed   time.Time // the last Allocate or Refresh success response
+	teardown    time.Time // the client requested the deletion of the allocation
+	terminating bool      // the server terminates the allocation
+
+	// peak bitrates over one-second intervals
+	rateSecond     int64
+	rateTx, rateRx uint64
+	peakTx, peakRx uint64
+}
+
+type peerKey struct {
+	ip   [16]byte
+	port int
+}
+
+type peerStats struct {
+	addr               string
+	txPackets, txBytes uint64
+	rxPackets, rxBytes uint64
+	lastActive         time.Time
+}
+
+func newPeerKey(addr net.Addr) (peerKey, bool) {
+	var k peerKey
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		copy(k.ip[:], a.IP.To16())
+		k.port = a.Port
+	case *net.TCPAddr:
+		copy(k.ip[:], a.IP.To16())
+		k.port = a.Port
+	default:
+		return k, false
+	}
+	return k, true
+}
+
+// newFlow registers a new flow for the relay address
+func (t *Table) newFlow(listener string, relay net.Addr) *Flow {
+	now := t.clock.Now()
+	f := &Flow{
+		id:          newSessionID(),
+		listener:    listener,
+		relay:       relay,
+		created:     now,
+		clock:       t.clock,
+		peers:       make(map[peerKey]*peerStats),
+		permissions: make(map[string]*permission),
+		channels:    make(map[uint16]*channel),
+		lastActive:  now.UnixNano(),
+	}
+
+	t.lock.Lock()
+	t.flows[relay.String()] = f
+	t.lock.Unlock()
+
+	t.log.Debugf("new flow: session %s, listener %q, relay %s", f.id, listener, relay)
+
+	return f
+}
+
+// bind associates a flow with the client that created it
+func (t *Table) bind(relay, client net.Addr, username string, send func([]byte) error) {
+	t.lock.RLock()
+	f, found := t.flows[relay.String()]
+	t.lock.RUnlock()
+
+	if !found {
+		t.log.Debugf("cannot bind client %s: unknown relay address %s", client, relay)
+		return
+	}
+
+	f.lock.Lock()
+	started := f.client == nil
+	f.client = client
+	f.send = send
+	f.username = username
+	f.lock.Unlock()
+
+	t.lock.Lock()
+	t.clients[sessionKey(f.listener, client.String())] = f
+	if started {
+		t.sources[sourceKey(client)]++
+	}
+	t.lock.Unlock()
+
+	t.log.Debugf("flow bound: session %s, listener %q, client %s, relay %s, username %q", f.id,
+		f.listener, client, relay, username)
+
+	// retransmitted Allocate responses rebind the flow
+	if started && t.accessLogged() {
+		t.logAccess(t.newRecord(f, "start", t.clock.Now()))
+	}
+}
+
+// deleteFlow removes a flow from the table
+func (t *Table) deleteFlow(f *Flow) {
+	f.lock.Lock()
+	client := f.client
+	f.lock.Unlock()
+
+	t.lock.Lock()
+	delete(t.flows, f.relay.String())
+	if client != nil {
+		id := sessionKey(f.listener, client.String())
+		if t.clients[id] == f {
+			delete(t.clients, id)
+		}
+		src := sourceKey(client)
+		if t.sources[src]--; t.sources[src] <= 0 {
+			delete(t.sources, src)
+		}
+	}
+	t.lock.Unlock()
+
+	// the end-of-session summary answers most questions about a session in a single line
+	now := t.clock.Now()
+	r := t.newRecord(f, "stop", now)
+	r.Reason = t.teardownReason(f, now)
+	t.log.Infof("session summary: %s", r.summary())
+
+	t.logAccess(r)
+
+	if tr := t.GetTracer(); tr != nil && client != nil {
+		tr.EndSession(sessionKey(f.listener, client.String()))
+	}
+}
+
+// newSessionID returns a short random session ID
+func newSessionID() string {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%08x", uint32(time.Now().UnixNano()))
+	}
+	return hex.EncodeToString(b)
+}
+
+// ID returns the session ID of the flow
+func (f *Flow) ID() string {
+	return f.id
+}
+
+// SessionID returns the session ID of the flow of a relay address, or an empty string if the relay
+// address is unknown
+func (t *Table) SessionID(relay net.Addr) string {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	if f, found := t.flows[relay.String()]; found {
+		return f.id
+	}
+	return ""
+}
+
+// ClientSessionID returns the session ID of the flow of a client on a listener, or an empty string
+// if the client has no allocation
+func (t *Table) ClientSessionID(listener string, client net.Addr) string {
+	if f := t.clientFlow(listener, client); f != nil {
+		return f.id
+	}
+	return ""
+}
+
+// ClientUsername returns the username of the allocation of a client on a listener, or an empty
+// string if the client has no allocation
+func (t *Table) ClientUsername(listener string, client net.Addr) string {
+	f := t.clientFlow(listener, client)
+	if f == nil {
+		return ""
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.username
+}
+
+// account updates the peer statistics of the flow: tx means client->peer, rx means peer->client
+func (f *Flow) account(peer net.Addr, n int, tx bool) {
+	now := f.clock.Now()
+	atomic.StoreInt64(&f.lastActive, now.UnixNano())
+
+	k, ok := newPeerKey(peer)
+	if !ok {
+		return
+	}
+
+	f.lock.Lock()
+	f.accountRate(now, n, tx)
+	p, found := f.peers[k]
+	if !found {
+		p = &peerStats{addr: peer.String()}
+		f.peers[k] = p
+	}
+	if tx {
+		p.txPackets++
+		p.txBytes += uint64(n)
+	} else {
+		p.rxPackets++
+		p.rxBytes += uint64(n)
+	}
+	p.lastActive = now
+	f.lock.Unlock()
+}
+
+// String returns a short human-readable representation of the flow
+func (f *Flow) String() string {
+	s := f.Status()
+	client := s.Client
+	if client == "" {
+		client = "<unbound>"
+	}
+	peers := make([]string, len(s.Peers))
+	for i, p := range s.Peers {
+		peers[i] = fmt.Sprintf("%s(tx:%dB/%dpkt,rx:%dB/%dpkt)", p.Peer, p.TxBytes,
+			p.TxPackets, p.RxBytes, p.RxPackets)
+	}
+	return fmt.Sprintf("session=%s listener=%s client=%s relay=%s user=%q age=%s idle=%s peers=[%s]",
+		s.SessionID, s.Listener, client, s.Relay, s.Username, s.Age, s.Idle, strings.Join(peers, ","))
+}
+
+// PeerStatus is the traffic statistics of a flow towards a single peer
+type PeerStatus struct {
+	Peer      string `json:"peer"`
+	TxPackets uint64 `json:"tx_packets"`
+	TxBytes   uint64 `json:"tx_bytes"`
+	RxPackets uint64 `json:"rx_packets"`
+	RxBytes   uint64 `json:"rx_bytes"`
+	Idle      string `json:"idle"`
+}
+
+// FlowStatus is a point-in-time snapshot of a flow
+type FlowStatus struct {
+	SessionID string `json:"session_id"`
+	Listener  string `json:"listener"`
+	Client    string `json:"client,omitempty"`
+	Relay     string `json:"relay"`
+	Username  string `json:"username,omitempty"`
+	Age       string `json:"age"`
+	Idle      string `json:"idle"`
+	// Expires is the time until the allocation expires unless refreshed, empty if unknown
+	Expires   string       `json:"expires,omitempty"`
+	TxPackets uint64       `json:"tx_packets"`
+	TxBytes   uint64       `json:"tx_bytes"`
+	RxPackets uint64       `json:"rx_packets"`
+	RxBytes   uint64       `json:"rx_bytes"`
+	Peers     []PeerStatus `json:"peers"`
+	// PeakTxBitrate and PeakRxBitrate are the peak bitrates from the client to the peers and
+	// from the peers to the client, in bits per second over one-second intervals
+	PeakTxBitrate uint64 `json:"peak_tx_bitrate"`
+	PeakRxBitrate uint64 `json:"peak_rx_bitrate"`
+	// Permissions lists the peer IPs the client has an active permission for
+	Permissions []PermissionStatus `json:"permissions"`
+	// Channels lists the active channel bindings of the client
+	Channels []ChannelStatus `json:"channels"`
+}
+
+// Status returns a snapshot of the flow
+func (f *Flow) Status() FlowStatus {
+	now := f.clock.Now()
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	s := FlowStatus{
+		SessionID: f.id,
+		Listener:  f.listener,
+		Relay:     f.relay.String(),
+		Username:  f.username,
+		Age:       now.Sub(f.created).Truncate(time.Millisecond).String(),
+		Idle: now.Sub(time.Unix(0, atomic.LoadInt64(&f.lastActive))).
+			Truncate(time.Millisecond).String(),
+		Peers: make([]PeerStatus, 0, len(f.peers)),
+	}
+	if f.client != nil {
+		s.Client = f.client.String()
+	}
+	if !f.expires.IsZero() && now.Before(f.expires) {
+		s.Expires = f.expires.Sub(now).Truncate(time.Second).String()
+	}
+	s.Permissions, s.Channels = f.grantStatus(now)
+	s.PeakTxBitrate, s.PeakRxBitrate = f.peakBitrates()
+
+	for _, p := range f.peers {
+		s.TxPackets += p.txPackets
+		s.TxBytes += p.txBytes
+		s.RxPackets += p.rxPackets
+		s.RxBytes += p.rxBytes
+		s.Peers = append(s.Peers, PeerStatus{
+			Peer:      p.addr,
+			TxPackets: p.txPackets,
+			TxBytes:   p.txBytes,
+			RxPackets: p.rxPackets,
+			RxBytes:   p.rxBytes,
+			Idle:      now.Sub(p.lastActive).Truncate(time.Millisecond).String(),
+		})
+	}
+	sort.Slice(s.Peers, func(i, j int) bool { return s.Peers[i].Peer < s.Peers[j].Peer })
+
+	return s
+}
+
+// Flows returns a snapshot of all the flows in the table, sorted by listener and relay address
+func (t *Table) Flows() []FlowStatus {
+	return t.FilterFlows(FlowFilter{})
+}
+
+// FilterFlows returns a snapshot of the flows in the table matching the filter, sorted by listener
+// and relay address
+func (t *Table) FilterFlows(filter FlowFilter) []FlowStatus {
+	t.lock.RLock()
+	flows := make([]*Flow, 0, len(t.flows))
+	for _, f := range t.flows {
+		flows = append(flows, f)
+	}
+	t.lock.RUnlock()
+
+	ret := make([]FlowStatus, 0, len(flows))
+	for _, f := range flows {
+		if s := f.Status(); filter.matches(s) {
+			ret = append(ret, s)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Listener != ret[j].Listener {
+			return ret[i].Listener < ret[j].Listener
+		}
+		return ret[i].Relay < ret[j].Relay
+	})
+
+	return ret
+}
+
+// Len returns the number of flows in the table
+func (t *Table) Len() int {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	return len(t.flows)
+}
+
+// SourceLen returns the number of flows of the clients with the given source IP address
+func (t *Table) SourceLen(ip net.IP) int {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	return t.sources[ip.String()]
+}
+
+// sourceKey returns the source IP address of a client as a string
+func sourceKey(client net.Addr) string {
+	switch a := client.(type) {
+	case *net.UDPAddr:
+		return a.IP.String()
+	case *net.TCPAddr:
+		return a.IP.String()
+	}
+	host, _, err := net.SplitHostPort(client.String())
+	if err != nil {
+		return client.String()
+	}
+	return host
+}
+
+// ListenerLen returns the number of flows of a listener
+func (t *Table) ListenerLen(listener string) int {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	n := 0
+	for _, f := range t.flows {
+		if f.listener == listener {
+			n++
+		}
+	}
+	return n
+}
+
+// Dump writes the content of the conntrack table to the log
+func (t *Table) Dump() {
+	t.lock.RLock()
+	flows := make([]*Flow, 0, len(t.flows))
+	for _, f := range t.flows {
+		flows = append(flows, f)
+	}
+	t.lock.RUnlock()
+
+	t.log.Infof("conntrack table: %d flows", len(flows))
+	for _, f := range flows {
+		t.log.Infof("flow: %s", f.String())
+	}
+}
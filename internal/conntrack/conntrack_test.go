@@ -0,0 +1,302 @@
+package conntrack
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pion/logging"
+	"github.com/pion/stun"
+	"github.com/pion/turn/v2"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/l7mp/stunner/internal/lifetime"
+	"github.com/l7mp/stunner/internal/stunmsg"
+	"github.com/l7mp/stunner/pkg/clock"
+)
+
+var testClient = &net.UDPAddr{IP: net.ParseIP("1.2.3.4"), Port: 5678}
+
+// testRelayAddressGenerator allocates relay transports on the loopback interface
+type testRelayAddressGenerator struct {
+	turn.RelayAddressGenerator
+}
+
+func (g *testRelayAddressGenerator) AllocatePacketConn(network string, requestedPort int) (net.PacketConn, net.Addr, error) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		return nil, nil, err
+	}
+	return conn, conn.LocalAddr(), nil
+}
+
+// testObserver collects the records reported by the table
+type testObserver struct {
+	lock    sync.Mutex
+	records []AccessLogRecord
+}
+
+func (o *testObserver) observe(r AccessLogRecord) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	o.records = append(o.records, r)
+}
+
+func (o *testObserver) events() []string {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	ret := []string{}
+	for _, r := range o.records {
+		ret = append(ret, r.Event)
+	}
+	return ret
+}
+
+func (o *testObserver) last() AccessLogRecord {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+	return o.records[len(o.records)-1]
+}
+
+func newTestTable() (*Table, *clock.Fake, *testObserver) {
+	table := NewTable(logging.NewDefaultLoggerFactory())
+	c := clock.NewFake(time.Unix(1700000000, 0))
+	table.SetClock(c)
+	o := &testObserver{}
+	table.SetObserver(o.observe)
+	return table, c, o
+}
+
+// relayedAddress is the XOR-RELAYED-ADDRESS attribute
+type relayedAddress stun.XORMappedAddress
+
+func (a relayedAddress) AddTo(m *stun.Message) error {
+	return stun.XORMappedAddress(a).AddToAs(m, stun.AttrXORRelayedAddress)
+}
+
+func testLifetime(d time.Duration) stun.RawAttribute {
+	v := make([]byte, 4)
+	binary.BigEndian.PutUint32(v, uint32(d/time.Second))
+	return stun.RawAttribute{Type: stun.AttrLifetime, Value: v}
+}
+
+func testChannelNumber(n uint16) stun.RawAttribute {
+	v := make([]byte, 4)
+	binary.BigEndian.PutUint16(v, n)
+	return stun.RawAttribute{Type: stun.AttrChannelNumber, Value: v}
+}
+
+// transact passes a request and the response through the tracker
+func transact(t *testing.T, tr *tracker, client net.Addr, method stun.Method, class stun.MessageClass, req []stun.Setter, res ...stun.Setter) {
+	m, err := stun.Build(append([]stun.Setter{stun.TransactionID,
+		stun.NewType(method, stun.ClassRequest)}, req...)...)
+	assert.NoError(t, err, "build request")
+	tr.inbound(m.Raw, stunmsg.Parse(m.Raw), client)
+
+	r, err := stun.Build(append([]stun.Setter{stun.NewTransactionIDSetter(m.TransactionID),
+		stun.NewType(method, class)}, res...)...)
+	assert.NoError(t, err, "build response")
+	tr.outbound(r.Raw, client, func([]byte) error { return nil })
+}
+
+// allocate creates a flow on the "udp" listener bound to the client with the given lifetime
+func allocate(t *testing.T, table *Table, tr *tracker, client net.Addr, d time.Duration) net.PacketConn {
+	gen := table.NewRelayAddressGenerator(&testRelayAddressGenerator{}, "udp")
+	relay, addr, err := gen.AllocatePacketConn("udp4", 0)
+	assert.NoError(t, err, "allocate relay")
+	a := addr.(*net.UDPAddr)
+	transact(t, tr, client, stun.MethodAllocate, stun.ClassSuccessResponse,
+		[]stun.Setter{stun.NewUsername("user1")},
+		relayedAddress{IP: a.IP, Port: a.Port}, testLifetime(d))
+	return relay
+}
+
+func TestFlowLifecycle(t *testing.T) {
+	table, c, o := newTestTable()
+	tr := newTracker(table, "udp", &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 3478})
+
+	// the flow is created with the relay transport, unbound
+	gen := table.NewRelayAddressGenerator(&testRelayAddressGenerator{}, "udp")
+	relay, addr, err := gen.AllocatePacketConn("udp4", 0)
+	assert.NoError(t, err, "allocate relay")
+	flows := table.Flows()
+	assert.Len(t, flows, 1, "flow created")
+	assert.Equal(t, addr.String(), flows[0].Relay, "relay")
+	assert.Equal(t, "", flows[0].Client, "unbound")
+	assert.Len(t, flows[0].SessionID, 8, "session ID")
+	assert.Equal(t, flows[0].SessionID, table.SessionID(addr), "session ID by relay")
+	assert.Equal(t, 0, table.SourceLen(testClient.IP), "no source before bound")
+
+	// the first Allocate request without credentials is challenged: nothing is bound
+	a := addr.(*net.UDPAddr)
+	transact(t, tr, testClient, stun.MethodAllocate, stun.ClassErrorResponse, nil,
+		stun.CodeUnauthorized)
+	assert.Equal(t, "", table.Flows()[0].Client, "challenge does not bind")
+	assert.Empty(t, o.events(), "challenge not reported")
+
+	// bound on the success response
+	for i := 0; i < 2; i++ {
+		// the retransmitted response rebinds the flow but it is not a new session
+		transact(t, tr, testClient, stun.MethodAllocate, stun.ClassSuccessResponse,
+			[]stun.Setter{stun.NewUsername("user1")},
+			relayedAddress{IP: a.IP, Port: a.Port}, testLifetime(10*time.Minute))
+	}
+	flows = table.Flows()
+	assert.Equal(t, testClient.String(), flows[0].Client, "bound")
+	assert.Equal(t, "user1", flows[0].Username, "username")
+	assert.Equal(t, "10m0s", flows[0].Expires, "expires")
+	assert.Equal(t, flows[0].SessionID, table.ClientSessionID("udp", testClient), "session by client")
+	assert.Equal(t, "", table.ClientSessionID("tcp", testClient), "other listener")
+	assert.Equal(t, "user1", table.ClientUsername("udp", testClient), "username by client")
+	assert.Equal(t, 1, table.SourceLen(testClient.IP), "source")
+	assert.Equal(t, 1, table.ListenerLen("udp"), "listener")
+	assert.Equal(t, []string{"start"}, o.events(), "session started once")
+
+	// the relayed traffic is accounted
+	peer, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	assert.NoError(t, err, "peer socket")
+	defer peer.Close()
+	_, err = relay.WriteTo([]byte("hello"), peer.LocalAddr())
+	assert.NoError(t, err, "write to peer")
+	_, err = peer.WriteTo([]byte("hi"), addr)
+	assert.NoError(t, err, "write to relay")
+	buf := make([]byte, 1500)
+	assert.NoError(t, relay.SetReadDeadline(time.Now().Add(5*time.Second)), "deadline")
+	_, _, err = relay.ReadFrom(buf)
+	assert.NoError(t, err, "read from peer")
+
+	c.Advance(2 * time.Second)
+	s := table.Flows()[0]
+	assert.Equal(t, "2s", s.Age, "age")
+	assert.Equal(t, "9m58s", s.Expires, "expiry counts down")
+	assert.Equal(t, uint64(1), s.TxPackets, "tx packets")
+	assert.Equal(t, uint64(5), s.TxBytes, "tx bytes")
+	assert.Equal(t, uint64(1), s.RxPackets, "rx packets")
+	assert.Equal(t, uint64(2), s.RxBytes, "rx bytes")
+	assert.Len(t, s.Peers, 1, "peers")
+	assert.Equal(t, peer.LocalAddr().String(), s.Peers[0].Peer, "peer")
+
+	// a refresh extends the allocation
+	transact(t, tr, testClient, stun.MethodRefresh, stun.ClassSuccessResponse,
+		[]stun.Setter{testLifetime(time.Hour)}, testLifetime(time.Hour))
+	assert.Equal(t, "1h0m0s", table.Flows()[0].Expires, "refreshed")
+
+	// a zero-lifetime refresh deletes the allocation, the flow is removed with the relay
+	transact(t, tr, testClient, stun.MethodRefresh, stun.ClassSuccessResponse,
+		[]stun.Setter{testLifetime(0)}, testLifetime(0))
+	assert.NoError(t, relay.Close(), "close relay")
+	assert.Equal(t, 0, table.Len(), "flow removed")
+	assert.Equal(t, "", table.SessionID(addr), "session removed")
+	assert.Equal(t, "", table.ClientSessionID("udp", testClient), "client removed")
+	assert.Equal(t, 0, table.SourceLen(testClient.IP), "source removed")
+
+	assert.Equal(t, []string{"start", "stop"}, o.events(), "session stopped")
+	r := o.last()
+	assert.Equal(t, TeardownClient, r.Reason, "reason")
+	assert.Equal(t, uint64(5), r.TxBytes, "tx bytes")
+	assert.Equal(t, uint64(2), r.RxBytes, "rx bytes")
+	assert.Equal(t, 2.0, r.Duration, "duration")
+
+	// closing twice does not report again
+	relay.Close()
+	assert.Equal(t, []string{"start", "stop"}, o.events(), "stopped once")
+}
+
+func TestFlowAuthFailure(t *testing.T) {
+	table, _, o := newTestTable()
+	tr := newTracker(table, "udp", &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 3478})
+
+	transact(t, tr, testClient, stun.MethodAllocate, stun.ClassErrorResponse,
+		[]stun.Setter{stun.NewUsername("user1")}, stun.CodeUnauthorized)
+	assert.Equal(t, []string{"auth-failure"}, o.events(), "auth failure reported")
+	assert.Equal(t, "user1", o.last().Username, "username")
+	assert.Equal(t, testClient.String(), o.last().Client, "client")
+
+	// other errors are not authentication failures
+	transact(t, tr, testClient, stun.MethodAllocate, stun.ClassErrorResponse,
+		[]stun.Setter{stun.NewUsername("user1")}, stun.CodeAllocQuotaReached)
+	assert.Equal(t, []string{"auth-failure"}, o.events(), "quota not reported")
+}
+
+func TestFlowTeardownReason(t *testing.T) {
+	for _, c := range []struct {
+		name, reason string
+		teardown     func(table *Table, c *clock.Fake)
+	}{
+		{"expiry", TeardownExpiry, func(_ *Table, c *clock.Fake) { c.Advance(time.Minute) }},
+		{"drain", TeardownDrain, func(table *Table, _ *clock.Fake) { table.Terminating() }},
+		{"error", TeardownError, func(_ *Table, c *clock.Fake) { c.Advance(time.Second) }},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			table, clk, o := newTestTable()
+			tr := newTracker(table, "udp", &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 3478})
+			relay := allocate(t, table, tr, testClient, time.Minute)
+
+			c.teardown(table, clk)
+			relay.Close()
+			assert.Equal(t, 0, table.Len(), "flow removed")
+			assert.Equal(t, c.reason, o.last().Reason, "reason")
+		})
+	}
+}
+
+func TestFlowGrants(t *testing.T) {
+	table, c, _ := newTestTable()
+	table.SetLifetimes(func(listener string) lifetime.Policy {
+		return lifetime.Policy{Permission: time.Minute, Channel: 2 * time.Minute}
+	})
+	tr := newTracker(table, "udp", &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 3478})
+	relay := allocate(t, table, tr, testClient, 10*time.Minute)
+	defer relay.Close()
+
+	peerA, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	assert.NoError(t, err, "peer socket")
+	defer peerA.Close()
+	a := peerA.LocalAddr().(*net.UDPAddr)
+	peerB := &net.UDPAddr{IP: net.ParseIP("1.2.3.5"), Port: 1234}
+
+	// a failed request grants nothing
+	transact(t, tr, testClient, stun.MethodCreatePermission, stun.ClassErrorResponse,
+		[]stun.Setter{peerAddress{IP: a.IP, Port: a.Port}}, stun.CodeForbidden)
+	assert.Empty(t, table.Flows()[0].Permissions, "no permission")
+
+	transact(t, tr, testClient, stun.MethodCreatePermission, stun.ClassSuccessResponse,
+		[]stun.Setter{peerAddress{IP: a.IP, Port: a.Port}})
+	transact(t, tr, testClient, stun.MethodChannelBind, stun.ClassSuccessResponse,
+		[]stun.Setter{peerAddress{IP: peerB.IP, Port: peerB.Port}, testChannelNumber(0x4000)})
+
+	s := table.Flows()[0]
+	assert.Equal(t, []PermissionStatus{{Peer: "1.2.3.5", Expires: "1m0s"},
+		{Peer: "127.0.0.1", Expires: "1m0s"}}, s.Permissions, "permissions")
+	assert.Equal(t, []ChannelStatus{{Number: 0x4000, Peer: peerB.String(), Expires: "2m0s"}},
+		s.Channels, "channels")
+	prefix := &net.IPNet{IP: net.ParseIP("1.2.3.0"), Mask: net.CIDRMask(24, 32)}
+	assert.Len(t, table.FilterFlows(FlowFilter{Peer: prefix}), 1, "filter by peer")
+
+	// the permissions expire before the channel binding, the traffic of the expired
+	// permissions is dropped
+	c.Advance(90 * time.Second)
+	s = table.Flows()[0]
+	assert.Empty(t, s.Permissions, "permissions expired")
+	assert.Len(t, s.Channels, 1, "channel kept")
+	_, err = relay.WriteTo([]byte("hello"), peerA.LocalAddr())
+	assert.NoError(t, err, "write dropped silently")
+	assert.Equal(t, uint64(0), table.Flows()[0].TxPackets, "dropped write not accounted")
+	channelData := []byte{0x40, 0x00, 0x00, 0x00}
+	assert.False(t, tr.channelExpired(stunmsg.Parse(channelData), testClient), "channel alive")
+
+	c.Advance(time.Minute)
+	assert.True(t, tr.channelExpired(stunmsg.Parse(channelData), testClient), "channel expired")
+	assert.Empty(t, table.Flows()[0].Channels, "channel expired")
+
+	// the expired grants are removed once the TURN server dropped them too
+	c.Advance(lifetime.DefaultChannel)
+	table.Flows()
+	f := table.clientFlow("udp", testClient)
+	f.lock.Lock()
+	assert.Empty(t, f.permissions, "permissions removed")
+	assert.Empty(t, f.channels, "channels removed")
+	f.lock.Unlock()
+}
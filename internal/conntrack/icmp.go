@@ -0,0 +1,64 @@
+package conntrack
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/pion/stun"
+)
+
+// attrICMP is the ICMP attribute of RFC 8656 Section 18.13
+const attrICMP stun.AttrType = 0x8004
+
+var errUnknownFlow = errors.New("unknown flow")
+
+// NotifyICMP forwards an ICMP error received on a relay transport to the client of the flow in a
+// Data indication carrying an ICMP attribute, as per RFC 8656 Section 11.5. Errors for peers the
+// client has not sent traffic to are dropped.
+func (t *Table) NotifyICMP(relay net.Addr, peer *net.UDPAddr, icmpType, icmpCode uint8, data uint32) error {
+	t.lock.RLock()
+	f, found := t.flows[relay.String()]
+	t.lock.RUnlock()
+	if !found {
+		return errUnknownFlow
+	}
+
+	k, _ := newPeerKey(peer)
+	f.lock.Lock()
+	_, known := f.peers[k]
+	send, client := f.send, f.client
+	f.lock.Unlock()
+
+	if !known {
+		return fmt.Errorf("no traffic sent to peer %s", peer)
+	}
+	if send == nil {
+		return fmt.Errorf("flow not bound to a client")
+	}
+
+	// Reserved (18 bits), ICMP Type (7 bits), ICMP Code (7 bits), Error Data (32 bits)
+	v := make([]byte, 8)
+	binary.BigEndian.PutUint32(v[0:4], uint32(icmpType&0x7f)<<7|uint32(icmpCode&0x7f))
+	binary.BigEndian.PutUint32(v[4:8], data)
+
+	m, err := stun.Build(stun.TransactionID, stun.NewType(stun.MethodData, stun.ClassIndication),
+		peerAddress{IP: peer.IP, Port: peer.Port},
+		stun.RawAttribute{Type: attrICMP, Value: v})
+	if err != nil {
+		return err
+	}
+
+	t.log.Debugf("forwarding ICMP error (type %d, code %d) from peer %s to client %s, "+
+		"session %s", icmpType, icmpCode, peer, client, f.id)
+
+	return send(m.Raw)
+}
+
+// peerAddress is the XOR-PEER-ADDRESS attribute
+type peerAddress stun.XORMappedAddress
+
+func (a peerAddress) AddTo(m *stun.Message) error {
+	return stun.XORMappedAddress(a).AddToAs(m, stun.AttrXORPeerAddress)
+}
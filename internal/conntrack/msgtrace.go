@@ -0,0 +1,167 @@
+package conntrack
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/pion/stun"
+)
+
+// MessageTrace selects the STUN/TURN messages on the listener sockets that are logged with their
+// decoded attributes: the messages of a sample of the transactions, and the messages of the
+// selected users and clients. Send and Data indications are never logged
+type MessageTrace struct {
+	threshold uint64
+	usernames map[string]bool
+	clients   []*net.IPNet
+}
+
+// messageTraceHolder wraps the message trace so that atomic.Value can store nil
+type messageTraceHolder struct{ trace *MessageTrace }
+
+// NewMessageTrace creates a message trace that logs the given ratio of the transactions, and the
+// messages of the given users and of the clients in the given prefixes
+func NewMessageTrace(ratio float64, usernames []string, clients []*net.IPNet) *MessageTrace {
+	mt := &MessageTrace{usernames: map[string]bool{}, clients: clients}
+	switch {
+	case ratio >= 1:
+		mt.threshold = ^uint64(0)
+	case ratio > 0:
+		mt.threshold = uint64(ratio * float64(^uint64(0)))
+	}
+	for _, u := range usernames {
+		mt.usernames[u] = true
+	}
+	return mt
+}
+
+// sampled returns true if the transaction is in the sample: the request and the response of a
+// transaction are sampled together
+func (mt *MessageTrace) sampled(id [stun.TransactionIDSize]byte) bool {
+	return mt.threshold > 0 && binary.BigEndian.Uint64(id[:8]) <= mt.threshold
+}
+
+// matchesClient returns true if the client is selected
+func (mt *MessageTrace) matchesClient(client net.Addr) bool {
+	if len(mt.clients) == 0 || client == nil {
+		return false
+	}
+	host, _, err := net.SplitHostPort(client.String())
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	for _, p := range mt.clients {
+		if ip != nil && p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetMessageTrace sets the messages to be logged, nil disables message tracing
+func (t *Table) SetMessageTrace(mt *MessageTrace) {
+	t.msgTrace.Store(messageTraceHolder{mt})
+}
+
+// GetMessageTrace returns the message trace of the table, or nil if message tracing is disabled
+func (t *Table) GetMessageTrace() *MessageTrace {
+	h, ok := t.msgTrace.Load().(messageTraceHolder)
+	if !ok {
+		return nil
+	}
+	return h.trace
+}
+
+// traceMessage logs a message received from or sent to a client if it is selected by the message
+// trace
+func (t *tracker) traceMessage(mt *MessageTrace, b []byte, client net.Addr, dir string) {
+	m := &stun.Message{Raw: append([]byte{}, b...)}
+	if err := m.Decode(); err != nil {
+		return
+	}
+
+	if !mt.sampled(m.TransactionID) && !mt.matchesClient(client) &&
+		!(len(mt.usernames) > 0 && mt.usernames[t.messageUsername(m, client)]) {
+		return
+	}
+
+	session := ""
+	if client != nil {
+		if id := t.table.ClientSessionID(t.listener, client); id != "" {
+			session = " session=" + id
+		}
+	}
+	t.table.msgLog.Infof("listener=%s%s %s client=%s %s", t.listener, session, dir, client,
+		describeMessage(m))
+}
+
+// messageUsername returns the username of a message: the USERNAME attribute of requests, or the
+// username of the pending Allocate transaction or of the allocation of the client
+func (t *tracker) messageUsername(m *stun.Message, client net.Addr) string {
+	var username stun.Username
+	if err := username.GetFrom(m); err == nil {
+		return username.String()
+	}
+
+	t.lock.Lock()
+	u, found := t.pending[m.TransactionID]
+	t.lock.Unlock()
+	if found {
+		return u
+	}
+
+	if client == nil {
+		return ""
+	}
+	if f := t.table.clientFlow(t.listener, client); f != nil {
+		f.lock.Lock()
+		defer f.lock.Unlock()
+		return f.username
+	}
+	return ""
+}
+
+// describeMessage returns the type, the transaction ID and the decoded attributes of a message.
+// The values of the attributes that are not decoded, like MESSAGE-INTEGRITY, are omitted
+func describeMessage(m *stun.Message) string {
+	attrs := make([]string, 0, len(m.Attributes))
+	for _, a := range m.Attributes {
+		attrs = append(attrs, fmt.Sprintf("%s=%s", a.Type, describeAttribute(m, a)))
+	}
+	return fmt.Sprintf("type=%q txid=%s attrs=[%s]", m.Type.String(),
+		hex.EncodeToString(m.TransactionID[:]), strings.Join(attrs, " "))
+}
+
+func describeAttribute(m *stun.Message, a stun.RawAttribute) string {
+	switch a.Type {
+	case stun.AttrUsername, stun.AttrRealm, stun.AttrNonce, stun.AttrSoftware:
+		return fmt.Sprintf("%q", string(a.Value))
+	case stun.AttrLifetime:
+		if d, ok := lifetimeAttr(m); ok {
+			return d.String()
+		}
+	case stun.AttrXORMappedAddress, stun.AttrXORPeerAddress, stun.AttrXORRelayedAddress:
+		var addr stun.XORMappedAddress
+		if err := addr.GetFromAs(m, a.Type); err == nil {
+			return addr.String()
+		}
+	case stun.AttrErrorCode:
+		var code stun.ErrorCodeAttribute
+		if err := code.GetFrom(m); err == nil {
+			return fmt.Sprintf("%q", code.String())
+		}
+	case stun.AttrChannelNumber:
+		if len(a.Value) >= 2 {
+			return fmt.Sprintf("0x%04x", binary.BigEndian.Uint16(a.Value))
+		}
+	case stun.AttrRequestedTransport:
+		if len(a.Value) >= 1 {
+			return fmt.Sprintf("%d", a.Value[0])
+		}
+	}
+	return fmt.Sprintf("<%d bytes>", len(a.Value))
+}
@@ -0,0 +1,300 @@
+package conntrack
+
+import (
+	"encoding/binary"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/pion/stun"
+
+	"github.com/l7mp/stunner/internal/lifetime"
+	"github.com/l7mp/stunner/internal/monitoring"
+	"github.com/l7mp/stunner/internal/stunmsg"
+)
+
+// LifetimeFunc returns the lifetimes of the permissions and the channel bindings of a listener
+type LifetimeFunc func(listener string) lifetime.Policy
+
+// lifetimeHolder wraps the lifetime callback so that atomic.Value can store nil
+type lifetimeHolder struct{ lifetimes LifetimeFunc }
+
+// SetLifetimes sets the callback returning the lifetimes of the permissions and the channel
+// bindings of the listeners, nil means the defaults of the TURN server
+func (t *Table) SetLifetimes(f LifetimeFunc) {
+	t.lifetimes.Store(lifetimeHolder{f})
+}
+
+func (t *Table) getLifetimes(listener string) lifetime.Policy {
+	h, ok := t.lifetimes.Load().(lifetimeHolder)
+	if !ok || h.lifetimes == nil {
+		return lifetime.Policy{}
+	}
+	return h.lifetimes(listener)
+}
+
+// grant is a CreatePermission or ChannelBind request waiting for a response
+type grant struct {
+	peers   []net.IP
+	channel uint16
+	peer    string
+}
+
+// permission is a permission of a flow
+type permission struct {
+	granted, expires time.Time
+}
+
+// channel is a channel binding of a flow
+type channel struct {
+	peer             string
+	granted, expires time.Time
+}
+
+// requestGrant remembers the peers of a CreatePermission or ChannelBind request
+func (t *tracker) requestGrant(m *stun.Message) {
+	g := grant{}
+	for _, a := range m.Attributes {
+		if a.Type != stun.AttrXORPeerAddress {
+			continue
+		}
+		// a CreatePermission request may carry multiple peers but XORMappedAddress decodes
+		// the first one only
+		var peer stun.XORMappedAddress
+		tmp := &stun.Message{TransactionID: m.TransactionID, Attributes: stun.Attributes{a}}
+		if err := peer.GetFromAs(tmp, stun.AttrXORPeerAddress); err != nil {
+			continue
+		}
+		g.peers = append(g.peers, peer.IP)
+		g.peer = peer.String()
+	}
+	if len(g.peers) == 0 {
+		return
+	}
+
+	if m.Type.Method == stun.MethodChannelBind {
+		// CHANNEL-NUMBER: 16 bit channel number and 16 bit RFFU
+		v, err := m.Get(stun.AttrChannelNumber)
+		if err != nil || len(v) != 4 {
+			return
+		}
+		g.channel = binary.BigEndian.Uint16(v[0:2])
+	}
+
+	t.lock.Lock()
+	if len(t.grants) >= maxPendingTransactions {
+		t.grants = make(map[[stun.TransactionIDSize]byte]grant)
+	}
+	t.grants[m.TransactionID] = g
+	t.lock.Unlock()
+}
+
+// commitGrant counts the result of a CreatePermission or ChannelBind request and records the
+// permissions and the channel granted by a success response on the flow of the client
+func (t *tracker) commitGrant(m *stun.Message, client net.Addr) {
+	t.lock.Lock()
+	g, found := t.grants[m.TransactionID]
+	delete(t.grants, m.TransactionID)
+	t.lock.Unlock()
+
+	if !found {
+		return
+	}
+
+	method := monitoring.MethodCreatePermission
+	if m.Type.Method == stun.MethodChannelBind {
+		method = monitoring.MethodChannelBind
+	}
+	if m.Type.Class != stun.ClassSuccessResponse {
+		var code stun.ErrorCodeAttribute
+		if err := code.GetFrom(m); err == nil {
+			monitoring.ObserveRequest(t.listener, method, monitoring.ResultForCode(int(code.Code)))
+		}
+		return
+	}
+	monitoring.ObserveRequest(t.listener, method, monitoring.ResultSuccess)
+
+	f := t.table.clientFlow(t.listener, client)
+	if f == nil {
+		return
+	}
+
+	// a channel binding also installs or refreshes a permission for the peer, the refresh
+	// interval is reported for the refreshed channel only
+	lt := t.table.getLifetimes(t.listener)
+	now := t.table.clock.Now()
+	f.lock.Lock()
+	for _, ip := range g.peers {
+		if p, ok := f.permissions[ip.String()]; ok && m.Type.Method == stun.MethodCreatePermission {
+			monitoring.RefreshIntervalHistogram.WithLabelValues(t.listener, "permission").Observe(
+				now.Sub(p.granted).Seconds())
+		}
+		f.permissions[ip.String()] = &permission{granted: now,
+			expires: now.Add(lt.PermissionLifetime())}
+	}
+	if m.Type.Method == stun.MethodChannelBind {
+		if c, ok := f.channels[g.channel]; ok {
+			monitoring.RefreshIntervalHistogram.WithLabelValues(t.listener, "channel").Observe(
+				now.Sub(c.granted).Seconds())
+		}
+		f.channels[g.channel] = &channel{peer: g.peer, granted: now,
+			expires: now.Add(lt.ChannelLifetime())}
+	}
+	f.lock.Unlock()
+}
+
+// permissionExpired returns true if the permission of a flow for a peer has expired while the TURN
+// server still holds it, i.e., the permissions of the listener have a lifetime shorter than the
+// default of the TURN server
+func (t *Table) permissionExpired(f *Flow, peer net.Addr) bool {
+	if t.getLifetimes(f.listener).PermissionLifetime() >= lifetime.DefaultPermission {
+		return false
+	}
+	var ip net.IP
+	switch a := peer.(type) {
+	case *net.UDPAddr:
+		ip = a.IP
+	case *net.TCPAddr:
+		ip = a.IP
+	default:
+		return false
+	}
+
+	now := t.clock.Now()
+	f.lock.Lock()
+	p, ok := f.permissions[ip.String()]
+	f.lock.Unlock()
+	if !ok || now.Before(p.expires) {
+		return false
+	}
+	monitoring.ExpiredGrantDropCounter.WithLabelValues(f.listener, "permission").Inc()
+	return true
+}
+
+// channelExpired returns true if a message, with the header h, received from a client is a
+// ChannelData message on a channel binding that has expired while the TURN server still holds it
+func (t *tracker) channelExpired(h stunmsg.Header, client net.Addr) bool {
+	if h.Kind != stunmsg.ChannelData ||
+		t.table.getLifetimes(t.listener).ChannelLifetime() >= lifetime.DefaultChannel {
+		return false
+	}
+	f := t.table.clientFlow(t.listener, client)
+	if f == nil {
+		return false
+	}
+
+	now := t.table.clock.Now()
+	f.lock.Lock()
+	c, ok := f.channels[h.Type]
+	f.lock.Unlock()
+	if !ok || now.Before(c.expires) {
+		return false
+	}
+	monitoring.ExpiredGrantDropCounter.WithLabelValues(t.listener, "channel").Inc()
+	return true
+}
+
+// clientFlow returns the flow of a client on a listener, or nil if the client has no allocation
+func (t *Table) clientFlow(listener string, client net.Addr) *Flow {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	return t.clients[sessionKey(listener, client.String())]
+}
+
+// PermissionStatus is a permission of a flow
+type PermissionStatus struct {
+	Peer    string `json:"peer"`
+	Expires string `json:"expires"`
+}
+
+// ChannelStatus is a channel binding of a flow
+type ChannelStatus struct {
+	Number  uint16 `json:"number"`
+	Peer    string `json:"peer"`
+	Expires string `json:"expires"`
+}
+
+// grantStatus returns the active permissions and channels of a flow, must be called with the flow
+// locked. Expired grants are kept until the TURN server drops them, so that their traffic is
+// dropped until then
+func (f *Flow) grantStatus(now time.Time) ([]PermissionStatus, []ChannelStatus) {
+	perms := []PermissionStatus{}
+	for ip, p := range f.permissions {
+		if p.expires.Before(now) {
+			if now.Sub(p.granted) >= lifetime.DefaultPermission {
+				delete(f.permissions, ip)
+			}
+			continue
+		}
+		perms = append(perms, PermissionStatus{Peer: ip,
+			Expires: p.expires.Sub(now).Truncate(time.Second).String()})
+	}
+	sort.Slice(perms, func(i, j int) bool { return perms[i].Peer < perms[j].Peer })
+
+	chans := []ChannelStatus{}
+	for n, c := range f.channels {
+		if c.expires.Before(now) {
+			if now.Sub(c.granted) >= lifetime.DefaultChannel {
+				delete(f.channels, n)
+			}
+			continue
+		}
+		chans = append(chans, ChannelStatus{Number: n, Peer: c.peer,
+			Expires: c.expires.Sub(now).Truncate(time.Second).String()})
+	}
+	sort.Slice(chans, func(i, j int) bool { return chans[i].Number < chans[j].Number })
+
+	return perms, chans
+}
+
+// FlowFilter selects flows, empty fields match all flows
+type FlowFilter struct {
+	// SessionID matches the flow with the session ID
+	SessionID string
+	// Username matches the flows of a user
+	Username string
+	// Listener matches the flows of a listener
+	Listener string
+	// Peer matches the flows with a permission, a channel binding or traffic to a peer in the
+	// prefix
+	Peer *net.IPNet
+}
+
+// matches returns true if the status of a flow matches the filter
+func (ff FlowFilter) matches(s FlowStatus) bool {
+	if ff.SessionID != "" && s.SessionID != ff.SessionID {
+		return false
+	}
+	if ff.Username != "" && s.Username != ff.Username {
+		return false
+	}
+	if ff.Listener != "" && s.Listener != ff.Listener {
+		return false
+	}
+	if ff.Peer == nil {
+		return true
+	}
+
+	for _, p := range s.Permissions {
+		if ip := net.ParseIP(p.Peer); ip != nil && ff.Peer.Contains(ip) {
+			return true
+		}
+	}
+	peers := make([]string, 0, len(s.Channels)+len(s.Peers))
+	for _, c := range s.Channels {
+		peers = append(peers, c.Peer)
+	}
+	for _, p := range s.Peers {
+		peers = append(peers, p.Peer)
+	}
+	for _, p := range peers {
+		host, _, err := net.SplitHostPort(p)
+		if err != nil {
+			continue
+		}
+		if ip := net.ParseIP(host); ip != nil && ff.Peer.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
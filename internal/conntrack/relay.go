@@ -0,0 +1,72 @@
+package conntrack
+
+import (
+	"net"
+	"sync"
+
+	"github.com/pion/turn/v2"
+)
+
+// relayAddressGenerator wraps a TURN relay address generator so that each relay transport it
+// allocates gets a flow in the conntrack table
+type relayAddressGenerator struct {
+	turn.RelayAddressGenerator
+	listener string
+	table    *Table
+}
+
+// NewRelayAddressGenerator wraps the relay address generator of a listener
+func (t *Table) NewRelayAddressGenerator(gen turn.RelayAddressGenerator, listener string) turn.RelayAddressGenerator {
+	return &relayAddressGenerator{RelayAddressGenerator: gen, listener: listener, table: t}
+}
+
+func (r *relayAddressGenerator) AllocatePacketConn(network string, requestedPort int) (net.PacketConn, net.Addr, error) {
+	conn, addr, err := r.RelayAddressGenerator.AllocatePacketConn(network, requestedPort)
+	if err != nil {
+		return conn, addr, err
+	}
+
+	f := r.table.newFlow(r.listener, addr)
+	return &relayConn{PacketConn: conn, flow: f, table: r.table}, addr, nil
+}
+
+// relayConn is a relay transport: packets written to it go from the client to a peer, packets
+// read from it go from a peer to the client
+type relayConn struct {
+	net.PacketConn
+	flow      *Flow
+	table     *Table
+	closeOnce sync.Once
+}
+
+// ReadFrom drops the packets of the peers whose permission has expired
+func (c *relayConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		n, addr, err := c.PacketConn.ReadFrom(b)
+		if err != nil {
+			return n, addr, err
+		}
+		if c.table.permissionExpired(c.flow, addr) {
+			continue
+		}
+		c.flow.account(addr, n, false)
+		return n, addr, err
+	}
+}
+
+// WriteTo silently drops the packets to the peers whose permission has expired
+func (c *relayConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if c.table.permissionExpired(c.flow, addr) {
+		return len(b), nil
+	}
+	n, err := c.PacketConn.WriteTo(b, addr)
+	if err == nil {
+		c.flow.account(addr, n, true)
+	}
+	return n, err
+}
+
+func (c *relayConn) Close() error {
+	c.closeOnce.Do(func() { c.table.deleteFlow(c.flow) })
+	return c.PacketConn.Close()
+}
@@ -0,0 +1,152 @@
+package conntrack
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/pion/stun"
+
+	"github.com/l7mp/stunner/internal/monitoring"
+)
+
+// Teardown reasons of the allocations
+const (
+	// TeardownExpiry: the allocation was not refreshed in time
+	TeardownExpiry = "expiry"
+	// TeardownClient: the client deleted the allocation with a zero-lifetime Refresh
+	TeardownClient = "client"
+	// TeardownDrain: the allocation was terminated because the server was stopped or restarted
+	TeardownDrain = "drain"
+	// TeardownError: the relay transport failed or the allocation was closed for another reason
+	TeardownError = "error"
+)
+
+const (
+	// teardownWindow is the time a zero-lifetime Refresh request is expected to delete the
+	// allocation in
+	teardownWindow = 5 * time.Second
+	// expirySlack accounts for the timer of the allocation firing slightly before the expiry
+	// computed from the responses
+	expirySlack = time.Second
+)
+
+var (
+	refreshRequest  = stun.NewType(stun.MethodRefresh, stun.ClassRequest).Value()
+	refreshResponse = stun.NewType(stun.MethodRefresh, stun.ClassSuccessResponse).Value()
+)
+
+// Terminating marks the active flows as terminated by the server, e.g., before the server is
+// stopped or restarted, which closes the allocations in the background
+func (t *Table) Terminating() {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	for _, f := range t.flows {
+		f.lock.Lock()
+		f.terminating = true
+		f.lock.Unlock()
+	}
+}
+
+// lifetimeAttr returns the LIFETIME attribute of a message, if any
+func lifetimeAttr(m *stun.Message) (time.Duration, bool) {
+	v, err := m.Get(stun.AttrLifetime)
+	if err != nil || len(v) != 4 {
+		return 0, false
+	}
+	return time.Duration(binary.BigEndian.Uint32(v)) * time.Second, true
+}
+
+// refreshed updates the expiry of the flow of the client from the LIFETIME of an Allocate or
+// Refresh success response
+func (t *tracker) refreshed(m *stun.Message, client net.Addr) {
+	d, ok := lifetimeAttr(m)
+	if !ok || d == 0 {
+		return
+	}
+	if f := t.table.clientFlow(t.listener, client); f != nil {
+		now := t.table.clock.Now()
+		f.lock.Lock()
+		if !f.refreshed.IsZero() {
+			monitoring.RefreshIntervalHistogram.WithLabelValues(t.listener, "allocation").Observe(
+				now.Sub(f.refreshed).Seconds())
+		}
+		f.refreshed = now
+		f.expires = now.Add(d)
+		f.lock.Unlock()
+	}
+}
+
+// teardownRequested remembers a zero-lifetime Refresh request of the client, which deletes the
+// allocation
+func (t *tracker) teardownRequested(m *stun.Message, client net.Addr) {
+	if d, ok := lifetimeAttr(m); !ok || d != 0 {
+		return
+	}
+	if f := t.table.clientFlow(t.listener, client); f != nil {
+		f.lock.Lock()
+		f.teardown = t.table.clock.Now()
+		f.lock.Unlock()
+	}
+}
+
+// teardownReason tells why a flow is deleted
+func (t *Table) teardownReason(f *Flow, now time.Time) string {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if f.terminating {
+		return TeardownDrain
+	}
+
+	if !f.teardown.IsZero() && now.Sub(f.teardown) < teardownWindow {
+		return TeardownClient
+	}
+	if !f.expires.IsZero() && !now.Before(f.expires.Add(-expirySlack)) {
+		return TeardownExpiry
+	}
+	return TeardownError
+}
+
+// accountRate updates the peak bitrates of the flow over one-second intervals, must be called
+// with the flow locked
+func (f *Flow) accountRate(now time.Time, n int, tx bool) {
+	sec := now.Unix()
+	if sec != f.rateSecond {
+		f.peakTx, f.peakRx = f.peakBitrates()
+		f.rateSecond, f.rateTx, f.rateRx = sec, 0, 0
+	}
+	if tx {
+		f.rateTx += uint64(n)
+	} else {
+		f.rateRx += uint64(n)
+	}
+}
+
+// peakBitrates returns the peak bitrates of the flow in bits per second, including the last
+// interval, must be called with the flow locked
+func (f *Flow) peakBitrates() (uint64, uint64) {
+	tx, rx := f.peakTx, f.peakRx
+	if f.rateTx*8 > tx {
+		tx = f.rateTx * 8
+	}
+	if f.rateRx*8 > rx {
+		rx = f.rateRx * 8
+	}
+	return tx, rx
+}
+
+// summary returns the end-of-session summary of a stop record as "key=value" pairs
+func (r AccessLogRecord) summary() string {
+	client, user := r.Client, r.Username
+	if client == "" {
+		client = "<unbound>"
+	}
+	return fmt.Sprintf("session=%s listener=%s client=%s relay=%s user=%q duration=%.3fs "+
+		"tx_bytes=%d rx_bytes=%d peak_tx_bps=%d peak_rx_bps=%d peers=[%s] reason=%s",
+		r.SessionID, r.Listener, client, r.Relay, user, r.Duration, r.TxBytes, r.RxBytes,
+		r.PeakTxBitrate, r.PeakRxBitrate, strings.Join(r.Peers, ","), r.Reason)
+}
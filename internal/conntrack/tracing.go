@@ -0,0 +1,113 @@
+package conntrack
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+
+	"github.com/pion/stun"
+
+	"github.com/l7mp/stunner/internal/tracing"
+)
+
+// tracedMethods are the TURN transactions exported as spans
+var tracedMethods = map[stun.Method]bool{
+	stun.MethodAllocate:         true,
+	stun.MethodRefresh:          true,
+	stun.MethodCreatePermission: true,
+	stun.MethodChannelBind:      true,
+}
+
+// tracerHolder wraps the tracer so that atomic.Value can store nil
+type tracerHolder struct{ tracer *tracing.Tracer }
+
+// SetTracer sets the tracer the TURN transactions on the listener sockets are reported to, nil
+// disables tracing
+func (t *Table) SetTracer(tr *tracing.Tracer) {
+	t.tracer.Store(tracerHolder{tr})
+}
+
+// GetTracer returns the tracer of the table, or nil if tracing is disabled
+func (t *Table) GetTracer() *tracing.Tracer {
+	h, ok := t.tracer.Load().(tracerHolder)
+	if !ok {
+		return nil
+	}
+	return h.tracer
+}
+
+// sessionKey identifies the session of a client on a listener, the spans of a session share a trace
+func sessionKey(listener, client string) string {
+	return fmt.Sprintf("%s/%s", listener, client)
+}
+
+// startSpan starts a span for a TURN request received from a client
+func (t *tracker) startSpan(m *stun.Message, client net.Addr) {
+	tr := t.table.GetTracer()
+	if tr == nil || client == nil || !tracedMethods[m.Type.Method] {
+		return
+	}
+
+	span := tr.StartSpan(sessionKey(t.listener, client.String()), "TURN "+m.Type.Method.String())
+	if span == nil {
+		return
+	}
+	span.SetAttribute("stunner.listener", t.listener)
+	span.SetAttribute("client.address", client.String())
+	if id := t.table.ClientSessionID(t.listener, client); id != "" {
+		span.SetAttribute("stunner.session_id", id)
+	}
+
+	var username stun.Username
+	if err := username.GetFrom(m); err == nil {
+		span.SetAttribute("turn.username", username.String())
+	}
+	var peer stun.XORMappedAddress
+	if err := peer.GetFromAs(m, stun.AttrXORPeerAddress); err == nil {
+		span.SetAttribute("turn.peer.address", peer.String())
+	}
+	if lifetime, err := m.Get(stun.AttrLifetime); err == nil && len(lifetime) == 4 {
+		span.SetAttribute("turn.lifetime", strconv.FormatUint(uint64(lifetime[0])<<24|
+			uint64(lifetime[1])<<16|uint64(lifetime[2])<<8|uint64(lifetime[3]), 10))
+	}
+
+	t.lock.Lock()
+	if len(t.spans) >= maxPendingTransactions {
+		t.spans = make(map[[stun.TransactionIDSize]byte]*tracing.Span)
+	}
+	t.spans[m.TransactionID] = span
+	t.lock.Unlock()
+}
+
+// endSpan ends the span of a TURN request when the response is sent to the client
+func (t *tracker) endSpan(m *stun.Message) {
+	t.lock.Lock()
+	span, found := t.spans[m.TransactionID]
+	delete(t.spans, m.TransactionID)
+	t.lock.Unlock()
+
+	if !found {
+		return
+	}
+
+	if m.Type.Class == stun.ClassErrorResponse {
+		var code stun.ErrorCodeAttribute
+		if err := code.GetFrom(m); err != nil {
+			span.End("error response")
+			return
+		}
+		span.SetAttribute("turn.error_code", strconv.Itoa(int(code.Code)))
+		msg := fmt.Sprintf("error code %d", code.Code)
+		if len(code.Reason) > 0 {
+			msg = fmt.Sprintf("%s: %s", msg, string(code.Reason))
+		}
+		span.End(msg)
+		return
+	}
+
+	var relay stun.XORMappedAddress
+	if err := relay.GetFromAs(m, stun.AttrXORRelayedAddress); err == nil {
+		span.SetAttribute("turn.relay.address", relay.String())
+	}
+	span.End("")
+}
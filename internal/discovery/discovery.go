@@ -0,0 +1,242 @@
+// Package discovery implements the NAT behavior discovery of RFC 5780 on the UDP listeners with an
+// alternate address: the Binding requests are answered with the RESPONSE-ORIGIN and OTHER-ADDRESS
+// attributes, and the CHANGE-REQUEST attribute makes the server send the response from the
+// alternate IP address and/or port, so that the clients can classify the mapping and filtering
+// behavior of their NAT. The server listens on the alternate port of the listener address and,
+// if an alternate IP address is set, on both ports of the alternate address. Requests for a
+// change the server has no socket for are refused with a 420 (Unknown Attribute) error.
+package discovery
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/pion/logging"
+	"github.com/pion/stun"
+
+	"github.com/l7mp/stunner/internal/monitoring"
+	"github.com/l7mp/stunner/internal/stunmsg"
+)
+
+const (
+	// the flags of the CHANGE-REQUEST attribute
+	changeIP   = 0x04
+	changePort = 0x02
+	// attrResponseOrigin is the RESPONSE-ORIGIN attribute, missing from pion/stun
+	attrResponseOrigin stun.AttrType = 0x802B
+	maxMessageSize                   = 1500
+)
+
+var bindingRequest = stun.NewType(stun.MethodBinding, stun.ClassRequest).Value()
+
+// Config sets the addresses of the discovery server
+type Config struct {
+	// Listener is the name of the listener
+	Listener string
+	// Addr and Port are the address of the listener
+	Addr net.IP
+	Port int
+	// AltAddr is the alternate IP address, nil if none
+	AltAddr net.IP
+	// AltPort is the alternate port
+	AltPort int
+}
+
+// ListenFunc opens a socket at an address
+type ListenFunc func(addr string) (net.PacketConn, error)
+
+// Server answers the Binding requests on the listener sockets and the alternate sockets
+type Server struct {
+	config Config
+	// conns are the sockets by IP address and port index, the listener socket is set by the
+	// first listener socket wrapped
+	conns [2][2]net.PacketConn
+	lock  sync.RWMutex
+	log   logging.LeveledLogger
+}
+
+// NewServer opens the alternate sockets of a listener with the listen function and starts
+// answering the Binding requests received on them
+func NewServer(config Config, listen ListenFunc, logger logging.LoggerFactory) (*Server, error) {
+	s := &Server{config: config, log: logger.NewLogger("nat-discovery")}
+
+	for _, a := range []struct{ ip, port int }{{0, 1}, {1, 0}, {1, 1}} {
+		if a.ip == 1 && config.AltAddr == nil {
+			continue
+		}
+		addr := s.addr(a.ip, a.port)
+		conn, err := listen(addr.String())
+		if err != nil {
+			s.Close() //nolint:errcheck
+			return nil, err
+		}
+		s.conns[a.ip][a.port] = conn
+		go s.serve(conn, a.ip, a.port)
+	}
+
+	s.log.Infof("NAT behavior discovery on listener %s: alternate address: %s", config.Listener,
+		s.addr(1, 1))
+	return s, nil
+}
+
+// addr returns the address of a socket by IP address and port index
+func (s *Server) addr(ip, port int) *net.UDPAddr {
+	ret := &net.UDPAddr{IP: s.config.Addr, Port: s.config.Port}
+	if ip == 1 && s.config.AltAddr != nil {
+		ret.IP = s.config.AltAddr
+	}
+	if port == 1 {
+		ret.Port = s.config.AltPort
+	}
+	return ret
+}
+
+// conn returns the socket by IP address and port index, or nil if none
+func (s *Server) conn(ip, port int) net.PacketConn {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	return s.conns[ip][port]
+}
+
+// Close closes the alternate sockets
+func (s *Server) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	var err error
+	for _, a := range []struct{ ip, port int }{{0, 1}, {1, 0}, {1, 1}} {
+		if c := s.conns[a.ip][a.port]; c != nil {
+			if e := c.Close(); e != nil && err == nil {
+				err = e
+			}
+			s.conns[a.ip][a.port] = nil
+		}
+	}
+	return err
+}
+
+// serve answers the Binding requests on an alternate socket, the rest of the packets are dropped
+func (s *Server) serve(conn net.PacketConn, ip, port int) {
+	buf := make([]byte, maxMessageSize)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				s.log.Debugf("alternate socket %s: %s", conn.LocalAddr(), err.Error())
+			}
+			return
+		}
+		s.handle(buf[:n], stunmsg.Parse(buf[:n]), addr, conn, ip, port)
+	}
+}
+
+// NewPacketConn wraps a listener socket so that the Binding requests are answered by the
+// discovery server
+func (s *Server) NewPacketConn(conn net.PacketConn) net.PacketConn {
+	s.lock.Lock()
+	if s.conns[0][0] == nil {
+		s.conns[0][0] = conn
+	}
+	s.lock.Unlock()
+	return &packetConn{PacketConn: conn, server: s}
+}
+
+type packetConn struct {
+	net.PacketConn
+	server *Server
+}
+
+func (c *packetConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, _, err := c.ReadMessage(b)
+	return n, addr, err
+}
+
+func (c *packetConn) ReadMessage(b []byte) (int, net.Addr, stunmsg.Header, error) {
+	for {
+		n, addr, h, err := stunmsg.ReadMessage(c.PacketConn, b)
+		if err != nil || !c.server.handle(b[:n], h, addr, c.PacketConn, 0, 0) {
+			return n, addr, h, err
+		}
+	}
+}
+
+// handle answers a Binding request, with the header h, received on the socket with the given IP
+// address and port index, and returns false if the packet is not a Binding request
+func (s *Server) handle(b []byte, h stunmsg.Header, client net.Addr, conn net.PacketConn, ip, port int) bool {
+	if !h.Is(bindingRequest) {
+		return false
+	}
+	m := &stun.Message{Raw: append([]byte(nil), b...)}
+	if err := m.Decode(); err != nil {
+		return false
+	}
+	udpAddr, ok := client.(*net.UDPAddr)
+	if !ok {
+		return false
+	}
+
+	// the socket to respond from
+	toIP, toPort, change := ip, port, "none"
+	if v, err := m.Get(stun.AttrChangeRequest); err == nil && len(v) == 4 {
+		flags := binary.BigEndian.Uint32(v)
+		switch {
+		case flags&changeIP != 0 && flags&changePort != 0:
+			toIP, toPort, change = 1-ip, 1-port, "ip-port"
+		case flags&changeIP != 0:
+			toIP, change = 1-ip, "ip"
+		case flags&changePort != 0:
+			toPort, change = 1-port, "port"
+		}
+	}
+	out := conn
+	if toIP != ip || toPort != port {
+		out = s.conn(toIP, toPort)
+	}
+
+	var res *stun.Message
+	var err error
+	if out == nil {
+		change = "unsupported"
+		res, err = stun.Build(stun.NewTransactionIDSetter(m.TransactionID),
+			stun.NewType(stun.MethodBinding, stun.ClassErrorResponse),
+			stun.CodeUnknownAttribute, stun.UnknownAttributes{stun.AttrChangeRequest},
+			stun.Fingerprint)
+		out = conn
+	} else {
+		other := s.addr(1-ip, 1-port)
+		res, err = stun.Build(stun.NewTransactionIDSetter(m.TransactionID), stun.BindingSuccess,
+			&stun.XORMappedAddress{IP: udpAddr.IP, Port: udpAddr.Port},
+			&responseOrigin{addr: s.addr(toIP, toPort)},
+			&stun.OtherAddress{IP: other.IP, Port: other.Port},
+			stun.Fingerprint)
+	}
+	if err != nil {
+		s.log.Warnf("cannot answer Binding request of client %s: %s", client, err.Error())
+		return true
+	}
+
+	if _, err := out.WriteTo(res.Raw, client); err != nil {
+		s.log.Debugf("cannot answer Binding request of client %s: %s", client, err.Error())
+	}
+	monitoring.NATDiscoveryCounter.WithLabelValues(s.config.Listener, change).Inc()
+	return true
+}
+
+// responseOrigin is the RESPONSE-ORIGIN attribute, encoded as MAPPED-ADDRESS
+type responseOrigin struct {
+	addr *net.UDPAddr
+}
+
+func (r *responseOrigin) AddTo(m *stun.Message) error {
+	family, ip := uint16(0x01), r.addr.IP.To4()
+	if ip == nil {
+		family, ip = 0x02, r.addr.IP.To16()
+	}
+	v := make([]byte, 4+len(ip))
+	binary.BigEndian.PutUint16(v[0:2], family)
+	binary.BigEndian.PutUint16(v[2:4], uint16(r.addr.Port))
+	copy(v[4:], ip)
+	m.Add(attrResponseOrigin, v)
+	return nil
+}
@@ -0,0 +1,141 @@
+package discovery
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pion/logging"
+	"github.com/pion/stun"
+	"github.com/stretchr/testify/assert"
+)
+
+func listen(addr string) (net.PacketConn, error) {
+	return net.ListenPacket("udp4", addr)
+}
+
+// newServer creates a discovery server at a free port pair of the address, with the listener
+// socket wrapped
+func newServer(t *testing.T, altAddr net.IP) (*Server, net.PacketConn) {
+	t.Helper()
+	server, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	assert.NoError(t, err, "listen")
+	alt, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	assert.NoError(t, err, "alternate port")
+	port, altPort := server.LocalAddr().(*net.UDPAddr).Port, alt.LocalAddr().(*net.UDPAddr).Port
+	alt.Close()
+
+	d, err := NewServer(Config{Listener: "udp", Addr: net.ParseIP("127.0.0.1"), Port: port,
+		AltAddr: altAddr, AltPort: altPort}, listen, logging.NewDefaultLoggerFactory())
+	assert.NoError(t, err, "server")
+	return d, d.NewPacketConn(server)
+}
+
+func binding(t *testing.T, change uint32) []byte {
+	t.Helper()
+	setters := []stun.Setter{stun.TransactionID, stun.BindingRequest}
+	if change != 0 {
+		v := make([]byte, 4)
+		binary.BigEndian.PutUint32(v, change)
+		setters = append(setters, stun.RawAttribute{Type: stun.AttrChangeRequest, Value: v})
+	}
+	m, err := stun.Build(append(setters, stun.Fingerprint)...)
+	assert.NoError(t, err, "build")
+	return m.Raw
+}
+
+// roundTrip sends a request to the listener socket and returns the response and its source
+func roundTrip(t *testing.T, conn, client net.PacketConn, req []byte) (*stun.Message, net.Addr) {
+	t.Helper()
+	_, err := client.WriteTo(req, conn.LocalAddr())
+	assert.NoError(t, err, "write")
+
+	// the wrapped listener socket answers the Binding requests and keeps on reading
+	buf := make([]byte, 1500)
+	assert.NoError(t, conn.SetReadDeadline(time.Now().Add(200*time.Millisecond)), "deadline")
+	_, _, err = conn.ReadFrom(buf)
+	assert.Error(t, err, "request consumed")
+
+	assert.NoError(t, client.SetReadDeadline(time.Now().Add(2*time.Second)), "deadline")
+	n, from, err := client.ReadFrom(buf)
+	assert.NoError(t, err, "read")
+	res := &stun.Message{Raw: buf[:n]}
+	assert.NoError(t, res.Decode(), "decode")
+	return res, from
+}
+
+func TestBinding(t *testing.T) {
+	d, conn := newServer(t, nil)
+	defer d.Close()
+	defer conn.Close()
+	client, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	assert.NoError(t, err, "client")
+	defer client.Close()
+
+	res, from := roundTrip(t, conn, client, binding(t, 0))
+	assert.Equal(t, stun.BindingSuccess, res.Type, "success")
+	assert.Equal(t, conn.LocalAddr().String(), from.String(), "response source")
+
+	var mapped stun.XORMappedAddress
+	assert.NoError(t, mapped.GetFrom(res), "xor-mapped-address")
+	assert.Equal(t, client.LocalAddr().(*net.UDPAddr).Port, mapped.Port, "mapped port")
+
+	var origin stun.MappedAddress
+	v, err := res.Get(attrResponseOrigin)
+	assert.NoError(t, err, "response-origin")
+	res.Add(stun.AttrMappedAddress, v)
+	assert.NoError(t, origin.GetFrom(res), "response-origin")
+	assert.Equal(t, conn.LocalAddr().String(), origin.String(), "response-origin")
+
+	var other stun.OtherAddress
+	assert.NoError(t, other.GetFrom(res), "other-address")
+	assert.Equal(t, d.config.AltPort, other.Port, "other-address")
+
+	// change port: the response comes from the alternate port
+	res, from = roundTrip(t, conn, client, binding(t, changePort))
+	assert.Equal(t, stun.BindingSuccess, res.Type, "change port")
+	assert.Equal(t, d.config.AltPort, from.(*net.UDPAddr).Port, "change port source")
+
+	// change IP: no alternate address
+	res, from = roundTrip(t, conn, client, binding(t, changeIP))
+	assert.Equal(t, stun.ClassErrorResponse, res.Type.Class, "change IP refused")
+	assert.Equal(t, conn.LocalAddr().String(), from.String(), "error source")
+	var code stun.ErrorCodeAttribute
+	assert.NoError(t, code.GetFrom(res), "error code")
+	assert.Equal(t, stun.CodeUnknownAttribute, code.Code, "unknown attribute")
+
+	// other packets are passed on
+	_, err = client.WriteTo([]byte("data"), conn.LocalAddr())
+	assert.NoError(t, err, "write")
+	buf := make([]byte, 1500)
+	assert.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)), "deadline")
+	n, _, err := conn.ReadFrom(buf)
+	assert.NoError(t, err, "read")
+	assert.Equal(t, "data", string(buf[:n]), "passed on")
+}
+
+func TestChangeIP(t *testing.T) {
+	// 127.0.0.2 is only routed on Linux by default
+	alt, err := net.ListenPacket("udp4", "127.0.0.2:0")
+	if err != nil {
+		t.Skip("no alternate loopback address")
+	}
+	alt.Close()
+
+	d, conn := newServer(t, net.ParseIP("127.0.0.2"))
+	defer d.Close()
+	defer conn.Close()
+	client, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	assert.NoError(t, err, "client")
+	defer client.Close()
+
+	res, from := roundTrip(t, conn, client, binding(t, changeIP|changePort))
+	assert.Equal(t, stun.BindingSuccess, res.Type, "change IP and port")
+	assert.Equal(t, "127.0.0.2", from.(*net.UDPAddr).IP.String(), "change IP source")
+	assert.Equal(t, d.config.AltPort, from.(*net.UDPAddr).Port, "change port source")
+
+	var other stun.OtherAddress
+	assert.NoError(t, other.GetFrom(res), "other-address")
+	assert.Equal(t, "127.0.0.2", other.IP.String(), "other-address")
+}
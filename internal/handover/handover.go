@@ -0,0 +1,526 @@
+// Package handover hands over the listening sockets of a running process to a new process over a
+// Unix socket, so that the gateway can be upgraded without cutting the live sessions. The new
+// process takes over the sockets with file descriptor passing and serves the new clients, while
+// the old process keeps serving its allocations until they are closed: the old process stops
+// accepting on the stream listeners, the accepted connections stay with the old process, and the
+// new process forwards the packets of the clients of the old process received on the UDP
+// listeners back to the old process over the Unix socket. The replies are sent by the old process
+// on its own copy of the sockets.
+package handover
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/logging"
+
+	"github.com/l7mp/stunner/internal/util"
+)
+
+const (
+	// maxHeaderSize limits the size of the handover header
+	maxHeaderSize = 16 << 20
+	// maxFiles limits the number of sockets handed over, the kernel limit of file descriptors
+	// per message
+	maxFiles = 253
+	// forwardQueueSize is the number of forwarded packets queued per socket of the old process
+	forwardQueueSize = 1024
+)
+
+// State is the state handed over to the new process
+type State struct {
+	// Sockets maps the keys of the listeners to their sockets, several for UDP listeners with
+	// multiple workers
+	Sockets map[string][]*os.File
+	// Clients maps the keys of the UDP listeners to the addresses of the clients with an
+	// allocation in the old process
+	Clients map[string][]string
+}
+
+// Close closes the files of the sockets not taken over
+func (s *State) Close() {
+	for _, fs := range s.Sockets {
+		for _, f := range fs {
+			f.Close()
+		}
+	}
+	s.Sockets = map[string][]*os.File{}
+}
+
+// header precedes the file descriptors on the Unix socket
+type header struct {
+	Sockets []socketHeader      `json:"sockets"`
+	Clients map[string][]string `json:"clients"`
+	// Error is the reason the old process refused the handover
+	Error string `json:"error,omitempty"`
+}
+
+type socketHeader struct {
+	Key   string `json:"key"`
+	Files int    `json:"files"`
+}
+
+// Listen creates the Unix socket the new process connects to for the handover, a socket left
+// behind by a previous process is removed. Access is limited to the owner of the process.
+func Listen(path string) (*net.UnixListener, error) {
+	if fi, err := os.Lstat(path); err == nil {
+		if fi.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("cannot create upgrade socket at %s: file exists", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("cannot remove stale upgrade socket at %s: %w", path, err)
+		}
+	}
+
+	// the socket file is created with the permissions set by the umask
+	mask := util.Umask(0o177)
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	util.Umask(mask)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create upgrade socket at %s: %w", path, err)
+	}
+	return l, nil
+}
+
+// Dial connects to the Unix socket of the old process
+func Dial(path string) (*net.UnixConn, error) {
+	return net.DialUnix("unix", nil, &net.UnixAddr{Name: path, Net: "unix"})
+}
+
+// Refuse tells the new process that the sockets are not handed over
+func Refuse(conn *net.UnixConn, reason string) error {
+	return send(conn, header{Sockets: []socketHeader{}, Error: reason}, nil)
+}
+
+// Send sends the state to the new process
+func Send(conn *net.UnixConn, state *State) error {
+	h := header{Sockets: []socketHeader{}, Clients: state.Clients}
+	fds := []int{}
+	for key, fs := range state.Sockets {
+		h.Sockets = append(h.Sockets, socketHeader{Key: key, Files: len(fs)})
+		for _, f := range fs {
+			fds = append(fds, int(f.Fd()))
+		}
+	}
+	if len(fds) > maxFiles {
+		return fmt.Errorf("too many sockets to hand over: %d", len(fds))
+	}
+	return send(conn, h, fds)
+}
+
+func send(conn *net.UnixConn, h header, fds []int) error {
+	b, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+	msg := make([]byte, 4+len(b))
+	binary.BigEndian.PutUint32(msg[0:4], uint32(len(b)))
+	copy(msg[4:], b)
+
+	// the descriptors go with the first byte of the message
+	var oob []byte
+	if len(fds) > 0 {
+		if oob, err = unixRights(fds); err != nil {
+			return fmt.Errorf("cannot send sockets: %w", err)
+		}
+	}
+	if _, _, err := conn.WriteMsgUnix(msg[:1], oob, nil); err != nil {
+		return fmt.Errorf("cannot send sockets: %w", err)
+	}
+	if _, err := conn.Write(msg[1:]); err != nil {
+		return fmt.Errorf("cannot send handover header: %w", err)
+	}
+	return nil
+}
+
+// Commit tells the new process that the old process released the resources the new process
+// needs besides the sockets, e.g., the ports of the API and metrics endpoints
+func Commit(conn *net.UnixConn) error {
+	_, err := conn.Write([]byte{1})
+	return err
+}
+
+// Receive receives the state from the old process, and waits until the old process commits the
+// handover
+func Receive(conn *net.UnixConn) (*State, error) {
+	msg := make([]byte, 1)
+	oob := make([]byte, rightsSpace(maxFiles))
+	_, oobn, _, _, err := conn.ReadMsgUnix(msg, oob)
+	if err != nil {
+		return nil, fmt.Errorf("cannot receive sockets: %w", err)
+	}
+	fds, err := parseUnixRights(oob[:oobn])
+	if err != nil {
+		return nil, fmt.Errorf("cannot receive sockets: %w", err)
+	}
+	closeFds := func() {
+		for _, fd := range fds {
+			closeFd(fd)
+		}
+	}
+
+	rest := make([]byte, 3)
+	if _, err := io.ReadFull(conn, rest); err != nil {
+		closeFds()
+		return nil, fmt.Errorf("cannot receive handover header: %w", err)
+	}
+	size := binary.BigEndian.Uint32(append(msg, rest...))
+	if size > maxHeaderSize {
+		closeFds()
+		return nil, fmt.Errorf("handover header too large: %d bytes", size)
+	}
+	b := make([]byte, size)
+	if _, err := io.ReadFull(conn, b); err != nil {
+		closeFds()
+		return nil, fmt.Errorf("cannot receive handover header: %w", err)
+	}
+	h := header{}
+	if err := json.Unmarshal(b, &h); err != nil {
+		closeFds()
+		return nil, fmt.Errorf("invalid handover header: %w", err)
+	}
+	if h.Error != "" {
+		closeFds()
+		return nil, fmt.Errorf("handover refused: %s", h.Error)
+	}
+
+	if _, err := io.ReadFull(conn, msg); err != nil {
+		closeFds()
+		return nil, fmt.Errorf("handover not committed: %w", err)
+	}
+
+	state := &State{Sockets: map[string][]*os.File{}, Clients: h.Clients}
+	if state.Clients == nil {
+		state.Clients = map[string][]string{}
+	}
+	for _, s := range h.Sockets {
+		if s.Files > len(fds) {
+			closeFds()
+			state.Close()
+			return nil, fmt.Errorf("missing sockets for %s", s.Key)
+		}
+		for _, fd := range fds[:s.Files] {
+			state.Sockets[s.Key] = append(state.Sockets[s.Key], os.NewFile(uintptr(fd), s.Key))
+		}
+		fds = fds[s.Files:]
+	}
+	closeFds()
+
+	return state, nil
+}
+
+// Mux switches the sockets of a process between the roles of the handover: the sockets of the old
+// process stop serving new clients and receive the packets forwarded by the new process, and the
+// sockets of the new process forward the packets of the clients of the old process
+type Mux struct {
+	lock     sync.Mutex
+	conns    map[string]*packetConn // key/worker
+	lns      map[string]*listener
+	detached int32 // atomic
+	// the new process forwards to the old one
+	forward *net.UnixConn
+	clients map[string]map[string]bool
+	// the old process receives from the new one
+	detachedConn *net.UnixConn
+	log          logging.LeveledLogger
+}
+
+// NewMux creates a mux with the sockets serving normally
+func NewMux(logger logging.LoggerFactory) *Mux {
+	return &Mux{
+		conns: map[string]*packetConn{},
+		lns:   map[string]*listener{},
+		log:   logger.NewLogger("handover"),
+	}
+}
+
+// Close closes the Unix sockets of the handover: the old process stops receiving the forwarded
+// packets and the new process stops forwarding
+func (m *Mux) Close() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.forward != nil {
+		m.forward.Close()
+		m.forward, m.clients = nil, nil
+	}
+	if m.detachedConn != nil {
+		m.detachedConn.Close()
+		m.detachedConn = nil
+	}
+}
+
+func connKey(key string, worker int) string {
+	return fmt.Sprintf("%s/%d", key, worker)
+}
+
+// Detach makes the sockets stop serving new clients after the sockets were handed over: the stream
+// listeners stop accepting connections and the packet sockets receive the packets forwarded by
+// the new process on the Unix socket, until the Unix socket is closed
+func (m *Mux) Detach(conn *net.UnixConn) {
+	atomic.StoreInt32(&m.detached, 1)
+	m.lock.Lock()
+	m.detachedConn = conn
+	m.lock.Unlock()
+
+	// unblock the reads in progress
+	past := time.Unix(1, 0)
+	m.lock.Lock()
+	for _, c := range m.conns {
+		c.PacketConn.SetReadDeadline(past) //nolint:errcheck
+	}
+	for _, l := range m.lns {
+		if d, ok := l.Listener.(interface{ SetDeadline(time.Time) error }); ok {
+			d.SetDeadline(past) //nolint:errcheck
+		}
+	}
+	m.lock.Unlock()
+
+	go m.receive(conn)
+}
+
+func (m *Mux) isDetached() bool {
+	return atomic.LoadInt32(&m.detached) == 1
+}
+
+// receive dispatches the packets forwarded by the new process to the sockets
+func (m *Mux) receive(conn *net.UnixConn) {
+	defer conn.Close()
+	for {
+		key, worker, addr, data, err := readFrame(conn)
+		if err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				m.log.Debugf("stopped receiving forwarded packets: %s", err.Error())
+			}
+			return
+		}
+
+		m.lock.Lock()
+		c, ok := m.conns[connKey(key, worker)]
+		m.lock.Unlock()
+		if !ok {
+			continue
+		}
+		select {
+		case c.forwarded <- forwardedPacket{addr: addr, data: data}:
+		default:
+			m.log.Tracef("forwarding queue full, dropping packet from %s", addr)
+		}
+	}
+}
+
+// Forward makes the packet sockets forward the packets of the clients of the old process to the
+// old process, until the Unix socket is closed by the old process
+func (m *Mux) Forward(conn *net.UnixConn, clients map[string][]string) {
+	set := map[string]map[string]bool{}
+	for key, addrs := range clients {
+		set[key] = map[string]bool{}
+		for _, a := range addrs {
+			set[key][a] = true
+		}
+	}
+
+	m.lock.Lock()
+	m.forward = conn
+	m.clients = set
+	m.lock.Unlock()
+
+	// the old process closes the Unix socket when it exits
+	go func() {
+		io.Copy(io.Discard, conn) //nolint:errcheck
+		m.log.Infof("old process exited, forwarding stopped")
+		m.lock.Lock()
+		if m.forward == conn {
+			m.forward, m.clients = nil, nil
+		}
+		m.lock.Unlock()
+		conn.Close()
+	}()
+}
+
+// forwardTo returns the Unix socket to forward a packet to, or nil if the packet is served locally
+func (m *Mux) forwardTo(key string, addr net.Addr) *net.UnixConn {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if m.forward == nil || !m.clients[key][addr.String()] {
+		return nil
+	}
+	return m.forward
+}
+
+// forwardedPacket is a packet forwarded to the old process
+type forwardedPacket struct {
+	addr net.Addr
+	data []byte
+}
+
+// NewPacketConn wraps a worker socket of a UDP listener
+func (m *Mux) NewPacketConn(conn net.PacketConn, key string, worker int) net.PacketConn {
+	c := &packetConn{
+		PacketConn: conn,
+		mux:        m,
+		key:        key,
+		worker:     worker,
+		forwarded:  make(chan forwardedPacket, forwardQueueSize),
+		closed:     make(chan struct{}),
+	}
+	m.lock.Lock()
+	m.conns[connKey(key, worker)] = c
+	m.lock.Unlock()
+	return c
+}
+
+type packetConn struct {
+	net.PacketConn
+	mux       *Mux
+	key       string
+	worker    int
+	forwarded chan forwardedPacket
+	closed    chan struct{}
+	closeOnce sync.Once
+	wlock     sync.Mutex // serializes the frames forwarded to the old process
+}
+
+func (c *packetConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		if c.mux.isDetached() {
+			select {
+			case p := <-c.forwarded:
+				return copy(b, p.data), p.addr, nil
+			case <-c.closed:
+				return 0, nil, net.ErrClosed
+			}
+		}
+
+		n, addr, err := c.PacketConn.ReadFrom(b)
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() && c.mux.isDetached() {
+				continue
+			}
+			return n, addr, err
+		}
+
+		conn := c.mux.forwardTo(c.key, addr)
+		if conn == nil {
+			return n, addr, err
+		}
+		c.wlock.Lock()
+		err = writeFrame(conn, c.key, c.worker, addr, b[:n])
+		c.wlock.Unlock()
+		if err != nil {
+			c.mux.log.Tracef("cannot forward packet from %s: %s", addr, err.Error())
+		}
+	}
+}
+
+func (c *packetConn) Close() error {
+	c.closeOnce.Do(func() { close(c.closed) })
+	c.mux.lock.Lock()
+	if c.mux.conns[connKey(c.key, c.worker)] == c {
+		delete(c.mux.conns, connKey(c.key, c.worker))
+	}
+	c.mux.lock.Unlock()
+	return c.PacketConn.Close()
+}
+
+// NewListener wraps the socket of a stream listener
+func (m *Mux) NewListener(ln net.Listener, key string) net.Listener {
+	l := &listener{Listener: ln, mux: m, key: key, closed: make(chan struct{})}
+	m.lock.Lock()
+	m.lns[key] = l
+	m.lock.Unlock()
+	return l
+}
+
+type listener struct {
+	net.Listener
+	mux       *Mux
+	key       string
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// Accept blocks until the listener is closed once the socket is handed over: returning an error
+// would make the TURN server close the allocations of the accepted connections
+func (l *listener) Accept() (net.Conn, error) {
+	for {
+		if l.mux.isDetached() {
+			<-l.closed
+			return nil, net.ErrClosed
+		}
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Timeout() && l.mux.isDetached() {
+				continue
+			}
+		}
+		return conn, err
+	}
+}
+
+func (l *listener) Close() error {
+	l.closeOnce.Do(func() { close(l.closed) })
+	l.mux.lock.Lock()
+	if l.mux.lns[l.key] == l {
+		delete(l.mux.lns, l.key)
+	}
+	l.mux.lock.Unlock()
+	return l.Listener.Close()
+}
+
+// writeFrame writes a forwarded packet: the key of the listener, the worker, the address of the
+// client and the packet, each prefixed with its length
+func writeFrame(w io.Writer, key string, worker int, addr net.Addr, data []byte) error {
+	a := addr.String()
+	if len(key) > 0xffff || len(a) > 0xff || len(data) > 0xffff {
+		return errors.New("frame too large")
+	}
+	b := make([]byte, 0, 2+len(key)+1+1+len(a)+2+len(data))
+	b = append(b, byte(len(key)>>8), byte(len(key)))
+	b = append(b, key...)
+	b = append(b, byte(worker), byte(len(a)))
+	b = append(b, a...)
+	b = append(b, byte(len(data)>>8), byte(len(data)))
+	b = append(b, data...)
+	_, err := w.Write(b)
+	return err
+}
+
+// readFrame reads a forwarded packet
+func readFrame(r io.Reader) (string, int, net.Addr, []byte, error) {
+	u16 := make([]byte, 2)
+	if _, err := io.ReadFull(r, u16); err != nil {
+		return "", 0, nil, nil, err
+	}
+	key := make([]byte, binary.BigEndian.Uint16(u16))
+	if _, err := io.ReadFull(r, key); err != nil {
+		return "", 0, nil, nil, err
+	}
+	wa := make([]byte, 2)
+	if _, err := io.ReadFull(r, wa); err != nil {
+		return "", 0, nil, nil, err
+	}
+	a := make([]byte, wa[1])
+	if _, err := io.ReadFull(r, a); err != nil {
+		return "", 0, nil, nil, err
+	}
+	addr, err := net.ResolveUDPAddr("udp", string(a))
+	if err != nil {
+		return "", 0, nil, nil, err
+	}
+	if _, err := io.ReadFull(r, u16); err != nil {
+		return "", 0, nil, nil, err
+	}
+	data := make([]byte, binary.BigEndian.Uint16(u16))
+	if _, err := io.ReadFull(r, data); err != nil {
+		return "", 0, nil, nil, err
+	}
+	return string(key), int(wa[0]), addr, data, nil
+}
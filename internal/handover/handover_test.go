@@ -0,0 +1,261 @@
+//go:build !windows
+// +build !windows
+
+package handover
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/pion/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+// unixPair returns a connected pair of Unix stream sockets
+func unixPair(t *testing.T) (*net.UnixConn, *net.UnixConn) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	assert.NoError(t, err, "socketpair")
+	conns := make([]*net.UnixConn, 2)
+	for i, fd := range fds {
+		f := os.NewFile(uintptr(fd), "unix")
+		c, err := net.FileConn(f)
+		assert.NoError(t, err, "file conn")
+		f.Close()
+		conns[i] = c.(*net.UnixConn)
+	}
+	return conns[0], conns[1]
+}
+
+func TestSendReceive(t *testing.T) {
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err, "listen udp")
+	defer udpConn.Close()
+	tcpListener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err, "listen tcp")
+	defer tcpListener.Close()
+
+	udpFile, err := udpConn.File()
+	assert.NoError(t, err, "udp file")
+	tcpFile, err := tcpListener.File()
+	assert.NoError(t, err, "tcp file")
+
+	old, new := unixPair(t)
+	defer old.Close()
+	defer new.Close()
+
+	state := &State{
+		Sockets: map[string][]*os.File{"udp": {udpFile}, "tcp": {tcpFile}},
+		Clients: map[string][]string{"udp": {"1.2.3.4:1234"}},
+	}
+	go func() {
+		assert.NoError(t, Send(old, state), "send")
+		state.Close()
+		assert.NoError(t, Commit(old), "commit")
+	}()
+
+	received, err := Receive(new)
+	assert.NoError(t, err, "receive")
+	assert.Equal(t, []string{"1.2.3.4:1234"}, received.Clients["udp"], "clients")
+	assert.Len(t, received.Sockets["udp"], 1, "udp socket")
+	assert.Len(t, received.Sockets["tcp"], 1, "tcp socket")
+
+	c, err := net.FilePacketConn(received.Sockets["udp"][0])
+	assert.NoError(t, err, "udp socket taken over")
+	defer c.Close()
+	assert.Equal(t, udpConn.LocalAddr().String(), c.LocalAddr().String(), "udp address")
+
+	l, err := net.FileListener(received.Sockets["tcp"][0])
+	assert.NoError(t, err, "tcp socket taken over")
+	defer l.Close()
+	assert.Equal(t, tcpListener.Addr().String(), l.Addr().String(), "tcp address")
+	received.Close()
+}
+
+func TestRefuse(t *testing.T) {
+	old, new := unixPair(t)
+	defer old.Close()
+	defer new.Close()
+
+	go func() { assert.NoError(t, Refuse(old, "not now"), "refuse") }()
+	_, err := Receive(new)
+	assert.EqualError(t, err, "handover refused: not now", "refused")
+}
+
+func TestListenDial(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "upgrade.sock")
+
+	// stale sockets are removed, other files are not
+	l, err := Listen(path)
+	assert.NoError(t, err, "listen")
+	l.SetUnlinkOnClose(false)
+	l.Close()
+	l, err = Listen(path)
+	assert.NoError(t, err, "listen on stale socket")
+	defer l.Close()
+
+	fi, err := os.Stat(path)
+	assert.NoError(t, err, "stat")
+	assert.Equal(t, os.FileMode(0o600), fi.Mode().Perm(), "permissions")
+
+	c, err := Dial(path)
+	assert.NoError(t, err, "dial")
+	c.Close()
+
+	file := filepath.Join(t.TempDir(), "file")
+	assert.NoError(t, os.WriteFile(file, nil, 0o600), "write")
+	_, err = Listen(file)
+	assert.Error(t, err, "file exists")
+}
+
+func TestForward(t *testing.T) {
+	logger := logging.NewDefaultLoggerFactory()
+
+	udpConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err, "listen udp")
+	f, err := udpConn.File()
+	assert.NoError(t, err, "file")
+	taken, err := net.FilePacketConn(f)
+	assert.NoError(t, err, "taken over")
+	f.Close()
+
+	oldClient, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err, "old client")
+	defer oldClient.Close()
+	newClient, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err, "new client")
+	defer newClient.Close()
+
+	oldMux, newMux := NewMux(logger), NewMux(logger)
+	oldConn := oldMux.NewPacketConn(udpConn, "udp", 0)
+	defer oldConn.Close()
+	newConn := newMux.NewPacketConn(taken, "udp", 0)
+	defer newConn.Close()
+
+	// the old process is blocked in a read when the sockets are handed over
+	type packet struct {
+		data string
+		addr net.Addr
+	}
+	oldRead := make(chan packet, 2)
+	go func() {
+		buf := make([]byte, 100)
+		for {
+			n, addr, err := oldConn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			oldRead <- packet{string(buf[:n]), addr}
+		}
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	old, new := unixPair(t)
+	oldMux.Detach(old)
+	newMux.Forward(new, map[string][]string{"udp": {oldClient.LocalAddr().String()}})
+
+	newRead := make(chan packet, 2)
+	go func() {
+		buf := make([]byte, 100)
+		for {
+			n, addr, err := newConn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			newRead <- packet{string(buf[:n]), addr}
+		}
+	}()
+
+	_, err = oldClient.WriteTo([]byte("old"), udpConn.LocalAddr())
+	assert.NoError(t, err, "write old")
+	_, err = newClient.WriteTo([]byte("new"), udpConn.LocalAddr())
+	assert.NoError(t, err, "write new")
+
+	select {
+	case p := <-oldRead:
+		assert.Equal(t, "old", p.data, "forwarded to old process")
+		assert.Equal(t, oldClient.LocalAddr().String(), p.addr.String(), "old client")
+	case <-time.After(2 * time.Second):
+		assert.Fail(t, "no packet forwarded to old process")
+	}
+	select {
+	case p := <-newRead:
+		assert.Equal(t, "new", p.data, "served by new process")
+		assert.Equal(t, newClient.LocalAddr().String(), p.addr.String(), "new client")
+	case <-time.After(2 * time.Second):
+		assert.Fail(t, "no packet served by new process")
+	}
+
+	// the old process replies on its copy of the socket
+	_, err = oldConn.WriteTo([]byte("reply"), oldClient.LocalAddr())
+	assert.NoError(t, err, "reply")
+	buf := make([]byte, 100)
+	assert.NoError(t, oldClient.SetReadDeadline(time.Now().Add(2*time.Second)), "deadline")
+	n, _, err := oldClient.ReadFrom(buf)
+	assert.NoError(t, err, "read reply")
+	assert.Equal(t, "reply", string(buf[:n]), "reply")
+
+	// forwarding stops when the old process exits
+	old.Close()
+	time.Sleep(50 * time.Millisecond)
+	_, err = oldClient.WriteTo([]byte("old again"), udpConn.LocalAddr())
+	assert.NoError(t, err, "write old")
+	select {
+	case p := <-newRead:
+		assert.Equal(t, "old again", p.data, "served by new process")
+	case <-time.After(2 * time.Second):
+		assert.Fail(t, "no packet served by new process")
+	}
+}
+
+func TestListener(t *testing.T) {
+	logger := logging.NewDefaultLoggerFactory()
+
+	tcpListener, err := net.ListenTCP("tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	assert.NoError(t, err, "listen tcp")
+	f, err := tcpListener.File()
+	assert.NoError(t, err, "file")
+	taken, err := net.FileListener(f)
+	assert.NoError(t, err, "taken over")
+	f.Close()
+
+	oldMux, newMux := NewMux(logger), NewMux(logger)
+	oldListener := oldMux.NewListener(tcpListener, "tcp")
+	newListener := newMux.NewListener(taken, "tcp")
+	defer newListener.Close()
+
+	accepted := make(chan error, 1)
+	go func() {
+		_, err := oldListener.Accept()
+		accepted <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+
+	old, new := unixPair(t)
+	defer new.Close()
+	oldMux.Detach(old)
+
+	conn, err := net.Dial("tcp", tcpListener.Addr().String())
+	assert.NoError(t, err, "dial")
+	defer conn.Close()
+	c, err := newListener.Accept()
+	assert.NoError(t, err, "accepted by new process")
+	c.Close()
+
+	// the old listener blocks until closed
+	select {
+	case <-accepted:
+		assert.Fail(t, "old listener accepted")
+	case <-time.After(100 * time.Millisecond):
+	}
+	oldListener.Close()
+	select {
+	case err := <-accepted:
+		assert.ErrorIs(t, err, net.ErrClosed, "closed")
+	case <-time.After(2 * time.Second):
+		assert.Fail(t, "old listener not closed")
+	}
+}
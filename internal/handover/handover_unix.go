@@ -0,0 +1,40 @@
+//go:build !windows
+// +build !windows
+
+package handover
+
+import "syscall"
+
+// unixRights encodes the file descriptors to be passed over a Unix socket
+func unixRights(fds []int) ([]byte, error) {
+	return syscall.UnixRights(fds...), nil
+}
+
+// rightsSpace returns the size of the buffer to receive the file descriptors in
+func rightsSpace(num int) int {
+	return syscall.CmsgSpace(num * 4)
+}
+
+// parseUnixRights decodes the file descriptors received over a Unix socket
+func parseUnixRights(oob []byte) ([]int, error) {
+	fds := []int{}
+	if len(oob) == 0 {
+		return fds, nil
+	}
+	cmsgs, err := syscall.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil, err
+	}
+	for _, cmsg := range cmsgs {
+		rights, err := syscall.ParseUnixRights(&cmsg)
+		if err != nil {
+			return nil, err
+		}
+		fds = append(fds, rights...)
+	}
+	return fds, nil
+}
+
+func closeFd(fd int) {
+	syscall.Close(fd) //nolint:errcheck
+}
@@ -0,0 +1,24 @@
+package handover
+
+import "errors"
+
+// errUnsupported is returned when sockets are to be passed on Windows: Unix sockets cannot carry
+// socket handles, so the listening sockets cannot be handed over
+var errUnsupported = errors.New("socket handover is not supported on this platform")
+
+func unixRights(fds []int) ([]byte, error) {
+	return nil, errUnsupported
+}
+
+func rightsSpace(num int) int {
+	return 0
+}
+
+func parseUnixRights(oob []byte) ([]int, error) {
+	if len(oob) > 0 {
+		return nil, errUnsupported
+	}
+	return []int{}, nil
+}
+
+func closeFd(fd int) {}
@@ -0,0 +1,190 @@
+// Package hashring implements the consistent hashing of the clients to the replicas of the gateway
+// behind a load balancer. Each client is owned by the replica its IP address hashes to with
+// rendezvous hashing, so that adding or removing a replica only moves the clients of that replica.
+// The listener sockets check the authenticated Allocate requests against the ring: the
+// allocations arriving at a replica other than the owner of the client are counted and, in
+// redirect mode, rejected with a 300 (Try Alternate) error pointing to the owner in an
+// ALTERNATE-SERVER attribute, so that the sessions stay pinned to the replica the load balancer
+// is expected to route them to.
+package hashring
+
+import (
+	"hash/fnv"
+	"net"
+	"sync/atomic"
+
+	"github.com/pion/logging"
+	"github.com/pion/stun"
+
+	"github.com/l7mp/stunner/internal/monitoring"
+	"github.com/l7mp/stunner/internal/stunmsg"
+)
+
+var (
+	allocateRequest = stun.NewType(stun.MethodAllocate, stun.ClassRequest).Value()
+	allocateError   = stun.NewType(stun.MethodAllocate, stun.ClassErrorResponse)
+)
+
+// Config sets the hash ring
+type Config struct {
+	// Replicas lists the IP addresses of the replicas in the ring
+	Replicas []net.IP
+	// Self is the IP address of this replica
+	Self net.IP
+	// Redirect rejects the Allocate requests of the clients owned by another replica
+	Redirect bool
+}
+
+// Ring maps the clients to the replicas of the gateway
+type Ring struct {
+	config atomic.Value // *Config
+	log    logging.LeveledLogger
+}
+
+// NewRing creates a hash ring, disabled until a config is set
+func NewRing(logger logging.LoggerFactory) *Ring {
+	r := &Ring{log: logger.NewLogger("hashring")}
+	r.config.Store((*Config)(nil))
+	return r
+}
+
+// SetConfig sets the config, nil disables the ring
+func (r *Ring) SetConfig(conf *Config) {
+	r.config.Store(conf)
+}
+
+// GetConfig returns the config, or nil if the ring is disabled
+func (r *Ring) GetConfig() *Config {
+	return r.config.Load().(*Config)
+}
+
+// Owner returns the IP address of the replica owning a client, or nil if the ring is disabled
+func (r *Ring) Owner(client net.IP) net.IP {
+	conf := r.GetConfig()
+	if conf == nil {
+		return nil
+	}
+	return owner(conf.Replicas, client)
+}
+
+// owner returns the replica with the highest score for the client
+func owner(replicas []net.IP, client net.IP) net.IP {
+	var best net.IP
+	var bestScore uint64
+	for _, replica := range replicas {
+		h := fnv.New64a()
+		_, _ = h.Write(replica.To16())
+		_, _ = h.Write(client.To16())
+		if score := mix(h.Sum64()); best == nil || score > bestScore {
+			best, bestScore = replica, score
+		}
+	}
+	return best
+}
+
+// mix is the 64-bit finalizer of MurmurHash3: FNV-1a mixes the last bytes hashed poorly into the
+// high bits, and so the scores of a client would be ordered by the replicas alone
+func mix(h uint64) uint64 {
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+// NewPacketConn wraps the socket of a UDP listener so that the authenticated Allocate requests of
+// the clients owned by another replica are counted and, in redirect mode, rejected. The key
+// function checks the integrity of the requests, and the port is the port of the listener, to be
+// advertised in the ALTERNATE-SERVER attributes
+func (r *Ring) NewPacketConn(conn net.PacketConn, listener string, port int, key stunmsg.KeyFunc) net.PacketConn {
+	return &packetConn{PacketConn: conn, ring: r, listener: listener, port: port, key: key}
+}
+
+type packetConn struct {
+	net.PacketConn
+	ring     *Ring
+	listener string
+	port     int
+	key      stunmsg.KeyFunc
+}
+
+func (c *packetConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, _, err := c.ReadMessage(b)
+	return n, addr, err
+}
+
+func (c *packetConn) ReadMessage(b []byte) (int, net.Addr, stunmsg.Header, error) {
+	for {
+		n, addr, h, err := stunmsg.ReadMessage(c.PacketConn, b)
+		if err != nil || !c.redirect(b[:n], h, addr) {
+			return n, addr, h, err
+		}
+	}
+}
+
+// redirect checks an Allocate request, with the header h, against the ring, and returns true if
+// the request was rejected
+func (c *packetConn) redirect(b []byte, h stunmsg.Header, addr net.Addr) bool {
+	conf := c.ring.GetConfig()
+	if conf == nil || !h.Is(allocateRequest) {
+		return false
+	}
+	client, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return false
+	}
+	owner := owner(conf.Replicas, client.IP)
+	if owner == nil || owner.Equal(conf.Self) {
+		return false
+	}
+
+	// the first, unauthenticated Allocate request is challenged as usual, so that the
+	// redirects can be authenticated with the key of the user
+	m := &stun.Message{Raw: append([]byte(nil), b...)}
+	if err := m.Decode(); err != nil {
+		return false
+	}
+	key, ok := c.integrity(m, addr)
+	if !ok {
+		return false
+	}
+
+	if !conf.Redirect {
+		monitoring.HashRingMisroutedCounter.WithLabelValues(c.listener, "hint").Inc()
+		c.ring.log.Debugf("allocation of client %s on listener %s belongs to replica %s",
+			addr, c.listener, owner)
+		return false
+	}
+
+	res, err := stun.Build(stun.NewTransactionIDSetter(m.TransactionID), allocateError,
+		stun.CodeTryAlternate, &stun.AlternateServer{IP: owner, Port: c.port},
+		stun.MessageIntegrity(key), stun.Fingerprint)
+	if err != nil {
+		c.ring.log.Warnf("cannot redirect client %s: %s", addr, err.Error())
+		return false
+	}
+	if _, err := c.PacketConn.WriteTo(res.Raw, addr); err != nil {
+		c.ring.log.Debugf("cannot redirect client %s: %s", addr, err.Error())
+	}
+	monitoring.HashRingMisroutedCounter.WithLabelValues(c.listener, "redirect").Inc()
+	c.ring.log.Debugf("redirecting allocation of client %s on listener %s to replica %s",
+		addr, c.listener, owner)
+	return true
+}
+
+// integrity checks the MESSAGE-INTEGRITY attribute of a message against the long-term key of the
+// user in the USERNAME and REALM attributes, and returns the key
+func (c *packetConn) integrity(m *stun.Message, client net.Addr) ([]byte, bool) {
+	var username stun.Username
+	var realm stun.Realm
+	if !m.Contains(stun.AttrMessageIntegrity) || username.GetFrom(m) != nil ||
+		realm.GetFrom(m) != nil {
+		return nil, false
+	}
+	key, ok := c.key(username.String(), realm.String(), client)
+	if !ok || stun.MessageIntegrity(key).Check(m) != nil {
+		return nil, false
+	}
+	return key, true
+}
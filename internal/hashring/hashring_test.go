@@ -0,0 +1,122 @@
+package hashring
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pion/logging"
+	"github.com/pion/stun"
+	"github.com/pion/turn/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOwner(t *testing.T) {
+	replicas := []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2"), net.ParseIP("10.0.0.3")}
+
+	clients := map[string]net.IP{}
+	owned := map[string]int{}
+	for i := 0; i < 300; i++ {
+		client := net.ParseIP(fmt.Sprintf("192.168.%d.%d", i/250, i%250+1))
+		o := owner(replicas, client)
+		assert.NotNil(t, o, "owner")
+		assert.Equal(t, o, owner(replicas, client), "stable")
+		clients[client.String()] = o
+		owned[o.String()]++
+	}
+	assert.Len(t, owned, 3, "all replicas own clients")
+
+	// removing a replica only moves the clients of the replica
+	for c, o := range clients {
+		n := owner(replicas[:2], net.ParseIP(c))
+		if !o.Equal(replicas[2]) {
+			assert.Equal(t, o, n, "client %s moved", c)
+		}
+	}
+
+	ring := NewRing(logging.NewDefaultLoggerFactory())
+	assert.Nil(t, ring.Owner(net.ParseIP("192.168.0.1")), "disabled")
+	ring.SetConfig(&Config{Replicas: replicas, Self: replicas[0]})
+	assert.Equal(t, clients["192.168.0.1"], ring.Owner(net.ParseIP("192.168.0.1")), "enabled")
+}
+
+func TestPacketConn(t *testing.T) {
+	ring := NewRing(logging.NewDefaultLoggerFactory())
+	key := turn.GenerateAuthKey("user1", "realm", "passwd1")
+	keyFunc := func(username, realm string, _ net.Addr) ([]byte, bool) {
+		return key, username == "user1"
+	}
+
+	server, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	assert.NoError(t, err, "listen")
+	conn := ring.NewPacketConn(server, "udp", 3478, keyFunc)
+	defer conn.Close()
+	client, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	assert.NoError(t, err, "client")
+	defer client.Close()
+
+	// the client is owned by the other replica
+	replicas := []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("10.0.0.2")}
+	other := owner(replicas, net.ParseIP("127.0.0.1"))
+	self := replicas[0]
+	if self.Equal(other) {
+		self = replicas[1]
+	}
+	ring.SetConfig(&Config{Replicas: replicas, Self: self, Redirect: true})
+
+	allocate := func(auth bool) []byte {
+		setters := []stun.Setter{stun.TransactionID, stun.NewType(stun.MethodAllocate, stun.ClassRequest)}
+		if auth {
+			setters = append(setters, stun.NewUsername("user1"), stun.NewRealm("realm"),
+				stun.NewNonce("nonce"), stun.MessageIntegrity(key))
+		}
+		m, err := stun.Build(append(setters, stun.Fingerprint)...)
+		assert.NoError(t, err, "build")
+		return m.Raw
+	}
+	buf := make([]byte, 1500)
+	assert.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)), "deadline")
+	assert.NoError(t, client.SetReadDeadline(time.Now().Add(2*time.Second)), "deadline")
+
+	// unauthenticated requests are challenged by the server as usual
+	req := allocate(false)
+	_, err = client.WriteTo(req, server.LocalAddr())
+	assert.NoError(t, err, "write")
+	n, _, err := conn.ReadFrom(buf)
+	assert.NoError(t, err, "read")
+	assert.Equal(t, req, buf[:n], "unauthenticated request")
+
+	// authenticated requests are redirected to the owner
+	_, err = client.WriteTo(allocate(true), server.LocalAddr())
+	assert.NoError(t, err, "write")
+	req = allocate(false)
+	_, err = client.WriteTo(req, server.LocalAddr())
+	assert.NoError(t, err, "write")
+	n, _, err = conn.ReadFrom(buf)
+	assert.NoError(t, err, "read")
+	assert.Equal(t, req, buf[:n], "redirected request dropped")
+
+	n, _, err = client.ReadFrom(buf)
+	assert.NoError(t, err, "redirect")
+	res := &stun.Message{Raw: buf[:n]}
+	assert.NoError(t, res.Decode(), "decode")
+	assert.Equal(t, stun.ClassErrorResponse, res.Type.Class, "error response")
+	var code stun.ErrorCodeAttribute
+	assert.NoError(t, code.GetFrom(res), "error code")
+	assert.Equal(t, stun.CodeTryAlternate, code.Code, "try alternate")
+	var alt stun.AlternateServer
+	assert.NoError(t, alt.GetFrom(res), "alternate server")
+	assert.True(t, other.Equal(alt.IP), "owner")
+	assert.Equal(t, 3478, alt.Port, "listener port")
+	assert.NoError(t, stun.MessageIntegrity(key).Check(res), "integrity")
+
+	// in hint mode the requests are passed on
+	ring.SetConfig(&Config{Replicas: replicas, Self: self})
+	req = allocate(true)
+	_, err = client.WriteTo(req, server.LocalAddr())
+	assert.NoError(t, err, "write")
+	n, _, err = conn.ReadFrom(buf)
+	assert.NoError(t, err, "read")
+	assert.Equal(t, req, buf[:n], "hint")
+}
@@ -0,0 +1,73 @@
+// Package icmp captures the ICMP errors received on relay transports (port unreachable,
+// fragmentation needed, etc.), so that they can be forwarded to TURN clients as per RFC 8656.
+package icmp
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/pion/turn/v2"
+)
+
+// Error is an ICMP error received in response to a packet sent to a peer
+type Error struct {
+	// Peer is the destination of the packet that triggered the error
+	Peer *net.UDPAddr
+	// IPv6 is true for ICMPv6 errors
+	IPv6 bool
+	// Type and Code are the ICMP (or ICMPv6) type and code
+	Type, Code uint8
+	// Info is the error data, e.g., the next-hop MTU for "fragmentation needed" errors
+	Info uint32
+}
+
+// String returns a human-readable representation of the error
+func (e *Error) String() string {
+	proto := "ICMP"
+	if e.IPv6 {
+		proto = "ICMPv6"
+	}
+	return fmt.Sprintf("%s type %d code %d from peer %s", proto, e.Type, e.Code, e.Peer)
+}
+
+// Reason returns a short name for the error, suitable as a metric label
+func (e *Error) Reason() string {
+	switch {
+	case !e.IPv6 && e.Type == 3 && e.Code == 3, e.IPv6 && e.Type == 1 && e.Code == 4:
+		return "port_unreachable"
+	case !e.IPv6 && e.Type == 3 && e.Code == 4, e.IPv6 && e.Type == 2:
+		return "fragmentation_needed"
+	case !e.IPv6 && e.Type == 3, e.IPv6 && e.Type == 1:
+		return "destination_unreachable"
+	case !e.IPv6 && e.Type == 11, e.IPv6 && e.Type == 3:
+		return "time_exceeded"
+	default:
+		return "other"
+	}
+}
+
+// Handler is called for each ICMP error received on a relay transport
+type Handler func(relay net.Addr, e *Error)
+
+// relayAddressGenerator wraps a TURN relay address generator so that the ICMP errors received
+// on the relay transports it allocates are reported to a handler
+type relayAddressGenerator struct {
+	turn.RelayAddressGenerator
+	handler Handler
+}
+
+// NewRelayAddressGenerator wraps the relay address generator of a listener. Relay transports that
+// do not support capturing ICMP errors (e.g., over vnet or on non-Linux platforms) are left
+// unchanged.
+func NewRelayAddressGenerator(gen turn.RelayAddressGenerator, handler Handler) turn.RelayAddressGenerator {
+	return &relayAddressGenerator{RelayAddressGenerator: gen, handler: handler}
+}
+
+func (r *relayAddressGenerator) AllocatePacketConn(network string, requestedPort int) (net.PacketConn, net.Addr, error) {
+	conn, addr, err := r.RelayAddressGenerator.AllocatePacketConn(network, requestedPort)
+	if err != nil {
+		return conn, addr, err
+	}
+
+	return newRelayConn(conn, addr, r.handler), addr, nil
+}
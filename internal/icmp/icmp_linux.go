@@ -0,0 +1,176 @@
+//go:build linux
+// +build linux
+
+package icmp
+
+import (
+	"errors"
+	"net"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/l7mp/stunner/internal/udp"
+	"github.com/l7mp/stunner/internal/util"
+)
+
+// errQueueDrainLimit bounds the number of errors read from the error queue in one go
+const errQueueDrainLimit = 64
+
+// relayConn is a relay transport with IP_RECVERR set: ICMP errors are queued on the socket error
+// queue and reported to the handler instead of failing the reads and writes of the TURN server
+type relayConn struct {
+	net.PacketConn
+	raw     syscall.RawConn
+	relay   net.Addr
+	ipv6    bool
+	handler Handler
+}
+
+// writeErrorHandlerSetter is implemented by the relay transports that send asynchronously (e.g.,
+// the batched UDP sockets), which cannot return the write errors to the caller
+type writeErrorHandlerSetter interface {
+	SetWriteErrorHandler(h udp.WriteErrorHandler)
+}
+
+func newRelayConn(conn net.PacketConn, relay net.Addr, handler Handler) net.PacketConn {
+	// both plain and batched UDP sockets expose the underlying socket
+	sysConn, ok := conn.(syscall.Conn)
+	if !ok {
+		return conn
+	}
+
+	raw, err := sysConn.SyscallConn()
+	if err != nil {
+		return conn
+	}
+
+	ipv6 := false
+	if a, ok := conn.LocalAddr().(*net.UDPAddr); ok && a.IP.To4() == nil {
+		ipv6 = true
+	}
+
+	var serr error
+	if err := raw.Control(func(fd uintptr) {
+		if ipv6 {
+			serr = unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_RECVERR, 1)
+		} else {
+			serr = unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_RECVERR, 1)
+		}
+	}); err != nil || serr != nil {
+		return conn
+	}
+
+	c := &relayConn{PacketConn: conn, raw: raw, relay: relay, ipv6: ipv6, handler: handler}
+	if s, ok := conn.(writeErrorHandlerSetter); ok {
+		s.SetWriteErrorHandler(func(err error) bool {
+			if !isICMPErr(err) {
+				return false
+			}
+			c.drainErrQueue()
+			return true
+		})
+	}
+
+	return c
+}
+
+func (c *relayConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		n, addr, err := c.PacketConn.ReadFrom(b)
+		if err == nil || !isICMPErr(err) {
+			return n, addr, err
+		}
+		// the pending error was reported by the kernel, the ICMP message is on the error queue
+		c.drainErrQueue()
+	}
+}
+
+func (c *relayConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	n, err := c.PacketConn.WriteTo(b, addr)
+	if err != nil && isICMPErr(err) {
+		// the send failed due to an earlier ICMP error, not this packet: retry once
+		c.drainErrQueue()
+		return c.PacketConn.WriteTo(b, addr)
+	}
+	return n, err
+}
+
+func isICMPErr(err error) bool {
+	return errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.EHOSTUNREACH) ||
+		errors.Is(err, syscall.ENETUNREACH) || errors.Is(err, syscall.EMSGSIZE) ||
+		errors.Is(err, syscall.EHOSTDOWN) || errors.Is(err, syscall.EPROTO)
+}
+
+// drainErrQueue reads all the queued errors and reports the ones caused by ICMP messages
+func (c *relayConn) drainErrQueue() {
+	var buf [1]byte
+	oobBuf := util.DefaultBufferPool.Get(512)
+	defer util.DefaultBufferPool.Put(oobBuf)
+	oob := *oobBuf
+
+	for i := 0; i < errQueueDrainLimit; i++ {
+		var oobn int
+		var from unix.Sockaddr
+		var rerr error
+		err := c.raw.Read(func(fd uintptr) bool {
+			_, oobn, _, from, rerr = unix.Recvmsg(int(fd), buf[:], oob, unix.MSG_ERRQUEUE|unix.MSG_DONTWAIT)
+			// never block on an empty error queue
+			return true
+		})
+		if err != nil || rerr != nil {
+			return
+		}
+
+		if e := c.parseErr(oob[:oobn], from); e != nil && c.handler != nil {
+			c.handler(c.relay, e)
+		}
+	}
+}
+
+func (c *relayConn) parseErr(oob []byte, from unix.Sockaddr) *Error {
+	msgs, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return nil
+	}
+
+	for _, m := range msgs {
+		isV4 := m.Header.Level == unix.IPPROTO_IP && m.Header.Type == unix.IP_RECVERR
+		isV6 := m.Header.Level == unix.IPPROTO_IPV6 && m.Header.Type == unix.IPV6_RECVERR
+		if !isV4 && !isV6 {
+			continue
+		}
+		if len(m.Data) < int(unsafe.Sizeof(unix.SockExtendedErr{})) {
+			continue
+		}
+
+		ee := (*unix.SockExtendedErr)(unsafe.Pointer(&m.Data[0]))
+		if ee.Origin != unix.SO_EE_ORIGIN_ICMP && ee.Origin != unix.SO_EE_ORIGIN_ICMP6 {
+			continue
+		}
+
+		e := &Error{IPv6: ee.Origin == unix.SO_EE_ORIGIN_ICMP6, Type: ee.Type, Code: ee.Code,
+			Info: ee.Info}
+
+		// the original destination of the failed packet is returned in msg_name
+		switch a := from.(type) {
+		case *unix.SockaddrInet4:
+			e.Peer = &net.UDPAddr{IP: net.IPv4(a.Addr[0], a.Addr[1], a.Addr[2], a.Addr[3]),
+				Port: a.Port}
+		case *unix.SockaddrInet6:
+			ip := make(net.IP, net.IPv6len)
+			copy(ip, a.Addr[:])
+			if v4 := ip.To4(); v4 != nil {
+				ip = v4
+			}
+			e.Peer = &net.UDPAddr{IP: ip, Port: a.Port}
+		default:
+			continue
+		}
+
+		return e
+	}
+
+	return nil
+}
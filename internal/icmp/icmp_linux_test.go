@@ -0,0 +1,73 @@
+//go:build linux
+// +build linux
+
+package icmp
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pion/logging"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/l7mp/stunner/internal/udp"
+)
+
+func TestRelayConnPortUnreachable(t *testing.T) {
+	t.Run("plain", func(t *testing.T) {
+		testRelayConnPortUnreachable(t, func(c net.PacketConn) net.PacketConn { return c })
+	})
+	t.Run("batched", func(t *testing.T) {
+		testRelayConnPortUnreachable(t, func(c net.PacketConn) net.PacketConn {
+			return udp.NewBatchConn(c, "test", 8, logging.NewDefaultLoggerFactory())
+		})
+	})
+}
+
+func testRelayConnPortUnreachable(t *testing.T, wrap func(net.PacketConn) net.PacketConn) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	assert.NoError(t, err, "relay socket")
+	conn = wrap(conn)
+
+	errs := make(chan *Error, 8)
+	relay := newRelayConn(conn, conn.LocalAddr(), func(_ net.Addr, e *Error) { errs <- e })
+	defer relay.Close()
+	_, ok := relay.(*relayConn)
+	assert.True(t, ok, "IP_RECVERR enabled")
+
+	// find a closed port
+	closed, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	assert.NoError(t, err, "peer socket")
+	peer := closed.LocalAddr().(*net.UDPAddr)
+	closed.Close()
+
+	// a live peer to unblock the reader
+	live, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	assert.NoError(t, err, "live peer socket")
+	defer live.Close()
+
+	_, err = relay.WriteTo([]byte("hello"), peer)
+	assert.NoError(t, err, "write to closed port")
+
+	time.Sleep(50 * time.Millisecond)
+	_, err = live.WriteTo([]byte("ping"), relay.LocalAddr())
+	assert.NoError(t, err, "write from live peer")
+
+	// the pending error must not fail the read
+	buf := make([]byte, 1500)
+	assert.NoError(t, relay.SetReadDeadline(time.Now().Add(2*time.Second)), "deadline")
+	n, from, err := relay.ReadFrom(buf)
+	assert.NoError(t, err, "read")
+	assert.Equal(t, "ping", string(buf[:n]), "payload")
+	assert.Equal(t, live.LocalAddr().String(), from.String(), "source")
+
+	select {
+	case e := <-errs:
+		assert.Equal(t, peer.String(), e.Peer.String(), "peer")
+		assert.False(t, e.IPv6, "ICMPv4")
+		assert.Equal(t, "port_unreachable", e.Reason(), "reason")
+	case <-time.After(2 * time.Second):
+		t.Fatal("ICMP error not reported")
+	}
+}
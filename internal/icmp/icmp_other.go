@@ -0,0 +1,13 @@
+//go:build !linux
+// +build !linux
+
+package icmp
+
+import (
+	"net"
+)
+
+// newRelayConn is a no-op on platforms without IP_RECVERR: ICMP errors are not reported.
+func newRelayConn(conn net.PacketConn, relay net.Addr, handler Handler) net.PacketConn {
+	return conn
+}
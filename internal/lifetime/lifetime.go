@@ -0,0 +1,232 @@
+// Package lifetime enforces the lifetimes of the allocations, permissions and channel bindings of
+// the listeners, which may be set shorter than the defaults of the TURN server. The lifetime the
+// clients request for an allocation is cut to the maximum allocation lifetime of the listener by
+// rewriting the LIFETIME attribute of the Allocate and Refresh requests at the socket layer, so that
+// the TURN server grants, and reports back to the client, the shorter lifetime. Permissions and
+// channel bindings have no lifetime negotiation in the protocol: their expiry is tracked in the
+// conntrack table, which drops the traffic of the expired ones.
+package lifetime
+
+import (
+	"encoding/binary"
+	"net"
+	"time"
+
+	"github.com/pion/logging"
+	"github.com/pion/stun"
+
+	"github.com/l7mp/stunner/internal/monitoring"
+	"github.com/l7mp/stunner/internal/stunmsg"
+)
+
+const (
+	// DefaultPermission is the lifetime of the permissions in the TURN server (RFC 8656)
+	DefaultPermission = 5 * time.Minute
+	// DefaultChannel is the lifetime of the channel bindings in the TURN server (RFC 8656)
+	DefaultChannel = 10 * time.Minute
+	// DefaultAllocation is the lifetime of the allocations requested with no LIFETIME attribute
+	DefaultAllocation = 10 * time.Minute
+	// MaxAllocation is the longest allocation lifetime granted by the TURN server
+	MaxAllocation = time.Hour
+)
+
+var (
+	allocateRequest = stun.NewType(stun.MethodAllocate, stun.ClassRequest).Value()
+	refreshRequest  = stun.NewType(stun.MethodRefresh, stun.ClassRequest).Value()
+)
+
+// Policy sets the lifetimes of a listener, zero means the default of the TURN server
+type Policy struct {
+	// Permission is the lifetime of the permissions
+	Permission time.Duration
+	// Channel is the lifetime of the channel bindings
+	Channel time.Duration
+	// MaxAllocation is the longest lifetime granted to an allocation
+	MaxAllocation time.Duration
+}
+
+// PermissionLifetime returns the effective permission lifetime
+func (p Policy) PermissionLifetime() time.Duration {
+	if p.Permission <= 0 || p.Permission > DefaultPermission {
+		return DefaultPermission
+	}
+	return p.Permission
+}
+
+// ChannelLifetime returns the effective channel binding lifetime
+func (p Policy) ChannelLifetime() time.Duration {
+	if p.Channel <= 0 || p.Channel > DefaultChannel {
+		return DefaultChannel
+	}
+	return p.Channel
+}
+
+// MaxAllocationLifetime returns the effective maximum allocation lifetime
+func (p Policy) MaxAllocationLifetime() time.Duration {
+	if p.MaxAllocation <= 0 || p.MaxAllocation > MaxAllocation {
+		return MaxAllocation
+	}
+	return p.MaxAllocation
+}
+
+// Clamper cuts the lifetime requested for the allocations of a listener to the current maximum
+type Clamper struct {
+	listener string
+	policy   func() Policy
+	key      stunmsg.KeyFunc
+	log      logging.LeveledLogger
+}
+
+// NewClamper creates a clamper for a listener. The policy callback returns the current lifetimes
+// of the listener and the key callback the long-term keys to check and recompute the integrity of
+// the rewritten requests with
+func NewClamper(listener string, policy func() Policy, key stunmsg.KeyFunc, logger logging.LoggerFactory) *Clamper {
+	return &Clamper{
+		listener: listener,
+		policy:   policy,
+		key:      key,
+		log:      logger.NewLogger("lifetime"),
+	}
+}
+
+// Clamp checks an authenticated Allocate or Refresh request, with the header h, received from a
+// client and returns the request rewritten to the maximum allocation lifetime, or nil if the
+// request is passed to the TURN server as is. Requests that fail the integrity check are never
+// rewritten
+func (c *Clamper) Clamp(b []byte, h stunmsg.Header, client net.Addr) []byte {
+	if !h.Is(allocateRequest) && !h.Is(refreshRequest) {
+		return nil
+	}
+	max := c.policy().MaxAllocationLifetime()
+	if max >= MaxAllocation {
+		return nil
+	}
+
+	m := &stun.Message{Raw: append([]byte(nil), b...)}
+	if err := m.Decode(); err != nil || !m.Contains(stun.AttrMessageIntegrity) {
+		return nil
+	}
+
+	requested := DefaultAllocation
+	if v, err := m.Get(stun.AttrLifetime); err == nil && len(v) == 4 {
+		requested = time.Duration(binary.BigEndian.Uint32(v)) * time.Second
+	}
+	// a zero lifetime deletes the allocation
+	if requested == 0 || requested <= max {
+		return nil
+	}
+
+	var username stun.Username
+	var realm stun.Realm
+	if username.GetFrom(m) != nil || realm.GetFrom(m) != nil {
+		return nil
+	}
+	key, ok := c.key(username.String(), realm.String(), client)
+	if !ok || stun.MessageIntegrity(key).Check(m) != nil {
+		return nil
+	}
+
+	out, err := rewrite(m, key, max)
+	if err != nil {
+		c.log.Debugf("cannot rewrite lifetime of %s from %s: %s", m.Type.String(), client,
+			err.Error())
+		return nil
+	}
+
+	monitoring.AllocationLifetimeClampedCounter.WithLabelValues(c.listener).Inc()
+	c.log.Tracef("cutting lifetime of %s from %s on listener %s: %s -> %s", m.Type.String(),
+		client, c.listener, requested, max)
+	return out
+}
+
+// rewrite re-encodes a request with the LIFETIME attribute set to the given lifetime and the
+// MESSAGE-INTEGRITY and FINGERPRINT attributes recomputed. The attributes after the
+// MESSAGE-INTEGRITY, ignored by the TURN server, are removed
+func rewrite(m *stun.Message, key []byte, lifetime time.Duration) ([]byte, error) {
+	v := make([]byte, 4)
+	binary.BigEndian.PutUint32(v, uint32(lifetime/time.Second))
+
+	out := stun.New()
+	out.Type = m.Type
+	out.TransactionID = m.TransactionID
+	out.WriteHeader()
+
+	found := false
+	for _, a := range m.Attributes {
+		if a.Type == stun.AttrMessageIntegrity {
+			break
+		}
+		if a.Type == stun.AttrLifetime {
+			out.Add(stun.AttrLifetime, v)
+			found = true
+			continue
+		}
+		out.Add(a.Type, a.Value)
+	}
+	if !found {
+		out.Add(stun.AttrLifetime, v)
+	}
+
+	if err := stun.MessageIntegrity(key).AddTo(out); err != nil {
+		return nil, err
+	}
+	if m.Contains(stun.AttrFingerprint) {
+		if err := stun.Fingerprint.AddTo(out); err != nil {
+			return nil, err
+		}
+	}
+	return out.Raw, nil
+}
+
+// NewPacketConn wraps the socket of a packet listener so that the allocation requests reach the
+// TURN server with the lifetime cut to the maximum
+func (c *Clamper) NewPacketConn(conn net.PacketConn) net.PacketConn {
+	return &packetConn{PacketConn: conn, clamper: c}
+}
+
+type packetConn struct {
+	net.PacketConn
+	clamper *Clamper
+}
+
+func (c *packetConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, _, err := c.ReadMessage(b)
+	return n, addr, err
+}
+
+func (c *packetConn) ReadMessage(b []byte) (int, net.Addr, stunmsg.Header, error) {
+	n, addr, h, err := stunmsg.ReadMessage(c.PacketConn, b)
+	if err != nil {
+		return n, addr, h, err
+	}
+	if out := c.clamper.Clamp(b[:n], h, addr); out != nil && len(out) <= len(b) {
+		n = copy(b, out)
+		h = stunmsg.Parse(b[:n])
+	}
+	return n, addr, h, err
+}
+
+// NewListener wraps the socket of a stream listener so that the allocation requests reach the TURN
+// server with the lifetime cut to the maximum
+func (c *Clamper) NewListener(ln net.Listener) net.Listener {
+	return &streamListener{Listener: ln, clamper: c}
+}
+
+type streamListener struct {
+	net.Listener
+	clamper *Clamper
+}
+
+func (l *streamListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return conn, err
+	}
+	client := conn.RemoteAddr()
+	return stunmsg.NewStreamConn(conn, func(b []byte, h stunmsg.Header) ([]byte, []byte) {
+		if out := l.clamper.Clamp(b, h, client); out != nil {
+			return out, nil
+		}
+		return b, nil
+	}), nil
+}
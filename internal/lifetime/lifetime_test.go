@@ -0,0 +1,190 @@
+package lifetime
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pion/logging"
+	"github.com/pion/stun"
+	"github.com/pion/turn/v2"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/l7mp/stunner/internal/stunmsg"
+)
+
+const testRealm = "stunner.l7mp.io"
+
+var (
+	testClient = &net.UDPAddr{IP: net.ParseIP("1.2.3.4"), Port: 1234}
+	testKey    = turn.GenerateAuthKey("user1", testRealm, "passwd1")
+	allocate   = stun.NewType(stun.MethodAllocate, stun.ClassRequest)
+	transport  = stun.RawAttribute{Type: stun.AttrRequestedTransport, Value: []byte{17, 0, 0, 0}}
+)
+
+func testKeyFunc(username, realm string, _ net.Addr) ([]byte, bool) {
+	if username != "user1" {
+		return nil, false
+	}
+	return testKey, true
+}
+
+func newTestClamper(p Policy) *Clamper {
+	return NewClamper("udp", func() Policy { return p }, testKeyFunc,
+		logging.NewDefaultLoggerFactory())
+}
+
+func clamp(c *Clamper, b []byte) []byte {
+	return c.Clamp(b, stunmsg.Parse(b), testClient)
+}
+
+func lifetimeAttr(d time.Duration) stun.RawAttribute {
+	v := make([]byte, 4)
+	binary.BigEndian.PutUint32(v, uint32(d/time.Second))
+	return stun.RawAttribute{Type: stun.AttrLifetime, Value: v}
+}
+
+func build(t *testing.T, setters ...stun.Setter) []byte {
+	m, err := stun.Build(append([]stun.Setter{stun.TransactionID}, setters...)...)
+	assert.NoError(t, err, "build")
+	return m.Raw
+}
+
+func buildAuth(t *testing.T, setters ...stun.Setter) []byte {
+	setters = append(setters, stun.NewUsername("user1"), stun.NewRealm(testRealm),
+		stun.NewNonce("nonce"), stun.NewLongTermIntegrity("user1", testRealm, "passwd1"),
+		stun.Fingerprint)
+	return build(t, setters...)
+}
+
+// decode checks the integrity of a rewritten message and returns its LIFETIME
+func decode(t *testing.T, b []byte) time.Duration {
+	m := &stun.Message{Raw: b}
+	assert.NoError(t, m.Decode(), "decode")
+	assert.NoError(t, stun.MessageIntegrity(testKey).Check(m), "integrity")
+	assert.NoError(t, stun.Fingerprint.Check(m), "fingerprint")
+	var username stun.Username
+	assert.NoError(t, username.GetFrom(m), "username")
+	assert.Equal(t, "user1", username.String(), "username")
+	v, err := m.Get(stun.AttrLifetime)
+	assert.NoError(t, err, "lifetime")
+	return time.Duration(binary.BigEndian.Uint32(v)) * time.Second
+}
+
+func TestPolicy(t *testing.T) {
+	p := Policy{}
+	assert.Equal(t, DefaultPermission, p.PermissionLifetime(), "default permission")
+	assert.Equal(t, DefaultChannel, p.ChannelLifetime(), "default channel")
+	assert.Equal(t, MaxAllocation, p.MaxAllocationLifetime(), "default allocation")
+
+	p = Policy{Permission: time.Minute, Channel: time.Hour, MaxAllocation: 5 * time.Minute}
+	assert.Equal(t, time.Minute, p.PermissionLifetime(), "permission")
+	assert.Equal(t, DefaultChannel, p.ChannelLifetime(), "lifetimes cannot be extended")
+	assert.Equal(t, 5*time.Minute, p.MaxAllocationLifetime(), "allocation")
+}
+
+func TestClampDisabled(t *testing.T) {
+	c := newTestClamper(Policy{})
+	b := buildAuth(t, allocate, transport, lifetimeAttr(time.Hour))
+	assert.Nil(t, clamp(c, b), "no maximum")
+}
+
+func TestClampLifetime(t *testing.T) {
+	c := newTestClamper(Policy{MaxAllocation: 5 * time.Minute})
+
+	out := clamp(c, buildAuth(t, allocate, transport, lifetimeAttr(time.Hour)))
+	assert.NotNil(t, out, "rewritten")
+	assert.Equal(t, 5*time.Minute, decode(t, out), "lifetime cut to maximum")
+
+	refresh := stun.NewType(stun.MethodRefresh, stun.ClassRequest)
+	out = clamp(c, buildAuth(t, refresh, lifetimeAttr(20*time.Minute)))
+	assert.NotNil(t, out, "refresh rewritten")
+	assert.Equal(t, 5*time.Minute, decode(t, out), "refresh lifetime cut to maximum")
+
+	// no LIFETIME means the default 10 minutes
+	out = clamp(c, buildAuth(t, allocate, transport))
+	assert.NotNil(t, out, "lifetime inserted")
+	assert.Equal(t, 5*time.Minute, decode(t, out), "inserted lifetime")
+
+	assert.Nil(t, clamp(c, buildAuth(t, allocate, transport, lifetimeAttr(time.Minute))),
+		"shorter lifetime")
+	assert.Nil(t, clamp(c, buildAuth(t, refresh, lifetimeAttr(0))), "deallocation")
+}
+
+func TestClampUnauthenticated(t *testing.T) {
+	c := newTestClamper(Policy{MaxAllocation: 5 * time.Minute})
+
+	// the first Allocate of the clients carries no credentials
+	assert.Nil(t, clamp(c, build(t, allocate, transport, lifetimeAttr(time.Hour))),
+		"no integrity")
+
+	b := build(t, allocate, transport, lifetimeAttr(time.Hour), stun.NewUsername("user1"),
+		stun.NewRealm(testRealm), stun.NewNonce("nonce"),
+		stun.NewLongTermIntegrity("user1", testRealm, "wrong"))
+	assert.Nil(t, clamp(c, b), "bad integrity")
+
+	b = build(t, allocate, transport, lifetimeAttr(time.Hour), stun.NewUsername("user2"),
+		stun.NewRealm(testRealm), stun.NewNonce("nonce"),
+		stun.NewLongTermIntegrity("user2", testRealm, "passwd1"))
+	assert.Nil(t, clamp(c, b), "unknown user")
+
+	// other requests pass
+	assert.Nil(t, clamp(c, buildAuth(t, stun.BindingRequest, lifetimeAttr(time.Hour))),
+		"binding request")
+	assert.Nil(t, clamp(c, []byte{0x40, 0x00, 0x00, 0x04, 1, 2, 3, 4}), "channel data")
+}
+
+type testPacketConn struct {
+	net.PacketConn
+	b []byte
+}
+
+func (c *testPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	return copy(b, c.b), testClient, nil
+}
+
+func TestClampPacketConn(t *testing.T) {
+	c := newTestClamper(Policy{MaxAllocation: 2 * time.Minute})
+	conn := c.NewPacketConn(&testPacketConn{b: buildAuth(t, allocate, transport)})
+
+	buf := make([]byte, 1500)
+	n, addr, err := conn.ReadFrom(buf)
+	assert.NoError(t, err, "read")
+	assert.Equal(t, testClient, addr, "client")
+	assert.Equal(t, 2*time.Minute, decode(t, buf[:n]), "lifetime inserted")
+}
+
+func TestClampListener(t *testing.T) {
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err, "server socket")
+	c := newTestClamper(Policy{MaxAllocation: 2 * time.Minute})
+	ln := c.NewListener(tcp)
+	defer ln.Close()
+
+	client, err := net.Dial("tcp", tcp.Addr().String())
+	assert.NoError(t, err, "client socket")
+	defer client.Close()
+	server, err := ln.Accept()
+	assert.NoError(t, err, "accept")
+	defer server.Close()
+
+	// two pipelined requests, the second split across two segments
+	req := buildAuth(t, allocate, transport, lifetimeAttr(time.Hour))
+	b := append(append([]byte{}, req...), req[:10]...)
+	_, err = client.Write(b)
+	assert.NoError(t, err, "send requests")
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		client.Write(req[10:]) //nolint:errcheck
+	}()
+
+	conn := turn.NewSTUNConn(server)
+	buf := make([]byte, 1500)
+	for i := 0; i < 2; i++ {
+		server.SetReadDeadline(time.Now().Add(time.Second)) //nolint:errcheck
+		n, _, err := conn.ReadFrom(buf)
+		assert.NoError(t, err, "read")
+		assert.Equal(t, 2*time.Minute, decode(t, buf[:n]), "lifetime cut to maximum")
+	}
+}
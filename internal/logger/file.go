@@ -0,0 +1,149 @@
+package logger
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat is the timestamp appended to the name of rotated log files
+const backupTimeFormat = "20060102T150405.000"
+
+// FileWriter writes logs to a file, rotating the file when it grows larger than MaxSize bytes or
+// when it has been written for longer than MaxAge. Rotated files are renamed to
+// "<path>.<timestamp>" and only the last MaxBackups rotated files are kept. A zero MaxSize or
+// MaxAge disables the corresponding rotation, a zero MaxBackups keeps all rotated files
+type FileWriter struct {
+	MaxSize    int64
+	MaxAge     time.Duration
+	MaxBackups int
+	path       string
+	file       *os.File
+	size       int64
+	opened     time.Time
+	lock       sync.Mutex
+}
+
+// NewFileWriter opens a log file for appending, creating it if it does not exist
+func NewFileWriter(path string, maxSize int64, maxAge time.Duration, maxBackups int) (*FileWriter, error) {
+	w := &FileWriter{MaxSize: maxSize, MaxAge: maxAge, MaxBackups: maxBackups, path: path}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Path returns the path of the log file
+func (w *FileWriter) Path() string {
+	return w.path
+}
+
+// SetLimits updates the rotation limits
+func (w *FileWriter) SetLimits(maxSize int64, maxAge time.Duration, maxBackups int) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	w.MaxSize, w.MaxAge, w.MaxBackups = maxSize, maxAge, maxBackups
+}
+
+// Write writes a log line to the file, rotating the file first if needed
+func (w *FileWriter) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if w.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	if w.size > 0 && ((w.MaxSize > 0 && w.size+int64(len(p)) > w.MaxSize) ||
+		(w.MaxAge > 0 && time.Since(w.opened) >= w.MaxAge)) {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Rotate rotates the log file
+func (w *FileWriter) Rotate() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.file == nil {
+		return os.ErrClosed
+	}
+	return w.rotate()
+}
+
+// Close closes the log file
+func (w *FileWriter) Close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+func (w *FileWriter) open() error {
+	file, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("could not open log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("could not open log file: %w", err)
+	}
+
+	w.file, w.size, w.opened = file, info.Size(), time.Now()
+	return nil
+}
+
+func (w *FileWriter) rotate() error {
+	if err := w.file.Close(); err != nil {
+		return err
+	}
+	w.file = nil
+
+	backup := fmt.Sprintf("%s.%s", w.path, time.Now().UTC().Format(backupTimeFormat))
+	if err := os.Rename(w.path, backup); err != nil {
+		return fmt.Errorf("could not rotate log file: %w", err)
+	}
+	w.removeBackups()
+
+	return w.open()
+}
+
+// removeBackups removes all but the last MaxBackups rotated files
+func (w *FileWriter) removeBackups() {
+	if w.MaxBackups <= 0 {
+		return
+	}
+
+	matches, err := filepath.Glob(w.path + ".*")
+	if err != nil {
+		return
+	}
+	backups := []string{}
+	for _, m := range matches {
+		ts := strings.TrimPrefix(m, w.path+".")
+		if _, err := time.Parse(backupTimeFormat, ts); err == nil {
+			backups = append(backups, m)
+		}
+	}
+
+	// timestamps sort chronologically
+	sort.Strings(backups)
+	for len(backups) > w.MaxBackups {
+		os.Remove(backups[0])
+		backups = backups[1:]
+	}
+}
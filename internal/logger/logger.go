@@ -1,11 +1,14 @@
 package logger
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"log"
 	"os"
+	"path"
 	"strings"
+	"time"
 
 	"github.com/pion/logging"
 )
@@ -13,12 +16,45 @@ import (
 // we move the scope/level to after the timestamp and unify the format
 const defaultFlags = log.Lmicroseconds | log.Lshortfile | log.Lmsgprefix
 
+// Log formats
+const (
+	// FormatConsole is the free-form, human-readable log format
+	FormatConsole = "console"
+	// FormatJSON emits a JSON object per line, with the time, level, logger, caller and message
+	FormatJSON = "json"
+)
+
+// logLevels maps the names of the log levels to log levels
+var logLevels = map[string]logging.LogLevel{
+	"DISABLE": logging.LogLevelDisabled,
+	"ERROR":   logging.LogLevelError,
+	"WARN":    logging.LogLevelWarn,
+	"INFO":    logging.LogLevelInfo,
+	"DEBUG":   logging.LogLevelDebug,
+	"TRACE":   logging.LogLevelTrace,
+}
+
 // LoggerFactory defines levels by scopes and creates new LeveledLogger
 type LoggerFactory struct {
 	Writer          io.Writer
+	Format          string
 	DefaultLogLevel logging.LogLevel
 	ScopeLevels     map[string]logging.LogLevel
 	Loggers         map[string]*logging.DefaultLeveledLogger
+	patterns        []scopePattern
+	// ThrottleInterval and ThrottleBurst throttle the INFO, WARNING and ERROR lines of each
+	// logger: within an interval, repeated messages and the distinct messages beyond the burst
+	// are summarized instead of written. A non-positive interval disables throttling
+	ThrottleInterval time.Duration
+	ThrottleBurst    int
+	// Syslog, if set, receives a copy of the logs
+	Syslog *SyslogWriter
+}
+
+// scopePattern sets the level for the scopes matching a glob pattern, e.g., "stunner-cluster-*"
+type scopePattern struct {
+	pattern string
+	level   logging.LogLevel
 }
 
 // NewLoggerFactory sets up a scoped logger for STUNner
@@ -27,6 +63,7 @@ func NewLoggerFactory(levelSpec string) *LoggerFactory {
 	logger.DefaultLogLevel = logging.LogLevelError
 	logger.ScopeLevels = make(map[string]logging.LogLevel)
 	logger.Writer = os.Stdout
+	logger.Format = FormatConsole
 
 	logger.ScopeLevels = make(map[string]logging.LogLevel)
 	logger.Loggers = make(map[string]*logging.DefaultLeveledLogger)
@@ -45,35 +82,140 @@ func (f *LoggerFactory) NewLogger(scope string) logging.LeveledLogger {
 	}
 
 	// create a new one
-	logLevel := f.DefaultLogLevel
-	scopeLevel, found := f.ScopeLevels[scope]
+	l := logging.NewDefaultLeveledLoggerForScope(scope, f.levelFor(scope), f.Writer)
+	f.setOutput(l, scope)
 
-	if found {
-		logLevel = scopeLevel
+	f.Loggers[scope] = l
+
+	return l
+}
+
+// setOutput sets the writer, the format, the syslog copy and the throttling of a leveled logger
+func (f *LoggerFactory) setOutput(l *logging.DefaultLeveledLogger, scope string) {
+	newLogger := func(level string, severity int, throttled bool) *log.Logger {
+		var w io.Writer
+		var marker string
+		prefix, flags := fmt.Sprintf("%s %s: ", scope, level), defaultFlags
+		if f.Format == FormatJSON {
+			w = &jsonWriter{w: f.Writer, scope: scope, level: strings.ToLower(level)}
+			// "<file>:<line>: <message>"
+			prefix, flags, marker = "", log.Lshortfile, ": "
+		} else {
+			w, marker = f.Writer, prefix
+		}
+		if f.Syslog != nil {
+			w = &syslogTee{w: f.Syslog, next: w, scope: scope, severity: severity,
+				timestamp: f.Format != FormatJSON, prefix: prefix}
+		}
+		if throttled && f.ThrottleInterval > 0 {
+			w = newThrottleWriter(w, f.ThrottleInterval, f.ThrottleBurst,
+				f.Format != FormatJSON, marker)
+		}
+		return log.New(w, prefix, flags)
 	}
 
-	l := logging.NewDefaultLeveledLoggerForScope(scope, logLevel, f.Writer).
-		WithTraceLogger(log.New(f.Writer, fmt.Sprintf("%s TRACE: ", scope), defaultFlags)).
-		WithDebugLogger(log.New(f.Writer, fmt.Sprintf("%s DEBUG: ", scope), defaultFlags)).
-		WithInfoLogger(log.New(f.Writer, fmt.Sprintf("%s INFO: ", scope), defaultFlags)).
-		WithWarnLogger(log.New(f.Writer, fmt.Sprintf("%s WARNING: ", scope), defaultFlags)).
-		WithErrorLogger(log.New(f.Writer, fmt.Sprintf("%s ERROR: ", scope), defaultFlags))
+	// debug and trace logs are opt-in, so they are never throttled
+	l.WithTraceLogger(newLogger("TRACE", SeverityDebug, false)).
+		WithDebugLogger(newLogger("DEBUG", SeverityDebug, false)).
+		WithInfoLogger(newLogger("INFO", SeverityInfo, true)).
+		WithWarnLogger(newLogger("WARNING", SeverityWarning, true)).
+		WithErrorLogger(newLogger("ERROR", SeverityError, true))
+}
 
-	f.Loggers[scope] = l
+// SetSyslog sends a copy of the logs of all existing and future loggers to syslog, or stops
+// sending the logs to syslog if w is nil
+func (f *LoggerFactory) SetSyslog(w *SyslogWriter) {
+	f.Syslog = w
+	for scope, logger := range f.Loggers {
+		f.setOutput(logger, scope)
+	}
+}
 
-	return l
+// SetThrottle sets the throttling of all existing and future loggers, a non-positive interval
+// disables throttling
+func (f *LoggerFactory) SetThrottle(interval time.Duration, burst int) {
+	if interval == f.ThrottleInterval && burst == f.ThrottleBurst {
+		return
+	}
+
+	f.ThrottleInterval, f.ThrottleBurst = interval, burst
+	for scope, logger := range f.Loggers {
+		f.setOutput(logger, scope)
+	}
 }
 
-// NewLogger returns a configured LeveledLogger for the given , argsscope
-func (f *LoggerFactory) SetLevel(levelSpec string) {
-	logLevels := map[string]logging.LogLevel{
-		"DISABLE": logging.LogLevelDisabled,
-		"ERROR":   logging.LogLevelError,
-		"WARN":    logging.LogLevelWarn,
-		"INFO":    logging.LogLevelInfo,
-		"DEBUG":   logging.LogLevelDebug,
-		"TRACE":   logging.LogLevelTrace,
+// SetWriter redirects the output of all existing and future loggers to the given writer
+func (f *LoggerFactory) SetWriter(w io.Writer) {
+	f.Writer = w
+	for scope, logger := range f.Loggers {
+		f.setOutput(logger, scope)
+	}
+}
+
+// SetFormat sets the format of all existing and future loggers: FormatConsole or FormatJSON.
+// Unknown formats fall back to FormatConsole
+func (f *LoggerFactory) SetFormat(format string) {
+	format = strings.ToLower(format)
+	if format != FormatJSON {
+		format = FormatConsole
+	}
+	if format == f.Format {
+		return
+	}
+
+	f.Format = format
+	for scope, logger := range f.Loggers {
+		f.setOutput(logger, scope)
 	}
+}
+
+// jsonEntry is a log line in the JSON format
+type jsonEntry struct {
+	Time   string `json:"time"`
+	Level  string `json:"level"`
+	Logger string `json:"logger"`
+	Caller string `json:"caller,omitempty"`
+	Msg    string `json:"msg"`
+}
+
+// jsonWriter converts the lines of a log.Logger with the log.Lshortfile flag to JSON
+type jsonWriter struct {
+	w            io.Writer
+	scope, level string
+}
+
+func (j *jsonWriter) Write(p []byte) (int, error) {
+	// the log package writes a single line per call: "<file>:<line>: <message>\n"
+	line := strings.TrimSuffix(string(p), "\n")
+	e := jsonEntry{
+		Time:   time.Now().UTC().Format(time.RFC3339Nano),
+		Level:  j.level,
+		Logger: j.scope,
+		Msg:    line,
+	}
+	if parts := strings.SplitN(line, ": ", 2); len(parts) == 2 {
+		e.Caller, e.Msg = parts[0], parts[1]
+	}
+
+	out, err := json.Marshal(e)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := j.w.Write(append(out, '\n')); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// SetLevel sets the log levels from a comma-separated list of "<scope>:<level>" pairs, e.g.,
+// "all:INFO,stunner-cluster-*:TRACE,turn:ERROR". The scope "all" sets the default level and scopes
+// may be glob patterns as in path.Match. A scope takes the level of the exact match if any, then
+// that of the last matching pattern, then the default level. Scope levels not in the spec are
+// removed, but the default level is kept unless the spec sets it. Existing loggers are updated in
+// place.
+func (f *LoggerFactory) SetLevel(levelSpec string) {
+	f.ScopeLevels = make(map[string]logging.LogLevel)
+	f.patterns = []scopePattern{}
 
 	levels := strings.Split(levelSpec, ",")
 	for _, s := range levels {
@@ -81,8 +223,8 @@ func (f *LoggerFactory) SetLevel(levelSpec string) {
 		if len(scopedLevel) != 2 {
 			continue
 		}
-		scope := scopedLevel[0]
-		level := scopedLevel[1]
+		scope := strings.TrimSpace(scopedLevel[0])
+		level := strings.TrimSpace(scopedLevel[1])
 
 		// set log-level
 		l, found := logLevels[strings.ToUpper(level)]
@@ -95,15 +237,60 @@ func (f *LoggerFactory) SetLevel(levelSpec string) {
 			continue
 		}
 
+		if strings.ContainsAny(scope, "*?[") {
+			// skip malformed patterns
+			if _, err := path.Match(scope, ""); err != nil {
+				continue
+			}
+			f.patterns = append(f.patterns, scopePattern{pattern: scope, level: l})
+			continue
+		}
+
 		f.ScopeLevels[scope] = l
 	}
 
 	for scope, logger := range f.Loggers {
-		l, found := f.ScopeLevels[scope]
-		if !found {
-			l = f.DefaultLogLevel
+		logger.SetLevel(f.levelFor(scope))
+	}
+}
+
+// CheckLevel checks a log level specification, e.g., "all:INFO,stunner-cluster-*:TRACE", and
+// returns an error for the first malformed scope, pattern or level, which SetLevel would skip
+func CheckLevel(levelSpec string) error {
+	if strings.TrimSpace(levelSpec) == "" {
+		return fmt.Errorf("empty log level")
+	}
+
+	for _, s := range strings.Split(levelSpec, ",") {
+		scopedLevel := strings.SplitN(s, ":", 2)
+		if len(scopedLevel) != 2 || strings.TrimSpace(scopedLevel[0]) == "" {
+			return fmt.Errorf("invalid log level %q: expected <scope>:<level>", s)
 		}
+		scope := strings.TrimSpace(scopedLevel[0])
+		level := strings.TrimSpace(scopedLevel[1])
 
-		logger.SetLevel(l)
+		if _, found := logLevels[strings.ToUpper(level)]; !found {
+			return fmt.Errorf("invalid log level %q: unknown level %q", s, level)
+		}
+		if _, err := path.Match(scope, ""); err != nil {
+			return fmt.Errorf("invalid log level %q: malformed scope pattern %q", s, scope)
+		}
 	}
+
+	return nil
+}
+
+// levelFor returns the log level for a scope
+func (f *LoggerFactory) levelFor(scope string) logging.LogLevel {
+	if l, found := f.ScopeLevels[scope]; found {
+		return l
+	}
+
+	for i := len(f.patterns) - 1; i >= 0; i-- {
+		if ok, _ := path.Match(f.patterns[i].pattern, scope); ok {
+			return f.patterns[i].level
+		}
+	}
+
+	return f.DefaultLogLevel
 }
@@ -0,0 +1,320 @@
+package logger
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pion/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoggerScopePatterns(t *testing.T) {
+	f := NewLoggerFactory("all:ERROR,stunner-cluster-*:TRACE,stunner-cluster-b:WARN,turn:INFO")
+	buf := &bytes.Buffer{}
+	f.SetWriter(buf)
+
+	assert.Equal(t, logging.LogLevelTrace, f.levelFor("stunner-cluster-a"), "pattern")
+	assert.Equal(t, logging.LogLevelWarn, f.levelFor("stunner-cluster-b"), "exact match first")
+	assert.Equal(t, logging.LogLevelInfo, f.levelFor("turn"), "scope")
+	assert.Equal(t, logging.LogLevelError, f.levelFor("stunner-listener-a"), "default")
+
+	a := f.NewLogger("stunner-cluster-a")
+	l := f.NewLogger("stunner-listener-a")
+	a.Trace("cluster trace")
+	l.Info("listener info")
+	assert.Contains(t, buf.String(), "cluster trace", "cluster traced")
+	assert.NotContains(t, buf.String(), "listener info", "listener at default level")
+
+	// later patterns take precedence, existing loggers are updated and unlisted scopes reset
+	buf.Reset()
+	f.SetLevel("stunner-*:DEBUG,stunner-listener-*:INFO,stunner-[:TRACE")
+	assert.Equal(t, logging.LogLevelDebug, f.levelFor("stunner-cluster-a"), "pattern")
+	assert.Equal(t, logging.LogLevelInfo, f.levelFor("stunner-listener-a"), "last pattern wins")
+	assert.Equal(t, logging.LogLevelError, f.levelFor("turn"), "scope level removed")
+	assert.Equal(t, logging.LogLevelError, f.DefaultLogLevel, "default level kept")
+
+	a.Trace("cluster trace")
+	l.Info("listener info")
+	assert.NotContains(t, buf.String(), "cluster trace", "cluster not traced")
+	assert.Contains(t, buf.String(), "listener info", "listener logs info")
+}
+
+func TestLoggerJSONFormat(t *testing.T) {
+	f := NewLoggerFactory("all:INFO")
+	buf := &bytes.Buffer{}
+	f.SetWriter(buf)
+
+	l := f.NewLogger("stunner-test")
+	l.Info("console line")
+	assert.Contains(t, buf.String(), "stunner-test INFO: console line", "console format")
+
+	// existing loggers are switched to JSON
+	buf.Reset()
+	f.SetFormat("json")
+	l.Warnf("json line: %d", 1)
+	f.NewLogger("turn").Error("new logger")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Len(t, lines, 2, "one line per log")
+
+	e := jsonEntry{}
+	assert.NoError(t, json.Unmarshal([]byte(lines[0]), &e), "valid JSON")
+	assert.Equal(t, "warning", e.Level, "level")
+	assert.Equal(t, "stunner-test", e.Logger, "logger")
+	assert.Equal(t, "json line: 1", e.Msg, "message")
+	assert.True(t, strings.HasPrefix(e.Caller, "logger_test.go:"), "caller")
+	assert.NotEmpty(t, e.Time, "time")
+
+	e = jsonEntry{}
+	assert.NoError(t, json.Unmarshal([]byte(lines[1]), &e), "valid JSON")
+	assert.Equal(t, "error", e.Level, "level")
+	assert.Equal(t, "turn", e.Logger, "logger")
+	assert.Equal(t, "new logger", e.Msg, "message")
+
+	// unknown formats fall back to console
+	buf.Reset()
+	f.SetFormat("dummy")
+	l.Info("console line")
+	assert.Contains(t, buf.String(), "stunner-test INFO: console line", "console format")
+}
+
+func TestLoggerFileRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "stunnerd.log")
+	w, err := NewFileWriter(path, 64, 0, 2)
+	assert.NoError(t, err, "open")
+	defer w.Close()
+
+	f := NewLoggerFactory("all:INFO")
+	f.SetWriter(w)
+	l := f.NewLogger("stunner-test")
+
+	// each line is longer than half of the max size: every line after the first rotates
+	for i := 0; i < 4; i++ {
+		l.Infof("line %d", i)
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	content, err := os.ReadFile(path)
+	assert.NoError(t, err, "read")
+	assert.Contains(t, string(content), "line 3", "last line")
+	assert.NotContains(t, string(content), "line 2", "previous line rotated")
+
+	backups, err := filepath.Glob(path + ".*")
+	assert.NoError(t, err, "glob")
+	assert.Len(t, backups, 2, "only max backups kept")
+
+	// age-based rotation
+	w.SetLimits(0, time.Millisecond, 0)
+	time.Sleep(2 * time.Millisecond)
+	l.Info("aged line")
+	backups, err = filepath.Glob(path + ".*")
+	assert.NoError(t, err, "glob")
+	assert.Len(t, backups, 3, "rotated by age")
+
+	assert.NoError(t, w.Close(), "close")
+	_, err = w.Write([]byte("closed"))
+	assert.ErrorIs(t, err, os.ErrClosed, "write after close")
+}
+
+func TestLoggerCheckLevel(t *testing.T) {
+	for _, spec := range []string{"all:INFO", "all:error,turn:DEBUG", " all : WARN , stunner-*:TRACE"} {
+		assert.NoError(t, CheckLevel(spec), spec)
+	}
+	for _, spec := range []string{"", "all", "all:LOUD", ":INFO", "all:INFO,", "stunner-[:INFO"} {
+		assert.Error(t, CheckLevel(spec), spec)
+	}
+}
+
+// syncBuffer is a buffer safe for the concurrent writes of the throttle timers
+type syncBuffer struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.String()
+}
+
+func TestLoggerThrottle(t *testing.T) {
+	f := NewLoggerFactory("all:DEBUG")
+	buf := &syncBuffer{}
+	f.SetWriter(buf)
+	f.SetThrottle(50*time.Millisecond, 3)
+
+	l := f.NewLogger("stunner-test")
+	for i := 0; i < 5; i++ {
+		l.Warnf("invalid endpoint %q", "dummy")
+		l.Debug("debug line")
+	}
+	for i := 0; i < 5; i++ {
+		l.Infof("distinct line %d", i)
+	}
+	out := buf.String()
+	assert.Equal(t, 1, strings.Count(out, "invalid endpoint"), "repeats deduplicated")
+	assert.Equal(t, 5, strings.Count(out, "debug line"), "debug not throttled")
+	// the burst is per level
+	assert.Contains(t, out, "distinct line 2", "within burst")
+	assert.NotContains(t, out, "distinct line 3", "beyond burst")
+
+	// summaries are written at the end of the interval
+	time.Sleep(150 * time.Millisecond)
+	out = buf.String()
+	assert.Contains(t, out, `stunner-test WARNING: message repeated 4 times: invalid endpoint "dummy"`,
+		"repeat summary")
+	assert.Contains(t, out, "stunner-test INFO: 2 messages suppressed", "suppressed summary")
+
+	// a new interval starts
+	l.Warnf("invalid endpoint %q", "dummy")
+	assert.Equal(t, 3, strings.Count(buf.String(), "invalid endpoint"), "logged again")
+
+	// throttling can be disabled
+	f.SetThrottle(0, 0)
+	for i := 0; i < 3; i++ {
+		l.Warn("unthrottled")
+	}
+	assert.Equal(t, 3, strings.Count(buf.String(), "unthrottled"), "throttling disabled")
+}
+
+func TestLoggerThrottleJSON(t *testing.T) {
+	f := NewLoggerFactory("all:INFO")
+	buf := &syncBuffer{}
+	f.SetWriter(buf)
+	f.SetFormat("json")
+	f.SetThrottle(50*time.Millisecond, 0)
+
+	l := f.NewLogger("stunner-test")
+	for i := 0; i < 3; i++ {
+		l.Error("could not resolve domain")
+	}
+	time.Sleep(150 * time.Millisecond)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Len(t, lines, 2, "line and summary")
+	if len(lines) != 2 {
+		return
+	}
+	e := jsonEntry{}
+	assert.NoError(t, json.Unmarshal([]byte(lines[1]), &e), "valid JSON")
+	assert.Equal(t, "error", e.Level, "level")
+	assert.Equal(t, "message repeated 2 times: could not resolve domain", e.Msg, "message")
+	assert.True(t, strings.HasPrefix(e.Caller, "logger_test.go:"), "caller")
+}
+
+func TestLoggerSyslogUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err, "listen")
+	defer conn.Close()
+
+	w, err := NewSyslogWriter("udp://"+conn.LocalAddr().String(), "local0")
+	assert.NoError(t, err, "syslog writer")
+	defer w.Close()
+
+	f := NewLoggerFactory("all:INFO")
+	buf := &bytes.Buffer{}
+	f.SetWriter(buf)
+	f.SetSyslog(w)
+
+	l := f.NewLogger("stunner-test")
+	l.Warnf("syslog line: %d", 1)
+	assert.Contains(t, buf.String(), "stunner-test WARNING: syslog line: 1", "local copy")
+
+	msg := make([]byte, 1024)
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := conn.ReadFrom(msg)
+	assert.NoError(t, err, "read")
+
+	// <PRI>VERSION TIMESTAMP HOSTNAME APP-NAME PROCID MSGID STRUCTURED-DATA MSG
+	fields := strings.SplitN(string(msg[:n]), " ", 8)
+	assert.Len(t, fields, 8, "fields")
+	if len(fields) != 8 {
+		return
+	}
+	assert.Equal(t, "<132>1", fields[0], "local0.warning")
+	_, err = time.Parse(time.RFC3339Nano, fields[1])
+	assert.NoError(t, err, "timestamp")
+	assert.Equal(t, strconv.Itoa(os.Getpid()), fields[4], "procid")
+	assert.Equal(t, "stunner-test", fields[5], "msgid")
+	assert.Equal(t, "-", fields[6], "no structured data")
+	assert.True(t, strings.HasPrefix(fields[7], "logger_test.go:"), "caller")
+	assert.True(t, strings.HasSuffix(fields[7], ": syslog line: 1"), "message")
+
+	// debug is not enabled: nothing is sent
+	l.Debug("debug line")
+	f.SetSyslog(nil)
+	l.Error("local only")
+	_ = conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	_, _, err = conn.ReadFrom(msg)
+	assert.Error(t, err, "no more messages")
+}
+
+func TestLoggerSyslogTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err, "listen")
+	defer ln.Close()
+
+	w, err := NewSyslogWriter("tcp://"+ln.Addr().String(), "daemon")
+	assert.NoError(t, err, "syslog writer")
+	defer w.Close()
+
+	f := NewLoggerFactory("all:INFO")
+	f.SetWriter(&bytes.Buffer{})
+	f.SetFormat("json")
+	f.SetSyslog(w)
+
+	l := f.NewLogger("stunner-test")
+	l.Error("first")
+	l.Info("second")
+
+	conn, err := ln.Accept()
+	assert.NoError(t, err, "accept")
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	r := bufio.NewReader(conn)
+
+	// octet-counting framing: "<length> <message>"
+	for _, expected := range []struct {
+		pri, msg string
+	}{{"<27>1", ": first"}, {"<30>1", ": second"}} {
+		size, err := r.ReadString(' ')
+		assert.NoError(t, err, "frame length")
+		n, err := strconv.Atoi(strings.TrimSpace(size))
+		assert.NoError(t, err, "frame length")
+		frame := make([]byte, n)
+		_, err = io.ReadFull(r, frame)
+		assert.NoError(t, err, "frame")
+		assert.True(t, strings.HasPrefix(string(frame), expected.pri+" "), "priority")
+		assert.True(t, strings.HasSuffix(string(frame), expected.msg), "message")
+		assert.NotContains(t, string(frame), "{", "not JSON")
+	}
+}
+
+func TestLoggerSyslogEndpoint(t *testing.T) {
+	for _, ep := range []string{"udp://127.0.0.1:514", "tcp://syslog:601", "unix:///dev/log"} {
+		_, err := NewSyslogWriter(ep, "daemon")
+		assert.NoError(t, err, ep)
+	}
+	for _, ep := range []string{"", "udp://127.0.0.1", "http://syslog:514", "unix://"} {
+		_, err := NewSyslogWriter(ep, "daemon")
+		assert.Error(t, err, ep)
+	}
+	_, err := NewSyslogWriter("udp://127.0.0.1:514", "dummy")
+	assert.Error(t, err, "facility")
+}
@@ -0,0 +1,224 @@
+package logger
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// syslogTimeFormat is the RFC 5424 timestamp with microsecond precision
+	syslogTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+	// syslogTimeout bounds dialing and writing to the syslog endpoint
+	syslogTimeout = time.Second
+	// syslogRedialInterval is the minimum time between two attempts to connect to the endpoint
+	syslogRedialInterval = 5 * time.Second
+	// syslogMaxMsgID is the maximum length of the MSGID field
+	syslogMaxMsgID = 32
+)
+
+// Syslog severities (RFC 5424)
+const (
+	SeverityError   = 3
+	SeverityWarning = 4
+	SeverityInfo    = 6
+	SeverityDebug   = 7
+)
+
+// syslogFacilities maps the names of the syslog facilities to facility codes (RFC 5424)
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11, "local0": 16, "local1": 17, "local2": 18,
+	"local3": 19, "local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// SyslogWriter sends log messages to a syslog endpoint in the RFC 5424 format. Messages are sent
+// in a datagram each over UDP and datagram Unix sockets, and with octet-counting framing (RFC
+// 6587) over TCP and stream Unix sockets. The endpoint is connected lazily and reconnected after
+// errors, and messages that cannot be sent are dropped, so that a syslog outage does not hold up
+// logging for long
+type SyslogWriter struct {
+	endpoint, network, addr string
+	facility                int
+	facilityName            string
+	hostname, appName       string
+	pid                     string
+	lock                    sync.Mutex
+	conn                    net.Conn
+	lastDial                time.Time
+}
+
+// NewSyslogWriter creates a writer for a syslog endpoint, given as "udp://<host>:<port>",
+// "tcp://<host>:<port>" or "unix://<path>", and a facility name, e.g., "daemon" or "local0"
+func NewSyslogWriter(endpoint, facility string) (*SyslogWriter, error) {
+	network, addr, err := parseSyslogEndpoint(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	code, ok := syslogFacilities[strings.ToLower(facility)]
+	if !ok {
+		return nil, fmt.Errorf("unknown syslog facility: %q", facility)
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		hostname = "-"
+	}
+
+	return &SyslogWriter{
+		endpoint:     endpoint,
+		network:      network,
+		addr:         addr,
+		facility:     code,
+		facilityName: strings.ToLower(facility),
+		hostname:     hostname,
+		appName:      filepath.Base(os.Args[0]),
+		pid:          strconv.Itoa(os.Getpid()),
+	}, nil
+}
+
+func parseSyslogEndpoint(endpoint string) (string, string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid syslog endpoint %q: %s", endpoint, err.Error())
+	}
+	switch u.Scheme {
+	case "udp", "tcp":
+		if u.Hostname() == "" || u.Port() == "" {
+			return "", "", fmt.Errorf("invalid syslog endpoint %q: expected %s://<host>:<port>",
+				endpoint, u.Scheme)
+		}
+		return u.Scheme, u.Host, nil
+	case "unix":
+		if u.Path == "" {
+			return "", "", fmt.Errorf("invalid syslog endpoint %q: expected unix://<path>",
+				endpoint)
+		}
+		return u.Scheme, u.Path, nil
+	}
+	return "", "", fmt.Errorf("invalid syslog endpoint %q: unknown scheme %q", endpoint, u.Scheme)
+}
+
+// Endpoint returns the syslog endpoint
+func (s *SyslogWriter) Endpoint() string {
+	return s.endpoint
+}
+
+// Facility returns the name of the facility
+func (s *SyslogWriter) Facility() string {
+	return s.facilityName
+}
+
+// WriteMessage sends a message with the given severity, the scope of the logger is sent as the
+// MSGID
+func (s *SyslogWriter) WriteMessage(severity int, scope, msg string) error {
+	if len(scope) > syslogMaxMsgID {
+		scope = scope[:syslogMaxMsgID]
+	}
+	if scope == "" {
+		scope = "-"
+	}
+	line := fmt.Sprintf("<%d>1 %s %s %s %s %s - %s", s.facility*8+severity,
+		time.Now().Format(syslogTimeFormat), s.hostname, s.appName, s.pid, scope,
+		strings.TrimSuffix(msg, "\n"))
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if err := s.connect(); err != nil {
+		return err
+	}
+
+	frame := []byte(line)
+	if s.stream() {
+		frame = []byte(fmt.Sprintf("%d %s", len(line), line))
+	}
+
+	_ = s.conn.SetWriteDeadline(time.Now().Add(syslogTimeout))
+	if _, err := s.conn.Write(frame); err != nil {
+		s.conn.Close()
+		s.conn = nil
+		return err
+	}
+	return nil
+}
+
+// connect connects to the endpoint unless connected, must be called with the lock held
+func (s *SyslogWriter) connect() error {
+	if s.conn != nil {
+		return nil
+	}
+	if time.Since(s.lastDial) < syslogRedialInterval {
+		return fmt.Errorf("syslog endpoint %q unavailable", s.endpoint)
+	}
+	s.lastDial = time.Now()
+
+	network := s.network
+	if network == "unix" {
+		// most local syslog daemons listen on a datagram socket
+		conn, err := net.DialTimeout("unixgram", s.addr, syslogTimeout)
+		if err == nil {
+			s.conn, s.network = conn, "unixgram"
+			return nil
+		}
+	}
+	conn, err := net.DialTimeout(network, s.addr, syslogTimeout)
+	if err != nil {
+		return err
+	}
+	s.conn = conn
+	return nil
+}
+
+// stream returns true if the messages are sent over a stream socket
+func (s *SyslogWriter) stream() bool {
+	return s.network == "tcp" || s.network == "unix"
+}
+
+// Close closes the connection to the endpoint
+func (s *SyslogWriter) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// syslogTee writes the lines of a logger to a writer and sends them to syslog as well, stripping
+// the timestamp and the prefix of console lines
+type syslogTee struct {
+	w         *SyslogWriter
+	next      io.Writer
+	scope     string
+	severity  int
+	timestamp bool
+	prefix    string
+}
+
+func (t *syslogTee) Write(p []byte) (int, error) {
+	msg := string(p)
+	if t.timestamp {
+		if i := strings.IndexByte(msg, ' '); i >= 0 {
+			msg = msg[i+1:]
+		}
+	}
+	if t.prefix != "" {
+		// "<file>:<line>: <scope> <LEVEL>: <message>"
+		msg = strings.Replace(msg, t.prefix, "", 1)
+	}
+	// errors are dropped: logs must not fail because syslog is unavailable
+	_ = t.w.WriteMessage(t.severity, t.scope, msg)
+
+	return t.next.Write(p)
+}
@@ -0,0 +1,133 @@
+package logger
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// consoleTimeFormat is the timestamp the log package writes with the log.Lmicroseconds flag
+const consoleTimeFormat = "15:04:05.000000"
+
+// throttleWriter deduplicates and rate-limits the lines of a logger. Within an interval, only the
+// first occurrence of a message is written and at most burst distinct messages are written, the
+// rest are counted and summarized at the end of the interval in "message repeated N times" and
+// "N messages suppressed" lines
+type throttleWriter struct {
+	w         io.Writer
+	interval  time.Duration
+	burst     int
+	timestamp bool   // lines start with a timestamp, which is ignored when comparing messages
+	marker    string // the message follows the first marker in a line
+
+	lock        sync.Mutex
+	start       time.Time
+	seen        map[string]int // line without the timestamp -> number of repeats
+	order       []string
+	suppressed  int
+	suppressKey string // the first line suppressed by the rate limit
+	timer       *time.Timer
+}
+
+func newThrottleWriter(w io.Writer, interval time.Duration, burst int, timestamp bool, marker string) *throttleWriter {
+	return &throttleWriter{
+		w:         w,
+		interval:  interval,
+		burst:     burst,
+		timestamp: timestamp,
+		marker:    marker,
+		seen:      map[string]int{},
+	}
+}
+
+func (t *throttleWriter) Write(p []byte) (int, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	now := time.Now()
+	if now.Sub(t.start) >= t.interval {
+		t.flush()
+		t.start = now
+	}
+
+	key := string(p)
+	if t.timestamp {
+		if i := strings.IndexByte(key, ' '); i >= 0 {
+			key = key[i+1:]
+		}
+	}
+
+	if n, found := t.seen[key]; found {
+		t.seen[key] = n + 1
+		t.schedule(now)
+		return len(p), nil
+	}
+	if t.burst > 0 && len(t.seen) >= t.burst {
+		if t.suppressed == 0 {
+			t.suppressKey = key
+		}
+		t.suppressed++
+		t.schedule(now)
+		return len(p), nil
+	}
+
+	t.seen[key] = 0
+	t.order = append(t.order, key)
+	return t.w.Write(p)
+}
+
+// schedule makes sure the summaries are written at the end of the interval, must be called with
+// the lock held
+func (t *throttleWriter) schedule(now time.Time) {
+	if t.timer != nil {
+		return
+	}
+	t.timer = time.AfterFunc(t.start.Add(t.interval).Sub(now), func() {
+		t.lock.Lock()
+		defer t.lock.Unlock()
+		t.timer = nil
+		t.flush()
+		t.start = time.Now()
+	})
+}
+
+// flush writes the summaries of the current interval and starts a new one, must be called with the
+// lock held
+func (t *throttleWriter) flush() {
+	for _, key := range t.order {
+		if n := t.seen[key]; n > 0 {
+			t.summarize(key, fmt.Sprintf("message repeated %d times: ", n), true)
+		}
+	}
+	if t.suppressed > 0 {
+		t.summarize(t.suppressKey, fmt.Sprintf("%d messages suppressed", t.suppressed), false)
+	}
+
+	t.seen = map[string]int{}
+	t.order = t.order[:0]
+	t.suppressed, t.suppressKey = 0, ""
+	if t.timer != nil {
+		t.timer.Stop()
+		t.timer = nil
+	}
+}
+
+// summarize writes a summary line, taking the caller and the prefix from a line, and keeping the
+// message of the line if requested
+func (t *throttleWriter) summarize(key, summary string, keepMsg bool) {
+	head, msg := "", strings.TrimSuffix(key, "\n")
+	if i := strings.Index(msg, t.marker); i >= 0 {
+		head, msg = msg[:i+len(t.marker)], msg[i+len(t.marker):]
+	}
+	if !keepMsg {
+		msg = ""
+	}
+
+	line := head + summary + msg + "\n"
+	if t.timestamp {
+		line = time.Now().Format(consoleTimeFormat) + " " + line
+	}
+	_, _ = t.w.Write([]byte(line))
+}
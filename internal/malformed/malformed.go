@@ -0,0 +1,318 @@
+// Package malformed discards the malformed traffic received on the listeners before any STUN/TURN
+// processing: the messages over the maximum message size and, optionally, the packets that are
+// neither STUN nor TURN ChannelData messages, e.g., port scans, probes of other protocols and
+// fuzzing attempts. Malformed packets are counted per listener and reason, and per client source,
+// so that the sources sending them are visible even if the packets are not dropped.
+package malformed
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/logging"
+
+	"github.com/l7mp/stunner/internal/monitoring"
+	"github.com/l7mp/stunner/internal/stunmsg"
+	"github.com/l7mp/stunner/internal/util"
+	"github.com/l7mp/stunner/pkg/clock"
+)
+
+// Reasons a packet is malformed for
+const (
+	// Oversize is a STUN or ChannelData message larger than the maximum message size
+	Oversize = "oversize"
+	// NotSTUN is a packet that is neither a STUN nor a TURN ChannelData message
+	NotSTUN = "not-stun"
+	// BadLength is a STUN or ChannelData message whose length does not match the datagram
+	BadLength = "bad-length"
+)
+
+const (
+	// maxSources bounds the number of sources whose malformed packets are counted
+	maxSources = 1 << 12
+	// sourceIdleTimeout is the time after which a source sending no malformed packets is forgotten
+	// if the table is full
+	sourceIdleTimeout = 10 * time.Minute
+)
+
+// Config sets the checks
+type Config struct {
+	// MaxMessageSize is the size of the largest message accepted, zero means no limit
+	MaxMessageSize int
+	// DropGarbage drops the packets that are neither STUN nor ChannelData messages
+	DropGarbage bool
+}
+
+// Source is the count of the malformed packets received from a client source
+type Source struct {
+	// Source is the IP address of the client source
+	Source string `json:"source"`
+	// Packets is the number of malformed packets received from the source
+	Packets uint64 `json:"packets"`
+	// Reason is the reason the last malformed packet of the source was malformed for
+	Reason string `json:"reason"`
+	// Listener is the listener the last malformed packet of the source was received on
+	Listener string `json:"listener"`
+	// LastSeen is the time the last malformed packet of the source was received
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// ReportFunc is called with the malformed packets dropped, e.g., to ban the sources sending them
+type ReportFunc func(listener string, client net.Addr)
+
+// Filter checks the packets received on the listeners
+type Filter struct {
+	lock    sync.Mutex
+	conf    *Config
+	enabled int32 // atomic, so that the sockets skip the checks if not configured
+	sources map[string]*Source
+	report  ReportFunc
+	log     logging.LeveledLogger
+	clock   clock.Clock
+}
+
+// NewFilter creates a filter that calls the report callback with the malformed packets dropped,
+// disabled until a config is set
+func NewFilter(report ReportFunc, logger logging.LoggerFactory) *Filter {
+	return &Filter{
+		sources: map[string]*Source{},
+		report:  report,
+		clock:   clock.Real,
+		log:     logger.NewLogger("malformed"),
+	}
+}
+
+// SetClock sets the clock the last malformed packet of the sources is timestamped with. Must be
+// called before the filter is used
+func (f *Filter) SetClock(c clock.Clock) {
+	f.clock = c
+}
+
+// SetConfig sets the checks, nil disables the filter and forgets the sources
+func (f *Filter) SetConfig(conf *Config) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	f.conf = conf
+	enabled := int32(0)
+	if conf != nil {
+		enabled = 1
+	} else {
+		f.sources = map[string]*Source{}
+		monitoring.MalformedSourcesGauge.Set(0)
+	}
+	atomic.StoreInt32(&f.enabled, enabled)
+}
+
+// config returns the current config, or nil if the filter is disabled
+func (f *Filter) config() *Config {
+	if atomic.LoadInt32(&f.enabled) == 0 {
+		return nil
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.conf
+}
+
+// Sources returns the client sources that sent malformed packets, the most prolific first
+func (f *Filter) Sources() []Source {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	ret := make([]Source, 0, len(f.sources))
+	for _, s := range f.sources {
+		ret = append(ret, *s)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Packets != ret[j].Packets {
+			return ret[i].Packets > ret[j].Packets
+		}
+		return ret[i].Source < ret[j].Source
+	})
+	return ret
+}
+
+// Check checks a datagram, with the header h, received from a client and returns the reason the
+// datagram is malformed for, or an empty string if the datagram is well-formed, and whether the
+// datagram is to be dropped
+func Check(b []byte, h stunmsg.Header, conf *Config) (string, bool) {
+	if conf.MaxMessageSize > 0 && len(b) > conf.MaxMessageSize {
+		return Oversize, true
+	}
+	switch {
+	case h.Kind == stunmsg.Garbage:
+		return NotSTUN, conf.DropGarbage
+	case !h.WellFormed(len(b)):
+		return BadLength, conf.DropGarbage
+	}
+	return "", false
+}
+
+// checkStream checks the beginning of the byte stream read from a client: only the messages
+// starting at the beginning of the stream, or read whole by a single Read, can be told apart from
+// the rest of the stream
+func checkStream(b []byte, first bool, conf *Config) (string, bool) {
+	if len(b) < stunmsg.ChannelDataHeaderSize {
+		return "", false
+	}
+	h := stunmsg.Parse(b)
+	switch {
+	case h.Kind == stunmsg.STUN || (h.Kind == stunmsg.ChannelData && first):
+		if conf.MaxMessageSize > 0 && h.Size() > conf.MaxMessageSize {
+			return Oversize, true
+		}
+		return "", false
+	case !first:
+		return "", false
+	case b[0]>>6 == 0 && len(b) >= 8 && binary.BigEndian.Uint32(b[4:8]) == stunmsg.MagicCookie:
+		// the beginning of a STUN header
+		return "", false
+	}
+	return NotSTUN, conf.DropGarbage
+}
+
+// count records a malformed packet of a client source
+func (f *Filter) count(listener, reason string, client net.Addr) {
+	monitoring.MalformedPacketCounter.WithLabelValues(listener, reason).Inc()
+
+	ip := util.AddrIP(client)
+	if ip == nil {
+		return
+	}
+	source := ip.String()
+	now := f.clock.Now()
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	s, ok := f.sources[source]
+	if !ok {
+		if len(f.sources) >= maxSources {
+			f.prune(now)
+		}
+		if len(f.sources) >= maxSources {
+			f.log.Debugf("too many sources, not counting the malformed packets of %s", source)
+			return
+		}
+		s = &Source{Source: source}
+		f.sources[source] = s
+		monitoring.MalformedSourcesGauge.Set(float64(len(f.sources)))
+	}
+	s.Packets++
+	s.Reason = reason
+	s.Listener = listener
+	s.LastSeen = now
+}
+
+// prune forgets the idle sources, must be called with the lock held
+func (f *Filter) prune(now time.Time) {
+	for source, s := range f.sources {
+		if now.Sub(s.LastSeen) > sourceIdleTimeout {
+			delete(f.sources, source)
+		}
+	}
+	monitoring.MalformedSourcesGauge.Set(float64(len(f.sources)))
+}
+
+// NewPacketConn wraps the socket of a packet listener so that the malformed packets are counted
+// and, if so configured, dropped before reaching the TURN server
+func (f *Filter) NewPacketConn(conn net.PacketConn, listener string) net.PacketConn {
+	return &packetConn{PacketConn: conn, filter: f, listener: listener}
+}
+
+type packetConn struct {
+	net.PacketConn
+	filter   *Filter
+	listener string
+}
+
+func (c *packetConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, _, err := c.ReadMessage(b)
+	return n, addr, err
+}
+
+func (c *packetConn) ReadMessage(b []byte) (int, net.Addr, stunmsg.Header, error) {
+	for {
+		n, addr, h, err := stunmsg.ReadMessage(c.PacketConn, b)
+		if err != nil {
+			return n, addr, h, err
+		}
+		conf := c.filter.config()
+		if conf == nil {
+			return n, addr, h, err
+		}
+		reason, drop := Check(b[:n], h, conf)
+		if reason == "" {
+			return n, addr, h, err
+		}
+		c.filter.count(c.listener, reason, addr)
+		if !drop {
+			return n, addr, h, err
+		}
+		c.filter.log.Tracef("dropping malformed packet from %s on listener %s: %s", addr,
+			c.listener, reason)
+		if c.filter.report != nil {
+			c.filter.report(c.listener, addr)
+		}
+	}
+}
+
+// NewListener wraps the socket of a stream listener so that the connections starting with a
+// malformed message or sending oversized messages are counted and, if so configured, closed
+func (f *Filter) NewListener(ln net.Listener, listener string) net.Listener {
+	return &streamListener{Listener: ln, filter: f, listener: listener}
+}
+
+type streamListener struct {
+	net.Listener
+	filter   *Filter
+	listener string
+}
+
+func (l *streamListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return conn, err
+	}
+	return &streamConn{Conn: conn, filter: l.filter, listener: l.listener}, nil
+}
+
+type streamConn struct {
+	net.Conn
+	filter   *Filter
+	listener string
+	started  bool
+}
+
+func (c *streamConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if err != nil {
+		return n, err
+	}
+	first := !c.started
+	c.started = true
+	conf := c.filter.config()
+	if conf == nil {
+		return n, err
+	}
+	reason, drop := checkStream(b[:n], first, conf)
+	if reason == "" {
+		return n, err
+	}
+	c.filter.count(c.listener, reason, c.Conn.RemoteAddr())
+	if !drop {
+		return n, err
+	}
+	c.filter.log.Debugf("closing connection from %s on listener %s: %s", c.Conn.RemoteAddr(),
+		c.listener, reason)
+	if c.filter.report != nil {
+		c.filter.report(c.listener, c.Conn.RemoteAddr())
+	}
+	c.Conn.Close()
+	return 0, io.EOF
+}
@@ -0,0 +1,150 @@
+package malformed
+
+import (
+	"io"
+	"net"
+	"testing"
+
+	"github.com/pion/logging"
+	"github.com/pion/stun"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/l7mp/stunner/internal/monitoring"
+	"github.com/l7mp/stunner/internal/stunmsg"
+)
+
+var (
+	testClient  = &net.UDPAddr{IP: net.ParseIP("1.2.3.4"), Port: 1234}
+	channelData = []byte{0x40, 0x00, 0x00, 0x04, 1, 2, 3, 4}
+	// the start of a TLS ClientHello
+	clientHello = []byte("\x16\x03\x01\x02\x00\x01\x00\x01\xfc\x03\x03")
+)
+
+func binding(t *testing.T) []byte {
+	m, err := stun.Build(stun.TransactionID, stun.BindingRequest, stun.NewSoftware("test"))
+	assert.NoError(t, err, "build")
+	return m.Raw
+}
+
+func TestCheck(t *testing.T) {
+	conf := &Config{MaxMessageSize: 64, DropGarbage: true}
+
+	for _, c := range []struct {
+		name   string
+		b      []byte
+		reason string
+	}{
+		{"binding", binding(t), ""},
+		{"channel data", channelData, ""},
+		{"channel data with padding", append(append([]byte{}, channelData...), 0, 0), ""},
+		{"oversize", make([]byte, 65), Oversize},
+		{"short", []byte{0, 1}, NotSTUN},
+		{"tls", clientHello, NotSTUN},
+		// "GE" is a valid channel number
+		{"http", []byte("GET / HTTP/1.1\r\n\r\n"), BadLength},
+		{"no magic cookie", append([]byte{0, 1, 0, 0, 1, 2, 3, 4}, make([]byte, 12)...), NotSTUN},
+		{"truncated stun", binding(t)[:24], BadLength},
+		{"truncated channel data", channelData[:6], BadLength},
+	} {
+		reason, drop := Check(c.b, stunmsg.Parse(c.b), conf)
+		assert.Equal(t, c.reason, reason, c.name)
+		assert.Equal(t, c.reason != "", drop, c.name)
+	}
+
+	// garbage is only counted unless dropped
+	reason, drop := Check(clientHello, stunmsg.Parse(clientHello), &Config{})
+	assert.Equal(t, NotSTUN, reason, "not dropped")
+	assert.False(t, drop, "not dropped")
+	oversize := make([]byte, 1500)
+	reason, drop = Check(oversize, stunmsg.Parse(oversize), &Config{MaxMessageSize: 1400})
+	assert.Equal(t, Oversize, reason, "oversize")
+	assert.True(t, drop, "oversize always dropped")
+}
+
+type testPacketConn struct {
+	net.PacketConn
+	packets [][]byte
+}
+
+func (c *testPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	if len(c.packets) == 0 {
+		return 0, nil, io.EOF
+	}
+	p := c.packets[0]
+	c.packets = c.packets[1:]
+	return copy(b, p), testClient, nil
+}
+
+func TestFilterPacketConn(t *testing.T) {
+	reported := 0
+	f := NewFilter(func(listener string, client net.Addr) {
+		assert.Equal(t, "udp", listener, "listener")
+		assert.Equal(t, testClient, client, "client")
+		reported++
+	}, logging.NewDefaultLoggerFactory())
+	counter := func(reason string) float64 {
+		return testutil.ToFloat64(monitoring.MalformedPacketCounter.WithLabelValues("udp", reason))
+	}
+
+	conn := f.NewPacketConn(&testPacketConn{packets: [][]byte{clientHello, channelData}}, "udp")
+	buf := make([]byte, 1500)
+
+	// disabled
+	n, _, err := conn.ReadFrom(buf)
+	assert.NoError(t, err, "read")
+	assert.Equal(t, clientHello, buf[:n], "disabled")
+	assert.Len(t, f.Sources(), 0, "no sources")
+
+	before := counter(NotSTUN)
+	f.SetConfig(&Config{DropGarbage: true})
+	conn = f.NewPacketConn(&testPacketConn{packets: [][]byte{clientHello, clientHello, channelData}}, "udp")
+	n, _, err = conn.ReadFrom(buf)
+	assert.NoError(t, err, "read")
+	assert.Equal(t, channelData, buf[:n], "garbage dropped")
+	assert.Equal(t, before+2, counter(NotSTUN), "counter")
+	assert.Equal(t, 2, reported, "reported")
+
+	sources := f.Sources()
+	assert.Len(t, sources, 1, "sources")
+	assert.Equal(t, "1.2.3.4", sources[0].Source, "source")
+	assert.Equal(t, uint64(2), sources[0].Packets, "packets")
+	assert.Equal(t, NotSTUN, sources[0].Reason, "reason")
+
+	// count only
+	f.SetConfig(&Config{})
+	conn = f.NewPacketConn(&testPacketConn{packets: [][]byte{clientHello}}, "udp")
+	n, _, err = conn.ReadFrom(buf)
+	assert.NoError(t, err, "read")
+	assert.Equal(t, clientHello, buf[:n], "counted only")
+	assert.Equal(t, uint64(3), f.Sources()[0].Packets, "packets")
+	assert.Equal(t, 2, reported, "not reported")
+
+	f.SetConfig(nil)
+	assert.Len(t, f.Sources(), 0, "sources forgotten")
+}
+
+func TestFilterStream(t *testing.T) {
+	conf := &Config{MaxMessageSize: 64, DropGarbage: true}
+
+	reason, _ := checkStream(clientHello, true, conf)
+	assert.Equal(t, NotSTUN, reason, "garbage at the start")
+	reason, _ = checkStream(clientHello, false, conf)
+	assert.Equal(t, "", reason, "possibly the middle of a message")
+	reason, _ = checkStream(binding(t), true, conf)
+	assert.Equal(t, "", reason, "binding")
+
+	b := binding(t)
+	b[2], b[3] = 0x01, 0x00
+	reason, _ = checkStream(b, false, conf)
+	assert.Equal(t, Oversize, reason, "oversize")
+
+	f := NewFilter(nil, logging.NewDefaultLoggerFactory())
+	f.SetConfig(conf)
+	client, server := net.Pipe()
+	defer client.Close()
+	conn := &streamConn{Conn: server, filter: f, listener: "tcp"}
+	go client.Write(clientHello) //nolint:errcheck
+	_, err := conn.Read(make([]byte, 1500))
+	assert.Equal(t, io.EOF, err, "connection closed")
+}
@@ -21,8 +21,16 @@ type Manager interface {
 	Delete(o object.Object) error
 	// PrepareReconciliation prepares the reconciliation of the manager
 	PrepareReconciliation(confs []v1alpha1.Config) (*ReconciliationState, error)
-	// FinishReconciliation finishes the reconciliation from the specified state
+	// FinishReconciliation stages and commits the reconciliation from the specified state
 	FinishReconciliation(state *ReconciliationState) error
+	// StageReconciliation creates the new objects without making them visible
+	StageReconciliation(state *ReconciliationState) error
+	// CommitReconciliation applies a staged reconciliation, on error the manager is unchanged
+	CommitReconciliation(state *ReconciliationState) error
+	// AbortReconciliation releases the objects of a staged but uncommitted reconciliation
+	AbortReconciliation(state *ReconciliationState)
+	// RevertReconciliation undoes a committed reconciliation
+	RevertReconciliation(state *ReconciliationState) error
 	// Keys returns the names iof all objects in the store in alphabetical order, suitable for iteration
 	Keys() []string
 }
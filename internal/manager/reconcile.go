@@ -17,6 +17,10 @@ type ReconcileJob struct {
 
 type ReconciliationState struct {
 	NewJobQueue, ChangedJobQueue, DeletedJobQueue []ReconcileJob
+	// staged holds the objects created for the new-object job queue but not yet committed
+	staged []object.Object
+	// committed is true if the reconciliation has been committed
+	committed bool
 }
 
 // PrepareReconciliation prepares the reconciliation of the objects handled by the manager and returns a
@@ -96,47 +100,154 @@ func (m *managerImpl) PrepareReconciliation(confs []v1alpha1.Config) (*Reconcili
 	return &state, restart
 }
 
-// FinishReconciliation finishes the reconciliation from the specified state
+// FinishReconciliation finishes the reconciliation from the specified state in a single step,
+// leaving the manager unchanged on error
 func (m *managerImpl) FinishReconciliation(state *ReconciliationState) error {
 	m.log.Tracef("finishing reconciliation")
 
-	m.log.Trace("running the new-object job queue")
+	if err := m.StageReconciliation(state); err != nil {
+		return err
+	}
+
+	if err := m.CommitReconciliation(state); err != nil {
+		m.AbortReconciliation(state)
+		return err
+	}
+
+	return nil
+}
+
+// StageReconciliation creates the new objects of a reconciliation without making them visible in
+// the manager. On error all staged objects are released
+func (m *managerImpl) StageReconciliation(state *ReconciliationState) error {
+	m.log.Trace("staging the new-object job queue")
+
+	state.staged = []object.Object{}
 	for _, j := range state.NewJobQueue {
 		o, err := m.factory.New(j.NewConfig)
 		if err != nil && err != v1alpha1.ErrRestartRequired {
 			m.log.Errorf("could not create new object: %s", err.Error())
+			m.AbortReconciliation(state)
 			return err
 		}
-		// ignore errors
-		_ = m.Upsert(o)
+		state.staged = append(state.staged, o)
 	}
 
-	m.log.Trace("running the deletion job queue")
-	for _, j := range state.DeletedJobQueue {
-		o := j.Object
-		m.log.Tracef("deleting object %q: running conf: %#v", o.ObjectName(), j.OldConfig)
-		// ignore error
-		_ = m.Delete(o)
-	}
+	return nil
+}
 
+// CommitReconciliation applies a staged reconciliation: changed objects are reconciled first,
+// since this is the only step that may fail, then the staged objects are added and the deleted
+// objects are removed. If an object cannot be reconciled, the objects already reconciled are
+// reverted to their old configuration and the manager is left unchanged
+func (m *managerImpl) CommitReconciliation(state *ReconciliationState) error {
 	m.log.Trace("running the reconciliation job queue")
-	for _, j := range state.ChangedJobQueue {
+	for i, j := range state.ChangedJobQueue {
 		o := j.Object
 		m.log.Tracef("reconciling object %q: %#v -> %#v", o.ObjectName(), j.OldConfig,
 			j.NewConfig)
 
 		err := o.Reconcile(j.NewConfig)
 		if err != nil && err != v1alpha1.ErrRestartRequired {
+			m.log.Errorf("could not reconcile object %q: %s", o.ObjectName(), err.Error())
+			// the failed object may have been partially updated, so revert it too
+			_ = m.revertChanged(state.ChangedJobQueue[:i+1])
 			return err
 		}
 	}
 
+	m.log.Trace("running the new-object job queue")
+	for _, o := range state.staged {
+		// ignore errors
+		_ = m.Upsert(o)
+	}
+
+	m.log.Trace("running the deletion job queue")
+	for _, j := range state.DeletedJobQueue {
+		o := j.Object
+		m.log.Tracef("deleting object %q: running conf: %#v", o.ObjectName(), j.OldConfig)
+		// ignore error
+		_ = m.Delete(o)
+	}
+
+	state.committed = true
+
 	m.log.Debugf("reconciliation ready: to-be-created: %d, changed: %d, deleted: %d",
 		len(state.NewJobQueue), len(state.ChangedJobQueue), len(state.DeletedJobQueue))
 
 	return nil
 }
 
+// AbortReconciliation releases the objects staged by a reconciliation that was not committed
+func (m *managerImpl) AbortReconciliation(state *ReconciliationState) {
+	if state.committed {
+		return
+	}
+
+	m.log.Trace("aborting reconciliation")
+	for _, o := range state.staged {
+		// ignore error
+		_ = o.Close()
+	}
+	state.staged = nil
+}
+
+// RevertReconciliation undoes a committed reconciliation: new objects are removed, deleted objects
+// are recreated and changed objects are reconciled to their old configuration. Returns the first
+// error encountered, but always tries to revert all objects
+func (m *managerImpl) RevertReconciliation(state *ReconciliationState) error {
+	if !state.committed {
+		m.AbortReconciliation(state)
+		return nil
+	}
+
+	m.log.Trace("reverting reconciliation")
+	var errFinal error
+
+	for _, o := range state.staged {
+		// ignore error
+		_ = m.Delete(o)
+	}
+	state.staged = nil
+
+	for _, j := range state.DeletedJobQueue {
+		o, err := m.factory.New(j.OldConfig)
+		if err != nil && err != v1alpha1.ErrRestartRequired {
+			m.log.Errorf("could not recreate object %q: %s", j.OldConfig.ConfigName(),
+				err.Error())
+			if errFinal == nil {
+				errFinal = err
+			}
+			continue
+		}
+		_ = m.Upsert(o)
+	}
+
+	if err := m.revertChanged(state.ChangedJobQueue); err != nil && errFinal == nil {
+		errFinal = err
+	}
+
+	state.committed = false
+
+	return errFinal
+}
+
+// revertChanged reconciles changed objects to their old configuration, in reverse order
+func (m *managerImpl) revertChanged(jobs []ReconcileJob) error {
+	var errFinal error
+	for i := len(jobs) - 1; i >= 0; i-- {
+		j := jobs[i]
+		err := j.Object.Reconcile(j.OldConfig)
+		if err != nil && err != v1alpha1.ErrRestartRequired {
+			m.log.Errorf("could not revert object %q: %s", j.Object.ObjectName(), err.Error())
+			if errFinal == nil {
+				errFinal = err
+			}
+		}
+	}
+	return errFinal
+}
+
 func findConfByName(confs []v1alpha1.Config, name string) bool {
 	for _, c := range confs {
 		if c.ConfigName() == name {
@@ -0,0 +1,209 @@
+package manager
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/pion/logging"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/l7mp/stunner/internal/object"
+	"github.com/l7mp/stunner/pkg/apis/v1alpha1"
+)
+
+var errTest = errors.New("test error")
+
+// testConfig configures a test object, objects with the "fail" value cannot be created or
+// reconciled
+type testConfig struct {
+	name, value string
+}
+
+func (c *testConfig) Validate() error    { return nil }
+func (c *testConfig) ConfigName() string { return c.name }
+func (c *testConfig) String() string     { return fmt.Sprintf("%s=%s", c.name, c.value) }
+func (c *testConfig) DeepEqual(other v1alpha1.Config) bool {
+	o, ok := other.(*testConfig)
+	return ok && *c == *o
+}
+
+// testObject records the operations on the objects to the log of the factory
+type testObject struct {
+	conf    testConfig
+	factory *testFactory
+}
+
+func (o *testObject) ObjectName() string                    { return o.conf.name }
+func (o *testObject) Inspect(old, new v1alpha1.Config) bool { return false }
+func (o *testObject) GetConfig() v1alpha1.Config            { c := o.conf; return &c }
+func (o *testObject) Status() object.ObjectStatus           { return object.ObjectStatus{} }
+
+func (o *testObject) Reconcile(conf v1alpha1.Config) error {
+	c := conf.(*testConfig)
+	o.factory.record("reconcile", c)
+	// the object is partially updated on failure
+	o.conf = *c
+	if c.value == "fail" {
+		return errTest
+	}
+	return nil
+}
+
+func (o *testObject) Close() error {
+	o.factory.record("close", &o.conf)
+	return nil
+}
+
+type testFactory struct {
+	log []string
+}
+
+func (f *testFactory) record(op string, c *testConfig) {
+	f.log = append(f.log, fmt.Sprintf("%s %s", op, c.String()))
+}
+
+func (f *testFactory) New(conf v1alpha1.Config) (object.Object, error) {
+	if conf == nil {
+		return &testObject{factory: f}, nil
+	}
+	c := conf.(*testConfig)
+	f.record("new", c)
+	if c.value == "fail" {
+		return nil, errTest
+	}
+	return &testObject{conf: *c, factory: f}, nil
+}
+
+func newTestManager(t *testing.T, confs ...*testConfig) (*managerImpl, *testFactory) {
+	f := &testFactory{}
+	m := NewManager("test", f, logging.NewDefaultLoggerFactory()).(*managerImpl)
+	state, err := m.PrepareReconciliation(testConfigs(confs...))
+	assert.NoError(t, err, "prepare")
+	assert.NoError(t, m.FinishReconciliation(state), "finish")
+	f.log = nil
+	return m, f
+}
+
+func testConfigs(confs ...*testConfig) []v1alpha1.Config {
+	ret := make([]v1alpha1.Config, len(confs))
+	for i, c := range confs {
+		ret[i] = c
+	}
+	return ret
+}
+
+// testState returns the name=value pairs of the objects of a manager
+func testState(m *managerImpl) []string {
+	ret := []string{}
+	for _, k := range m.Keys() {
+		o, _ := m.Get(k)
+		ret = append(ret, o.GetConfig().String())
+	}
+	return ret
+}
+
+func TestReconcileCommitOrder(t *testing.T) {
+	m, f := newTestManager(t, &testConfig{"a", "1"}, &testConfig{"b", "1"}, &testConfig{"c", "1"})
+
+	state, err := m.PrepareReconciliation(testConfigs(&testConfig{"a", "2"}, &testConfig{"c", "1"},
+		&testConfig{"d", "1"}))
+	assert.NoError(t, err, "prepare")
+	assert.Len(t, state.ChangedJobQueue, 1, "changed")
+	assert.Len(t, state.NewJobQueue, 1, "new")
+	assert.Len(t, state.DeletedJobQueue, 1, "deleted")
+
+	// staged objects are created but not visible
+	assert.NoError(t, m.StageReconciliation(state), "stage")
+	assert.Equal(t, []string{"new d=1"}, f.log, "staged")
+	assert.Equal(t, []string{"a=1", "b=1", "c=1"}, testState(m), "nothing applied")
+
+	// changed objects are reconciled first, then the new objects are added and the deleted
+	// objects are removed
+	f.log = nil
+	assert.NoError(t, m.CommitReconciliation(state), "commit")
+	assert.Equal(t, []string{"reconcile a=2", "close b=1"}, f.log, "commit order")
+	assert.Equal(t, []string{"a=2", "c=1", "d=1"}, testState(m), "applied")
+
+	// aborting a committed reconciliation is a no-op
+	m.AbortReconciliation(state)
+	assert.Equal(t, []string{"a=2", "c=1", "d=1"}, testState(m), "not aborted")
+}
+
+func TestReconcileStageFailure(t *testing.T) {
+	m, f := newTestManager(t, &testConfig{"a", "1"}, &testConfig{"b", "1"})
+
+	state, err := m.PrepareReconciliation(testConfigs(&testConfig{"a", "2"}, &testConfig{"c", "1"},
+		&testConfig{"d", "fail"}))
+	assert.NoError(t, err, "prepare")
+
+	// the objects staged before the failure are released and nothing is applied
+	assert.ErrorIs(t, m.StageReconciliation(state), errTest, "stage")
+	assert.Equal(t, []string{"new c=1", "new d=fail", "close c=1"}, f.log, "staged and released")
+	assert.Equal(t, []string{"a=1", "b=1"}, testState(m), "nothing applied")
+
+	// a failed stage is also reported by the single-step reconciliation
+	f.log = nil
+	assert.ErrorIs(t, m.FinishReconciliation(state), errTest, "finish")
+	assert.Equal(t, []string{"a=1", "b=1"}, testState(m), "nothing applied")
+}
+
+func TestReconcileCommitFailure(t *testing.T) {
+	m, f := newTestManager(t, &testConfig{"a", "1"}, &testConfig{"b", "1"}, &testConfig{"c", "1"})
+
+	state, err := m.PrepareReconciliation(testConfigs(&testConfig{"a", "2"},
+		&testConfig{"b", "fail"}, &testConfig{"d", "1"}))
+	assert.NoError(t, err, "prepare")
+	assert.NoError(t, m.StageReconciliation(state), "stage")
+
+	// the objects reconciled before the failure and the failed object are reverted in reverse
+	// order, the staged objects are not added and the deleted objects are kept
+	f.log = nil
+	assert.ErrorIs(t, m.CommitReconciliation(state), errTest, "commit")
+	assert.Equal(t, []string{"reconcile a=2", "reconcile b=fail", "reconcile b=1",
+		"reconcile a=1"}, f.log, "reverted")
+	assert.Equal(t, []string{"a=1", "b=1", "c=1"}, testState(m), "old objects kept")
+
+	f.log = nil
+	m.AbortReconciliation(state)
+	assert.Equal(t, []string{"close d=1"}, f.log, "staged objects released")
+
+	// a reconciliation that was not committed is aborted on revert
+	assert.NoError(t, m.RevertReconciliation(state), "revert")
+	assert.Equal(t, []string{"a=1", "b=1", "c=1"}, testState(m), "old objects kept")
+}
+
+func TestReconcileRevert(t *testing.T) {
+	m, f := newTestManager(t, &testConfig{"a", "1"}, &testConfig{"b", "1"}, &testConfig{"c", "1"})
+	a, _ := m.Get("a")
+
+	state, err := m.PrepareReconciliation(testConfigs(&testConfig{"a", "2"}, &testConfig{"c", "1"},
+		&testConfig{"d", "1"}))
+	assert.NoError(t, err, "prepare")
+	assert.NoError(t, m.StageReconciliation(state), "stage")
+	assert.NoError(t, m.CommitReconciliation(state), "commit")
+	assert.Equal(t, []string{"a=2", "c=1", "d=1"}, testState(m), "applied")
+
+	// a committed reconciliation, e.g., of the listeners when the clusters fail later in the
+	// transaction, is undone: the new objects are removed, the deleted objects are recreated
+	// and the changed objects are reconciled to their old config
+	f.log = nil
+	assert.NoError(t, m.RevertReconciliation(state), "revert")
+	assert.Equal(t, []string{"close d=1", "new b=1", "reconcile a=1"}, f.log, "revert order")
+	assert.Equal(t, []string{"a=1", "b=1", "c=1"}, testState(m), "old objects restored")
+	o, _ := m.Get("a")
+	assert.Same(t, a, o, "changed object reconciled in place")
+
+	// recreating a deleted object may fail, but all objects are reverted
+	state, err = m.PrepareReconciliation(testConfigs(&testConfig{"a", "2"}))
+	assert.NoError(t, err, "prepare")
+	assert.NoError(t, m.StageReconciliation(state), "stage")
+	assert.NoError(t, m.CommitReconciliation(state), "commit")
+	for i, j := range state.DeletedJobQueue {
+		if j.OldConfig.ConfigName() == "b" {
+			state.DeletedJobQueue[i].OldConfig = &testConfig{"b", "fail"}
+		}
+	}
+	assert.ErrorIs(t, m.RevertReconciliation(state), errTest, "revert")
+	assert.Equal(t, []string{"a=1", "c=1"}, testState(m), "other objects restored")
+}
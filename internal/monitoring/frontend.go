@@ -8,6 +8,7 @@ import (
 	"strconv"
 
 	"github.com/pion/logging"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	"github.com/l7mp/stunner/pkg/apis/v1alpha1"
@@ -88,7 +89,11 @@ func (b *frontendImpl) Reconcile(endpoint string) error {
 	}
 
 	mux := http.NewServeMux()
-	mux.Handle(path, promhttp.Handler())
+	// OpenMetrics carries the exemplars
+	mux.Handle(path, promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{
+			EnableOpenMetrics: true,
+		})))
 
 	server := &http.Server{
 		Addr:    addr,
@@ -7,9 +7,428 @@ import (
 
 var AllocActiveGauge prometheus.GaugeFunc
 
+// CertExpiryGauge is the expiry time of the certificate currently served by each TLS/DTLS
+// listener, in seconds since the Unix epoch
+var CertExpiryGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "stunner_listener_certificate_expiry_timestamp_seconds",
+		Help: "Expiry time of the listener certificate in seconds since the Unix epoch.",
+	},
+	[]string{"listener"},
+)
+
+// CertReloadCounter counts the certificate rotations per listener, by result
+var CertReloadCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "stunner_listener_certificate_reloads_total",
+		Help: "Number of listener certificate reloads.",
+	},
+	[]string{"listener", "result"},
+)
+
+// OCSPStapleNextUpdateGauge is the time the OCSP response currently stapled by each TLS/WSS
+// listener expires, in seconds since the Unix epoch
+var OCSPStapleNextUpdateGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "stunner_listener_ocsp_staple_next_update_timestamp_seconds",
+		Help: "Next update time of the stapled OCSP response in seconds since the Unix epoch.",
+	},
+	[]string{"listener"},
+)
+
+// OCSPStapleUpdateCounter counts the OCSP response fetches per listener, by result
+var OCSPStapleUpdateCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "stunner_listener_ocsp_staple_updates_total",
+		Help: "Number of OCSP response fetches for stapling.",
+	},
+	[]string{"listener", "result"},
+)
+
+// ClientCertRevokedCounter counts the client certificates refused as revoked per listener
+var ClientCertRevokedCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "stunner_listener_client_cert_revoked_total",
+		Help: "Number of client certificates refused as revoked.",
+	},
+	[]string{"listener"},
+)
+
+// ICMPErrorCounter counts the ICMP errors received on relay transports, by reason
+var ICMPErrorCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "stunner_relay_icmp_errors_total",
+		Help: "Number of ICMP errors received on relay transports.",
+	},
+	[]string{"reason"},
+)
+
+// ConfigRollbackCounter counts the rollbacks to the last-known-good config after a failed
+// reconciliation, by result
+var ConfigRollbackCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "stunner_config_rollbacks_total",
+		Help: "Number of rollbacks to the last-known-good configuration.",
+	},
+	[]string{"result"},
+)
+
+// ConfigGenerationGauge is the generation of the running configuration
+var ConfigGenerationGauge = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "stunner_config_generation",
+		Help: "Generation of the running configuration.",
+	},
+)
+
+// ResourceUsageGauge is the usage of the resources tracked against the watermarks: the ratio of the
+// relay port range of each listener and of the open file limit in use, and the number of active
+// allocations
+var ResourceUsageGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "stunner_resource_usage",
+		Help: "Usage of the relay ports and the file descriptors as a ratio, and number of active allocations.",
+	},
+	[]string{"resource", "listener"},
+)
+
+// WatermarkExceededGauge is 1 if the usage of a resource is above its watermark and 0 otherwise
+var WatermarkExceededGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "stunner_resource_watermark_exceeded",
+		Help: "Whether the usage of a resource is above its watermark.",
+	},
+	[]string{"resource", "listener"},
+)
+
+// DNSResolutionFailingGauge is 1 if the resolution of a domain of a STRICT_DNS cluster is failing
+// and 0 otherwise
+var DNSResolutionFailingGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "stunner_cluster_dns_resolution_failing",
+		Help: "Whether the resolution of a domain of a STRICT_DNS cluster is failing.",
+	},
+	[]string{"cluster", "domain"},
+)
+
+// ListenerACLDropCounter counts the packets (UDP listeners) and the connections (stream and DTLS
+// listeners) dropped by the source IP ACL of each listener
+var ListenerACLDropCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "stunner_listener_acl_drops_total",
+		Help: "Number of packets or connections dropped by the source IP ACL of the listener.",
+	},
+	[]string{"listener"},
+)
+
+// BanCounter counts the sources banned, by reason
+var BanCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "stunner_bans_total",
+		Help: "Number of client sources banned.",
+	},
+	[]string{"reason"},
+)
+
+// BannedSourcesGauge is the number of client sources currently banned
+var BannedSourcesGauge = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "stunner_banned_sources",
+		Help: "Number of client sources currently banned.",
+	},
+)
+
+// BanDropCounter counts the packets (UDP listeners) and the connections (stream and DTLS
+// listeners) of banned sources dropped by each listener
+var BanDropCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "stunner_ban_drops_total",
+		Help: "Number of packets or connections of banned sources dropped by the listener.",
+	},
+	[]string{"listener"},
+)
+
+// QuotaRejectionCounter counts the Allocate requests rejected by each listener for exceeding a
+// quota, by quota
+var QuotaRejectionCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "stunner_quota_rejections_total",
+		Help: "Number of Allocate requests rejected for exceeding an allocation quota.",
+	},
+	[]string{"listener", "quota"},
+)
+
+// AmplificationSuppressedCounter counts the unauthenticated requests dropped by each UDP listener,
+// and hence the responses suppressed, to protect against amplification attacks, by reason
+var AmplificationSuppressedCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "stunner_amplification_suppressed_total",
+		Help: "Number of responses suppressed against amplification attacks.",
+	},
+	[]string{"listener", "reason"},
+)
+
+// PeerPortDeniedCounter counts the packets dropped by the relay transports of each listener for
+// a peer port denied by the peer port policy, by direction ("inbound" or "outbound")
+var PeerPortDeniedCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "stunner_peer_port_denied_total",
+		Help: "Number of relayed packets dropped for a denied peer port.",
+	},
+	[]string{"listener", "direction"},
+)
+
+// StrictViolationCounter counts the messages refused by the STUN message checks of each listener,
+// by violation
+var StrictViolationCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "stunner_listener_strict_violations_total",
+		Help: "Number of messages refused by the strict STUN message checks.",
+	},
+	[]string{"listener", "violation"},
+)
+
+// RefreshIntervalHistogram is the time between two consecutive grants of the same allocation,
+// permission or channel binding on each listener, by kind ("allocation", "permission" or
+// "channel"), which shows how early the clients refresh before the lifetime expires
+var RefreshIntervalHistogram = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:    "stunner_refresh_interval_seconds",
+		Help:    "Time between two consecutive refreshes of an allocation, permission or channel binding.",
+		Buckets: []float64{15, 30, 60, 120, 180, 240, 270, 300, 450, 540, 600, 1200, 1800, 3600},
+	},
+	[]string{"listener", "kind"},
+)
+
+// AllocationLifetimeClampedCounter counts the Allocate and Refresh requests of each listener whose
+// requested lifetime was cut to the maximum allocation lifetime
+var AllocationLifetimeClampedCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "stunner_allocation_lifetime_clamped_total",
+		Help: "Number of allocation requests whose lifetime was cut to the maximum.",
+	},
+	[]string{"listener"},
+)
+
+// ExpiredGrantDropCounter counts the packets of each listener dropped for an expired permission
+// or channel binding, by kind ("permission" or "channel")
+var ExpiredGrantDropCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "stunner_expired_grant_drops_total",
+		Help: "Number of packets dropped for an expired permission or channel binding.",
+	},
+	[]string{"listener", "kind"},
+)
+
+// RequestRateLimitedCounter counts the Refresh, CreatePermission and ChannelBind requests dropped
+// by each listener for exceeding the request rate of the username, by method
+var RequestRateLimitedCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "stunner_request_rate_limited_total",
+		Help: "Number of requests dropped for exceeding the request rate of the user.",
+	},
+	[]string{"listener", "method"},
+)
+
+// MalformedPacketCounter counts the malformed packets received on each listener, by reason
+// ("oversize", "not-stun" or "bad-length")
+var MalformedPacketCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "stunner_malformed_packets_total",
+		Help: "Number of malformed packets received.",
+	},
+	[]string{"listener", "reason"},
+)
+
+// MalformedSourcesGauge is the number of client sources whose malformed packets are counted
+var MalformedSourcesGauge = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "stunner_malformed_sources",
+		Help: "Number of client sources that sent malformed packets.",
+	},
+)
+
+// ClusterProbeRTTHistogram is the round-trip time of the latency probes sent to the endpoints of
+// each cluster
+var ClusterProbeRTTHistogram = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:    "stunner_cluster_probe_rtt_seconds",
+		Help:    "Round-trip time of the latency probes sent to the cluster endpoints.",
+		Buckets: prometheus.ExponentialBuckets(0.0005, 2, 14),
+	},
+	[]string{"cluster"},
+)
+
+// ClusterProbeCounter counts the latency probes sent to the endpoints of each cluster, by result
+var ClusterProbeCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "stunner_cluster_probes_total",
+		Help: "Number of latency probes sent to the cluster endpoints.",
+	},
+	[]string{"cluster", "result"},
+)
+
+// ClusterProbeLossGauge is the ratio of the latency probes lost in the last round of probes sent
+// to the endpoints of each cluster
+var ClusterProbeLossGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "stunner_cluster_probe_loss_ratio",
+		Help: "Ratio of the latency probes lost in the last round of probes sent to the cluster endpoints.",
+	},
+	[]string{"cluster"},
+)
+
+// TenantAllocationsGauge is the number of active allocations on the listeners of each tenant
+var TenantAllocationsGauge = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "stunner_tenant_allocations_active",
+		Help: "Number of active allocations on the listeners of the tenant.",
+	},
+	[]string{"tenant"},
+)
+
+// ListenerTenantInfo is 1 for each listener of a tenant, labeled with the tenant of the listener
+var ListenerTenantInfo = prometheus.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "stunner_listener_tenant_info",
+		Help: "The tenant each listener belongs to.",
+	},
+	[]string{"listener", "tenant"},
+)
+
+// ReplicatedAllocationsGauge is the number of allocations of the other replicas that can be taken over
+var ReplicatedAllocationsGauge = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "stunner_replicated_allocations",
+		Help: "Number of allocations of the other gateway replicas that can be taken over.",
+	},
+)
+
+// ReplicationTakeoverCounter is the number of takeovers of replicated allocations per listener and
+// result
+var ReplicationTakeoverCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "stunner_replication_takeovers_total",
+		Help: "Number of takeovers of the allocations of other gateway replicas.",
+	},
+	[]string{"listener", "result"},
+)
+
+// ReplicationPushCounter is the number of allocation state pushes to the other replicas per result
+var ReplicationPushCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "stunner_replication_pushes_total",
+		Help: "Number of allocation state pushes to the other gateway replicas.",
+	},
+	[]string{"result"},
+)
+
+// HashRingMisroutedCounter counts the authenticated Allocate requests received on each listener
+// from the clients owned by another replica of the hash ring, by action: "hint" if served and
+// "redirect" if rejected with an alternate server
+var HashRingMisroutedCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "stunner_hashring_misrouted_allocations_total",
+		Help: "Number of allocation requests from clients owned by another replica of the hash ring.",
+	},
+	[]string{"listener", "action"},
+)
+
+// NATDiscoveryCounter counts the Binding requests answered by the NAT behavior discovery server of
+// each listener, by the change requested: "none", "port", "ip" or "ip-port", or "unsupported" if
+// the change was refused
+var NATDiscoveryCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "stunner_nat_discovery_requests_total",
+		Help: "Number of Binding requests answered by the NAT behavior discovery server.",
+	},
+	[]string{"listener", "change"},
+)
+
+// StandbyActiveGauge is 1 if the gateway is the active gateway of a hot-standby pair, 0 otherwise
+var StandbyActiveGauge = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "stunner_standby_active",
+		Help: "Whether the gateway is the active gateway of the hot-standby pair.",
+	},
+)
+
+// StandbyPeerReadyGauge is 1 if the other gateway of the hot-standby pair is ready to take over,
+// 0 otherwise
+var StandbyPeerReadyGauge = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "stunner_standby_peer_ready",
+		Help: "Whether the other gateway of the hot-standby pair is ready to take over.",
+	},
+)
+
+// StandbyTakeoverCounter counts the takeovers of the virtual IP of the hot-standby pair by reason:
+// "start", "failure" or "release"
+var StandbyTakeoverCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "stunner_standby_takeovers_total",
+		Help: "Number of takeovers of the virtual IP of the hot-standby pair.",
+	},
+	[]string{"reason"},
+)
+
+// AddressMappingCounter counts the relayed packets translated by the address-mapping table on the
+// relay transports of each listener, by direction ("inbound" or "outbound") and by the source of
+// the mapping ("static" or "hairpin")
+var AddressMappingCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "stunner_address_mapping_packets_total",
+		Help: "Number of relayed packets translated by the address-mapping table.",
+	},
+	[]string{"listener", "direction", "source"},
+)
+
+// PolicyDecisionCounter counts the decisions made with the policy plugin, by the kind of the
+// decision ("allocation" or "permission"), the decision ("allow" or "deny") and the origin of the
+// decision ("plugin", "builtin" if the plugin kept the built-in decision, or "failure" if the
+// plugin failed)
+var PolicyDecisionCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "stunner_policy_decisions_total",
+		Help: "Number of decisions made with the policy plugin.",
+	},
+	[]string{"kind", "decision", "origin"},
+)
+
+// TruncatedDatagramCounter counts the datagrams dropped by the batched UDP sockets of each listener
+// for not fitting into the receive buffer, by socket ("listener" or "relay")
+var TruncatedDatagramCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "stunner_udp_truncated_datagrams_total",
+		Help: "Number of datagrams dropped by the batched UDP sockets for being truncated.",
+	},
+	[]string{"listener", "socket"},
+)
+
+// UnsentDatagramCounter counts the datagrams the batched UDP sockets of each listener failed to
+// send, by socket ("listener" or "relay")
+var UnsentDatagramCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "stunner_udp_unsent_datagrams_total",
+		Help: "Number of datagrams the batched UDP sockets failed to send.",
+	},
+	[]string{"listener", "socket"},
+)
+
+// AddWithSessionExemplar increments a counter, attaching the session ID of the allocation the
+// increment is about as an exemplar, if any. Exemplars are exposed in the OpenMetrics format
+func AddWithSessionExemplar(c prometheus.Counter, session string) {
+	if e, ok := c.(prometheus.ExemplarAdder); ok && session != "" {
+		e.AddWithExemplar(1, prometheus.Labels{"session_id": session})
+		return
+	}
+	c.Inc()
+}
+
 //TODO: add connection metrics
 
-func RegisterMetrics(log logging.LeveledLogger, GetAllocationCount func() float64) {
+// RegisterMetrics registers the STUNner metrics with the given registry
+func RegisterMetrics(reg prometheus.Registerer, log logging.LeveledLogger, GetAllocationCount func() float64) {
 	AllocActiveGauge = prometheus.NewGaugeFunc(
 		prometheus.GaugeOpts{
 			Name: "stunner_allocations_active",
@@ -17,16 +436,81 @@ func RegisterMetrics(log logging.LeveledLogger, GetAllocationCount func() float6
 		},
 		GetAllocationCount,
 	)
-	if err := prometheus.Register(AllocActiveGauge); err == nil {
+	if err := reg.Register(AllocActiveGauge); err == nil {
 		log.Debug("GaugeFunc 'stunner_allocations_active' registered.")
 	} else {
 		log.Warn("GaugeFunc 'stunner_allocations_active' cannot be registered.")
 	}
+
+	for _, c := range []prometheus.Collector{CertExpiryGauge, CertReloadCounter,
+		OCSPStapleNextUpdateGauge, OCSPStapleUpdateCounter, ClientCertRevokedCounter, ICMPErrorCounter,
+		ConfigRollbackCounter, ConfigGenerationGauge, RequestCounter, SLO, ResourceUsageGauge,
+		WatermarkExceededGauge, DNSResolutionFailingGauge, ClusterProbeRTTHistogram,
+		ClusterProbeCounter, ClusterProbeLossGauge, ListenerACLDropCounter, BanCounter,
+		BannedSourcesGauge, BanDropCounter, QuotaRejectionCounter,
+		AmplificationSuppressedCounter, PeerPortDeniedCounter, StrictViolationCounter,
+		RefreshIntervalHistogram, AllocationLifetimeClampedCounter, ExpiredGrantDropCounter,
+		MalformedPacketCounter, MalformedSourcesGauge, RequestRateLimitedCounter,
+		TenantAllocationsGauge, ListenerTenantInfo, ReplicatedAllocationsGauge,
+		ReplicationTakeoverCounter, ReplicationPushCounter, HashRingMisroutedCounter,
+		NATDiscoveryCounter, StandbyActiveGauge, StandbyPeerReadyGauge,
+		StandbyTakeoverCounter, AddressMappingCounter, PolicyDecisionCounter,
+		TruncatedDatagramCounter, UnsentDatagramCounter} {
+		if err := reg.Register(c); err != nil {
+			log.Debugf("metrics cannot be registered: %s", err.Error())
+		}
+	}
 }
 
-func UnregisterMetrics(log logging.LeveledLogger) {
+// UnregisterMetrics removes the STUNner metrics from the given registry
+func UnregisterMetrics(reg prometheus.Registerer, log logging.LeveledLogger) {
+	reg.Unregister(CertExpiryGauge)
+	reg.Unregister(CertReloadCounter)
+	reg.Unregister(OCSPStapleNextUpdateGauge)
+	reg.Unregister(OCSPStapleUpdateCounter)
+	reg.Unregister(ClientCertRevokedCounter)
+	reg.Unregister(ICMPErrorCounter)
+	reg.Unregister(ConfigRollbackCounter)
+	reg.Unregister(ConfigGenerationGauge)
+	reg.Unregister(RequestCounter)
+	reg.Unregister(SLO)
+	reg.Unregister(ResourceUsageGauge)
+	reg.Unregister(WatermarkExceededGauge)
+	reg.Unregister(DNSResolutionFailingGauge)
+	reg.Unregister(ClusterProbeRTTHistogram)
+	reg.Unregister(ClusterProbeCounter)
+	reg.Unregister(ClusterProbeLossGauge)
+	reg.Unregister(ListenerACLDropCounter)
+	reg.Unregister(BanCounter)
+	reg.Unregister(BannedSourcesGauge)
+	reg.Unregister(BanDropCounter)
+	reg.Unregister(QuotaRejectionCounter)
+	reg.Unregister(AmplificationSuppressedCounter)
+	reg.Unregister(PeerPortDeniedCounter)
+	reg.Unregister(StrictViolationCounter)
+	reg.Unregister(RefreshIntervalHistogram)
+	reg.Unregister(AllocationLifetimeClampedCounter)
+	reg.Unregister(ExpiredGrantDropCounter)
+	reg.Unregister(MalformedPacketCounter)
+	reg.Unregister(MalformedSourcesGauge)
+	reg.Unregister(RequestRateLimitedCounter)
+	reg.Unregister(TenantAllocationsGauge)
+	reg.Unregister(ListenerTenantInfo)
+	reg.Unregister(ReplicatedAllocationsGauge)
+	reg.Unregister(ReplicationTakeoverCounter)
+	reg.Unregister(ReplicationPushCounter)
+	reg.Unregister(HashRingMisroutedCounter)
+	reg.Unregister(NATDiscoveryCounter)
+	reg.Unregister(StandbyActiveGauge)
+	reg.Unregister(StandbyPeerReadyGauge)
+	reg.Unregister(StandbyTakeoverCounter)
+	reg.Unregister(AddressMappingCounter)
+	reg.Unregister(PolicyDecisionCounter)
+	reg.Unregister(TruncatedDatagramCounter)
+	reg.Unregister(UnsentDatagramCounter)
+
 	if AllocActiveGauge != nil {
-		if success := prometheus.Unregister(AllocActiveGauge); success {
+		if success := reg.Unregister(AllocActiveGauge); success {
 			log.Debug("GaugeFunc 'stunner_allocations_active' unregistered.")
 			return
 		}
@@ -0,0 +1,235 @@
+package monitoring
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Results of the TURN requests counted for the SLO metrics
+const (
+	ResultSuccess     = "success"
+	ResultClientError = "client_error"
+	ResultServerError = "server_error"
+)
+
+// TURN request methods counted for the SLO metrics
+const (
+	MethodAllocate         = "allocate"
+	MethodCreatePermission = "create_permission"
+	MethodChannelBind      = "channel_bind"
+)
+
+// sloBucketWidth is the resolution of the rolling windows
+const sloBucketWidth = time.Minute
+
+// SLOWindows are the rolling windows the success ratios are computed over, matching the usual
+// multi-window burn-rate alerts
+var SLOWindows = []struct {
+	Name   string
+	Length time.Duration
+}{{"5m", 5 * time.Minute}, {"30m", 30 * time.Minute}, {"1h", time.Hour}, {"6h", 6 * time.Hour}}
+
+// RequestCounter counts the TURN requests by listener, method and result
+var RequestCounter = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "stunner_turn_requests_total",
+		Help: "Number of Allocate, CreatePermission and ChannelBind requests answered, by result.",
+	},
+	[]string{"listener", "method", "result"},
+)
+
+// SLO is the tracker of the rolling success ratios
+var SLO = NewSLOTracker()
+
+// sloCounts is the number of successful requests and of the requests failed due to a server error
+// in a bucket
+type sloCounts struct {
+	start               time.Time
+	success, serverErrs uint64
+}
+
+// sloKey identifies a time series of the success ratio
+type sloKey struct {
+	listener, method string
+}
+
+// SLOTracker computes the rolling success ratios of the TURN requests: the ratio of the successful
+// requests to the requests that were not rejected due to a client error, so that clients sending
+// bad credentials or malformed requests do not burn the error budget of the gateway
+type SLOTracker struct {
+	lock      sync.Mutex
+	buckets   map[sloKey][]sloCounts
+	retention time.Duration // the windows longer than the retention are not tracked, zero: all
+	now       func() time.Time
+	desc      *prometheus.Desc
+}
+
+// NewSLOTracker creates a new SLO tracker
+func NewSLOTracker() *SLOTracker {
+	return &SLOTracker{
+		buckets: map[sloKey][]sloCounts{},
+		now:     time.Now,
+		desc: prometheus.NewDesc("stunner_turn_request_success_ratio",
+			"Ratio of the successful Allocate, CreatePermission and ChannelBind requests to the "+
+				"requests not rejected due to a client error, over a rolling window.",
+			[]string{"listener", "method", "window"}, nil),
+	}
+}
+
+// ResultForCode classifies a TURN response: a zero code is a success, 3xx and 4xx codes are client
+// errors and 5xx codes are server errors
+func ResultForCode(code int) string {
+	switch {
+	case code == 0:
+		return ResultSuccess
+	case code < 500:
+		return ResultClientError
+	default:
+		return ResultServerError
+	}
+}
+
+// ObserveRequest records the result of a TURN request
+func ObserveRequest(listener, method, result string) {
+	RequestCounter.WithLabelValues(listener, method, result).Inc()
+	SLO.Observe(listener, method, result)
+}
+
+// SetRetention limits the rolling windows to the ones not longer than the retention, zero tracks
+// all windows. The buckets older than the longest window tracked are dropped
+func (s *SLOTracker) SetRetention(retention time.Duration) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.retention = retention
+}
+
+// windows returns the rolling windows tracked, must be called with the lock held
+func (s *SLOTracker) windows() []time.Duration {
+	ret := []time.Duration{}
+	for _, w := range SLOWindows {
+		if s.retention == 0 || w.Length <= s.retention {
+			ret = append(ret, w.Length)
+		}
+	}
+	return ret
+}
+
+// Observe records the result of a request in the rolling windows, client errors are ignored
+func (s *SLOTracker) Observe(listener, method, result string) {
+	if result != ResultSuccess && result != ResultServerError {
+		return
+	}
+
+	now := s.now().Truncate(sloBucketWidth)
+	key := sloKey{listener: listener, method: method}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	buckets := s.expire(s.buckets[key], now)
+	if len(buckets) == 0 || !buckets[len(buckets)-1].start.Equal(now) {
+		buckets = append(buckets, sloCounts{start: now})
+	}
+	b := &buckets[len(buckets)-1]
+	switch result {
+	case ResultSuccess:
+		b.success++
+	case ResultServerError:
+		b.serverErrs++
+	}
+	s.buckets[key] = buckets
+}
+
+// expire drops the buckets older than the longest window, must be called with the lock held
+func (s *SLOTracker) expire(buckets []sloCounts, now time.Time) []sloCounts {
+	windows := s.windows()
+	if len(windows) == 0 {
+		return nil
+	}
+	horizon := now.Add(-windows[len(windows)-1])
+	i := 0
+	for i < len(buckets) && !buckets[i].start.After(horizon) {
+		i++
+	}
+	return buckets[i:]
+}
+
+// Ratio returns the success ratio of the requests of a listener and a method over a window, and
+// false if there were no requests to compute the ratio from
+func (s *SLOTracker) Ratio(listener, method string, window time.Duration) (float64, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.ratio(s.buckets[sloKey{listener: listener, method: method}], window)
+}
+
+// ratio must be called with the lock held
+func (s *SLOTracker) ratio(buckets []sloCounts, window time.Duration) (float64, bool) {
+	// the current bucket is included in the window
+	from := s.now().Truncate(sloBucketWidth).Add(-window)
+	var success, total uint64
+	for _, b := range buckets {
+		if !b.start.After(from) {
+			continue
+		}
+		success += b.success
+		total += b.success + b.serverErrs
+	}
+	if total == 0 {
+		return 0, false
+	}
+	return float64(success) / float64(total), true
+}
+
+// Reset forgets all requests
+func (s *SLOTracker) Reset() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.buckets = map[sloKey][]sloCounts{}
+}
+
+// Describe implements prometheus.Collector
+func (s *SLOTracker) Describe(ch chan<- *prometheus.Desc) {
+	ch <- s.desc
+}
+
+// Collect implements prometheus.Collector
+func (s *SLOTracker) Collect(ch chan<- prometheus.Metric) {
+	now := s.now().Truncate(sloBucketWidth)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	keys := make([]sloKey, 0, len(s.buckets))
+	for key, buckets := range s.buckets {
+		buckets = s.expire(buckets, now)
+		if len(buckets) == 0 {
+			delete(s.buckets, key)
+			continue
+		}
+		s.buckets[key] = buckets
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].listener != keys[j].listener {
+			return keys[i].listener < keys[j].listener
+		}
+		return keys[i].method < keys[j].method
+	})
+
+	for _, key := range keys {
+		for _, w := range SLOWindows {
+			if s.retention > 0 && w.Length > s.retention {
+				continue
+			}
+			r, ok := s.ratio(s.buckets[key], w.Length)
+			if !ok {
+				continue
+			}
+			ch <- prometheus.MustNewConstMetric(s.desc, prometheus.GaugeValue, r,
+				key.listener, key.method, w.Name)
+		}
+	}
+}
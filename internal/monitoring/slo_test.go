@@ -0,0 +1,96 @@
+package monitoring
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSLOTracker(t *testing.T) {
+	now := time.Date(2022, 10, 11, 12, 30, 0, 0, time.UTC)
+	s := NewSLOTracker()
+	s.now = func() time.Time { return now }
+
+	_, ok := s.Ratio("udp", MethodAllocate, time.Hour)
+	assert.False(t, ok, "no requests")
+
+	// an hour ago: 1 success, 3 server errors
+	now = now.Add(-time.Hour)
+	s.Observe("udp", MethodAllocate, ResultSuccess)
+	for i := 0; i < 3; i++ {
+		s.Observe("udp", MethodAllocate, ResultServerError)
+	}
+
+	// now: 3 successes and a client error
+	now = now.Add(time.Hour)
+	for i := 0; i < 3; i++ {
+		s.Observe("udp", MethodAllocate, ResultSuccess)
+	}
+	s.Observe("udp", MethodAllocate, ResultClientError)
+	s.Observe("udp", MethodCreatePermission, ResultClientError)
+
+	r, ok := s.Ratio("udp", MethodAllocate, 5*time.Minute)
+	assert.True(t, ok, "ratio")
+	assert.Equal(t, 1.0, r, "client errors ignored")
+	r, ok = s.Ratio("udp", MethodAllocate, time.Hour)
+	assert.True(t, ok, "ratio")
+	assert.Equal(t, 1.0, r, "window excludes the old bucket")
+	r, ok = s.Ratio("udp", MethodAllocate, 6*time.Hour)
+	assert.True(t, ok, "ratio")
+	assert.Equal(t, 4.0/7.0, r, "long window")
+	_, ok = s.Ratio("udp", MethodCreatePermission, time.Hour)
+	assert.False(t, ok, "client errors only")
+
+	expected := `
+# HELP stunner_turn_request_success_ratio Ratio of the successful Allocate, CreatePermission and ChannelBind requests to the requests not rejected due to a client error, over a rolling window.
+# TYPE stunner_turn_request_success_ratio gauge
+stunner_turn_request_success_ratio{listener="udp",method="allocate",window="1h"} 1
+stunner_turn_request_success_ratio{listener="udp",method="allocate",window="30m"} 1
+stunner_turn_request_success_ratio{listener="udp",method="allocate",window="5m"} 1
+stunner_turn_request_success_ratio{listener="udp",method="allocate",window="6h"} 0.5714285714285714
+`
+	assert.NoError(t, testutil.CollectAndCompare(s, strings.NewReader(expected)), "collect")
+
+	// old buckets expire
+	now = now.Add(6 * time.Hour)
+	assert.Equal(t, 0, testutil.CollectAndCount(s), "expired")
+}
+
+func TestSLOTrackerRetention(t *testing.T) {
+	now := time.Date(2022, 10, 11, 12, 30, 0, 0, time.UTC)
+	s := NewSLOTracker()
+	s.now = func() time.Time { return now }
+	s.SetRetention(time.Hour)
+
+	// two hours ago: a server error, dropped with the 6h window
+	now = now.Add(-2 * time.Hour)
+	s.Observe("udp", MethodAllocate, ResultServerError)
+	now = now.Add(2 * time.Hour)
+	s.Observe("udp", MethodAllocate, ResultSuccess)
+
+	expected := `
+# HELP stunner_turn_request_success_ratio Ratio of the successful Allocate, CreatePermission and ChannelBind requests to the requests not rejected due to a client error, over a rolling window.
+# TYPE stunner_turn_request_success_ratio gauge
+stunner_turn_request_success_ratio{listener="udp",method="allocate",window="1h"} 1
+stunner_turn_request_success_ratio{listener="udp",method="allocate",window="30m"} 1
+stunner_turn_request_success_ratio{listener="udp",method="allocate",window="5m"} 1
+`
+	assert.NoError(t, testutil.CollectAndCompare(s, strings.NewReader(expected)), "collect")
+	r, ok := s.Ratio("udp", MethodAllocate, 6*time.Hour)
+	assert.True(t, ok, "ratio")
+	assert.Equal(t, 1.0, r, "old bucket dropped")
+
+	s.SetRetention(0)
+	assert.Equal(t, 4, testutil.CollectAndCount(s), "all windows")
+}
+
+func TestSLOResultForCode(t *testing.T) {
+	assert.Equal(t, ResultSuccess, ResultForCode(0), "success")
+	assert.Equal(t, ResultClientError, ResultForCode(401), "unauthorized")
+	assert.Equal(t, ResultClientError, ResultForCode(403), "forbidden")
+	assert.Equal(t, ResultServerError, ResultForCode(500), "server error")
+	assert.Equal(t, ResultServerError, ResultForCode(508), "insufficient capacity")
+}
@@ -0,0 +1,81 @@
+// Package nat64 implements the IPv4-embedded IPv6 address format of RFC 6052, so that peers
+// reached through a NAT64 gateway can be matched against clusters defined with IPv4 endpoints
+// and relayed over IPv4 relay transports.
+package nat64
+
+import (
+	"fmt"
+	"net"
+)
+
+// WellKnownPrefix is the well-known NAT64 prefix of RFC 6052
+const WellKnownPrefix = "64:ff9b::/96"
+
+// ParsePrefix parses a NAT64 prefix, which must be an IPv6 CIDR of length 32, 40, 48, 56, 64 or
+// 96 as per RFC 6052 Section 2.2
+func ParsePrefix(prefix string) (*net.IPNet, error) {
+	ip, n, err := net.ParseCIDR(prefix)
+	if err != nil {
+		return nil, err
+	}
+	if ip.To4() != nil {
+		return nil, fmt.Errorf("invalid NAT64 prefix %q: not an IPv6 prefix", prefix)
+	}
+
+	ones, _ := n.Mask.Size()
+	switch ones {
+	case 32, 40, 48, 56, 64, 96:
+		return n, nil
+	default:
+		return nil, fmt.Errorf("invalid NAT64 prefix %q: prefix length must be one of "+
+			"32, 40, 48, 56, 64 or 96", prefix)
+	}
+}
+
+// Extract returns the IPv4 address embedded into an IPv6 address with the given prefix, or false
+// if the address does not fall into the prefix
+func Extract(ip net.IP, prefix *net.IPNet) (net.IP, bool) {
+	if prefix == nil || ip.To4() != nil || !prefix.Contains(ip) {
+		return nil, false
+	}
+
+	ip = ip.To16()
+	if ip == nil {
+		return nil, false
+	}
+
+	ones, _ := prefix.Mask.Size()
+	v4 := make(net.IP, 0, net.IPv4len)
+	for i := ones / 8; len(v4) < net.IPv4len; i++ {
+		// bits 64-71 (the "u" octet) must be skipped
+		if i == 8 {
+			continue
+		}
+		v4 = append(v4, ip[i])
+	}
+
+	return net.IPv4(v4[0], v4[1], v4[2], v4[3]), true
+}
+
+// Synthesize embeds an IPv4 address into an IPv6 address with the given prefix
+func Synthesize(ip net.IP, prefix *net.IPNet) (net.IP, bool) {
+	v4 := ip.To4()
+	if prefix == nil || v4 == nil {
+		return nil, false
+	}
+
+	ret := make(net.IP, net.IPv6len)
+	copy(ret, prefix.IP.To16())
+
+	ones, _ := prefix.Mask.Size()
+	j := 0
+	for i := ones / 8; j < net.IPv4len; i++ {
+		if i == 8 {
+			continue
+		}
+		ret[i] = v4[j]
+		j++
+	}
+
+	return ret, true
+}
@@ -0,0 +1,51 @@
+package nat64
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// RFC 6052 Section 2.4 examples
+var testAddrs = []struct {
+	prefix, ipv6 string
+}{
+	{"2001:db8::/32", "2001:db8:c000:221::"},
+	{"2001:db8:100::/40", "2001:db8:1c0:2:21::"},
+	{"2001:db8:122::/48", "2001:db8:122:c000:2:2100::"},
+	{"2001:db8:122:300::/56", "2001:db8:122:3c0:0:221::"},
+	{"2001:db8:122:344::/64", "2001:db8:122:344:c0:2:2100:0"},
+	{"2001:db8:122:344::/96", "2001:db8:122:344::192.0.2.33"},
+	{WellKnownPrefix, "64:ff9b::192.0.2.33"},
+}
+
+func TestNAT64Addresses(t *testing.T) {
+	v4 := net.ParseIP("192.0.2.33")
+	for _, c := range testAddrs {
+		prefix, err := ParsePrefix(c.prefix)
+		assert.NoError(t, err, "parse prefix %s", c.prefix)
+		v6 := net.ParseIP(c.ipv6)
+
+		ip, ok := Extract(v6, prefix)
+		assert.True(t, ok, "extract from %s", c.ipv6)
+		assert.True(t, v4.Equal(ip), "extracted address from %s: %s", c.ipv6, ip)
+
+		ip, ok = Synthesize(v4, prefix)
+		assert.True(t, ok, "synthesize in %s", c.prefix)
+		assert.True(t, v6.Equal(ip), "synthesized address in %s: %s", c.prefix, ip)
+	}
+
+	prefix, _ := ParsePrefix(WellKnownPrefix)
+	_, ok := Extract(net.ParseIP("2001:db8::1"), prefix)
+	assert.False(t, ok, "address out of prefix")
+	_, ok = Extract(v4, prefix)
+	assert.False(t, ok, "IPv4 address")
+	_, ok = Extract(net.ParseIP("64:ff9b::192.0.2.33"), nil)
+	assert.False(t, ok, "no prefix")
+
+	for _, p := range []string{"10.0.0.0/8", "64:ff9b::/95", "garbage"} {
+		_, err := ParsePrefix(p)
+		assert.Error(t, err, "invalid prefix %s", p)
+	}
+}
@@ -0,0 +1,85 @@
+package nat64
+
+import (
+	"net"
+	"sync"
+
+	"github.com/pion/turn/v2"
+)
+
+// relayAddressGenerator wraps a TURN relay address generator so that IPv4 relay transports can
+// reach peers addressed with NAT64-synthesized IPv6 addresses
+type relayAddressGenerator struct {
+	turn.RelayAddressGenerator
+	prefix func() *net.IPNet
+}
+
+// NewRelayAddressGenerator wraps the relay address generator of a listener. The prefix callback
+// returns the current NAT64 prefix, or nil if NAT64 translation is disabled.
+func NewRelayAddressGenerator(gen turn.RelayAddressGenerator, prefix func() *net.IPNet) turn.RelayAddressGenerator {
+	return &relayAddressGenerator{RelayAddressGenerator: gen, prefix: prefix}
+}
+
+func (r *relayAddressGenerator) AllocatePacketConn(network string, requestedPort int) (net.PacketConn, net.Addr, error) {
+	conn, addr, err := r.RelayAddressGenerator.AllocatePacketConn(network, requestedPort)
+	if err != nil {
+		return conn, addr, err
+	}
+
+	// only IPv4 relay transports need translation
+	if a, ok := addr.(*net.UDPAddr); !ok || a.IP.To4() == nil {
+		return conn, addr, nil
+	}
+
+	return &relayConn{PacketConn: conn, prefix: r.prefix, peers: make(map[string]*net.UDPAddr)}, addr, nil
+}
+
+// relayConn translates NAT64 peer addresses: packets sent to a synthesized IPv6 address go out
+// to the embedded IPv4 address, and packets received from that IPv4 address are reported as
+// coming from the IPv6 address the client used, so that TURN permissions keep matching
+type relayConn struct {
+	net.PacketConn
+	prefix func() *net.IPNet
+	lock   sync.RWMutex
+	peers  map[string]*net.UDPAddr // IPv4 peer -> synthesized IPv6 peer
+}
+
+func (c *relayConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if a, ok := addr.(*net.UDPAddr); ok {
+		if v4, ok := Extract(a.IP, c.prefix()); ok {
+			peer := &net.UDPAddr{IP: v4, Port: a.Port}
+			key := peer.String()
+
+			c.lock.RLock()
+			_, found := c.peers[key]
+			c.lock.RUnlock()
+			if !found {
+				c.lock.Lock()
+				c.peers[key] = a
+				c.lock.Unlock()
+			}
+
+			addr = peer
+		}
+	}
+
+	return c.PacketConn.WriteTo(b, addr)
+}
+
+func (c *relayConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, err := c.PacketConn.ReadFrom(b)
+	if err != nil {
+		return n, addr, err
+	}
+
+	if a, ok := addr.(*net.UDPAddr); ok && a.IP.To4() != nil {
+		c.lock.RLock()
+		v6, found := c.peers[a.String()]
+		c.lock.RUnlock()
+		if found {
+			addr = v6
+		}
+	}
+
+	return n, addr, nil
+}
@@ -0,0 +1,126 @@
+package object
+
+import (
+	"net"
+
+	"github.com/l7mp/stunner/internal/monitoring"
+	"github.com/l7mp/stunner/pkg/apis/v1alpha1"
+)
+
+// sourceACL is the source IP access control list of a listener
+type sourceACL struct {
+	allowed, denied []*net.IPNet
+}
+
+func newSourceACL(allowed, denied []string) (*sourceACL, error) {
+	acl := &sourceACL{}
+	for _, c := range allowed {
+		n, err := v1alpha1.ParseSourceCIDR(c)
+		if err != nil {
+			return nil, err
+		}
+		acl.allowed = append(acl.allowed, n)
+	}
+	for _, c := range denied {
+		n, err := v1alpha1.ParseSourceCIDR(c)
+		if err != nil {
+			return nil, err
+		}
+		acl.denied = append(acl.denied, n)
+	}
+	return acl, nil
+}
+
+// allows checks an address against the ACL: denied prefixes take precedence, and if there are
+// allowed prefixes then the address must match one
+func (acl *sourceACL) allows(ip net.IP) bool {
+	for _, n := range acl.denied {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	if len(acl.allowed) == 0 {
+		return true
+	}
+	for _, n := range acl.allowed {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsSource returns true if the source IP ACL of the listener accepts a client
+func (l *Listener) AllowsSource(addr net.Addr) bool {
+	acl, ok := l.acl.Load().(*sourceACL)
+	if !ok || (len(acl.allowed) == 0 && len(acl.denied) == 0) {
+		return true
+	}
+
+	var ip net.IP
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		ip = a.IP
+	case *net.TCPAddr:
+		ip = a.IP
+	default:
+		host, _, err := net.SplitHostPort(addr.String())
+		if err != nil {
+			return false
+		}
+		ip = net.ParseIP(host)
+	}
+	if ip == nil {
+		return false
+	}
+	return acl.allows(ip)
+}
+
+// drop counts and logs a packet or a connection refused by the source ACL
+func (l *Listener) drop(addr net.Addr) {
+	monitoring.ListenerACLDropCounter.WithLabelValues(l.Name).Inc()
+	l.log.Debugf("listener %s: dropping traffic from %s: source not allowed", l.Name, addr)
+}
+
+// NewACLPacketConn wraps the socket of a packet listener so that the packets from sources refused
+// by the source ACL of the listener are dropped before any STUN/TURN processing
+func (l *Listener) NewACLPacketConn(conn net.PacketConn) net.PacketConn {
+	return &aclPacketConn{PacketConn: conn, listener: l}
+}
+
+type aclPacketConn struct {
+	net.PacketConn
+	listener *Listener
+}
+
+func (c *aclPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		n, addr, err := c.PacketConn.ReadFrom(b)
+		if err != nil || c.listener.AllowsSource(addr) {
+			return n, addr, err
+		}
+		c.listener.drop(addr)
+	}
+}
+
+// NewACLListener wraps the socket of a stream listener so that the connections from sources
+// refused by the source ACL of the listener are closed before any STUN/TURN processing
+func (l *Listener) NewACLListener(ln net.Listener) net.Listener {
+	return &aclListener{Listener: ln, listener: l}
+}
+
+type aclListener struct {
+	net.Listener
+	listener *Listener
+}
+
+func (a *aclListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := a.Listener.Accept()
+		if err != nil || a.listener.AllowsSource(conn.RemoteAddr()) {
+			return conn, err
+		}
+		a.listener.drop(conn.RemoteAddr())
+		conn.Close()
+	}
+}
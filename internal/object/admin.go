@@ -1,9 +1,15 @@
 package object
 
 import (
+	"fmt"
+	"net"
+	"time"
+
 	"github.com/pion/logging"
 
+	"github.com/l7mp/stunner/internal/api"
 	"github.com/l7mp/stunner/internal/monitoring"
+	"github.com/l7mp/stunner/internal/nat64"
 	"github.com/l7mp/stunner/pkg/apis/v1alpha1"
 )
 
@@ -11,20 +17,54 @@ const DefaultAdminObjectName = "DefaultAdmin"
 
 // Admin is the main object holding STUNner administration info
 type Admin struct {
-	Name, LogLevel, MetricsEndpoint string
-	log                             logging.LeveledLogger
-	MonitoringFrontend              monitoring.Frontend
+	Name, LogLevel, MetricsEndpoint, APIEndpoint, APIToken string
+	LogFormat, LogFile, AccessLog, AccessLogFormat         string
+	AuditLog                                               string
+	EventWebhook, TracingEndpoint, CaptureDir              string
+	SyslogEndpoint, SyslogFacility                         string
+	SDSEndpoint                                            string
+	TracingSampleRatio                                     float64
+	LogMaxSize, LogMaxBackups, LogThrottleBurst            int
+	LogMaxAge, LogThrottleInterval                         time.Duration
+	NAT64Prefix                                            *net.IPNet
+	RestartPolicy                                          v1alpha1.RestartPolicy
+	DrainTimeout                                           time.Duration
+	PermissionLifetime, ChannelLifetime                    time.Duration
+	MaxAllocationLifetime                                  time.Duration
+	DefaultRoute                                           v1alpha1.DefaultRoutePolicy
+	ResourceProfile                                        v1alpha1.ResourceProfile
+	FIPSMode                                               bool
+	AllowInsecureProtocols                                 *bool
+	Notifier                                               *v1alpha1.NotifierConfig
+	MessageTrace                                           *v1alpha1.MessageTraceConfig
+	Watermarks                                             *v1alpha1.WatermarkConfig
+	DNSHealth                                              *v1alpha1.DNSHealthConfig
+	LatencyProbe                                           *v1alpha1.LatencyProbeConfig
+	Ban                                                    *v1alpha1.BanConfig
+	Quota                                                  *v1alpha1.QuotaConfig
+	Amplification                                          *v1alpha1.AmplificationConfig
+	Malformed                                              *v1alpha1.MalformedConfig
+	RequestRate                                            *v1alpha1.RequestRateConfig
+	PeerPorts                                              *v1alpha1.PeerPortConfig
+	Replication                                            *v1alpha1.ReplicationConfig
+	ConsistentHashing                                      *v1alpha1.ConsistentHashingConfig
+	Standby                                                *v1alpha1.StandbyConfig
+	AddressMapping                                         *v1alpha1.AddressMappingConfig
+	PolicyPlugin                                           *v1alpha1.PolicyPluginConfig
+	log                                                    logging.LeveledLogger
+	MonitoringFrontend                                     monitoring.Frontend
+	APIServer                                              api.Server
 }
 
 // NewAdmin creates a new Admin object. Requires a server restart (returns
 // v1alpha1.ErrRestartRequired)
-func NewAdmin(conf v1alpha1.Config, mf monitoring.Frontend, logger logging.LoggerFactory) (Object, error) {
+func NewAdmin(conf v1alpha1.Config, mf monitoring.Frontend, as api.Server, logger logging.LoggerFactory) (Object, error) {
 	req, ok := conf.(*v1alpha1.AdminConfig)
 	if !ok {
 		return nil, v1alpha1.ErrInvalidConf
 	}
 
-	admin := Admin{MonitoringFrontend: mf, log: logger.NewLogger("stunner-admin")}
+	admin := Admin{MonitoringFrontend: mf, APIServer: as, log: logger.NewLogger("stunner-admin")}
 	admin.log.Tracef("NewAdmin: %#v", req)
 
 	if err := admin.Reconcile(req); err != nil && err != v1alpha1.ErrRestartRequired {
@@ -57,13 +97,91 @@ func (a *Admin) Reconcile(conf v1alpha1.Config) error {
 
 	a.Name = req.Name
 	a.LogLevel = req.LogLevel
+	a.LogFormat = req.LogFormat
+	a.LogFile = req.LogFile
+	a.LogMaxSize = req.LogMaxSize
+	a.LogMaxAge = time.Duration(req.LogMaxAge) * time.Hour
+	a.LogMaxBackups = req.LogMaxBackups
+	a.LogThrottleInterval = time.Duration(req.LogThrottleInterval) * time.Second
+	a.LogThrottleBurst = req.LogThrottleBurst
+	a.SyslogEndpoint = req.SyslogEndpoint
+	a.SyslogFacility = req.SyslogFacility
+	a.AccessLog = req.AccessLog
+	a.AccessLogFormat = req.AccessLogFormat
+	a.AuditLog = req.AuditLog
+	a.TracingEndpoint = req.TracingEndpoint
+	a.TracingSampleRatio = req.TracingSampleRatio
+	a.CaptureDir = req.CaptureDir
 	a.MetricsEndpoint = req.MetricsEndpoint
+	a.APIEndpoint = req.APIEndpoint
+	a.APIToken = req.APIToken
+	a.EventWebhook = req.EventWebhook
+	a.Notifier = req.Notifier.DeepCopy()
+	a.MessageTrace = req.MessageTrace.DeepCopy()
+	a.Watermarks = req.Watermarks.DeepCopy()
+	a.DNSHealth = req.DNSHealth.DeepCopy()
+	a.LatencyProbe = req.LatencyProbe.DeepCopy()
+	a.Ban = req.Ban.DeepCopy()
+	a.Quota = req.Quota.DeepCopy()
+	a.Amplification = req.Amplification.DeepCopy()
+	a.Malformed = req.Malformed.DeepCopy()
+	a.RequestRate = req.RequestRate.DeepCopy()
+	a.PeerPorts = req.PeerPorts.DeepCopy()
+	a.Replication = req.Replication.DeepCopy()
+	a.ConsistentHashing = req.ConsistentHashing.DeepCopy()
+	a.Standby = req.Standby.DeepCopy()
+	a.AddressMapping = req.AddressMapping.DeepCopy()
+	a.PolicyPlugin = req.PolicyPlugin.DeepCopy()
+	a.SDSEndpoint = req.SDSEndpoint
+	a.FIPSMode = req.FIPSMode
+	a.AllowInsecureProtocols = nil
+	if req.AllowInsecureProtocols != nil {
+		allow := *req.AllowInsecureProtocols
+		a.AllowInsecureProtocols = &allow
+	}
+	a.DrainTimeout = time.Duration(req.DrainTimeout) * time.Second
+	a.PermissionLifetime = time.Duration(req.PermissionLifetime) * time.Second
+	a.ChannelLifetime = time.Duration(req.ChannelLifetime) * time.Second
+	a.MaxAllocationLifetime = time.Duration(req.MaxAllocationLifetime) * time.Second
+
+	policy, err := v1alpha1.NewRestartPolicy(req.RestartPolicy)
+	if err != nil {
+		return err
+	}
+	a.RestartPolicy = policy
+
+	route, err := v1alpha1.NewDefaultRoutePolicy(req.DefaultRoute)
+	if err != nil {
+		return err
+	}
+	a.DefaultRoute = route
+
+	profile, err := v1alpha1.NewResourceProfile(req.ResourceProfile)
+	if err != nil {
+		return err
+	}
+	a.ResourceProfile = profile
+
+	a.NAT64Prefix = nil
+	if req.NAT64Prefix != "" {
+		prefix, err := nat64.ParsePrefix(req.NAT64Prefix)
+		if err != nil {
+			return err
+		}
+		a.NAT64Prefix = prefix
+	}
 
 	// monitoring
 	if err := a.MonitoringFrontend.Reconcile(a.MetricsEndpoint); err != nil {
 		a.log.Warnf("error in reconciling metrics endpoint: %s", err)
 	}
 
+	// admin API
+	a.APIServer.SetToken(a.APIToken)
+	if err := a.APIServer.Reconcile(a.APIEndpoint); err != nil {
+		a.log.Warnf("error in reconciling admin API endpoint: %s", err)
+	}
+
 	return nil
 }
 
@@ -76,11 +194,72 @@ func (a *Admin) ObjectName() string {
 // GetConfig returns the configuration of the running object
 func (a *Admin) GetConfig() v1alpha1.Config {
 	a.log.Tracef("GetConfig")
-	return &v1alpha1.AdminConfig{
-		Name:            a.Name,
-		LogLevel:        a.LogLevel,
-		MetricsEndpoint: a.MetricsEndpoint,
+	c := &v1alpha1.AdminConfig{
+		Name:                   a.Name,
+		LogLevel:               a.LogLevel,
+		LogFormat:              a.LogFormat,
+		LogFile:                a.LogFile,
+		LogMaxSize:             a.LogMaxSize,
+		LogMaxAge:              int(a.LogMaxAge / time.Hour),
+		LogMaxBackups:          a.LogMaxBackups,
+		LogThrottleInterval:    int(a.LogThrottleInterval / time.Second),
+		LogThrottleBurst:       a.LogThrottleBurst,
+		SyslogEndpoint:         a.SyslogEndpoint,
+		SyslogFacility:         a.SyslogFacility,
+		AccessLog:              a.AccessLog,
+		AccessLogFormat:        a.AccessLogFormat,
+		AuditLog:               a.AuditLog,
+		TracingEndpoint:        a.TracingEndpoint,
+		TracingSampleRatio:     a.TracingSampleRatio,
+		CaptureDir:             a.CaptureDir,
+		MetricsEndpoint:        a.MetricsEndpoint,
+		APIEndpoint:            a.APIEndpoint,
+		APIToken:               a.APIToken,
+		RestartPolicy:          a.RestartPolicy.String(),
+		DrainTimeout:           int(a.DrainTimeout / time.Second),
+		EventWebhook:           a.EventWebhook,
+		DefaultRoute:           a.DefaultRoute.String(),
+		ResourceProfile:        a.ResourceProfile.String(),
+		FIPSMode:               a.FIPSMode,
+		AllowInsecureProtocols: a.AllowInsecureProtocols,
+		PermissionLifetime:     int(a.PermissionLifetime / time.Second),
+		ChannelLifetime:        int(a.ChannelLifetime / time.Second),
+		MaxAllocationLifetime:  int(a.MaxAllocationLifetime / time.Second),
+		Notifier:               a.Notifier.DeepCopy(),
+		MessageTrace:           a.MessageTrace.DeepCopy(),
+		Watermarks:             a.Watermarks.DeepCopy(),
+		DNSHealth:              a.DNSHealth.DeepCopy(),
+		LatencyProbe:           a.LatencyProbe.DeepCopy(),
+		Ban:                    a.Ban.DeepCopy(),
+		Quota:                  a.Quota.DeepCopy(),
+		Amplification:          a.Amplification.DeepCopy(),
+		Malformed:              a.Malformed.DeepCopy(),
+		RequestRate:            a.RequestRate.DeepCopy(),
+		PeerPorts:              a.PeerPorts.DeepCopy(),
+		Replication:            a.Replication.DeepCopy(),
+		ConsistentHashing:      a.ConsistentHashing.DeepCopy(),
+		Standby:                a.Standby.DeepCopy(),
+		AddressMapping:         a.AddressMapping.DeepCopy(),
+		PolicyPlugin:           a.PolicyPlugin.DeepCopy(),
+		SDSEndpoint:            a.SDSEndpoint,
 	}
+	if a.NAT64Prefix != nil {
+		c.NAT64Prefix = a.NAT64Prefix.String()
+	}
+	return c
+}
+
+// Status returns the status of the admin object, which is degraded if the admin API server is
+// configured but not running
+func (a *Admin) Status() ObjectStatus {
+	degraded := Condition{Reason: "AsExpected"}
+	if a.APIEndpoint != "" && !a.APIServer.Running() {
+		degraded = Condition{Status: true, Reason: "APIServerDown",
+			Message: fmt.Sprintf("admin API server not running at %s", a.APIEndpoint)}
+	}
+
+	return NewObjectStatus("admin", a.Name, Condition{Status: true, Reason: "AsExpected"},
+		degraded, Condition{Reason: "AsExpected"})
 }
 
 // Close closes the Admin object
@@ -92,12 +271,13 @@ func (a *Admin) Close() error {
 // AdminFactory can create now Admin objects
 type AdminFactory struct {
 	monitoringFrontend monitoring.Frontend
+	apiServer          api.Server
 	logger             logging.LoggerFactory
 }
 
 // NewAdminFactory creates a new factory for Admin objects
-func NewAdminFactory(mf monitoring.Frontend, logger logging.LoggerFactory) Factory {
-	return &AdminFactory{monitoringFrontend: mf, logger: logger}
+func NewAdminFactory(mf monitoring.Frontend, as api.Server, logger logging.LoggerFactory) Factory {
+	return &AdminFactory{monitoringFrontend: mf, apiServer: as, logger: logger}
 }
 
 // New can produce a new Admin object from the given configuration. A nil config will create an
@@ -107,5 +287,5 @@ func (f *AdminFactory) New(conf v1alpha1.Config) (Object, error) {
 		return &Admin{}, nil
 	}
 
-	return NewAdmin(conf, f.monitoringFrontend, f.logger)
+	return NewAdmin(conf, f.monitoringFrontend, f.apiServer, f.logger)
 }
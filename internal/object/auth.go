@@ -1,7 +1,8 @@
 package object
 
 import (
-	// "fmt"
+	"fmt"
+	"sort"
 
 	"github.com/pion/logging"
 	// "github.com/pion/turn/v2"
@@ -13,7 +14,19 @@ import (
 type Auth struct {
 	Type                              v1alpha1.AuthType
 	Realm, Username, Password, Secret string
-	Log                               logging.LeveledLogger
+	GenericRealm                      bool
+	// Tenants holds the authenticators of the tenants with their own credentials
+	Tenants map[string]*Auth
+	Log     logging.LeveledLogger
+}
+
+// ForTenant returns the authenticator of the clients of a tenant: the authenticator of the tenant
+// if the tenant has its own credentials, otherwise the authenticator of the gateway
+func (auth *Auth) ForTenant(tenant string) *Auth {
+	if t, ok := auth.Tenants[tenant]; ok && tenant != "" {
+		return t
+	}
+	return auth
 }
 
 // NewAuth creates a new authenticator. Requires a server restart (returns
@@ -82,15 +95,45 @@ func (auth *Auth) Reconcile(conf v1alpha1.Config) error {
 	// no error: update
 	auth.Type = atype
 	auth.Realm = req.Realm
+	auth.GenericRealm = req.GenericRealm
+	auth.setCredentials(atype, req.Credentials)
+
+	tenants := make(map[string]*Auth, len(req.Tenants))
+	for _, t := range req.Tenants {
+		ttype, _ := v1alpha1.NewAuthType(t.Type)
+		tenant := &Auth{Realm: req.Realm, GenericRealm: req.GenericRealm, Log: auth.Log}
+		tenant.setCredentials(ttype, t.Credentials)
+		tenants[t.Tenant] = tenant
+	}
+	auth.Tenants = tenants
+
+	return nil
+}
+
+// setCredentials sets the type and the credentials of an authenticator
+func (auth *Auth) setCredentials(atype v1alpha1.AuthType, credentials map[string]string) {
+	auth.Type = atype
+	auth.Username, auth.Password, auth.Secret = "", "", ""
 	switch atype {
 	case v1alpha1.AuthTypePlainText:
-		auth.Username = req.Credentials["username"]
-		auth.Password = req.Credentials["password"]
+		auth.Username = credentials["username"]
+		auth.Password = credentials["password"]
 	case v1alpha1.AuthTypeLongTerm:
-		auth.Secret = req.Credentials["secret"]
+		auth.Secret = credentials["secret"]
 	}
+}
 
-	return nil
+// credentials returns the credentials of an authenticator
+func (auth *Auth) credentials() map[string]string {
+	c := make(map[string]string)
+	switch auth.Type {
+	case v1alpha1.AuthTypePlainText:
+		c["username"] = auth.Username
+		c["password"] = auth.Password
+	case v1alpha1.AuthTypeLongTerm:
+		c["secret"] = auth.Secret
+	}
+	return c
 }
 
 // Name returns the name of the object
@@ -103,21 +146,27 @@ func (auth *Auth) ObjectName() string {
 func (auth *Auth) GetConfig() v1alpha1.Config {
 	auth.Log.Tracef("GetConfig")
 	r := v1alpha1.AuthConfig{
-		Type:        auth.Type.String(),
-		Realm:       auth.Realm,
-		Credentials: make(map[string]string),
+		Type:         auth.Type.String(),
+		Realm:        auth.Realm,
+		GenericRealm: auth.GenericRealm,
+		Credentials:  auth.credentials(),
 	}
-	switch auth.Type {
-	case v1alpha1.AuthTypePlainText:
-		r.Credentials["username"] = auth.Username
-		r.Credentials["password"] = auth.Password
-	case v1alpha1.AuthTypeLongTerm:
-		r.Credentials["secret"] = auth.Secret
+	for name, t := range auth.Tenants {
+		r.Tenants = append(r.Tenants, v1alpha1.TenantAuthConfig{Tenant: name,
+			Type: t.Type.String(), Credentials: t.credentials()})
 	}
+	sort.Slice(r.Tenants, func(i, j int) bool { return r.Tenants[i].Tenant < r.Tenants[j].Tenant })
 
 	return &r
 }
 
+// Status returns the status of the authenticator, which is always ready
+func (auth *Auth) Status() ObjectStatus {
+	return NewObjectStatus("auth", v1alpha1.DefaultAuthName, Condition{Status: true,
+		Reason: "AsExpected", Message: fmt.Sprintf("type: %s, realm: %s", auth.Type.String(),
+			auth.Realm)}, Condition{Reason: "AsExpected"}, Condition{Reason: "AsExpected"})
+}
+
 // Close closes the authenticator
 func (auth *Auth) Close() error {
 	auth.Log.Tracef("Close")
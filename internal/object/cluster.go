@@ -5,6 +5,7 @@ import (
 	"net"
 	"sort"
 	"strings"
+	"time"
 
 	"github.com/pion/logging"
 
@@ -15,13 +16,15 @@ import (
 
 // Listener implements a STUNner cluster
 type Cluster struct {
-	Name      string
-	Type      v1alpha1.ClusterType
-	Endpoints []net.IPNet
-	Domains   []string
-	Resolver  resolver.DnsResolver // for strict DNS
-	logger    logging.LoggerFactory
-	log       logging.LeveledLogger
+	Name                string
+	Type                v1alpha1.ClusterType
+	Endpoints           []net.IPNet
+	Domains             []string
+	AllowSensitivePeers bool
+	Tenant              string
+	Resolver            resolver.DnsResolver // for strict DNS
+	logger              logging.LoggerFactory
+	log                 logging.LeveledLogger
 }
 
 // NewCluster creates a new cluster. Requires a server restart (returns
@@ -75,6 +78,8 @@ func (c *Cluster) Reconcile(conf v1alpha1.Config) error {
 
 	c.log.Tracef("Reconcile: %#v", req)
 	c.Type, _ = v1alpha1.NewClusterType(req.Type)
+	c.AllowSensitivePeers = req.AllowSensitivePeers
+	c.Tenant = req.Tenant
 
 	switch c.Type {
 	case v1alpha1.ClusterTypeStatic:
@@ -104,7 +109,7 @@ func (c *Cluster) Reconcile(conf v1alpha1.Config) error {
 
 			_, n2, err := net.ParseCIDR(e)
 			if err != nil {
-				c.log.Warnf("cluster %q: could not convert endpoint %q to CIDR subnet ",
+				c.log.Warnf("cluster %q: could not convert endpoint %q to CIDR subnet "+
 					"(ignoring): %s", c.Name, e, err.Error())
 				continue
 			}
@@ -142,8 +147,10 @@ func (c *Cluster) ObjectName() string {
 // GetConfig returns the configuration of the running cluster
 func (c *Cluster) GetConfig() v1alpha1.Config {
 	conf := v1alpha1.ClusterConfig{
-		Name: c.Name,
-		Type: c.Type.String(),
+		Name:                c.Name,
+		Type:                c.Type.String(),
+		AllowSensitivePeers: c.AllowSensitivePeers,
+		Tenant:              c.Tenant,
 	}
 
 	switch c.Type {
@@ -160,6 +167,52 @@ func (c *Cluster) GetConfig() v1alpha1.Config {
 	return &conf
 }
 
+// Status returns the status of the cluster: a STRICT_DNS cluster is ready only if all its domains
+// have been resolved, and degraded if some of them have not
+func (c *Cluster) Status() ObjectStatus {
+	ready := Condition{Status: true, Reason: "AsExpected"}
+	degraded := Condition{Reason: "AsExpected"}
+
+	switch c.Type {
+	case v1alpha1.ClusterTypeStatic:
+		ready.Message = fmt.Sprintf("endpoints: %d", len(c.Endpoints))
+	case v1alpha1.ClusterTypeStrictDNS:
+		n, unresolved := 0, []string{}
+		for _, d := range c.Domains {
+			ips, err := c.Resolver.Lookup(d)
+			if err != nil || len(ips) == 0 {
+				unresolved = append(unresolved, d)
+			}
+			n += len(ips)
+		}
+		ready.Message = fmt.Sprintf("resolved addresses: %d", n)
+		if len(unresolved) > 0 {
+			msg := fmt.Sprintf("unresolved domains: %s", strings.Join(unresolved, ", "))
+			ready = Condition{Reason: "UnresolvedDomains", Message: msg}
+			if len(unresolved) < len(c.Domains) {
+				degraded = Condition{Status: true, Reason: "UnresolvedDomains", Message: msg}
+			}
+		}
+	}
+
+	return NewObjectStatus("cluster", c.Name, ready, degraded, Condition{Reason: "AsExpected"})
+}
+
+// FailingDomains returns the domains of a STRICT_DNS cluster whose resolution is failing, with the
+// time of the first failure
+func (c *Cluster) FailingDomains() map[string]time.Time {
+	ret := map[string]time.Time{}
+	if c.Type != v1alpha1.ClusterTypeStrictDNS {
+		return ret
+	}
+	for _, d := range c.Domains {
+		if st := c.Resolver.Status(d); !st.FailingSince.IsZero() {
+			ret[d] = st.FailingSince
+		}
+	}
+	return ret
+}
+
 // Close closes the cluster
 func (c *Cluster) Close() error {
 	c.log.Trace("closing cluster")
@@ -181,6 +234,12 @@ func (c *Cluster) Route(peer net.IP) bool {
 	c.log.Tracef("Route: cluster %q of type %s, peer IP: %s", c.Name, c.Type.String(),
 		peer.String())
 
+	if r, ok := SensitivePeer(peer); ok && !c.AllowSensitivePeers {
+		c.log.Debugf("cluster %q: peer %s is in the sensitive range %s, denied", c.Name,
+			peer.String(), r)
+		return false
+	}
+
 	switch c.Type {
 	case v1alpha1.ClusterTypeStatic:
 		// endpoints are IPNets
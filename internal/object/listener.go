@@ -4,11 +4,17 @@ import (
 	"fmt"
 	"net"
 	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/pion/logging"
 	"github.com/pion/transport/vnet"
 	"github.com/pion/turn/v2"
 
+	"github.com/l7mp/stunner/internal/lifetime"
+	"github.com/l7mp/stunner/internal/sockopt"
+	"github.com/l7mp/stunner/internal/strict"
 	"github.com/l7mp/stunner/internal/util"
 	"github.com/l7mp/stunner/pkg/apis/v1alpha1"
 )
@@ -19,9 +25,28 @@ type Listener struct {
 	Proto                  v1alpha1.ListenerProtocol
 	Addr                   net.IP
 	Port, MinPort, MaxPort int
+	PublicAddr             string
+	PublicPort             int
+	SDSSecret              string
 	Cert, Key, rawAddr     string      // net.IP.String() may rewrite the string representation
-	Conn                   interface{} // either turn.ListenerConfig or turn.PacketConnConfig
+	Conn                   interface{} // either turn.ListenerConfig or []turn.PacketConnConfig (one per worker)
 	Routes                 []string
+	AllowedSources         []string
+	DeniedSources          []string
+	OCSPStapling           bool
+	Tenant                 string
+	ClientCA, ClientCRL    string
+	BindToDevice           string
+	DSCP                   int
+	AlternateAddr          string
+	AlternatePort          int          // zero if the NAT behavior discovery is disabled
+	acl                    atomic.Value // *sourceACL, read by the listener sockets concurrently
+	strict                 atomic.Value // strict.Policy, read by the listener sockets concurrently
+	lifetimes              atomic.Value // lifetime.Policy, zero values inherit the gateway lifetimes
+	portLock               sync.RWMutex // relay generators read the port range concurrently
+	draining               int32        // atomic, set if the listener refuses new allocations
+	statusLock             sync.Mutex
+	restartPending         string // the reason if a change takes effect only after a restart
 	log                    logging.LeveledLogger
 	Net                    *vnet.Net
 }
@@ -78,16 +103,23 @@ func (l *Listener) Inspect(old, new v1alpha1.Config) bool {
 
 	proto, _ := v1alpha1.NewListenerProtocol(req.Protocol)
 
-	// the only chance we don't need a restart if only the Routes change
+	// a restart is needed only if the listener socket must be rebound: routes, the source ACLs,
+	// the STUN message checks and the relay port range are updated in place, and so are the TLS creds of TLS and WSS listeners (the server
+	// swaps the certificate on the running listener), but pion/dtls cannot change the
+	// certificate of a running DTLS listener. The OCSP stapling and client certificate
+	// settings are part of the TLS config of the listener socket, and the tenant is bound to the
+	// listener sockets, and so are the socket options and the alternate sockets
 	restart := true
 	if l.Name == req.Name && // name unchanged (should always be true)
 		l.Proto == proto && // protocol unchanged
 		l.rawAddr == req.Addr && // address unchanged
-		l.Port == req.Port && // ports unchanged
-		l.MinPort == req.MinRelayPort &&
-		l.MaxPort == req.MaxRelayPort &&
-		l.Cert == req.Cert && // TLS creds unchanged
-		l.Key == req.Key {
+		l.Port == req.Port && // port unchanged
+		(proto != v1alpha1.ListenerProtocolDTLS || (l.Cert == req.Cert && l.Key == req.Key &&
+			l.SDSSecret == req.SDSSecret)) &&
+		l.OCSPStapling == req.OCSPStapling && l.ClientCA == req.ClientCA &&
+		l.ClientCRL == req.ClientCRL && l.Tenant == req.Tenant &&
+		l.BindToDevice == req.BindToDevice && l.DSCP == req.DSCP &&
+		l.AlternateAddr == req.AlternateAddr && l.AlternatePort == req.AlternatePort {
 		restart = false
 	}
 
@@ -120,24 +152,159 @@ func (l *Listener) Reconcile(conf v1alpha1.Config) error {
 	l.Proto = proto
 	l.Addr = ipAddr
 	l.rawAddr = req.Addr
-	l.Port, l.MinPort, l.MaxPort = req.Port, req.MinRelayPort, req.MaxRelayPort
-	if proto == v1alpha1.ListenerProtocolTLS || proto == v1alpha1.ListenerProtocolDTLS {
+	l.Port = req.Port
+	l.portLock.Lock()
+	l.MinPort, l.MaxPort = req.MinRelayPort, req.MaxRelayPort
+	l.portLock.Unlock()
+	if proto == v1alpha1.ListenerProtocolTLS || proto == v1alpha1.ListenerProtocolDTLS ||
+		proto == v1alpha1.ListenerProtocolWSS {
 		l.Cert = req.Cert
 		l.Key = req.Key
+		l.SDSSecret = req.SDSSecret
+		l.ClientCA = req.ClientCA
+		l.ClientCRL = req.ClientCRL
 	}
+	l.OCSPStapling = req.OCSPStapling
+	l.Tenant = req.Tenant
+	l.BindToDevice = req.BindToDevice
+	l.DSCP = req.DSCP
+	l.AlternateAddr = req.AlternateAddr
+	l.AlternatePort = req.AlternatePort
+	l.PublicAddr = req.PublicAddr
+	l.PublicPort = req.PublicPort
 
 	l.Routes = make([]string, len(req.Routes))
 	copy(l.Routes, req.Routes)
 
+	// the source ACL is updated in place
+	acl, err := newSourceACL(req.AllowedSourceCIDRs, req.DeniedSourceCIDRs)
+	if err != nil {
+		return fmt.Errorf("listener %q: %s", l.Name, err.Error())
+	}
+	l.AllowedSources = append([]string(nil), req.AllowedSourceCIDRs...)
+	l.DeniedSources = append([]string(nil), req.DeniedSourceCIDRs...)
+	l.acl.Store(acl)
+
+	// and so are the STUN message checks
+	l.strict.Store(strict.Policy{
+		RequireFingerprint:  req.RequireFingerprint,
+		StrictAttributes:    req.StrictAttributes,
+		IndicationIntegrity: req.IndicationIntegrity,
+	})
+
+	// and the lifetimes
+	l.lifetimes.Store(lifetime.Policy{
+		Permission:    time.Duration(req.PermissionLifetime) * time.Second,
+		Channel:       time.Duration(req.ChannelLifetime) * time.Second,
+		MaxAllocation: time.Duration(req.MaxAllocationLifetime) * time.Second,
+	})
+
+	// an updated listener accepts allocations again
+	l.SetDraining(false)
+
 	return nil
 }
 
+// StrictPolicy returns the STUN message checks of the listener
+func (l *Listener) StrictPolicy() strict.Policy {
+	p, _ := l.strict.Load().(strict.Policy)
+	return p
+}
+
+// LifetimePolicy returns the lifetimes set for the listener, zero values mean the lifetimes of
+// the gateway
+func (l *Listener) LifetimePolicy() lifetime.Policy {
+	p, _ := l.lifetimes.Load().(lifetime.Policy)
+	return p
+}
+
+// SetDraining makes the listener refuse (or accept again) new allocations
+func (l *Listener) SetDraining(draining bool) {
+	var v int32
+	if draining {
+		v = 1
+	}
+	atomic.StoreInt32(&l.draining, v)
+}
+
+// IsDraining returns true if the listener refuses new allocations
+func (l *Listener) IsDraining() bool {
+	return atomic.LoadInt32(&l.draining) == 1
+}
+
+// RelayPortRange returns the current port range for the relay transports of the listener
+func (l *Listener) RelayPortRange() (int, int) {
+	l.portLock.RLock()
+	defer l.portLock.RUnlock()
+	return l.MinPort, l.MaxPort
+}
+
+// SocketOptions returns the socket options of the listener and the relay sockets
+func (l *Listener) SocketOptions() sockopt.Options {
+	return sockopt.Options{Device: l.BindToDevice, DSCP: l.DSCP}
+}
+
+// NewRelayAddressGenerator creates a relay address generator that allocates relay transports from
+// the port range of the listener current at the time of the allocation
+func (l *Listener) NewRelayAddressGenerator() turn.RelayAddressGenerator {
+	return &relayAddressGenerator{
+		listener: l,
+		base: turn.RelayAddressGeneratorPortRange{
+			RelayAddress: l.Addr,
+			Address:      l.Addr.String(),
+			Net:          l.Net,
+		},
+	}
+}
+
+type relayAddressGenerator struct {
+	listener *Listener
+	base     turn.RelayAddressGeneratorPortRange
+}
+
+// generator returns a copy of the base generator for the current port range
+func (g *relayAddressGenerator) generator() *turn.RelayAddressGeneratorPortRange {
+	r := g.base
+	min, max := g.listener.RelayPortRange()
+	r.MinPort, r.MaxPort = uint16(min), uint16(max)
+	return &r
+}
+
+func (g *relayAddressGenerator) Validate() error {
+	// initialize the defaults (net, random source) once in the base generator
+	min, max := g.listener.RelayPortRange()
+	g.base.MinPort, g.base.MaxPort = uint16(min), uint16(max)
+	return g.base.Validate()
+}
+
+func (g *relayAddressGenerator) AllocatePacketConn(network string, requestedPort int) (net.PacketConn, net.Addr, error) {
+	conn, addr, err := g.generator().AllocatePacketConn(network, requestedPort)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// the relay sockets inherit the socket options of the listener
+	if err := sockopt.Apply(conn, g.listener.SocketOptions()); err != nil {
+		conn.Close()
+		return nil, nil, fmt.Errorf("listener %q: %s", g.listener.Name, err.Error())
+	}
+
+	return conn, addr, nil
+}
+
+func (g *relayAddressGenerator) AllocateConn(network string, requestedPort int) (net.Conn, net.Addr, error) {
+	return g.generator().AllocateConn(network, requestedPort)
+}
+
 // String returns a short stable string representation of the listener, safe for applying as a key in a map
 func (l *Listener) String() string {
 	uri := fmt.Sprintf("%s://%s:%d[%d:%d]", l.Proto, l.Addr, l.Port, l.MinPort, l.MaxPort)
 	if l.Cert != "" && l.Key != "" {
 		uri += " (cert/key)"
 	}
+	if l.SDSSecret != "" {
+		uri += " (sds)"
+	}
 	return uri
 }
 
@@ -153,22 +320,83 @@ func (l *Listener) GetConfig() v1alpha1.Config {
 	sort.Strings(l.Routes)
 
 	c := &v1alpha1.ListenerConfig{
-		Name:         l.Name,
-		Protocol:     l.Proto.String(),
-		Addr:         l.rawAddr,
-		Port:         l.Port,
-		MinRelayPort: l.MinPort,
-		MaxRelayPort: l.MaxPort,
-		Cert:         l.Cert,
-		Key:          l.Key,
+		Name:          l.Name,
+		Protocol:      l.Proto.String(),
+		PublicAddr:    l.PublicAddr,
+		PublicPort:    l.PublicPort,
+		Addr:          l.rawAddr,
+		Port:          l.Port,
+		MinRelayPort:  l.MinPort,
+		MaxRelayPort:  l.MaxPort,
+		Cert:          l.Cert,
+		Key:           l.Key,
+		SDSSecret:     l.SDSSecret,
+		OCSPStapling:  l.OCSPStapling,
+		Tenant:        l.Tenant,
+		ClientCA:      l.ClientCA,
+		ClientCRL:     l.ClientCRL,
+		BindToDevice:  l.BindToDevice,
+		DSCP:          l.DSCP,
+		AlternateAddr: l.AlternateAddr,
+		AlternatePort: l.AlternatePort,
 	}
 
 	c.Routes = make([]string, len(l.Routes))
 	copy(c.Routes, l.Routes)
 
+	p := l.StrictPolicy()
+	c.RequireFingerprint = p.RequireFingerprint
+	c.StrictAttributes = p.StrictAttributes
+	c.IndicationIntegrity = p.IndicationIntegrity
+
+	lt := l.LifetimePolicy()
+	c.PermissionLifetime = int(lt.Permission / time.Second)
+	c.ChannelLifetime = int(lt.Channel / time.Second)
+	c.MaxAllocationLifetime = int(lt.MaxAllocation / time.Second)
+
+	if len(l.AllowedSources) > 0 {
+		c.AllowedSourceCIDRs = append([]string(nil), l.AllowedSources...)
+	}
+	if len(l.DeniedSources) > 0 {
+		c.DeniedSourceCIDRs = append([]string(nil), l.DeniedSources...)
+	}
+
 	return c
 }
 
+// SetRestartPending marks the listener as having a change that takes effect only after the next
+// server restart, an empty reason clears the mark
+func (l *Listener) SetRestartPending(reason string) {
+	l.statusLock.Lock()
+	defer l.statusLock.Unlock()
+	l.restartPending = reason
+}
+
+// Status returns the status of the listener: a listener is ready if it has been started, and
+// degraded while it is draining
+func (l *Listener) Status() ObjectStatus {
+	ready := Condition{Status: true, Reason: "AsExpected", Message: l.String()}
+	if l.Conn == nil {
+		ready = Condition{Reason: "NotStarted", Message: "not started"}
+	}
+
+	degraded := Condition{Reason: "AsExpected"}
+	if l.IsDraining() {
+		degraded = Condition{Status: true, Reason: "Draining",
+			Message: "listener refuses new allocations"}
+	}
+
+	restartPending := Condition{Reason: "AsExpected"}
+	l.statusLock.Lock()
+	if l.restartPending != "" {
+		restartPending = Condition{Status: true, Reason: "RestartRequired",
+			Message: l.restartPending}
+	}
+	l.statusLock.Unlock()
+
+	return NewObjectStatus("listener", l.Name, ready, degraded, restartPending)
+}
+
 // Close closes the listener, required a restart!
 func (l *Listener) Close() error {
 	l.log.Tracef("closing %s listener at %s", l.Proto.String(), l.Addr)
@@ -177,16 +405,19 @@ func (l *Listener) Close() error {
 	case v1alpha1.ListenerProtocolUDP:
 		if l.Conn != nil {
 			l.log.Tracef("closing %s packet socket at %s", l.Proto.String(), l.Addr)
-			conn, ok := l.Conn.(turn.PacketConnConfig)
+			conns, ok := l.Conn.([]turn.PacketConnConfig)
 			if !ok {
-				return fmt.Errorf("internal error: invalid conversion to turn.PacketConnConfig")
+				return fmt.Errorf("internal error: invalid conversion to []turn.PacketConnConfig")
 			}
 
-			if err := conn.PacketConn.Close(); err != nil && !util.IsClosedErr(err) {
-				return err
+			for _, conn := range conns {
+				if err := conn.PacketConn.Close(); err != nil && !util.IsClosedErr(err) {
+					return err
+				}
 			}
 		}
-	case v1alpha1.ListenerProtocolTCP, v1alpha1.ListenerProtocolTLS, v1alpha1.ListenerProtocolDTLS:
+	case v1alpha1.ListenerProtocolTCP, v1alpha1.ListenerProtocolTLS, v1alpha1.ListenerProtocolDTLS,
+		v1alpha1.ListenerProtocolWS, v1alpha1.ListenerProtocolWSS:
 		if l.Conn != nil {
 			l.log.Tracef("closing %s listener socket at %s", l.Proto.String(), l.Addr)
 			conn, ok := l.Conn.(turn.ListenerConfig)
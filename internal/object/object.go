@@ -14,6 +14,8 @@ type Object interface {
 	Reconcile(conf v1alpha1.Config) error
 	// GetConfig returns the configuration of the running authenticator
 	GetConfig() v1alpha1.Config
+	// Status returns the runtime status of the object
+	Status() ObjectStatus
 	// Close closes the object, may return ErrRestartRequired
 	Close() error
 }
@@ -0,0 +1,40 @@
+package object
+
+import (
+	"net"
+)
+
+// sensitiveRanges are the peer address ranges relaying is denied to unless the cluster explicitly
+// allows it: these let clients reach the services of the node or the control plane of the cloud
+// provider
+var sensitiveRanges = func() []*net.IPNet {
+	ret := []*net.IPNet{}
+	for _, c := range []string{
+		"0.0.0.0/8",          // this network
+		"127.0.0.0/8",        // loopback
+		"169.254.0.0/16",     // link-local, including the metadata service of most clouds
+		"224.0.0.0/4",        // multicast
+		"255.255.255.255/32", // broadcast
+		"100.100.100.200/32", // Alibaba Cloud metadata service
+		"168.63.129.16/32",   // Azure host services
+		"::/128",             // unspecified
+		"::1/128",            // loopback
+		"fe80::/10",          // link-local
+		"ff00::/8",           // multicast
+		"fd00:ec2::254/128",  // AWS metadata service
+	} {
+		_, n, _ := net.ParseCIDR(c)
+		ret = append(ret, n)
+	}
+	return ret
+}()
+
+// SensitivePeer returns the sensitive address range a peer IP address falls in, if any
+func SensitivePeer(ip net.IP) (string, bool) {
+	for _, n := range sensitiveRanges {
+		if n.Contains(ip) {
+			return n.String(), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,68 @@
+package object
+
+// ConditionType is the type of a status condition
+type ConditionType string
+
+const (
+	// ConditionReady is true if the object is fully operational
+	ConditionReady ConditionType = "Ready"
+	// ConditionDegraded is true if the object is operational but not all of its functions are
+	// available
+	ConditionDegraded ConditionType = "Degraded"
+	// ConditionRestartPending is true if a change to the object takes effect only after the next
+	// server restart
+	ConditionRestartPending ConditionType = "RestartPending"
+)
+
+// Condition is an aspect of the status of an object
+type Condition struct {
+	// Type is the type of the condition
+	Type ConditionType `json:"type"`
+	// Status is true if the condition holds
+	Status bool `json:"status"`
+	// Reason is a machine-readable CamelCase reason for the status of the condition
+	Reason string `json:"reason,omitempty"`
+	// Message is a human-readable description of the status of the condition
+	Message string `json:"message,omitempty"`
+}
+
+// ObjectStatus is the runtime status of a STUNner object
+type ObjectStatus struct {
+	// Kind is the kind of the object: "admin", "auth", "listener" or "cluster"
+	Kind string `json:"kind"`
+	// Name is the name of the object
+	Name string `json:"name"`
+	// Ready is true if the object is fully operational, same as the Ready condition
+	Ready bool `json:"ready"`
+	// Message is a human-readable description of the status, same as the message of the Ready
+	// condition
+	Message string `json:"message,omitempty"`
+	// Conditions are the Ready, Degraded and RestartPending conditions of the object
+	Conditions []Condition `json:"conditions"`
+}
+
+// NewObjectStatus creates the status of an object from the status of its Ready, Degraded and
+// RestartPending conditions, in this order
+func NewObjectStatus(kind, name string, ready, degraded, restartPending Condition) ObjectStatus {
+	ready.Type = ConditionReady
+	degraded.Type = ConditionDegraded
+	restartPending.Type = ConditionRestartPending
+
+	return ObjectStatus{
+		Kind:       kind,
+		Name:       name,
+		Ready:      ready.Status,
+		Message:    ready.Message,
+		Conditions: []Condition{ready, degraded, restartPending},
+	}
+}
+
+// Condition returns the condition of the given type
+func (s *ObjectStatus) Condition(t ConditionType) Condition {
+	for _, c := range s.Conditions {
+		if c.Type == t {
+			return c
+		}
+	}
+	return Condition{Type: t}
+}
@@ -0,0 +1,31 @@
+// Package pbstruct encodes the messages of the gRPC services of STUNner as google.protobuf.Struct
+// values via their JSON encoding, so that the services need no generated code.
+package pbstruct
+
+import (
+	"encoding/json"
+
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// Encode encodes a message into a protobuf Struct
+func Encode(v interface{}) (*structpb.Struct, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	m := map[string]interface{}{}
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return nil, err
+	}
+	return structpb.NewStruct(m)
+}
+
+// Decode decodes a message from a protobuf Struct
+func Decode(s *structpb.Struct, v interface{}) error {
+	raw, err := json.Marshal(s.AsMap())
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, v)
+}
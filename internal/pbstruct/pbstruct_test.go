@@ -0,0 +1,43 @@
+package pbstruct
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+type message struct {
+	Name    string            `json:"name"`
+	Port    int               `json:"port,omitempty"`
+	Enabled bool              `json:"enabled,omitempty"`
+	Routes  []string          `json:"routes,omitempty"`
+	Labels  map[string]string `json:"labels,omitempty"`
+	Nested  *message          `json:"nested,omitempty"`
+}
+
+func TestRoundTrip(t *testing.T) {
+	in := &message{Name: "udp", Port: 3478, Enabled: true, Routes: []string{"a", "b"},
+		Labels: map[string]string{"k": "v"}, Nested: &message{Name: "nested"}}
+
+	s, err := Encode(in)
+	assert.NoError(t, err, "encode")
+	assert.Equal(t, "udp", s.Fields["name"].GetStringValue(), "name")
+	assert.Equal(t, 3478.0, s.Fields["port"].GetNumberValue(), "port")
+	assert.NotContains(t, s.Fields["nested"].GetStructValue().Fields, "port", "omitempty")
+
+	out := &message{}
+	assert.NoError(t, Decode(s, out), "decode")
+	assert.Equal(t, in, out, "round trip")
+}
+
+func TestErrors(t *testing.T) {
+	_, err := Encode(make(chan int))
+	assert.Error(t, err, "cannot encode a channel")
+	_, err = Encode("not an object")
+	assert.Error(t, err, "cannot encode a non-object")
+
+	s, err := structpb.NewStruct(map[string]interface{}{"port": "not a number"})
+	assert.NoError(t, err, "struct")
+	assert.Error(t, Decode(s, &message{}), "type mismatch")
+}
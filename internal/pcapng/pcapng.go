@@ -0,0 +1,211 @@
+// Package pcapng writes packets to capture files in the pcapng format, see
+// https://www.ietf.org/archive/id/draft-ietf-opsawg-pcapng-01.html. The packets are recorded as raw
+// IP datagrams: the STUN/TURN messages captured on the listener sockets are wrapped into synthetic
+// IPv4 or IPv6 and UDP headers, so that Wireshark and similar tools decode them out of the box.
+package pcapng
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	blockTypeSHB = 0x0A0D0D0A
+	blockTypeIDB = 0x00000001
+	blockTypeEPB = 0x00000006
+
+	byteOrderMagic = 0x1A2B3C4D
+	// linkTypeRaw is LINKTYPE_RAW: raw IPv4 or IPv6 datagrams
+	linkTypeRaw = 101
+	snapLen     = 65535
+
+	optEndOfOpt = 0
+	optEPBFlags = 2
+
+	ipv4HeaderSize = 20
+	ipv6HeaderSize = 40
+	udpHeaderSize  = 8
+	protoUDP       = 17
+	ttl            = 64
+)
+
+// Direction is the direction of a packet relative to the capturing host
+type Direction uint32
+
+const (
+	// Inbound packets are received by the capturing host
+	Inbound Direction = 1
+	// Outbound packets are sent by the capturing host
+	Outbound Direction = 2
+)
+
+// Writer writes a pcapng capture file with a single section and a single interface
+type Writer struct {
+	w io.Writer
+}
+
+// NewWriter writes the section header and the interface description to w and returns a writer
+// for the packets
+func NewWriter(w io.Writer) (*Writer, error) {
+	shb := make([]byte, 28)
+	binary.LittleEndian.PutUint32(shb[0:], blockTypeSHB)
+	binary.LittleEndian.PutUint32(shb[4:], uint32(len(shb)))
+	binary.LittleEndian.PutUint32(shb[8:], byteOrderMagic)
+	binary.LittleEndian.PutUint16(shb[12:], 1) // major version
+	binary.LittleEndian.PutUint16(shb[14:], 0) // minor version
+	// section length: unspecified
+	binary.LittleEndian.PutUint64(shb[16:], 0xFFFFFFFFFFFFFFFF)
+	binary.LittleEndian.PutUint32(shb[24:], uint32(len(shb)))
+
+	idb := make([]byte, 20)
+	binary.LittleEndian.PutUint32(idb[0:], blockTypeIDB)
+	binary.LittleEndian.PutUint32(idb[4:], uint32(len(idb)))
+	binary.LittleEndian.PutUint16(idb[8:], linkTypeRaw)
+	binary.LittleEndian.PutUint32(idb[12:], snapLen)
+	binary.LittleEndian.PutUint32(idb[16:], uint32(len(idb)))
+
+	if _, err := w.Write(append(shb, idb...)); err != nil {
+		return nil, err
+	}
+	return &Writer{w: w}, nil
+}
+
+// HeaderSize is the number of bytes NewWriter writes
+const HeaderSize = 28 + 20
+
+// BlockSize returns the number of bytes WritePacket writes for a packet of n captured bytes
+func BlockSize(n int) int {
+	return 28 + pad(n) + 12 + 4
+}
+
+// WritePacket writes a packet with the given time and direction, data is the captured part of the
+// packet and origLen is the length of the packet on the wire
+func (w *Writer) WritePacket(t time.Time, dir Direction, data []byte, origLen int) error {
+	size := BlockSize(len(data))
+	b := make([]byte, size)
+	ts := uint64(t.UnixNano() / int64(time.Microsecond))
+
+	binary.LittleEndian.PutUint32(b[0:], blockTypeEPB)
+	binary.LittleEndian.PutUint32(b[4:], uint32(size))
+	binary.LittleEndian.PutUint32(b[8:], 0) // interface ID
+	binary.LittleEndian.PutUint32(b[12:], uint32(ts>>32))
+	binary.LittleEndian.PutUint32(b[16:], uint32(ts))
+	binary.LittleEndian.PutUint32(b[20:], uint32(len(data)))
+	binary.LittleEndian.PutUint32(b[24:], uint32(origLen))
+	copy(b[28:], data)
+
+	opts := b[28+pad(len(data)):]
+	binary.LittleEndian.PutUint16(opts[0:], optEPBFlags)
+	binary.LittleEndian.PutUint16(opts[2:], 4)
+	binary.LittleEndian.PutUint32(opts[4:], uint32(dir))
+	binary.LittleEndian.PutUint16(opts[8:], optEndOfOpt)
+	binary.LittleEndian.PutUint16(opts[10:], 0)
+	binary.LittleEndian.PutUint32(b[size-4:], uint32(size))
+
+	_, err := w.w.Write(b)
+	return err
+}
+
+func pad(n int) int {
+	return (n + 3) &^ 3
+}
+
+// UDPPacket wraps a payload into synthetic IP and UDP headers from src to dst. The result is an
+// IPv4 datagram if both addresses are IPv4 and an IPv6 datagram otherwise. Only the first snap
+// bytes of the payload are kept, a negative snap keeps the entire payload. The returned length is
+// the length of the untruncated datagram
+func UDPPacket(src, dst net.Addr, payload []byte, snap int) ([]byte, int) {
+	srcIP, srcPort := hostPort(src)
+	dstIP, dstPort := hostPort(dst)
+
+	udp := make([]byte, udpHeaderSize+len(payload))
+	binary.BigEndian.PutUint16(udp[0:], uint16(srcPort))
+	binary.BigEndian.PutUint16(udp[2:], uint16(dstPort))
+	binary.BigEndian.PutUint16(udp[4:], uint16(len(udp)))
+	copy(udp[udpHeaderSize:], payload)
+
+	var ip []byte
+	if src4, dst4 := srcIP.To4(), dstIP.To4(); src4 != nil && dst4 != nil {
+		ip = make([]byte, ipv4HeaderSize)
+		ip[0] = 0x45
+		binary.BigEndian.PutUint16(ip[2:], uint16(ipv4HeaderSize+len(udp)))
+		binary.BigEndian.PutUint16(ip[6:], 0x4000) // don't fragment
+		ip[8] = ttl
+		ip[9] = protoUDP
+		copy(ip[12:], src4)
+		copy(ip[16:], dst4)
+		binary.BigEndian.PutUint16(ip[10:], checksum(ip, 0))
+
+		pseudo := append(append(append([]byte{}, src4...), dst4...), 0, protoUDP, 0, 0)
+		binary.BigEndian.PutUint16(pseudo[10:], uint16(len(udp)))
+		binary.BigEndian.PutUint16(udp[6:], udpChecksum(pseudo, udp))
+	} else {
+		ip = make([]byte, ipv6HeaderSize)
+		ip[0] = 0x60
+		binary.BigEndian.PutUint16(ip[4:], uint16(len(udp)))
+		ip[6] = protoUDP
+		ip[7] = ttl
+		copy(ip[8:], srcIP.To16())
+		copy(ip[24:], dstIP.To16())
+
+		pseudo := make([]byte, 40)
+		copy(pseudo[0:], ip[8:40])
+		binary.BigEndian.PutUint32(pseudo[32:], uint32(len(udp)))
+		pseudo[39] = protoUDP
+		binary.BigEndian.PutUint16(udp[6:], udpChecksum(pseudo, udp))
+	}
+
+	pkt := append(ip, udp...)
+	origLen := len(pkt)
+	if snap >= 0 && snap < len(payload) {
+		pkt = pkt[:len(ip)+udpHeaderSize+snap]
+	}
+	return pkt, origLen
+}
+
+// hostPort returns the IP address and the port of a UDP or TCP address, or the unspecified IPv4
+// address and port zero for other addresses
+func hostPort(addr net.Addr) (net.IP, int) {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.IP, a.Port
+	case *net.TCPAddr:
+		return a.IP, a.Port
+	}
+	if addr != nil {
+		if host, port, err := net.SplitHostPort(addr.String()); err == nil {
+			if ip := net.ParseIP(host); ip != nil {
+				p, _ := strconv.Atoi(port)
+				return ip, p
+			}
+		}
+	}
+	return net.IPv4zero, 0
+}
+
+// checksum computes the Internet checksum (RFC 1071) of b, continuing from a partial sum
+func checksum(b []byte, sum uint32) uint16 {
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum > 0xffff {
+		sum = sum>>16 + sum&0xffff
+	}
+	return ^uint16(sum)
+}
+
+func udpChecksum(pseudo, udp []byte) uint16 {
+	sum := uint32(^checksum(pseudo, 0))
+	c := checksum(udp, sum)
+	if c == 0 {
+		// zero means no checksum in UDP
+		c = 0xffff
+	}
+	return c
+}
@@ -0,0 +1,110 @@
+package pcapng
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type block struct {
+	typ  uint32
+	body []byte
+}
+
+func readBlocks(t *testing.T, b []byte) []block {
+	ret := []block{}
+	for len(b) > 0 {
+		if !assert.GreaterOrEqual(t, len(b), 12, "block header") {
+			return ret
+		}
+		typ := binary.LittleEndian.Uint32(b[0:])
+		l := int(binary.LittleEndian.Uint32(b[4:]))
+		if !assert.True(t, l%4 == 0 && l <= len(b), "block length") {
+			return ret
+		}
+		assert.Equal(t, uint32(l), binary.LittleEndian.Uint32(b[l-4:]), "trailing block length")
+		ret = append(ret, block{typ: typ, body: b[8 : l-4]})
+		b = b[l:]
+	}
+	return ret
+}
+
+func TestPcapngWriter(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w, err := NewWriter(buf)
+	assert.NoError(t, err, "writer")
+	assert.Equal(t, HeaderSize, buf.Len(), "header size")
+
+	client := &net.UDPAddr{IP: net.ParseIP("1.2.3.4"), Port: 5678}
+	server := &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 3478}
+	payload := []byte("0123456789")
+
+	pkt, origLen := UDPPacket(client, server, payload, -1)
+	assert.Equal(t, ipv4HeaderSize+udpHeaderSize+len(payload), len(pkt), "packet length")
+	assert.Equal(t, len(pkt), origLen, "original length")
+	ts := time.Unix(1665491401, 123456000)
+	assert.NoError(t, w.WritePacket(ts, Inbound, pkt, origLen), "write")
+
+	trunc, truncLen := UDPPacket(server, client, payload, 4)
+	assert.Equal(t, ipv4HeaderSize+udpHeaderSize+4, len(trunc), "truncated length")
+	assert.Equal(t, origLen, truncLen, "original length of truncated packet")
+	assert.NoError(t, w.WritePacket(ts, Outbound, trunc, truncLen), "write")
+	assert.Equal(t, HeaderSize+BlockSize(len(pkt))+BlockSize(len(trunc)), buf.Len(), "file size")
+
+	blocks := readBlocks(t, buf.Bytes())
+	assert.Len(t, blocks, 4, "blocks")
+	if len(blocks) != 4 {
+		return
+	}
+	assert.Equal(t, uint32(blockTypeSHB), blocks[0].typ, "section header")
+	assert.Equal(t, uint32(byteOrderMagic), binary.LittleEndian.Uint32(blocks[0].body), "byte order")
+	assert.Equal(t, uint32(blockTypeIDB), blocks[1].typ, "interface description")
+	assert.Equal(t, uint16(linkTypeRaw), binary.LittleEndian.Uint16(blocks[1].body), "link type")
+
+	epb := blocks[2]
+	assert.Equal(t, uint32(blockTypeEPB), epb.typ, "packet")
+	us := uint64(binary.LittleEndian.Uint32(epb.body[4:]))<<32 |
+		uint64(binary.LittleEndian.Uint32(epb.body[8:]))
+	assert.Equal(t, uint64(ts.UnixNano()/1000), us, "timestamp")
+	capLen := int(binary.LittleEndian.Uint32(epb.body[12:]))
+	assert.Equal(t, len(pkt), capLen, "captured length")
+	data := epb.body[20 : 20+capLen]
+	assert.Equal(t, pkt, data, "packet data")
+	opts := epb.body[20+pad(capLen):]
+	assert.Equal(t, uint16(optEPBFlags), binary.LittleEndian.Uint16(opts), "flags option")
+	assert.Equal(t, uint32(Inbound), binary.LittleEndian.Uint32(opts[4:]), "direction")
+
+	// IPv4 and UDP headers
+	assert.Equal(t, uint16(0), checksum(data[:ipv4HeaderSize], 0), "IPv4 header checksum")
+	assert.Equal(t, client.IP.To4(), net.IP(data[12:16]), "source IP")
+	assert.Equal(t, server.IP.To4(), net.IP(data[16:20]), "destination IP")
+	assert.Equal(t, uint16(client.Port), binary.BigEndian.Uint16(data[20:]), "source port")
+	assert.Equal(t, uint16(server.Port), binary.BigEndian.Uint16(data[22:]), "destination port")
+	assert.Equal(t, payload, data[28:], "payload")
+
+	pseudo := append(append(append([]byte{}, data[12:20]...), 0, protoUDP), data[24:26]...)
+	assert.Equal(t, uint16(0), checksum(data[20:], uint32(^checksum(pseudo, 0))), "UDP checksum")
+
+	epb = blocks[3]
+	capLen = int(binary.LittleEndian.Uint32(epb.body[12:]))
+	assert.Equal(t, len(trunc), capLen, "truncated captured length")
+	assert.Equal(t, uint32(len(pkt)), binary.LittleEndian.Uint32(epb.body[16:]), "original length")
+	opts = epb.body[20+pad(capLen):]
+	assert.Equal(t, uint32(Outbound), binary.LittleEndian.Uint32(opts[4:]), "direction")
+}
+
+func TestPcapngIPv6(t *testing.T) {
+	client := &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 5678}
+	server := &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 3478}
+
+	pkt, _ := UDPPacket(client, server, []byte("hello"), -1)
+	assert.Equal(t, ipv6HeaderSize+udpHeaderSize+5, len(pkt), "packet length")
+	assert.Equal(t, byte(0x60), pkt[0], "IPv6")
+	assert.Equal(t, client.IP, net.IP(pkt[8:24]), "source IP")
+	assert.Equal(t, server.IP.To16(), net.IP(pkt[24:40]), "IPv4-mapped destination IP")
+	assert.Equal(t, uint16(udpHeaderSize+5), binary.BigEndian.Uint16(pkt[4:]), "payload length")
+}
@@ -0,0 +1,132 @@
+// Package peerport restricts the peer ports reachable via the relay transports. TURN permissions
+// are installed per peer IP address, so the peer port cannot be checked when a permission is
+// created: instead, the relay transports drop the packets sent to, or received from, the peer
+// ports outside the policy.
+package peerport
+
+import (
+	"net"
+	"sync/atomic"
+
+	"github.com/pion/logging"
+	"github.com/pion/turn/v2"
+
+	"github.com/l7mp/stunner/internal/monitoring"
+)
+
+// Range is an inclusive range of ports
+type Range struct {
+	Min, Max int
+}
+
+// Contains returns true if a port falls into the range
+func (r Range) Contains(port int) bool {
+	return port >= r.Min && port <= r.Max
+}
+
+// Policy sets the peer ports allowed: a port must fall into one of the allowed ranges, or there
+// is no allowed range, and into none of the denied ranges
+type Policy struct {
+	Allow, Deny []Range
+}
+
+// Allowed returns true if the policy allows a peer port
+func (p *Policy) Allowed(port int) bool {
+	for _, r := range p.Deny {
+		if r.Contains(port) {
+			return false
+		}
+	}
+	if len(p.Allow) == 0 {
+		return true
+	}
+	for _, r := range p.Allow {
+		if r.Contains(port) {
+			return true
+		}
+	}
+	return false
+}
+
+// Filter applies the current peer port policy to the relay transports
+type Filter struct {
+	policy atomic.Value // *Policy, nil if all ports are allowed
+	log    logging.LeveledLogger
+}
+
+// NewFilter creates a filter that allows all peer ports until a policy is set
+func NewFilter(logger logging.LoggerFactory) *Filter {
+	f := &Filter{log: logger.NewLogger("peerport")}
+	f.policy.Store((*Policy)(nil))
+	return f
+}
+
+// SetPolicy sets the peer port policy, nil allows all ports. The policy applies to the existing
+// relay transports too
+func (f *Filter) SetPolicy(p *Policy) {
+	f.policy.Store(p)
+}
+
+// Allowed returns true if a peer address is allowed by the current policy
+func (f *Filter) Allowed(addr net.Addr) bool {
+	p := f.policy.Load().(*Policy)
+	if p == nil {
+		return true
+	}
+	a, ok := addr.(*net.UDPAddr)
+	if !ok {
+		return true
+	}
+	return p.Allowed(a.Port)
+}
+
+// relayAddressGenerator wraps a TURN relay address generator so that the relay transports it
+// allocates filter the peer ports
+type relayAddressGenerator struct {
+	turn.RelayAddressGenerator
+	listener string
+	filter   *Filter
+}
+
+// NewRelayAddressGenerator wraps the relay address generator of a listener
+func (f *Filter) NewRelayAddressGenerator(gen turn.RelayAddressGenerator, listener string) turn.RelayAddressGenerator {
+	return &relayAddressGenerator{RelayAddressGenerator: gen, listener: listener, filter: f}
+}
+
+func (r *relayAddressGenerator) AllocatePacketConn(network string, requestedPort int) (net.PacketConn, net.Addr, error) {
+	conn, addr, err := r.RelayAddressGenerator.AllocatePacketConn(network, requestedPort)
+	if err != nil {
+		return conn, addr, err
+	}
+	return &relayConn{PacketConn: conn, listener: r.listener, filter: r.filter}, addr, nil
+}
+
+// relayConn is a relay transport that silently drops the packets to and from the peer ports not
+// allowed by the policy
+type relayConn struct {
+	net.PacketConn
+	listener string
+	filter   *Filter
+}
+
+func (c *relayConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if !c.filter.Allowed(addr) {
+		monitoring.PeerPortDeniedCounter.WithLabelValues(c.listener, "outbound").Inc()
+		c.filter.log.Tracef("dropping packet to peer %s on listener %s: peer port denied",
+			addr, c.listener)
+		return len(b), nil
+	}
+	return c.PacketConn.WriteTo(b, addr)
+}
+
+func (c *relayConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	for {
+		n, addr, err := c.PacketConn.ReadFrom(b)
+		if err != nil || c.filter.Allowed(addr) {
+			return n, addr, err
+		}
+		monitoring.PeerPortDeniedCounter.WithLabelValues(c.listener, "inbound").Inc()
+		c.filter.log.Tracef("dropping packet from peer %s on listener %s: peer port denied",
+			addr, c.listener)
+	}
+}
@@ -0,0 +1,77 @@
+package peerport
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pion/logging"
+	"github.com/pion/transport/vnet"
+	"github.com/pion/turn/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicy(t *testing.T) {
+	p := &Policy{}
+	assert.True(t, p.Allowed(22), "empty policy")
+
+	p = &Policy{Deny: []Range{{1, 1023}}}
+	assert.False(t, p.Allowed(22), "denied")
+	assert.False(t, p.Allowed(1023), "denied upper bound")
+	assert.True(t, p.Allowed(1024), "not denied")
+
+	p = &Policy{Allow: []Range{{10000, 60000}}, Deny: []Range{{20000, 20000}}}
+	assert.False(t, p.Allowed(5432), "not allowed")
+	assert.True(t, p.Allowed(10000), "allowed lower bound")
+	assert.True(t, p.Allowed(60000), "allowed upper bound")
+	assert.False(t, p.Allowed(20000), "deny takes precedence")
+	assert.False(t, p.Allowed(60001), "not allowed")
+}
+
+func TestRelayConn(t *testing.T) {
+	f := NewFilter(logging.NewDefaultLoggerFactory())
+	gen := f.NewRelayAddressGenerator(&turn.RelayAddressGeneratorStatic{
+		RelayAddress: net.ParseIP("127.0.0.1"),
+		Address:      "127.0.0.1",
+		Net:          vnet.NewNet(nil),
+	}, "udp")
+	relay, _, err := gen.AllocatePacketConn("udp4", 0)
+	assert.NoError(t, err, "allocate")
+	defer relay.Close()
+
+	peer, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	assert.NoError(t, err, "peer")
+	defer peer.Close()
+	port := peer.LocalAddr().(*net.UDPAddr).Port
+
+	send := func() bool {
+		_, err := relay.WriteTo([]byte("ping"), peer.LocalAddr())
+		assert.NoError(t, err, "write")
+		buf := make([]byte, 16)
+		peer.SetReadDeadline(time.Now().Add(50 * time.Millisecond)) //nolint:errcheck
+		_, _, err = peer.ReadFrom(buf)
+		return err == nil
+	}
+	receive := func() bool {
+		_, err := peer.WriteTo([]byte("pong"), relay.LocalAddr())
+		assert.NoError(t, err, "write")
+		buf := make([]byte, 16)
+		relay.SetReadDeadline(time.Now().Add(50 * time.Millisecond)) //nolint:errcheck
+		_, _, err = relay.ReadFrom(buf)
+		return err == nil
+	}
+
+	assert.True(t, send(), "no policy: send")
+	assert.True(t, receive(), "no policy: receive")
+
+	f.SetPolicy(&Policy{Deny: []Range{{port, port}}})
+	assert.False(t, send(), "denied: send")
+	assert.False(t, receive(), "denied: receive")
+
+	f.SetPolicy(&Policy{Allow: []Range{{port, port}}})
+	assert.True(t, send(), "allowed: send")
+	assert.True(t, receive(), "allowed: receive")
+
+	f.SetPolicy(nil)
+	assert.True(t, send(), "policy removed: send")
+}
@@ -0,0 +1,370 @@
+// Package plugin consults the policy plugin of the gateway on the allocation and permission
+// decisions. The engine calls the plugin, served over gRPC or installed in process, with a
+// timeout, falls back to the failure policy if the plugin fails, logs the annotations of the
+// decisions and keeps the last decisions for the admin API. The listener sockets ask the plugin
+// about the new authenticated Allocate requests, and reject the denied requests with a 403
+// (Forbidden) error.
+package plugin
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pion/logging"
+	"github.com/pion/stun"
+	"google.golang.org/grpc"
+
+	"github.com/l7mp/stunner/internal/monitoring"
+	"github.com/l7mp/stunner/internal/stunmsg"
+	"github.com/l7mp/stunner/pkg/policy"
+)
+
+// Failure policies
+const (
+	// FailureBuiltin keeps the decision of the built-in routing policy if the plugin fails
+	FailureBuiltin = "builtin"
+	// FailureDeny refuses the request if the plugin fails
+	FailureDeny = "deny"
+)
+
+// Origins of the decisions
+const (
+	// OriginPlugin is a decision of the plugin
+	OriginPlugin = "plugin"
+	// OriginBuiltin is a built-in decision kept by the plugin
+	OriginBuiltin = "builtin"
+	// OriginFailure is the decision of the failure policy
+	OriginFailure = "failure"
+)
+
+const (
+	// maxDecisions bounds the decision log
+	maxDecisions = 100
+)
+
+var (
+	allocateRequest = stun.NewType(stun.MethodAllocate, stun.ClassRequest).Value()
+	allocateError   = stun.NewType(stun.MethodAllocate, stun.ClassErrorResponse)
+)
+
+// Config sets the policy plugin
+type Config struct {
+	// Address is the gRPC address of the plugin, empty for the in-process plugin
+	Address string
+	// Hooks lists the kinds of decisions the plugin is consulted on
+	Hooks []string
+	// Timeout is the time a decision of the plugin is waited for
+	Timeout time.Duration
+	// FailurePolicy is FailureBuiltin or FailureDeny
+	FailurePolicy string
+}
+
+func (c *Config) hasHook(kind string) bool {
+	for _, h := range c.Hooks {
+		if h == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// Decision is an entry of the decision log
+type Decision struct {
+	// Time is the time of the decision
+	Time time.Time `json:"time"`
+	// Request is the request the decision was made on
+	Request *policy.Request `json:"request"`
+	// Decision is policy.Allow or policy.Deny
+	Decision string `json:"decision"`
+	// Origin is OriginPlugin, OriginBuiltin or OriginFailure
+	Origin string `json:"origin"`
+	// Reason is the reason given by the plugin
+	Reason string `json:"reason,omitempty"`
+	// Annotations are the annotations of the plugin
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// Error is the error of the plugin, for the decisions of the failure policy
+	Error string `json:"error,omitempty"`
+}
+
+// RequestFunc returns the request to ask the plugin about a new authenticated Allocate request of
+// a client, or nil if the plugin need not be consulted, e.g., for a retransmission
+type RequestFunc func(client net.Addr, username, realm string) *policy.Request
+
+// Engine consults the policy plugin
+type Engine struct {
+	lock      sync.RWMutex
+	conf      *Config
+	conn      *grpc.ClientConn
+	remote    policy.Plugin
+	local     policy.Plugin
+	decisions []Decision
+	next      int
+	log       logging.LeveledLogger
+}
+
+// NewEngine creates a policy engine, disabled until a config is set
+func NewEngine(logger logging.LoggerFactory) *Engine {
+	return &Engine{log: logger.NewLogger("policy")}
+}
+
+// SetConfig sets the config, nil disables the engine. The connection to the plugin is re-dialed if
+// the address changes
+func (e *Engine) SetConfig(conf *Config) error {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	addr := ""
+	if conf != nil {
+		addr = conf.Address
+	}
+	if e.conf == nil || e.conf.Address != addr {
+		e.closeConn()
+		if addr != "" {
+			// the connection is established in the background and re-established on failure
+			conn, err := grpc.Dial(addr, grpc.WithInsecure())
+			if err != nil {
+				e.conf = nil
+				return fmt.Errorf("cannot connect to policy plugin at %s: %w", addr, err)
+			}
+			e.conn, e.remote = conn, policy.NewClient(conn)
+			e.log.Infof("policy plugin at %s", addr)
+		}
+	}
+	e.conf = conf
+	return nil
+}
+
+// SetPlugin installs the in-process plugin, consulted if no plugin address is set
+func (e *Engine) SetPlugin(p policy.Plugin) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.local = p
+}
+
+// Close closes the connection to the plugin and disables the engine
+func (e *Engine) Close() {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.closeConn()
+	e.conf = nil
+}
+
+// closeConn closes the connection to the plugin, must be called with the lock held
+func (e *Engine) closeConn() {
+	if e.conn != nil {
+		_ = e.conn.Close()
+	}
+	e.conn, e.remote = nil, nil
+}
+
+// plugin returns the config and the plugin consulted on a kind of decisions, or a nil plugin if
+// the plugin is not consulted
+func (e *Engine) plugin(kind string) (*Config, policy.Plugin) {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	if e.conf == nil || !e.conf.hasHook(kind) {
+		return nil, nil
+	}
+	if e.conf.Address != "" {
+		return e.conf, e.remote
+	}
+	return e.conf, e.local
+}
+
+// Hooked returns true if the plugin is consulted on a kind of decisions
+func (e *Engine) Hooked(kind string) bool {
+	_, p := e.plugin(kind)
+	return p != nil
+}
+
+// Decide asks the plugin about a request carrying the built-in decision and returns true if the
+// request is allowed. The built-in decision is returned if the plugin is not consulted on the
+// kind of the request
+func (e *Engine) Decide(req *policy.Request) bool {
+	builtin := req.Decision == policy.Allow
+	conf, p := e.plugin(req.Kind)
+	if p == nil {
+		return builtin
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), conf.Timeout)
+	defer cancel()
+	resp, err := p.Decide(ctx, req)
+	if err == nil && resp == nil {
+		resp = &policy.Response{}
+	}
+	if err == nil && resp.Decision != "" && resp.Decision != policy.Allow &&
+		resp.Decision != policy.Deny {
+		err = fmt.Errorf("invalid decision %q", resp.Decision)
+	}
+
+	d := Decision{Time: time.Now(), Request: req, Decision: req.Decision, Origin: OriginBuiltin}
+	switch {
+	case err != nil:
+		d.Origin, d.Error = OriginFailure, err.Error()
+		if conf.FailurePolicy == FailureDeny {
+			d.Decision = policy.Deny
+		}
+		e.log.Warnf("policy plugin failed on %s request of client %s on listener %q, "+
+			"decision: %s: %s", req.Kind, req.Client, req.Listener, d.Decision, err.Error())
+	case resp.Decision != "":
+		d.Decision, d.Origin = resp.Decision, OriginPlugin
+		fallthrough
+	default:
+		d.Reason, d.Annotations = resp.Reason, resp.Annotations
+	}
+
+	if len(d.Annotations) > 0 || d.Decision != req.Decision {
+		e.log.Infof("policy plugin decision on %s request of client %s on listener %q: %s "+
+			"(built-in: %s, reason: %q, annotations: %s)", req.Kind, req.Client, req.Listener,
+			d.Decision, req.Decision, d.Reason, formatAnnotations(d.Annotations))
+	}
+	monitoring.PolicyDecisionCounter.WithLabelValues(req.Kind, d.Decision, d.Origin).Inc()
+	e.record(d)
+
+	return d.Decision == policy.Allow
+}
+
+// record adds a decision to the decision log
+func (e *Engine) record(d Decision) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	if len(e.decisions) < maxDecisions {
+		e.decisions = append(e.decisions, d)
+		return
+	}
+	e.decisions[e.next] = d
+	e.next = (e.next + 1) % maxDecisions
+}
+
+// Decisions returns the decision log, oldest first
+func (e *Engine) Decisions() []Decision {
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+	ret := make([]Decision, 0, len(e.decisions))
+	ret = append(ret, e.decisions[e.next:]...)
+	return append(ret, e.decisions[:e.next]...)
+}
+
+func formatAnnotations(a map[string]string) string {
+	keys := make([]string, 0, len(a))
+	for k := range a {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	kv := make([]string, 0, len(keys))
+	for _, k := range keys {
+		kv = append(kv, k+"="+a[k])
+	}
+	return "{" + strings.Join(kv, ",") + "}"
+}
+
+// reject checks a message, with the header h, received from a client and returns the error
+// response to send back if the message is a new authenticated Allocate request denied by the plugin
+func (e *Engine) reject(b []byte, h stunmsg.Header, client net.Addr, key stunmsg.KeyFunc,
+	request RequestFunc) ([]byte, bool) {
+	if !h.Is(allocateRequest) || !e.Hooked(policy.KindAllocation) {
+		return nil, false
+	}
+
+	// the first, unauthenticated Allocate request is challenged as usual, so that the
+	// rejections can be authenticated with the key of the user
+	m := &stun.Message{Raw: append([]byte(nil), b...)}
+	if err := m.Decode(); err != nil {
+		return nil, false
+	}
+	var username stun.Username
+	var realm stun.Realm
+	if !m.Contains(stun.AttrMessageIntegrity) || username.GetFrom(m) != nil ||
+		realm.GetFrom(m) != nil {
+		return nil, false
+	}
+	k, ok := key(username.String(), realm.String(), client)
+	if !ok || stun.MessageIntegrity(k).Check(m) != nil {
+		return nil, false
+	}
+
+	req := request(client, username.String(), realm.String())
+	if req == nil || e.Decide(req) {
+		return nil, false
+	}
+
+	e.log.Debugf("rejecting Allocate request from %s on listener %q: denied by the policy plugin",
+		client, req.Listener)
+	res, err := stun.Build(stun.NewTransactionIDSetter(m.TransactionID), allocateError,
+		stun.CodeForbidden, stun.MessageIntegrity(k), stun.Fingerprint)
+	if err != nil {
+		e.log.Warnf("cannot reject client %s: %s", client, err.Error())
+		return nil, false
+	}
+	return res.Raw, true
+}
+
+// NewPacketConn wraps the socket of a packet listener so that the new authenticated Allocate
+// requests denied by the plugin are answered with an error response and dropped before reaching
+// the TURN server
+func (e *Engine) NewPacketConn(conn net.PacketConn, key stunmsg.KeyFunc, request RequestFunc) net.PacketConn {
+	return &packetConn{PacketConn: conn, engine: e, key: key, request: request}
+}
+
+type packetConn struct {
+	net.PacketConn
+	engine  *Engine
+	key     stunmsg.KeyFunc
+	request RequestFunc
+}
+
+func (c *packetConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, _, err := c.ReadMessage(b)
+	return n, addr, err
+}
+
+func (c *packetConn) ReadMessage(b []byte) (int, net.Addr, stunmsg.Header, error) {
+	for {
+		n, addr, h, err := stunmsg.ReadMessage(c.PacketConn, b)
+		if err != nil {
+			return n, addr, h, err
+		}
+		res, ok := c.engine.reject(b[:n], h, addr, c.key, c.request)
+		if !ok {
+			return n, addr, h, err
+		}
+		if _, err := c.PacketConn.WriteTo(res, addr); err != nil {
+			c.engine.log.Debugf("cannot send error response to %s: %s", addr, err.Error())
+		}
+	}
+}
+
+// NewListener wraps the socket of a stream listener so that the new authenticated Allocate
+// requests denied by the plugin are answered with an error response and dropped before reaching
+// the TURN server
+func (e *Engine) NewListener(ln net.Listener, key stunmsg.KeyFunc, request RequestFunc) net.Listener {
+	return &streamListener{Listener: ln, engine: e, key: key, request: request}
+}
+
+type streamListener struct {
+	net.Listener
+	engine  *Engine
+	key     stunmsg.KeyFunc
+	request RequestFunc
+}
+
+func (l *streamListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return conn, err
+	}
+	client := conn.RemoteAddr()
+	return stunmsg.NewStreamConn(conn, func(b []byte, h stunmsg.Header) ([]byte, []byte) {
+		if res, ok := l.engine.reject(b, h, client, l.key, l.request); ok {
+			// drop the request
+			return nil, res
+		}
+		return b, nil
+	}), nil
+}
@@ -0,0 +1,207 @@
+package plugin
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pion/logging"
+	"github.com/pion/stun"
+	"github.com/pion/turn/v2"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+
+	"github.com/l7mp/stunner/pkg/policy"
+)
+
+// testPlugin denies the peers of the "deny" cluster, fails for the "fail" listener and keeps the
+// built-in decision otherwise
+var testPlugin = policy.PluginFunc(func(ctx context.Context, req *policy.Request) (*policy.Response, error) {
+	switch {
+	case req.Listener == "fail":
+		return nil, errors.New("plugin failure")
+	case req.Listener == "slow":
+		<-ctx.Done()
+		return nil, ctx.Err()
+	case req.Listener == "invalid":
+		return &policy.Response{Decision: "maybe"}, nil
+	case len(req.Clusters) > 0 && req.Clusters[0] == "deny":
+		return &policy.Response{Decision: policy.Deny, Reason: "denied cluster",
+			Annotations: map[string]string{"site": "a"}}, nil
+	case len(req.Clusters) > 0 && req.Clusters[0] == "allow":
+		return &policy.Response{Decision: policy.Allow}, nil
+	}
+	return nil, nil
+})
+
+func TestDecide(t *testing.T) {
+	e := NewEngine(logging.NewDefaultLoggerFactory())
+	req := func(listener, cluster, decision string) *policy.Request {
+		return &policy.Request{Kind: policy.KindPermission, Listener: listener,
+			Client: "1.2.3.4:5678", Peer: "10.0.0.1", Clusters: []string{cluster}, Decision: decision}
+	}
+
+	// disabled
+	e.SetPlugin(testPlugin)
+	assert.False(t, e.Hooked(policy.KindPermission), "disabled")
+	assert.True(t, e.Decide(req("udp", "deny", policy.Allow)), "built-in decision")
+
+	assert.NoError(t, e.SetConfig(&Config{Hooks: []string{policy.KindPermission},
+		Timeout: 50 * time.Millisecond, FailurePolicy: FailureBuiltin}), "config")
+	assert.True(t, e.Hooked(policy.KindPermission), "permission hook")
+	assert.False(t, e.Hooked(policy.KindAllocation), "no allocation hook")
+
+	assert.False(t, e.Decide(req("udp", "deny", policy.Allow)), "plugin deny")
+	assert.True(t, e.Decide(req("udp", "allow", policy.Deny)), "plugin allow")
+	assert.True(t, e.Decide(req("udp", "other", policy.Allow)), "built-in allow kept")
+	assert.False(t, e.Decide(req("udp", "other", policy.Deny)), "built-in deny kept")
+	assert.True(t, e.Decide(req("fail", "other", policy.Allow)), "failure: built-in")
+	assert.True(t, e.Decide(req("invalid", "other", policy.Allow)), "invalid: built-in")
+	start := time.Now()
+	assert.True(t, e.Decide(req("slow", "other", policy.Allow)), "timeout: built-in")
+	assert.Less(t, int64(time.Since(start)), int64(time.Second), "timeout")
+
+	d := e.Decisions()
+	assert.Len(t, d, 7, "decision log")
+	assert.Equal(t, policy.Deny, d[0].Decision, "decision")
+	assert.Equal(t, OriginPlugin, d[0].Origin, "origin")
+	assert.Equal(t, "denied cluster", d[0].Reason, "reason")
+	assert.Equal(t, map[string]string{"site": "a"}, d[0].Annotations, "annotations")
+	assert.Equal(t, OriginBuiltin, d[2].Origin, "origin")
+	assert.Equal(t, OriginFailure, d[4].Origin, "origin")
+	assert.Equal(t, "plugin failure", d[4].Error, "error")
+	assert.Equal(t, OriginFailure, d[5].Origin, "invalid decision")
+
+	assert.NoError(t, e.SetConfig(&Config{Hooks: []string{policy.KindPermission},
+		Timeout: 50 * time.Millisecond, FailurePolicy: FailureDeny}), "config")
+	assert.False(t, e.Decide(req("fail", "other", policy.Allow)), "failure: deny")
+
+	// the decision log keeps the last decisions
+	for i := 0; i < maxDecisions; i++ {
+		e.Decide(req(fmt.Sprintf("udp-%d", i), "other", policy.Allow))
+	}
+	d = e.Decisions()
+	assert.Len(t, d, maxDecisions, "decision log")
+	assert.Equal(t, "udp-0", d[0].Request.Listener, "oldest")
+	assert.Equal(t, fmt.Sprintf("udp-%d", maxDecisions-1), d[maxDecisions-1].Request.Listener, "newest")
+
+	e.Close()
+	assert.False(t, e.Hooked(policy.KindPermission), "closed")
+}
+
+func TestGRPCPlugin(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err, "listen")
+	srv := grpc.NewServer()
+	policy.RegisterServer(srv, testPlugin)
+	go srv.Serve(ln) //nolint:errcheck
+	defer srv.Stop()
+
+	e := NewEngine(logging.NewDefaultLoggerFactory())
+	defer e.Close()
+	assert.NoError(t, e.SetConfig(&Config{Address: ln.Addr().String(),
+		Hooks:   []string{policy.KindAllocation, policy.KindPermission},
+		Timeout: 2 * time.Second, FailurePolicy: FailureBuiltin}), "config")
+
+	req := &policy.Request{Kind: policy.KindAllocation, Listener: "udp", Client: "1.2.3.4:5678",
+		Clusters: []string{"deny"}, Decision: policy.Allow}
+	assert.False(t, e.Decide(req), "plugin deny")
+	d := e.Decisions()
+	assert.Len(t, d, 1, "decision log")
+	assert.Equal(t, OriginPlugin, d[0].Origin, "origin")
+	assert.Equal(t, map[string]string{"site": "a"}, d[0].Annotations, "annotations")
+
+	req.Clusters = []string{"other"}
+	assert.True(t, e.Decide(req), "built-in decision kept")
+	req.Listener = "fail"
+	assert.True(t, e.Decide(req), "failure")
+	assert.Equal(t, OriginFailure, e.Decisions()[2].Origin, "origin")
+
+	// the in-process plugin is not consulted if an address is set
+	e.SetPlugin(policy.PluginFunc(func(context.Context, *policy.Request) (*policy.Response, error) {
+		return &policy.Response{Decision: policy.Deny}, nil
+	}))
+	req.Listener = "udp"
+	assert.True(t, e.Decide(req), "remote plugin")
+}
+
+func TestPacketConn(t *testing.T) {
+	e := NewEngine(logging.NewDefaultLoggerFactory())
+	e.SetPlugin(testPlugin)
+	assert.NoError(t, e.SetConfig(&Config{Hooks: []string{policy.KindAllocation},
+		Timeout: time.Second, FailurePolicy: FailureBuiltin}), "config")
+
+	key := turn.GenerateAuthKey("user1", "realm", "passwd1")
+	keyFunc := func(username, realm string, _ net.Addr) ([]byte, bool) {
+		return key, username == "user1"
+	}
+	cluster := "deny"
+	request := func(client net.Addr, username, realm string) *policy.Request {
+		assert.Equal(t, "user1", username, "username")
+		assert.Equal(t, "realm", realm, "realm")
+		return &policy.Request{Kind: policy.KindAllocation, Listener: "udp",
+			Client: client.String(), Clusters: []string{cluster}, Decision: policy.Allow}
+	}
+
+	server, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	assert.NoError(t, err, "listen")
+	conn := e.NewPacketConn(server, keyFunc, request)
+	defer conn.Close()
+	client, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	assert.NoError(t, err, "client")
+	defer client.Close()
+
+	allocate := func(auth bool) []byte {
+		setters := []stun.Setter{stun.TransactionID, stun.NewType(stun.MethodAllocate, stun.ClassRequest)}
+		if auth {
+			setters = append(setters, stun.NewUsername("user1"), stun.NewRealm("realm"),
+				stun.NewNonce("nonce"), stun.MessageIntegrity(key))
+		}
+		m, err := stun.Build(append(setters, stun.Fingerprint)...)
+		assert.NoError(t, err, "build")
+		return m.Raw
+	}
+	buf := make([]byte, 1500)
+	assert.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)), "deadline")
+	assert.NoError(t, client.SetReadDeadline(time.Now().Add(2*time.Second)), "deadline")
+
+	// unauthenticated requests are challenged by the server as usual
+	req := allocate(false)
+	_, err = client.WriteTo(req, server.LocalAddr())
+	assert.NoError(t, err, "write")
+	n, _, err := conn.ReadFrom(buf)
+	assert.NoError(t, err, "read")
+	assert.Equal(t, req, buf[:n], "unauthenticated request")
+
+	// authenticated requests denied by the plugin are rejected
+	_, err = client.WriteTo(allocate(true), server.LocalAddr())
+	assert.NoError(t, err, "write")
+	req = allocate(false)
+	_, err = client.WriteTo(req, server.LocalAddr())
+	assert.NoError(t, err, "write")
+	n, _, err = conn.ReadFrom(buf)
+	assert.NoError(t, err, "read")
+	assert.Equal(t, req, buf[:n], "denied request dropped")
+
+	n, _, err = client.ReadFrom(buf)
+	assert.NoError(t, err, "rejection")
+	res := &stun.Message{Raw: buf[:n]}
+	assert.NoError(t, res.Decode(), "decode")
+	assert.Equal(t, stun.ClassErrorResponse, res.Type.Class, "error response")
+	var code stun.ErrorCodeAttribute
+	assert.NoError(t, code.GetFrom(res), "error code")
+	assert.Equal(t, stun.CodeForbidden, code.Code, "forbidden")
+	assert.NoError(t, stun.MessageIntegrity(key).Check(res), "integrity")
+
+	// allowed requests are passed on
+	cluster = "other"
+	req = allocate(true)
+	_, err = client.WriteTo(req, server.LocalAddr())
+	assert.NoError(t, err, "write")
+	n, _, err = conn.ReadFrom(buf)
+	assert.NoError(t, err, "read")
+	assert.Equal(t, req, buf[:n], "allowed request")
+}
@@ -0,0 +1,174 @@
+// Package probe measures the round-trip time toward an endpoint with lightweight probes: ICMP
+// echo requests, UDP echo datagrams or TCP connects.
+package probe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// Protocols of the probes
+const (
+	// ICMP sends an ICMP (or ICMPv6) echo request over an unprivileged ICMP socket
+	ICMP = "icmp"
+	// UDP sends a datagram to the echo port of the endpoint: the echoed datagram or an ICMP
+	// port unreachable error is taken as the response
+	UDP = "udp"
+	// TCP connects to the port of the endpoint: an accepted or a refused connection is taken as
+	// the response
+	TCP = "tcp"
+)
+
+// payload is sent in UDP and ICMP probes
+var payload = []byte("stunner-probe")
+
+// Prober sends probes toward endpoints
+type Prober struct {
+	protocol string
+	port     int
+	timeout  time.Duration
+}
+
+// NewProber creates a prober with the given protocol, port and timeout. The port is ignored for
+// ICMP probes
+func NewProber(protocol string, port int, timeout time.Duration) (*Prober, error) {
+	switch protocol {
+	case ICMP, UDP, TCP:
+	default:
+		return nil, fmt.Errorf("unknown probe protocol %q", protocol)
+	}
+	return &Prober{protocol: protocol, port: port, timeout: timeout}, nil
+}
+
+// Check returns an error if the probes cannot be sent, e.g., if unprivileged ICMP sockets are not
+// permitted for the process by the net.ipv4.ping_group_range sysctl
+func (p *Prober) Check() error {
+	if p.protocol != ICMP {
+		return nil
+	}
+	conn, err := icmp.ListenPacket("udp4", "")
+	if err != nil {
+		return fmt.Errorf("cannot open ICMP socket: %w", err)
+	}
+	return conn.Close()
+}
+
+// Probe sends a probe to an endpoint and returns the round-trip time, or an error if no response
+// was received within the timeout
+func (p *Prober) Probe(ctx context.Context, ip net.IP) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	switch p.protocol {
+	case TCP:
+		return p.probeTCP(ctx, ip)
+	case UDP:
+		return p.probeUDP(ctx, ip)
+	default:
+		return p.probeICMP(ctx, ip)
+	}
+}
+
+func (p *Prober) probeTCP(ctx context.Context, ip net.IP) (time.Duration, error) {
+	d := net.Dialer{}
+	start := time.Now()
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(ip.String(), strconv.Itoa(p.port)))
+	rtt := time.Since(start)
+	if err != nil {
+		// a reset is a response too
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			return rtt, nil
+		}
+		return 0, err
+	}
+	conn.Close()
+	return rtt, nil
+}
+
+func (p *Prober) probeUDP(ctx context.Context, ip net.IP) (time.Duration, error) {
+	d := net.Dialer{}
+	conn, err := d.DialContext(ctx, "udp", net.JoinHostPort(ip.String(), strconv.Itoa(p.port)))
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline) //nolint:errcheck
+	}
+
+	start := time.Now()
+	if _, err := conn.Write(payload); err != nil {
+		return 0, err
+	}
+	buf := make([]byte, 1500)
+	if _, err := conn.Read(buf); err != nil {
+		// the port unreachable error sent back by the endpoint is a response too
+		if errors.Is(err, syscall.ECONNREFUSED) {
+			return time.Since(start), nil
+		}
+		return 0, err
+	}
+	return time.Since(start), nil
+}
+
+func (p *Prober) probeICMP(ctx context.Context, ip net.IP) (time.Duration, error) {
+	network, proto := "udp4", 1
+	var typ, reply icmp.Type = ipv4.ICMPTypeEcho, ipv4.ICMPTypeEchoReply
+	if ip.To4() == nil {
+		network, proto = "udp6", 58
+		typ, reply = ipv6.ICMPTypeEchoRequest, ipv6.ICMPTypeEchoReply
+	}
+
+	conn, err := icmp.ListenPacket(network, "")
+	if err != nil {
+		return 0, fmt.Errorf("cannot open ICMP socket: %w", err)
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline) //nolint:errcheck
+	}
+
+	// the kernel rewrites the ID to the local port of unprivileged ICMP sockets
+	seq := int(time.Now().UnixNano() & 0xffff)
+	msg := icmp.Message{Type: typ, Body: &icmp.Echo{ID: os.Getpid() & 0xffff, Seq: seq,
+		Data: payload}}
+	b, err := msg.Marshal(nil)
+	if err != nil {
+		return 0, err
+	}
+
+	start := time.Now()
+	if _, err := conn.WriteTo(b, &net.UDPAddr{IP: ip}); err != nil {
+		return 0, err
+	}
+
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			return 0, err
+		}
+		if a, ok := from.(*net.UDPAddr); !ok || !a.IP.Equal(ip) {
+			continue
+		}
+		m, err := icmp.ParseMessage(proto, buf[:n])
+		if err != nil || m.Type != reply {
+			continue
+		}
+		if echo, ok := m.Body.(*icmp.Echo); ok && echo.Seq == seq {
+			return time.Since(start), nil
+		}
+	}
+}
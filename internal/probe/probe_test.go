@@ -0,0 +1,89 @@
+package probe
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var loopback = net.ParseIP("127.0.0.1")
+
+func TestProbeUDPEcho(t *testing.T) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	assert.NoError(t, err, "echo server")
+	defer conn.Close()
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteTo(buf[:n], addr) //nolint:errcheck
+		}
+	}()
+
+	p, err := NewProber(UDP, conn.LocalAddr().(*net.UDPAddr).Port, time.Second)
+	assert.NoError(t, err, "prober")
+	rtt, err := p.Probe(context.Background(), loopback)
+	assert.NoError(t, err, "probe")
+	assert.True(t, rtt > 0, "rtt")
+}
+
+func TestProbeUDPPortUnreachable(t *testing.T) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	assert.NoError(t, err, "socket")
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+	conn.Close()
+
+	p, err := NewProber(UDP, port, time.Second)
+	assert.NoError(t, err, "prober")
+	_, err = p.Probe(context.Background(), loopback)
+	assert.NoError(t, err, "port unreachable is a response")
+}
+
+func TestProbeUDPTimeout(t *testing.T) {
+	// a silent endpoint
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	assert.NoError(t, err, "socket")
+	defer conn.Close()
+
+	p, err := NewProber(UDP, conn.LocalAddr().(*net.UDPAddr).Port, 50*time.Millisecond)
+	assert.NoError(t, err, "prober")
+	_, err = p.Probe(context.Background(), loopback)
+	assert.Error(t, err, "lost")
+}
+
+func TestProbeTCP(t *testing.T) {
+	l, err := net.Listen("tcp4", "127.0.0.1:0")
+	assert.NoError(t, err, "listener")
+	port := l.Addr().(*net.TCPAddr).Port
+
+	p, err := NewProber(TCP, port, time.Second)
+	assert.NoError(t, err, "prober")
+	_, err = p.Probe(context.Background(), loopback)
+	assert.NoError(t, err, "connect")
+
+	// a refused connection is a response too
+	l.Close()
+	_, err = p.Probe(context.Background(), loopback)
+	assert.NoError(t, err, "refused")
+}
+
+func TestProbeICMP(t *testing.T) {
+	p, err := NewProber(ICMP, 0, time.Second)
+	assert.NoError(t, err, "prober")
+	if err := p.Check(); err != nil {
+		t.Skipf("ICMP sockets not permitted: %s", err.Error())
+	}
+	_, err = p.Probe(context.Background(), loopback)
+	assert.NoError(t, err, "echo")
+}
+
+func TestProbeUnknownProtocol(t *testing.T) {
+	_, err := NewProber("sctp", 7, time.Second)
+	assert.Error(t, err, "unknown protocol")
+}
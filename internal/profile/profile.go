@@ -0,0 +1,66 @@
+// Package profile defines the resource ceilings of the resource profiles of the gateway. The
+// default profile sets no ceilings. The low-memory profile is meant for single-board computers
+// and other edge devices with 512 MB RAM or less: it caps the buffer pools, the UDP worker
+// counts, the retention of the rolling metrics, the in-memory audit trail and the number of
+// allocations, makes the garbage collector run more often and sets the soft memory limit of the
+// runtime to MemoryCeiling, so that the memory use of the gateway stays below the ceiling.
+package profile
+
+import (
+	"time"
+
+	"github.com/l7mp/stunner/pkg/apis/v1alpha1"
+)
+
+// Limits are the resource ceilings of a profile, zero means no ceiling
+type Limits struct {
+	// UDPThreadNum is the number of worker threads per UDP listener
+	UDPThreadNum int
+	// UDPBatchSize is the number of datagrams read or written per syscall with batched UDP I/O
+	UDPBatchSize int
+	// MaxPooledBufferSize is the size of the largest buffer kept in the buffer pool, larger
+	// buffers are allocated from the heap and freed once used
+	MaxPooledBufferSize int
+	// SLORetention is the longest rolling window the success ratios are computed over
+	SLORetention time.Duration
+	// AuditHistoryLen is the number of audit records kept in memory for the admin API
+	AuditHistoryLen int
+	// MaxAllocations is the number of concurrent allocations on the gateway
+	MaxAllocations int
+	// GCPercent is the garbage collection target percentage (see debug.SetGCPercent)
+	GCPercent int
+	// MemoryCeiling is the memory use of the gateway in bytes the ceilings are sized for, set as
+	// the soft memory limit of the runtime (see debug.SetMemoryLimit)
+	MemoryCeiling int64
+}
+
+// LowMemory are the ceilings of the low-memory profile. An allocation takes about 64 KB on the
+// heap (the relay socket, the read loop and its buffers, the permissions and channel bindings of
+// a typical client), so 1000 allocations fit into 64 MB, the rest of the ceiling is left for the
+// runtime, the listener sockets, the pooled buffers and the control plane
+var LowMemory = Limits{
+	UDPThreadNum:        1,
+	UDPBatchSize:        8,
+	MaxPooledBufferSize: 1600,
+	SLORetention:        time.Hour,
+	AuditHistoryLen:     128,
+	MaxAllocations:      1000,
+	GCPercent:           50,
+	MemoryCeiling:       128 << 20,
+}
+
+// Get returns the ceilings of a resource profile
+func Get(p v1alpha1.ResourceProfile) Limits {
+	if p == v1alpha1.ResourceProfileLowMemory {
+		return LowMemory
+	}
+	return Limits{}
+}
+
+// Cap returns the value capped at the ceiling, zero values mean no limit for both
+func Cap(value, ceiling int) int {
+	if ceiling > 0 && (value <= 0 || value > ceiling) {
+		return ceiling
+	}
+	return value
+}
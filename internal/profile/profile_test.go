@@ -0,0 +1,27 @@
+package profile
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/l7mp/stunner/pkg/apis/v1alpha1"
+)
+
+func TestGet(t *testing.T) {
+	assert.Equal(t, Limits{}, Get(v1alpha1.ResourceProfileDefault), "default")
+	assert.Equal(t, LowMemory, Get(v1alpha1.ResourceProfileLowMemory), "low-memory")
+
+	// the ceilings fit into the memory of the devices the profile is meant for
+	assert.LessOrEqual(t, LowMemory.MemoryCeiling, int64(512<<20), "memory ceiling")
+	assert.LessOrEqual(t, int64(LowMemory.MaxAllocations)*64<<10, LowMemory.MemoryCeiling,
+		"allocations")
+}
+
+func TestCap(t *testing.T) {
+	assert.Equal(t, 4, Cap(4, 0), "no ceiling")
+	assert.Equal(t, 0, Cap(0, 0), "no limit")
+	assert.Equal(t, 2, Cap(4, 2), "capped")
+	assert.Equal(t, 1, Cap(1, 2), "below the ceiling")
+	assert.Equal(t, 2, Cap(0, 2), "no limit capped")
+}
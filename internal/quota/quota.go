@@ -0,0 +1,263 @@
+// Package quota caps the allocations, gateway-wide and per client source IP address: the number of
+// concurrent allocations and the rate of the Allocate requests. Allocate requests over a quota are
+// rejected at the socket layer of the listeners, before authentication, with a 486 (Allocation
+// Quota Reached) error for the per-source quotas and a 508 (Insufficient Capacity) error for the
+// gateway-wide quotas.
+package quota
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/logging"
+	"github.com/pion/stun"
+
+	"github.com/l7mp/stunner/internal/monitoring"
+	"github.com/l7mp/stunner/internal/stunmsg"
+	"github.com/l7mp/stunner/internal/tokenbucket"
+	"github.com/l7mp/stunner/internal/util"
+	"github.com/l7mp/stunner/pkg/clock"
+)
+
+// Quotas an Allocate request may exceed
+const (
+	// MaxAllocations is the number of concurrent allocations on the gateway
+	MaxAllocations = "max-allocations"
+	// MaxAllocationsPerSource is the number of concurrent allocations of a source
+	MaxAllocationsPerSource = "max-allocations-per-source"
+	// AllocationRate is the rate of the Allocate requests on the gateway
+	AllocationRate = "allocation-rate"
+	// AllocationRatePerSource is the rate of the Allocate requests of a source
+	AllocationRatePerSource = "allocation-rate-per-source"
+)
+
+const (
+	// maxSources bounds the number of sources whose request rate is tracked
+	maxSources = 1 << 16
+)
+
+var allocateRequest = stun.NewType(stun.MethodAllocate, stun.ClassRequest).Value()
+
+// Config sets the quotas, zero means no limit
+type Config struct {
+	// MaxAllocations is the number of concurrent allocations on the gateway
+	MaxAllocations int
+	// MaxAllocationsPerSource is the number of concurrent allocations of a source
+	MaxAllocationsPerSource int
+	// Rate is the number of Allocate requests per second on the gateway
+	Rate int
+	// RatePerSource is the number of Allocate requests per second of a source
+	RatePerSource int
+}
+
+// Counter reports the allocations in use
+type Counter interface {
+	// Len returns the number of allocations on the gateway
+	Len() int
+	// SourceLen returns the number of allocations of a client source IP address
+	SourceLen(ip net.IP) int
+	// ClientSessionID returns the session ID of the allocation of a client on a listener, or an
+	// empty string if the client has no allocation
+	ClientSessionID(listener string, client net.Addr) string
+}
+
+// Limiter enforces the allocation quotas
+type Limiter struct {
+	lock    sync.Mutex
+	conf    *Config
+	enabled int32 // atomic, so that the sockets skip the checks if no quota is set
+	counter Counter
+	global  *tokenbucket.Bucket
+	sources map[string]*tokenbucket.Bucket
+	log     logging.LeveledLogger
+	clock   clock.Clock
+}
+
+// NewLimiter creates a limiter that takes the allocations in use from the counter, disabled until
+// a config is set
+func NewLimiter(counter Counter, logger logging.LoggerFactory) *Limiter {
+	return &Limiter{
+		counter: counter,
+		sources: map[string]*tokenbucket.Bucket{},
+		clock:   clock.Real,
+		log:     logger.NewLogger("quota"),
+	}
+}
+
+// SetClock sets the clock the allocation rates are metered with. Must be called before the limiter
+// is used
+func (l *Limiter) SetClock(c clock.Clock) {
+	l.clock = c
+}
+
+// SetConfig sets the quotas, nil disables the limiter. The request rates are reset if the quotas
+// change
+func (l *Limiter) SetConfig(conf *Config) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if conf != nil && l.conf != nil && *conf == *l.conf {
+		return
+	}
+	l.conf = conf
+	l.global = nil
+	l.sources = map[string]*tokenbucket.Bucket{}
+
+	enabled := int32(0)
+	if conf != nil {
+		enabled = 1
+		if conf.Rate > 0 {
+			l.global = tokenbucket.New(l.clock.Now(), conf.Rate)
+		}
+	}
+	atomic.StoreInt32(&l.enabled, enabled)
+}
+
+// GetConfig returns the quotas, or nil if the limiter is disabled
+func (l *Limiter) GetConfig() *Config {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.conf
+}
+
+// Admit checks an Allocate request of a client on a listener and returns the quota the request
+// exceeds, or an empty string if the request is admitted
+func (l *Limiter) Admit(listener string, client net.Addr) string {
+	if atomic.LoadInt32(&l.enabled) == 0 {
+		return ""
+	}
+	ip := util.AddrIP(client)
+	if ip == nil {
+		return ""
+	}
+	// retransmissions for an existing allocation are answered by the TURN server
+	if l.counter.ClientSessionID(listener, client) != "" {
+		return ""
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	conf := l.conf
+	if conf == nil {
+		return ""
+	}
+	if conf.MaxAllocationsPerSource > 0 &&
+		l.counter.SourceLen(ip) >= conf.MaxAllocationsPerSource {
+		return MaxAllocationsPerSource
+	}
+	if conf.MaxAllocations > 0 && l.counter.Len() >= conf.MaxAllocations {
+		return MaxAllocations
+	}
+
+	now := l.clock.Now()
+	if conf.RatePerSource > 0 && !l.takeSource(now, ip.String(), conf.RatePerSource) {
+		return AllocationRatePerSource
+	}
+	if l.global != nil && !l.global.Take(now, conf.Rate) {
+		return AllocationRate
+	}
+	return ""
+}
+
+// takeSource takes a token from the bucket of a source, must be called with the lock held
+func (l *Limiter) takeSource(now time.Time, source string, rate int) bool {
+	b := util.Bucket(l.sources, source, now, rate, maxSources)
+	if b == nil {
+		l.log.Debugf("too many sources, not limiting the request rate of %s", source)
+		return true
+	}
+	return b.Take(now, rate)
+}
+
+// reject checks a message, with the header h, received from a client and returns the error response
+// to send back if the message is an Allocate request over a quota
+func (l *Limiter) reject(b []byte, h stunmsg.Header, listener string, client net.Addr) ([]byte, bool) {
+	if atomic.LoadInt32(&l.enabled) == 0 || !h.Is(allocateRequest) {
+		return nil, false
+	}
+
+	quota := l.Admit(listener, client)
+	if quota == "" {
+		return nil, false
+	}
+
+	monitoring.QuotaRejectionCounter.WithLabelValues(listener, quota).Inc()
+	l.log.Debugf("rejecting Allocate request from %s on listener %s: %s quota exceeded", client,
+		listener, quota)
+
+	code := stun.CodeInsufficientCapacity
+	if quota == MaxAllocationsPerSource || quota == AllocationRatePerSource {
+		code = stun.CodeAllocQuotaReached
+	}
+	var id [stun.TransactionIDSize]byte
+	copy(id[:], b[8:stunmsg.HeaderSize])
+	m, err := stun.Build(stun.NewTransactionIDSetter(id),
+		stun.NewType(stun.MethodAllocate, stun.ClassErrorResponse), code)
+	if err != nil {
+		return nil, false
+	}
+	return m.Raw, true
+}
+
+// NewPacketConn wraps the socket of a packet listener so that the Allocate requests over a quota
+// are answered with an error response and dropped before reaching the TURN server
+func (l *Limiter) NewPacketConn(conn net.PacketConn, listener string) net.PacketConn {
+	return &packetConn{PacketConn: conn, limiter: l, listener: listener}
+}
+
+type packetConn struct {
+	net.PacketConn
+	limiter  *Limiter
+	listener string
+}
+
+func (c *packetConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, _, err := c.ReadMessage(b)
+	return n, addr, err
+}
+
+func (c *packetConn) ReadMessage(b []byte) (int, net.Addr, stunmsg.Header, error) {
+	for {
+		n, addr, h, err := stunmsg.ReadMessage(c.PacketConn, b)
+		if err != nil {
+			return n, addr, h, err
+		}
+		res, ok := c.limiter.reject(b[:n], h, c.listener, addr)
+		if !ok {
+			return n, addr, h, err
+		}
+		if _, err := c.PacketConn.WriteTo(res, addr); err != nil {
+			c.limiter.log.Debugf("cannot send error response to %s: %s", addr, err.Error())
+		}
+	}
+}
+
+// NewListener wraps the socket of a stream listener so that the Allocate requests over a quota
+// are answered with an error response and dropped before reaching the TURN server
+func (l *Limiter) NewListener(ln net.Listener, listener string) net.Listener {
+	return &streamListener{Listener: ln, limiter: l, listener: listener}
+}
+
+type streamListener struct {
+	net.Listener
+	limiter  *Limiter
+	listener string
+}
+
+func (l *streamListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return conn, err
+	}
+	client := conn.RemoteAddr()
+	return stunmsg.NewStreamConn(conn, func(b []byte, h stunmsg.Header) ([]byte, []byte) {
+		if res, ok := l.limiter.reject(b, h, l.listener, client); ok {
+			// drop the request
+			return nil, res
+		}
+		return b, nil
+	}), nil
+}
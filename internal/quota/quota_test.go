@@ -0,0 +1,197 @@
+package quota
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pion/logging"
+	"github.com/pion/stun"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/l7mp/stunner/pkg/clock"
+)
+
+type testCounter struct {
+	total   int
+	sources map[string]int
+	clients map[string]bool
+}
+
+func (c *testCounter) Len() int                { return c.total }
+func (c *testCounter) SourceLen(ip net.IP) int { return c.sources[ip.String()] }
+func (c *testCounter) ClientSessionID(listener string, client net.Addr) string {
+	if c.clients[listener+"/"+client.String()] {
+		return "session"
+	}
+	return ""
+}
+
+func newTestCounter() *testCounter {
+	return &testCounter{sources: map[string]int{}, clients: map[string]bool{}}
+}
+
+func TestQuotaDisabled(t *testing.T) {
+	l := NewLimiter(newTestCounter(), logging.NewDefaultLoggerFactory())
+	client := &net.UDPAddr{IP: net.ParseIP("1.2.3.4"), Port: 1234}
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, "", l.Admit("udp", client), "no quota")
+	}
+
+	l.SetConfig(&Config{RatePerSource: 1})
+	assert.Equal(t, "", l.Admit("udp", client), "admitted")
+	assert.Equal(t, "", l.Admit("udp", client), "burst")
+	assert.Equal(t, AllocationRatePerSource, l.Admit("udp", client), "rate exceeded")
+
+	l.SetConfig(nil)
+	assert.Equal(t, "", l.Admit("udp", client), "quota removed")
+}
+
+func TestQuotaMaxAllocations(t *testing.T) {
+	c := newTestCounter()
+	l := NewLimiter(c, logging.NewDefaultLoggerFactory())
+	l.SetConfig(&Config{MaxAllocations: 3, MaxAllocationsPerSource: 2})
+
+	client := &net.UDPAddr{IP: net.ParseIP("1.2.3.4"), Port: 1234}
+	other := &net.TCPAddr{IP: net.ParseIP("1.2.3.5"), Port: 1234}
+
+	c.sources["1.2.3.4"], c.total = 1, 1
+	assert.Equal(t, "", l.Admit("udp", client), "below quota")
+
+	c.sources["1.2.3.4"], c.total = 2, 2
+	assert.Equal(t, MaxAllocationsPerSource, l.Admit("udp", client), "source quota")
+	assert.Equal(t, "", l.Admit("tcp", other), "other source")
+
+	c.sources["1.2.3.5"], c.total = 1, 3
+	assert.Equal(t, MaxAllocations, l.Admit("tcp", other), "gateway quota")
+
+	// retransmitted requests of existing allocations are left to the TURN server
+	c.clients["udp/"+client.String()] = true
+	assert.Equal(t, "", l.Admit("udp", client), "existing allocation")
+}
+
+func TestQuotaRate(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	l := NewLimiter(newTestCounter(), logging.NewDefaultLoggerFactory())
+	l.SetClock(clk)
+	l.SetConfig(&Config{Rate: 3, RatePerSource: 1})
+
+	client := &net.UDPAddr{IP: net.ParseIP("1.2.3.4"), Port: 1234}
+	assert.Equal(t, "", l.Admit("udp", client), "admitted")
+	assert.Equal(t, "", l.Admit("udp", client), "burst")
+	assert.Equal(t, AllocationRatePerSource, l.Admit("udp", client), "source rate exceeded")
+
+	// the gateway-wide bucket holds 6 tokens, 2 taken
+	for i := 5; i < 9; i++ {
+		assert.Equal(t, "", l.Admit("udp", &net.UDPAddr{IP: net.IPv4(1, 2, 3, byte(i)), Port: 1}),
+			"admitted")
+	}
+	assert.Equal(t, AllocationRate, l.Admit("udp", &net.UDPAddr{IP: net.IPv4(1, 2, 3, 10), Port: 1}),
+		"gateway rate exceeded")
+
+	// the same config does not reset the rates
+	l.SetConfig(&Config{Rate: 3, RatePerSource: 1})
+	assert.Equal(t, AllocationRatePerSource, l.Admit("udp", client), "source rate exceeded")
+
+	// the buckets are refilled
+	clk.Advance(time.Second)
+	assert.Equal(t, "", l.Admit("udp", client), "refilled")
+	assert.Equal(t, AllocationRatePerSource, l.Admit("udp", client), "source rate exceeded")
+}
+
+func TestQuotaPacketConn(t *testing.T) {
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err, "server socket")
+	defer server.Close()
+	client, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err, "client socket")
+	defer client.Close()
+
+	l := NewLimiter(newTestCounter(), logging.NewDefaultLoggerFactory())
+	l.SetConfig(&Config{RatePerSource: 1})
+	conn := l.NewPacketConn(server, "udp")
+
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			if _, _, err := conn.ReadFrom(buf); err != nil {
+				return
+			}
+		}
+	}()
+
+	req := stun.MustBuild(stun.TransactionID, stun.NewType(stun.MethodAllocate, stun.ClassRequest))
+	res := &stun.Message{Raw: make([]byte, 1500)}
+	for i := 0; i < 3; i++ {
+		_, err = client.WriteTo(req.Raw, server.LocalAddr())
+		assert.NoError(t, err, "send request")
+	}
+
+	client.SetReadDeadline(time.Now().Add(time.Second)) //nolint:errcheck
+	n, _, err := client.ReadFrom(res.Raw)
+	assert.NoError(t, err, "error response")
+	res.Raw = res.Raw[:n]
+	assert.NoError(t, res.Decode(), "decode")
+	assert.Equal(t, stun.NewType(stun.MethodAllocate, stun.ClassErrorResponse), res.Type, "type")
+	assert.Equal(t, req.TransactionID, res.TransactionID, "transaction id")
+	var code stun.ErrorCodeAttribute
+	assert.NoError(t, code.GetFrom(res), "error code")
+	assert.Equal(t, stun.CodeAllocQuotaReached, code.Code, "code")
+}
+
+func TestQuotaListener(t *testing.T) {
+	tcp, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err, "server socket")
+	l := NewLimiter(newTestCounter(), logging.NewDefaultLoggerFactory())
+	l.SetConfig(&Config{RatePerSource: 1})
+	ln := l.NewListener(tcp, "tcp")
+	defer ln.Close()
+
+	client, err := net.Dial("tcp", tcp.Addr().String())
+	assert.NoError(t, err, "client socket")
+	defer client.Close()
+	server, err := ln.Accept()
+	assert.NoError(t, err, "accept")
+	defer server.Close()
+
+	// the burst of the bucket is 2, the third of the pipelined requests is over the quota, the
+	// fourth is split across two segments
+	reqs := []*stun.Message{}
+	for i := 0; i < 4; i++ {
+		reqs = append(reqs, stun.MustBuild(stun.TransactionID,
+			stun.NewType(stun.MethodAllocate, stun.ClassRequest)))
+	}
+	_, err = client.Write(append(append(append([]byte{}, reqs[0].Raw...), reqs[1].Raw...),
+		reqs[2].Raw...))
+	assert.NoError(t, err, "send requests")
+
+	buf := make([]byte, 2*len(reqs[0].Raw))
+	server.SetReadDeadline(time.Now().Add(time.Second)) //nolint:errcheck
+	_, err = io.ReadFull(server, buf)
+	assert.NoError(t, err, "read")
+	assert.Equal(t, append(append([]byte{}, reqs[0].Raw...), reqs[1].Raw...), buf, "admitted")
+
+	readResponse := func(req *stun.Message) {
+		res := &stun.Message{Raw: make([]byte, len(req.Raw)+64)}
+		client.SetReadDeadline(time.Now().Add(time.Second)) //nolint:errcheck
+		n, err := client.Read(res.Raw)
+		assert.NoError(t, err, "error response")
+		res.Raw = res.Raw[:n]
+		assert.NoError(t, res.Decode(), "decode")
+		assert.Equal(t, req.TransactionID, res.TransactionID, "transaction id")
+		var code stun.ErrorCodeAttribute
+		assert.NoError(t, code.GetFrom(res), "error code")
+		assert.Equal(t, stun.CodeAllocQuotaReached, code.Code, "code")
+	}
+	readResponse(reqs[2])
+
+	_, err = client.Write(reqs[3].Raw[:10])
+	assert.NoError(t, err, "send partial request")
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		client.Write(reqs[3].Raw[10:]) //nolint:errcheck
+	}()
+	go server.Read(buf) //nolint:errcheck
+	readResponse(reqs[3])
+}
@@ -0,0 +1,210 @@
+// Package ratelimit limits the rate of the control-plane requests of the allocations per
+// authenticated username: the Refresh, CreatePermission and ChannelBind requests, so that a client
+// stuck in a refresh loop cannot monopolize the processing of the TURN server. The username of a
+// request is the username the allocation of the client was authenticated with, so that the
+// requests of clients with no allocation, rejected by the TURN server anyway, are not limited and
+// a client cannot drain the requests of another user. Requests over the rate are dropped at the
+// socket layer of the listeners, and the clients retransmit them with a backoff.
+package ratelimit
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/logging"
+	"github.com/pion/stun"
+
+	"github.com/l7mp/stunner/internal/monitoring"
+	"github.com/l7mp/stunner/internal/stunmsg"
+	"github.com/l7mp/stunner/internal/tokenbucket"
+	"github.com/l7mp/stunner/internal/util"
+	"github.com/l7mp/stunner/pkg/clock"
+)
+
+const (
+	// maxUsers bounds the number of usernames whose request rate is tracked
+	maxUsers = 1 << 16
+)
+
+// methods maps the message types of the limited requests to the method label of the metrics
+var methods = map[uint16]string{
+	stun.NewType(stun.MethodRefresh, stun.ClassRequest).Value():          "refresh",
+	stun.NewType(stun.MethodCreatePermission, stun.ClassRequest).Value(): "create-permission",
+	stun.NewType(stun.MethodChannelBind, stun.ClassRequest).Value():      "channel-bind",
+}
+
+var refreshRequest = stun.NewType(stun.MethodRefresh, stun.ClassRequest).Value()
+
+// Config sets the limits
+type Config struct {
+	// RatePerUser is the number of requests per second of a username
+	RatePerUser int
+}
+
+// Counter reports the allocations in use
+type Counter interface {
+	// ClientUsername returns the username of the allocation of a client on a listener, or an
+	// empty string if the client has no allocation
+	ClientUsername(listener string, client net.Addr) string
+}
+
+// Limiter limits the request rate of the usernames
+type Limiter struct {
+	lock    sync.Mutex
+	conf    *Config
+	enabled int32 // atomic, so that the sockets skip the checks if no limit is set
+	counter Counter
+	users   map[string]*tokenbucket.Bucket
+	log     logging.LeveledLogger
+	clock   clock.Clock
+}
+
+// NewLimiter creates a limiter that takes the usernames of the allocations from the counter,
+// disabled until a config is set
+func NewLimiter(counter Counter, logger logging.LoggerFactory) *Limiter {
+	return &Limiter{
+		counter: counter,
+		users:   map[string]*tokenbucket.Bucket{},
+		clock:   clock.Real,
+		log:     logger.NewLogger("ratelimit"),
+	}
+}
+
+// SetClock sets the clock the request rates are metered with. Must be called before the limiter is
+// used
+func (l *Limiter) SetClock(c clock.Clock) {
+	l.clock = c
+}
+
+// SetConfig sets the limits, nil disables the limiter. The request rates are reset if the limits
+// change
+func (l *Limiter) SetConfig(conf *Config) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if conf != nil && l.conf != nil && *conf == *l.conf {
+		return
+	}
+	l.conf = conf
+	l.users = map[string]*tokenbucket.Bucket{}
+
+	enabled := int32(0)
+	if conf != nil && conf.RatePerUser > 0 {
+		enabled = 1
+	}
+	atomic.StoreInt32(&l.enabled, enabled)
+}
+
+// Limit checks a message, with the header h, received from a client on a listener and returns the
+// method of the request if the request is over the rate of the username and is to be dropped, or
+// an empty string if the message is passed to the TURN server
+func (l *Limiter) Limit(b []byte, h stunmsg.Header, listener string, client net.Addr) string {
+	if atomic.LoadInt32(&l.enabled) == 0 || h.Kind != stunmsg.STUN {
+		return ""
+	}
+	method, ok := methods[h.Type]
+	if !ok || (h.Type == refreshRequest && deallocation(b)) {
+		return ""
+	}
+	username := l.counter.ClientUsername(listener, client)
+	if username == "" {
+		return ""
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	conf := l.conf
+	if conf == nil || conf.RatePerUser <= 0 {
+		return ""
+	}
+	if l.take(l.clock.Now(), username, conf.RatePerUser) {
+		return ""
+	}
+	return method
+}
+
+// take takes a token from the bucket of a username, must be called with the lock held
+func (l *Limiter) take(now time.Time, username string, rate int) bool {
+	b := util.Bucket(l.users, username, now, rate, maxUsers)
+	if b == nil {
+		l.log.Debugf("too many users, not limiting the request rate of %q", username)
+		return true
+	}
+	return b.Take(now, rate)
+}
+
+// deallocation returns true if a Refresh request deletes the allocation with a zero LIFETIME, which
+// is never limited
+func deallocation(b []byte) bool {
+	off, length, ok := stunmsg.Attribute(b, uint16(stun.AttrLifetime))
+	return ok && length == 4 && binary.BigEndian.Uint32(b[off:off+4]) == 0
+}
+
+// drop records a request dropped
+func (l *Limiter) drop(method, listener string, client net.Addr) {
+	monitoring.RequestRateLimitedCounter.WithLabelValues(listener, method).Inc()
+	l.log.Tracef("dropping %s request from %s on listener %s: request rate exceeded", method,
+		client, listener)
+}
+
+// NewPacketConn wraps the socket of a packet listener so that the requests over the rate are
+// dropped before reaching the TURN server
+func (l *Limiter) NewPacketConn(conn net.PacketConn, listener string) net.PacketConn {
+	return &packetConn{PacketConn: conn, limiter: l, listener: listener}
+}
+
+type packetConn struct {
+	net.PacketConn
+	limiter  *Limiter
+	listener string
+}
+
+func (c *packetConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, _, err := c.ReadMessage(b)
+	return n, addr, err
+}
+
+func (c *packetConn) ReadMessage(b []byte) (int, net.Addr, stunmsg.Header, error) {
+	for {
+		n, addr, h, err := stunmsg.ReadMessage(c.PacketConn, b)
+		if err != nil {
+			return n, addr, h, err
+		}
+		method := c.limiter.Limit(b[:n], h, c.listener, addr)
+		if method == "" {
+			return n, addr, h, err
+		}
+		c.limiter.drop(method, c.listener, addr)
+	}
+}
+
+// NewListener wraps the socket of a stream listener so that the requests over the rate are
+// dropped before reaching the TURN server
+func (l *Limiter) NewListener(ln net.Listener, listener string) net.Listener {
+	return &streamListener{Listener: ln, limiter: l, listener: listener}
+}
+
+type streamListener struct {
+	net.Listener
+	limiter  *Limiter
+	listener string
+}
+
+func (l *streamListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return conn, err
+	}
+	client := conn.RemoteAddr()
+	return stunmsg.NewStreamConn(conn, func(b []byte, h stunmsg.Header) ([]byte, []byte) {
+		if method := l.limiter.Limit(b, h, l.listener, client); method != "" {
+			l.limiter.drop(method, l.listener, client)
+			return nil, nil
+		}
+		return b, nil
+	}), nil
+}
@@ -0,0 +1,136 @@
+package ratelimit
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pion/logging"
+	"github.com/pion/stun"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/l7mp/stunner/internal/stunmsg"
+	"github.com/l7mp/stunner/pkg/clock"
+)
+
+type testCounter map[string]string
+
+func (c testCounter) ClientUsername(listener string, client net.Addr) string {
+	return c[listener+"/"+client.String()]
+}
+
+var (
+	client1 = &net.UDPAddr{IP: net.ParseIP("1.2.3.4"), Port: 1234}
+	client2 = &net.UDPAddr{IP: net.ParseIP("1.2.3.5"), Port: 1234}
+	client3 = &net.UDPAddr{IP: net.ParseIP("1.2.3.6"), Port: 1234}
+)
+
+func newTestLimiter() *Limiter {
+	c := testCounter{
+		"udp/" + client1.String(): "user1",
+		"udp/" + client2.String(): "user1",
+		"udp/" + client3.String(): "user2",
+	}
+	return NewLimiter(c, logging.NewDefaultLoggerFactory())
+}
+
+func limit(l *Limiter, b []byte, listener string, client net.Addr) string {
+	return l.Limit(b, stunmsg.Parse(b), listener, client)
+}
+
+func request(t *testing.T, method stun.Method, setters ...stun.Setter) []byte {
+	m, err := stun.Build(append([]stun.Setter{stun.TransactionID,
+		stun.NewType(method, stun.ClassRequest)}, setters...)...)
+	assert.NoError(t, err, "build")
+	return m.Raw
+}
+
+func lifetime(d time.Duration) stun.RawAttribute {
+	v := make([]byte, 4)
+	binary.BigEndian.PutUint32(v, uint32(d/time.Second))
+	return stun.RawAttribute{Type: stun.AttrLifetime, Value: v}
+}
+
+func TestRateLimitDisabled(t *testing.T) {
+	l := newTestLimiter()
+	refresh := request(t, stun.MethodRefresh, lifetime(10*time.Minute))
+	for i := 0; i < 100; i++ {
+		assert.Equal(t, "", limit(l, refresh, "udp", client1), "no limit")
+	}
+
+	l.SetConfig(&Config{RatePerUser: 1})
+	assert.Equal(t, "", limit(l, refresh, "udp", client1), "admitted")
+	assert.Equal(t, "", limit(l, refresh, "udp", client1), "burst")
+	assert.Equal(t, "refresh", limit(l, refresh, "udp", client1), "rate exceeded")
+
+	l.SetConfig(nil)
+	assert.Equal(t, "", limit(l, refresh, "udp", client1), "limit removed")
+}
+
+func TestRateLimitPerUser(t *testing.T) {
+	clk := clock.NewFake(time.Now())
+	l := newTestLimiter()
+	l.SetClock(clk)
+	l.SetConfig(&Config{RatePerUser: 1})
+
+	permission := request(t, stun.MethodCreatePermission)
+	channel := request(t, stun.MethodChannelBind)
+	assert.Equal(t, "", limit(l, permission, "udp", client1), "admitted")
+	// the clients of a username share the rate
+	assert.Equal(t, "", limit(l, channel, "udp", client2), "burst")
+	assert.Equal(t, "create-permission", limit(l, permission, "udp", client1), "rate exceeded")
+	assert.Equal(t, "channel-bind", limit(l, channel, "udp", client2), "rate exceeded")
+
+	// other usernames are not affected
+	assert.Equal(t, "", limit(l, permission, "udp", client3), "other user")
+
+	// neither are the clients with no allocation, other listeners and other requests
+	other := &net.UDPAddr{IP: net.ParseIP("1.2.3.7"), Port: 1234}
+	assert.Equal(t, "", limit(l, permission, "udp", other), "no allocation")
+	assert.Equal(t, "", limit(l, permission, "tcp", client1), "other listener")
+	assert.Equal(t, "", limit(l, request(t, stun.MethodAllocate), "udp", client1), "allocate")
+	assert.Equal(t, "", limit(l, request(t, stun.MethodBinding), "udp", client1), "binding")
+	assert.Equal(t, "", limit(l, []byte{0x40, 0x00, 0x00, 0x04, 1, 2, 3, 4}, "udp", client1),
+		"channel data")
+
+	// deallocations are never limited
+	assert.Equal(t, "", limit(l, request(t, stun.MethodRefresh, lifetime(0)), "udp", client1),
+		"deallocation")
+
+	// the bucket is refilled
+	clk.Advance(time.Second)
+	assert.Equal(t, "", limit(l, permission, "udp", client1), "refilled")
+	assert.Equal(t, "create-permission", limit(l, permission, "udp", client1), "rate exceeded")
+}
+
+type testPacketConn struct {
+	net.PacketConn
+	packets [][]byte
+}
+
+func (c *testPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	p := c.packets[0]
+	c.packets = c.packets[1:]
+	return copy(b, p), client1, nil
+}
+
+func TestRateLimitPacketConn(t *testing.T) {
+	l := newTestLimiter()
+	l.SetConfig(&Config{RatePerUser: 1})
+
+	refresh := request(t, stun.MethodRefresh, lifetime(10*time.Minute))
+	binding := request(t, stun.MethodBinding)
+	conn := l.NewPacketConn(&testPacketConn{packets: [][]byte{refresh, refresh, refresh,
+		binding}}, "udp")
+
+	buf := make([]byte, 1500)
+	for i := 0; i < 2; i++ {
+		n, _, err := conn.ReadFrom(buf)
+		assert.NoError(t, err, "read")
+		assert.Equal(t, refresh, buf[:n], "admitted")
+	}
+	n, _, err := conn.ReadFrom(buf)
+	assert.NoError(t, err, "read")
+	assert.Equal(t, binding, buf[:n], "refresh dropped")
+}
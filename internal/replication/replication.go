@@ -0,0 +1,222 @@
+// Package replication implements the takeover of the UDP allocations of the failed replicas of the
+// gateway. The replicas share the state of their allocations, and if the packets of a client of
+// another replica arrive at a listener with no allocation for the client, the listener socket
+// recreates the allocation of the client with the same relay port, permissions and channel
+// bindings before passing the packets of the client on to the TURN server. The allocation is
+// recreated by injecting the Allocate, CreatePermission and ChannelBind requests of the client,
+// authenticated with the key of the username of the allocation, into the TURN server, and the
+// responses to the injected requests are swallowed.
+package replication
+
+import (
+	"net"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/logging"
+
+	"github.com/l7mp/stunner/internal/monitoring"
+)
+
+// Channel is a replicated channel binding
+type Channel struct {
+	// Number is the channel number
+	Number uint16 `json:"number"`
+	// Peer is the transport address of the peer
+	Peer string `json:"peer"`
+}
+
+// Allocation is the replicated state of a UDP allocation
+type Allocation struct {
+	// Listener is the name of the listener of the allocation
+	Listener string `json:"listener"`
+	// Client is the transport address of the client
+	Client string `json:"client"`
+	// Username is the username the allocation was authenticated with
+	Username string `json:"username"`
+	// RelayPort is the port of the relay transport
+	RelayPort int `json:"relay_port"`
+	// Lifetime is the time in seconds until the allocation expires unless refreshed
+	Lifetime int `json:"lifetime"`
+	// Permissions lists the peer IPs the client has a permission for
+	Permissions []string `json:"permissions,omitempty"`
+	// Channels lists the channel bindings of the client
+	Channels []Channel `json:"channels,omitempty"`
+}
+
+// Snapshot is the state of the allocations of a replica
+type Snapshot struct {
+	// Node is the name of the replica
+	Node string `json:"node"`
+	// Allocations lists the allocations of the replica
+	Allocations []Allocation `json:"allocations"`
+}
+
+// Config configures the takeover of the replicated allocations
+type Config struct {
+	// Node is the name of the local replica, the snapshots of which are ignored
+	Node string
+}
+
+type entry struct {
+	Allocation
+	node    string
+	expires time.Time
+}
+
+func entryKey(listener, client string) string {
+	return listener + "|" + client
+}
+
+// Table holds the allocations of the other replicas
+type Table struct {
+	lock    sync.RWMutex
+	conf    *Config
+	entries map[string]*entry
+	count   int32 // atomic, so that the sockets skip the lookups if there is nothing to take over
+	pending map[string]int
+	log     logging.LeveledLogger
+}
+
+// NewTable creates an empty table, disabled until a config is set
+func NewTable(logger logging.LoggerFactory) *Table {
+	return &Table{
+		entries: map[string]*entry{},
+		pending: map[string]int{},
+		log:     logger.NewLogger("replication"),
+	}
+}
+
+// SetConfig sets the config, nil disables the takeovers and forgets the replicated allocations
+func (t *Table) SetConfig(conf *Config) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.conf = conf
+	if conf == nil {
+		t.entries = map[string]*entry{}
+		t.updateCount()
+	}
+}
+
+// updateCount updates the count of the entries, must be called with the lock held
+func (t *Table) updateCount() {
+	atomic.StoreInt32(&t.count, int32(len(t.entries)))
+	monitoring.ReplicatedAllocationsGauge.Set(float64(len(t.entries)))
+}
+
+// Update replaces the allocations of a replica with the snapshot of the replica, and returns
+// false if the takeovers are disabled
+func (t *Table) Update(s Snapshot) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if t.conf == nil {
+		return false
+	}
+	if s.Node == t.conf.Node {
+		return true
+	}
+
+	now := time.Now()
+	for key, e := range t.entries {
+		if e.node == s.Node || !now.Before(e.expires) {
+			delete(t.entries, key)
+		}
+	}
+	for _, a := range s.Allocations {
+		if a.Lifetime <= 0 || a.RelayPort <= 0 || a.RelayPort > 0xffff {
+			continue
+		}
+		t.entries[entryKey(a.Listener, a.Client)] = &entry{Allocation: a, node: s.Node,
+			expires: now.Add(time.Duration(a.Lifetime) * time.Second)}
+	}
+	t.updateCount()
+	t.log.Debugf("replicated %d allocations of replica %q", len(s.Allocations), s.Node)
+
+	return true
+}
+
+// Allocations returns the replicated allocations that can be taken over, with their remaining
+// lifetimes, sorted by listener and client
+func (t *Table) Allocations() []Allocation {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+
+	now := time.Now()
+	ret := []Allocation{}
+	for _, e := range t.entries {
+		if !now.Before(e.expires) {
+			continue
+		}
+		a := e.Allocation
+		a.Lifetime = int(e.expires.Sub(now) / time.Second)
+		ret = append(ret, a)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Listener != ret[j].Listener {
+			return ret[i].Listener < ret[j].Listener
+		}
+		return ret[i].Client < ret[j].Client
+	})
+	return ret
+}
+
+// take removes the replicated allocation of a client and returns it if not expired
+func (t *Table) take(listener, client string) *entry {
+	key := entryKey(listener, client)
+
+	t.lock.RLock()
+	_, ok := t.entries[key]
+	t.lock.RUnlock()
+	if !ok {
+		return nil
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	e, ok := t.entries[key]
+	if !ok {
+		return nil
+	}
+	delete(t.entries, key)
+	t.updateCount()
+
+	// the lifetime of the allocation is recomputed for the takeover
+	now := time.Now()
+	if !now.Before(e.expires) {
+		return nil
+	}
+	e.Lifetime = int(e.expires.Sub(now) / time.Second)
+	if e.Lifetime == 0 {
+		return nil
+	}
+	return e
+}
+
+// setPending requests the relay port for the next allocation of a listener
+func (t *Table) setPending(listener string, port int) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.pending[listener] = port
+}
+
+// takePending returns the relay port requested for the next allocation of a listener, if any
+func (t *Table) takePending(listener string) int {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	port := t.pending[listener]
+	delete(t.pending, listener)
+	return port
+}
+
+// clientAddr parses the transport address of a client
+func clientAddr(addr string) *net.UDPAddr {
+	a, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil
+	}
+	return a
+}
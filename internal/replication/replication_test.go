@@ -0,0 +1,82 @@
+package replication
+
+import (
+	"net"
+	"testing"
+
+	"github.com/pion/logging"
+	"github.com/pion/turn/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTable(t *testing.T) {
+	table := NewTable(logging.NewDefaultLoggerFactory())
+
+	snapshot := Snapshot{Node: "replica-a", Allocations: []Allocation{{
+		Listener: "udp", Client: "1.2.3.4:5000", Username: "user1", RelayPort: 40000, Lifetime: 600,
+		Permissions: []string{"10.0.0.1"}, Channels: []Channel{{Number: 0x4000, Peer: "10.0.0.1:6000"}},
+	}, {
+		Listener: "udp", Client: "1.2.3.5:5000", Username: "user1", RelayPort: 40001, Lifetime: 0,
+	}}}
+
+	// disabled until configured
+	assert.False(t, table.Update(snapshot), "disabled")
+	assert.Len(t, table.Allocations(), 0, "disabled")
+
+	table.SetConfig(&Config{Node: "replica-b"})
+	assert.True(t, table.Update(snapshot), "update")
+	allocs := table.Allocations()
+	assert.Len(t, allocs, 1, "expired allocations are skipped")
+	assert.Equal(t, "1.2.3.4:5000", allocs[0].Client, "client")
+	assert.Equal(t, []string{"10.0.0.1"}, allocs[0].Permissions, "permissions")
+
+	// the own snapshots are ignored
+	assert.True(t, table.Update(Snapshot{Node: "replica-b", Allocations: []Allocation{{
+		Listener: "udp", Client: "1.2.3.6:5000", RelayPort: 40002, Lifetime: 600}}}), "own")
+	assert.Len(t, table.Allocations(), 1, "own snapshot")
+
+	// a snapshot replaces the allocations of the replica
+	assert.True(t, table.Update(Snapshot{Node: "replica-a", Allocations: []Allocation{{
+		Listener: "udp", Client: "1.2.3.7:5000", RelayPort: 40003, Lifetime: 600}}}), "replace")
+	allocs = table.Allocations()
+	assert.Len(t, allocs, 1, "replaced")
+	assert.Equal(t, "1.2.3.7:5000", allocs[0].Client, "replaced client")
+
+	assert.Nil(t, table.take("udp", "1.2.3.4:5000"), "replaced allocation")
+	assert.Nil(t, table.take("tcp", "1.2.3.7:5000"), "other listener")
+	e := table.take("udp", "1.2.3.7:5000")
+	assert.NotNil(t, e, "take")
+	assert.Equal(t, 40003, e.RelayPort, "relay port")
+	assert.Nil(t, table.take("udp", "1.2.3.7:5000"), "taken once")
+
+	// disabling forgets the allocations
+	assert.True(t, table.Update(snapshot), "update")
+	table.SetConfig(nil)
+	assert.Len(t, table.Allocations(), 0, "forgotten")
+}
+
+type portGenerator struct {
+	turn.RelayAddressGenerator
+	requested int
+}
+
+func (g *portGenerator) AllocatePacketConn(network string, requestedPort int) (net.PacketConn, net.Addr, error) {
+	g.requested = requestedPort
+	return nil, nil, nil
+}
+
+func TestRelayAddressGenerator(t *testing.T) {
+	table := NewTable(logging.NewDefaultLoggerFactory())
+	base := &portGenerator{}
+	gen := table.NewRelayAddressGenerator(base, "udp")
+
+	_, _, _ = gen.AllocatePacketConn("udp4", 0)
+	assert.Equal(t, 0, base.requested, "no pending port")
+
+	table.setPending("udp", 40000)
+	_, _, _ = gen.AllocatePacketConn("udp4", 0)
+	assert.Equal(t, 40000, base.requested, "pending port")
+
+	_, _, _ = gen.AllocatePacketConn("udp4", 0)
+	assert.Equal(t, 0, base.requested, "pending port is used once")
+}
@@ -0,0 +1,332 @@
+package replication
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/stun"
+	"github.com/pion/turn/v2"
+
+	"github.com/l7mp/stunner/internal/monitoring"
+	"github.com/l7mp/stunner/internal/stunmsg"
+)
+
+const (
+	// takeoverTimeout is the time after which a stalled takeover is abandoned
+	takeoverTimeout = 2 * time.Second
+	// maxHeld bounds the number of packets of a client held during a takeover
+	maxHeld = 8
+)
+
+var allocateRequest = stun.NewType(stun.MethodAllocate, stun.ClassRequest).Value()
+
+const (
+	stepNonce = iota
+	stepAllocate
+	stepGrant
+	stepRelease
+)
+
+type takeover struct {
+	entry   *entry
+	client  net.Addr
+	held    [][]byte
+	txid    [stun.TransactionIDSize]byte
+	step    int
+	nonce   stun.Nonce
+	realm   stun.Realm
+	key     []byte
+	grants  [][]stun.Setter
+	started time.Time
+}
+
+type packet struct {
+	b    []byte
+	addr net.Addr
+	port int // the relay port to request for an injected Allocate request
+}
+
+// NewRelayAddressGenerator wraps the relay address generator of a listener so that the
+// allocations recreated by the takeovers get the relay port of the replicated allocation
+func (t *Table) NewRelayAddressGenerator(gen turn.RelayAddressGenerator, listener string) turn.RelayAddressGenerator {
+	return &relayAddressGenerator{RelayAddressGenerator: gen, listener: listener, table: t}
+}
+
+type relayAddressGenerator struct {
+	turn.RelayAddressGenerator
+	listener string
+	table    *Table
+}
+
+func (r *relayAddressGenerator) AllocatePacketConn(network string, requestedPort int) (net.PacketConn, net.Addr, error) {
+	if port := r.table.takePending(r.listener); port != 0 && requestedPort == 0 {
+		requestedPort = port
+	}
+	return r.RelayAddressGenerator.AllocatePacketConn(network, requestedPort)
+}
+
+// NewPacketConn wraps the socket of a UDP listener so that the replicated allocations of the
+// clients with no local allocation are recreated before the packets of the client are passed on.
+// The key function authenticates the injected requests, and local reports whether a client
+// already has an allocation on the listener
+func (t *Table) NewPacketConn(conn net.PacketConn, listener string, key stunmsg.KeyFunc, local func(net.Addr) bool) net.PacketConn {
+	return &packetConn{PacketConn: conn, table: t, listener: listener, key: key, local: local,
+		takeovers: map[string]*takeover{}}
+}
+
+type packetConn struct {
+	net.PacketConn
+	table     *Table
+	listener  string
+	key       stunmsg.KeyFunc
+	local     func(net.Addr) bool
+	lock      sync.Mutex
+	takeovers map[string]*takeover
+	active    int32 // atomic, the number of takeovers in progress
+	queue     []packet
+}
+
+func (c *packetConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, _, err := c.ReadMessage(b)
+	return n, addr, err
+}
+
+func (c *packetConn) ReadMessage(b []byte) (int, net.Addr, stunmsg.Header, error) {
+	for {
+		if p, ok := c.next(); ok {
+			if p.port != 0 {
+				c.table.setPending(c.listener, p.port)
+			}
+			n := copy(b, p.b)
+			return n, p.addr, stunmsg.Parse(b[:n]), nil
+		}
+		n, addr, h, err := stunmsg.ReadMessage(c.PacketConn, b)
+		if err != nil || !c.intercept(b[:n], h, addr) {
+			return n, addr, h, err
+		}
+	}
+}
+
+func (c *packetConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	if c.response(b, addr) {
+		return len(b), nil
+	}
+	return c.PacketConn.WriteTo(b, addr)
+}
+
+// next returns the next injected or released packet, abandoning the stalled takeovers
+func (c *packetConn) next() (packet, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if atomic.LoadInt32(&c.active) > 0 {
+		now := time.Now()
+		for _, t := range c.takeovers {
+			if now.Sub(t.started) > takeoverTimeout {
+				c.finish(t, "timeout")
+			}
+		}
+	}
+	return c.pop()
+}
+
+// pop removes the first packet from the queue, must be called with the lock held
+func (c *packetConn) pop() (packet, bool) {
+	if len(c.queue) == 0 {
+		return packet{}, false
+	}
+	p := c.queue[0]
+	c.queue[0] = packet{}
+	c.queue = c.queue[1:]
+	return p, true
+}
+
+// intercept holds the packets, with the header h, of the clients being taken over and starts the
+// takeover of the replicated allocations
+func (c *packetConn) intercept(b []byte, h stunmsg.Header, addr net.Addr) bool {
+	if atomic.LoadInt32(&c.table.count) == 0 && atomic.LoadInt32(&c.active) == 0 {
+		return false
+	}
+
+	client := addr.String()
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if t, ok := c.takeovers[client]; ok {
+		if len(t.held) < maxHeld {
+			t.held = append(t.held, append([]byte(nil), b...))
+		}
+		return true
+	}
+
+	if atomic.LoadInt32(&c.table.count) == 0 {
+		return false
+	}
+	e := c.table.take(c.listener, client)
+	if e == nil {
+		return false
+	}
+	// a new allocation supersedes the replicated one, and so does a local allocation
+	if h.Is(allocateRequest) || c.local(addr) {
+		return false
+	}
+
+	t := &takeover{entry: e, client: addr, held: [][]byte{append([]byte(nil), b...)}, started: time.Now()}
+	for _, ip := range e.Permissions {
+		peer := net.ParseIP(ip)
+		if peer == nil {
+			continue
+		}
+		t.grants = append(t.grants, []stun.Setter{stun.NewType(stun.MethodCreatePermission, stun.ClassRequest),
+			peerAddress{IP: peer}})
+	}
+	for _, ch := range e.Channels {
+		peer := clientAddr(ch.Peer)
+		if peer == nil {
+			continue
+		}
+		t.grants = append(t.grants, []stun.Setter{stun.NewType(stun.MethodChannelBind, stun.ClassRequest),
+			stun.RawAttribute{Type: stun.AttrChannelNumber, Value: []byte{byte(ch.Number >> 8), byte(ch.Number), 0, 0}},
+			peerAddress{IP: peer.IP, Port: peer.Port}})
+	}
+
+	// the unauthenticated Allocate request obtains a nonce and the realm from the server
+	m, err := stun.Build(stun.TransactionID, stun.NewType(stun.MethodAllocate, stun.ClassRequest),
+		requestedTransportUDP, stun.Fingerprint)
+	if err != nil {
+		c.table.log.Warnf("takeover of the allocation of client %s failed: %s", client, err.Error())
+		return false
+	}
+	t.txid = m.TransactionID
+	c.takeovers[client] = t
+	atomic.AddInt32(&c.active, 1)
+	c.queue = append(c.queue, packet{b: m.Raw, addr: addr})
+
+	c.table.log.Debugf("taking over the allocation of client %s on listener %s from replica %q",
+		client, c.listener, e.node)
+	return true
+}
+
+// response swallows the responses to the injected requests and advances the takeovers
+func (c *packetConn) response(b []byte, addr net.Addr) bool {
+	if atomic.LoadInt32(&c.active) == 0 || stunmsg.Parse(b).Kind != stunmsg.STUN {
+		return false
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	t, ok := c.takeovers[addr.String()]
+	if !ok || !bytes.Equal(b[8:stunmsg.HeaderSize], t.txid[:]) {
+		return false
+	}
+	m := &stun.Message{Raw: append([]byte(nil), b...)}
+	if err := m.Decode(); err != nil {
+		c.finish(t, "failed")
+		return true
+	}
+	c.advance(t, m)
+	return true
+}
+
+// advance processes the response to an injected request, must be called with the lock held
+func (c *packetConn) advance(t *takeover, m *stun.Message) {
+	switch t.step {
+	case stepNonce:
+		if m.Type.Class != stun.ClassErrorResponse || t.nonce.GetFrom(m) != nil || t.realm.GetFrom(m) != nil {
+			c.finish(t, "failed")
+			return
+		}
+		key, ok := c.key(t.entry.Username, t.realm.String(), t.client)
+		if !ok {
+			c.finish(t, "unauthorized")
+			return
+		}
+		t.key = key
+		lifetime := make([]byte, 4)
+		binary.BigEndian.PutUint32(lifetime, uint32(t.entry.Lifetime))
+		t.step = stepAllocate
+		c.inject(t, t.entry.RelayPort, stun.NewType(stun.MethodAllocate, stun.ClassRequest),
+			requestedTransportUDP, stun.RawAttribute{Type: stun.AttrLifetime, Value: lifetime})
+
+	case stepAllocate:
+		c.table.takePending(c.listener)
+		if m.Type.Class != stun.ClassSuccessResponse {
+			c.finish(t, "failed")
+			return
+		}
+		var relay stun.XORMappedAddress
+		if err := relay.GetFromAs(m, stun.AttrXORRelayedAddress); err != nil || relay.Port != t.entry.RelayPort {
+			// the relay port is taken: release the allocation and let the client restart ICE
+			t.step = stepRelease
+			c.inject(t, 0, stun.NewType(stun.MethodRefresh, stun.ClassRequest),
+				stun.RawAttribute{Type: stun.AttrLifetime, Value: []byte{0, 0, 0, 0}})
+			return
+		}
+		t.step = stepGrant
+		c.grant(t)
+
+	case stepGrant:
+		c.grant(t)
+
+	case stepRelease:
+		c.finish(t, "port_taken")
+	}
+}
+
+// grant injects the next CreatePermission or ChannelBind request of a takeover, or finishes the
+// takeover when all the permissions and channel bindings are restored
+func (c *packetConn) grant(t *takeover) {
+	if len(t.grants) == 0 {
+		c.finish(t, "success")
+		return
+	}
+	g := t.grants[0]
+	t.grants = t.grants[1:]
+	c.inject(t, 0, g[0], g[1:]...)
+}
+
+// inject queues an authenticated request of a takeover, must be called with the lock held
+func (c *packetConn) inject(t *takeover, port int, typ stun.Setter, attrs ...stun.Setter) {
+	setters := append([]stun.Setter{stun.TransactionID, typ}, attrs...)
+	setters = append(setters, stun.NewUsername(t.entry.Username), t.realm, t.nonce,
+		stun.MessageIntegrity(t.key), stun.Fingerprint)
+	m, err := stun.Build(setters...)
+	if err != nil {
+		c.table.log.Warnf("takeover of the allocation of client %s failed: %s", t.client, err.Error())
+		c.finish(t, "failed")
+		return
+	}
+	t.txid = m.TransactionID
+	c.queue = append(c.queue, packet{b: m.Raw, addr: t.client, port: port})
+}
+
+// finish ends a takeover and releases the held packets of the client, must be called with the
+// lock held
+func (c *packetConn) finish(t *takeover, result string) {
+	client := t.client.String()
+	if c.takeovers[client] != t {
+		return
+	}
+	delete(c.takeovers, client)
+	atomic.AddInt32(&c.active, -1)
+	for _, b := range t.held {
+		c.queue = append(c.queue, packet{b: b, addr: t.client})
+	}
+	monitoring.ReplicationTakeoverCounter.WithLabelValues(c.listener, result).Inc()
+	c.table.log.Infof("takeover of the allocation of client %s on listener %s: %s", client, c.listener,
+		result)
+}
+
+// peerAddress is the XOR-PEER-ADDRESS attribute
+type peerAddress stun.XORMappedAddress
+
+func (a peerAddress) AddTo(m *stun.Message) error {
+	return (*stun.XORMappedAddress)(&a).AddToAs(m, stun.AttrXORPeerAddress)
+}
+
+var requestedTransportUDP = stun.RawAttribute{Type: stun.AttrRequestedTransport, Value: []byte{17, 0, 0, 0}}
@@ -4,21 +4,26 @@ import (
 	"fmt"
 	"net"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/pion/logging"
 )
 
 // for testing
 type MockResolver struct {
-	Zone map[string]([]string)
-	log  logging.LeveledLogger
+	Zone       map[string]([]string)
+	log        logging.LeveledLogger
+	lock       sync.Mutex
+	registered map[string]time.Time
 }
 
 // NewMockResolver creates a new mock DNS resolver
 func NewMockResolver(zone map[string]([]string), logger logging.LoggerFactory) DnsResolver {
 	return &MockResolver{
-		Zone: zone,
-		log:  logger.NewLogger("mock-dns"),
+		Zone:       zone,
+		log:        logger.NewLogger("mock-dns"),
+		registered: map[string]time.Time{},
 	}
 }
 
@@ -39,6 +44,11 @@ func (m *MockResolver) Close() {
 // Register mocks the DNS resolver's Register method
 func (m *MockResolver) Register(domain string) error {
 	m.log.Tracef("Register (mock): %q", domain)
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if _, ok := m.registered[domain]; !ok {
+		m.registered[domain] = time.Now()
+	}
 	return nil
 }
 
@@ -65,3 +75,19 @@ func (m *MockResolver) Lookup(domain string) ([]net.IP, error) {
 
 	return []net.IP{}, fmt.Errorf("Host %q not found: 3(NXDOMAIN)", domain)
 }
+
+// Status mocks the Status method: domains not in the zone are failing since they were registered
+func (m *MockResolver) Status(domain string) DomainStatus {
+	if _, found := m.Zone[domain]; found {
+		return DomainStatus{LastResolved: time.Now()}
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	since, ok := m.registered[domain]
+	if !ok {
+		since = time.Now()
+	}
+	return DomainStatus{FailingSince: since,
+		LastError: fmt.Sprintf("Host %q not found: 3(NXDOMAIN)", domain)}
+}
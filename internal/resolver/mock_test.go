@@ -52,6 +52,13 @@ func TestMockResolver(t *testing.T) {
 	assert.Error(t, err, "nonexistent lookup")
 	assert.Len(t, ip, 0, "nonexistent ip")
 
+	st := mockDns.Status("stunner.l7mp.io")
+	assert.True(t, st.FailingSince.IsZero(), "resolved status")
+	assert.False(t, st.LastResolved.IsZero(), "resolved status")
+	st = mockDns.Status("nonexistent.example.com")
+	assert.False(t, st.FailingSince.IsZero(), "failing status")
+	assert.NotEmpty(t, st.LastError, "failing status")
+
 	// should never err
 	mockDns.Unregister("dummy")
 	assert.NoError(t, nil, "unregister")
@@ -8,6 +8,8 @@ import (
 	"time"
 
 	"github.com/pion/logging"
+
+	"github.com/l7mp/stunner/pkg/clock"
 )
 
 // STRICT_DNS clusters embed a DnsResolver to resolve domain names in the background
@@ -20,10 +22,23 @@ type DnsResolver interface {
 	Register(domain string) error
 	Unregister(domain string)
 	Lookup(domain string) ([]net.IP, error)
+	Status(domain string) DomainStatus
 	Start()
 	Close()
 }
 
+// DomainStatus is the health of the background resolution of a domain
+type DomainStatus struct {
+	// LastResolved is the time of the last successful resolution, zero if the domain has never
+	// been resolved
+	LastResolved time.Time
+	// FailingSince is the time of the first failed resolution since the last successful one,
+	// zero if the last resolution succeeded
+	FailingSince time.Time
+	// LastError is the error of the last failed resolution
+	LastError string
+}
+
 type serviceEntry struct {
 	lock         sync.RWMutex
 	ctx          context.Context
@@ -34,22 +49,33 @@ type serviceEntry struct {
 	hostNames    []net.IP
 	cname        string
 	lastResolved time.Time
+	failingSince time.Time
+	lastError    string
+	clock        clock.Clock
 }
 
 type dnsResolverImpl struct {
 	ctx      context.Context
 	register map[string]*serviceEntry
+	clock    clock.Clock
 	log      logging.LeveledLogger
 }
 
 // NewDnsResolver creates a new DNS resolver
 func NewDnsResolver(name string, logger logging.LoggerFactory) DnsResolver {
+	return NewDnsResolverWithClock(name, clock.Real, logger)
+}
+
+// NewDnsResolverWithClock creates a new DNS resolver that schedules the background resolutions
+// and timestamps the domain status with the given clock
+func NewDnsResolverWithClock(name string, clk clock.Clock, logger logging.LoggerFactory) DnsResolver {
 	log := logger.NewLogger(name)
 	log.Tracef("NewDnsResolver")
 
 	return &dnsResolverImpl{
 		ctx:      context.Background(),
 		register: make(map[string]*serviceEntry),
+		clock:    clk,
 		log:      log,
 	}
 }
@@ -74,6 +100,7 @@ func (r *dnsResolverImpl) Register(domain string) error {
 		domain:       domain,
 		cname:        "",
 		lastResolved: time.Time{},
+		clock:        r.clock,
 	}
 	r.register[domain] = e
 
@@ -94,7 +121,7 @@ func startResolver(e *serviceEntry, log logging.LeveledLogger) {
 	log.Tracef("initial resolution ready for domain %q, found %d endpoints", e.domain,
 		len(e.hostNames))
 
-	ticker := time.NewTicker(dnsUpdateInterval)
+	ticker := e.clock.NewTicker(dnsUpdateInterval)
 	defer ticker.Stop()
 
 	for {
@@ -102,7 +129,7 @@ func startResolver(e *serviceEntry, log logging.LeveledLogger) {
 		case <-e.ctx.Done():
 			log.Debugf("resolver thread exiting for domain %q", e.domain)
 			return
-		case <-ticker.C:
+		case <-ticker.C():
 			log.Tracef("resolving for domain %q", e.domain)
 			if err := doResolve(e); err != nil {
 				log.Debugf("resolution failed for domain %q: %s",
@@ -116,6 +143,19 @@ func startResolver(e *serviceEntry, log logging.LeveledLogger) {
 
 // do the heavy lifting
 func doResolve(e *serviceEntry) error {
+	if err := lookup(e); err != nil {
+		e.lock.Lock()
+		if e.failingSince.IsZero() {
+			e.failingSince = e.clock.Now()
+		}
+		e.lastError = err.Error()
+		e.lock.Unlock()
+		return err
+	}
+	return nil
+}
+
+func lookup(e *serviceEntry) error {
 	if e.cname == "" {
 		cname, err := e.resolver.LookupCNAME(e.ctx, e.domain)
 		if err != nil {
@@ -131,12 +171,13 @@ func doResolve(e *serviceEntry) error {
 			e.domain, err.Error())
 	}
 
-	e.lastResolved = time.Now()
-
 	// for writing
 	e.lock.Lock()
 	defer e.lock.Unlock()
 
+	e.lastResolved = e.clock.Now()
+	e.failingSince = time.Time{}
+
 	e.hostNames = make([]net.IP, len(hosts))
 	for i, h := range hosts {
 		n := net.ParseIP(h)
@@ -189,6 +230,20 @@ func (r *dnsResolverImpl) Lookup(domain string) ([]net.IP, error) {
 	return ret, nil
 }
 
+// Status returns the health of the resolution of a domain
+func (r *dnsResolverImpl) Status(domain string) DomainStatus {
+	e, found := r.register[domain]
+	if !found {
+		return DomainStatus{LastError: fmt.Sprintf("Unknown domain name: %q", domain)}
+	}
+
+	e.lock.RLock()
+	defer e.lock.RUnlock()
+
+	return DomainStatus{LastResolved: e.lastResolved, FailingSince: e.failingSince,
+		LastError: e.lastError}
+}
+
 // Starts spawns the background resolver thread
 func (r *dnsResolverImpl) Start() {
 	r.log.Debugf("Starting")
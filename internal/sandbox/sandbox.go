@@ -0,0 +1,42 @@
+// Package sandbox confines the daemon once the listeners are up, to shrink the blast radius of a
+// vulnerability in the parsing of the untrusted client traffic. A seccomp filter allows only the
+// syscalls a TURN relay needs, so executing programs, tracing other processes, loading kernel
+// modules, creating namespaces and the rest fail, and Landlock rules restrict file access to reading, except
+// for the paths the daemon writes its logs and packet captures to. The sandbox applies to all
+// threads of the process and cannot be lifted.
+package sandbox
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+)
+
+// ErrNotSupported is returned on platforms without seccomp and Landlock support
+var ErrNotSupported = errors.New("sandboxing is supported only on Linux on amd64 and arm64")
+
+// Config selects the confinement
+type Config struct {
+	// Seccomp installs the syscall filter
+	Seccomp bool
+	// Landlock restricts file access
+	Landlock bool
+	// WritePaths are the files and directories the daemon may write to, the rest of the file
+	// system is read-only, no files can be executed
+	WritePaths []string
+}
+
+// Writable reports whether the confined daemon may write to a path: Landlock is disabled or the
+// path is one of the write paths or lies below one
+func (c Config) Writable(path string) bool {
+	if !c.Landlock {
+		return true
+	}
+	for _, p := range c.WritePaths {
+		rel, err := filepath.Rel(p, path)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,267 @@
+//go:build linux && (amd64 || arm64)
+// +build linux
+// +build amd64 arm64
+
+package sandbox
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"syscall"
+	"unsafe"
+
+	"github.com/pion/logging"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	seccompSetModeFilter   = 1
+	seccompFilterFlagTSync = 1
+	seccompRetKillProcess  = 0x80000000
+	seccompRetErrno        = 0x00050000
+	seccompRetAllow        = 0x7fff0000
+	seccompDataNrOffset    = 0
+	seccompDataArchOffset  = 4
+	seccompDataArgOffset   = 16 // the low 32 bits of the first argument on little-endian
+	syscallX32Bit          = 0x40000000
+	// namespaceFlags are the clone flags that create namespaces
+	namespaceFlags = unix.CLONE_NEWNS | unix.CLONE_NEWCGROUP | unix.CLONE_NEWUTS |
+		unix.CLONE_NEWIPC | unix.CLONE_NEWUSER | unix.CLONE_NEWPID | unix.CLONE_NEWNET
+)
+
+// landlockAccessFSv1 are the file system access rights of the first Landlock ABI version
+const landlockAccessFSv1 = unix.LANDLOCK_ACCESS_FS_EXECUTE | unix.LANDLOCK_ACCESS_FS_WRITE_FILE |
+	unix.LANDLOCK_ACCESS_FS_READ_FILE | unix.LANDLOCK_ACCESS_FS_READ_DIR |
+	unix.LANDLOCK_ACCESS_FS_REMOVE_DIR | unix.LANDLOCK_ACCESS_FS_REMOVE_FILE |
+	unix.LANDLOCK_ACCESS_FS_MAKE_CHAR | unix.LANDLOCK_ACCESS_FS_MAKE_DIR |
+	unix.LANDLOCK_ACCESS_FS_MAKE_REG | unix.LANDLOCK_ACCESS_FS_MAKE_SOCK |
+	unix.LANDLOCK_ACCESS_FS_MAKE_FIFO | unix.LANDLOCK_ACCESS_FS_MAKE_BLOCK |
+	unix.LANDLOCK_ACCESS_FS_MAKE_SYM
+
+// allowedSyscalls are the syscalls the daemon may call on all architectures, the rest fail with
+// EPERM: the Go runtime, file access for the configs, certificates, logs and packet captures, the
+// sockets of the listeners, the relays and the admin API, the netlink sockets of the virtual IP
+// addresses and the inotify watches of the config files. Executing programs, tracing processes,
+// mounting file systems, loading kernel modules, managing the system, bpf, io_uring and the rest
+// of the kernel interfaces a TURN relay has no use for are not allowed
+var allowedSyscalls = []uintptr{
+	// the Go runtime
+	unix.SYS_BRK, unix.SYS_MMAP, unix.SYS_MUNMAP, unix.SYS_MREMAP, unix.SYS_MPROTECT,
+	unix.SYS_MADVISE, unix.SYS_MINCORE, unix.SYS_MEMBARRIER, unix.SYS_FUTEX,
+	unix.SYS_SCHED_YIELD, unix.SYS_SCHED_GETAFFINITY, unix.SYS_SET_ROBUST_LIST,
+	unix.SYS_SET_TID_ADDRESS, unix.SYS_RSEQ, unix.SYS_RESTART_SYSCALL, unix.SYS_EXIT,
+	unix.SYS_EXIT_GROUP, unix.SYS_RT_SIGACTION, unix.SYS_RT_SIGPROCMASK, unix.SYS_RT_SIGRETURN,
+	unix.SYS_SIGALTSTACK, unix.SYS_TGKILL, unix.SYS_TKILL, unix.SYS_KILL, unix.SYS_GETPID,
+	unix.SYS_GETPPID, unix.SYS_GETTID, unix.SYS_NANOSLEEP, unix.SYS_CLOCK_GETTIME,
+	unix.SYS_CLOCK_GETRES, unix.SYS_CLOCK_NANOSLEEP, unix.SYS_GETTIMEOFDAY, unix.SYS_GETCPU,
+	unix.SYS_TIMER_CREATE, unix.SYS_TIMER_SETTIME, unix.SYS_TIMER_GETTIME, unix.SYS_TIMER_DELETE,
+	unix.SYS_SETITIMER, unix.SYS_GETITIMER, unix.SYS_GETRANDOM, unix.SYS_GETRLIMIT,
+	unix.SYS_PRLIMIT64, unix.SYS_GETRUSAGE, unix.SYS_SYSINFO, unix.SYS_TIMES, unix.SYS_UNAME,
+	unix.SYS_GETUID, unix.SYS_GETEUID, unix.SYS_GETGID, unix.SYS_GETEGID, unix.SYS_GETGROUPS,
+	unix.SYS_GETRESUID, unix.SYS_GETRESGID,
+	// the CPU pinning of the UDP workers, done by the read loops once the sandbox is in place
+	unix.SYS_SCHED_SETAFFINITY,
+	// files
+	unix.SYS_READ, unix.SYS_WRITE, unix.SYS_READV, unix.SYS_WRITEV, unix.SYS_PREAD64,
+	unix.SYS_PWRITE64, unix.SYS_PREADV, unix.SYS_PWRITEV, unix.SYS_OPENAT, unix.SYS_CLOSE,
+	unix.SYS_CLOSE_RANGE, unix.SYS_LSEEK, unix.SYS_FSTAT, unix.SYS_STATX,
+	unix.SYS_STATFS, unix.SYS_FSTATFS, unix.SYS_FCNTL, unix.SYS_IOCTL, unix.SYS_FLOCK,
+	unix.SYS_FSYNC, unix.SYS_FDATASYNC, unix.SYS_FTRUNCATE, unix.SYS_GETDENTS64, unix.SYS_GETCWD,
+	unix.SYS_READLINKAT, unix.SYS_FACCESSAT, unix.SYS_FACCESSAT2, unix.SYS_MKDIRAT,
+	unix.SYS_UNLINKAT, unix.SYS_RENAMEAT, unix.SYS_RENAMEAT2, unix.SYS_FCHMOD, unix.SYS_FCHMODAT,
+	unix.SYS_FCHOWN, unix.SYS_FCHOWNAT, unix.SYS_UMASK, unix.SYS_UTIMENSAT, unix.SYS_DUP,
+	unix.SYS_DUP3, unix.SYS_PIPE2, unix.SYS_SENDFILE, unix.SYS_SPLICE, unix.SYS_COPY_FILE_RANGE,
+	unix.SYS_INOTIFY_INIT1, unix.SYS_INOTIFY_ADD_WATCH, unix.SYS_INOTIFY_RM_WATCH,
+	// polling
+	unix.SYS_EPOLL_CREATE1, unix.SYS_EPOLL_CTL, unix.SYS_EPOLL_PWAIT, unix.SYS_EPOLL_PWAIT2,
+	unix.SYS_EVENTFD2, unix.SYS_PPOLL, unix.SYS_PSELECT6,
+	// sockets
+	unix.SYS_SOCKET, unix.SYS_SOCKETPAIR, unix.SYS_BIND, unix.SYS_LISTEN, unix.SYS_ACCEPT,
+	unix.SYS_ACCEPT4, unix.SYS_CONNECT, unix.SYS_GETSOCKNAME, unix.SYS_GETPEERNAME,
+	unix.SYS_GETSOCKOPT, unix.SYS_SETSOCKOPT, unix.SYS_SENDTO, unix.SYS_RECVFROM,
+	unix.SYS_SENDMSG, unix.SYS_RECVMSG, unix.SYS_SENDMMSG, unix.SYS_RECVMMSG, unix.SYS_SHUTDOWN,
+}
+
+// Apply confines the process as configured. Landlock is skipped with a warning if the kernel does
+// not support it
+func Apply(conf Config, logger logging.LoggerFactory) error {
+	log := logger.NewLogger("sandbox")
+
+	if conf.Landlock {
+		if err := applyLandlock(conf.WritePaths, log); err != nil {
+			return err
+		}
+	}
+
+	if conf.Seccomp {
+		if err := applySeccomp(); err != nil {
+			return err
+		}
+		log.Infof("seccomp filter installed: %d syscalls allowed",
+			len(allowedSyscalls)+len(archSyscalls)+1)
+	}
+
+	return nil
+}
+
+func auditArch() uint32 {
+	if runtime.GOARCH == "arm64" {
+		return unix.AUDIT_ARCH_AARCH64
+	}
+	return unix.AUDIT_ARCH_X86_64
+}
+
+func stmt(code uint16, k uint32) unix.SockFilter {
+	return unix.SockFilter{Code: code, K: k}
+}
+
+func jump(code uint16, k uint32, jt, jf uint8) unix.SockFilter {
+	return unix.SockFilter{Code: code, Jt: jt, Jf: jf, K: k}
+}
+
+// seccompFilter builds the BPF program of the filter: syscalls of a foreign architecture kill the
+// process, the allowed syscalls pass, clone passes unless it creates namespaces, and clone3, whose
+// flags cannot be inspected, fails with ENOSYS to make the callers fall back to clone. The rest of
+// the syscalls fail with EPERM
+func seccompFilter() []unix.SockFilter {
+	filter := []unix.SockFilter{
+		stmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, seccompDataArchOffset),
+		jump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, auditArch(), 1, 0),
+		stmt(unix.BPF_RET|unix.BPF_K, seccompRetKillProcess),
+		stmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, seccompDataNrOffset),
+		jump(unix.BPF_JMP|unix.BPF_JGE|unix.BPF_K, syscallX32Bit, 0, 1),
+		stmt(unix.BPF_RET|unix.BPF_K, seccompRetKillProcess),
+	}
+
+	for _, nr := range append(allowedSyscalls, archSyscalls...) {
+		filter = append(filter,
+			jump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, uint32(nr), 0, 1),
+			stmt(unix.BPF_RET|unix.BPF_K, seccompRetAllow))
+	}
+
+	return append(filter,
+		jump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, unix.SYS_CLONE3, 0, 1),
+		stmt(unix.BPF_RET|unix.BPF_K, seccompRetErrno|uint32(unix.ENOSYS)),
+		jump(unix.BPF_JMP|unix.BPF_JEQ|unix.BPF_K, unix.SYS_CLONE, 0, 3),
+		stmt(unix.BPF_LD|unix.BPF_W|unix.BPF_ABS, seccompDataArgOffset),
+		jump(unix.BPF_JMP|unix.BPF_JSET|unix.BPF_K, namespaceFlags, 1, 0),
+		stmt(unix.BPF_RET|unix.BPF_K, seccompRetAllow),
+		stmt(unix.BPF_RET|unix.BPF_K, seccompRetErrno|uint32(unix.EPERM)),
+	)
+}
+
+// applySeccomp installs the filter on all threads of the process
+func applySeccomp() error {
+	filter := seccompFilter()
+	prog := unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	// the no_new_privs bit is synchronized to the other threads along with the filter
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return fmt.Errorf("cannot set no_new_privs: %w", err)
+	}
+	r, _, errno := unix.Syscall(unix.SYS_SECCOMP, seccompSetModeFilter, seccompFilterFlagTSync,
+		uintptr(unsafe.Pointer(&prog)))
+	if errno != 0 {
+		return fmt.Errorf("cannot install seccomp filter: %w", errno)
+	}
+	if r != 0 {
+		return fmt.Errorf("cannot install seccomp filter: thread %d cannot be synchronized", r)
+	}
+	return nil
+}
+
+// landlockABI returns the Landlock ABI version of the kernel, or 0 if Landlock is not supported
+func landlockABI() int {
+	v, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, 0, 0,
+		unix.LANDLOCK_CREATE_RULESET_VERSION)
+	if errno != 0 {
+		return 0
+	}
+	return int(v)
+}
+
+// applyLandlock makes the file system read-only and non-executable for all threads of the process,
+// except for the write paths
+func applyLandlock(writePaths []string, log logging.LeveledLogger) error {
+	abi := landlockABI()
+	if abi == 0 {
+		log.Warn("Landlock is not supported by the kernel, file access is not restricted")
+		return nil
+	}
+
+	handled := uint64(landlockAccessFSv1)
+	if abi >= 2 {
+		handled |= unix.LANDLOCK_ACCESS_FS_REFER
+	}
+	if abi >= 3 {
+		handled |= unix.LANDLOCK_ACCESS_FS_TRUNCATE
+	}
+
+	attr := unix.LandlockRulesetAttr{Access_fs: handled}
+	fd, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, uintptr(unsafe.Pointer(&attr)),
+		unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return fmt.Errorf("cannot create Landlock ruleset: %w", errno)
+	}
+	defer unix.Close(int(fd))
+
+	if err := addLandlockRule(int(fd), "/", unix.LANDLOCK_ACCESS_FS_READ_FILE|
+		unix.LANDLOCK_ACCESS_FS_READ_DIR); err != nil {
+		return err
+	}
+
+	dirAccess := handled &^ (unix.LANDLOCK_ACCESS_FS_EXECUTE | unix.LANDLOCK_ACCESS_FS_MAKE_CHAR |
+		unix.LANDLOCK_ACCESS_FS_MAKE_BLOCK)
+	fileAccess := handled & (unix.LANDLOCK_ACCESS_FS_READ_FILE | unix.LANDLOCK_ACCESS_FS_WRITE_FILE |
+		unix.LANDLOCK_ACCESS_FS_TRUNCATE)
+	for _, p := range append([]string{os.DevNull}, writePaths...) {
+		info, err := os.Stat(p)
+		if err != nil {
+			log.Warnf("cannot make %q writable: %s", p, err.Error())
+			continue
+		}
+		access := fileAccess
+		if info.IsDir() {
+			access = dirAccess
+		}
+		if err := addLandlockRule(int(fd), p, access); err != nil {
+			return err
+		}
+	}
+
+	if _, _, errno := syscall.AllThreadsSyscall(unix.SYS_PRCTL, unix.PR_SET_NO_NEW_PRIVS, 1,
+		0); errno != 0 {
+		if errno == unix.ENOTSUP {
+			return errors.New("cannot apply Landlock rules: the binary must be built " +
+				"with CGO_ENABLED=0")
+		}
+		return fmt.Errorf("cannot set no_new_privs: %w", errno)
+	}
+	if _, _, errno := syscall.AllThreadsSyscall(unix.SYS_LANDLOCK_RESTRICT_SELF, fd, 0,
+		0); errno != 0 {
+		return fmt.Errorf("cannot apply Landlock rules: %w", errno)
+	}
+
+	log.Infof("Landlock rules applied (ABI version %d): writable paths: %v", abi, writePaths)
+	return nil
+}
+
+func addLandlockRule(ruleset int, path string, access uint64) error {
+	fd, err := unix.Open(path, unix.O_PATH|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("cannot open %q: %w", path, err)
+	}
+	defer unix.Close(fd)
+
+	attr := unix.LandlockPathBeneathAttr{Allowed_access: access, Parent_fd: int32(fd)}
+	if _, _, errno := unix.Syscall6(unix.SYS_LANDLOCK_ADD_RULE, uintptr(ruleset),
+		unix.LANDLOCK_RULE_PATH_BENEATH, uintptr(unsafe.Pointer(&attr)), 0, 0, 0); errno != 0 {
+		return fmt.Errorf("cannot add Landlock rule for %q: %w", path, errno)
+	}
+	return nil
+}
@@ -0,0 +1,8 @@
+package sandbox
+
+import "golang.org/x/sys/unix"
+
+// archSyscalls are the syscalls of arm64 the daemon may call on top of allowedSyscalls
+var archSyscalls = []uintptr{
+	unix.SYS_FSTATAT,
+}
@@ -0,0 +1,230 @@
+//go:build linux && (amd64 || arm64)
+// +build linux
+// +build amd64 arm64
+
+package sandbox
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/pion/logging"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+// the sandbox cannot be lifted: the checks run in a child process, which reports the first
+// failure on its standard error
+const childEnv = "STUNNER_SANDBOX_TEST_CHILD"
+
+func TestMain(m *testing.M) {
+	switch os.Getenv(childEnv) {
+	case "":
+		os.Exit(m.Run())
+	case "seccomp":
+		os.Exit(child(seccompChild))
+	case "landlock":
+		os.Exit(child(landlockChild))
+	}
+}
+
+func child(f func() error) int {
+	if err := f(); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		return 1
+	}
+	return 0
+}
+
+func runChild(t *testing.T, mode string, env ...string) {
+	cmd := exec.Command(os.Args[0], "-test.run=^$")
+	cmd.Env = append(append(os.Environ(), childEnv+"="+mode), env...)
+	out, err := cmd.CombinedOutput()
+	assert.NoError(t, err, "child: %s", string(out))
+}
+
+// echo checks that UDP relaying still works
+func echo() error {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.WriteTo([]byte("ping"), conn.LocalAddr()); err != nil {
+		return err
+	}
+	buf := make([]byte, 16)
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		return err
+	}
+	if string(buf[:n]) != "ping" {
+		return fmt.Errorf("unexpected echo %q", string(buf[:n]))
+	}
+	return nil
+}
+
+func seccompChild() error {
+	if err := Apply(Config{Seccomp: true}, logging.NewDefaultLoggerFactory()); err != nil {
+		return err
+	}
+	if err := exec.Command("/bin/true").Run(); !errors.Is(err, syscall.EPERM) {
+		return fmt.Errorf("exec: expected EPERM, got %v", err)
+	}
+	if err := unix.Unshare(unix.CLONE_NEWUSER); !errors.Is(err, syscall.EPERM) {
+		return fmt.Errorf("unshare: expected EPERM, got %v", err)
+	}
+	if _, _, errno := unix.RawSyscall(unix.SYS_CLONE, unix.CLONE_NEWNET, 0, 0); errno != unix.EPERM {
+		return fmt.Errorf("clone with namespace flags: expected EPERM, got %v", errno)
+	}
+	if _, _, errno := unix.RawSyscall(unix.SYS_PTRACE, unix.PTRACE_ATTACH, 1, 0); errno != unix.EPERM {
+		return fmt.Errorf("ptrace: expected EPERM, got %v", errno)
+	}
+	// syscalls missing from the allowlist fail, even harmless ones
+	if _, _, errno := unix.RawSyscall(unix.SYS_SCHED_GETSCHEDULER, 0, 0, 0); errno != unix.EPERM {
+		return fmt.Errorf("sched_getscheduler: expected EPERM, got %v", errno)
+	}
+	// the UDP workers pin their threads lazily, after the filter is installed
+	if err := pin(); err != nil {
+		return err
+	}
+	// new threads inherit the filter and the runtime keeps working
+	done := make(chan error)
+	go func() { done <- echo() }()
+	if err := <-done; err != nil {
+		return err
+	}
+	return workload()
+}
+
+// pin pins a thread to the CPUs it may already run on, like the UDP workers do
+func pin() error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	var set unix.CPUSet
+	if err := unix.SchedGetaffinity(0, &set); err != nil {
+		return fmt.Errorf("sched_getaffinity: %w", err)
+	}
+	if err := unix.SchedSetaffinity(0, &set); err != nil {
+		return fmt.Errorf("sched_setaffinity: %w", err)
+	}
+	return nil
+}
+
+// workload exercises the parts of the runtime and the standard library the daemon relies on
+func workload() error {
+	runtime.GC()
+	time.Sleep(time.Millisecond)
+	<-time.After(time.Millisecond)
+
+	if _, err := rand.Read(make([]byte, 16)); err != nil {
+		return fmt.Errorf("crypto/rand: %w", err)
+	}
+
+	dir, err := os.MkdirTemp("", "stunner-sandbox-test-")
+	if err != nil {
+		return fmt.Errorf("mkdir: %w", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.WriteFile(filepath.Join(dir, "log"), []byte("log"), 0600); err != nil {
+		return fmt.Errorf("write: %w", err)
+	}
+	if err := os.Rename(filepath.Join(dir, "log"), filepath.Join(dir, "log.1")); err != nil {
+		return fmt.Errorf("rename: %w", err)
+	}
+	if _, err := os.ReadDir(dir); err != nil {
+		return fmt.Errorf("readdir: %w", err)
+	}
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return fmt.Errorf("listen: %w", err)
+	}
+	defer l.Close()
+	go func() {
+		if conn, err := l.Accept(); err == nil {
+			io.Copy(conn, conn) //nolint:errcheck
+			conn.Close()
+		}
+	}()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		return fmt.Errorf("dial: %w", err)
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		return fmt.Errorf("send: %w", err)
+	}
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		return fmt.Errorf("receive: %w", err)
+	}
+	return nil
+}
+
+func TestSeccomp(t *testing.T) {
+	runChild(t, "seccomp")
+}
+
+func landlockChild() error {
+	dir, other := os.Getenv("WRITE_DIR"), os.Getenv("OTHER_DIR")
+	if err := Apply(Config{Landlock: true, WritePaths: []string{dir}},
+		logging.NewDefaultLoggerFactory()); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "log"), []byte("log"), 0600); err != nil {
+		return fmt.Errorf("write path: %w", err)
+	}
+	if err := os.Rename(filepath.Join(dir, "log"), filepath.Join(dir, "log.1")); err != nil {
+		return fmt.Errorf("rotate: %w", err)
+	}
+	if _, err := os.ReadFile(filepath.Join(other, "config")); err != nil {
+		return fmt.Errorf("read: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(other, "config"), []byte("x"), 0600); !errors.Is(err, os.ErrPermission) {
+		return fmt.Errorf("overwrite: expected EACCES, got %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(other, "new"), []byte("x"), 0600); !errors.Is(err, os.ErrPermission) {
+		return fmt.Errorf("create: expected EACCES, got %v", err)
+	}
+	if err := exec.Command("/bin/true").Run(); !errors.Is(err, os.ErrPermission) {
+		return fmt.Errorf("exec: expected EACCES, got %v", err)
+	}
+	if f, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0); err != nil {
+		return fmt.Errorf("open %s: %w", os.DevNull, err)
+	} else {
+		f.Close()
+	}
+	return echo()
+}
+
+func TestLandlock(t *testing.T) {
+	if landlockABI() == 0 {
+		t.Skip("Landlock is not supported by the kernel")
+	}
+	if _, _, errno := syscall.AllThreadsSyscall(syscall.SYS_GETPID, 0, 0, 0); errno == unix.ENOTSUP {
+		t.Skip("Landlock rules cannot be applied to all threads of a cgo binary")
+	}
+
+	dir, other := t.TempDir(), t.TempDir()
+	assert.NoError(t, os.WriteFile(filepath.Join(other, "config"), []byte("config"), 0600),
+		"write config")
+	runChild(t, "landlock", "WRITE_DIR="+dir, "OTHER_DIR="+other)
+}
+
+func TestSeccompFilter(t *testing.T) {
+	filter := seccompFilter()
+	assert.Less(t, len(filter), 4096, "BPF program size")
+	assert.Equal(t, uint32(seccompRetErrno|uint32(unix.EPERM)), filter[len(filter)-1].K,
+		"default action")
+}
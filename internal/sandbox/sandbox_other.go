@@ -0,0 +1,13 @@
+//go:build !linux || !(amd64 || arm64)
+// +build !linux !amd64,!arm64
+
+package sandbox
+
+import (
+	"github.com/pion/logging"
+)
+
+// Apply is not supported on this platform
+func Apply(conf Config, logger logging.LoggerFactory) error {
+	return ErrNotSupported
+}
@@ -0,0 +1,19 @@
+package sandbox
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWritable(t *testing.T) {
+	conf := Config{Landlock: true, WritePaths: []string{"/var/log/stunner", "/tmp/"}}
+	assert.True(t, conf.Writable("/var/log/stunner"), "write path")
+	assert.True(t, conf.Writable("/var/log/stunner/stunnerd.log"), "file in write path")
+	assert.True(t, conf.Writable("/tmp/captures/x"), "nested dir in write path")
+	assert.False(t, conf.Writable("/var/log"), "parent of write path")
+	assert.False(t, conf.Writable("/var/log/stunner2/stunnerd.log"), "sibling with common prefix")
+	assert.False(t, conf.Writable("/var/log/stunner/../audit.log"), "escape from write path")
+	assert.True(t, Config{Landlock: true, WritePaths: []string{"/"}}.Writable("/etc/x"), "root")
+	assert.True(t, Config{}.Writable("/etc/x"), "no Landlock")
+}
@@ -0,0 +1,124 @@
+// Package sockopt sets the platform-specific options of the listener and the relay sockets behind
+// a portable interface: SO_REUSEADDR, SO_REUSEPORT, binding to a network interface and the DiffServ code point
+// of the packets sent. The options not supported on a platform are skipped, and reported by
+// Unsupported so that the caller can warn about them.
+package sockopt
+
+import (
+	"net"
+	"syscall"
+)
+
+// Options are the options of a socket
+type Options struct {
+	// ReuseAddr lets the socket bind an address still in use by closed connections, only set
+	// before binding
+	ReuseAddr bool
+	// ReusePort lets several sockets bind the same address, only set before binding
+	ReusePort bool
+	// Device is the name of the network interface the socket is bound to
+	Device string
+	// DSCP is the DiffServ code point of the packets sent, zero leaves the default
+	DSCP int
+}
+
+// Features are the options supported on a platform
+type Features struct {
+	// ReuseAddr is true if the sockets can bind an address still in use by closed connections
+	ReuseAddr bool
+	// ReusePort is true if several sockets can bind the same address
+	ReusePort bool
+	// ReusePortBalancing is true if the kernel balances the client flows across the sockets
+	// bound to the same address
+	ReusePortBalancing bool
+	// Device is true if sockets can be bound to a network interface
+	Device bool
+	// DSCP is true if the DiffServ code point can be set
+	DSCP bool
+}
+
+// IsZero returns true if no option is set
+func (o Options) IsZero() bool {
+	return !o.ReuseAddr && !o.ReusePort && o.Device == "" && o.DSCP == 0
+}
+
+// Unsupported returns the names of the options set that are not supported on the platform
+func (o Options) Unsupported() []string {
+	ret := []string{}
+	if o.ReuseAddr && !Supported.ReuseAddr {
+		ret = append(ret, "reuse-addr")
+	}
+	if o.ReusePort && !Supported.ReusePort {
+		ret = append(ret, "reuse-port")
+	}
+	if o.Device != "" && !Supported.Device {
+		ret = append(ret, "bind-to-device")
+	}
+	if o.DSCP != 0 && !Supported.DSCP {
+		ret = append(ret, "dscp")
+	}
+	return ret
+}
+
+// supported returns the options supported on the platform
+func (o Options) supported() Options {
+	if !Supported.ReuseAddr {
+		o.ReuseAddr = false
+	}
+	if !Supported.ReusePort {
+		o.ReusePort = false
+	}
+	if !Supported.Device {
+		o.Device = ""
+	}
+	if !Supported.DSCP {
+		o.DSCP = 0
+	}
+	return o
+}
+
+// Control sets the options on the sockets created with a net.ListenConfig, skipping the options
+// not supported on the platform
+func (o Options) Control(network, address string, c syscall.RawConn) error {
+	o = o.supported()
+	if o.IsZero() {
+		return nil
+	}
+	var serr error
+	if err := c.Control(func(fd uintptr) { serr = set(fd, o) }); err != nil {
+		return err
+	}
+	return serr
+}
+
+// Apply sets the options, except ReuseAddr and ReusePort, on an open socket. Sockets with no file descriptor,
+// e.g., the sockets of a virtual network, are left alone
+func Apply(conn net.PacketConn, o Options) error {
+	o.ReuseAddr, o.ReusePort = false, false
+	if o.supported().IsZero() {
+		return nil
+	}
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return nil
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return err
+	}
+	return o.Control(conn.LocalAddr().Network(), conn.LocalAddr().String(), raw)
+}
+
+// tos returns the value of the IP_TOS and IPV6_TCLASS options for a DiffServ code point
+func tos(dscp int) int {
+	return dscp << 2
+}
+
+// either returns nil if either the IPv4 or the IPv6 variant of an option was set: the family of
+// dual-stack sockets depends on the platform and the address
+func either(v4, v6 error) error {
+	if v4 == nil || v6 == nil {
+		return nil
+	}
+	return v4
+}
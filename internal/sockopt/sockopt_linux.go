@@ -0,0 +1,39 @@
+//go:build linux
+// +build linux
+
+package sockopt
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// Supported are the options supported on the platform
+var Supported = Features{ReuseAddr: true, ReusePort: true, ReusePortBalancing: true, Device: true, DSCP: true}
+
+func set(fd uintptr, o Options) error {
+	s := int(fd)
+	if o.ReuseAddr {
+		if err := unix.SetsockoptInt(s, unix.SOL_SOCKET, unix.SO_REUSEADDR, 1); err != nil {
+			return fmt.Errorf("cannot set SO_REUSEADDR: %w", err)
+		}
+	}
+	if o.ReusePort {
+		if err := unix.SetsockoptInt(s, unix.SOL_SOCKET, unix.SO_REUSEPORT, 1); err != nil {
+			return fmt.Errorf("cannot set SO_REUSEPORT: %w", err)
+		}
+	}
+	if o.Device != "" {
+		if err := unix.BindToDevice(s, o.Device); err != nil {
+			return fmt.Errorf("cannot bind to device %s: %w", o.Device, err)
+		}
+	}
+	if o.DSCP != 0 {
+		if err := either(unix.SetsockoptInt(s, unix.IPPROTO_IP, unix.IP_TOS, tos(o.DSCP)),
+			unix.SetsockoptInt(s, unix.IPPROTO_IPV6, unix.IPV6_TCLASS, tos(o.DSCP))); err != nil {
+			return fmt.Errorf("cannot set DSCP %d: %w", o.DSCP, err)
+		}
+	}
+	return nil
+}
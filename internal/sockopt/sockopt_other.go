@@ -0,0 +1,11 @@
+//go:build !linux && !darwin && !windows
+// +build !linux,!darwin,!windows
+
+package sockopt
+
+// Supported are the options supported on the platform: none
+var Supported = Features{}
+
+func set(fd uintptr, o Options) error {
+	return nil
+}
@@ -0,0 +1,69 @@
+package sockopt
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUnsupported(t *testing.T) {
+	assert.Empty(t, Options{}.Unsupported(), "no options")
+	assert.True(t, Options{}.IsZero(), "zero")
+
+	o := Options{ReuseAddr: true, ReusePort: true, Device: "lo", DSCP: 46}
+	assert.False(t, o.IsZero(), "not zero")
+	u := o.Unsupported()
+	assert.Equal(t, !Supported.ReuseAddr, contains(u, "reuse-addr"), "reuse-addr")
+	assert.Equal(t, !Supported.ReusePort, contains(u, "reuse-port"), "reuse-port")
+	assert.Equal(t, !Supported.Device, contains(u, "bind-to-device"), "bind-to-device")
+	assert.Equal(t, !Supported.DSCP, contains(u, "dscp"), "dscp")
+
+	// the unsupported options are skipped
+	assert.Empty(t, o.supported().Unsupported(), "supported")
+}
+
+func TestControl(t *testing.T) {
+	lc := net.ListenConfig{Control: Options{ReusePort: true, DSCP: 46}.Control}
+	c1, err := lc.ListenPacket(context.Background(), "udp4", "127.0.0.1:0")
+	assert.NoError(t, err, "listen")
+	defer c1.Close()
+
+	// a second socket can bind the same address if SO_REUSEPORT is supported
+	c2, err := lc.ListenPacket(context.Background(), "udp4", c1.LocalAddr().String())
+	if Supported.ReusePort {
+		assert.NoError(t, err, "reuse port")
+		c2.Close()
+	} else {
+		assert.Error(t, err, "no reuse port")
+	}
+}
+
+func TestApply(t *testing.T) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	assert.NoError(t, err, "listen")
+	defer conn.Close()
+
+	assert.NoError(t, Apply(conn, Options{}), "no options")
+	assert.NoError(t, Apply(conn, Options{DSCP: 46}), "dscp")
+	// ReusePort is ignored on open sockets
+	assert.NoError(t, Apply(conn, Options{ReusePort: true}), "reuse port")
+
+	// binding to an unknown device fails where supported
+	err = Apply(conn, Options{Device: "no-such-device0"})
+	if Supported.Device {
+		assert.Error(t, err, "unknown device")
+	} else {
+		assert.NoError(t, err, "device ignored")
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
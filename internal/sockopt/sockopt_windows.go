@@ -0,0 +1,50 @@
+//go:build windows
+// +build windows
+
+package sockopt
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+
+	"golang.org/x/sys/windows"
+)
+
+// options missing from x/sys/windows
+const (
+	ipUnicastIf   = 31
+	ipv6UnicastIf = 31
+	ipv6TClass    = 39
+)
+
+// Supported are the options supported on the platform: there is no SO_REUSEPORT, SO_REUSEADDR
+// lets any socket steal the address and so it is not used, the sockets are bound to a device by
+// selecting the interface of the unicast packets sent, and the DiffServ code point is only
+// honored if allowed by the QoS policy of the host
+var Supported = Features{ReusePort: false, ReusePortBalancing: false, Device: true, DSCP: true}
+
+func set(fd uintptr, o Options) error {
+	h := windows.Handle(fd)
+	if o.Device != "" {
+		ifi, err := net.InterfaceByName(o.Device)
+		if err != nil {
+			return fmt.Errorf("cannot bind to device %s: %w", o.Device, err)
+		}
+		// IP_UNICAST_IF takes the interface index in network byte order
+		be := make([]byte, 4)
+		binary.BigEndian.PutUint32(be, uint32(ifi.Index))
+		if err := either(windows.SetsockoptInt(h, windows.IPPROTO_IP, ipUnicastIf,
+			int(binary.LittleEndian.Uint32(be))),
+			windows.SetsockoptInt(h, windows.IPPROTO_IPV6, ipv6UnicastIf, ifi.Index)); err != nil {
+			return fmt.Errorf("cannot bind to device %s: %w", o.Device, err)
+		}
+	}
+	if o.DSCP != 0 {
+		if err := either(windows.SetsockoptInt(h, windows.IPPROTO_IP, windows.IP_TOS, tos(o.DSCP)),
+			windows.SetsockoptInt(h, windows.IPPROTO_IPV6, ipv6TClass, tos(o.DSCP))); err != nil {
+			return fmt.Errorf("cannot set DSCP %d: %w", o.DSCP, err)
+		}
+	}
+	return nil
+}
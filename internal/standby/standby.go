@@ -0,0 +1,196 @@
+// Package standby implements the state machine of a hot-standby pair of gateways. The gateways
+// exchange heartbeats carrying their state, active or standby, and the readiness of the standby
+// to take over. The standby takes over once no heartbeat was received for the failover timeout,
+// or right away when the active gateway releases the virtual IP. On start both gateways are
+// standby: the gateway with the active role takes over on the first heartbeat of a standby peer,
+// or after the failover timeout if the peer is down, so that a gateway coming back never preempts
+// the other gateway. If both gateways end up active, e.g., after a network partition, the gateway
+// with the standby role steps down.
+package standby
+
+import (
+	"sync"
+	"time"
+
+	"github.com/l7mp/stunner/pkg/apis/v1alpha1"
+)
+
+// The roles and the states of the gateways
+const (
+	Active  = "active"
+	Standby = "standby"
+)
+
+// The reasons of the takeovers
+const (
+	// ReasonStart: the gateway with the active role took over on start
+	ReasonStart = "start"
+	// ReasonFailure: the heartbeats of the active gateway were missing for the failover timeout
+	ReasonFailure = "failure"
+	// ReasonRelease: the active gateway released the virtual IP
+	ReasonRelease = "release"
+)
+
+// Heartbeat is the message the gateways of the pair exchange. The responses to the heartbeats are
+// the heartbeats of the receiver
+type Heartbeat struct {
+	// Node identifies the gateway
+	Node string `json:"node"`
+	// Role is the role of the gateway
+	Role string `json:"role"`
+	// State is the state of the gateway: active or standby
+	State string `json:"state"`
+	// Ready is true if the gateway is ready to take over
+	Ready bool `json:"ready"`
+	// Release asks the receiver to take over from the sender
+	Release bool `json:"release,omitempty"`
+	// ConfigHash is the hash of the config of the gateway without its name and standby settings
+	ConfigHash string `json:"config_hash,omitempty"`
+	// WantConfig asks an active receiver to send its config if the config hashes differ
+	WantConfig bool `json:"want_config,omitempty"`
+	// Config is the config of an active sender, sent in response to a WantConfig heartbeat, with
+	// the secrets redacted
+	Config *v1alpha1.StunnerConfig `json:"config,omitempty"`
+	// Time is the UNIX time the heartbeat was sent at, so that replayed heartbeats expire
+	Time int64 `json:"time"`
+}
+
+// Action is the state change decided by the state machine
+type Action struct {
+	// TakeOver makes the gateway active for the reason given
+	TakeOver string
+	// StepDown makes the gateway standby
+	StepDown bool
+}
+
+// Pair is the state machine of a gateway of the pair
+type Pair struct {
+	role    string
+	timeout time.Duration
+	state   string
+	ready   bool
+	// settled is set once the gateway took over or stepped down, or has seen an active peer:
+	// the gateway with the active role no longer takes over on the heartbeat of a standby peer
+	settled bool
+	// releasing is set once the gateway released the virtual IP until the peer is active
+	releasing bool
+	started   time.Time
+	lastSeen  time.Time
+	peer      Heartbeat
+	lock      sync.Mutex
+}
+
+// NewPair creates the state machine of a gateway in standby state. A gateway with the active role
+// is always ready to take over, a standby is ready once SetReady is called
+func NewPair(role string, timeout time.Duration, now time.Time) *Pair {
+	return &Pair{role: role, timeout: timeout, state: Standby, ready: role == Active, started: now}
+}
+
+// Role returns the role of the gateway
+func (p *Pair) Role() string {
+	return p.role
+}
+
+// SetTimeout sets the failover timeout
+func (p *Pair) SetTimeout(timeout time.Duration) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.timeout = timeout
+}
+
+// State returns the state of the gateway
+func (p *Pair) State() string {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.state
+}
+
+// Ready returns true if the gateway is ready to take over
+func (p *Pair) Ready() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.ready
+}
+
+// SetReady sets the readiness of the gateway to take over
+func (p *Pair) SetReady(ready bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.ready = ready || p.role == Active
+}
+
+// Peer returns the last heartbeat of the peer and the time it was received, zero if none
+func (p *Pair) Peer() (Heartbeat, time.Time) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.peer, p.lastSeen
+}
+
+// Receive processes a heartbeat of the peer
+func (p *Pair) Receive(hb Heartbeat, now time.Time) Action {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	hb.Config = nil
+	p.peer, p.lastSeen = hb, now
+	if hb.State == Active {
+		p.settled, p.releasing = true, false
+	}
+
+	switch {
+	case p.state == Standby && hb.Release && p.ready:
+		return p.transition(Action{TakeOver: ReasonRelease})
+	case p.state == Standby && hb.State == Standby && !hb.Release && p.role == Active &&
+		!p.settled:
+		return p.transition(Action{TakeOver: ReasonStart})
+	case p.state == Active && hb.State == Active && p.role == Standby:
+		return p.transition(Action{StepDown: true})
+	}
+	return Action{}
+}
+
+// Tick checks the heartbeats of the peer for the failover timeout
+func (p *Pair) Tick(now time.Time) Action {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	last := p.lastSeen
+	if last.IsZero() {
+		last = p.started
+	}
+	if p.state == Standby && p.ready && now.Sub(last) > p.timeout {
+		return p.transition(Action{TakeOver: ReasonFailure})
+	}
+	return Action{}
+}
+
+// Release makes an active gateway standby, the heartbeats then ask the peer to take over
+func (p *Pair) Release() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.state != Active {
+		return false
+	}
+	p.transition(Action{StepDown: true})
+	p.releasing = true
+	return true
+}
+
+// Releasing returns true if the gateway released the virtual IP and the peer has not taken over
+// yet
+func (p *Pair) Releasing() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return p.releasing
+}
+
+func (p *Pair) transition(a Action) Action {
+	p.settled = true
+	if a.TakeOver != "" {
+		p.state = Active
+	}
+	if a.StepDown {
+		p.state = Standby
+	}
+	return a
+}
@@ -0,0 +1,77 @@
+package standby
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStart(t *testing.T) {
+	now := time.Now()
+	active := NewPair(Active, 3*time.Second, now)
+	standby := NewPair(Standby, 3*time.Second, now)
+	assert.Equal(t, Standby, active.State(), "active starts standby")
+	assert.True(t, active.Ready(), "active ready")
+	assert.False(t, standby.Ready(), "standby not ready")
+
+	// the active takes over on the heartbeat of a standby peer
+	assert.Equal(t, Action{}, standby.Receive(Heartbeat{Role: Active, State: Standby}, now), "standby")
+	assert.Equal(t, Action{TakeOver: ReasonStart}, active.Receive(Heartbeat{Role: Standby, State: Standby}, now), "start")
+	assert.Equal(t, Active, active.State(), "active")
+
+	// the standby takes over once ready and the heartbeats are missing
+	assert.Equal(t, Action{}, standby.Receive(Heartbeat{Role: Active, State: Active}, now), "heartbeat")
+	assert.Equal(t, Action{}, standby.Tick(now.Add(4*time.Second)), "not ready")
+	standby.SetReady(true)
+	assert.Equal(t, Action{}, standby.Tick(now.Add(2*time.Second)), "within timeout")
+	assert.Equal(t, Action{TakeOver: ReasonFailure}, standby.Tick(now.Add(4*time.Second)), "failure")
+	assert.Equal(t, Active, standby.State(), "taken over")
+
+	// the active coming back does not preempt the standby
+	back := NewPair(Active, 3*time.Second, now)
+	assert.Equal(t, Action{}, back.Receive(Heartbeat{Role: Standby, State: Active}, now), "no preemption")
+	assert.Equal(t, Action{}, back.Receive(Heartbeat{Role: Standby, State: Standby}, now), "settled")
+	assert.Equal(t, Standby, back.State(), "standby")
+}
+
+func TestActivePeerDown(t *testing.T) {
+	now := time.Now()
+	active := NewPair(Active, 3*time.Second, now)
+	assert.Equal(t, Action{}, active.Tick(now.Add(time.Second)), "within timeout")
+	assert.Equal(t, Action{TakeOver: ReasonFailure}, active.Tick(now.Add(4*time.Second)), "peer down")
+}
+
+func TestSplitBrain(t *testing.T) {
+	now := time.Now()
+	standby := NewPair(Standby, 3*time.Second, now)
+	standby.SetReady(true)
+	assert.Equal(t, Action{TakeOver: ReasonFailure}, standby.Tick(now.Add(4*time.Second)), "failure")
+
+	active := NewPair(Active, 3*time.Second, now)
+	assert.Equal(t, Action{TakeOver: ReasonFailure}, active.Tick(now.Add(4*time.Second)), "failure")
+
+	// the gateway with the standby role steps down
+	assert.Equal(t, Action{}, active.Receive(Heartbeat{Role: Standby, State: Active}, now), "active keeps")
+	assert.Equal(t, Action{StepDown: true}, standby.Receive(Heartbeat{Role: Active, State: Active}, now), "standby steps down")
+	assert.Equal(t, Standby, standby.State(), "standby")
+}
+
+func TestRelease(t *testing.T) {
+	now := time.Now()
+	active := NewPair(Active, 3*time.Second, now)
+	standby := NewPair(Standby, 3*time.Second, now)
+	standby.SetReady(true)
+	active.Receive(Heartbeat{Role: Standby, State: Standby, Ready: true}, now)
+	assert.False(t, standby.Release(), "not active")
+
+	assert.True(t, active.Release(), "release")
+	assert.Equal(t, Standby, active.State(), "released")
+	assert.True(t, active.Releasing(), "releasing")
+
+	// the released gateway does not take over again on the heartbeat of the standby
+	assert.Equal(t, Action{}, active.Receive(Heartbeat{Role: Standby, State: Standby}, now), "no retake")
+	assert.Equal(t, Action{TakeOver: ReasonRelease}, standby.Receive(Heartbeat{Role: Active, State: Standby, Release: true}, now), "take over")
+	assert.Equal(t, Action{}, active.Receive(Heartbeat{Role: Standby, State: Active}, now), "peer active")
+	assert.False(t, active.Releasing(), "released")
+}
@@ -0,0 +1,236 @@
+// Package strict enforces the optional STUN message checks of hardened listeners: requiring the
+// FINGERPRINT attribute, refusing the comprehension-required attributes unknown to the server, and
+// requiring a valid MESSAGE-INTEGRITY on indications. The checks run at the socket layer of the
+// listeners: requests with unknown comprehension-required attributes are answered with a 420
+// (Unknown Attribute) error, the rest of the offending messages are silently dropped.
+package strict
+
+import (
+	"net"
+
+	"github.com/pion/logging"
+	"github.com/pion/stun"
+
+	"github.com/l7mp/stunner/internal/monitoring"
+	"github.com/l7mp/stunner/internal/stunmsg"
+	"github.com/l7mp/stunner/internal/util"
+)
+
+// Violations a message may be dropped for
+const (
+	// MissingFingerprint is a message with no FINGERPRINT attribute
+	MissingFingerprint = "missing-fingerprint"
+	// BadFingerprint is a message whose FINGERPRINT attribute does not match
+	BadFingerprint = "bad-fingerprint"
+	// UnknownAttribute is a message with a comprehension-required attribute unknown to the server
+	UnknownAttribute = "unknown-attribute"
+	// IndicationIntegrity is an indication with no valid MESSAGE-INTEGRITY attribute
+	IndicationIntegrity = "indication-integrity"
+)
+
+// knownAttributes are the comprehension-required attributes of STUN (RFC 8489) and TURN (RFC 8656,
+// RFC 6062) understood by the server
+var knownAttributes = map[stun.AttrType]bool{
+	stun.AttrMappedAddress:          true,
+	stun.AttrChangeRequest:          true,
+	stun.AttrUsername:               true,
+	stun.AttrMessageIntegrity:       true,
+	stun.AttrErrorCode:              true,
+	stun.AttrUnknownAttributes:      true,
+	stun.AttrChannelNumber:          true,
+	stun.AttrLifetime:               true,
+	stun.AttrXORPeerAddress:         true,
+	stun.AttrData:                   true,
+	stun.AttrRealm:                  true,
+	stun.AttrNonce:                  true,
+	stun.AttrXORRelayedAddress:      true,
+	stun.AttrRequestedAddressFamily: true,
+	stun.AttrEvenPort:               true,
+	stun.AttrRequestedTransport:     true,
+	stun.AttrDontFragment:           true,
+	stun.AttrXORMappedAddress:       true,
+	stun.AttrReservationToken:       true,
+	stun.AttrConnectionID:           true,
+	0x001C:                          true, // MESSAGE-INTEGRITY-SHA256
+	0x001D:                          true, // PASSWORD-ALGORITHM
+	0x001E:                          true, // USERHASH
+}
+
+// Policy sets the checks of a listener
+type Policy struct {
+	// RequireFingerprint drops the messages with no valid FINGERPRINT attribute
+	RequireFingerprint bool
+	// StrictAttributes refuses the messages with comprehension-required attributes unknown to the
+	// server
+	StrictAttributes bool
+	// IndicationIntegrity drops the indications with no valid MESSAGE-INTEGRITY attribute
+	IndicationIntegrity bool
+}
+
+// enabled returns true if any check is enabled
+func (p Policy) enabled() bool {
+	return p.RequireFingerprint || p.StrictAttributes || p.IndicationIntegrity
+}
+
+// Checker checks the messages received on a listener against the current policy of the listener
+type Checker struct {
+	listener string
+	policy   func() Policy
+	key      stunmsg.KeyFunc
+	log      logging.LeveledLogger
+}
+
+// NewChecker creates a checker for a listener. The policy callback returns the current policy of
+// the listener and the key callback the long-term keys to check the integrity of indications with
+func NewChecker(listener string, policy func() Policy, key stunmsg.KeyFunc, logger logging.LoggerFactory) *Checker {
+	return &Checker{
+		listener: listener,
+		policy:   policy,
+		key:      key,
+		log:      logger.NewLogger("strict"),
+	}
+}
+
+// Check checks a message, with the header h, received from a client and returns the violation the
+// message is to be dropped for, or an empty string if the message is passed to the TURN server,
+// plus the error response to send back, if any
+func (c *Checker) Check(b []byte, h stunmsg.Header, client net.Addr) (string, []byte) {
+	// ChannelData messages and non-STUN traffic are not checked
+	if h.Kind != stunmsg.STUN {
+		return "", nil
+	}
+	p := c.policy()
+	if !p.enabled() {
+		return "", nil
+	}
+
+	// the indications of the data path are checked too, so decode from a pooled copy
+	buf := util.DefaultBufferPool.Copy(b)
+	defer util.DefaultBufferPool.Put(buf)
+	m := &stun.Message{Raw: *buf}
+	if err := m.Decode(); err != nil {
+		// malformed messages are refused by the TURN server
+		return "", nil
+	}
+
+	if p.RequireFingerprint {
+		if !m.Contains(stun.AttrFingerprint) {
+			return c.violation(MissingFingerprint, m, client), nil
+		}
+		if err := stun.Fingerprint.Check(m); err != nil {
+			return c.violation(BadFingerprint, m, client), nil
+		}
+	}
+
+	if p.StrictAttributes {
+		unknown := stun.UnknownAttributes{}
+		for _, a := range m.Attributes {
+			if a.Type.Required() && !knownAttributes[a.Type] {
+				unknown = append(unknown, a.Type)
+			}
+		}
+		if len(unknown) > 0 {
+			v := c.violation(UnknownAttribute, m, client)
+			if m.Type.Class != stun.ClassRequest {
+				return v, nil
+			}
+			res, err := stun.Build(stun.NewTransactionIDSetter(m.TransactionID),
+				stun.NewType(m.Type.Method, stun.ClassErrorResponse),
+				stun.CodeUnknownAttribute, unknown, stun.Fingerprint)
+			if err != nil {
+				return v, nil
+			}
+			return v, res.Raw
+		}
+	}
+
+	if p.IndicationIntegrity && m.Type.Class == stun.ClassIndication && !c.integrity(m, client) {
+		return c.violation(IndicationIntegrity, m, client), nil
+	}
+
+	return "", nil
+}
+
+// integrity checks the MESSAGE-INTEGRITY attribute of a message against the long-term key of the
+// user in the USERNAME and REALM attributes
+func (c *Checker) integrity(m *stun.Message, client net.Addr) bool {
+	var username stun.Username
+	var realm stun.Realm
+	if !m.Contains(stun.AttrMessageIntegrity) || username.GetFrom(m) != nil ||
+		realm.GetFrom(m) != nil {
+		return false
+	}
+	key, ok := c.key(username.String(), realm.String(), client)
+	if !ok {
+		return false
+	}
+	return stun.MessageIntegrity(key).Check(m) == nil
+}
+
+// violation counts and logs a violation
+func (c *Checker) violation(v string, m *stun.Message, client net.Addr) string {
+	monitoring.StrictViolationCounter.WithLabelValues(c.listener, v).Inc()
+	c.log.Debugf("refusing %s from %s on listener %s: %s", m.Type.String(), client, c.listener, v)
+	return v
+}
+
+// NewPacketConn wraps the socket of a packet listener so that the offending messages are refused
+// before reaching the TURN server
+func (c *Checker) NewPacketConn(conn net.PacketConn) net.PacketConn {
+	return &packetConn{PacketConn: conn, checker: c}
+}
+
+type packetConn struct {
+	net.PacketConn
+	checker *Checker
+}
+
+func (c *packetConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, _, err := c.ReadMessage(b)
+	return n, addr, err
+}
+
+func (c *packetConn) ReadMessage(b []byte) (int, net.Addr, stunmsg.Header, error) {
+	for {
+		n, addr, h, err := stunmsg.ReadMessage(c.PacketConn, b)
+		if err != nil {
+			return n, addr, h, err
+		}
+		v, res := c.checker.Check(b[:n], h, addr)
+		if v == "" {
+			return n, addr, h, err
+		}
+		if res == nil {
+			continue
+		}
+		if _, err := c.PacketConn.WriteTo(res, addr); err != nil {
+			c.checker.log.Debugf("cannot send error response to %s: %s", addr, err.Error())
+		}
+	}
+}
+
+// NewListener wraps the socket of a stream listener so that the offending messages are refused
+// before reaching the TURN server
+func (c *Checker) NewListener(ln net.Listener) net.Listener {
+	return &streamListener{Listener: ln, checker: c}
+}
+
+type streamListener struct {
+	net.Listener
+	checker *Checker
+}
+
+func (l *streamListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return conn, err
+	}
+	client := conn.RemoteAddr()
+	return stunmsg.NewStreamConn(conn, func(b []byte, h stunmsg.Header) ([]byte, []byte) {
+		if v, res := l.checker.Check(b, h, client); v != "" {
+			// drop the message
+			return nil, res
+		}
+		return b, nil
+	}), nil
+}
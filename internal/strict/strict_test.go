@@ -0,0 +1,168 @@
+package strict
+
+import (
+	"net"
+	"testing"
+
+	"github.com/pion/logging"
+	"github.com/pion/stun"
+	"github.com/pion/turn/v2"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/l7mp/stunner/internal/stunmsg"
+)
+
+var (
+	testClient = &net.UDPAddr{IP: net.ParseIP("1.2.3.4"), Port: 1234}
+	testKey    = turn.GenerateAuthKey("user1", "stunner.l7mp.io", "passwd1")
+)
+
+func testKeyFunc(username, realm string, _ net.Addr) ([]byte, bool) {
+	if username != "user1" {
+		return nil, false
+	}
+	return testKey, true
+}
+
+func check(c *Checker, b []byte) (string, []byte) {
+	return c.Check(b, stunmsg.Parse(b), testClient)
+}
+
+func newTestChecker(p Policy) *Checker {
+	return NewChecker("udp", func() Policy { return p }, testKeyFunc,
+		logging.NewDefaultLoggerFactory())
+}
+
+func build(t *testing.T, setters ...stun.Setter) []byte {
+	m, err := stun.Build(append([]stun.Setter{stun.TransactionID}, setters...)...)
+	assert.NoError(t, err, "build")
+	return m.Raw
+}
+
+func TestStrictDisabled(t *testing.T) {
+	c := newTestChecker(Policy{})
+	unknown := stun.RawAttribute{Type: 0x7000, Value: []byte{1, 2, 3, 4}}
+	b := build(t, stun.BindingRequest, unknown)
+	v, res := check(c, b)
+	assert.Equal(t, "", v, "no policy")
+	assert.Nil(t, res, "no response")
+}
+
+func TestStrictFingerprint(t *testing.T) {
+	c := newTestChecker(Policy{RequireFingerprint: true})
+
+	v, _ := check(c, build(t, stun.BindingRequest))
+	assert.Equal(t, MissingFingerprint, v, "missing fingerprint")
+
+	b := build(t, stun.BindingRequest, stun.Fingerprint)
+	v, _ = check(c, b)
+	assert.Equal(t, "", v, "fingerprint")
+
+	// corrupt the CRC
+	b[len(b)-1] ^= 0xff
+	v, res := check(c, b)
+	assert.Equal(t, BadFingerprint, v, "bad fingerprint")
+	assert.Nil(t, res, "silently dropped")
+
+	// ChannelData and non-STUN traffic pass
+	v, _ = check(c, []byte{0x40, 0x00, 0x00, 0x04, 1, 2, 3, 4})
+	assert.Equal(t, "", v, "channel data")
+}
+
+func TestStrictAttributes(t *testing.T) {
+	c := newTestChecker(Policy{StrictAttributes: true})
+
+	allocate := stun.NewType(stun.MethodAllocate, stun.ClassRequest)
+	transport := stun.RawAttribute{Type: stun.AttrRequestedTransport, Value: []byte{17, 0, 0, 0}}
+	v, _ := check(c, build(t, allocate, transport, stun.NewSoftware("test"), stun.Fingerprint))
+	assert.Equal(t, "", v, "known attributes")
+
+	// unknown comprehension-optional attributes are ignored
+	optional := stun.RawAttribute{Type: 0x8fff, Value: []byte{1, 2, 3, 4}}
+	v, _ = check(c, build(t, allocate, transport, optional))
+	assert.Equal(t, "", v, "comprehension-optional attribute")
+
+	required := stun.RawAttribute{Type: 0x7000, Value: []byte{1, 2, 3, 4}}
+	v, res := check(c, build(t, allocate, transport, required))
+	assert.Equal(t, UnknownAttribute, v, "unknown attribute")
+	assert.NotNil(t, res, "error response")
+
+	m := &stun.Message{Raw: res}
+	assert.NoError(t, m.Decode(), "decode response")
+	assert.Equal(t, stun.NewType(stun.MethodAllocate, stun.ClassErrorResponse), m.Type, "type")
+	var code stun.ErrorCodeAttribute
+	assert.NoError(t, code.GetFrom(m), "error code")
+	assert.Equal(t, stun.CodeUnknownAttribute, code.Code, "420")
+	var unknown stun.UnknownAttributes
+	assert.NoError(t, unknown.GetFrom(m), "unknown attributes")
+	assert.Equal(t, stun.UnknownAttributes{0x7000}, unknown, "unknown attribute list")
+
+	// indications are dropped
+	send := stun.NewType(stun.MethodSend, stun.ClassIndication)
+	v, res = check(c, build(t, send, required))
+	assert.Equal(t, UnknownAttribute, v, "unknown attribute in indication")
+	assert.Nil(t, res, "no response to indication")
+}
+
+func TestStrictIndicationIntegrity(t *testing.T) {
+	c := newTestChecker(Policy{IndicationIntegrity: true})
+
+	send := stun.NewType(stun.MethodSend, stun.ClassIndication)
+	data := stun.RawAttribute{Type: stun.AttrData, Value: []byte("Hello")}
+
+	v, _ := check(c, build(t, send, data))
+	assert.Equal(t, IndicationIntegrity, v, "no integrity")
+
+	v, _ = check(c, build(t, send, data, stun.NewUsername("user1"),
+		stun.NewRealm("stunner.l7mp.io"), stun.MessageIntegrity(testKey), stun.Fingerprint))
+	assert.Equal(t, "", v, "valid integrity")
+
+	v, _ = check(c, build(t, send, data, stun.NewUsername("user1"),
+		stun.NewRealm("stunner.l7mp.io"), stun.MessageIntegrity([]byte("wrong-key"))))
+	assert.Equal(t, IndicationIntegrity, v, "invalid integrity")
+
+	v, _ = check(c, build(t, send, data, stun.NewUsername("user2"),
+		stun.NewRealm("stunner.l7mp.io"), stun.MessageIntegrity(testKey)))
+	assert.Equal(t, IndicationIntegrity, v, "unknown user")
+
+	// requests are authenticated by the TURN server
+	v, _ = check(c, build(t, stun.BindingRequest))
+	assert.Equal(t, "", v, "request")
+}
+
+func TestStrictPacketConn(t *testing.T) {
+	server, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	assert.NoError(t, err, "server")
+	c := newTestChecker(Policy{StrictAttributes: true, RequireFingerprint: true})
+	conn := c.NewPacketConn(server)
+	defer conn.Close()
+
+	client, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	assert.NoError(t, err, "client")
+	defer client.Close()
+
+	// the first request has no fingerprint, the second has an unknown attribute, the third
+	// passes
+	required := stun.RawAttribute{Type: 0x7000, Value: []byte{1, 2, 3, 4}}
+	ok := build(t, stun.BindingRequest, stun.NewSoftware("ok"), stun.Fingerprint)
+	for _, b := range [][]byte{
+		build(t, stun.BindingRequest),
+		build(t, stun.BindingRequest, required, stun.Fingerprint),
+		ok,
+	} {
+		_, err = client.WriteTo(b, server.LocalAddr())
+		assert.NoError(t, err, "send")
+	}
+
+	buf := make([]byte, 1500)
+	n, _, err := conn.ReadFrom(buf)
+	assert.NoError(t, err, "read")
+	assert.Equal(t, ok, buf[:n], "passed request")
+
+	// the client got a 420
+	n, _, err = client.ReadFrom(buf)
+	assert.NoError(t, err, "read response")
+	m := &stun.Message{Raw: buf[:n]}
+	assert.NoError(t, m.Decode(), "decode response")
+	assert.Equal(t, stun.ClassErrorResponse, m.Type.Class, "error response")
+}
@@ -0,0 +1,118 @@
+package stunmsg
+
+import (
+	"encoding/binary"
+	"net"
+)
+
+// readSize is the size of the reads from the wrapped stream connections
+const readSize = 4096
+
+// Frame returns the size of the message at the beginning of the data read from a stream
+// connection, framed the same way as the TURN server does: a STUN message is recognized by the
+// magic cookie and a ChannelData message is padded to 4 bytes. Returns zero if more data is needed
+// to frame the message, or -1 if the data does not start with a message.
+func Frame(b []byte) int {
+	size := 0
+	switch {
+	case len(b) >= HeaderSize && binary.BigEndian.Uint32(b[4:8]) == MagicCookie:
+		size = HeaderSize + int(binary.BigEndian.Uint16(b[2:4]))
+	case len(b) >= ChannelDataHeaderSize && b[0]&0xc0 == 0x40:
+		size = ChannelDataHeaderSize + (int(binary.BigEndian.Uint16(b[2:4]))+3)&^3
+	case len(b) < HeaderSize:
+		return 0
+	default:
+		return -1
+	}
+	if size > len(b) {
+		return 0
+	}
+	return size
+}
+
+// Filter checks a message framed from a stream connection. Returns the message to pass on to the
+// TURN server, which may be rewritten, or nil to drop the message, and a response to send to the
+// client, if any.
+type Filter func(b []byte, h Header) (msg []byte, res []byte)
+
+// StreamConn is a stream connection that frames the messages read from the connection and passes
+// each message through a filter, buffering the partial messages across Reads. Once the data read
+// cannot be framed the rest of the stream is passed on unchecked, since the TURN server closes the
+// connection on the first invalid frame.
+type StreamConn struct {
+	net.Conn
+	filter Filter
+	buf    []byte
+	// in holds the data not framed yet, out[off:] the filtered messages not returned yet
+	in, out []byte
+	off     int
+	invalid bool
+	err     error
+}
+
+// NewStreamConn wraps a stream connection with a filter
+func NewStreamConn(conn net.Conn, filter Filter) *StreamConn {
+	return &StreamConn{Conn: conn, filter: filter}
+}
+
+// Read returns the filtered messages read from the connection
+func (c *StreamConn) Read(b []byte) (int, error) {
+	for c.off == len(c.out) {
+		if c.err != nil {
+			return 0, c.err
+		}
+		if c.buf == nil {
+			c.buf = make([]byte, readSize)
+		}
+		c.out, c.off = c.out[:0], 0
+		n, err := c.Conn.Read(c.buf)
+		c.err = err
+		if c.invalid {
+			c.out = append(c.out, c.buf[:n]...)
+			continue
+		}
+		c.in = append(c.in, c.buf[:n]...)
+		if err := c.frame(); err != nil {
+			return 0, err
+		}
+	}
+
+	n := copy(b, c.out[c.off:])
+	c.off += n
+	return n, nil
+}
+
+// frame filters the whole messages buffered
+func (c *StreamConn) frame() error {
+	off := 0
+	for !c.invalid {
+		size := Frame(c.in[off:])
+		if size == 0 {
+			break
+		}
+		if size < 0 {
+			c.invalid = true
+			c.out = append(c.out, c.in[off:]...)
+			off = len(c.in)
+			break
+		}
+
+		b := c.in[off : off+size]
+		off += size
+		h := Parse(b)
+		if h.Kind == Garbage {
+			// the TURN server handles any message with the magic cookie as STUN, but the top
+			// bits of the message type must be zero
+			continue
+		}
+		msg, res := c.filter(b, h)
+		if res != nil {
+			if _, err := c.Conn.Write(res); err != nil {
+				return err
+			}
+		}
+		c.out = append(c.out, msg...)
+	}
+	c.in = c.in[:copy(c.in, c.in[off:])]
+	return nil
+}
@@ -0,0 +1,129 @@
+// Package stunmsg parses the headers of the messages received on the TURN listeners, shared by the
+// filters wrapping the listener sockets. The packet sockets of the filters return the parsed header
+// along with each datagram, so that the header of a datagram is parsed once no matter how many
+// filters the datagram passes through. The stream connections of the filters frame the messages
+// the same way as the TURN server, so that every message of a stream is checked.
+package stunmsg
+
+import (
+	"encoding/binary"
+	"net"
+)
+
+const (
+	// HeaderSize is the size of the header of a STUN message
+	HeaderSize = 20
+	// ChannelDataHeaderSize is the size of the header of a TURN ChannelData message
+	ChannelDataHeaderSize = 4
+	// MagicCookie is the magic cookie of the STUN messages
+	MagicCookie = 0x2112A442
+)
+
+// KeyFunc returns the long-term credential key of a username in a realm, or false if the user is
+// unknown
+type KeyFunc func(username, realm string, srcAddr net.Addr) ([]byte, bool)
+
+// Kind is the kind of a message
+type Kind int
+
+const (
+	// Garbage is a packet that is neither a STUN nor a TURN ChannelData message
+	Garbage Kind = iota
+	// STUN is a STUN message
+	STUN
+	// ChannelData is a TURN ChannelData message
+	ChannelData
+)
+
+// Header is the header of a message received from a client
+type Header struct {
+	// Kind is the kind of the message
+	Kind Kind
+	// Type is the message type of a STUN message, or the channel number of a ChannelData message
+	Type uint16
+	// Length is the length field of the header: the size of the attributes of a STUN message or
+	// of the application data of a ChannelData message
+	Length int
+}
+
+// Parse parses the header of a message, a packet too short for a header is Garbage
+func Parse(b []byte) Header {
+	switch {
+	case len(b) >= HeaderSize && b[0]&0xc0 == 0 && binary.BigEndian.Uint32(b[4:8]) == MagicCookie:
+		return Header{
+			Kind:   STUN,
+			Type:   binary.BigEndian.Uint16(b[0:2]),
+			Length: int(binary.BigEndian.Uint16(b[2:4])),
+		}
+	case len(b) >= ChannelDataHeaderSize && b[0]&0xc0 == 0x40:
+		return Header{
+			Kind:   ChannelData,
+			Type:   binary.BigEndian.Uint16(b[0:2]),
+			Length: int(binary.BigEndian.Uint16(b[2:4])),
+		}
+	}
+	return Header{}
+}
+
+// Is returns true if the header is of a STUN message of a type
+func (h Header) Is(typ uint16) bool {
+	return h.Kind == STUN && h.Type == typ
+}
+
+// Size returns the size of the message, without the padding of the ChannelData messages on
+// stream transports, or zero for Garbage
+func (h Header) Size() int {
+	switch h.Kind {
+	case STUN:
+		return HeaderSize + h.Length
+	case ChannelData:
+		return ChannelDataHeaderSize + h.Length
+	}
+	return 0
+}
+
+// WellFormed returns true if the length of the message matches a datagram of n bytes: a STUN
+// message fills the datagram, and a ChannelData message fits into it
+func (h Header) WellFormed(n int) bool {
+	switch h.Kind {
+	case STUN:
+		return h.Length%4 == 0 && HeaderSize+h.Length == n
+	case ChannelData:
+		return ChannelDataHeaderSize+h.Length <= n
+	}
+	return false
+}
+
+// Attribute returns the offset and the length of the value of the first attribute of a type in a
+// STUN message, without decoding the entire message, or false if the message has no attribute of
+// the type whose value fits into the message
+func Attribute(b []byte, typ uint16) (int, int, bool) {
+	for off := HeaderSize; off+4 <= len(b); {
+		l := int(binary.BigEndian.Uint16(b[off+2 : off+4]))
+		if binary.BigEndian.Uint16(b[off:off+2]) == typ {
+			return off + 4, l, off+4+l <= len(b)
+		}
+		off += 4 + (l+3)&^3
+	}
+	return 0, 0, false
+}
+
+// PacketConn is a packet socket that returns the header of the datagrams it reads
+type PacketConn interface {
+	net.PacketConn
+	// ReadMessage reads a datagram like ReadFrom, and returns the header of the datagram too
+	ReadMessage(b []byte) (int, net.Addr, Header, error)
+}
+
+// ReadMessage reads a datagram from a packet socket and returns the header of the datagram, parsed
+// here unless the socket is a PacketConn that has already parsed it
+func ReadMessage(conn net.PacketConn, b []byte) (int, net.Addr, Header, error) {
+	if c, ok := conn.(PacketConn); ok {
+		return c.ReadMessage(b)
+	}
+	n, addr, err := conn.ReadFrom(b)
+	if err != nil {
+		return n, addr, Header{}, err
+	}
+	return n, addr, Parse(b[:n]), nil
+}
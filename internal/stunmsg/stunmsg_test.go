@@ -0,0 +1,205 @@
+package stunmsg
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/pion/stun"
+	"github.com/stretchr/testify/assert"
+)
+
+var allocateRequest = stun.NewType(stun.MethodAllocate, stun.ClassRequest).Value()
+
+func TestParse(t *testing.T) {
+	m, err := stun.Build(stun.TransactionID, stun.NewType(stun.MethodAllocate, stun.ClassRequest),
+		stun.RawAttribute{Type: stun.AttrLifetime, Value: []byte{0, 0, 0, 60}},
+		stun.RawAttribute{Type: stun.AttrData, Value: []byte("hello")})
+	assert.NoError(t, err, "build")
+
+	h := Parse(m.Raw)
+	assert.Equal(t, STUN, h.Kind, "stun")
+	assert.True(t, h.Is(allocateRequest), "allocate")
+	assert.False(t, h.Is(stun.NewType(stun.MethodRefresh, stun.ClassRequest).Value()), "refresh")
+	assert.Equal(t, len(m.Raw), h.Size(), "size")
+	assert.True(t, h.WellFormed(len(m.Raw)), "well-formed")
+	assert.False(t, h.WellFormed(len(m.Raw)+4), "trailing bytes")
+
+	off, l, ok := Attribute(m.Raw, uint16(stun.AttrLifetime))
+	assert.True(t, ok, "lifetime")
+	assert.Equal(t, []byte{0, 0, 0, 60}, m.Raw[off:off+l], "lifetime value")
+	off, l, ok = Attribute(m.Raw, uint16(stun.AttrData))
+	assert.True(t, ok, "data")
+	assert.Equal(t, "hello", string(m.Raw[off:off+l]), "data value")
+	_, _, ok = Attribute(m.Raw, uint16(stun.AttrUsername))
+	assert.False(t, ok, "no username")
+	_, _, ok = Attribute(m.Raw[:len(m.Raw)-4], uint16(stun.AttrData))
+	assert.False(t, ok, "truncated data")
+
+	channelData := []byte{0x40, 0x01, 0x00, 0x04, 1, 2, 3, 4}
+	h = Parse(channelData)
+	assert.Equal(t, Header{Kind: ChannelData, Type: 0x4001, Length: 4}, h, "channel data")
+	assert.False(t, h.Is(0x4001), "not stun")
+	assert.Equal(t, 8, h.Size(), "channel data size")
+	assert.True(t, h.WellFormed(len(channelData)+4), "padding")
+	assert.False(t, h.WellFormed(6), "truncated channel data")
+
+	for name, b := range map[string][]byte{
+		"empty":           nil,
+		"short":           {0x00, 0x01},
+		"no magic cookie": append([]byte{0, 1, 0, 0, 1, 2, 3, 4}, make([]byte, 12)...),
+		"truncated stun":  m.Raw[:HeaderSize-1],
+		"tls":             []byte("\x16\x03\x01\x02\x00\x01\x00\x01\xfc\x03\x03"),
+	} {
+		h := Parse(b)
+		assert.Equal(t, Garbage, h.Kind, name)
+		assert.Equal(t, 0, h.Size(), name)
+		assert.False(t, h.WellFormed(len(b)), name)
+	}
+}
+
+// testPacketConn returns a single datagram and counts the reads
+type testPacketConn struct {
+	net.PacketConn
+	b     []byte
+	reads int
+}
+
+func (c *testPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	c.reads++
+	return copy(b, c.b), &net.UDPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 1234}, nil
+}
+
+// testFilter is a filter that records the headers it receives from below
+type testFilter struct {
+	net.PacketConn
+	headers []Header
+}
+
+func (c *testFilter) ReadMessage(b []byte) (int, net.Addr, Header, error) {
+	n, addr, h, err := ReadMessage(c.PacketConn, b)
+	c.headers = append(c.headers, h)
+	return n, addr, h, err
+}
+
+func TestReadMessage(t *testing.T) {
+	m, err := stun.Build(stun.TransactionID, stun.BindingRequest)
+	assert.NoError(t, err, "build")
+	conn := &testPacketConn{b: m.Raw}
+	inner := &testFilter{PacketConn: conn}
+	outer := &testFilter{PacketConn: inner}
+
+	b := make([]byte, 1500)
+	n, addr, h, err := ReadMessage(outer, b)
+	assert.NoError(t, err, "read")
+	assert.Equal(t, len(m.Raw), n, "size")
+	assert.Equal(t, "1.2.3.4:1234", addr.String(), "source")
+	assert.True(t, h.Is(stun.BindingRequest.Value()), "header")
+	assert.Equal(t, 1, conn.reads, "single read")
+	assert.Equal(t, []Header{h}, inner.headers, "parsed by the innermost filter")
+	assert.Equal(t, []Header{h}, outer.headers, "passed to the outer filter")
+}
+
+// clientHello is the beginning of a TLS ClientHello, long enough for a STUN header
+var clientHello = append([]byte("\x16\x03\x01\x02\x00\x01\x00\x01\xfc\x03\x03"), make([]byte, 9)...)
+
+func TestFrame(t *testing.T) {
+	m, err := stun.Build(stun.TransactionID, stun.BindingRequest,
+		stun.RawAttribute{Type: stun.AttrData, Value: []byte("hello")})
+	assert.NoError(t, err, "build")
+
+	assert.Equal(t, len(m.Raw), Frame(m.Raw), "stun")
+	assert.Equal(t, len(m.Raw), Frame(append(append([]byte{}, m.Raw...), 0x40, 0, 0)), "pipelined")
+	assert.Equal(t, 0, Frame(m.Raw[:len(m.Raw)-1]), "partial stun")
+	assert.Equal(t, 0, Frame(m.Raw[:HeaderSize-1]), "partial header")
+	assert.Equal(t, 0, Frame(nil), "empty")
+
+	// the ChannelData messages are padded on streams
+	assert.Equal(t, 12, Frame([]byte{0x40, 0x01, 0x00, 0x05, 1, 2, 3, 4, 5, 0, 0, 0}), "channel data")
+	assert.Equal(t, 0, Frame([]byte{0x40, 0x01, 0x00, 0x05, 1, 2, 3, 4, 5}), "unpadded channel data")
+	assert.Equal(t, 0, Frame([]byte{0x40, 0x01}), "partial channel data header")
+
+	assert.Equal(t, -1, Frame(clientHello), "tls")
+}
+
+// testConn returns the chunks of a stream one by one and records the data written
+type testConn struct {
+	net.Conn
+	chunks  [][]byte
+	written [][]byte
+}
+
+func (c *testConn) Read(b []byte) (int, error) {
+	if len(c.chunks) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(b, c.chunks[0])
+	c.chunks = c.chunks[1:]
+	return n, nil
+}
+
+func (c *testConn) Write(b []byte) (int, error) {
+	c.written = append(c.written, append([]byte{}, b...))
+	return len(b), nil
+}
+
+func TestStreamConn(t *testing.T) {
+	allocate := stun.MustBuild(stun.TransactionID, stun.NewType(stun.MethodAllocate, stun.ClassRequest))
+	binding := stun.MustBuild(stun.TransactionID, stun.BindingRequest)
+	channelData := []byte{0x40, 0x01, 0x00, 0x02, 1, 2, 0, 0}
+	cat := func(bs ...[]byte) []byte { return bytes.Join(bs, nil) }
+
+	// drops the Allocate requests and responds with the message, passes the rest
+	filtered := []Header{}
+	filter := func(b []byte, h Header) ([]byte, []byte) {
+		filtered = append(filtered, h)
+		if h.Is(allocateRequest) {
+			return nil, b
+		}
+		return b, nil
+	}
+	read := func(conn net.Conn) []byte {
+		b, err := io.ReadAll(conn)
+		assert.NoError(t, err, "read")
+		return b
+	}
+
+	// two messages in one Read
+	conn := &testConn{chunks: [][]byte{cat(binding.Raw, allocate.Raw)}}
+	assert.Equal(t, binding.Raw, read(NewStreamConn(conn, filter)), "pipelined")
+	assert.Len(t, filtered, 2, "each message filtered")
+	assert.Equal(t, [][]byte{allocate.Raw}, conn.written, "response")
+
+	// a message split across two Reads, and followed by a ChannelData message
+	filtered = filtered[:0]
+	raw := cat(binding.Raw, allocate.Raw, channelData)
+	conn = &testConn{chunks: [][]byte{raw[:len(binding.Raw)+10], raw[len(binding.Raw)+10:]}}
+	assert.Equal(t, cat(binding.Raw, channelData), read(NewStreamConn(conn, filter)), "split")
+	assert.Len(t, filtered, 3, "each message filtered")
+	assert.True(t, filtered[1].Is(allocateRequest), "split message")
+	assert.Equal(t, ChannelData, filtered[2].Kind, "channel data")
+	assert.Equal(t, [][]byte{allocate.Raw}, conn.written, "response")
+
+	// the reads may be shorter than the messages
+	conn = &testConn{chunks: [][]byte{cat(binding.Raw, binding.Raw)}}
+	c := NewStreamConn(conn, filter)
+	b := make([]byte, 8)
+	n, err := c.Read(b)
+	assert.NoError(t, err, "short read")
+	assert.Equal(t, binding.Raw[:8], b[:n], "short read")
+	assert.Equal(t, cat(binding.Raw[8:], binding.Raw), read(c), "rest")
+
+	// a message with the magic cookie but the top bits of the type set is dropped
+	invalid := append([]byte{}, binding.Raw...)
+	invalid[0] |= 0xc0
+	conn = &testConn{chunks: [][]byte{cat(invalid, binding.Raw)}}
+	assert.Equal(t, binding.Raw, read(NewStreamConn(conn, filter)), "invalid type")
+
+	// the stream is passed unchecked from the first invalid frame on
+	filtered = filtered[:0]
+	conn = &testConn{chunks: [][]byte{cat(binding.Raw, clientHello), allocate.Raw}}
+	assert.Equal(t, cat(binding.Raw, clientHello, allocate.Raw), read(NewStreamConn(conn, filter)),
+		"garbage")
+	assert.Len(t, filtered, 1, "only the first message filtered")
+}
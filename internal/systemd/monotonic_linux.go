@@ -0,0 +1,12 @@
+package systemd
+
+import "golang.org/x/sys/unix"
+
+// monotonicUsec returns the CLOCK_MONOTONIC time in microseconds
+func monotonicUsec() int64 {
+	var ts unix.Timespec
+	if err := unix.ClockGettime(unix.CLOCK_MONOTONIC, &ts); err != nil {
+		return 0
+	}
+	return ts.Nano() / 1000
+}
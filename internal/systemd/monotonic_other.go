@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package systemd
+
+// monotonicUsec is not available outside Linux, where there is no systemd anyway
+func monotonicUsec() int64 {
+	return 0
+}
@@ -0,0 +1,119 @@
+// Package systemd implements the systemd socket activation and service notification protocols
+// (see sd_listen_fds(3) and sd_notify(3)) without linking libsystemd: the listening sockets
+// passed by systemd are returned as files, and the state changes of the service (READY,
+// RELOADING, STOPPING) and the watchdog keep-alives are sent to the notification socket of the
+// service manager. Outside systemd there are no sockets passed and the notifications are no-ops.
+package systemd
+
+import (
+	"errors"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// listenFdsStart is the first file descriptor passed by socket activation
+	listenFdsStart = 3
+
+	// the notifications of the service state
+	Ready     = "READY=1"
+	Reloading = "RELOADING=1"
+	Stopping  = "STOPPING=1"
+	Watchdog  = "WATCHDOG=1"
+)
+
+// ListenFiles returns the sockets passed to the process by socket activation, named by the
+// FileDescriptorName= setting of the socket unit, or nil if none. The environment variables of
+// the protocol are unset, so that the sockets are not claimed again by the child processes
+func ListenFiles() []*os.File {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")     //nolint:errcheck
+		os.Unsetenv("LISTEN_FDS")     //nolint:errcheck
+		os.Unsetenv("LISTEN_FDNAMES") //nolint:errcheck
+	}()
+	return listenFiles(os.Getpid(), listenFdsStart)
+}
+
+// listenFiles returns the sockets passed to the process with the given PID, starting at the
+// given file descriptor
+func listenFiles(pid, start int) []*os.File {
+	if p, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || p != pid {
+		return nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	files := make([]*os.File, n)
+	for i := range files {
+		name := "LISTEN_FD_" + strconv.Itoa(start+i)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		files[i] = os.NewFile(uintptr(start+i), name)
+	}
+	return files
+}
+
+// Notify sends a notification to the service manager, e.g., Ready. It returns false if the
+// process was not started by a service manager expecting notifications
+func Notify(state ...string) (bool, error) {
+	path := os.Getenv("NOTIFY_SOCKET")
+	if path == "" {
+		return false, nil
+	}
+	// Go maps the leading "@" to the abstract namespace of Linux
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(strings.Join(state, "\n"))); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// ReloadingState returns the notification of a reload, with the monotonic timestamp systemd
+// expects along with it
+func ReloadingState() []string {
+	usec := monotonicUsec()
+	if usec == 0 {
+		return []string{Reloading}
+	}
+	return []string{Reloading, "MONOTONIC_USEC=" + strconv.FormatInt(usec, 10)}
+}
+
+// statusReplacer folds the line breaks of a status line, which would otherwise end the status and
+// start a new notification, e.g., a multi-line error message carrying "READY=1" on a line
+var statusReplacer = strings.NewReplacer("\r\n", " ", "\n", " ", "\r", " ")
+
+// Status returns the notification of a status line shown by systemctl status, with the line
+// breaks replaced by spaces
+func Status(status string) string {
+	return "STATUS=" + statusReplacer.Replace(status)
+}
+
+// WatchdogInterval returns the period the watchdog keep-alives must be sent in, zero if the
+// watchdog is disabled for the process
+func WatchdogInterval() (time.Duration, error) {
+	usec := os.Getenv("WATCHDOG_USEC")
+	if usec == "" {
+		return 0, nil
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" {
+		if p, err := strconv.Atoi(pid); err != nil || p != os.Getpid() {
+			return 0, nil
+		}
+	}
+	n, err := strconv.ParseInt(usec, 10, 64)
+	if err != nil || n <= 0 {
+		return 0, errors.New("invalid WATCHDOG_USEC: " + usec)
+	}
+	return time.Duration(n) * time.Microsecond, nil
+}
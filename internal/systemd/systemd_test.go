@@ -0,0 +1,98 @@
+//go:build !windows
+// +build !windows
+
+package systemd
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListenFiles(t *testing.T) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	assert.NoError(t, err, "listen")
+	defer conn.Close()
+	f, err := conn.(*net.UDPConn).File()
+	assert.NoError(t, err, "file")
+	defer f.Close()
+	fd := int(f.Fd())
+
+	pid := strconv.Itoa(os.Getpid())
+	t.Setenv("LISTEN_PID", "1")
+	t.Setenv("LISTEN_FDS", "1")
+	assert.Nil(t, listenFiles(os.Getpid(), fd), "other process")
+
+	t.Setenv("LISTEN_PID", pid)
+	t.Setenv("LISTEN_FDNAMES", "media")
+	fs := listenFiles(os.Getpid(), fd)
+	assert.Len(t, fs, 1, "passed sockets")
+	assert.Equal(t, "media", fs[0].Name(), "name")
+
+	c, err := net.FilePacketConn(fs[0])
+	assert.NoError(t, err, "socket")
+	assert.Equal(t, conn.LocalAddr().String(), c.LocalAddr().String(), "address")
+	c.Close()
+
+	t.Setenv("LISTEN_FDS", "0")
+	assert.Nil(t, listenFiles(os.Getpid(), fd), "no sockets")
+}
+
+func TestNotify(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	ok, err := Notify(Ready)
+	assert.NoError(t, err, "no service manager")
+	assert.False(t, ok, "no service manager")
+
+	path := filepath.Join(t.TempDir(), "notify")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	assert.NoError(t, err, "listen")
+	defer conn.Close()
+	t.Setenv("NOTIFY_SOCKET", path)
+
+	ok, err = Notify(Ready, Status("running"))
+	assert.NoError(t, err, "notify")
+	assert.True(t, ok, "notified")
+
+	buf := make([]byte, 1024)
+	assert.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)), "deadline")
+	n, err := conn.Read(buf)
+	assert.NoError(t, err, "read")
+	assert.Equal(t, "READY=1\nSTATUS=running", string(buf[:n]), "notification")
+
+	assert.Equal(t, Reloading, ReloadingState()[0], "reloading")
+}
+
+func TestStatus(t *testing.T) {
+	assert.Equal(t, "STATUS=running", Status("running"), "status")
+	assert.Equal(t, "STATUS=could not reconcile: invalid  config READY=1", Status(
+		"could not reconcile: invalid\r\n config\nREADY=1"), "multi-line status")
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	t.Setenv("WATCHDOG_USEC", "")
+	d, err := WatchdogInterval()
+	assert.NoError(t, err, "disabled")
+	assert.Zero(t, d, "disabled")
+
+	t.Setenv("WATCHDOG_USEC", "30000000")
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	d, err = WatchdogInterval()
+	assert.NoError(t, err, "enabled")
+	assert.Equal(t, 30*time.Second, d, "interval")
+
+	t.Setenv("WATCHDOG_PID", "1")
+	d, err = WatchdogInterval()
+	assert.NoError(t, err, "other process")
+	assert.Zero(t, d, "other process")
+
+	t.Setenv("WATCHDOG_PID", "")
+	t.Setenv("WATCHDOG_USEC", "x")
+	_, err = WatchdogInterval()
+	assert.Error(t, err, "invalid")
+}
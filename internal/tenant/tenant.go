@@ -0,0 +1,186 @@
+// Package tenant maps the clients to the tenants of the listeners they connect to. The TURN server
+// authenticates the clients knowing only their source address, so the sockets of the listeners
+// of a tenant record the source address of each client for the authentication handler to find
+// the credentials of the tenant of the client.
+package tenant
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pion/logging"
+
+	"github.com/l7mp/stunner/internal/stunmsg"
+)
+
+const (
+	// maxClients bounds the number of UDP clients recorded
+	maxClients = 1 << 16
+	// clientIdleTimeout is the time after which a UDP client sending no STUN messages is forgotten
+	clientIdleTimeout = 10 * time.Minute
+	// sweepInterval is the minimum time between two sweeps of the idle UDP clients
+	sweepInterval = time.Minute
+	// touchInterval is the minimum time between two updates of the last-seen time of a client
+	touchInterval = time.Second
+)
+
+type client struct {
+	tenant   string
+	stream   bool  // stream clients are removed when the connection is closed
+	lastSeen int64 // unix nanos, atomic
+}
+
+// Table maps the source addresses of the clients to the tenants of the listeners
+type Table struct {
+	lock      sync.RWMutex
+	clients   map[string]*client
+	lastSweep time.Time
+	log       logging.LeveledLogger
+}
+
+// NewTable creates an empty tenant table
+func NewTable(logger logging.LoggerFactory) *Table {
+	return &Table{
+		clients:   map[string]*client{},
+		lastSweep: time.Now(),
+		log:       logger.NewLogger("tenant"),
+	}
+}
+
+func clientKey(addr net.Addr) string {
+	return addr.Network() + "/" + addr.String()
+}
+
+// Lookup returns the tenant of the listener a client connects to, or an empty string if the
+// client connects to a listener of no tenant
+func (t *Table) Lookup(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	if c, ok := t.clients[clientKey(addr)]; ok {
+		return c.tenant
+	}
+	return ""
+}
+
+// Len returns the number of clients recorded
+func (t *Table) Len() int {
+	t.lock.RLock()
+	defer t.lock.RUnlock()
+	return len(t.clients)
+}
+
+// record records the tenant of a client, or refreshes the last-seen time of the client
+func (t *Table) record(addr net.Addr, tenant string, stream bool) {
+	key := clientKey(addr)
+	now := time.Now()
+
+	t.lock.RLock()
+	c, ok := t.clients[key]
+	t.lock.RUnlock()
+	if ok && c.tenant == tenant {
+		if now.UnixNano()-atomic.LoadInt64(&c.lastSeen) > int64(touchInterval) {
+			atomic.StoreInt64(&c.lastSeen, now.UnixNano())
+		}
+		return
+	}
+
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if !stream && now.Sub(t.lastSweep) > sweepInterval {
+		t.sweep(now)
+	}
+	if !stream && !ok && len(t.clients) >= maxClients {
+		t.log.Debugf("too many clients, not recording the tenant of client %s", addr)
+		return
+	}
+	t.clients[key] = &client{tenant: tenant, stream: stream, lastSeen: now.UnixNano()}
+}
+
+// sweep forgets the idle UDP clients, must be called with the lock held
+func (t *Table) sweep(now time.Time) {
+	for key, c := range t.clients {
+		if !c.stream && now.UnixNano()-atomic.LoadInt64(&c.lastSeen) > int64(clientIdleTimeout) {
+			delete(t.clients, key)
+		}
+	}
+	t.lastSweep = now
+}
+
+// remove forgets a stream client
+func (t *Table) remove(addr net.Addr) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	key := clientKey(addr)
+	if c, ok := t.clients[key]; ok && c.stream {
+		delete(t.clients, key)
+	}
+}
+
+// NewPacketConn wraps the socket of a packet listener of a tenant so that the clients sending
+// STUN messages are recorded with the tenant, an empty tenant returns the socket as is
+func (t *Table) NewPacketConn(conn net.PacketConn, tenant string) net.PacketConn {
+	if tenant == "" {
+		return conn
+	}
+	return &packetConn{PacketConn: conn, table: t, tenant: tenant}
+}
+
+type packetConn struct {
+	net.PacketConn
+	table  *Table
+	tenant string
+}
+
+func (c *packetConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, _, err := c.ReadMessage(b)
+	return n, addr, err
+}
+
+func (c *packetConn) ReadMessage(b []byte) (int, net.Addr, stunmsg.Header, error) {
+	n, addr, h, err := stunmsg.ReadMessage(c.PacketConn, b)
+	// only STUN messages are authenticated: ChannelData messages are not recorded
+	if err == nil && h.Kind == stunmsg.STUN && addr != nil {
+		c.table.record(addr, c.tenant, false)
+	}
+	return n, addr, h, err
+}
+
+// NewListener wraps the socket of a stream listener of a tenant so that the clients are recorded
+// with the tenant for the lifetime of the connection, an empty tenant returns the socket as is
+func (t *Table) NewListener(ln net.Listener, tenant string) net.Listener {
+	if tenant == "" {
+		return ln
+	}
+	return &streamListener{Listener: ln, table: t, tenant: tenant}
+}
+
+type streamListener struct {
+	net.Listener
+	table  *Table
+	tenant string
+}
+
+func (l *streamListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil || conn.RemoteAddr() == nil {
+		return conn, err
+	}
+	l.table.record(conn.RemoteAddr(), l.tenant, true)
+	return &streamConn{Conn: conn, table: l.table}, nil
+}
+
+type streamConn struct {
+	net.Conn
+	table *Table
+	once  sync.Once
+}
+
+func (c *streamConn) Close() error {
+	c.once.Do(func() { c.table.remove(c.Conn.RemoteAddr()) })
+	return c.Conn.Close()
+}
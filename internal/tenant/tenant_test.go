@@ -0,0 +1,81 @@
+package tenant
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pion/logging"
+	"github.com/pion/stun"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPacketConn(t *testing.T) {
+	table := NewTable(logging.NewDefaultLoggerFactory())
+
+	server, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err, "listen")
+	conn := table.NewPacketConn(server, "tenant-a")
+	defer conn.Close()
+	assert.Equal(t, server, table.NewPacketConn(server, ""), "no tenant")
+
+	client, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.NoError(t, err, "client")
+	defer client.Close()
+
+	m, err := stun.Build(stun.TransactionID, stun.BindingRequest)
+	assert.NoError(t, err, "build")
+	channelData := []byte{0x40, 0x00, 0x00, 0x04, 1, 2, 3, 4}
+
+	buf := make([]byte, 1500)
+	assert.NoError(t, conn.SetReadDeadline(time.Now().Add(2*time.Second)), "deadline")
+
+	// channel data messages are not recorded
+	_, err = client.WriteTo(channelData, server.LocalAddr())
+	assert.NoError(t, err, "write")
+	_, addr, err := conn.ReadFrom(buf)
+	assert.NoError(t, err, "read")
+	assert.Equal(t, "", table.Lookup(addr), "channel data")
+
+	_, err = client.WriteTo(m.Raw, server.LocalAddr())
+	assert.NoError(t, err, "write")
+	_, addr, err = conn.ReadFrom(buf)
+	assert.NoError(t, err, "read")
+	assert.Equal(t, "tenant-a", table.Lookup(addr), "stun")
+	assert.Equal(t, 1, table.Len(), "len")
+
+	// idle clients are forgotten
+	table.lock.Lock()
+	table.sweep(time.Now().Add(2 * clientIdleTimeout))
+	table.lock.Unlock()
+	assert.Equal(t, "", table.Lookup(addr), "idle")
+	assert.Equal(t, "", table.Lookup(nil), "nil")
+}
+
+func TestListener(t *testing.T) {
+	table := NewTable(logging.NewDefaultLoggerFactory())
+
+	server, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err, "listen")
+	ln := table.NewListener(server, "tenant-b")
+	defer ln.Close()
+	assert.Equal(t, server, table.NewListener(server, ""), "no tenant")
+
+	client, err := net.Dial("tcp", server.Addr().String())
+	assert.NoError(t, err, "dial")
+	defer client.Close()
+
+	conn, err := ln.Accept()
+	assert.NoError(t, err, "accept")
+	assert.Equal(t, "tenant-b", table.Lookup(conn.RemoteAddr()), "accepted")
+
+	// stream clients are never swept
+	table.lock.Lock()
+	table.sweep(time.Now().Add(2 * clientIdleTimeout))
+	table.lock.Unlock()
+	assert.Equal(t, "tenant-b", table.Lookup(conn.RemoteAddr()), "not swept")
+
+	assert.NoError(t, conn.Close(), "close")
+	assert.Equal(t, "", table.Lookup(conn.RemoteAddr()), "closed")
+	assert.Equal(t, 0, table.Len(), "len")
+}
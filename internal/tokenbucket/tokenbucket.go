@@ -0,0 +1,47 @@
+// Package tokenbucket implements the token buckets shared by the request limiters: the buckets are
+// refilled at a rate of tokens per second up to BurstFactor seconds worth of tokens. The time is
+// always passed in by the caller, so that the limiters can take it from their own clock and the
+// buckets need no locking of their own.
+package tokenbucket
+
+import "time"
+
+// BurstFactor is the size of the buckets in seconds worth of tokens
+const BurstFactor = 2
+
+// Bucket is a token bucket, not safe for concurrent use
+type Bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// New creates a full bucket
+func New(now time.Time, rate int) *Bucket {
+	return &Bucket{tokens: float64(rate * BurstFactor), last: now}
+}
+
+// refill adds the tokens accrued since the last update, up to the size of the bucket
+func (b *Bucket) refill(now time.Time, rate int) {
+	b.tokens += now.Sub(b.last).Seconds() * float64(rate)
+	if max := float64(rate * BurstFactor); b.tokens > max {
+		b.tokens = max
+	}
+	b.last = now
+}
+
+// Take removes a token from the bucket, or returns false if the bucket is empty
+func (b *Bucket) Take(now time.Time, rate int) bool {
+	b.refill(now, rate)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Full refills the bucket and returns whether it is full, so that the limiters can forget the
+// buckets that would behave the same as a new one
+func (b *Bucket) Full(now time.Time, rate int) bool {
+	b.refill(now, rate)
+	return b.tokens >= float64(rate*BurstFactor)
+}
@@ -0,0 +1,34 @@
+package tokenbucket
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBucket(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	b := New(now, 2)
+	assert.True(t, b.Full(now, 2), "new bucket full")
+
+	// the bucket holds 4 tokens
+	for i := 0; i < 4; i++ {
+		assert.True(t, b.Take(now, 2), "token %d", i)
+	}
+	assert.False(t, b.Take(now, 2), "bucket empty")
+	assert.False(t, b.Full(now, 2), "bucket not full")
+
+	// a token is refilled in half a second
+	now = now.Add(500 * time.Millisecond)
+	assert.True(t, b.Take(now, 2), "token refilled")
+	assert.False(t, b.Take(now, 2), "bucket empty again")
+
+	// the refill stops at the size of the bucket
+	now = now.Add(time.Hour)
+	assert.True(t, b.Full(now, 2), "bucket refilled")
+	for i := 0; i < 4; i++ {
+		assert.True(t, b.Take(now, 2), "token %d after refill", i)
+	}
+	assert.False(t, b.Take(now, 2), "burst bounded")
+}
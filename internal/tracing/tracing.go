@@ -0,0 +1,367 @@
+// Package tracing exports the TURN transactions handled by STUNner as OpenTelemetry spans to an
+// OTLP/HTTP collector, using the JSON encoding. The spans of a client session share a trace, so
+// that a single client can be followed through authentication, routing and relay setup.
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pion/logging"
+)
+
+const (
+	// exportInterval is the period of exporting the queued spans
+	exportInterval = 5 * time.Second
+	// exportBatchSize is the number of queued spans that triggers an export right away
+	exportBatchSize = 512
+	// maxQueueLen bounds the number of queued spans, spans are dropped if the collector is slow
+	maxQueueLen = 4 * exportBatchSize
+	// exportTimeout is the timeout of posting a batch of spans to the collector
+	exportTimeout = 10 * time.Second
+	// sessionIdleTimeout is the time after which an idle session is forgotten
+	sessionIdleTimeout = 10 * time.Minute
+	// scopeName is the instrumentation scope of the spans
+	scopeName = "github.com/l7mp/stunner"
+)
+
+// Tracer samples the client sessions and exports the spans of the sampled sessions
+type Tracer struct {
+	endpoint, service string
+	ratio             float64
+	client            *http.Client
+	lock              sync.Mutex
+	sessions          map[string]*session
+	queue             []*Span
+	flush             chan struct{}
+	done              chan struct{}
+	closed            sync.WaitGroup
+	log               logging.LeveledLogger
+}
+
+type session struct {
+	traceID  [16]byte
+	sampled  bool
+	lastSeen time.Time
+}
+
+// NewTracer creates a tracer that exports the spans of the given ratio of the client sessions to
+// the OTLP/HTTP traces endpoint of a collector, e.g., "http://otel-collector:4318/v1/traces"
+func NewTracer(endpoint, service string, ratio float64, logger logging.LoggerFactory) *Tracer {
+	t := &Tracer{
+		endpoint: endpoint,
+		service:  service,
+		ratio:    ratio,
+		client:   &http.Client{Timeout: exportTimeout},
+		sessions: map[string]*session{},
+		flush:    make(chan struct{}, 1),
+		done:     make(chan struct{}),
+		log:      logger.NewLogger("stunner-tracing"),
+	}
+
+	t.closed.Add(1)
+	go t.run()
+
+	return t
+}
+
+// Endpoint returns the collector endpoint of the tracer
+func (t *Tracer) Endpoint() string { return t.endpoint }
+
+// Service returns the service name the spans are exported with
+func (t *Tracer) Service() string { return t.service }
+
+// Ratio returns the ratio of the sampled sessions
+func (t *Tracer) Ratio() float64 { return t.ratio }
+
+// Close exports the queued spans and stops the tracer
+func (t *Tracer) Close() {
+	close(t.done)
+	t.closed.Wait()
+}
+
+// StartSpan starts a span in the trace of a session, or returns nil if the session is not sampled
+func (t *Tracer) StartSpan(sessionID, name string) *Span {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	s := t.session(sessionID)
+	if !s.sampled {
+		return nil
+	}
+
+	span := &Span{
+		tracer:  t,
+		traceID: s.traceID,
+		name:    name,
+		start:   time.Now(),
+		attrs:   map[string]string{"stunner.session": sessionID},
+	}
+	if _, err := rand.Read(span.spanID[:]); err != nil {
+		return nil
+	}
+	return span
+}
+
+// TraceID returns the hex-encoded trace ID of a session, or an empty string if the session is
+// unknown or not sampled
+func (t *Tracer) TraceID(sessionID string) string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	s, found := t.sessions[sessionID]
+	if !found || !s.sampled {
+		return ""
+	}
+	return hex.EncodeToString(s.traceID[:])
+}
+
+// EndSession forgets a session, the next span of a client with the same session ID starts a new
+// trace
+func (t *Tracer) EndSession(sessionID string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	delete(t.sessions, sessionID)
+}
+
+// session returns the session for an ID, creating it if needed, must be called with the lock held
+func (t *Tracer) session(id string) *session {
+	s, found := t.sessions[id]
+	if !found {
+		s = &session{sampled: sample(t.ratio)}
+		if _, err := rand.Read(s.traceID[:]); err != nil {
+			s.sampled = false
+		}
+		t.sessions[id] = s
+	}
+	s.lastSeen = time.Now()
+	return s
+}
+
+func sample(ratio float64) bool {
+	if ratio >= 1.0 {
+		return true
+	}
+	n, err := rand.Int(rand.Reader, big.NewInt(1<<53))
+	if err != nil {
+		return false
+	}
+	return float64(n.Int64())/float64(1<<53) < ratio
+}
+
+func (t *Tracer) enqueue(s *Span) {
+	t.lock.Lock()
+	if len(t.queue) >= maxQueueLen {
+		t.lock.Unlock()
+		t.log.Debugf("span queue full, dropping span %q", s.name)
+		return
+	}
+	t.queue = append(t.queue, s)
+	n := len(t.queue)
+	t.lock.Unlock()
+
+	if n >= exportBatchSize {
+		select {
+		case t.flush <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (t *Tracer) run() {
+	defer t.closed.Done()
+
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			t.expireSessions()
+			t.export()
+		case <-t.flush:
+			t.export()
+		case <-t.done:
+			t.export()
+			return
+		}
+	}
+}
+
+func (t *Tracer) expireSessions() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for id, s := range t.sessions {
+		if time.Since(s.lastSeen) > sessionIdleTimeout {
+			delete(t.sessions, id)
+		}
+	}
+}
+
+// export posts the queued spans to the collector
+func (t *Tracer) export() {
+	t.lock.Lock()
+	spans := t.queue
+	t.queue = nil
+	t.lock.Unlock()
+
+	if len(spans) == 0 {
+		return
+	}
+
+	if err := t.post(spans); err != nil {
+		t.log.Warnf("could not export %d spans to %s: %s", len(spans), t.endpoint, err.Error())
+		return
+	}
+	t.log.Tracef("exported %d spans to %s", len(spans), t.endpoint)
+}
+
+func (t *Tracer) post(spans []*Span) error {
+	body, err := json.Marshal(t.encode(spans))
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return nil
+}
+
+// Span is a TURN transaction in the trace of a client session
+type Span struct {
+	tracer     *Tracer
+	traceID    [16]byte
+	spanID     [8]byte
+	name       string
+	start, end time.Time
+	attrs      map[string]string
+	err        string
+}
+
+// SetAttribute sets an attribute of the span
+func (s *Span) SetAttribute(key, value string) {
+	s.attrs[key] = value
+}
+
+// End ends the span and queues it for export, a non-empty error message marks the span failed
+func (s *Span) End(err string) {
+	s.end = time.Now()
+	s.err = err
+	s.tracer.enqueue(s)
+}
+
+// the OTLP/JSON encoding of the spans, see
+// https://github.com/open-telemetry/opentelemetry-proto/blob/main/docs/specification.md#json-protobuf-encoding
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes"`
+	Status            *otlpStatus     `json:"status,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+const (
+	otlpSpanKindServer  = 2
+	otlpStatusCodeError = 2
+)
+
+func (t *Tracer) encode(spans []*Span) otlpRequest {
+	ss := make([]otlpSpan, len(spans))
+	for i, s := range spans {
+		ss[i] = otlpSpan{
+			TraceID:           hex.EncodeToString(s.traceID[:]),
+			SpanID:            hex.EncodeToString(s.spanID[:]),
+			Name:              s.name,
+			Kind:              otlpSpanKindServer,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+			Attributes:        attributes(s.attrs),
+		}
+		if s.err != "" {
+			ss[i].Status = &otlpStatus{Code: otlpStatusCodeError, Message: s.err}
+		}
+	}
+
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: attributes(map[string]string{"service.name": t.service})},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: scopeName}, Spans: ss}},
+	}}}
+}
+
+func attributes(attrs map[string]string) []otlpAttribute {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	ret := make([]otlpAttribute, len(keys))
+	for i, k := range keys {
+		ret[i] = otlpAttribute{Key: k, Value: otlpValue{StringValue: attrs[k]}}
+	}
+	return ret
+}
@@ -0,0 +1,86 @@
+package tracing
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/l7mp/stunner/internal/logger"
+)
+
+func TestTracerExport(t *testing.T) {
+	var lock sync.Mutex
+	reqs := []otlpRequest{}
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		assert.NoError(t, err, "read body")
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"), "content type")
+		req := otlpRequest{}
+		assert.NoError(t, json.Unmarshal(body, &req), "OTLP request")
+		lock.Lock()
+		reqs = append(reqs, req)
+		lock.Unlock()
+	}))
+	defer collector.Close()
+
+	tr := NewTracer(collector.URL+"/v1/traces", "stunner-test", 1.0, logger.NewLoggerFactory("all:ERROR"))
+
+	s1 := tr.StartSpan("udp/1.2.3.4:5678", "TURN Allocate")
+	assert.NotNil(t, s1, "sampled")
+	s1.SetAttribute("turn.username", "user1")
+	s1.End("401 Unauthorized")
+	s2 := tr.StartSpan("udp/1.2.3.4:5678", "TURN Allocate")
+	s2.End("")
+	traceID := tr.TraceID("udp/1.2.3.4:5678")
+	assert.Len(t, traceID, 32, "trace ID")
+
+	// a new session gets a new trace
+	tr.EndSession("udp/1.2.3.4:5678")
+	assert.Empty(t, tr.TraceID("udp/1.2.3.4:5678"), "session ended")
+	s3 := tr.StartSpan("udp/1.2.3.4:5678", "TURN Refresh")
+	s3.End("")
+	tr.Close()
+
+	lock.Lock()
+	defer lock.Unlock()
+	assert.Len(t, reqs, 1, "spans exported in a batch on close")
+	if len(reqs) != 1 {
+		return
+	}
+
+	rs := reqs[0].ResourceSpans[0]
+	assert.Equal(t, []otlpAttribute{{Key: "service.name", Value: otlpValue{StringValue: "stunner-test"}}},
+		rs.Resource.Attributes, "service name")
+	spans := rs.ScopeSpans[0].Spans
+	assert.Len(t, spans, 3, "spans")
+	if len(spans) != 3 {
+		return
+	}
+
+	assert.Equal(t, "TURN Allocate", spans[0].Name, "name")
+	assert.Equal(t, otlpSpanKindServer, spans[0].Kind, "kind")
+	assert.Equal(t, traceID, spans[0].TraceID, "trace ID")
+	assert.Equal(t, traceID, spans[1].TraceID, "same session, same trace")
+	assert.NotEqual(t, traceID, spans[2].TraceID, "new session, new trace")
+	assert.NotEqual(t, spans[0].SpanID, spans[1].SpanID, "span IDs")
+	assert.Equal(t, &otlpStatus{Code: otlpStatusCodeError, Message: "401 Unauthorized"},
+		spans[0].Status, "error status")
+	assert.Nil(t, spans[1].Status, "ok status")
+	assert.Equal(t, []otlpAttribute{
+		{Key: "stunner.session", Value: otlpValue{StringValue: "udp/1.2.3.4:5678"}},
+		{Key: "turn.username", Value: otlpValue{StringValue: "user1"}},
+	}, spans[0].Attributes, "attributes")
+}
+
+func TestTracerSampling(t *testing.T) {
+	tr := NewTracer("http://127.0.0.1:1/v1/traces", "stunner-test", 0, logger.NewLoggerFactory("all:ERROR"))
+	defer tr.Close()
+
+	assert.Nil(t, tr.StartSpan("udp/1.2.3.4:5678", "TURN Allocate"), "not sampled")
+	assert.Empty(t, tr.TraceID("udp/1.2.3.4:5678"), "no trace ID")
+}
@@ -0,0 +1,85 @@
+// Package udp implements performance-oriented wrappers for the UDP sockets used by STUNner.
+package udp
+
+import (
+	"net"
+
+	"github.com/pion/logging"
+	"github.com/pion/turn/v2"
+)
+
+const (
+	// DefaultBatchSize is the default number of datagrams to read or write per syscall.
+	DefaultBatchSize = 32
+	// MaxBatchSize is the maximum batch size accepted by NewBatchConn.
+	MaxBatchSize = 256
+	// maxDatagramSize is the size of the per-message receive buffers: jumbo frames, well above
+	// the MTU the TURN server reads with. Longer datagrams are truncated by the kernel and dropped.
+	maxDatagramSize = 9216
+)
+
+// socket kinds, used as metric labels
+const (
+	listenerSocket = "listener"
+	relaySocket    = "relay"
+)
+
+// WriteErrorHandler is called by the background writer of a batched socket when a batch cannot be
+// sent, returns true to retry the write once. The errors of the queued writes cannot be returned
+// to the caller, so this is the only way to react to them (e.g., to drain the ICMP errors pending
+// on the socket).
+type WriteErrorHandler func(err error) bool
+
+// NewBatchConn wraps the socket of a UDP listener into a batched reader/writer, see
+// newBatchConn. If conn is not a *net.UDPConn (e.g., a vnet socket) or batched I/O is
+// unsupported on the platform it is returned unchanged.
+func NewBatchConn(conn net.PacketConn, listener string, batchSize int, logger logging.LoggerFactory) net.PacketConn {
+	return newBatchConn(conn, listener, listenerSocket, batchSize, logger)
+}
+
+// relayAddressGenerator wraps a TURN relay address generator so that the relay transports it
+// allocates use batched I/O
+type relayAddressGenerator struct {
+	turn.RelayAddressGenerator
+	listener  string
+	batchSize int
+	logger    logging.LoggerFactory
+}
+
+// NewRelayAddressGenerator wraps the relay address generator of a listener so that the relay
+// transports are batched too. It must wrap the generator of the listener directly, since only the
+// plain UDP sockets it allocates can be batched.
+func NewRelayAddressGenerator(gen turn.RelayAddressGenerator, listener string, batchSize int, logger logging.LoggerFactory) turn.RelayAddressGenerator {
+	return &relayAddressGenerator{RelayAddressGenerator: gen, listener: listener,
+		batchSize: batchSize, logger: logger}
+}
+
+func (r *relayAddressGenerator) AllocatePacketConn(network string, requestedPort int) (net.PacketConn, net.Addr, error) {
+	conn, addr, err := r.RelayAddressGenerator.AllocatePacketConn(network, requestedPort)
+	if err != nil {
+		return conn, addr, err
+	}
+
+	return newBatchConn(conn, r.listener, relaySocket, r.batchSize, r.logger), addr, nil
+}
+
+// normalizeBatchSize clamps the batch size into the supported range.
+func normalizeBatchSize(size int) int {
+	if size <= 0 {
+		return DefaultBatchSize
+	}
+	if size > MaxBatchSize {
+		return MaxBatchSize
+	}
+	return size
+}
+
+// sameUDPAddr checks whether two addresses refer to the same UDP endpoint.
+func sameUDPAddr(a, b net.Addr) bool {
+	ua, okA := a.(*net.UDPAddr)
+	ub, okB := b.(*net.UDPAddr)
+	if !okA || !okB {
+		return false
+	}
+	return ua.Port == ub.Port && ua.IP.Equal(ub.IP) && ua.Zone == ub.Zone
+}
@@ -0,0 +1,365 @@
+//go:build linux
+// +build linux
+
+package udp
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/pion/logging"
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"golang.org/x/sys/unix"
+
+	"github.com/l7mp/stunner/internal/monitoring"
+	"github.com/l7mp/stunner/internal/util"
+)
+
+const (
+	// udpSegment is the UDP_SEGMENT socket option/control message type used for UDP GSO, not
+	// exported by all versions of x/sys/unix
+	udpSegment = 103
+	// maxGSOSegments is the maximum number of segments the kernel accepts in a single GSO send
+	maxGSOSegments = 64
+	// maxGSOSize is the maximum total payload size of a single GSO send
+	maxGSOSize = 65507
+	// writeWarnInterval is the minimum time between the warnings on failed writes
+	writeWarnInterval = 10 * time.Second
+)
+
+// batchReadWriter is implemented by both ipv4.PacketConn and ipv6.PacketConn.
+type batchReadWriter interface {
+	ReadBatch(ms []ipv4.Message, flags int) (int, error)
+	WriteBatch(ms []ipv4.Message, flags int) (int, error)
+}
+
+type packet struct {
+	buf  *[]byte
+	addr net.Addr
+}
+
+// packets and their buffers are recycled once written to the socket
+var packetPool = sync.Pool{New: func() interface{} { return new(packet) }}
+
+func newPacket(b []byte, addr net.Addr) *packet {
+	p := packetPool.Get().(*packet)
+	p.buf = util.DefaultBufferPool.Get(len(b))
+	copy(*p.buf, b)
+	p.addr = addr
+	return p
+}
+
+func freePacket(p *packet) {
+	util.DefaultBufferPool.Put(p.buf)
+	p.buf, p.addr = nil, nil
+	packetPool.Put(p)
+}
+
+// batchConn is a net.PacketConn that amortizes syscall costs by reading datagrams with
+// recvmmsg(2) and writing them with sendmmsg(2), coalescing same-size datagrams to the same
+// destination into a single UDP GSO send when the kernel supports it.
+type batchConn struct {
+	*net.UDPConn
+	batch     batchReadWriter
+	batchSize int
+
+	// reader state, protected by readLock
+	readLock   sync.Mutex
+	readMsgs   []ipv4.Message
+	readPos    int
+	readLen    int
+	writeQueue chan *packet
+	truncated  prometheus.Counter
+
+	// writer state, owned by the writer goroutine: iov and oob are preallocated per batch so
+	// that flushing a batch does not allocate
+	iov        [][]byte
+	oob        [][]byte
+	gso        bool
+	unsent     prometheus.Counter
+	lastWarn   time.Time
+	errHandler atomic.Value // WriteErrorHandler
+	closeOnce  sync.Once
+	closed     chan struct{}
+	writerDone chan struct{}
+	log        logging.LeveledLogger
+}
+
+// newBatchConn wraps a UDP packet connection into a batched reader/writer. Reads are served
+// from a batch of datagrams received with a single recvmmsg(2) call, the datagrams that do not fit
+// into the receive buffers are dropped and counted by listener and socket kind. Writes are queued
+// and flushed by a background writer using sendmmsg(2): whatever is queued when the writer wakes
+// up is sent in one syscall, so no artificial delay is added to the data path. If conn is not a
+// *net.UDPConn (e.g., a vnet socket) it is returned unchanged.
+func newBatchConn(conn net.PacketConn, listener, socket string, batchSize int, logger logging.LoggerFactory) net.PacketConn {
+	udpConn, ok := conn.(*net.UDPConn)
+	if !ok {
+		return conn
+	}
+
+	batchSize = normalizeBatchSize(batchSize)
+	c := &batchConn{
+		UDPConn:    udpConn,
+		batchSize:  batchSize,
+		readMsgs:   make([]ipv4.Message, batchSize),
+		writeQueue: make(chan *packet, 4*batchSize),
+		truncated:  monitoring.TruncatedDatagramCounter.WithLabelValues(listener, socket),
+		unsent:     monitoring.UnsentDatagramCounter.WithLabelValues(listener, socket),
+		iov:        make([][]byte, batchSize),
+		oob:        make([][]byte, batchSize),
+		closed:     make(chan struct{}),
+		writerDone: make(chan struct{}),
+		log:        logger.NewLogger("udp-batch"),
+	}
+
+	if a, ok := udpConn.LocalAddr().(*net.UDPAddr); ok && a.IP.To4() == nil && !a.IP.IsUnspecified() {
+		c.batch = ipv6.NewPacketConn(udpConn)
+	} else {
+		c.batch = ipv4.NewPacketConn(udpConn)
+	}
+
+	for i := range c.readMsgs {
+		c.readMsgs[i].Buffers = [][]byte{make([]byte, maxDatagramSize)}
+		c.oob[i] = make([]byte, unix.CmsgSpace(2))
+	}
+
+	c.gso = gsoSupported(udpConn)
+	c.log.Debugf("batched UDP %s socket at %s: batch size: %d, GSO: %t", socket,
+		udpConn.LocalAddr(), batchSize, c.gso)
+
+	go c.writeLoop()
+
+	return c
+}
+
+// ReadFrom returns the next datagram from the current batch, refilling the batch with a single
+// recvmmsg(2) call when it is exhausted. Truncated datagrams, i.e., the ones longer than the
+// receive buffers or the buffer of the caller, are dropped.
+func (c *batchConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	c.readLock.Lock()
+	defer c.readLock.Unlock()
+
+	for {
+		if c.readPos >= c.readLen {
+			n, err := c.batch.ReadBatch(c.readMsgs, 0)
+			if err != nil {
+				return 0, nil, err
+			}
+			c.readPos, c.readLen = 0, n
+		}
+
+		m := &c.readMsgs[c.readPos]
+		c.readPos++
+
+		if m.Flags&unix.MSG_TRUNC != 0 || m.N > len(b) {
+			c.truncated.Inc()
+			continue
+		}
+
+		return copy(b, m.Buffers[0][:m.N]), m.Addr, nil
+	}
+}
+
+// SetWriteErrorHandler sets the handler called by the background writer on write errors.
+func (c *batchConn) SetWriteErrorHandler(h WriteErrorHandler) {
+	c.errHandler.Store(h)
+}
+
+// WriteTo queues a datagram for the background writer. The buffer is copied into a pooled
+// buffer so the caller may reuse it immediately.
+func (c *batchConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	select {
+	case <-c.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+
+	p := newPacket(b, addr)
+	select {
+	case c.writeQueue <- p:
+		return len(b), nil
+	case <-c.closed:
+		freePacket(p)
+		return 0, net.ErrClosed
+	}
+}
+
+// Close closes the socket and stops the background writer.
+func (c *batchConn) Close() error {
+	err := net.ErrClosed
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		err = c.UDPConn.Close()
+		<-c.writerDone
+	})
+	return err
+}
+
+func (c *batchConn) writeLoop() {
+	defer close(c.writerDone)
+
+	pkts := make([]*packet, 0, c.batchSize)
+	msgs := make([]ipv4.Message, 0, c.batchSize)
+	for {
+		select {
+		case p := <-c.writeQueue:
+			pkts = append(pkts, p)
+		case <-c.closed:
+			return
+		}
+
+	drain:
+		for len(pkts) < c.batchSize {
+			select {
+			case p := <-c.writeQueue:
+				pkts = append(pkts, p)
+			default:
+				break drain
+			}
+		}
+
+		msgs = c.flush(pkts, msgs[:0])
+		for i, p := range pkts {
+			freePacket(p)
+			pkts[i] = nil
+		}
+		pkts = pkts[:0]
+	}
+}
+
+// flush sends a batch of packets, returns the message slice for reuse
+func (c *batchConn) flush(pkts []*packet, msgs []ipv4.Message) []ipv4.Message {
+	if c.gso {
+		msgs = c.appendGSOMessages(msgs, pkts)
+	} else {
+		msgs = c.appendMessages(msgs, pkts)
+	}
+
+	sent, err := c.writeBatch(msgs)
+	if err != nil && c.gso && errors.Is(err, syscall.EIO) {
+		// the NIC cannot do checksum offload: fall back to plain sendmmsg for the rest of the batch
+		c.log.Infof("UDP GSO unsupported on socket %s, disabling", c.LocalAddr())
+		c.gso = false
+		pkts = pkts[datagrams(msgs[:sent]):]
+		msgs = c.appendMessages(msgs[:0], pkts)
+		sent, err = c.writeBatch(msgs)
+	}
+	if err != nil {
+		unsent := datagrams(msgs[sent:])
+		c.unsent.Add(float64(unsent))
+		if now := time.Now(); now.Sub(c.lastWarn) >= writeWarnInterval {
+			c.lastWarn = now
+			c.log.Warnf("cannot write %d datagrams on socket %s: %s", unsent, c.LocalAddr(),
+				err.Error())
+		}
+	}
+
+	for i := range c.iov {
+		c.iov[i] = nil
+	}
+
+	return msgs
+}
+
+// writeBatch writes a batch of messages, returns the number of messages sent
+func (c *batchConn) writeBatch(msgs []ipv4.Message) (int, error) {
+	sent, retried := 0, false
+	for sent < len(msgs) {
+		n, err := c.batch.WriteBatch(msgs[sent:], 0)
+		if n > 0 {
+			sent += n
+		}
+		if err != nil {
+			if h, ok := c.errHandler.Load().(WriteErrorHandler); ok && !retried && h(err) {
+				retried = true
+				continue
+			}
+			return sent, err
+		}
+	}
+	return sent, nil
+}
+
+// datagrams returns the number of datagrams in a batch of messages
+func datagrams(msgs []ipv4.Message) int {
+	n := 0
+	for _, m := range msgs {
+		n += len(m.Buffers)
+	}
+	return n
+}
+
+func (c *batchConn) appendMessages(msgs []ipv4.Message, pkts []*packet) []ipv4.Message {
+	for i, p := range pkts {
+		c.iov[i] = *p.buf
+		msgs = append(msgs, ipv4.Message{Buffers: c.iov[i : i+1], Addr: p.addr})
+	}
+	return msgs
+}
+
+// appendGSOMessages coalesces runs of packets to the same destination into GSO super-buffers:
+// all segments but the last must be of the same size, and the last one may not be longer
+func (c *batchConn) appendGSOMessages(msgs []ipv4.Message, pkts []*packet) []ipv4.Message {
+	for i := 0; i < len(pkts); {
+		first := pkts[i]
+		segSize := len(*first.buf)
+		c.iov[i] = *first.buf
+		total := segSize
+
+		j := i + 1
+		for ; j < len(pkts) && j-i < maxGSOSegments; j++ {
+			p := pkts[j]
+			if !sameUDPAddr(first.addr, p.addr) || len(*p.buf) > segSize ||
+				total+len(*p.buf) > maxGSOSize {
+				break
+			}
+			c.iov[j] = *p.buf
+			total += len(*p.buf)
+			if len(*p.buf) < segSize {
+				// a short segment terminates the run
+				j++
+				break
+			}
+		}
+
+		m := ipv4.Message{Buffers: c.iov[i:j], Addr: first.addr}
+		if j-i > 1 {
+			m.OOB = setGSOControlMessage(c.oob[len(msgs)], uint16(segSize))
+		}
+		msgs = append(msgs, m)
+		i = j
+	}
+	return msgs
+}
+
+func setGSOControlMessage(b []byte, segSize uint16) []byte {
+	h := (*unix.Cmsghdr)(unsafe.Pointer(&b[0]))
+	h.Level = unix.IPPROTO_UDP
+	h.Type = udpSegment
+	h.SetLen(unix.CmsgLen(2))
+	*(*uint16)(unsafe.Pointer(&b[unix.CmsgLen(0)])) = segSize
+	return b
+}
+
+func gsoSupported(conn *net.UDPConn) bool {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return false
+	}
+
+	var serr error
+	if err := raw.Control(func(fd uintptr) {
+		_, serr = unix.GetsockoptInt(int(fd), unix.IPPROTO_UDP, udpSegment)
+	}); err != nil {
+		return false
+	}
+
+	return serr == nil
+}
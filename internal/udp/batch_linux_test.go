@@ -0,0 +1,136 @@
+//go:build linux
+// +build linux
+
+package udp
+
+import (
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/pion/turn/v2"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/net/ipv4"
+
+	"github.com/l7mp/stunner/internal/monitoring"
+)
+
+func TestBatchConnTruncated(t *testing.T) {
+	a, b := newLoopbackPair(t)
+	defer a.Close()
+	receiver := NewBatchConn(b, "truncated", 4, testLoggerFactory)
+	defer receiver.Close()
+	truncated := monitoring.TruncatedDatagramCounter.WithLabelValues("truncated", "listener")
+	before := testutil.ToFloat64(truncated)
+
+	// longer than the receive buffers, longer than the buffer of the caller, and one that fits
+	for _, size := range []int{maxDatagramSize + 1, 1501, 1500} {
+		_, err := a.WriteTo(make([]byte, size), receiver.LocalAddr())
+		assert.NoError(t, err, "write %d bytes", size)
+	}
+
+	buf := make([]byte, 1500)
+	assert.NoError(t, receiver.SetReadDeadline(time.Now().Add(5*time.Second)))
+	n, _, err := receiver.ReadFrom(buf)
+	assert.NoError(t, err, "read")
+	assert.Equal(t, 1500, n, "truncated datagrams dropped")
+	assert.Equal(t, 2.0, testutil.ToFloat64(truncated)-before, "truncated datagrams counted")
+}
+
+// testBatch records the written messages and fails the writes as scripted
+type testBatch struct {
+	results []error
+	sent    [][]ipv4.Message
+}
+
+func (b *testBatch) ReadBatch([]ipv4.Message, int) (int, error) { return 0, errors.New("no reads") }
+
+// WriteBatch sends one message per call, or fails with the next scripted error
+func (b *testBatch) WriteBatch(ms []ipv4.Message, _ int) (int, error) {
+	if len(b.results) > 0 {
+		err := b.results[0]
+		b.results = b.results[1:]
+		if err != nil {
+			return 0, err
+		}
+	}
+	b.sent = append(b.sent, append([]ipv4.Message(nil), ms[:1]...))
+	return 1, nil
+}
+
+func TestBatchConnFlush(t *testing.T) {
+	a, b := newLoopbackPair(t)
+	defer a.Close()
+	defer b.Close()
+	unsent := monitoring.UnsentDatagramCounter.WithLabelValues("flush", "listener")
+	c := &batchConn{UDPConn: b.(*net.UDPConn), batchSize: 4, gso: true, iov: make([][]byte, 4),
+		oob: make([][]byte, 4), unsent: unsent, log: testLoggerFactory.NewLogger("udp-batch")}
+	for i := range c.oob {
+		c.oob[i] = make([]byte, 64)
+	}
+	first := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1}
+	second := &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 2}
+	newPackets := func() []*packet {
+		return []*packet{newPacket(make([]byte, 100), first), newPacket(make([]byte, 100), first),
+			newPacket(make([]byte, 100), second), newPacket(make([]byte, 100), second)}
+	}
+
+	// GSO fails after the first super-buffer was sent: only the rest is resent, without GSO
+	batch := &testBatch{results: []error{nil, syscall.EIO}}
+	c.batch = batch
+	c.flush(newPackets(), nil)
+	assert.False(t, c.gso, "GSO disabled")
+	assert.Len(t, batch.sent, 3, "writes")
+	assert.Len(t, batch.sent[0][0].Buffers, 2, "GSO super-buffer")
+	assert.Equal(t, first, batch.sent[0][0].Addr, "first destination")
+	for _, ms := range batch.sent[1:] {
+		assert.Len(t, ms[0].Buffers, 1, "plain message")
+		assert.Equal(t, second, ms[0].Addr, "second destination")
+	}
+
+	// the datagrams that cannot be sent are counted
+	before := testutil.ToFloat64(unsent)
+	batch = &testBatch{results: []error{nil, errors.New("network unreachable")}}
+	c.batch = batch
+	c.flush(newPackets(), nil)
+	assert.Len(t, batch.sent, 1, "writes")
+	assert.Equal(t, 3.0, testutil.ToFloat64(unsent)-before, "unsent datagrams counted")
+}
+
+type testRelayAddressGenerator struct {
+	turn.RelayAddressGenerator
+}
+
+func (g *testRelayAddressGenerator) AllocatePacketConn(network string, requestedPort int) (net.PacketConn, net.Addr, error) {
+	conn, err := net.ListenPacket(network, "127.0.0.1:0")
+	if err != nil {
+		return nil, nil, err
+	}
+	return conn, conn.LocalAddr(), nil
+}
+
+func TestRelayAddressGenerator(t *testing.T) {
+	gen := NewRelayAddressGenerator(&testRelayAddressGenerator{}, "relay", 4, testLoggerFactory)
+	relay, addr, err := gen.AllocatePacketConn("udp4", 0)
+	assert.NoError(t, err, "allocate")
+	defer relay.Close()
+	_, ok := relay.(*batchConn)
+	assert.True(t, ok, "relay transport batched")
+	assert.Equal(t, addr.String(), relay.LocalAddr().String(), "relay address")
+
+	peer, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	assert.NoError(t, err, "peer socket")
+	defer peer.Close()
+
+	_, err = relay.WriteTo([]byte("hello"), peer.LocalAddr())
+	assert.NoError(t, err, "write to peer")
+	buf := make([]byte, 1500)
+	assert.NoError(t, peer.SetReadDeadline(time.Now().Add(5*time.Second)))
+	n, from, err := peer.ReadFrom(buf)
+	assert.NoError(t, err, "read from relay")
+	assert.Equal(t, "hello", string(buf[:n]), "payload")
+	assert.Equal(t, addr.String(), from.String(), "source")
+}
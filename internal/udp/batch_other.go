@@ -0,0 +1,18 @@
+//go:build !linux
+// +build !linux
+
+package udp
+
+import (
+	"net"
+
+	"github.com/pion/logging"
+)
+
+// newBatchConn is a no-op on platforms without recvmmsg(2)/sendmmsg(2): the connection is
+// returned unchanged.
+func newBatchConn(conn net.PacketConn, listener, socket string, batchSize int, logger logging.LoggerFactory) net.PacketConn {
+	logger.NewLogger("udp-batch").Debugf("batched UDP I/O unsupported on this platform, "+
+		"using plain socket at %s", conn.LocalAddr())
+	return conn
+}
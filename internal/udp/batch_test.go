@@ -0,0 +1,124 @@
+package udp
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pion/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+var testLoggerFactory = logging.NewDefaultLoggerFactory()
+
+func newLoopbackPair(t testing.TB) (net.PacketConn, net.PacketConn) {
+	a, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot create socket: %s", err.Error())
+	}
+	b, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("cannot create socket: %s", err.Error())
+	}
+	return a, b
+}
+
+func TestBatchConnRoundTrip(t *testing.T) {
+	for _, size := range []int{1, 8, DefaultBatchSize} {
+		t.Run(fmt.Sprintf("batch-size-%d", size), func(t *testing.T) {
+			a, b := newLoopbackPair(t)
+			sender := NewBatchConn(a, "test", size, testLoggerFactory)
+			receiver := NewBatchConn(b, "test", size, testLoggerFactory)
+
+			const num = 200
+			go func() {
+				for i := 0; i < num; i++ {
+					msg := []byte(fmt.Sprintf("packet-%03d", i))
+					_, err := sender.WriteTo(msg, receiver.LocalAddr())
+					assert.NoError(t, err, "write")
+				}
+			}()
+
+			buf := make([]byte, 1500)
+			assert.NoError(t, receiver.SetReadDeadline(time.Now().Add(5*time.Second)))
+			for i := 0; i < num; i++ {
+				n, from, err := receiver.ReadFrom(buf)
+				assert.NoError(t, err, "read")
+				assert.Equal(t, sender.LocalAddr().String(), from.String(), "source address")
+				// loopback preserves ordering
+				assert.Equal(t, fmt.Sprintf("packet-%03d", i), string(buf[:n]), "payload")
+			}
+
+			assert.NoError(t, sender.Close(), "close sender")
+			assert.NoError(t, receiver.Close(), "close receiver")
+
+			_, err := sender.WriteTo([]byte("late"), receiver.LocalAddr())
+			assert.Error(t, err, "write after close")
+		})
+	}
+}
+
+// benchmarkWrite measures the rate at which datagrams can be pushed into the kernel
+func benchmarkWrite(b *testing.B, wrap func(net.PacketConn) net.PacketConn) {
+	a, c := newLoopbackPair(b)
+	sender := wrap(a)
+	defer sender.Close()
+	defer c.Close()
+
+	payload := make([]byte, 1200) // typical RTP packet size
+	b.SetBytes(int64(len(payload)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := sender.WriteTo(payload, c.LocalAddr()); err != nil {
+			b.Fatalf("write: %s", err.Error())
+		}
+	}
+}
+
+// benchmarkRead measures the rate at which datagrams can be read while a sender keeps the
+// socket saturated
+func benchmarkRead(b *testing.B, wrap func(net.PacketConn) net.PacketConn) {
+	a, c := newLoopbackPair(b)
+	_ = c.(*net.UDPConn).SetReadBuffer(8 << 20)
+	receiver := wrap(c)
+	sender := wrapBatch(a)
+	defer sender.Close()
+
+	stop := make(chan struct{})
+	go func() {
+		payload := make([]byte, 1200)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				_, _ = sender.WriteTo(payload, receiver.LocalAddr())
+			}
+		}
+	}()
+
+	buf := make([]byte, 1500)
+	b.SetBytes(1200)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, err := receiver.ReadFrom(buf); err != nil {
+			b.Fatalf("read: %s", err.Error())
+		}
+	}
+	b.StopTimer()
+	close(stop)
+	receiver.Close()
+}
+
+func wrapPlain(c net.PacketConn) net.PacketConn { return c }
+
+func wrapBatch(c net.PacketConn) net.PacketConn {
+	return NewBatchConn(c, "test", DefaultBatchSize, testLoggerFactory)
+}
+
+func BenchmarkWritePlain(b *testing.B) { benchmarkWrite(b, wrapPlain) }
+func BenchmarkWriteBatch(b *testing.B) { benchmarkWrite(b, wrapBatch) }
+func BenchmarkReadPlain(b *testing.B)  { benchmarkRead(b, wrapPlain) }
+func BenchmarkReadBatch(b *testing.B)  { benchmarkRead(b, wrapBatch) }
@@ -0,0 +1,53 @@
+package udp
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"runtime"
+
+	"github.com/l7mp/stunner/internal/sockopt"
+)
+
+// NormalizeThreadNum returns the number of per-listener read-loop workers to run: -1 means one
+// worker per CPU, zero and other negative values mean a single worker.
+func NormalizeThreadNum(num int) int {
+	if num == -1 {
+		return runtime.NumCPU()
+	}
+	if num <= 0 {
+		return 1
+	}
+	return num
+}
+
+// ListenWorkers opens num UDP sockets bound to the same address with SO_REUSEPORT, with the given
+// socket options. The kernel hashes each client flow (by its 5-tuple) to one of the sockets, so
+// each worker sees a disjoint set of clients and can serve them without sharing state with the
+// other workers. On platforms where SO_REUSEPORT does not load-balance client flows across
+// sockets a single socket is opened: all clients are served by a single worker.
+func ListenWorkers(network, address string, num int, opts sockopt.Options) ([]net.PacketConn, error) {
+	if !sockopt.Supported.ReusePortBalancing {
+		num = 1
+	}
+	opts.ReusePort = num > 1
+
+	lc := net.ListenConfig{Control: opts.Control}
+	conns := make([]net.PacketConn, 0, num)
+	for i := 0; i < num; i++ {
+		conn, err := lc.ListenPacket(context.Background(), network, address)
+		if err != nil {
+			for _, c := range conns {
+				c.Close() //nolint:errcheck
+			}
+			return nil, fmt.Errorf("cannot open worker socket %d: %w", i, err)
+		}
+		// bind the rest of the workers to the same port if the first one got an ephemeral port
+		if i == 0 {
+			address = conn.LocalAddr().String()
+		}
+		conns = append(conns, conn)
+	}
+
+	return conns, nil
+}
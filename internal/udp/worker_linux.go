@@ -0,0 +1,53 @@
+//go:build linux
+// +build linux
+
+package udp
+
+import (
+	"net"
+	"runtime"
+	"sync"
+
+	"github.com/pion/logging"
+	"golang.org/x/sys/unix"
+)
+
+// pinnedConn pins the goroutine running the read loop on the socket to a CPU.
+type pinnedConn struct {
+	net.PacketConn
+	cpu  int
+	once sync.Once
+	log  logging.LeveledLogger
+}
+
+// NewPinnedConn wraps a packet connection so that the goroutine that reads from it is locked to
+// an OS thread and the thread is pinned to the given CPU (modulo the number of CPUs). This
+// assumes that a single goroutine reads from the connection, which is the case for the TURN
+// server read loop.
+func NewPinnedConn(conn net.PacketConn, cpu int, logger logging.LoggerFactory) net.PacketConn {
+	return &pinnedConn{
+		PacketConn: conn,
+		cpu:        cpu % runtime.NumCPU(),
+		log:        logger.NewLogger("udp-worker"),
+	}
+}
+
+func (c *pinnedConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	c.once.Do(c.pin)
+	return c.PacketConn.ReadFrom(b)
+}
+
+func (c *pinnedConn) pin() {
+	// never unlocked: the thread exits when the read loop goroutine does
+	runtime.LockOSThread()
+
+	var set unix.CPUSet
+	set.Zero()
+	set.Set(c.cpu)
+	if err := unix.SchedSetaffinity(0, &set); err != nil {
+		c.log.Warnf("cannot pin worker at %s to CPU %d: %s", c.LocalAddr(), c.cpu, err.Error())
+		return
+	}
+
+	c.log.Debugf("worker at %s pinned to CPU %d", c.LocalAddr(), c.cpu)
+}
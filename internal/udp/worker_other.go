@@ -0,0 +1,18 @@
+//go:build !linux
+// +build !linux
+
+package udp
+
+import (
+	"net"
+
+	"github.com/pion/logging"
+)
+
+// NewPinnedConn is a no-op on platforms without CPU affinity support: the connection is returned
+// unchanged.
+func NewPinnedConn(conn net.PacketConn, cpu int, logger logging.LoggerFactory) net.PacketConn {
+	logger.NewLogger("udp-worker").Debugf("CPU pinning unsupported on this platform, "+
+		"worker at %s not pinned", conn.LocalAddr())
+	return conn
+}
@@ -0,0 +1,72 @@
+package udp
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/l7mp/stunner/internal/sockopt"
+)
+
+func TestListenWorkers(t *testing.T) {
+	const workers = 4
+	const clients = 32
+
+	conns, err := ListenWorkers("udp4", "127.0.0.1:0", workers, sockopt.Options{})
+	assert.NoError(t, err, "listen")
+	defer func() {
+		for _, c := range conns {
+			c.Close() //nolint:errcheck
+		}
+	}()
+
+	expected := workers
+	if len(conns) == 1 {
+		// platform without SO_REUSEPORT load-balancing
+		expected = 1
+	}
+	assert.Len(t, conns, expected, "worker socket count")
+	for _, c := range conns {
+		assert.Equal(t, conns[0].LocalAddr().String(), c.LocalAddr().String(), "worker address")
+	}
+
+	// echo servers, pinned to CPUs
+	for i := range conns {
+		go func(c net.PacketConn) {
+			buf := make([]byte, 1500)
+			for {
+				n, addr, err := c.ReadFrom(buf)
+				if err != nil {
+					return
+				}
+				_, _ = c.WriteTo(buf[:n], addr)
+			}
+		}(NewPinnedConn(conns[i], i, testLoggerFactory))
+	}
+
+	// each client must be served by exactly one worker, whichever it is
+	var wg sync.WaitGroup
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c, err := net.Dial("udp4", conns[0].LocalAddr().String())
+			assert.NoError(t, err, "dial")
+			defer c.Close()
+
+			msg := fmt.Sprintf("client-%02d", i)
+			buf := make([]byte, 1500)
+			assert.NoError(t, c.SetReadDeadline(time.Now().Add(2*time.Second)), "deadline")
+			_, err = c.Write([]byte(msg))
+			assert.NoError(t, err, "write")
+			n, err := c.Read(buf)
+			assert.NoError(t, err, "read")
+			assert.Equal(t, msg, string(buf[:n]), "echo")
+		}(i)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,24 @@
+package util
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// addr is a transport address of another type, e.g., of a WebSocket connection
+type addr string
+
+func (a addr) Network() string { return "ws" }
+func (a addr) String() string  { return string(a) }
+
+func TestAddrIP(t *testing.T) {
+	ip := net.ParseIP("1.2.3.4")
+	assert.Equal(t, ip, AddrIP(&net.UDPAddr{IP: ip, Port: 1}), "UDP")
+	assert.Equal(t, ip, AddrIP(&net.TCPAddr{IP: ip, Port: 1}), "TCP")
+	assert.Equal(t, net.ParseIP("::1"), AddrIP(addr("[::1]:1")), "host and port")
+	assert.Nil(t, AddrIP(addr("::1")), "no port")
+	assert.Nil(t, AddrIP(&net.UnixAddr{Name: "/tmp/socket"}), "no IP")
+	assert.Nil(t, AddrIP(nil), "nil")
+}
@@ -0,0 +1,37 @@
+package util
+
+import (
+	"time"
+
+	"github.com/l7mp/stunner/internal/tokenbucket"
+)
+
+// Bucket returns the token bucket of a key from a set of at most max buckets, creating a full
+// bucket for new keys. If the set is full, the buckets that have been refilled are forgotten
+// first, and nil is returned if there is still no room for the key, in which case the caller
+// should not limit the key. Not safe for concurrent use
+func Bucket(buckets map[string]*tokenbucket.Bucket, key string, now time.Time,
+	rate, max int) *tokenbucket.Bucket {
+	b, ok := buckets[key]
+	if ok {
+		return b
+	}
+	if len(buckets) >= max {
+		PruneBuckets(buckets, now, rate)
+	}
+	if len(buckets) >= max {
+		return nil
+	}
+	b = tokenbucket.New(now, rate)
+	buckets[key] = b
+	return b
+}
+
+// PruneBuckets forgets the buckets that have been refilled
+func PruneBuckets(buckets map[string]*tokenbucket.Bucket, now time.Time, rate int) {
+	for key, b := range buckets {
+		if b.Full(now, rate) {
+			delete(buckets, key)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package util
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/l7mp/stunner/internal/tokenbucket"
+)
+
+func TestBucket(t *testing.T) {
+	now := time.Now()
+	buckets := map[string]*tokenbucket.Bucket{}
+
+	a := Bucket(buckets, "a", now, 1, 2)
+	assert.NotNil(t, a, "new bucket")
+	assert.True(t, a.Take(now, 1), "take")
+	assert.Equal(t, a, Bucket(buckets, "a", now, 1, 2), "same bucket")
+	b := Bucket(buckets, "b", now, 1, 2)
+	assert.NotNil(t, b, "new bucket")
+	assert.True(t, b.Take(now, 1), "take")
+
+	// no room: the buckets in use are kept
+	assert.Nil(t, Bucket(buckets, "c", now, 1, 2), "full")
+	assert.Len(t, buckets, 2, "buckets kept")
+
+	// the refilled buckets make room
+	later := now.Add(time.Duration(tokenbucket.BurstFactor) * time.Second)
+	assert.NotNil(t, Bucket(buckets, "c", later, 1, 2), "pruned")
+	assert.Len(t, buckets, 1, "refilled buckets forgotten")
+}
@@ -0,0 +1,98 @@
+package util
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// DefaultBufferSizes are the size classes of the default buffer pool: small STUN control
+// messages, MTU-sized media packets, jumbo frames and maximum-size UDP datagrams
+var DefaultBufferSizes = []int{256, 1600, 9216, 1 << 16}
+
+// DefaultBufferPool is the buffer pool shared by the listener and relay data path
+var DefaultBufferPool = NewBufferPool(DefaultBufferSizes...)
+
+// BufferPool is a set of sync.Pools that hand out byte buffers by size class, so that the
+// per-packet data path can run without heap allocations. Buffers are passed around as *[]byte to
+// avoid allocating a slice header on each Put.
+type BufferPool struct {
+	sizes []int
+	pools []sync.Pool
+	limit int64 // atomic, the largest size class in use, zero means all
+}
+
+// NewBufferPool creates a new buffer pool with the given size classes
+func NewBufferPool(sizes ...int) *BufferPool {
+	s := make([]int, len(sizes))
+	copy(s, sizes)
+	sort.Ints(s)
+
+	p := &BufferPool{sizes: s, pools: make([]sync.Pool, len(s))}
+	for i := range p.pools {
+		size := s[i]
+		p.pools[i].New = func() interface{} {
+			b := make([]byte, size)
+			return &b
+		}
+	}
+
+	return p
+}
+
+// SetMaxPooledSize limits the size classes in use to the ones not larger than size, the buffers
+// of the larger size classes are allocated from the heap and dropped on Put. Zero lifts the limit.
+func (p *BufferPool) SetMaxPooledSize(size int) {
+	atomic.StoreInt64(&p.limit, int64(size))
+}
+
+// Get returns a buffer of length size from the smallest size class that can hold it. Requests
+// larger than the largest size class in use are served from the heap.
+func (p *BufferPool) Get(size int) *[]byte {
+	i := p.class(size)
+	if i < 0 {
+		b := make([]byte, size)
+		return &b
+	}
+
+	b := p.pools[i].Get().(*[]byte)
+	*b = (*b)[:size]
+	return b
+}
+
+// Copy returns a copy of b in a buffer obtained from Get, to be returned to the pool with Put once
+// the copy is no longer in use
+func (p *BufferPool) Copy(b []byte) *[]byte {
+	c := p.Get(len(b))
+	copy(*c, b)
+	return c
+}
+
+// Put returns a buffer obtained from Get to the pool. Buffers whose capacity does not match a
+// size class are dropped.
+func (p *BufferPool) Put(b *[]byte) {
+	if b == nil {
+		return
+	}
+
+	c := cap(*b)
+	i := p.class(c)
+	if i < 0 || p.sizes[i] != c {
+		return
+	}
+
+	*b = (*b)[:c]
+	p.pools[i].Put(b)
+}
+
+// class returns the index of the smallest size class in use that can hold size bytes, or -1
+func (p *BufferPool) class(size int) int {
+	i := sort.SearchInts(p.sizes, size)
+	if i == len(p.sizes) {
+		return -1
+	}
+	if limit := atomic.LoadInt64(&p.limit); limit > 0 && int64(p.sizes[i]) > limit {
+		return -1
+	}
+	return i
+}
@@ -0,0 +1,68 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBufferPoolSizing(t *testing.T) {
+	p := NewBufferPool(1600, 256, 9216)
+
+	for _, c := range []struct{ size, cap int }{
+		{0, 256}, {1, 256}, {256, 256}, {257, 1600}, {1500, 1600}, {1600, 1600},
+		{1601, 9216}, {9216, 9216},
+		// served from the heap
+		{9217, 9217}, {1 << 16, 1 << 16},
+	} {
+		b := p.Get(c.size)
+		assert.Len(t, *b, c.size, "length for size %d", c.size)
+		assert.Equal(t, c.cap, cap(*b), "capacity for size %d", c.size)
+		p.Put(b)
+	}
+
+	// the larger size classes are not used over the limit
+	p.SetMaxPooledSize(1600)
+	b := p.Get(1601)
+	assert.Equal(t, 1601, cap(*b), "heap buffer over the limit")
+	b = p.Get(1600)
+	assert.Equal(t, 1600, cap(*b), "pooled buffer below the limit")
+	p.SetMaxPooledSize(0)
+	b = p.Get(1601)
+	assert.Equal(t, 9216, cap(*b), "limit lifted")
+
+	c := p.Copy([]byte("hello"))
+	assert.Equal(t, []byte("hello"), *c, "copy")
+	assert.Equal(t, 256, cap(*c), "copy capacity")
+	p.Put(nil)
+}
+
+func TestBufferPoolReuse(t *testing.T) {
+	p := NewBufferPool(256, 1600)
+
+	// sync.Pool may drop buffers at any time (and does so at random with the race detector),
+	// so only require that the buffers are reused at all
+	reused := false
+	for i := 0; i < 100 && !reused; i++ {
+		b := p.Get(100)
+		(*b)[0] = 42
+		p.Put(b)
+		c := p.Get(200)
+		reused = c == b
+		if reused {
+			assert.Len(t, *c, 200, "resliced on Get")
+			assert.Equal(t, byte(42), (*c)[0], "same buffer")
+		}
+		p.Put(c)
+	}
+	assert.True(t, reused, "buffer reused")
+
+	// buffers not of a size class are dropped, and never handed out
+	odd := make([]byte, 300)
+	for i := 0; i < 100; i++ {
+		p.Put(&odd)
+		b := p.Get(300)
+		assert.Equal(t, 1600, cap(*b), "odd buffer dropped")
+		assert.NotSame(t, &odd, b, "odd buffer dropped")
+	}
+}
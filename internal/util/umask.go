@@ -0,0 +1,11 @@
+//go:build !windows
+// +build !windows
+
+package util
+
+import "syscall"
+
+// Umask sets the file mode creation mask of the process and returns the previous mask
+func Umask(mask int) int {
+	return syscall.Umask(mask)
+}
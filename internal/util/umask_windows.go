@@ -0,0 +1,7 @@
+package util
+
+// Umask is a no-op on Windows: there is no file mode creation mask, the Unix socket files are
+// protected by the ACL of the directory they are created in
+func Umask(mask int) int {
+	return 0
+}
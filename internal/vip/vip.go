@@ -0,0 +1,53 @@
+// Package vip adds and removes the virtual IP address of a hot-standby pair of gateways on a
+// network interface. Adding an IPv4 address announces the new owner of the address on the link with
+// gratuitous ARP requests, so that the neighbors and the switches update their caches right away
+// instead of when their entries expire. Virtual IP addresses are only supported on Linux.
+package vip
+
+import (
+	"errors"
+	"net"
+)
+
+// ErrUnsupported is returned on the platforms without virtual IP support
+var ErrUnsupported = errors.New("virtual IP addresses are only supported on Linux")
+
+// Add adds an address to an interface and announces it, adding an address held already is not an
+// error
+func Add(iface string, addr *net.IPNet) error {
+	ifi, err := net.InterfaceByName(iface)
+	if err != nil {
+		return err
+	}
+	if err := addAddr(ifi, addr); err != nil {
+		return err
+	}
+	return announce(ifi, addr.IP)
+}
+
+// Remove removes an address from an interface, removing an address not held is not an error
+func Remove(iface string, addr *net.IPNet) error {
+	ifi, err := net.InterfaceByName(iface)
+	if err != nil {
+		return err
+	}
+	return delAddr(ifi, addr)
+}
+
+// Holds returns true if an interface holds an address
+func Holds(iface string, ip net.IP) bool {
+	ifi, err := net.InterfaceByName(iface)
+	if err != nil {
+		return false
+	}
+	addrs, err := ifi.Addrs()
+	if err != nil {
+		return false
+	}
+	for _, a := range addrs {
+		if n, ok := a.(*net.IPNet); ok && n.IP.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,142 @@
+package vip
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// arpAnnouncements is the number of gratuitous ARP requests sent for an address
+const arpAnnouncements = 3
+
+func addAddr(ifi *net.Interface, addr *net.IPNet) error {
+	err := netlinkRequest(addrMessage(unix.RTM_NEWADDR,
+		unix.NLM_F_REQUEST|unix.NLM_F_ACK|unix.NLM_F_CREATE|unix.NLM_F_EXCL, ifi.Index, addr))
+	if errors.Is(err, unix.EEXIST) {
+		return nil
+	}
+	return err
+}
+
+func delAddr(ifi *net.Interface, addr *net.IPNet) error {
+	err := netlinkRequest(addrMessage(unix.RTM_DELADDR, unix.NLM_F_REQUEST|unix.NLM_F_ACK,
+		ifi.Index, addr))
+	if errors.Is(err, unix.EADDRNOTAVAIL) {
+		return nil
+	}
+	return err
+}
+
+// addrMessage encodes a netlink message adding or removing an address of an interface: the
+// message header, the ifaddrmsg header and the IFA_LOCAL and IFA_ADDRESS attributes
+func addrMessage(typ, flags uint16, index int, addr *net.IPNet) []byte {
+	family, ip := uint8(unix.AF_INET), addr.IP.To4()
+	if ip == nil {
+		family, ip = unix.AF_INET6, addr.IP.To16()
+	}
+	ones, _ := addr.Mask.Size()
+	attrLen := rtaAlign(unix.SizeofRtAttr + len(ip))
+
+	b := make([]byte, unix.SizeofNlMsghdr+unix.SizeofIfAddrmsg+2*attrLen)
+	*(*unix.NlMsghdr)(unsafe.Pointer(&b[0])) = unix.NlMsghdr{
+		Len: uint32(len(b)), Type: typ, Flags: flags, Seq: 1}
+	*(*unix.IfAddrmsg)(unsafe.Pointer(&b[unix.SizeofNlMsghdr])) = unix.IfAddrmsg{
+		Family: family, Prefixlen: uint8(ones), Index: uint32(index)}
+
+	off := unix.SizeofNlMsghdr + unix.SizeofIfAddrmsg
+	for _, t := range []uint16{unix.IFA_LOCAL, unix.IFA_ADDRESS} {
+		*(*unix.RtAttr)(unsafe.Pointer(&b[off])) = unix.RtAttr{
+			Len: uint16(unix.SizeofRtAttr + len(ip)), Type: t}
+		copy(b[off+unix.SizeofRtAttr:], ip)
+		off += attrLen
+	}
+	return b
+}
+
+func rtaAlign(n int) int {
+	return (n + unix.RTA_ALIGNTO - 1) & ^(unix.RTA_ALIGNTO - 1)
+}
+
+// netlinkRequest sends a request to the routing netlink of the kernel and waits for the ack
+func netlinkRequest(msg []byte) error {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_ROUTE)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+
+	if err := unix.Sendto(fd, msg, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 4096)
+	for {
+		n, _, err := unix.Recvfrom(fd, buf, 0)
+		if err != nil {
+			return err
+		}
+		msgs, err := syscall.ParseNetlinkMessage(buf[:n])
+		if err != nil {
+			return err
+		}
+		for _, m := range msgs {
+			if m.Header.Type != unix.NLMSG_ERROR || m.Header.Seq != 1 || len(m.Data) < 4 {
+				continue
+			}
+			// the error code is in host byte order, zero for an ack
+			if code := *(*int32)(unsafe.Pointer(&m.Data[0])); code != 0 {
+				return unix.Errno(-code)
+			}
+			return nil
+		}
+	}
+}
+
+// announce sends gratuitous ARP requests for an IPv4 address on an Ethernet interface
+func announce(ifi *net.Interface, ip net.IP) error {
+	ip4 := ip.To4()
+	if ip4 == nil || len(ifi.HardwareAddr) != 6 {
+		return nil
+	}
+
+	proto := htons(unix.ETH_P_ARP)
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, int(proto))
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+
+	to := &unix.SockaddrLinklayer{Protocol: proto, Ifindex: ifi.Index, Halen: 6}
+	copy(to.Addr[:], []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	for i := 0; i < arpAnnouncements; i++ {
+		if err := unix.Sendto(fd, arpRequest(ifi.HardwareAddr, ip4), 0, to); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// arpRequest encodes a gratuitous ARP request: the sender and the target protocol address are
+// both the announced address
+func arpRequest(hw net.HardwareAddr, ip net.IP) []byte {
+	b := make([]byte, 28)
+	binary.BigEndian.PutUint16(b[0:2], 1)      // Ethernet
+	binary.BigEndian.PutUint16(b[2:4], 0x0800) // IPv4
+	b[4], b[5] = 6, 4
+	binary.BigEndian.PutUint16(b[6:8], 1) // request
+	copy(b[8:14], hw)
+	copy(b[14:18], ip)
+	copy(b[24:28], ip)
+	return b
+}
+
+// htons converts a value to network byte order
+func htons(v uint16) uint16 {
+	b := make([]byte, 2)
+	binary.BigEndian.PutUint16(b, v)
+	return *(*uint16)(unsafe.Pointer(&b[0]))
+}
@@ -0,0 +1,52 @@
+package vip
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+func TestAddrMessage(t *testing.T) {
+	_, addr, _ := net.ParseCIDR("192.0.2.10/24")
+	addr.IP = net.ParseIP("192.0.2.10")
+	b := addrMessage(unix.RTM_NEWADDR, unix.NLM_F_REQUEST, 7, addr)
+	assert.Len(t, b, unix.SizeofNlMsghdr+unix.SizeofIfAddrmsg+2*8, "length")
+
+	off := unix.SizeofNlMsghdr
+	assert.Equal(t, byte(unix.AF_INET), b[off], "family")
+	assert.Equal(t, byte(24), b[off+1], "prefix length")
+	off += unix.SizeofIfAddrmsg
+	assert.Equal(t, []byte{192, 0, 2, 10}, b[off+4:off+8], "local address")
+	assert.Equal(t, []byte{192, 0, 2, 10}, b[off+12:off+16], "address")
+
+	_, addr6, _ := net.ParseCIDR("2001:db8::10/64")
+	b = addrMessage(unix.RTM_DELADDR, unix.NLM_F_REQUEST, 7, addr6)
+	assert.Len(t, b, unix.SizeofNlMsghdr+unix.SizeofIfAddrmsg+2*20, "length")
+	assert.Equal(t, byte(unix.AF_INET6), b[unix.SizeofNlMsghdr], "family")
+}
+
+func TestARPRequest(t *testing.T) {
+	hw, _ := net.ParseMAC("02:00:00:00:00:01")
+	b := arpRequest(hw, net.ParseIP("192.0.2.10").To4())
+	assert.Equal(t, []byte{0, 1, 8, 0, 6, 4, 0, 1}, b[:8], "header")
+	assert.Equal(t, []byte(hw), b[8:14], "sender hardware address")
+	assert.Equal(t, b[14:18], b[24:28], "gratuitous")
+}
+
+func TestAddRemove(t *testing.T) {
+	_, addr, _ := net.ParseCIDR("127.0.0.77/32")
+	if err := Add("lo", addr); errors.Is(err, unix.EPERM) || errors.Is(err, unix.EACCES) {
+		t.Skip("no permission to add addresses")
+	} else {
+		assert.NoError(t, err, "add")
+	}
+	assert.True(t, Holds("lo", addr.IP), "added")
+	assert.NoError(t, Add("lo", addr), "add again")
+
+	assert.NoError(t, Remove("lo", addr), "remove")
+	assert.False(t, Holds("lo", addr.IP), "removed")
+	assert.NoError(t, Remove("lo", addr), "remove again")
+}
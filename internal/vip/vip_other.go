@@ -0,0 +1,12 @@
+//go:build !linux
+// +build !linux
+
+package vip
+
+import "net"
+
+func addAddr(*net.Interface, *net.IPNet) error { return ErrUnsupported }
+
+func delAddr(*net.Interface, *net.IPNet) error { return ErrUnsupported }
+
+func announce(*net.Interface, net.IP) error { return nil }
@@ -0,0 +1,93 @@
+package ws
+
+import (
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// closeTimeout is the time allowed for sending the close frame of a connection
+const closeTimeout = time.Second
+
+// conn is a TURN-over-WebSocket connection. Reads return the payload of the data frames as a byte
+// stream, like TCP, and each write is sent in a single binary frame
+type conn struct {
+	ws        *websocket.Conn
+	reader    io.Reader
+	writeLock sync.Mutex
+	once      sync.Once
+	onClose   func()
+}
+
+func newConn(ws *websocket.Conn) *conn {
+	return &conn{ws: ws}
+}
+
+func (c *conn) Read(b []byte) (int, error) {
+	for {
+		if c.reader == nil {
+			_, r, err := c.ws.NextReader()
+			if websocket.IsCloseError(err, websocket.CloseNormalClosure,
+				websocket.CloseGoingAway, websocket.CloseNoStatusReceived) {
+				return 0, io.EOF
+			} else if err != nil {
+				return 0, err
+			}
+			c.reader = r
+		}
+
+		n, err := c.reader.Read(b)
+		if err == io.EOF {
+			c.reader = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+// Write sends b in a single binary frame, writes may be called concurrently
+func (c *conn) Write(b []byte) (int, error) {
+	c.writeLock.Lock()
+	defer c.writeLock.Unlock()
+
+	if err := c.ws.WriteMessage(websocket.BinaryMessage, b); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Close sends a close frame and closes the underlying connection
+func (c *conn) Close() error {
+	var err error
+	c.once.Do(func() {
+		msg := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "")
+		c.ws.WriteControl(websocket.CloseMessage, msg, time.Now().Add(closeTimeout)) //nolint:errcheck
+		err = c.ws.Close()
+		if c.onClose != nil {
+			c.onClose()
+		}
+	})
+	return err
+}
+
+// LocalAddr returns the local address of the underlying TCP connection
+func (c *conn) LocalAddr() net.Addr { return c.ws.LocalAddr() }
+
+// RemoteAddr returns the remote address of the underlying TCP connection
+func (c *conn) RemoteAddr() net.Addr { return c.ws.RemoteAddr() }
+
+func (c *conn) SetDeadline(t time.Time) error {
+	if err := c.ws.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.ws.SetWriteDeadline(t)
+}
+
+func (c *conn) SetReadDeadline(t time.Time) error  { return c.ws.SetReadDeadline(t) }
+func (c *conn) SetWriteDeadline(t time.Time) error { return c.ws.SetWriteDeadline(t) }
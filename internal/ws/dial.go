@@ -0,0 +1,25 @@
+package ws
+
+import (
+	"crypto/tls"
+	"net"
+
+	"github.com/gorilla/websocket"
+)
+
+// Dial opens a TURN-over-WebSocket client connection to the given ws:// or wss:// URL.
+func Dial(url string, tlsConf *tls.Config) (net.Conn, error) {
+	dialer := &websocket.Dialer{
+		Proxy:            websocket.DefaultDialer.Proxy,
+		HandshakeTimeout: handshakeTimeout,
+		TLSClientConfig:  tlsConf,
+		Subprotocols:     []string{Subprotocol},
+	}
+
+	wsConn, _, err := dialer.Dial(url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return newConn(wsConn), nil
+}
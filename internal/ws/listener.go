@@ -0,0 +1,154 @@
+// Package ws implements a TURN transport that tunnels TURN messages over WebSocket, for clients
+// behind proxies that only let HTTP(S) through. Each binary WebSocket frame carries a STUN
+// message or a ChannelData message, and the resulting connections are served by the TURN server
+// just like TCP/TLS connections.
+package ws
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/pion/logging"
+)
+
+// Subprotocol is the WebSocket subprotocol negotiated with clients that ask for one.
+const Subprotocol = "turn"
+
+const (
+	// handshakeTimeout bounds the time for reading the request headers of the WebSocket
+	// handshake, so that slowloris clients cannot hold the sockets of the listener
+	handshakeTimeout = 10 * time.Second
+	// idleTimeout bounds the time an HTTP connection may sit idle before the handshake
+	idleTimeout = 60 * time.Second
+)
+
+var errListenerClosed = errors.New("websocket listener closed")
+
+// listener is a net.Listener that accepts TURN-over-WebSocket connections
+type listener struct {
+	server   *http.Server
+	upgrader *websocket.Upgrader
+	ln       net.Listener
+	accept   chan *conn
+	done     chan struct{}
+	once     sync.Once
+	lock     sync.Mutex
+	conns    map[*conn]bool
+	log      logging.LeveledLogger
+}
+
+// Listen creates a WebSocket listener at the given address. If tlsConf is not nil the listener
+// serves wss://, otherwise plain ws:// (e.g., behind a TLS-terminating ingress). Any request path
+// is accepted.
+func Listen(addr string, tlsConf *tls.Config, logger logging.LoggerFactory) (net.Listener, error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return NewListener(ln, tlsConf, logger), nil
+}
+
+// NewListener creates a WebSocket listener on a TCP listener, e.g., a socket taken over from
+// another process. If tlsConf is not nil the listener serves wss://, otherwise plain ws://.
+func NewListener(ln net.Listener, tlsConf *tls.Config, logger logging.LoggerFactory) net.Listener {
+	addr := ln.Addr().String()
+	if tlsConf != nil {
+		ln = tls.NewListener(ln, tlsConf)
+	}
+
+	l := &listener{
+		upgrader: &websocket.Upgrader{
+			HandshakeTimeout: handshakeTimeout,
+			Subprotocols:     []string{Subprotocol},
+			// origin checks are meaningless for TURN: clients authenticate with TURN
+			// credentials
+			CheckOrigin: func(*http.Request) bool { return true },
+		},
+		ln:     ln,
+		accept: make(chan *conn),
+		done:   make(chan struct{}),
+		conns:  make(map[*conn]bool),
+		log:    logger.NewLogger("websocket"),
+	}
+
+	l.server = &http.Server{
+		Handler:           http.HandlerFunc(l.handle),
+		ReadHeaderTimeout: handshakeTimeout,
+		IdleTimeout:       idleTimeout,
+	}
+
+	go func() {
+		if err := l.server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			l.log.Warnf("websocket listener at %s failed: %s", addr, err.Error())
+		}
+	}()
+
+	return l
+}
+
+// handle upgrades the request to a WebSocket connection and hands it over to Accept. The
+// connection is hijacked from the HTTP server, so it outlives the handler
+func (l *listener) handle(w http.ResponseWriter, req *http.Request) {
+	wsConn, err := l.upgrader.Upgrade(w, req, nil)
+	if err != nil {
+		// the upgrader has already replied with an HTTP error
+		l.log.Debugf("websocket handshake from %s failed: %s", req.RemoteAddr, err.Error())
+		return
+	}
+
+	c := newConn(wsConn)
+	l.lock.Lock()
+	l.conns[c] = true
+	l.lock.Unlock()
+	c.onClose = func() {
+		l.lock.Lock()
+		delete(l.conns, c)
+		l.lock.Unlock()
+	}
+
+	l.log.Debugf("new websocket connection from %s (path: %s)", c.RemoteAddr(), req.URL.Path)
+
+	select {
+	case l.accept <- c:
+	case <-l.done:
+		c.Close() //nolint:errcheck
+	}
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.accept:
+		return c, nil
+	case <-l.done:
+		return nil, errListenerClosed
+	}
+}
+
+// Close stops the listener and closes all active connections
+func (l *listener) Close() error {
+	var err error
+	l.once.Do(func() {
+		close(l.done)
+		err = l.server.Close()
+
+		l.lock.Lock()
+		conns := make([]*conn, 0, len(l.conns))
+		for c := range l.conns {
+			conns = append(conns, c)
+		}
+		l.lock.Unlock()
+		for _, c := range conns {
+			c.Close() //nolint:errcheck
+		}
+	})
+	return err
+}
+
+func (l *listener) Addr() net.Addr {
+	return l.ln.Addr()
+}
@@ -0,0 +1,81 @@
+package ws
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pion/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebSocket(t *testing.T) {
+	ln, err := Listen("127.0.0.1:0", nil, logging.NewDefaultLoggerFactory())
+	assert.NoError(t, err, "listen")
+	defer ln.Close()
+
+	l := ln.(*listener)
+	assert.Equal(t, handshakeTimeout, l.server.ReadHeaderTimeout, "read header timeout")
+	assert.Equal(t, idleTimeout, l.server.IdleTimeout, "idle timeout")
+
+	client, err := Dial("ws://"+ln.Addr().String()+"/turn", nil)
+	assert.NoError(t, err, "dial")
+	defer client.Close()
+	assert.Equal(t, Subprotocol, client.(*conn).ws.Subprotocol(), "subprotocol")
+
+	server, err := ln.Accept()
+	assert.NoError(t, err, "accept")
+	assert.Equal(t, client.LocalAddr().String(), server.RemoteAddr().String(), "remote address")
+	assert.IsType(t, &net.TCPAddr{}, server.RemoteAddr(), "TCP address")
+	assert.Equal(t, ln.Addr().String(), server.LocalAddr().String(), "local address")
+
+	// the frames are read as a byte stream
+	_, err = client.Write([]byte("abc"))
+	assert.NoError(t, err, "write")
+	_, err = client.Write([]byte("def"))
+	assert.NoError(t, err, "write")
+	buf := make([]byte, 6)
+	assert.NoError(t, server.SetReadDeadline(time.Now().Add(time.Second)), "deadline")
+	_, err = io.ReadFull(server, buf)
+	assert.NoError(t, err, "read")
+	assert.Equal(t, "abcdef", string(buf), "stream")
+
+	// short reads leave the rest of the frame for the next read
+	_, err = server.Write([]byte("ghijkl"))
+	assert.NoError(t, err, "write")
+	n, err := client.Read(buf[:4])
+	assert.NoError(t, err, "read")
+	assert.Equal(t, "ghij", string(buf[:n]), "first part")
+	n, err = client.Read(buf)
+	assert.NoError(t, err, "read")
+	assert.Equal(t, "kl", string(buf[:n]), "second part")
+
+	// closing the client ends the stream on the server
+	assert.NoError(t, client.Close(), "close")
+	_, err = server.Read(buf)
+	assert.Equal(t, io.EOF, err, "EOF")
+	assert.NoError(t, server.Close(), "close")
+}
+
+func TestWebSocketListenerClose(t *testing.T) {
+	ln, err := Listen("127.0.0.1:0", nil, logging.NewDefaultLoggerFactory())
+	assert.NoError(t, err, "listen")
+
+	client, err := Dial("ws://"+ln.Addr().String()+"/", nil)
+	assert.NoError(t, err, "dial")
+	defer client.Close()
+	_, err = ln.Accept()
+	assert.NoError(t, err, "accept")
+
+	// the active connections are closed with the listener
+	assert.NoError(t, ln.Close(), "close")
+	assert.NoError(t, client.SetReadDeadline(time.Now().Add(time.Second)), "deadline")
+	_, err = client.Read(make([]byte, 16))
+	assert.Equal(t, io.EOF, err, "connection closed")
+	_, err = ln.Accept()
+	assert.Equal(t, errListenerClosed, err, "listener closed")
+
+	_, err = Dial("ws://"+ln.Addr().String()+"/", nil)
+	assert.Error(t, err, "dial closed listener")
+}
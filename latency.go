@@ -0,0 +1,130 @@
+package stunner
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/l7mp/stunner/internal/monitoring"
+	"github.com/l7mp/stunner/internal/probe"
+	"github.com/l7mp/stunner/pkg/apis/v1alpha1"
+)
+
+// latencyProber holds the running latency probe config and stops the probes on reconfiguration
+type latencyProber struct {
+	lock   sync.Mutex
+	conf   *v1alpha1.LatencyProbeConfig
+	cancel context.CancelFunc
+}
+
+func newLatencyProber() *latencyProber {
+	return &latencyProber{}
+}
+
+// reconcileLatencyProbe restarts the latency probes when the latency probe config changes. No
+// probes are sent in dry-run mode
+func (s *Stunner) reconcileLatencyProbe() {
+	var conf *v1alpha1.LatencyProbeConfig
+	if !s.options.DryRun {
+		conf = s.GetAdmin().LatencyProbe
+	}
+
+	p := s.latencyProber
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if reflect.DeepEqual(conf, p.conf) {
+		return
+	}
+	if p.cancel != nil {
+		p.cancel()
+		p.cancel = nil
+	}
+	monitoring.ClusterProbeLossGauge.Reset()
+	p.conf = conf.DeepCopy()
+	if conf == nil {
+		return
+	}
+
+	prober, err := probe.NewProber(conf.Protocol, conf.Port,
+		time.Duration(conf.Timeout)*time.Millisecond)
+	if err == nil {
+		err = prober.Check()
+	}
+	if err != nil {
+		s.log.Errorf("latency probing disabled: %s", err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p.cancel = cancel
+	go s.runLatencyProbe(ctx, prober, time.Duration(conf.Interval)*time.Second, conf.SampleSize)
+	s.log.Infof("latency probing enabled: protocol: %s, interval: %ds, endpoints per cluster: %d",
+		conf.Protocol, conf.Interval, conf.SampleSize)
+}
+
+// runLatencyProbe probes the clusters periodically until the context is canceled or STUNner is
+// closed
+func (s *Stunner) runLatencyProbe(ctx context.Context, prober *probe.Prober, interval time.Duration, sampleSize int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		s.probeClusters(ctx, prober, sampleSize)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// probeClusters sends a round of probes to a sample of the endpoints of each cluster in parallel,
+// and exports the round-trip times and the loss per cluster
+func (s *Stunner) probeClusters(ctx context.Context, prober *probe.Prober, sampleSize int) {
+	loss := map[string]float64{}
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	for _, name := range s.clusterManager.Keys() {
+		ips := sampleEndpoints(s.GetCluster(name), sampleSize)
+		if len(ips) == 0 {
+			continue
+		}
+		loss[name] = 0
+
+		for _, ip := range ips {
+			wg.Add(1)
+			go func(cluster string, ip net.IP, n int) {
+				defer wg.Done()
+				rtt, err := prober.Probe(ctx, ip)
+				if err != nil {
+					if ctx.Err() != nil {
+						return
+					}
+					s.log.Debugf("latency probe to endpoint %s of cluster %s lost: %s",
+						ip, cluster, err.Error())
+					monitoring.ClusterProbeCounter.WithLabelValues(cluster, "lost").Inc()
+					lock.Lock()
+					loss[cluster] += 1 / float64(n)
+					lock.Unlock()
+					return
+				}
+				monitoring.ClusterProbeRTTHistogram.WithLabelValues(cluster).Observe(rtt.Seconds())
+				monitoring.ClusterProbeCounter.WithLabelValues(cluster, "success").Inc()
+			}(name, ip, len(ips))
+		}
+	}
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return
+	}
+	monitoring.ClusterProbeLossGauge.Reset()
+	for cluster, l := range loss {
+		monitoring.ClusterProbeLossGauge.WithLabelValues(cluster).Set(l)
+	}
+}
@@ -0,0 +1,35 @@
+package stunner
+
+import (
+	"net"
+
+	"github.com/l7mp/stunner/internal/ban"
+	"github.com/l7mp/stunner/internal/malformed"
+)
+
+// reconcileMalformed sets the malformed traffic defenses from the admin config, or disables the
+// defenses if none is configured
+func (s *Stunner) reconcileMalformed() {
+	req := s.GetAdmin().Malformed
+	if req == nil {
+		s.malformed.SetConfig(nil)
+		return
+	}
+
+	s.malformed.SetConfig(&malformed.Config{
+		MaxMessageSize: req.MaxMessageSize,
+		DropGarbage:    req.DropGarbage,
+	})
+}
+
+// reportMalformed reports the malformed packets dropped before reaching the conntrack table to the
+// ban table
+func (s *Stunner) reportMalformed(listener string, client net.Addr) {
+	s.bans.Report(listener, client.String(), ban.ReasonMalformedPacket)
+}
+
+// GetMalformedSources returns the client sources that sent malformed packets, the most prolific
+// first
+func (s *Stunner) GetMalformedSources() []malformed.Source {
+	return s.malformed.Sources()
+}
@@ -0,0 +1,272 @@
+package stunner
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"sigs.k8s.io/yaml"
+
+	"github.com/l7mp/stunner/pkg/apis/v1alpha1"
+)
+
+// configIncludeKey is the top-level key of a config document listing the files (or glob patterns,
+// relative to the directory of the including file) to be merged before the document
+const configIncludeKey = "include"
+
+// configDocSeparator separates the documents of a multi-document YAML config
+var configDocSeparator = regexp.MustCompile(`(?m)^---[ \t]*$`)
+
+// configLoader loads a config file with all its documents and includes, in merge order
+type configLoader struct {
+	docs    []map[string]interface{}
+	loading map[string]bool
+	hash    hash.Hash
+}
+
+func newConfigLoader() *configLoader {
+	return &configLoader{loading: map[string]bool{}, hash: sha256.New()}
+}
+
+// load reads a config file, substitutes environment variables and appends its documents to the
+// merge list, each document preceded by the files it includes
+func (l *configLoader) load(file string) error {
+	path, err := filepath.Abs(file)
+	if err != nil {
+		return fmt.Errorf("could not read config: %s", err.Error())
+	}
+	if l.loading[path] {
+		return fmt.Errorf("include cycle at config file '%s'", file)
+	}
+	l.loading[path] = true
+	defer delete(l.loading, path)
+
+	raw, err := os.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("could not read config: %w", err)
+	}
+	l.hash.Write(raw) //nolint:errcheck
+
+	e, err := ExpandEnv(string(raw))
+	if err != nil {
+		return fmt.Errorf("could not substitute environment variables in config file at "+
+			"'%s': %s", file, err.Error())
+	}
+
+	var docs []map[string]interface{}
+	if strings.EqualFold(filepath.Ext(file), ".toml") {
+		docs, err = parseConfigTOML([]byte(e))
+	} else {
+		docs, err = splitConfig([]byte(e))
+	}
+	if err != nil {
+		return fmt.Errorf("could not parse config file at '%s': %s", file, err.Error())
+	}
+
+	for _, doc := range docs {
+		includes, err := configIncludes(doc)
+		if err != nil {
+			return fmt.Errorf("invalid config file at '%s': %s", file, err.Error())
+		}
+		delete(doc, configIncludeKey)
+
+		for _, pattern := range includes {
+			if !filepath.IsAbs(pattern) {
+				pattern = filepath.Join(filepath.Dir(file), pattern)
+			}
+			matches, err := filepath.Glob(pattern)
+			if err != nil {
+				return fmt.Errorf("invalid include pattern '%s' in config file at '%s': %s",
+					pattern, file, err.Error())
+			}
+			// a plain file name that matches nothing is an error, an empty glob is not
+			if len(matches) == 0 && !strings.ContainsAny(pattern, "*?[") {
+				return fmt.Errorf("could not find config file '%s' included from '%s'",
+					pattern, file)
+			}
+			for _, m := range matches {
+				if err := l.load(m); err != nil {
+					return err
+				}
+			}
+		}
+
+		l.docs = append(l.docs, doc)
+	}
+
+	return nil
+}
+
+// configIncludes returns the include list of a config document
+func configIncludes(doc map[string]interface{}) ([]string, error) {
+	switch v := doc[configIncludeKey].(type) {
+	case nil:
+		return []string{}, nil
+	case string:
+		return []string{v}, nil
+	case []interface{}:
+		ret := make([]string, len(v))
+		for i := range v {
+			s, ok := v[i].(string)
+			if !ok {
+				return nil, fmt.Errorf("invalid include: %v", v[i])
+			}
+			ret[i] = s
+		}
+		return ret, nil
+	default:
+		return nil, fmt.Errorf("invalid include: %v", v)
+	}
+}
+
+// splitConfig parses each document of a multi-document YAML or JSON config, empty documents are
+// skipped
+func splitConfig(c []byte) ([]map[string]interface{}, error) {
+	docs := []map[string]interface{}{}
+	for i, d := range configDocSeparator.Split(string(c), -1) {
+		j, err := yaml.YAMLToJSON([]byte(d))
+		if err != nil {
+			return nil, fmt.Errorf("document %d: %s", i, err.Error())
+		}
+		doc := map[string]interface{}{}
+		if err := json.Unmarshal(j, &doc); err != nil {
+			return nil, fmt.Errorf("document %d: %s", i, err.Error())
+		}
+		if len(doc) == 0 {
+			continue
+		}
+		docs = append(docs, doc)
+	}
+	return docs, nil
+}
+
+// parseConfigTOML parses a config in TOML format into a config document, using the same keys as
+// the YAML and JSON formats
+func parseConfigTOML(c []byte) ([]map[string]interface{}, error) {
+	doc := map[string]interface{}{}
+	if err := toml.Unmarshal(c, &doc); err != nil {
+		return nil, fmt.Errorf("TOML parse error: %s", err.Error())
+	}
+
+	// normalize arrays of tables and integers so that TOML documents can be merged with YAML
+	// and JSON documents
+	j, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+	doc = map[string]interface{}{}
+	if err := json.Unmarshal(j, &doc); err != nil {
+		return nil, err
+	}
+
+	if len(doc) == 0 {
+		return []map[string]interface{}{}, nil
+	}
+	return []map[string]interface{}{doc}, nil
+}
+
+// MergeConfigDocs merges config documents in order into a single document. Later documents take
+// precedence: objects (like "admin" or "auth") are merged recursively key by key, listeners and
+// clusters are matched by name and merged the same way (unknown names are appended), and all other
+// values, including lists like routes or endpoints, are replaced. The API version must be the same
+// in all documents that specify it.
+func MergeConfigDocs(docs ...map[string]interface{}) (map[string]interface{}, error) {
+	ret := map[string]interface{}{}
+	for _, doc := range docs {
+		if err := mergeConfigDoc(ret, doc); err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+func mergeConfigDoc(dst, src map[string]interface{}) error {
+	for k, v := range src {
+		switch k {
+		case "version":
+			if old, ok := dst[k]; ok && old != v {
+				return fmt.Errorf("conflicting API versions: %v and %v", old, v)
+			}
+			dst[k] = v
+		case "listeners", "clusters":
+			list, err := mergeNamedList(k, dst[k], v)
+			if err != nil {
+				return err
+			}
+			dst[k] = list
+		default:
+			dst[k] = mergeValue(dst[k], v)
+		}
+	}
+	return nil
+}
+
+// mergeValue merges maps recursively and replaces everything else
+func mergeValue(dst, src interface{}) interface{} {
+	d, ok1 := dst.(map[string]interface{})
+	s, ok2 := src.(map[string]interface{})
+	if !ok1 || !ok2 {
+		return src
+	}
+	for k, v := range s {
+		d[k] = mergeValue(d[k], v)
+	}
+	return d
+}
+
+// mergeNamedList merges a list of objects by name, keeping the order of first appearance
+func mergeNamedList(kind string, dst, src interface{}) ([]interface{}, error) {
+	ret, _ := dst.([]interface{})
+	list, ok := src.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: expected a list", kind)
+	}
+
+	for _, e := range list {
+		obj, ok := e.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%s: expected an object, got %v", kind, e)
+		}
+		// unnamed objects are never merged, validation will reject them
+		name, ok := obj["name"].(string)
+		if !ok || name == "" {
+			ret = append(ret, obj)
+			continue
+		}
+
+		found := false
+		for i := range ret {
+			if old, ok := ret[i].(map[string]interface{}); ok && old["name"] == name {
+				ret[i] = mergeValue(old, obj)
+				found = true
+				break
+			}
+		}
+		if !found {
+			ret = append(ret, obj)
+		}
+	}
+
+	return ret, nil
+}
+
+// parseConfigDocs parses a list of config documents after merging them
+func parseConfigDocs(docs []map[string]interface{}, strict bool) (*v1alpha1.StunnerConfig, error) {
+	doc, err := MergeConfigDocs(docs...)
+	if err != nil {
+		return nil, err
+	}
+
+	j, err := json.Marshal(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	return parseConfig(j, strict)
+}
@@ -0,0 +1,209 @@
+package stunner
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/l7mp/stunner/internal/ban"
+	"github.com/l7mp/stunner/internal/conntrack"
+	"github.com/l7mp/stunner/pkg/apis/v1alpha1"
+)
+
+// OperationalEvent is posted to the notifier webhook when something happens on the data plane
+// that external systems may want to react to
+type OperationalEvent struct {
+	// Type is the type of the event: "allocation-created", "allocation-deleted",
+	// "auth-failures", "listener-failed" or "reconcile-failed"
+	Type string `json:"type"`
+	// Node is the name of the daemon
+	Node string `json:"node,omitempty"`
+	// Time is the time of the event
+	Time time.Time `json:"time"`
+	// Message is a human-readable description of the event
+	Message string `json:"message,omitempty"`
+	// SessionID is the session ID of the allocation the event is about
+	SessionID string `json:"session_id,omitempty"`
+	// Listener is the name of the listener the event is about
+	Listener string `json:"listener,omitempty"`
+	// Username is the username of the client the event is about
+	Username string `json:"username,omitempty"`
+	// Client is the address of the client the event is about
+	Client string `json:"client,omitempty"`
+	// Relay is the relay address of the allocation the event is about
+	Relay string `json:"relay,omitempty"`
+	// Count is the number of failed authentications in the last minute for "auth-failures"
+	// events
+	Count int `json:"count,omitempty"`
+	// conf is the notifier config the event is posted with
+	conf *v1alpha1.NotifierConfig
+}
+
+// notifierQueueLen is the number of events queued for posting, events are dropped if the webhook
+// is slow
+const notifierQueueLen = 256
+
+// authFailureWindow is the window the failed authentications are counted in
+const authFailureWindow = time.Minute
+
+// notifier posts operational events to the webhook set in the admin config
+type notifier struct {
+	config       atomic.Value // notifierConfig
+	queue        chan OperationalEvent
+	lock         sync.Mutex
+	authFailures []time.Time
+}
+
+type notifierConfig struct {
+	node string
+	conf *v1alpha1.NotifierConfig
+}
+
+func newNotifier() *notifier {
+	n := &notifier{queue: make(chan OperationalEvent, notifierQueueLen)}
+	n.config.Store(notifierConfig{})
+	return n
+}
+
+// reconcileNotifier sets the notifier to post to the webhook set in the admin config, or disables
+// the notifier if no webhook is set. The conntrack events feed the ban table too, so they are
+// observed if either the notifier or banning is enabled
+func (s *Stunner) reconcileNotifier() {
+	admin := s.GetAdmin()
+	s.notifier.config.Store(notifierConfig{node: admin.Name, conf: admin.Notifier.DeepCopy()})
+
+	if admin.Notifier == nil && admin.Ban == nil {
+		s.conntrack.SetObserver(nil)
+		return
+	}
+	s.conntrack.SetObserver(s.observeConntrack)
+}
+
+// notify queues an operational event for posting, provided that the notifier is enabled for the
+// event type
+func (s *Stunner) notify(e OperationalEvent) {
+	c := s.notifier.config.Load().(notifierConfig)
+	if c.conf == nil || !c.conf.Enabled(e.Type) {
+		return
+	}
+
+	e.Node, e.conf = c.node, c.conf
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+
+	select {
+	case s.notifier.queue <- e:
+	default:
+		s.log.Debugf("notifier queue full, dropping %s event", e.Type)
+	}
+}
+
+// observeConntrack turns the allocation and the authentication events of the conntrack table into
+// operational events, and reports the failed authentications and the malformed packets to the ban
+// table
+func (s *Stunner) observeConntrack(r conntrack.AccessLogRecord) {
+	e := OperationalEvent{Time: r.Time, SessionID: r.SessionID, Listener: r.Listener,
+		Username: r.Username, Client: r.Client, Relay: r.Relay}
+
+	switch r.Event {
+	case "malformed":
+		s.bans.Report(r.Listener, r.Client, ban.ReasonMalformedPacket)
+		return
+	case "start":
+		e.Type = v1alpha1.NotifierEventAllocationCreated
+	case "stop":
+		e.Type = v1alpha1.NotifierEventAllocationDeleted
+	case "auth-failure":
+		s.bans.Report(r.Listener, r.Client, ban.ReasonAuthFailure)
+		count := s.countAuthFailure(r.Time)
+		if count == 0 {
+			return
+		}
+		e.Type = v1alpha1.NotifierEventAuthFailures
+		e.Count = count
+		e.Message = fmt.Sprintf("%d failed authentications in the last minute", count)
+	default:
+		return
+	}
+
+	s.notify(e)
+}
+
+// countAuthFailure counts a failed authentication and returns the number of failures in the last
+// minute once it reaches the threshold, or zero otherwise. The counter is reset when the
+// threshold is reached, so a sustained burst fires an event per threshold failures
+func (s *Stunner) countAuthFailure(t time.Time) int {
+	c := s.notifier.config.Load().(notifierConfig)
+	if c.conf == nil {
+		return 0
+	}
+
+	n := s.notifier
+	n.lock.Lock()
+	defer n.lock.Unlock()
+
+	n.authFailures = append(n.authFailures, t)
+	i := 0
+	for i < len(n.authFailures) && t.Sub(n.authFailures[i]) > authFailureWindow {
+		i++
+	}
+	n.authFailures = n.authFailures[i:]
+
+	count := len(n.authFailures)
+	if count < c.conf.AuthFailureThreshold {
+		return 0
+	}
+	n.authFailures = nil
+	return count
+}
+
+// runNotifier posts the queued operational events to the notifier webhook
+func (s *Stunner) runNotifier() {
+	client := &http.Client{Timeout: eventWebhookTimeout}
+	for {
+		select {
+		case e := <-s.notifier.queue:
+			if err := postOperationalEvent(client, e); err != nil {
+				s.log.Warnf("could not post %s event to notifier %s: %s", e.Type,
+					e.conf.URL, err.Error())
+			}
+		case <-s.done:
+			return
+		}
+	}
+}
+
+func postOperationalEvent(client *http.Client, e OperationalEvent) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), eventWebhookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.conf.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	for k, v := range e.conf.Headers {
+		req.Header.Set(k, v)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return nil
+}
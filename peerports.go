@@ -0,0 +1,30 @@
+package stunner
+
+import (
+	"github.com/l7mp/stunner/internal/peerport"
+	"github.com/l7mp/stunner/pkg/apis/v1alpha1"
+)
+
+// reconcilePeerPorts sets the peer port policy of the relay transports from the admin config, or
+// allows all peer ports if no policy is configured
+func (s *Stunner) reconcilePeerPorts() {
+	req := s.GetAdmin().PeerPorts
+	if req == nil {
+		s.peerPorts.SetPolicy(nil)
+		return
+	}
+
+	policy := &peerport.Policy{}
+	for _, r := range req.Allow {
+		// validated
+		if min, max, err := v1alpha1.ParsePortRange(r); err == nil {
+			policy.Allow = append(policy.Allow, peerport.Range{Min: min, Max: max})
+		}
+	}
+	for _, r := range req.Deny {
+		if min, max, err := v1alpha1.ParsePortRange(r); err == nil {
+			policy.Deny = append(policy.Deny, peerport.Range{Min: min, Max: max})
+		}
+	}
+	s.peerPorts.SetPolicy(policy)
+}
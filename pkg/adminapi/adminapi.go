@@ -0,0 +1,62 @@
+// Package adminapi implements the STUNner gRPC admin API: a unary gRPC service through which
+// automation can create, update and delete the listeners and clusters of a running daemon, set the
+// log level and drain listeners, without rewriting the whole config.
+//
+// Each call is applied to the running config through a regular reconciliation, so changes are
+// validated and rolled back on error just like a config file update. Note that changes made over
+// the admin API last only until the next config update from the config source (file, URL or CDS)
+// replaces the running config.
+//
+// The service is served on the admin REST API endpoint over HTTP/2 (cleartext HTTP/2 is
+// supported) and requires the bearer token set in the api_token admin setting, sent in the
+// "authorization" metadata (see TokenCredentials). Messages are encoded as
+// google.protobuf.Struct values, so the service needs no generated code.
+package adminapi
+
+import "github.com/l7mp/stunner/pkg/apis/v1alpha1"
+
+// ServiceName is the fully qualified name of the gRPC service
+const ServiceName = "stunner.admin.v1.Admin"
+
+// ListenerRequest creates or updates a listener
+type ListenerRequest struct {
+	// Listener is the listener config
+	Listener v1alpha1.ListenerConfig `json:"listener"`
+}
+
+// ClusterRequest creates or updates a cluster
+type ClusterRequest struct {
+	// Cluster is the cluster config
+	Cluster v1alpha1.ClusterConfig `json:"cluster"`
+}
+
+// DeleteRequest deletes a listener or a cluster
+type DeleteRequest struct {
+	// Name is the name of the object to delete
+	Name string `json:"name"`
+}
+
+// LogLevelRequest sets the log level
+type LogLevelRequest struct {
+	// LogLevel is the new log level, e.g., "all:INFO,turn:DEBUG"
+	LogLevel string `json:"loglevel"`
+}
+
+// DrainRequest drains a listener: the listener refuses new allocations and the call waits until
+// the active allocations of the listener are closed or the timeout expires. The listener keeps
+// refusing new allocations until it is updated or deleted.
+type DrainRequest struct {
+	// Name is the name of the listener
+	Name string `json:"name"`
+	// Timeout is the maximum time to wait for the active allocations to close, in seconds. Zero
+	// makes the call return right away
+	Timeout int `json:"timeout,omitempty"`
+}
+
+// Response is returned by all calls
+type Response struct {
+	// Restarted is set if the change required a restart of the TURN server
+	Restarted bool `json:"restarted,omitempty"`
+	// Allocations is the number of allocations still active on a drained listener
+	Allocations int `json:"allocations,omitempty"`
+}
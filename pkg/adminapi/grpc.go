@@ -0,0 +1,198 @@
+package adminapi
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/l7mp/stunner/internal/pbstruct"
+)
+
+// Server is implemented by daemons serving the admin API
+type Server interface {
+	// CreateListener adds a new listener, fails with codes.AlreadyExists if it exists
+	CreateListener(context.Context, *ListenerRequest) (*Response, error)
+	// UpdateListener changes an existing listener, fails with codes.NotFound if it does not exist
+	UpdateListener(context.Context, *ListenerRequest) (*Response, error)
+	// DeleteListener removes a listener, fails with codes.NotFound if it does not exist
+	DeleteListener(context.Context, *DeleteRequest) (*Response, error)
+	// CreateCluster adds a new cluster, fails with codes.AlreadyExists if it exists
+	CreateCluster(context.Context, *ClusterRequest) (*Response, error)
+	// UpdateCluster changes an existing cluster, fails with codes.NotFound if it does not exist
+	UpdateCluster(context.Context, *ClusterRequest) (*Response, error)
+	// DeleteCluster removes a cluster, fails with codes.NotFound if it does not exist
+	DeleteCluster(context.Context, *DeleteRequest) (*Response, error)
+	// SetLogLevel sets the log level
+	SetLogLevel(context.Context, *LogLevelRequest) (*Response, error)
+	// DrainListener drains a listener
+	DrainListener(context.Context, *DrainRequest) (*Response, error)
+}
+
+// method describes a unary method: how to create the request and how to dispatch it
+type method struct {
+	name   string
+	newReq func() interface{}
+	call   func(Server, context.Context, interface{}) (*Response, error)
+}
+
+var methods = []method{
+	{"CreateListener", func() interface{} { return &ListenerRequest{} },
+		func(s Server, ctx context.Context, r interface{}) (*Response, error) {
+			return s.CreateListener(ctx, r.(*ListenerRequest))
+		}},
+	{"UpdateListener", func() interface{} { return &ListenerRequest{} },
+		func(s Server, ctx context.Context, r interface{}) (*Response, error) {
+			return s.UpdateListener(ctx, r.(*ListenerRequest))
+		}},
+	{"DeleteListener", func() interface{} { return &DeleteRequest{} },
+		func(s Server, ctx context.Context, r interface{}) (*Response, error) {
+			return s.DeleteListener(ctx, r.(*DeleteRequest))
+		}},
+	{"CreateCluster", func() interface{} { return &ClusterRequest{} },
+		func(s Server, ctx context.Context, r interface{}) (*Response, error) {
+			return s.CreateCluster(ctx, r.(*ClusterRequest))
+		}},
+	{"UpdateCluster", func() interface{} { return &ClusterRequest{} },
+		func(s Server, ctx context.Context, r interface{}) (*Response, error) {
+			return s.UpdateCluster(ctx, r.(*ClusterRequest))
+		}},
+	{"DeleteCluster", func() interface{} { return &DeleteRequest{} },
+		func(s Server, ctx context.Context, r interface{}) (*Response, error) {
+			return s.DeleteCluster(ctx, r.(*DeleteRequest))
+		}},
+	{"SetLogLevel", func() interface{} { return &LogLevelRequest{} },
+		func(s Server, ctx context.Context, r interface{}) (*Response, error) {
+			return s.SetLogLevel(ctx, r.(*LogLevelRequest))
+		}},
+	{"DrainListener", func() interface{} { return &DrainRequest{} },
+		func(s Server, ctx context.Context, r interface{}) (*Response, error) {
+			return s.DrainListener(ctx, r.(*DrainRequest))
+		}},
+}
+
+// RegisterServer registers an admin API server with a gRPC server
+func RegisterServer(g *grpc.Server, srv Server) {
+	desc := grpc.ServiceDesc{
+		ServiceName: ServiceName,
+		HandlerType: (*Server)(nil),
+		Metadata:    "stunner/admin.proto",
+	}
+
+	for i := range methods {
+		m := methods[i]
+		desc.Methods = append(desc.Methods, grpc.MethodDesc{
+			MethodName: m.name,
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error,
+				interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+				in := &structpb.Struct{}
+				if err := dec(in); err != nil {
+					return nil, err
+				}
+				handler := func(ctx context.Context, in interface{}) (interface{}, error) {
+					req := m.newReq()
+					if err := pbstruct.Decode(in.(*structpb.Struct), req); err != nil {
+						return nil, err
+					}
+					resp, err := m.call(srv.(Server), ctx, req)
+					if err != nil {
+						return nil, err
+					}
+					return pbstruct.Encode(resp)
+				}
+				if interceptor == nil {
+					return handler(ctx, in)
+				}
+				info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + ServiceName + "/" + m.name}
+				return interceptor(ctx, in, info, handler)
+			},
+		})
+	}
+
+	g.RegisterService(&desc, srv)
+}
+
+// Client is the client side of the admin API
+type Client struct {
+	conn grpc.ClientConnInterface
+}
+
+// NewClient creates an admin API client on a client connection
+func NewClient(conn grpc.ClientConnInterface) *Client {
+	return &Client{conn: conn}
+}
+
+func (c *Client) invoke(ctx context.Context, name string, req interface{}, opts ...grpc.CallOption) (*Response, error) {
+	in, err := pbstruct.Encode(req)
+	if err != nil {
+		return nil, err
+	}
+	out := &structpb.Struct{}
+	if err := c.conn.Invoke(ctx, "/"+ServiceName+"/"+name, in, out, opts...); err != nil {
+		return nil, err
+	}
+	resp := &Response{}
+	if err := pbstruct.Decode(out, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// CreateListener adds a new listener
+func (c *Client) CreateListener(ctx context.Context, req *ListenerRequest, opts ...grpc.CallOption) (*Response, error) {
+	return c.invoke(ctx, "CreateListener", req, opts...)
+}
+
+// UpdateListener changes an existing listener
+func (c *Client) UpdateListener(ctx context.Context, req *ListenerRequest, opts ...grpc.CallOption) (*Response, error) {
+	return c.invoke(ctx, "UpdateListener", req, opts...)
+}
+
+// DeleteListener removes a listener
+func (c *Client) DeleteListener(ctx context.Context, req *DeleteRequest, opts ...grpc.CallOption) (*Response, error) {
+	return c.invoke(ctx, "DeleteListener", req, opts...)
+}
+
+// CreateCluster adds a new cluster
+func (c *Client) CreateCluster(ctx context.Context, req *ClusterRequest, opts ...grpc.CallOption) (*Response, error) {
+	return c.invoke(ctx, "CreateCluster", req, opts...)
+}
+
+// UpdateCluster changes an existing cluster
+func (c *Client) UpdateCluster(ctx context.Context, req *ClusterRequest, opts ...grpc.CallOption) (*Response, error) {
+	return c.invoke(ctx, "UpdateCluster", req, opts...)
+}
+
+// DeleteCluster removes a cluster
+func (c *Client) DeleteCluster(ctx context.Context, req *DeleteRequest, opts ...grpc.CallOption) (*Response, error) {
+	return c.invoke(ctx, "DeleteCluster", req, opts...)
+}
+
+// SetLogLevel sets the log level
+func (c *Client) SetLogLevel(ctx context.Context, req *LogLevelRequest, opts ...grpc.CallOption) (*Response, error) {
+	return c.invoke(ctx, "SetLogLevel", req, opts...)
+}
+
+// DrainListener drains a listener
+func (c *Client) DrainListener(ctx context.Context, req *DrainRequest, opts ...grpc.CallOption) (*Response, error) {
+	return c.invoke(ctx, "DrainListener", req, opts...)
+}
+
+// tokenCredentials sends a bearer token with each call
+type tokenCredentials string
+
+// TokenCredentials returns the per-call credentials for authenticating to the admin API with a
+// bearer token, use with grpc.WithPerRPCCredentials. The token is sent on insecure connections
+// too, as the admin API is usually reached over the loopback or the pod network
+func TokenCredentials(token string) credentials.PerRPCCredentials {
+	return tokenCredentials(token)
+}
+
+func (t tokenCredentials) GetRequestMetadata(context.Context, ...string) (map[string]string, error) {
+	return map[string]string{"authorization": "Bearer " + string(t)}, nil
+}
+
+func (t tokenCredentials) RequireTransportSecurity() bool {
+	return false
+}
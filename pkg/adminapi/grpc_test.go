@@ -0,0 +1,95 @@
+package adminapi
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/l7mp/stunner/pkg/apis/v1alpha1"
+)
+
+// testServer records the last request and answers with a fixed response
+type testServer struct {
+	req interface{}
+}
+
+func (s *testServer) handle(req interface{}) (*Response, error) {
+	s.req = req
+	return &Response{Restarted: true, Allocations: 3}, nil
+}
+
+func (s *testServer) CreateListener(_ context.Context, r *ListenerRequest) (*Response, error) {
+	return s.handle(r)
+}
+func (s *testServer) UpdateListener(_ context.Context, r *ListenerRequest) (*Response, error) {
+	return s.handle(r)
+}
+func (s *testServer) DeleteListener(_ context.Context, r *DeleteRequest) (*Response, error) {
+	return nil, status.Errorf(codes.NotFound, "listener %q not found", r.Name)
+}
+func (s *testServer) CreateCluster(_ context.Context, r *ClusterRequest) (*Response, error) {
+	return s.handle(r)
+}
+func (s *testServer) UpdateCluster(_ context.Context, r *ClusterRequest) (*Response, error) {
+	return s.handle(r)
+}
+func (s *testServer) DeleteCluster(_ context.Context, r *DeleteRequest) (*Response, error) {
+	return s.handle(r)
+}
+func (s *testServer) SetLogLevel(_ context.Context, r *LogLevelRequest) (*Response, error) {
+	return s.handle(r)
+}
+func (s *testServer) DrainListener(_ context.Context, r *DrainRequest) (*Response, error) {
+	return s.handle(r)
+}
+
+func TestGRPC(t *testing.T) {
+	l := bufconn.Listen(1 << 16)
+	g := grpc.NewServer()
+	srv := &testServer{}
+	RegisterServer(g, srv)
+	go g.Serve(l) //nolint:errcheck
+	defer g.Stop()
+
+	conn, err := grpc.Dial("bufnet", grpc.WithInsecure(),
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return l.Dial() }))
+	assert.NoError(t, err, "dial")
+	defer conn.Close()
+	c := NewClient(conn)
+	ctx := context.Background()
+
+	lreq := &ListenerRequest{Listener: v1alpha1.ListenerConfig{Name: "udp", Protocol: "udp",
+		Addr: "1.2.3.4", Port: 3478, Routes: []string{"media"}}}
+	resp, err := c.CreateListener(ctx, lreq)
+	assert.NoError(t, err, "create listener")
+	assert.Equal(t, lreq, srv.req, "listener request round trip")
+	assert.Equal(t, &Response{Restarted: true, Allocations: 3}, resp, "response round trip")
+
+	creq := &ClusterRequest{Cluster: v1alpha1.ClusterConfig{Name: "media", Type: "STATIC",
+		Endpoints: []string{"10.0.0.0/8"}}}
+	_, err = c.UpdateCluster(ctx, creq)
+	assert.NoError(t, err, "update cluster")
+	assert.Equal(t, creq, srv.req, "cluster request round trip")
+
+	dreq := &DrainRequest{Name: "udp", Timeout: 10}
+	_, err = c.DrainListener(ctx, dreq)
+	assert.NoError(t, err, "drain listener")
+	assert.Equal(t, dreq, srv.req, "drain request round trip")
+
+	// the errors of the server are passed through
+	_, err = c.DeleteListener(ctx, &DeleteRequest{Name: "tcp"})
+	assert.Equal(t, codes.NotFound, status.Code(err), "not found")
+
+	// requests that do not decode are refused
+	in, err := structpb.NewStruct(map[string]interface{}{"listener": "udp"})
+	assert.NoError(t, err, "struct")
+	err = conn.Invoke(ctx, "/"+ServiceName+"/CreateListener", in, &structpb.Struct{})
+	assert.Error(t, err, "invalid request")
+}
@@ -0,0 +1,705 @@
+package v1
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"reflect"
+	"sort"
+
+	"github.com/l7mp/stunner/pkg/apis/v1alpha1"
+)
+
+// AdminConfig holds the administrative configuration
+type AdminConfig struct {
+	// Name is the name of the server, optional
+	Name string `json:"name,omitempty"`
+	// LogLevel is the desired log verbosity, e.g.: "stunner:TRACE,all:INFO", scopes may be glob
+	// patterns
+	LogLevel string `json:"loglevel,omitempty"`
+	// LogFormat is "console" or "json" (default: console)
+	LogFormat string `json:"log_format,omitempty"`
+	// LogFile is the path of the log file (default: disabled)
+	LogFile string `json:"log_file,omitempty"`
+	// LogMaxSize is the size in megabytes the log file is rotated at (default: 100)
+	LogMaxSize int `json:"log_max_size,omitempty"`
+	// LogMaxAge is the time in hours the log file is rotated after (default: disabled)
+	LogMaxAge int `json:"log_max_age,omitempty"`
+	// LogMaxBackups is the number of rotated log files to keep (default: 5)
+	LogMaxBackups int `json:"log_max_backups,omitempty"`
+	// LogThrottleInterval is the log throttle interval in seconds, negative disables throttling
+	// (default: 10)
+	LogThrottleInterval int `json:"log_throttle_interval,omitempty"`
+	// LogThrottleBurst is the number of distinct messages logged per throttle interval (default:
+	// 100)
+	LogThrottleBurst int `json:"log_throttle_burst,omitempty"`
+	// SyslogEndpoint is the "udp://", "tcp://" or "unix://" syslog endpoint to send a copy of the
+	// logs to (default: disabled)
+	SyslogEndpoint string `json:"syslog_endpoint,omitempty"`
+	// SyslogFacility is the syslog facility of the logs (default: daemon)
+	SyslogFacility string `json:"syslog_facility,omitempty"`
+	// AccessLog is "stdout", "stderr" or the path of the access log file (default: disabled)
+	AccessLog string `json:"access_log,omitempty"`
+	// AccessLogFormat is "json" or "clf" (default: json)
+	AccessLogFormat string `json:"access_log_format,omitempty"`
+	// AuditLog is "stdout", "stderr" or the path of the config audit log file (default: disabled)
+	AuditLog string `json:"audit_log,omitempty"`
+	// TracingEndpoint is the OTLP/HTTP traces endpoint to export spans to (default: disabled)
+	TracingEndpoint string `json:"tracing_endpoint,omitempty"`
+	// TracingSampleRatio is the ratio of the client sessions traced (default: 0.1)
+	TracingSampleRatio float64 `json:"tracing_sample_ratio,omitempty"`
+	// MessageTrace logs the STUN/TURN messages of sampled transactions and of selected users and
+	// clients (default: disabled)
+	MessageTrace *MessageTraceConfig `json:"message_trace,omitempty"`
+	// CaptureDir is the directory of the packet captures (default: the temporary directory)
+	CaptureDir string `json:"capture_dir,omitempty"`
+	// MetricsEndpoint is the url to the metric server (Prometheus)
+	MetricsEndpoint string `json:"metrics_endpoint,omitempty"`
+	// APIEndpoint is the url of the admin REST API server (default: disabled)
+	APIEndpoint string `json:"api_endpoint,omitempty"`
+	// APIToken is the bearer token required by the admin REST API, without a token only the
+	// status is served (default: no token)
+	APIToken string `json:"api_token,omitempty"`
+	// NAT64Prefix is the IPv6 prefix of IPv4-embedded peer addresses (default: disabled)
+	NAT64Prefix string `json:"nat64_prefix,omitempty"`
+	// RestartPolicy is "never", "graceful" or "immediate" (default: immediate)
+	RestartPolicy string `json:"restart_policy,omitempty"`
+	// DrainTimeout is the drain timeout in seconds for the "graceful" restart policy (default: 60)
+	DrainTimeout int `json:"drain_timeout,omitempty"`
+	// EventWebhook is the URL to post the config change events to (default: disabled)
+	EventWebhook string `json:"event_webhook,omitempty"`
+	// Notifier posts operational events to a webhook (default: disabled)
+	Notifier *NotifierConfig `json:"notifier,omitempty"`
+	// Watermarks sets the relay port, file descriptor and allocation usage thresholds (default:
+	// disabled)
+	Watermarks *WatermarkConfig `json:"watermarks,omitempty"`
+	// DNSHealth degrades the gateway when the DNS resolution of a STRICT_DNS cluster is failing
+	// (default: disabled)
+	DNSHealth *DNSHealthConfig `json:"dns_health,omitempty"`
+	// LatencyProbe probes a sample of the endpoints of each cluster and exports the round-trip
+	// time and the loss as metrics (default: disabled)
+	LatencyProbe *LatencyProbeConfig `json:"latency_probe,omitempty"`
+	// Ban temporarily bans the client sources behaving abusively (default: disabled)
+	Ban *BanConfig `json:"ban,omitempty"`
+	// Quota caps the allocations gateway-wide and per client source (default: unlimited)
+	Quota *QuotaConfig `json:"quota,omitempty"`
+	// Amplification limits the responses to unauthenticated requests on UDP listeners
+	// (default: disabled)
+	Amplification *AmplificationConfig `json:"amplification,omitempty"`
+	// Malformed counts and discards the malformed packets received on the listeners (default:
+	// disabled)
+	Malformed *MalformedConfig `json:"malformed,omitempty"`
+	// RequestRate limits the Refresh, CreatePermission and ChannelBind requests per username
+	// (default: unlimited)
+	RequestRate *RequestRateConfig `json:"request_rate,omitempty"`
+	// PeerPorts restricts the peer ports reachable via the relay transports (default: all ports)
+	PeerPorts *PeerPortConfig `json:"peer_ports,omitempty"`
+	// Replication shares the state of the UDP allocations with the other replicas of the
+	// gateway for failover (default: disabled)
+	Replication *ReplicationConfig `json:"replication,omitempty"`
+	// ConsistentHashing counts or redirects the allocations arriving at the wrong replica of the
+	// hash ring (default: disabled)
+	ConsistentHashing *ConsistentHashingConfig `json:"consistent_hashing,omitempty"`
+	// Standby pairs the gateway with a hot-standby gateway taking over the virtual IP of the
+	// pair on failure (default: disabled)
+	Standby *StandbyConfig `json:"standby,omitempty"`
+	// AddressMapping translates the peer addresses of the relay transports (default: disabled)
+	AddressMapping *AddressMappingConfig `json:"address_mapping,omitempty"`
+	// PolicyPlugin consults a policy plugin on the allocation and permission decisions
+	// (default: disabled)
+	PolicyPlugin *PolicyPluginConfig `json:"policy_plugin,omitempty"`
+	// SDSEndpoint is the gRPC address of the secret discovery service serving the certificates
+	// of the listeners with an SDS secret, reached unencrypted (default: disabled)
+	SDSEndpoint string `json:"sds_endpoint,omitempty"`
+	// FIPSMode restricts the crypto to FIPS-approved algorithms (default: false)
+	FIPSMode bool `json:"fips_mode,omitempty"`
+	// AllowInsecureProtocols permits UDP, TCP and WS listeners (default: true)
+	AllowInsecureProtocols *bool `json:"allow_insecure_protocols,omitempty"`
+	// PermissionLifetime is the lifetime in seconds of the permissions, at most 300 (default: 300)
+	PermissionLifetime int `json:"permission_lifetime,omitempty"`
+	// ChannelLifetime is the lifetime in seconds of the channel bindings, at most 600 (default:
+	// 600)
+	ChannelLifetime int `json:"channel_lifetime,omitempty"`
+	// MaxAllocationLifetime is the longest allocation lifetime in seconds, at most 3600
+	// (default: 3600)
+	MaxAllocationLifetime int `json:"max_allocation_lifetime,omitempty"`
+	// DefaultRoute is "deny" or "allow", the policy for listeners with no routes (default: deny)
+	DefaultRoute string `json:"default_route,omitempty"`
+	// ResourceProfile is "default" or "low-memory", the latter caps the resource use for
+	// devices with 512 MB RAM or less (default: default)
+	ResourceProfile string `json:"resource_profile,omitempty"`
+}
+
+// Validate checks a configuration and injects defaults
+func (req *AdminConfig) Validate() error {
+	if req.LogLevel == "" {
+		req.LogLevel = DefaultLogLevel
+	}
+	if req.LogFormat == "" {
+		req.LogFormat = DefaultLogFormat
+	}
+	if req.LogFile != "" && req.LogMaxSize == 0 {
+		req.LogMaxSize = DefaultLogMaxSize
+	}
+	if req.LogFile != "" && req.LogMaxBackups == 0 {
+		req.LogMaxBackups = DefaultLogMaxBackups
+	}
+	if req.LogThrottleInterval == 0 {
+		req.LogThrottleInterval = DefaultLogThrottleInterval
+	}
+	if req.LogThrottleBurst == 0 {
+		req.LogThrottleBurst = DefaultLogThrottleBurst
+	}
+	if req.SyslogEndpoint != "" && req.SyslogFacility == "" {
+		req.SyslogFacility = DefaultSyslogFacility
+	}
+	if req.AccessLog != "" && req.AccessLogFormat == "" {
+		req.AccessLogFormat = DefaultAccessLogFormat
+	}
+	if req.TracingEndpoint != "" && req.TracingSampleRatio == 0 {
+		req.TracingSampleRatio = DefaultTracingSampleRatio
+	}
+	if req.Name == "" {
+		req.Name = DefaultStunnerName
+	}
+	if req.RestartPolicy == "" {
+		req.RestartPolicy = DefaultRestartPolicy
+	}
+	if req.DrainTimeout == 0 {
+		req.DrainTimeout = DefaultDrainTimeout
+	}
+	if req.DefaultRoute == "" {
+		req.DefaultRoute = DefaultDefaultRoute
+	}
+	if req.ResourceProfile == "" {
+		req.ResourceProfile = DefaultResourceProfile
+	}
+
+	if err := v1alpha1.ValidateName(req.Name); err != nil {
+		return fmt.Errorf("invalid name %q: %s", req.Name, err.Error())
+	}
+
+	switch req.LogFormat {
+	case "console", "json":
+	default:
+		return fmt.Errorf("unknown log format: %q", req.LogFormat)
+	}
+	if req.LogMaxSize < 0 || req.LogMaxAge < 0 || req.LogMaxBackups < 0 {
+		return fmt.Errorf("invalid log rotation settings: max size: %d, max age: %d, "+
+			"max backups: %d", req.LogMaxSize, req.LogMaxAge, req.LogMaxBackups)
+	}
+	if req.LogThrottleBurst < 0 {
+		return fmt.Errorf("invalid log throttle burst: %d", req.LogThrottleBurst)
+	}
+	switch req.AccessLogFormat {
+	case "", "json", "clf":
+	default:
+		return fmt.Errorf("unknown access log format: %q", req.AccessLogFormat)
+	}
+	switch req.RestartPolicy {
+	case "never", "graceful", "immediate":
+	default:
+		return fmt.Errorf("unknown restart policy: %q", req.RestartPolicy)
+	}
+	if req.TracingSampleRatio < 0 || req.TracingSampleRatio > 1 {
+		return fmt.Errorf("invalid tracing sample ratio: %g", req.TracingSampleRatio)
+	}
+	if req.DrainTimeout < 0 {
+		return fmt.Errorf("invalid drain timeout: %d", req.DrainTimeout)
+	}
+	switch req.DefaultRoute {
+	case "deny", "allow":
+	default:
+		return fmt.Errorf("unknown default route policy: %q", req.DefaultRoute)
+	}
+	switch req.ResourceProfile {
+	case "default", "low-memory":
+	default:
+		return fmt.Errorf("unknown resource profile: %q", req.ResourceProfile)
+	}
+
+	for _, ep := range []string{req.MetricsEndpoint, req.APIEndpoint, req.EventWebhook,
+		req.TracingEndpoint} {
+		if ep == "" {
+			continue
+		}
+		u, err := url.Parse(ep)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("%s: not a valid endpoint URL", ep)
+		}
+	}
+
+	if req.SyslogEndpoint != "" {
+		u, err := url.Parse(req.SyslogEndpoint)
+		if err != nil || ((u.Scheme != "udp" && u.Scheme != "tcp" || u.Host == "") &&
+			(u.Scheme != "unix" || u.Path == "")) {
+			return fmt.Errorf("%s: not a valid syslog endpoint URL", req.SyslogEndpoint)
+		}
+		found := false
+		for _, f := range v1alpha1.SyslogFacilities {
+			found = found || f == req.SyslogFacility
+		}
+		if !found {
+			return fmt.Errorf("unknown syslog facility: %q", req.SyslogFacility)
+		}
+	}
+
+	if n := req.Notifier; n != nil {
+		if n.AuthFailureThreshold == 0 {
+			n.AuthFailureThreshold = DefaultAuthFailureThreshold
+		}
+		if n.AuthFailureThreshold < 0 {
+			return fmt.Errorf("invalid auth failure threshold: %d", n.AuthFailureThreshold)
+		}
+		u, err := url.Parse(n.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%s: not a valid notifier URL", n.URL)
+		}
+		for _, e := range n.Events {
+			switch e {
+			case "allocation-created", "allocation-deleted", "auth-failures",
+				"listener-failed", "reconcile-failed":
+			default:
+				return fmt.Errorf("unknown notifier event: %q", e)
+			}
+		}
+	}
+
+	if mt := req.MessageTrace; mt != nil {
+		if mt.SampleRatio < 0 || mt.SampleRatio > 1 {
+			return fmt.Errorf("invalid message trace sample ratio: %g", mt.SampleRatio)
+		}
+		for _, c := range mt.Clients {
+			if _, _, err := net.ParseCIDR(c); err != nil && net.ParseIP(c) == nil {
+				return fmt.Errorf("invalid message trace client: %q", c)
+			}
+		}
+	}
+
+	if w := req.Watermarks; w != nil {
+		if w.RelayPorts == 0 {
+			w.RelayPorts = DefaultRelayPortWatermark
+		}
+		if w.FileDescriptors == 0 {
+			w.FileDescriptors = DefaultFileDescriptorWatermark
+		}
+		if w.RelayPorts < 0 || w.RelayPorts > 100 || w.FileDescriptors < 0 ||
+			w.FileDescriptors > 100 || w.Allocations < 0 {
+			return fmt.Errorf("invalid watermarks: relay ports: %d%%, file descriptors: %d%%, "+
+				"allocations: %d", w.RelayPorts, w.FileDescriptors, w.Allocations)
+		}
+	}
+
+	if d := req.DNSHealth; d != nil {
+		if d.FailureThreshold == 0 {
+			d.FailureThreshold = DefaultDNSFailureThreshold
+		}
+		if d.FailureThreshold < 0 {
+			return fmt.Errorf("invalid DNS failure threshold: %d", d.FailureThreshold)
+		}
+	}
+
+	if p := req.LatencyProbe; p != nil {
+		if p.Protocol == "" {
+			p.Protocol = DefaultLatencyProbeProtocol
+		}
+		if p.Port == 0 {
+			p.Port = DefaultLatencyProbePort
+		}
+		if p.Interval == 0 {
+			p.Interval = DefaultLatencyProbeInterval
+		}
+		if p.Timeout == 0 {
+			p.Timeout = DefaultLatencyProbeTimeout
+		}
+		if p.SampleSize == 0 {
+			p.SampleSize = DefaultLatencyProbeSampleSize
+		}
+		if p.Protocol != "icmp" && p.Protocol != "udp" && p.Protocol != "tcp" {
+			return fmt.Errorf("invalid latency probe protocol: %q", p.Protocol)
+		}
+		if p.Port < 0 || p.Port > 65535 {
+			return fmt.Errorf("invalid latency probe port: %d", p.Port)
+		}
+		if p.Interval < 0 {
+			return fmt.Errorf("invalid latency probe interval: %d", p.Interval)
+		}
+		if p.Timeout < 0 || p.Timeout > p.Interval*1000 {
+			return fmt.Errorf("invalid latency probe timeout: %d", p.Timeout)
+		}
+		if p.SampleSize < 0 {
+			return fmt.Errorf("invalid latency probe sample size: %d", p.SampleSize)
+		}
+	}
+
+	if b := req.Ban; b != nil {
+		if b.Window == 0 {
+			b.Window = DefaultBanWindow
+		}
+		if b.Duration == 0 {
+			b.Duration = DefaultBanDuration
+		}
+		if b.AuthFailures == 0 {
+			b.AuthFailures = DefaultAuthFailureThreshold
+		}
+		if b.MalformedPackets == 0 {
+			b.MalformedPackets = DefaultBanMalformedPackets
+		}
+		if b.PermissionDenials == 0 {
+			b.PermissionDenials = DefaultBanPermissionDenials
+		}
+		if b.Window < 0 {
+			return fmt.Errorf("invalid ban window: %d", b.Window)
+		}
+		if b.Duration < 0 {
+			return fmt.Errorf("invalid ban duration: %d", b.Duration)
+		}
+		for _, c := range b.ExemptCIDRs {
+			if _, err := v1alpha1.ParseSourceCIDR(c); err != nil {
+				return fmt.Errorf("invalid ban exemption: %s", err.Error())
+			}
+		}
+		sort.Strings(b.ExemptCIDRs)
+	}
+
+	if q := req.Quota; q != nil {
+		if q.MaxAllocations < 0 {
+			return fmt.Errorf("invalid allocation quota: %d", q.MaxAllocations)
+		}
+		if q.MaxAllocationsPerSource < 0 {
+			return fmt.Errorf("invalid per-source allocation quota: %d",
+				q.MaxAllocationsPerSource)
+		}
+		if q.AllocationRate < 0 {
+			return fmt.Errorf("invalid allocation rate: %d", q.AllocationRate)
+		}
+		if q.AllocationRatePerSource < 0 {
+			return fmt.Errorf("invalid per-source allocation rate: %d",
+				q.AllocationRatePerSource)
+		}
+	}
+
+	if a := req.Amplification; a != nil {
+		if a.ResponseRate < 0 {
+			return fmt.Errorf("invalid response rate: %d", a.ResponseRate)
+		}
+		if a.ResponseRatePerSource < 0 {
+			return fmt.Errorf("invalid per-source response rate: %d", a.ResponseRatePerSource)
+		}
+	}
+
+	if m := req.Malformed; m != nil {
+		if m.MaxMessageSize < 0 || (m.MaxMessageSize > 0 && m.MaxMessageSize < 20) {
+			return fmt.Errorf("invalid max message size: %d", m.MaxMessageSize)
+		}
+	}
+
+	if r := req.RequestRate; r != nil {
+		if r.RatePerUser == 0 {
+			r.RatePerUser = DefaultRequestRatePerUser
+		}
+		if r.RatePerUser < 0 {
+			return fmt.Errorf("invalid request rate per user: %d", r.RatePerUser)
+		}
+	}
+
+	if p := req.PeerPorts; p != nil {
+		for _, r := range p.Allow {
+			if _, _, err := v1alpha1.ParsePortRange(r); err != nil {
+				return fmt.Errorf("invalid allowed peer ports: %s", err.Error())
+			}
+		}
+		for _, r := range p.Deny {
+			if _, _, err := v1alpha1.ParsePortRange(r); err != nil {
+				return fmt.Errorf("invalid denied peer ports: %s", err.Error())
+			}
+		}
+		sort.Strings(p.Allow)
+		sort.Strings(p.Deny)
+	}
+
+	if r := req.Replication; r != nil {
+		if req.APIToken == "" {
+			return fmt.Errorf("allocation state replication requires an api_token")
+		}
+		c := v1alpha1.ReplicationConfig(*r)
+		if err := c.Validate(); err != nil {
+			return err
+		}
+		*r = ReplicationConfig(c)
+	}
+
+	if h := req.ConsistentHashing; h != nil {
+		c := v1alpha1.ConsistentHashingConfig(*h)
+		if err := c.Validate(); err != nil {
+			return err
+		}
+		*h = ConsistentHashingConfig(c)
+	}
+
+	if sb := req.Standby; sb != nil {
+		if req.APIToken == "" {
+			return fmt.Errorf("hot standby requires an api_token")
+		}
+		c := v1alpha1.StandbyConfig(*sb)
+		if err := c.Validate(); err != nil {
+			return err
+		}
+		*sb = StandbyConfig(c)
+	}
+
+	if am := req.AddressMapping; am != nil {
+		c := v1alpha1.AddressMappingConfig{}
+		for _, m := range am.Mappings {
+			c.Mappings = append(c.Mappings, v1alpha1.AddressMapping(m))
+		}
+		if err := c.Validate(); err != nil {
+			return err
+		}
+		for i, m := range c.Mappings {
+			am.Mappings[i] = AddressMapping(m)
+		}
+	}
+
+	if pp := req.PolicyPlugin; pp != nil {
+		c := v1alpha1.PolicyPluginConfig(*pp)
+		if err := c.Validate(); err != nil {
+			return err
+		}
+		*pp = PolicyPluginConfig(c)
+	}
+
+	if err := v1alpha1.ValidateLifetimes(req.PermissionLifetime, req.ChannelLifetime,
+		req.MaxAllocationLifetime); err != nil {
+		return err
+	}
+
+	if req.NAT64Prefix != "" {
+		ip, n, err := net.ParseCIDR(req.NAT64Prefix)
+		if err != nil || ip.To4() != nil {
+			return fmt.Errorf("%s: not a valid IPv6 NAT64 prefix", req.NAT64Prefix)
+		}
+		switch ones, _ := n.Mask.Size(); ones {
+		case 32, 40, 48, 56, 64, 96:
+		default:
+			return fmt.Errorf("%s: invalid NAT64 prefix length, must be one of 32, 40, 48, "+
+				"56, 64 or 96", req.NAT64Prefix)
+		}
+	}
+
+	return nil
+}
+
+// NotifierConfig configures the webhook operational events are posted to
+type NotifierConfig struct {
+	// URL is the webhook to post the events to
+	URL string `json:"url"`
+	// Headers are added to each request
+	Headers map[string]string `json:"headers,omitempty"`
+	// Events is the list of events to post, empty means all
+	Events []string `json:"events,omitempty"`
+	// AuthFailureThreshold is the number of failed authentications in a minute that fires an
+	// "auth-failures" event (default: 10)
+	AuthFailureThreshold int `json:"auth_failure_threshold,omitempty"`
+}
+
+// MessageTraceConfig selects the STUN/TURN messages logged with their decoded attributes
+type MessageTraceConfig struct {
+	// SampleRatio is the ratio of the transactions logged (default: 0)
+	SampleRatio float64 `json:"sample_ratio,omitempty"`
+	// Usernames lists the users whose messages are logged
+	Usernames []string `json:"usernames,omitempty"`
+	// Clients lists the IP addresses or prefixes of the clients whose messages are logged
+	Clients []string `json:"clients,omitempty"`
+}
+
+// WatermarkConfig sets the resource usage thresholds
+type WatermarkConfig struct {
+	// RelayPorts is the percentage of the relay port range of a listener in use (default: 80)
+	RelayPorts int `json:"relay_ports,omitempty"`
+	// FileDescriptors is the percentage of the open file limit in use (default: 80)
+	FileDescriptors int `json:"file_descriptors,omitempty"`
+	// Allocations is the number of active allocations (default: disabled)
+	Allocations int `json:"allocations,omitempty"`
+	// DegradeReadiness marks the gateway not ready while a watermark is exceeded (default: false)
+	DegradeReadiness bool `json:"degrade_readiness,omitempty"`
+}
+
+// DNSHealthConfig sets when the failing DNS resolution of a STRICT_DNS cluster degrades the
+// gateway
+type DNSHealthConfig struct {
+	// FailureThreshold is the time in seconds the resolution of all the domains of a cluster
+	// must be failing for (default: 30)
+	FailureThreshold int `json:"failure_threshold,omitempty"`
+	// DegradeReadiness marks the gateway not ready while the resolution of a cluster is failing
+	// (default: false)
+	DegradeReadiness bool `json:"degrade_readiness,omitempty"`
+}
+
+// LatencyProbeConfig sets the probes sent to a sample of the endpoints of each cluster
+type LatencyProbeConfig struct {
+	// Protocol is "icmp", "udp" or "tcp" (default: icmp)
+	Protocol string `json:"protocol,omitempty"`
+	// Port is the destination port of the UDP and TCP probes (default: 7)
+	Port int `json:"port,omitempty"`
+	// Interval is the time in seconds between two rounds of probes (default: 10)
+	Interval int `json:"interval,omitempty"`
+	// Timeout is the time in milliseconds after a probe is counted as lost (default: 1000)
+	Timeout int `json:"timeout,omitempty"`
+	// SampleSize is the number of endpoints probed per cluster (default: 3)
+	SampleSize int `json:"sample_size,omitempty"`
+}
+
+// BanConfig sets the thresholds of the offenses a client source is temporarily banned for
+type BanConfig struct {
+	// Window is the time in seconds the offenses are counted in (default: 60)
+	Window int `json:"window,omitempty"`
+	// Duration is the ban period in seconds (default: 600)
+	Duration int `json:"duration,omitempty"`
+	// AuthFailures is the number of failed authentications, negative disables (default: 10)
+	AuthFailures int `json:"auth_failures,omitempty"`
+	// MalformedPackets is the number of malformed packets, negative disables (default: 100)
+	MalformedPackets int `json:"malformed_packets,omitempty"`
+	// PermissionDenials is the number of permission denials, negative disables (default: 50)
+	PermissionDenials int `json:"permission_denials,omitempty"`
+	// ExemptCIDRs lists the IP prefixes of the sources never banned
+	ExemptCIDRs []string `json:"exempt_cidrs,omitempty"`
+}
+
+// QuotaConfig caps the concurrent allocations and the rate of the Allocate requests, zero means no
+// limit
+type QuotaConfig struct {
+	// MaxAllocations is the number of concurrent allocations on the gateway
+	MaxAllocations int `json:"max_allocations,omitempty"`
+	// MaxAllocationsPerSource is the number of concurrent allocations of a client source
+	MaxAllocationsPerSource int `json:"max_allocations_per_source,omitempty"`
+	// AllocationRate is the number of Allocate requests per second on the gateway
+	AllocationRate int `json:"allocation_rate,omitempty"`
+	// AllocationRatePerSource is the number of Allocate requests per second of a client source
+	AllocationRatePerSource int `json:"allocation_rate_per_source,omitempty"`
+}
+
+// AmplificationConfig limits the responses to the unauthenticated requests of the client sources
+// holding no allocation, zero means no limit
+type AmplificationConfig struct {
+	// ResponseRate is the number of responses per second on the gateway
+	ResponseRate int `json:"response_rate,omitempty"`
+	// ResponseRatePerSource is the number of responses per second to a client source
+	ResponseRatePerSource int `json:"response_rate_per_source,omitempty"`
+	// RequireCookie answers Binding requests only to the client sources holding an allocation
+	RequireCookie bool `json:"require_cookie,omitempty"`
+}
+
+// MalformedConfig sets the defenses against the malformed packets received on the listeners
+type MalformedConfig struct {
+	// MaxMessageSize is the size in bytes of the largest message accepted (default: no limit)
+	MaxMessageSize int `json:"max_message_size,omitempty"`
+	// DropGarbage drops the packets that are neither STUN nor ChannelData (default: false)
+	DropGarbage bool `json:"drop_garbage,omitempty"`
+}
+
+// RequestRateConfig limits the rate of the Refresh, CreatePermission and ChannelBind requests per
+// authenticated username
+type RequestRateConfig struct {
+	// RatePerUser is the number of requests per second of a username (default: 20)
+	RatePerUser int `json:"rate_per_user,omitempty"`
+}
+
+// PeerPortConfig restricts the peer ports reachable via the relay transports: a port must fall
+// into an allowed range, if any, and into no denied range
+type PeerPortConfig struct {
+	// Allow lists the peer port ranges allowed, e.g., "10000-60000" (default: all ports)
+	Allow []string `json:"allow,omitempty"`
+	// Deny lists the peer port ranges denied, e.g., "1-1023"
+	Deny []string `json:"deny,omitempty"`
+}
+
+// ReplicationConfig configures the replication of the allocation state between the replicas of the
+// gateway, authenticated with the admin API token
+type ReplicationConfig struct {
+	// Peers lists the admin API URLs of the other replicas over HTTPS, e.g.,
+	// "https://10.0.0.2:8443"
+	Peers []string `json:"peers"`
+	// Interval is the period in seconds the allocation state is pushed at (default: 5)
+	Interval int `json:"interval,omitempty"`
+}
+
+// ConsistentHashingConfig sets the hash ring of the replicas of the gateway
+type ConsistentHashingConfig struct {
+	// Replicas lists the IP addresses of the replicas in the hash ring
+	Replicas []string `json:"replicas"`
+	// Self is the IP address of this replica, must be listed in the replicas
+	Self string `json:"self"`
+	// Mode is "hint" or "redirect" (default: hint)
+	Mode string `json:"mode,omitempty"`
+}
+
+// StandbyConfig pairs the gateway with a hot-standby gateway, authenticated with the admin API
+// token
+type StandbyConfig struct {
+	// Role is the role of the gateway in the pair: "active" or "standby"
+	Role string `json:"role"`
+	// Peer is the admin API URL of the other gateway of the pair
+	Peer string `json:"peer"`
+	// VirtualIP is the virtual IP address of the pair with the prefix length, e.g.,
+	// "192.0.2.10/24" (default: none)
+	VirtualIP string `json:"virtual_ip,omitempty"`
+	// Interface is the network interface the virtual IP is added to
+	Interface string `json:"interface,omitempty"`
+	// MirrorConfig makes the standby apply the config of the active gateway (default: true)
+	MirrorConfig *bool `json:"mirror_config,omitempty"`
+	// ReplicateAllocations replicates the UDP allocations to the other gateway (default: false)
+	ReplicateAllocations bool `json:"replicate_allocations,omitempty"`
+	// HeartbeatInterval is the period in seconds of the heartbeats (default: 1)
+	HeartbeatInterval int `json:"heartbeat_interval,omitempty"`
+	// FailoverTimeout is the time in seconds without heartbeats after which the standby takes
+	// over (default: 3)
+	FailoverTimeout int `json:"failover_timeout,omitempty"`
+}
+
+// AddressMappingConfig sets the address-mapping table of the relay transports
+type AddressMappingConfig struct {
+	// Hairpin maps the public address of each listener to the address of the listener
+	// (default: false)
+	Hairpin bool `json:"hairpin,omitempty"`
+	// Mappings lists the static address mappings
+	Mappings []AddressMapping `json:"mappings,omitempty"`
+}
+
+// AddressMapping maps an external peer address to an internal address, each an IP address with
+// an optional port
+type AddressMapping struct {
+	// External is the peer address the clients use
+	External string `json:"external"`
+	// Internal is the address the peer is reached at
+	Internal string `json:"internal"`
+}
+
+// PolicyPluginConfig sets the policy plugin consulted on the allocation and permission decisions
+type PolicyPluginConfig struct {
+	// Address is the gRPC address of the plugin (default: the in-process plugin)
+	Address string `json:"address,omitempty"`
+	// Hooks lists the decisions the plugin is consulted on: "allocation" and "permission"
+	// (default: both)
+	Hooks []string `json:"hooks,omitempty"`
+	// Timeout is the time in milliseconds a decision of the plugin is waited for (default: 100)
+	Timeout int `json:"timeout,omitempty"`
+	// FailurePolicy is "builtin" or "deny" (default: builtin)
+	FailurePolicy string `json:"failure_policy,omitempty"`
+}
+
+// Name returns the name of the object to be configured
+func (req *AdminConfig) ConfigName() string {
+	// singleton!
+	return DefaultAdminName
+}
+
+// DeepEqual compares two configurations
+func (req *AdminConfig) DeepEqual(other Config) bool {
+	return reflect.DeepEqual(req, other)
+}
+
+// String stringifies the configuration
+func (req *AdminConfig) String() string {
+	return fmt.Sprintf("%#v", req)
+}
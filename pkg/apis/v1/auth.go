@@ -0,0 +1,136 @@
+package v1
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/l7mp/stunner/pkg/apis/v1alpha1"
+)
+
+// AuthConfig defines the specification of the STUN/TURN authentication mechanism used by STUNner
+type AuthConfig struct {
+	// Type is the type of the STUN/TURN authentication mechanism ("static" or "ephemeral")
+	Type AuthType `json:"type,omitempty"`
+	// Realm defines the STUN/TURN realm to be used for STUNner
+	Realm string `json:"realm,omitempty"`
+	// GenericRealm derives the long-term keys with the realm of each request rather than with
+	// the configured realm (default: false)
+	GenericRealm bool `json:"generic_realm,omitempty"`
+	// Username is the username for "static" authentication
+	Username string `json:"username,omitempty"`
+	// Password is the password for "static" authentication
+	Password string `json:"password,omitempty"`
+	// Secret is the shared secret for "ephemeral" authentication
+	Secret string `json:"secret,omitempty"`
+	// Tenants lists the credentials of the tenants, used for the clients of the listeners of
+	// each tenant instead of the above ones
+	Tenants []TenantAuthConfig `json:"tenants,omitempty"`
+}
+
+// TenantAuthConfig defines the STUN/TURN authentication of the clients of a tenant
+type TenantAuthConfig struct {
+	// Tenant is the name of the tenant
+	Tenant string `json:"tenant"`
+	// Type is the type of the STUN/TURN authentication mechanism ("static" or "ephemeral")
+	Type AuthType `json:"type,omitempty"`
+	// Username is the username for "static" authentication
+	Username string `json:"username,omitempty"`
+	// Password is the password for "static" authentication
+	Password string `json:"password,omitempty"`
+	// Secret is the shared secret for "ephemeral" authentication
+	Secret string `json:"secret,omitempty"`
+}
+
+// Validate checks a configuration and injects defaults
+func (req *AuthConfig) Validate() error {
+	atype, err := validateCredentials(req.Type, req.Username, req.Password, req.Secret)
+	if err != nil {
+		return err
+	}
+	req.Type = atype
+
+	if req.Realm == "" {
+		req.Realm = DefaultRealm
+	}
+
+	seen := map[string]bool{}
+	for i := range req.Tenants {
+		t := &req.Tenants[i]
+		if err := v1alpha1.ValidateName(t.Tenant); err != nil {
+			return fmt.Errorf("invalid tenant name %q: %s", t.Tenant, err.Error())
+		}
+		if seen[t.Tenant] {
+			return fmt.Errorf("duplicate credentials for tenant %q", t.Tenant)
+		}
+		seen[t.Tenant] = true
+		atype, err := validateCredentials(t.Type, t.Username, t.Password, t.Secret)
+		if err != nil {
+			return fmt.Errorf("tenant %q: %s", t.Tenant, err.Error())
+		}
+		t.Type = atype
+	}
+	sort.Slice(req.Tenants, func(i, j int) bool {
+		return req.Tenants[i].Tenant < req.Tenants[j].Tenant
+	})
+
+	return nil
+}
+
+// validateCredentials checks the credentials of an authentication type, and returns the type with
+// the default injected
+func validateCredentials(authType AuthType, username, password, secret string) (AuthType, error) {
+	if authType == "" {
+		authType = DefaultAuthType
+	}
+	atype, err := NewAuthType(string(authType))
+	if err != nil {
+		return "", err
+	}
+
+	switch atype {
+	case AuthTypeStatic:
+		if username == "" || password == "" {
+			return "", fmt.Errorf("%s: empty username or password", atype)
+		}
+		if secret != "" {
+			return "", fmt.Errorf("%s: secret must not be set", atype)
+		}
+	case AuthTypeEphemeral:
+		if secret == "" {
+			return "", fmt.Errorf("%s: empty secret", atype)
+		}
+		if username != "" || password != "" {
+			return "", fmt.Errorf("%s: username/password must not be set", atype)
+		}
+	}
+
+	return atype, nil
+}
+
+// Name returns the name of the object to be configured
+func (req *AuthConfig) ConfigName() string {
+	// singleton!
+	return DefaultAuthName
+}
+
+// DeepEqual compares two configurations
+func (req *AuthConfig) DeepEqual(other Config) bool {
+	return reflect.DeepEqual(req, other)
+}
+
+// String stringifies the configuration, with the credentials redacted
+func (req *AuthConfig) String() string {
+	c := *req
+	c.Tenants = append([]TenantAuthConfig(nil), req.Tenants...)
+	secrets := []*string{&c.Password, &c.Secret}
+	for i := range c.Tenants {
+		secrets = append(secrets, &c.Tenants[i].Password, &c.Tenants[i].Secret)
+	}
+	for _, s := range secrets {
+		if *s != "" {
+			*s = "<SECRET>"
+		}
+	}
+	return fmt.Sprintf("%#v", &c)
+}
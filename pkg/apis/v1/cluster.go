@@ -0,0 +1,79 @@
+package v1
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+
+	"github.com/l7mp/stunner/pkg/apis/v1alpha1"
+)
+
+// ClusterConfig specifies a set of upstream peers STUNner can open transport relay connections
+// to, either listed explicitly (STATIC) or resolved from DNS domain names (STRICT_DNS)
+type ClusterConfig struct {
+	// Name is the name of the cluster
+	Name string `json:"name"`
+	// Type specifies the cluster address resolution policy, either STATIC or STRICT_DNS
+	Type ClusterType `json:"type,omitempty"`
+	// Endpoints specifies the peers that can be reached via this cluster
+	Endpoints []string `json:"endpoints,omitempty"`
+	// AllowSensitivePeers permits relaying to loopback, link-local, multicast and cloud metadata
+	// addresses via the cluster (default: false)
+	AllowSensitivePeers bool `json:"allow_sensitive_peers,omitempty"`
+	// Tenant is the tenant the cluster belongs to (default: no tenant)
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// Validate checks a configuration and injects defaults
+func (req *ClusterConfig) Validate() error {
+	if req.Name == "" {
+		return fmt.Errorf("missing name in cluster configuration: %s", req.String())
+	}
+	if err := v1alpha1.ValidateName(req.Name); err != nil {
+		return fmt.Errorf("invalid cluster name %q: %s", req.Name, err.Error())
+	}
+	if req.Type == "" {
+		req.Type = DefaultClusterType
+	}
+	ctype, err := NewClusterType(string(req.Type))
+	if err != nil {
+		return err
+	}
+	req.Type = ctype
+
+	if req.Type == ClusterTypeStatic {
+		for _, e := range req.Endpoints {
+			// either a CIDR or a plain IP address
+			if _, _, err := net.ParseCIDR(e); err != nil && net.ParseIP(e) == nil {
+				return fmt.Errorf("cluster %q: invalid endpoint %q", req.Name, e)
+			}
+		}
+	}
+
+	if req.Tenant != "" {
+		if err := v1alpha1.ValidateName(req.Tenant); err != nil {
+			return fmt.Errorf("cluster %q: invalid tenant name %q: %s", req.Name, req.Tenant,
+				err.Error())
+		}
+	}
+
+	sort.Strings(req.Endpoints)
+	return nil
+}
+
+// Name returns the name of the object to be configured
+func (req *ClusterConfig) ConfigName() string {
+	return req.Name
+}
+
+// DeepEqual compares two configurations
+func (req *ClusterConfig) DeepEqual(other Config) bool {
+	// endpoints must be sorted in both configs!
+	return reflect.DeepEqual(req, other)
+}
+
+// String stringifies the configuration
+func (req *ClusterConfig) String() string {
+	return fmt.Sprintf("%#v", req)
+}
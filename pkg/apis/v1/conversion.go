@@ -0,0 +1,423 @@
+package v1
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/l7mp/stunner/pkg/apis/v1alpha1"
+)
+
+// ConvertV1Alpha1ToV1 converts a v1alpha1 configuration to v1. The result is not validated.
+func ConvertV1Alpha1ToV1(in *v1alpha1.StunnerConfig) (*StunnerConfig, error) {
+	out := &StunnerConfig{
+		ApiVersion: ApiVersion,
+		Generation: in.Generation,
+		Admin: AdminConfig{
+			Name:                   in.Admin.Name,
+			LogLevel:               in.Admin.LogLevel,
+			LogFormat:              in.Admin.LogFormat,
+			LogFile:                in.Admin.LogFile,
+			LogMaxSize:             in.Admin.LogMaxSize,
+			LogMaxAge:              in.Admin.LogMaxAge,
+			LogMaxBackups:          in.Admin.LogMaxBackups,
+			LogThrottleInterval:    in.Admin.LogThrottleInterval,
+			LogThrottleBurst:       in.Admin.LogThrottleBurst,
+			SyslogEndpoint:         in.Admin.SyslogEndpoint,
+			SyslogFacility:         in.Admin.SyslogFacility,
+			AccessLog:              in.Admin.AccessLog,
+			AccessLogFormat:        in.Admin.AccessLogFormat,
+			AuditLog:               in.Admin.AuditLog,
+			TracingEndpoint:        in.Admin.TracingEndpoint,
+			TracingSampleRatio:     in.Admin.TracingSampleRatio,
+			CaptureDir:             in.Admin.CaptureDir,
+			MetricsEndpoint:        in.Admin.MetricsEndpoint,
+			APIEndpoint:            in.Admin.APIEndpoint,
+			APIToken:               in.Admin.APIToken,
+			SDSEndpoint:            in.Admin.SDSEndpoint,
+			NAT64Prefix:            in.Admin.NAT64Prefix,
+			RestartPolicy:          in.Admin.RestartPolicy,
+			DrainTimeout:           in.Admin.DrainTimeout,
+			EventWebhook:           in.Admin.EventWebhook,
+			DefaultRoute:           in.Admin.DefaultRoute,
+			ResourceProfile:        in.Admin.ResourceProfile,
+			FIPSMode:               in.Admin.FIPSMode,
+			AllowInsecureProtocols: in.Admin.AllowInsecureProtocols,
+			PermissionLifetime:     in.Admin.PermissionLifetime,
+			ChannelLifetime:        in.Admin.ChannelLifetime,
+			MaxAllocationLifetime:  in.Admin.MaxAllocationLifetime,
+		},
+		Auth: AuthConfig{
+			Realm:        in.Auth.Realm,
+			GenericRealm: in.Auth.GenericRealm,
+		},
+		Listeners: make([]ListenerConfig, len(in.Listeners)),
+		Clusters:  make([]ClusterConfig, len(in.Clusters)),
+	}
+
+	if n := in.Admin.Notifier.DeepCopy(); n != nil {
+		c := NotifierConfig(*n)
+		out.Admin.Notifier = &c
+	}
+	if mt := in.Admin.MessageTrace.DeepCopy(); mt != nil {
+		c := MessageTraceConfig(*mt)
+		out.Admin.MessageTrace = &c
+	}
+	if w := in.Admin.Watermarks; w != nil {
+		c := WatermarkConfig(*w)
+		out.Admin.Watermarks = &c
+	}
+	if d := in.Admin.DNSHealth; d != nil {
+		c := DNSHealthConfig(*d)
+		out.Admin.DNSHealth = &c
+	}
+	if p := in.Admin.LatencyProbe; p != nil {
+		c := LatencyProbeConfig(*p)
+		out.Admin.LatencyProbe = &c
+	}
+	if b := in.Admin.Ban; b != nil {
+		c := BanConfig(*b.DeepCopy())
+		out.Admin.Ban = &c
+	}
+	if q := in.Admin.Quota; q != nil {
+		c := QuotaConfig(*q)
+		out.Admin.Quota = &c
+	}
+	if a := in.Admin.Amplification; a != nil {
+		c := AmplificationConfig(*a)
+		out.Admin.Amplification = &c
+	}
+	if m := in.Admin.Malformed; m != nil {
+		c := MalformedConfig(*m)
+		out.Admin.Malformed = &c
+	}
+	if r := in.Admin.RequestRate; r != nil {
+		c := RequestRateConfig(*r)
+		out.Admin.RequestRate = &c
+	}
+	if p := in.Admin.PeerPorts; p != nil {
+		c := PeerPortConfig(*p.DeepCopy())
+		out.Admin.PeerPorts = &c
+	}
+	if r := in.Admin.Replication; r != nil {
+		c := ReplicationConfig(*r.DeepCopy())
+		out.Admin.Replication = &c
+	}
+	if h := in.Admin.ConsistentHashing; h != nil {
+		c := ConsistentHashingConfig(*h.DeepCopy())
+		out.Admin.ConsistentHashing = &c
+	}
+	if sb := in.Admin.Standby; sb != nil {
+		c := StandbyConfig(*sb.DeepCopy())
+		out.Admin.Standby = &c
+	}
+	if am := in.Admin.AddressMapping; am != nil {
+		c := AddressMappingConfig{Hairpin: am.Hairpin}
+		for _, m := range am.Mappings {
+			c.Mappings = append(c.Mappings, AddressMapping(m))
+		}
+		out.Admin.AddressMapping = &c
+	}
+	if pp := in.Admin.PolicyPlugin; pp != nil {
+		c := PolicyPluginConfig(*pp.DeepCopy())
+		out.Admin.PolicyPlugin = &c
+	}
+
+	if in.Auth.Type != "" {
+		atype, err := NewAuthType(in.Auth.Type)
+		if err != nil {
+			return nil, err
+		}
+		out.Auth.Type = atype
+	}
+	for k, v := range in.Auth.Credentials {
+		switch k {
+		case "username":
+			out.Auth.Username = v
+		case "password":
+			out.Auth.Password = v
+		case "secret":
+			out.Auth.Secret = v
+		default:
+			return nil, fmt.Errorf("cannot convert auth credential %q to %s", k, ApiVersion)
+		}
+	}
+
+	for _, t := range in.Auth.Tenants {
+		tenant := TenantAuthConfig{Tenant: t.Tenant}
+		if t.Type != "" {
+			atype, err := NewAuthType(t.Type)
+			if err != nil {
+				return nil, err
+			}
+			tenant.Type = atype
+		}
+		for k, v := range t.Credentials {
+			switch k {
+			case "username":
+				tenant.Username = v
+			case "password":
+				tenant.Password = v
+			case "secret":
+				tenant.Secret = v
+			default:
+				return nil, fmt.Errorf("cannot convert auth credential %q of tenant %q to %s",
+					k, t.Tenant, ApiVersion)
+			}
+		}
+		out.Auth.Tenants = append(out.Auth.Tenants, tenant)
+	}
+
+	for i, l := range in.Listeners {
+		out.Listeners[i] = ListenerConfig{
+			Name:                  l.Name,
+			Protocol:              ListenerProtocol(strings.ToUpper(l.Protocol)),
+			PublicAddress:         l.PublicAddr,
+			PublicPort:            l.PublicPort,
+			Address:               l.Addr,
+			Port:                  l.Port,
+			MinRelayPort:          l.MinRelayPort,
+			MaxRelayPort:          l.MaxRelayPort,
+			Cert:                  l.Cert,
+			Key:                   l.Key,
+			SDSSecret:             l.SDSSecret,
+			OCSPStapling:          l.OCSPStapling,
+			ClientCA:              l.ClientCA,
+			ClientCRL:             l.ClientCRL,
+			RequireFingerprint:    l.RequireFingerprint,
+			StrictAttributes:      l.StrictAttributes,
+			IndicationIntegrity:   l.IndicationIntegrity,
+			PermissionLifetime:    l.PermissionLifetime,
+			ChannelLifetime:       l.ChannelLifetime,
+			MaxAllocationLifetime: l.MaxAllocationLifetime,
+			Routes:                append([]string(nil), l.Routes...),
+			Tenant:                l.Tenant,
+			AllowedSourceCIDRs:    append([]string(nil), l.AllowedSourceCIDRs...),
+			DeniedSourceCIDRs:     append([]string(nil), l.DeniedSourceCIDRs...),
+			BindToDevice:          l.BindToDevice,
+			DSCP:                  l.DSCP,
+			AlternateAddr:         l.AlternateAddr,
+			AlternatePort:         l.AlternatePort,
+		}
+	}
+
+	for i, c := range in.Clusters {
+		out.Clusters[i] = ClusterConfig{
+			Name:                c.Name,
+			Type:                ClusterType(strings.ToUpper(c.Type)),
+			Endpoints:           append([]string(nil), c.Endpoints...),
+			AllowSensitivePeers: c.AllowSensitivePeers,
+			Tenant:              c.Tenant,
+		}
+	}
+
+	return out, nil
+}
+
+// ConvertV1ToV1Alpha1 converts a v1 configuration to v1alpha1, e.g., to feed it to a STUNner
+// daemon that implements the v1alpha1 API. The result is not validated.
+func ConvertV1ToV1Alpha1(in *StunnerConfig) (*v1alpha1.StunnerConfig, error) {
+	out := &v1alpha1.StunnerConfig{
+		ApiVersion: v1alpha1.ApiVersion,
+		Generation: in.Generation,
+		Admin: v1alpha1.AdminConfig{
+			Name:                   in.Admin.Name,
+			LogLevel:               in.Admin.LogLevel,
+			LogFormat:              in.Admin.LogFormat,
+			LogFile:                in.Admin.LogFile,
+			LogMaxSize:             in.Admin.LogMaxSize,
+			LogMaxAge:              in.Admin.LogMaxAge,
+			LogMaxBackups:          in.Admin.LogMaxBackups,
+			LogThrottleInterval:    in.Admin.LogThrottleInterval,
+			LogThrottleBurst:       in.Admin.LogThrottleBurst,
+			SyslogEndpoint:         in.Admin.SyslogEndpoint,
+			SyslogFacility:         in.Admin.SyslogFacility,
+			AccessLog:              in.Admin.AccessLog,
+			AccessLogFormat:        in.Admin.AccessLogFormat,
+			AuditLog:               in.Admin.AuditLog,
+			TracingEndpoint:        in.Admin.TracingEndpoint,
+			TracingSampleRatio:     in.Admin.TracingSampleRatio,
+			CaptureDir:             in.Admin.CaptureDir,
+			MetricsEndpoint:        in.Admin.MetricsEndpoint,
+			APIEndpoint:            in.Admin.APIEndpoint,
+			APIToken:               in.Admin.APIToken,
+			SDSEndpoint:            in.Admin.SDSEndpoint,
+			NAT64Prefix:            in.Admin.NAT64Prefix,
+			RestartPolicy:          in.Admin.RestartPolicy,
+			DrainTimeout:           in.Admin.DrainTimeout,
+			EventWebhook:           in.Admin.EventWebhook,
+			DefaultRoute:           in.Admin.DefaultRoute,
+			ResourceProfile:        in.Admin.ResourceProfile,
+			FIPSMode:               in.Admin.FIPSMode,
+			AllowInsecureProtocols: in.Admin.AllowInsecureProtocols,
+			PermissionLifetime:     in.Admin.PermissionLifetime,
+			ChannelLifetime:        in.Admin.ChannelLifetime,
+			MaxAllocationLifetime:  in.Admin.MaxAllocationLifetime,
+		},
+		Auth: v1alpha1.AuthConfig{
+			Realm:        in.Auth.Realm,
+			GenericRealm: in.Auth.GenericRealm,
+			Credentials:  map[string]string{},
+		},
+		Listeners: make([]v1alpha1.ListenerConfig, len(in.Listeners)),
+		Clusters:  make([]v1alpha1.ClusterConfig, len(in.Clusters)),
+	}
+
+	if in.Admin.Notifier != nil {
+		n := v1alpha1.NotifierConfig(*in.Admin.Notifier)
+		out.Admin.Notifier = n.DeepCopy()
+	}
+	if in.Admin.MessageTrace != nil {
+		mt := v1alpha1.MessageTraceConfig(*in.Admin.MessageTrace)
+		out.Admin.MessageTrace = mt.DeepCopy()
+	}
+	if in.Admin.Watermarks != nil {
+		w := v1alpha1.WatermarkConfig(*in.Admin.Watermarks)
+		out.Admin.Watermarks = &w
+	}
+	if in.Admin.DNSHealth != nil {
+		d := v1alpha1.DNSHealthConfig(*in.Admin.DNSHealth)
+		out.Admin.DNSHealth = &d
+	}
+	if in.Admin.LatencyProbe != nil {
+		p := v1alpha1.LatencyProbeConfig(*in.Admin.LatencyProbe)
+		out.Admin.LatencyProbe = &p
+	}
+	if in.Admin.Ban != nil {
+		b := v1alpha1.BanConfig(*in.Admin.Ban)
+		b.ExemptCIDRs = append([]string(nil), in.Admin.Ban.ExemptCIDRs...)
+		out.Admin.Ban = &b
+	}
+	if in.Admin.Quota != nil {
+		q := v1alpha1.QuotaConfig(*in.Admin.Quota)
+		out.Admin.Quota = &q
+	}
+	if in.Admin.Amplification != nil {
+		a := v1alpha1.AmplificationConfig(*in.Admin.Amplification)
+		out.Admin.Amplification = &a
+	}
+	if in.Admin.Malformed != nil {
+		m := v1alpha1.MalformedConfig(*in.Admin.Malformed)
+		out.Admin.Malformed = &m
+	}
+	if in.Admin.RequestRate != nil {
+		r := v1alpha1.RequestRateConfig(*in.Admin.RequestRate)
+		out.Admin.RequestRate = &r
+	}
+	if in.Admin.PeerPorts != nil {
+		p := v1alpha1.PeerPortConfig(*in.Admin.PeerPorts)
+		p.Allow = append([]string(nil), in.Admin.PeerPorts.Allow...)
+		p.Deny = append([]string(nil), in.Admin.PeerPorts.Deny...)
+		out.Admin.PeerPorts = &p
+	}
+	if in.Admin.Replication != nil {
+		r := v1alpha1.ReplicationConfig(*in.Admin.Replication)
+		r.Peers = append([]string(nil), in.Admin.Replication.Peers...)
+		out.Admin.Replication = &r
+	}
+	if in.Admin.ConsistentHashing != nil {
+		h := v1alpha1.ConsistentHashingConfig(*in.Admin.ConsistentHashing)
+		out.Admin.ConsistentHashing = h.DeepCopy()
+	}
+	if in.Admin.Standby != nil {
+		sb := v1alpha1.StandbyConfig(*in.Admin.Standby)
+		out.Admin.Standby = sb.DeepCopy()
+	}
+	if in.Admin.AddressMapping != nil {
+		am := &v1alpha1.AddressMappingConfig{Hairpin: in.Admin.AddressMapping.Hairpin}
+		for _, m := range in.Admin.AddressMapping.Mappings {
+			am.Mappings = append(am.Mappings, v1alpha1.AddressMapping(m))
+		}
+		out.Admin.AddressMapping = am
+	}
+	if in.Admin.PolicyPlugin != nil {
+		pp := v1alpha1.PolicyPluginConfig(*in.Admin.PolicyPlugin)
+		out.Admin.PolicyPlugin = pp.DeepCopy()
+	}
+
+	if in.Auth.Type != "" {
+		atype, err := NewAuthType(string(in.Auth.Type))
+		if err != nil {
+			return nil, err
+		}
+		switch atype {
+		case AuthTypeStatic:
+			out.Auth.Type = v1alpha1.AuthTypePlainText.String()
+		case AuthTypeEphemeral:
+			out.Auth.Type = v1alpha1.AuthTypeLongTerm.String()
+		}
+	}
+	for k, v := range map[string]string{"username": in.Auth.Username,
+		"password": in.Auth.Password, "secret": in.Auth.Secret} {
+		if v != "" {
+			out.Auth.Credentials[k] = v
+		}
+	}
+
+	for _, t := range in.Auth.Tenants {
+		tenant := v1alpha1.TenantAuthConfig{Tenant: t.Tenant, Credentials: map[string]string{}}
+		if t.Type != "" {
+			atype, err := NewAuthType(string(t.Type))
+			if err != nil {
+				return nil, err
+			}
+			switch atype {
+			case AuthTypeStatic:
+				tenant.Type = v1alpha1.AuthTypePlainText.String()
+			case AuthTypeEphemeral:
+				tenant.Type = v1alpha1.AuthTypeLongTerm.String()
+			}
+		}
+		for k, v := range map[string]string{"username": t.Username, "password": t.Password,
+			"secret": t.Secret} {
+			if v != "" {
+				tenant.Credentials[k] = v
+			}
+		}
+		out.Auth.Tenants = append(out.Auth.Tenants, tenant)
+	}
+
+	for i, l := range in.Listeners {
+		out.Listeners[i] = v1alpha1.ListenerConfig{
+			Name:                  l.Name,
+			Protocol:              strings.ToLower(string(l.Protocol)),
+			PublicAddr:            l.PublicAddress,
+			PublicPort:            l.PublicPort,
+			Addr:                  l.Address,
+			Port:                  l.Port,
+			MinRelayPort:          l.MinRelayPort,
+			MaxRelayPort:          l.MaxRelayPort,
+			Cert:                  l.Cert,
+			Key:                   l.Key,
+			SDSSecret:             l.SDSSecret,
+			OCSPStapling:          l.OCSPStapling,
+			ClientCA:              l.ClientCA,
+			ClientCRL:             l.ClientCRL,
+			RequireFingerprint:    l.RequireFingerprint,
+			StrictAttributes:      l.StrictAttributes,
+			IndicationIntegrity:   l.IndicationIntegrity,
+			PermissionLifetime:    l.PermissionLifetime,
+			ChannelLifetime:       l.ChannelLifetime,
+			MaxAllocationLifetime: l.MaxAllocationLifetime,
+			Routes:                append([]string(nil), l.Routes...),
+			Tenant:                l.Tenant,
+			AllowedSourceCIDRs:    append([]string(nil), l.AllowedSourceCIDRs...),
+			DeniedSourceCIDRs:     append([]string(nil), l.DeniedSourceCIDRs...),
+			BindToDevice:          l.BindToDevice,
+			DSCP:                  l.DSCP,
+			AlternateAddr:         l.AlternateAddr,
+			AlternatePort:         l.AlternatePort,
+		}
+	}
+
+	for i, c := range in.Clusters {
+		out.Clusters[i] = v1alpha1.ClusterConfig{
+			Name:                c.Name,
+			Type:                string(c.Type),
+			Endpoints:           append([]string(nil), c.Endpoints...),
+			AllowSensitivePeers: c.AllowSensitivePeers,
+			Tenant:              c.Tenant,
+		}
+	}
+
+	return out, nil
+}
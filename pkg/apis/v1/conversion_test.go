@@ -0,0 +1,128 @@
+package v1
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/l7mp/stunner/pkg/apis/v1alpha1"
+)
+
+func testV1Alpha1Config() *v1alpha1.StunnerConfig {
+	allow := true
+	return &v1alpha1.StunnerConfig{
+		ApiVersion: v1alpha1.ApiVersion,
+		Generation: 3,
+		Admin: v1alpha1.AdminConfig{
+			Name:                   "stunner",
+			LogLevel:               "all:DEBUG",
+			MetricsEndpoint:        "http://:8080/metrics",
+			APIToken:               "token",
+			SDSEndpoint:            "unix:///var/run/stunner/sds.sock",
+			FIPSMode:               true,
+			AllowInsecureProtocols: &allow,
+			Quota:                  &v1alpha1.QuotaConfig{},
+			AddressMapping: &v1alpha1.AddressMappingConfig{Hairpin: true,
+				Mappings: []v1alpha1.AddressMapping{{External: "1.2.3.4", Internal: "10.0.0.1"}}},
+		},
+		Auth: v1alpha1.AuthConfig{
+			Type:  "plaintext",
+			Realm: "stunner.l7mp.io",
+			Credentials: map[string]string{
+				"username": "user",
+				"password": "pass",
+			},
+			Tenants: []v1alpha1.TenantAuthConfig{{
+				Tenant:      "team-a",
+				Type:        "longterm",
+				Credentials: map[string]string{"secret": "secret"},
+			}},
+		},
+		Listeners: []v1alpha1.ListenerConfig{{
+			Name:               "udp",
+			Protocol:           "udp",
+			PublicAddr:         "1.2.3.4",
+			PublicPort:         3479,
+			Addr:               "10.0.0.1",
+			Port:               3478,
+			MinRelayPort:       10000,
+			MaxRelayPort:       20000,
+			RequireFingerprint: true,
+			PermissionLifetime: 120,
+			Routes:             []string{"media"},
+			Tenant:             "team-a",
+			AllowedSourceCIDRs: []string{"10.0.0.0/8"},
+			DSCP:               46,
+			AlternateAddr:      "10.0.0.2",
+			AlternatePort:      3479,
+		}, {
+			Name:     "tls",
+			Protocol: "tls",
+			Addr:     "10.0.0.1",
+			Port:     443,
+			Cert:     "cert.pem",
+			Key:      "key.pem",
+			ClientCA: "ca.pem",
+		}, {
+			Name:      "dtls",
+			Protocol:  "dtls",
+			Addr:      "10.0.0.1",
+			Port:      443,
+			SDSSecret: "dtls-cert",
+		}},
+		Clusters: []v1alpha1.ClusterConfig{{
+			Name:      "media",
+			Type:      "STRICT_DNS",
+			Endpoints: []string{"media.default.svc.cluster.local"},
+			Tenant:    "team-a",
+		}},
+	}
+}
+
+func TestConversionRoundTrip(t *testing.T) {
+	in := testV1Alpha1Config()
+
+	c, err := ConvertV1Alpha1ToV1(in)
+	assert.NoError(t, err, "convert to v1")
+	assert.Equal(t, ApiVersion, c.ApiVersion, "version")
+	assert.Equal(t, AuthTypeStatic, c.Auth.Type, "auth type")
+	assert.Equal(t, "user", c.Auth.Username, "username")
+	assert.Equal(t, AuthTypeEphemeral, c.Auth.Tenants[0].Type, "tenant auth type")
+	assert.Equal(t, ListenerProtocolUDP, c.Listeners[0].Protocol, "protocol")
+	assert.Equal(t, "1.2.3.4", c.Listeners[0].PublicAddress, "public address")
+	assert.Equal(t, 3479, c.Listeners[0].PublicPort, "public port")
+	assert.Equal(t, "dtls-cert", c.Listeners[2].SDSSecret, "SDS secret")
+	assert.Equal(t, ClusterTypeStrictDNS, c.Clusters[0].Type, "cluster type")
+
+	out, err := ConvertV1ToV1Alpha1(c)
+	assert.NoError(t, err, "convert to v1alpha1")
+	assert.Equal(t, in, out, "round trip")
+
+	// and the other way around
+	back, err := ConvertV1Alpha1ToV1(out)
+	assert.NoError(t, err, "convert to v1")
+	assert.Equal(t, c, back, "round trip")
+}
+
+func TestConversionErrors(t *testing.T) {
+	in := testV1Alpha1Config()
+	in.Auth.Type = "oauth"
+	_, err := ConvertV1Alpha1ToV1(in)
+	assert.Error(t, err, "unknown auth type")
+
+	in = testV1Alpha1Config()
+	in.Auth.Credentials["token"] = "token"
+	_, err = ConvertV1Alpha1ToV1(in)
+	assert.Error(t, err, "unknown credential")
+
+	in = testV1Alpha1Config()
+	in.Auth.Tenants[0].Credentials["token"] = "token"
+	_, err = ConvertV1Alpha1ToV1(in)
+	assert.Error(t, err, "unknown tenant credential")
+
+	c, err := ConvertV1Alpha1ToV1(testV1Alpha1Config())
+	assert.NoError(t, err, "convert to v1")
+	c.Auth.Type = "oauth"
+	_, err = ConvertV1ToV1Alpha1(c)
+	assert.Error(t, err, "unknown auth type")
+}
@@ -0,0 +1,52 @@
+package v1
+
+const ApiVersion string = "v1"
+const DefaultStunnerName = "default-stunnerd"
+const DefaultProtocol = ListenerProtocolUDP
+const DefaultPort int = 3478
+const DefaultLogLevel = "all:INFO"
+const DefaultRealm = "stunner.l7mp.io"
+const DefaultAuthType = AuthTypeStatic
+
+const DefaultMinRelayPort int = 1 << 15
+const DefaultMaxRelayPort int = 1<<16 - 1
+const DefaultClusterType = ClusterTypeStatic
+
+const DefaultAdminName = "default-admin-config"
+const DefaultAuthName = "default-auth-config"
+
+const DefaultRestartPolicy = "immediate"
+const DefaultDrainTimeout int = 60
+const DefaultDefaultRoute = "deny"
+const DefaultResourceProfile = "default"
+const DefaultLogFormat = "console"
+const DefaultLogMaxSize int = 100
+const DefaultLogMaxBackups int = 5
+const DefaultLogThrottleInterval int = 10
+const DefaultLogThrottleBurst int = 100
+const DefaultSyslogFacility = "daemon"
+const DefaultAccessLogFormat = "json"
+const DefaultTracingSampleRatio = 0.1
+const DefaultAuthFailureThreshold int = 10
+const DefaultRelayPortWatermark int = 80
+const DefaultFileDescriptorWatermark int = 80
+const DefaultDNSFailureThreshold int = 30
+const DefaultLatencyProbeProtocol = "icmp"
+const DefaultLatencyProbePort int = 7
+const DefaultLatencyProbeInterval int = 10
+const DefaultLatencyProbeTimeout int = 1000
+const DefaultLatencyProbeSampleSize int = 3
+const DefaultBanWindow int = 60
+const DefaultBanDuration int = 600
+const DefaultBanMalformedPackets int = 100
+const DefaultBanPermissionDenials int = 50
+const DefaultRequestRatePerUser int = 20
+const DefaultReplicationInterval int = 5
+const DefaultConsistentHashingMode = "hint"
+const DefaultStandbyHeartbeatInterval int = 1
+const DefaultStandbyFailoverTimeout int = 3
+const DefaultPolicyPluginTimeout int = 100
+const DefaultPolicyPluginFailurePolicy = "builtin"
+
+const DefaultMetricsPort int = 8080
+const DefaultAPIPort int = 8086
@@ -0,0 +1,19 @@
+// Package v1 is the v1 version of the STUNner API.
+//
+// Compared to v1alpha1, v1 uses typed enums for the authentication, listener protocol and
+// cluster types, replaces the free-form authentication credentials map with explicit fields, and
+// validates the configuration structurally (e.g., name uniqueness). Use ConvertV1Alpha1ToV1 and
+// ConvertV1ToV1Alpha1 to convert between the two API versions.
+package v1
+
+// Config is the main interface for STUNner configuration objects
+type Config interface {
+	// Validate checks a configuration and injects defaults
+	Validate() error
+	// Name returns the name of the object to be configured
+	ConfigName() string
+	// DeepEqual compares two configurations
+	DeepEqual(other Config) bool
+	// String stringifies the configuration
+	String() string
+}
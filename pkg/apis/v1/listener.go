@@ -0,0 +1,213 @@
+package v1
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+
+	"github.com/l7mp/stunner/pkg/apis/v1alpha1"
+)
+
+// ListenerConfig specifies a particular listener for the STUNner deamon
+type ListenerConfig struct {
+	// Name is the name of the listener
+	Name string `json:"name"`
+	// Protocol is the transport protocol used by the listener ("UDP", "TCP", "TLS", "DTLS",
+	// "WS", "WSS")
+	Protocol ListenerProtocol `json:"protocol,omitempty"`
+	// PublicAddress is the Internet-facing public IP address of the listener
+	PublicAddress string `json:"public_address,omitempty"`
+	// PublicPort is the Internet-facing public port of the listener
+	PublicPort int `json:"public_port,omitempty"`
+	// Address is the IP address of the listener
+	Address string `json:"address,omitempty"`
+	// Port is the port for the listener
+	Port int `json:"port,omitempty"`
+	// MinRelayPort is the smallest relay port assigned for the relay connections spawned by
+	// the listener
+	MinRelayPort int `json:"min_relay_port,omitempty"`
+	// MaxRelayPort is the highest relay port assigned for the relay connections spawned by the
+	// listener
+	MaxRelayPort int `json:"max_relay_port,omitempty"`
+	// Cert is the path to the TLS cert file
+	Cert string `json:"cert,omitempty"`
+	// Key is the path to the TLS key file
+	Key string `json:"key,omitempty"`
+	// SDSSecret is the name of the secret holding the TLS cert/key pair at the secret discovery
+	// service, used instead of Cert and Key
+	SDSSecret string `json:"sds_secret,omitempty"`
+	// OCSPStapling makes a TLS or WSS listener staple an OCSP response for its certificate
+	OCSPStapling bool `json:"ocsp_stapling,omitempty"`
+	// ClientCA is the path to the CA bundle client certificates are verified against (mTLS)
+	ClientCA string `json:"client_ca,omitempty"`
+	// ClientCRL is the path to the revocation list of the client certificates
+	ClientCRL string `json:"client_crl,omitempty"`
+	// RequireFingerprint drops the STUN messages with no valid FINGERPRINT attribute
+	RequireFingerprint bool `json:"require_fingerprint,omitempty"`
+	// StrictAttributes refuses the STUN messages with unknown comprehension-required attributes
+	StrictAttributes bool `json:"strict_attributes,omitempty"`
+	// IndicationIntegrity drops the STUN indications with no valid MESSAGE-INTEGRITY attribute
+	IndicationIntegrity bool `json:"indication_integrity,omitempty"`
+	// PermissionLifetime overrides the permission lifetime of the gateway
+	PermissionLifetime int `json:"permission_lifetime,omitempty"`
+	// ChannelLifetime overrides the channel binding lifetime of the gateway
+	ChannelLifetime int `json:"channel_lifetime,omitempty"`
+	// MaxAllocationLifetime overrides the maximum allocation lifetime of the gateway
+	MaxAllocationLifetime int `json:"max_allocation_lifetime,omitempty"`
+	// Routes specifies the list of Routes allowed via a listener
+	Routes []string `json:"routes,omitempty"`
+	// Tenant is the tenant the listener belongs to: the listener can only route to the clusters
+	// of the tenant (default: no tenant)
+	Tenant string `json:"tenant,omitempty"`
+	// AllowedSourceCIDRs lists the IP prefixes of the clients accepted by the listener (default:
+	// any source)
+	AllowedSourceCIDRs []string `json:"allowed_source_cidrs,omitempty"`
+	// DeniedSourceCIDRs lists the IP prefixes of the clients refused by the listener, overriding
+	// AllowedSourceCIDRs
+	DeniedSourceCIDRs []string `json:"denied_source_cidrs,omitempty"`
+	// BindToDevice binds the listener and the relay sockets to a network interface
+	BindToDevice string `json:"bind_to_device,omitempty"`
+	// DSCP is the Differentiated Services Code Point of the packets sent by the listener
+	DSCP int `json:"dscp,omitempty"`
+	// AlternateAddr is the alternate IP address of a UDP listener for RFC 5780 NAT behavior
+	// discovery
+	AlternateAddr string `json:"alternate_address,omitempty"`
+	// AlternatePort is the alternate port of a UDP listener for RFC 5780 NAT behavior discovery,
+	// required to enable the discovery
+	AlternatePort int `json:"alternate_port,omitempty"`
+}
+
+// Validate checks a configuration and injects defaults
+func (req *ListenerConfig) Validate() error {
+	if req.Name == "" {
+		return fmt.Errorf("missing name in listener configuration: %s", req.String())
+	}
+	if err := v1alpha1.ValidateName(req.Name); err != nil {
+		return fmt.Errorf("invalid listener name %q: %s", req.Name, err.Error())
+	}
+
+	if req.Protocol == "" {
+		req.Protocol = DefaultProtocol
+	}
+	proto, err := NewListenerProtocol(string(req.Protocol))
+	if err != nil {
+		return err
+	}
+	req.Protocol = proto
+
+	if req.Address == "" {
+		req.Address = "0.0.0.0"
+	}
+	if req.Address != "localhost" && net.ParseIP(req.Address) == nil {
+		return fmt.Errorf("listener %q: invalid address %q", req.Name, req.Address)
+	}
+
+	if req.Port == 0 {
+		req.Port = DefaultPort
+	}
+	if req.MinRelayPort == 0 {
+		req.MinRelayPort = DefaultMinRelayPort
+	}
+	if req.MaxRelayPort == 0 {
+		req.MaxRelayPort = DefaultMaxRelayPort
+	}
+	for _, p := range []int{req.Port, req.MinRelayPort, req.MaxRelayPort} {
+		if p <= 0 || p > 65535 {
+			return fmt.Errorf("listener %q: invalid port: %d", req.Name, p)
+		}
+	}
+	if req.MinRelayPort > req.MaxRelayPort {
+		return fmt.Errorf("listener %q: empty relay port range [%d:%d]", req.Name,
+			req.MinRelayPort, req.MaxRelayPort)
+	}
+
+	if req.Protocol.IsTLS() && req.SDSSecret == "" && (req.Cert == "" || req.Key == "") {
+		return fmt.Errorf("listener %q: protocol %s requires a TLS certificate and key or "+
+			"an SDS secret", req.Name, req.Protocol)
+	}
+	if req.SDSSecret != "" {
+		if !req.Protocol.IsTLS() {
+			return fmt.Errorf("listener %q: SDS secrets are not supported for protocol %s",
+				req.Name, req.Protocol)
+		}
+		if req.Cert != "" || req.Key != "" {
+			return fmt.Errorf("listener %q: both a TLS certificate and key and an SDS secret "+
+				"are set", req.Name)
+		}
+	}
+
+	if req.Tenant != "" {
+		if err := v1alpha1.ValidateName(req.Tenant); err != nil {
+			return fmt.Errorf("listener %q: invalid tenant name %q: %s", req.Name, req.Tenant,
+				err.Error())
+		}
+	}
+
+	for _, c := range append(append([]string{}, req.AllowedSourceCIDRs...), req.DeniedSourceCIDRs...) {
+		if _, err := v1alpha1.ParseSourceCIDR(c); err != nil {
+			return fmt.Errorf("listener %q: %s", req.Name, err.Error())
+		}
+	}
+
+	if req.DSCP < 0 || req.DSCP > 63 {
+		return fmt.Errorf("listener %q: invalid DSCP %d (must be between 0 and 63)", req.Name,
+			req.DSCP)
+	}
+
+	if req.AlternateAddr != "" || req.AlternatePort != 0 {
+		if req.Protocol != ListenerProtocolUDP {
+			return fmt.Errorf("listener %q: NAT behavior discovery is not supported for "+
+				"protocol %s", req.Name, req.Protocol)
+		}
+		if req.AlternatePort <= 0 || req.AlternatePort > 65535 || req.AlternatePort == req.Port {
+			return fmt.Errorf("listener %q: invalid alternate port: %d", req.Name,
+				req.AlternatePort)
+		}
+		if ip := net.ParseIP(req.Address); ip == nil || ip.IsUnspecified() {
+			return fmt.Errorf("listener %q: NAT behavior discovery requires a specific "+
+				"listener address, not %q", req.Name, req.Address)
+		}
+		if req.AlternateAddr != "" && net.ParseIP(req.AlternateAddr) == nil {
+			return fmt.Errorf("listener %q: invalid alternate address: %q", req.Name,
+				req.AlternateAddr)
+		}
+	}
+
+	if req.OCSPStapling && req.Protocol != ListenerProtocolTLS &&
+		req.Protocol != ListenerProtocolWSS {
+		return fmt.Errorf("listener %q: OCSP stapling is not supported for protocol %s",
+			req.Name, req.Protocol)
+	}
+	if req.ClientCA != "" && !req.Protocol.IsTLS() {
+		return fmt.Errorf("listener %q: client certificates are not supported for protocol %s",
+			req.Name, req.Protocol)
+	}
+	if req.ClientCRL != "" && req.ClientCA == "" {
+		return fmt.Errorf("listener %q: client CRL requires a client CA", req.Name)
+	}
+	if err := v1alpha1.ValidateLifetimes(req.PermissionLifetime, req.ChannelLifetime,
+		req.MaxAllocationLifetime); err != nil {
+		return fmt.Errorf("listener %q: %s", req.Name, err.Error())
+	}
+
+	sort.Strings(req.Routes)
+	sort.Strings(req.AllowedSourceCIDRs)
+	sort.Strings(req.DeniedSourceCIDRs)
+	return nil
+}
+
+// Name returns the name of the object to be configured
+func (req *ListenerConfig) ConfigName() string {
+	return req.Name
+}
+
+// DeepEqual compares two configurations
+func (req *ListenerConfig) DeepEqual(other Config) bool {
+	return reflect.DeepEqual(req, other)
+}
+
+// String stringifies the configuration
+func (req *ListenerConfig) String() string {
+	return fmt.Sprintf("%#v", req)
+}
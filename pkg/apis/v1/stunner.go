@@ -0,0 +1,106 @@
+package v1
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// StunnerConfig configures the STUnner daemon
+type StunnerConfig struct {
+	// ApiVersion is the version of the STUNner API implemented
+	ApiVersion string `json:"version"`
+	// Generation is the config version set by the control plane (default: 0, untracked)
+	Generation int64 `json:"generation,omitempty"`
+	// AdminConfig holds the administrative configuration
+	Admin AdminConfig `json:"admin,omitempty"`
+	// Auth defines the specification of the STUN/TURN authentication mechanism used by STUNner
+	Auth AuthConfig `json:"auth"`
+	// Listeners defines the listeners for STUNner
+	Listeners []ListenerConfig `json:"listeners,omitempty"`
+	// Clusters defines the upstream endpoints to which transport peer connections can be made
+	// through STUNner
+	Clusters []ClusterConfig `json:"clusters,omitempty"`
+}
+
+// Validate checks if a configuration is correct and injects defaults
+func (req *StunnerConfig) Validate() error {
+	if req.ApiVersion != ApiVersion {
+		return fmt.Errorf("unsupported API version: %q", req.ApiVersion)
+	}
+
+	if req.Generation < 0 {
+		return fmt.Errorf("invalid config generation: %d", req.Generation)
+	}
+
+	if err := req.Admin.Validate(); err != nil {
+		return err
+	}
+
+	if err := req.Auth.Validate(); err != nil {
+		return err
+	}
+
+	names := map[string]bool{}
+	for i := range req.Listeners {
+		if err := req.Listeners[i].Validate(); err != nil {
+			return err
+		}
+		if names[req.Listeners[i].Name] {
+			return fmt.Errorf("duplicate listener name %q", req.Listeners[i].Name)
+		}
+		if req.Listeners[i].SDSSecret != "" && req.Admin.SDSEndpoint == "" {
+			return fmt.Errorf("listener %q: SDS secret %q requires an SDS endpoint",
+				req.Listeners[i].Name, req.Listeners[i].SDSSecret)
+		}
+		names[req.Listeners[i].Name] = true
+	}
+	sort.Slice(req.Listeners, func(i, j int) bool {
+		return req.Listeners[i].Name < req.Listeners[j].Name
+	})
+
+	names = map[string]bool{}
+	for i := range req.Clusters {
+		if err := req.Clusters[i].Validate(); err != nil {
+			return err
+		}
+		if names[req.Clusters[i].Name] {
+			return fmt.Errorf("duplicate cluster name %q", req.Clusters[i].Name)
+		}
+		names[req.Clusters[i].Name] = true
+	}
+	sort.Slice(req.Clusters, func(i, j int) bool {
+		return req.Clusters[i].Name < req.Clusters[j].Name
+	})
+
+	// listeners can only route to the clusters of their own tenant
+	tenants := map[string]string{}
+	for _, c := range req.Clusters {
+		tenants[c.Name] = c.Tenant
+	}
+	for _, l := range req.Listeners {
+		for _, r := range l.Routes {
+			if t, ok := tenants[r]; ok && t != l.Tenant {
+				return fmt.Errorf("listener %q of tenant %q cannot route to cluster %q of "+
+					"tenant %q", l.Name, l.Tenant, r, t)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Name returns the name of the object to be configured
+func (req *StunnerConfig) ConfigName() string {
+	return req.Admin.Name
+}
+
+// DeepEqual compares two configurations
+func (req *StunnerConfig) DeepEqual(conf Config) bool {
+	return reflect.DeepEqual(req, conf)
+}
+
+// String stringifies the configuration
+func (req *StunnerConfig) String() string {
+	return fmt.Sprintf("%#v", req)
+}
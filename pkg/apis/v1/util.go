@@ -0,0 +1,79 @@
+package v1
+
+import (
+	"fmt"
+	"strings"
+)
+
+// AuthType species the type of the STUN/TURN authentication mechanism used by STUNner
+type AuthType string
+
+const (
+	// AuthTypeStatic is authentication with a static username/password pair (formerly
+	// "plaintext")
+	AuthTypeStatic AuthType = "static"
+	// AuthTypeEphemeral is authentication with time-windowed credentials derived from a
+	// shared secret (formerly "longterm")
+	AuthTypeEphemeral AuthType = "ephemeral"
+)
+
+// NewAuthType parses the authentication mechanism specification. The v1alpha1 names "plaintext"
+// and "longterm" are accepted as aliases
+func NewAuthType(raw string) (AuthType, error) {
+	switch strings.ToLower(raw) {
+	case string(AuthTypeStatic), "plaintext":
+		return AuthTypeStatic, nil
+	case string(AuthTypeEphemeral), "longterm":
+		return AuthTypeEphemeral, nil
+	default:
+		return "", fmt.Errorf("unknown authentication type: %q", raw)
+	}
+}
+
+// ListenerProtocol specifies the network protocol for a listener
+type ListenerProtocol string
+
+const (
+	ListenerProtocolUDP  ListenerProtocol = "UDP"
+	ListenerProtocolTCP  ListenerProtocol = "TCP"
+	ListenerProtocolTLS  ListenerProtocol = "TLS"
+	ListenerProtocolDTLS ListenerProtocol = "DTLS"
+	ListenerProtocolWS   ListenerProtocol = "WS"
+	ListenerProtocolWSS  ListenerProtocol = "WSS"
+)
+
+// NewListenerProtocol parses the protocol specification (case-insensitive)
+func NewListenerProtocol(raw string) (ListenerProtocol, error) {
+	p := ListenerProtocol(strings.ToUpper(raw))
+	switch p {
+	case ListenerProtocolUDP, ListenerProtocolTCP, ListenerProtocolTLS, ListenerProtocolDTLS,
+		ListenerProtocolWS, ListenerProtocolWSS:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown listener protocol: %q", raw)
+	}
+}
+
+// IsTLS returns true if the protocol requires a TLS certificate
+func (p ListenerProtocol) IsTLS() bool {
+	return p == ListenerProtocolTLS || p == ListenerProtocolDTLS || p == ListenerProtocolWSS
+}
+
+// ClusterType specifies the cluster address resolution policy
+type ClusterType string
+
+const (
+	ClusterTypeStatic    ClusterType = "STATIC"
+	ClusterTypeStrictDNS ClusterType = "STRICT_DNS"
+)
+
+// NewClusterType parses the cluster type specification (case-insensitive)
+func NewClusterType(raw string) (ClusterType, error) {
+	t := ClusterType(strings.ToUpper(raw))
+	switch t {
+	case ClusterTypeStatic, ClusterTypeStrictDNS:
+		return t, nil
+	default:
+		return "", fmt.Errorf("unknown cluster type: %q", raw)
+	}
+}
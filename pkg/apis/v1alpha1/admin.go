@@ -2,29 +2,262 @@ package v1alpha1
 
 import (
 	"fmt"
+	"net"
 	"net/url"
 	"reflect"
+	"sort"
+	"strconv"
 )
 
 // AdminConfig holds the administrative configuration
 type AdminConfig struct {
 	// Name is the name of the server, optional
 	Name string `json:"name,omitempty"`
-	// LogLevel is the desired log verbosity, e.g.: "stunner:TRACE,all:INFO"
+	// LogLevel is the desired log verbosity, e.g.: "stunner:TRACE,all:INFO". Scopes may be glob
+	// patterns, e.g., "all:INFO,stunner-cluster-*:TRACE"
 	LogLevel string `json:"loglevel,omitempty"`
+	// LogFormat is the format of the logs: "console" writes free-form human-readable lines and
+	// "json" writes a JSON object per line with the time, level, logger, caller and message
+	// fields (default: console)
+	LogFormat string `json:"log_format,omitempty"`
+	// LogFile is the path of the file to write the logs to, instead of the standard output
+	// (default: disabled)
+	LogFile string `json:"log_file,omitempty"`
+	// LogMaxSize is the size in megabytes the log file is rotated at, 0 disables size-based
+	// rotation (default: 100 if LogFile is set)
+	LogMaxSize int `json:"log_max_size,omitempty"`
+	// LogMaxAge is the time in hours the log file is rotated after (default: disabled)
+	LogMaxAge int `json:"log_max_age,omitempty"`
+	// LogMaxBackups is the number of rotated log files to keep, 0 keeps all (default: 5 if
+	// LogFile is set)
+	LogMaxBackups int `json:"log_max_backups,omitempty"`
+	// LogThrottleInterval is the interval in seconds the INFO, WARNING and ERROR logs are
+	// throttled over: within an interval each message is written once and repeats are summarized
+	// in a "message repeated N times" line at the end of the interval, a negative value disables
+	// throttling (default: 10)
+	LogThrottleInterval int `json:"log_throttle_interval,omitempty"`
+	// LogThrottleBurst is the number of distinct messages a logger writes per level and throttle
+	// interval, the rest are summarized in a "N messages suppressed" line (default: 100)
+	LogThrottleBurst int `json:"log_throttle_burst,omitempty"`
+	// SyslogEndpoint is the syslog endpoint to send a copy of the logs to in the RFC 5424 format:
+	// "udp://<host>:<port>", "tcp://<host>:<port>" or "unix://<path>", e.g., "unix:///dev/log"
+	// (default: disabled)
+	SyslogEndpoint string `json:"syslog_endpoint,omitempty"`
+	// SyslogFacility is the syslog facility of the logs, e.g., "daemon" or "local0" (default:
+	// daemon if SyslogEndpoint is set)
+	SyslogFacility string `json:"syslog_facility,omitempty"`
+	// AccessLog is the sink of the access log, which has a record per allocation start and stop:
+	// "stdout", "stderr" or the path of a file, rotated like the log file (default: disabled)
+	AccessLog string `json:"access_log,omitempty"`
+	// AccessLogFormat is the format of the access log records: "json" or "clf", a format modeled
+	// after the Common Log Format (default: json if AccessLog is set)
+	AccessLogFormat string `json:"access_log_format,omitempty"`
+	// AuditLog is the sink of the config audit trail, which has a record per applied config with
+	// its source, generation and changes: "stdout", "stderr" or the path of a file, which is
+	// appended to and never rotated (default: disabled)
+	AuditLog string `json:"audit_log,omitempty"`
+	// TracingEndpoint is the OTLP/HTTP traces endpoint of an OpenTelemetry collector, e.g.,
+	// "http://otel-collector:4318/v1/traces", to export the Allocate, Refresh, CreatePermission
+	// and ChannelBind transactions of the sampled client sessions to as spans (default: disabled)
+	TracingEndpoint string `json:"tracing_endpoint,omitempty"`
+	// TracingSampleRatio is the ratio of the client sessions traced, between 0 and 1 (default: 0.1
+	// if TracingEndpoint is set)
+	TracingSampleRatio float64 `json:"tracing_sample_ratio,omitempty"`
+	// MessageTrace logs the STUN/TURN messages of a sample of the transactions and of selected
+	// users and clients with their decoded attributes, unlike the TRACE log level which logs all
+	// messages (default: disabled)
+	MessageTrace *MessageTraceConfig `json:"message_trace,omitempty"`
+	// CaptureDir is the directory the packet captures started via the admin API are written to
+	// (default: the temporary directory of the system)
+	CaptureDir string `json:"capture_dir,omitempty"`
 	// MetricsEndpoint is the url to the metric server (Prometheus)
 	MetricsEndpoint string `json:"metrics_endpoint,omitempty"`
+	// APIEndpoint is the url of the admin REST API server, e.g., "http://127.0.0.1:8086"
+	// (default: disabled)
+	APIEndpoint string `json:"api_endpoint,omitempty"`
+	// APIToken is the bearer token required by the admin REST API, passed in the
+	// "Authorization: Bearer <token>" header. The status path is served without authentication,
+	// without a token the rest of the API is refused and is accessible on the admin socket only
+	// (default: no token)
+	APIToken string `json:"api_token,omitempty"`
+	// NAT64Prefix is the IPv6 prefix used to synthesize IPv4-embedded IPv6 peer addresses
+	// (RFC 6052), e.g., "64:ff9b::/96". Peers in this prefix are matched against clusters using
+	// the embedded IPv4 address and are relayed over IPv4 (default: disabled)
+	NAT64Prefix string `json:"nat64_prefix,omitempty"`
+	// RestartPolicy controls how configuration changes that require a server restart are
+	// applied: "never" refuses the change, "graceful" stops accepting new allocations and waits
+	// until the active allocations are closed or DrainTimeout expires before restarting, and
+	// "immediate" restarts at once, terminating the active allocations (default: immediate)
+	RestartPolicy string `json:"restart_policy,omitempty"`
+	// DrainTimeout is the maximum time in seconds to wait for the active allocations to be
+	// closed with the "graceful" restart policy (default: 60)
+	DrainTimeout int `json:"drain_timeout,omitempty"`
+	// EventWebhook is the URL to post the change events of the config to, e.g.,
+	// "https://controller.example.com/stunner/events" (default: disabled)
+	EventWebhook string `json:"event_webhook,omitempty"`
+	// Notifier posts operational events, like allocations created and deleted, bursts of
+	// authentication failures, listener bind failures and reconcile errors, to a webhook
+	// (default: disabled)
+	Notifier *NotifierConfig `json:"notifier,omitempty"`
+	// Watermarks sets the thresholds the relay port, file descriptor and allocation usage is
+	// checked against, so that capacity exhaustion is visible before allocations start failing
+	// (default: disabled)
+	Watermarks *WatermarkConfig `json:"watermarks,omitempty"`
+	// DNSHealth makes the gateway status reflect the health of the DNS resolution of the
+	// STRICT_DNS clusters, which deny all traffic once none of their domains resolve (default:
+	// disabled)
+	DNSHealth *DNSHealthConfig `json:"dns_health,omitempty"`
+	// LatencyProbe sends periodic probes to a sample of the endpoints of each cluster and exports
+	// the round-trip time and the loss toward the clusters as metrics (default: disabled)
+	LatencyProbe *LatencyProbeConfig `json:"latency_probe,omitempty"`
+	// Ban temporarily bans the client sources exceeding the thresholds of failed
+	// authentications, malformed packets or permission denials (default: disabled)
+	Ban *BanConfig `json:"ban,omitempty"`
+	// Quota caps the concurrent allocations and the rate of the allocation requests, gateway-wide
+	// and per client source IP address (default: unlimited)
+	Quota *QuotaConfig `json:"quota,omitempty"`
+	// Amplification limits the responses to the unauthenticated requests received on UDP
+	// listeners from client sources holding no allocation, so that the gateway cannot be abused
+	// as a UDP amplifier (default: disabled)
+	Amplification *AmplificationConfig `json:"amplification,omitempty"`
+	// Malformed counts the malformed packets received on the listeners per client source and
+	// discards the oversized messages and, optionally, the packets that are neither STUN nor
+	// TURN ChannelData messages before any STUN/TURN processing (default: disabled)
+	Malformed *MalformedConfig `json:"malformed,omitempty"`
+	// RequestRate limits the rate of the Refresh, CreatePermission and ChannelBind requests per
+	// authenticated username (default: unlimited)
+	RequestRate *RequestRateConfig `json:"request_rate,omitempty"`
+	// PeerPorts restricts the peer ports the relay transports may send to and receive from,
+	// on top of the routing policy of the clusters, so that the gateway cannot be used to reach,
+	// e.g., SSH or databases even inside the permitted endpoints (default: all ports)
+	PeerPorts *PeerPortConfig `json:"peer_ports,omitempty"`
+	// Replication shares the state of the UDP allocations with the other replicas of the
+	// gateway, so that a replica can take over the allocations of a failed replica for the
+	// clients re-sending to the same public address (default: disabled)
+	Replication *ReplicationConfig `json:"replication,omitempty"`
+	// ConsistentHashing makes the gateway aware of the consistent hashing of the clients to the
+	// replicas behind a load balancer, so that the allocations arriving at the wrong replica are
+	// counted, or redirected to the replica the client hashes to (default: disabled)
+	ConsistentHashing *ConsistentHashingConfig `json:"consistent_hashing,omitempty"`
+	// Standby pairs the gateway with a hot-standby gateway for on-prem deployments: the
+	// standby mirrors the config and, optionally, the allocation state of the active gateway,
+	// and takes over the virtual IP of the pair if the active gateway fails (default: disabled)
+	Standby *StandbyConfig `json:"standby,omitempty"`
+	// AddressMapping translates the peer addresses of the relay transports, so that the peers
+	// behind the gateway (hairpin) or reachable only via a translated address are reached at
+	// their internal address (default: disabled)
+	AddressMapping *AddressMappingConfig `json:"address_mapping,omitempty"`
+	// PolicyPlugin consults a policy plugin on the allocation and permission decisions, so that
+	// site-specific policies can allow, deny or annotate the decisions of the built-in routing
+	// policy (default: disabled)
+	PolicyPlugin *PolicyPluginConfig `json:"policy_plugin,omitempty"`
+	// SDSEndpoint is the gRPC address of the secret discovery service (SDS) serving the TLS
+	// certificates of the listeners with an SDS secret, e.g., "127.0.0.1:9091" or
+	// "unix:///var/run/stunner/sds.sock". The connection is unencrypted, so the SDS server must
+	// be reached over a unix socket or the loopback (default: disabled)
+	SDSEndpoint string `json:"sds_endpoint,omitempty"`
+	// FIPSMode restricts the TLS listeners and the auth primitives to FIPS-approved algorithms
+	// and refuses configs not compliant with the restrictions, e.g., DTLS listeners. Always on
+	// in boringcrypto builds. Changing the setting requires a restart (default: false)
+	FIPSMode bool `json:"fips_mode,omitempty"`
+	// AllowInsecureProtocols permits listeners with a plaintext protocol: UDP, TCP and WS. If
+	// false, configs with such listeners are refused, so that only TLS, DTLS and WSS listeners
+	// can be opened (default: true)
+	AllowInsecureProtocols *bool `json:"allow_insecure_protocols,omitempty"`
+	// PermissionLifetime is the lifetime in seconds of the permissions, at most 300. Clients
+	// must refresh their permissions sooner, otherwise the traffic of the expired permissions
+	// is dropped (default: 300)
+	PermissionLifetime int `json:"permission_lifetime,omitempty"`
+	// ChannelLifetime is the lifetime in seconds of the channel bindings, at most 600 (default:
+	// 600)
+	ChannelLifetime int `json:"channel_lifetime,omitempty"`
+	// MaxAllocationLifetime is the longest lifetime in seconds granted to the allocations, at
+	// most 3600: longer lifetimes requested by the clients are cut to the maximum (default:
+	// 3600)
+	MaxAllocationLifetime int `json:"max_allocation_lifetime,omitempty"`
+	// DefaultRoute controls the peers reachable via listeners with no routes: "deny" denies
+	// access to all peers and "allow" grants access to any peer (default: deny). Routes to
+	// missing clusters do not fall back to the default route
+	DefaultRoute string `json:"default_route,omitempty"`
+	// ResourceProfile sizes the buffer pools, the worker counts, the metric retention and the
+	// number of allocations of the gateway: "default" sets no ceilings, and "low-memory" caps
+	// the resource use for devices with 512 MB RAM or less (default: default)
+	ResourceProfile string `json:"resource_profile,omitempty"`
 }
 
-// Validate checks a configuration and injects defaults
-func (req *AdminConfig) Validate() error {
-	//FIXME: no validation for loglevel (we'd need to create a new logger and it's not worth)
+// Default injects the defaults into a configuration
+func (req *AdminConfig) Default() {
 	if req.LogLevel == "" {
 		req.LogLevel = DefaultLogLevel
 	}
+	if req.LogFormat == "" {
+		req.LogFormat = DefaultLogFormat
+	}
+	if req.LogFile != "" && req.LogMaxSize == 0 {
+		req.LogMaxSize = DefaultLogMaxSize
+	}
+	if req.LogFile != "" && req.LogMaxBackups == 0 {
+		req.LogMaxBackups = DefaultLogMaxBackups
+	}
+	if req.LogThrottleInterval == 0 {
+		req.LogThrottleInterval = DefaultLogThrottleInterval
+	}
+	if req.LogThrottleBurst == 0 {
+		req.LogThrottleBurst = DefaultLogThrottleBurst
+	}
+	if req.SyslogEndpoint != "" && req.SyslogFacility == "" {
+		req.SyslogFacility = DefaultSyslogFacility
+	}
+	if req.AccessLog != "" && req.AccessLogFormat == "" {
+		req.AccessLogFormat = DefaultAccessLogFormat
+	}
+	if req.TracingEndpoint != "" && req.TracingSampleRatio == 0 {
+		req.TracingSampleRatio = DefaultTracingSampleRatio
+	}
 	if req.Name == "" {
 		req.Name = DefaultStunnerName
 	}
+	if req.RestartPolicy == "" {
+		req.RestartPolicy = DefaultRestartPolicy
+	}
+	if req.DrainTimeout == 0 {
+		req.DrainTimeout = DefaultDrainTimeout
+	}
+	if req.DefaultRoute == "" {
+		req.DefaultRoute = DefaultDefaultRoute
+	}
+	if req.ResourceProfile == "" {
+		req.ResourceProfile = DefaultResourceProfile
+	}
+}
+
+// Validate checks a configuration and injects defaults
+func (req *AdminConfig) Validate() error {
+	//FIXME: no validation for loglevel (we'd need to create a new logger and it's not worth)
+	req.Default()
+
+	if err := ValidateName(req.Name); err != nil {
+		return fmt.Errorf("invalid name %q: %s", req.Name, err.Error())
+	}
+
+	switch req.LogFormat {
+	case "console", "json":
+	default:
+		return fmt.Errorf("unknown log format: %q", req.LogFormat)
+	}
+	if req.LogMaxSize < 0 || req.LogMaxAge < 0 || req.LogMaxBackups < 0 {
+		return fmt.Errorf("invalid log rotation settings: max size: %d, max age: %d, "+
+			"max backups: %d", req.LogMaxSize, req.LogMaxAge, req.LogMaxBackups)
+	}
+	if req.LogThrottleBurst < 0 {
+		return fmt.Errorf("invalid log throttle burst: %d", req.LogThrottleBurst)
+	}
+
+	switch req.AccessLogFormat {
+	case "", "json", "clf":
+	default:
+		return fmt.Errorf("unknown access log format: %q", req.AccessLogFormat)
+	}
 
 	//validate metrics endpoint
 	_, err := url.Parse(req.MetricsEndpoint)
@@ -32,9 +265,387 @@ func (req *AdminConfig) Validate() error {
 		return fmt.Errorf("%s: not a valid metric endpoint URL", req.MetricsEndpoint)
 	}
 
+	// validate admin API endpoint
+	if _, err := url.Parse(req.APIEndpoint); err != nil {
+		return fmt.Errorf("%s: not a valid admin API endpoint URL", req.APIEndpoint)
+	}
+
+	// validate event webhook
+	if req.EventWebhook != "" {
+		u, err := url.Parse(req.EventWebhook)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%s: not a valid event webhook URL", req.EventWebhook)
+		}
+	}
+
+	// validate notifier
+	if req.Notifier != nil {
+		if err := req.Notifier.Validate(); err != nil {
+			return err
+		}
+	}
+
+	// validate message trace
+	if req.MessageTrace != nil {
+		if err := req.MessageTrace.Validate(); err != nil {
+			return err
+		}
+	}
+
+	// validate watermarks
+	if req.Watermarks != nil {
+		if err := req.Watermarks.Validate(); err != nil {
+			return err
+		}
+	}
+
+	// validate DNS health
+	if req.DNSHealth != nil {
+		if err := req.DNSHealth.Validate(); err != nil {
+			return err
+		}
+	}
+
+	// validate latency probe
+	if req.LatencyProbe != nil {
+		if err := req.LatencyProbe.Validate(); err != nil {
+			return err
+		}
+	}
+
+	// validate ban
+	if req.Ban != nil {
+		if err := req.Ban.Validate(); err != nil {
+			return err
+		}
+	}
+
+	// validate quota
+	if req.Quota != nil {
+		if err := req.Quota.Validate(); err != nil {
+			return err
+		}
+	}
+
+	// validate amplification protections
+	if req.Amplification != nil {
+		if err := req.Amplification.Validate(); err != nil {
+			return err
+		}
+	}
+
+	// validate malformed traffic defenses
+	if req.Malformed != nil {
+		if err := req.Malformed.Validate(); err != nil {
+			return err
+		}
+	}
+
+	// validate request rate limits
+	if req.RequestRate != nil {
+		if err := req.RequestRate.Validate(); err != nil {
+			return err
+		}
+	}
+
+	// validate peer port restrictions
+	if req.PeerPorts != nil {
+		if err := req.PeerPorts.Validate(); err != nil {
+			return err
+		}
+	}
+
+	// validate allocation state replication: the replicated allocations are taken over with the
+	// keys of the users, so the replicas must authenticate each other
+	if req.Replication != nil {
+		if req.APIToken == "" {
+			return fmt.Errorf("allocation state replication requires an api_token")
+		}
+		if err := req.Replication.Validate(); err != nil {
+			return err
+		}
+	}
+
+	// validate consistent hashing
+	if req.ConsistentHashing != nil {
+		if err := req.ConsistentHashing.Validate(); err != nil {
+			return err
+		}
+	}
+
+	// validate the hot-standby pair: the heartbeats are authenticated with the API token
+	if req.Standby != nil {
+		if req.APIToken == "" {
+			return fmt.Errorf("hot standby requires an api_token")
+		}
+		if err := req.Standby.Validate(); err != nil {
+			return err
+		}
+	}
+
+	// validate the address-mapping table
+	if req.AddressMapping != nil {
+		if err := req.AddressMapping.Validate(); err != nil {
+			return err
+		}
+	}
+
+	// validate the policy plugin
+	if req.PolicyPlugin != nil {
+		if err := req.PolicyPlugin.Validate(); err != nil {
+			return err
+		}
+	}
+
+	// validate lifetimes
+	if err := ValidateLifetimes(req.PermissionLifetime, req.ChannelLifetime,
+		req.MaxAllocationLifetime); err != nil {
+		return err
+	}
+
+	// validate syslog
+	if req.SyslogEndpoint != "" {
+		u, err := url.Parse(req.SyslogEndpoint)
+		if err != nil || ((u.Scheme != "udp" && u.Scheme != "tcp" || u.Host == "") &&
+			(u.Scheme != "unix" || u.Path == "")) {
+			return fmt.Errorf("%s: not a valid syslog endpoint URL", req.SyslogEndpoint)
+		}
+		if !containsString(SyslogFacilities, req.SyslogFacility) {
+			return fmt.Errorf("unknown syslog facility: %q", req.SyslogFacility)
+		}
+	}
+
+	// validate tracing
+	if req.TracingEndpoint != "" {
+		u, err := url.Parse(req.TracingEndpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%s: not a valid tracing endpoint URL", req.TracingEndpoint)
+		}
+	}
+	if req.TracingSampleRatio < 0 || req.TracingSampleRatio > 1 {
+		return fmt.Errorf("invalid tracing sample ratio: %g", req.TracingSampleRatio)
+	}
+
+	policy, err := NewRestartPolicy(req.RestartPolicy)
+	if err != nil {
+		return err
+	}
+	req.RestartPolicy = policy.String()
+
+	if req.DrainTimeout < 0 {
+		return fmt.Errorf("invalid drain timeout: %d", req.DrainTimeout)
+	}
+
+	route, err := NewDefaultRoutePolicy(req.DefaultRoute)
+	if err != nil {
+		return err
+	}
+	req.DefaultRoute = route.String()
+
+	profile, err := NewResourceProfile(req.ResourceProfile)
+	if err != nil {
+		return err
+	}
+	req.ResourceProfile = profile.String()
+
+	// validate NAT64 prefix (RFC 6052 Section 2.2)
+	if req.NAT64Prefix != "" {
+		ip, n, err := net.ParseCIDR(req.NAT64Prefix)
+		if err != nil || ip.To4() != nil {
+			return fmt.Errorf("%s: not a valid IPv6 NAT64 prefix", req.NAT64Prefix)
+		}
+		switch ones, _ := n.Mask.Size(); ones {
+		case 32, 40, 48, 56, 64, 96:
+		default:
+			return fmt.Errorf("%s: invalid NAT64 prefix length, must be one of 32, 40, 48, "+
+				"56, 64 or 96", req.NAT64Prefix)
+		}
+	}
+
+	return nil
+}
+
+// SyslogFacilities are the names of the syslog facilities (RFC 5424)
+var SyslogFacilities = []string{"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "local0", "local1", "local2", "local3", "local4", "local5",
+	"local6", "local7"}
+
+// Operational events posted by the notifier
+const (
+	// NotifierEventAllocationCreated is posted when a client creates an allocation
+	NotifierEventAllocationCreated = "allocation-created"
+	// NotifierEventAllocationDeleted is posted when an allocation is deleted or expires
+	NotifierEventAllocationDeleted = "allocation-deleted"
+	// NotifierEventAuthFailures is posted when the number of failed authentications in a minute
+	// reaches the threshold
+	NotifierEventAuthFailures = "auth-failures"
+	// NotifierEventListenerFailed is posted when a listener cannot be bound
+	NotifierEventListenerFailed = "listener-failed"
+	// NotifierEventReconcileFailed is posted when a configuration cannot be applied
+	NotifierEventReconcileFailed = "reconcile-failed"
+)
+
+// NotifierEvents lists the operational events posted by the notifier
+var NotifierEvents = []string{NotifierEventAllocationCreated, NotifierEventAllocationDeleted,
+	NotifierEventAuthFailures, NotifierEventListenerFailed, NotifierEventReconcileFailed}
+
+// NotifierConfig configures the webhook operational events are posted to
+type NotifierConfig struct {
+	// URL is the webhook to post the events to as JSON, e.g., "https://alerts.example.com/stunner"
+	URL string `json:"url"`
+	// Headers are added to each request, e.g., to authenticate with the receiver
+	Headers map[string]string `json:"headers,omitempty"`
+	// Events is the list of events to post, empty means all: "allocation-created",
+	// "allocation-deleted", "auth-failures", "listener-failed" and "reconcile-failed"
+	Events []string `json:"events,omitempty"`
+	// AuthFailureThreshold is the number of failed authentications in a minute that fires an
+	// "auth-failures" event (default: 10)
+	AuthFailureThreshold int `json:"auth_failure_threshold,omitempty"`
+}
+
+// Validate checks a notifier configuration and injects defaults
+func (req *NotifierConfig) Validate() error {
+	if req.AuthFailureThreshold == 0 {
+		req.AuthFailureThreshold = DefaultAuthFailureThreshold
+	}
+
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%s: not a valid notifier URL", req.URL)
+	}
+	if req.AuthFailureThreshold < 0 {
+		return fmt.Errorf("invalid auth failure threshold: %d", req.AuthFailureThreshold)
+	}
+	for _, e := range req.Events {
+		if !containsString(NotifierEvents, e) {
+			return fmt.Errorf("unknown notifier event: %q", e)
+		}
+	}
+
 	return nil
 }
 
+// Enabled returns true if the notifier posts the given event
+func (req *NotifierConfig) Enabled(event string) bool {
+	return len(req.Events) == 0 || containsString(req.Events, event)
+}
+
+// DeepCopy returns a copy of the notifier configuration
+func (req *NotifierConfig) DeepCopy() *NotifierConfig {
+	if req == nil {
+		return nil
+	}
+	out := *req
+	if req.Headers != nil {
+		out.Headers = make(map[string]string, len(req.Headers))
+		for k, v := range req.Headers {
+			out.Headers[k] = v
+		}
+	}
+	out.Events = append([]string(nil), req.Events...)
+	return &out
+}
+
+// MessageTraceConfig selects the STUN/TURN messages logged with their decoded attributes: a message
+// is logged if its transaction is sampled, or if it is sent by or to one of the users or clients
+type MessageTraceConfig struct {
+	// SampleRatio is the ratio of the transactions logged, between 0 and 1, the request and the
+	// response of a transaction are sampled together (default: 0)
+	SampleRatio float64 `json:"sample_ratio,omitempty"`
+	// Usernames lists the users whose messages are logged
+	Usernames []string `json:"usernames,omitempty"`
+	// Clients lists the IP addresses or prefixes of the clients whose messages are logged, e.g.,
+	// "192.0.2.1" or "10.0.0.0/8"
+	Clients []string `json:"clients,omitempty"`
+}
+
+// Validate checks a message trace configuration
+func (req *MessageTraceConfig) Validate() error {
+	if req.SampleRatio < 0 || req.SampleRatio > 1 {
+		return fmt.Errorf("invalid message trace sample ratio: %g", req.SampleRatio)
+	}
+	for _, c := range req.Clients {
+		if _, _, err := net.ParseCIDR(c); err != nil && net.ParseIP(c) == nil {
+			return fmt.Errorf("invalid message trace client: %q", c)
+		}
+	}
+	return nil
+}
+
+// DeepCopy returns a copy of the message trace configuration
+func (req *MessageTraceConfig) DeepCopy() *MessageTraceConfig {
+	if req == nil {
+		return nil
+	}
+	out := *req
+	out.Usernames = append([]string(nil), req.Usernames...)
+	out.Clients = append([]string(nil), req.Clients...)
+	return &out
+}
+
+// WatermarkConfig sets the resource usage thresholds: crossing one logs a warning, sets the
+// "stunner_resource_watermark_exceeded" metric and marks the gateway degraded
+type WatermarkConfig struct {
+	// RelayPorts is the percentage of the relay port range of a listener in use by allocations
+	// (default: 80)
+	RelayPorts int `json:"relay_ports,omitempty"`
+	// FileDescriptors is the percentage of the open file limit of the daemon in use (default:
+	// 80)
+	FileDescriptors int `json:"file_descriptors,omitempty"`
+	// Allocations is the number of active allocations, 0 disables the watermark (default:
+	// disabled)
+	Allocations int `json:"allocations,omitempty"`
+	// DegradeReadiness marks the gateway not ready while a watermark is exceeded, so that load
+	// balancers steer new clients to other instances (default: false)
+	DegradeReadiness bool `json:"degrade_readiness,omitempty"`
+}
+
+// Validate checks a watermark configuration and injects defaults
+func (req *WatermarkConfig) Validate() error {
+	if req.RelayPorts == 0 {
+		req.RelayPorts = DefaultRelayPortWatermark
+	}
+	if req.FileDescriptors == 0 {
+		req.FileDescriptors = DefaultFileDescriptorWatermark
+	}
+
+	if req.RelayPorts < 0 || req.RelayPorts > 100 {
+		return fmt.Errorf("invalid relay port watermark: %d%%", req.RelayPorts)
+	}
+	if req.FileDescriptors < 0 || req.FileDescriptors > 100 {
+		return fmt.Errorf("invalid file descriptor watermark: %d%%", req.FileDescriptors)
+	}
+	if req.Allocations < 0 {
+		return fmt.Errorf("invalid allocation watermark: %d", req.Allocations)
+	}
+
+	return nil
+}
+
+// DeepCopy returns a copy of the watermark configuration
+func (req *WatermarkConfig) DeepCopy() *WatermarkConfig {
+	if req == nil {
+		return nil
+	}
+	out := *req
+	return &out
+}
+
+func containsString(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+// InsecureProtocolsAllowed returns true unless the listeners with a plaintext protocol are
+// explicitly disallowed
+func (req *AdminConfig) InsecureProtocolsAllowed() bool {
+	return req.AllowInsecureProtocols == nil || *req.AllowInsecureProtocols
+}
+
 // Name returns the name of the object to be configured
 func (req *AdminConfig) ConfigName() string {
 	// singleton!
@@ -50,3 +661,710 @@ func (req *AdminConfig) DeepEqual(other Config) bool {
 func (req *AdminConfig) String() string {
 	return fmt.Sprintf("%#v", req)
 }
+
+// DNSHealthConfig sets when the failing DNS resolution of a STRICT_DNS cluster degrades the
+// gateway: when the resolution of all the domains of the cluster has been failing for longer than
+// the threshold
+type DNSHealthConfig struct {
+	// FailureThreshold is the time in seconds the resolution of all the domains of a cluster
+	// must be failing for to degrade the gateway (default: 30)
+	FailureThreshold int `json:"failure_threshold,omitempty"`
+	// DegradeReadiness marks the gateway not ready while the resolution of a cluster is
+	// failing, so that load balancers steer new clients to other instances (default: false)
+	DegradeReadiness bool `json:"degrade_readiness,omitempty"`
+}
+
+// Validate checks a DNS health configuration and injects defaults
+func (req *DNSHealthConfig) Validate() error {
+	if req.FailureThreshold == 0 {
+		req.FailureThreshold = DefaultDNSFailureThreshold
+	}
+	if req.FailureThreshold < 0 {
+		return fmt.Errorf("invalid DNS failure threshold: %d", req.FailureThreshold)
+	}
+	return nil
+}
+
+// DeepCopy returns a copy of the DNS health configuration
+func (req *DNSHealthConfig) DeepCopy() *DNSHealthConfig {
+	if req == nil {
+		return nil
+	}
+	out := *req
+	return &out
+}
+
+// LatencyProbeConfig sets the probes sent from the gateway to a sample of the endpoints of each
+// cluster to measure the round-trip time and the loss toward the cluster
+type LatencyProbeConfig struct {
+	// Protocol is the protocol of the probes: "icmp" sends ICMP echo requests over unprivileged
+	// ICMP sockets, "udp" sends datagrams to the echo port of the endpoints and "tcp" connects to
+	// the port of the endpoints (default: icmp)
+	Protocol string `json:"protocol,omitempty"`
+	// Port is the destination port of the UDP and TCP probes (default: 7)
+	Port int `json:"port,omitempty"`
+	// Interval is the time in seconds between two rounds of probes (default: 10)
+	Interval int `json:"interval,omitempty"`
+	// Timeout is the time in milliseconds a probe is waited for before it is counted as lost
+	// (default: 1000)
+	Timeout int `json:"timeout,omitempty"`
+	// SampleSize is the number of endpoints probed per cluster (default: 3)
+	SampleSize int `json:"sample_size,omitempty"`
+}
+
+// Validate checks a latency probe configuration and injects defaults
+func (req *LatencyProbeConfig) Validate() error {
+	if req.Protocol == "" {
+		req.Protocol = DefaultLatencyProbeProtocol
+	}
+	if req.Port == 0 {
+		req.Port = DefaultLatencyProbePort
+	}
+	if req.Interval == 0 {
+		req.Interval = DefaultLatencyProbeInterval
+	}
+	if req.Timeout == 0 {
+		req.Timeout = DefaultLatencyProbeTimeout
+	}
+	if req.SampleSize == 0 {
+		req.SampleSize = DefaultLatencyProbeSampleSize
+	}
+
+	if req.Protocol != "icmp" && req.Protocol != "udp" && req.Protocol != "tcp" {
+		return fmt.Errorf("invalid latency probe protocol: %q", req.Protocol)
+	}
+	if req.Port < 0 || req.Port > 65535 {
+		return fmt.Errorf("invalid latency probe port: %d", req.Port)
+	}
+	if req.Interval < 0 {
+		return fmt.Errorf("invalid latency probe interval: %d", req.Interval)
+	}
+	if req.Timeout < 0 || req.Timeout > req.Interval*1000 {
+		return fmt.Errorf("invalid latency probe timeout: %d", req.Timeout)
+	}
+	if req.SampleSize < 0 {
+		return fmt.Errorf("invalid latency probe sample size: %d", req.SampleSize)
+	}
+	return nil
+}
+
+// DeepCopy returns a copy of the latency probe configuration
+func (req *LatencyProbeConfig) DeepCopy() *LatencyProbeConfig {
+	if req == nil {
+		return nil
+	}
+	out := *req
+	return &out
+}
+
+// BanConfig sets when a client source is banned: when the number of its failed authentications,
+// malformed packets or permission denials within the window exceeds the threshold, the packets and
+// the connections of the source are dropped by all listeners for the ban period
+type BanConfig struct {
+	// Window is the time in seconds the offenses of a source are counted in (default: 60)
+	Window int `json:"window,omitempty"`
+	// Duration is the ban period in seconds (default: 600)
+	Duration int `json:"duration,omitempty"`
+	// AuthFailures is the number of failed authentications a source is banned for, negative
+	// disables (default: 10)
+	AuthFailures int `json:"auth_failures,omitempty"`
+	// MalformedPackets is the number of packets that are neither STUN nor TURN ChannelData
+	// messages received on UDP listeners a source is banned for, negative disables (default:
+	// 100)
+	MalformedPackets int `json:"malformed_packets,omitempty"`
+	// PermissionDenials is the number of permission requests to peers not reachable via the
+	// listener a source is banned for, negative disables (default: 50)
+	PermissionDenials int `json:"permission_denials,omitempty"`
+	// ExemptCIDRs is the list of IP prefixes (or addresses) of the sources never banned
+	ExemptCIDRs []string `json:"exempt_cidrs,omitempty"`
+}
+
+// Validate checks a ban configuration and injects defaults
+func (req *BanConfig) Validate() error {
+	if req.Window == 0 {
+		req.Window = DefaultBanWindow
+	}
+	if req.Duration == 0 {
+		req.Duration = DefaultBanDuration
+	}
+	if req.AuthFailures == 0 {
+		req.AuthFailures = DefaultAuthFailureThreshold
+	}
+	if req.MalformedPackets == 0 {
+		req.MalformedPackets = DefaultBanMalformedPackets
+	}
+	if req.PermissionDenials == 0 {
+		req.PermissionDenials = DefaultBanPermissionDenials
+	}
+
+	if req.Window < 0 {
+		return fmt.Errorf("invalid ban window: %d", req.Window)
+	}
+	if req.Duration < 0 {
+		return fmt.Errorf("invalid ban duration: %d", req.Duration)
+	}
+	for _, c := range req.ExemptCIDRs {
+		if _, err := ParseSourceCIDR(c); err != nil {
+			return fmt.Errorf("invalid ban exemption: %s", err.Error())
+		}
+	}
+	sort.Strings(req.ExemptCIDRs)
+	return nil
+}
+
+// DeepCopy returns a copy of the ban configuration
+func (req *BanConfig) DeepCopy() *BanConfig {
+	if req == nil {
+		return nil
+	}
+	out := *req
+	out.ExemptCIDRs = append([]string(nil), req.ExemptCIDRs...)
+	return &out
+}
+
+// QuotaConfig caps the allocations: Allocate requests over a per-source quota are rejected with a
+// 486 (Allocation Quota Reached) error and requests over a gateway-wide quota are rejected with a
+// 508 (Insufficient Capacity) error, before authentication. Zero means no limit
+type QuotaConfig struct {
+	// MaxAllocations is the number of concurrent allocations on the gateway
+	MaxAllocations int `json:"max_allocations,omitempty"`
+	// MaxAllocationsPerSource is the number of concurrent allocations of a client source IP
+	// address
+	MaxAllocationsPerSource int `json:"max_allocations_per_source,omitempty"`
+	// AllocationRate is the number of Allocate requests per second accepted by the gateway, with
+	// bursts of up to twice the rate
+	AllocationRate int `json:"allocation_rate,omitempty"`
+	// AllocationRatePerSource is the number of Allocate requests per second accepted from a
+	// client source IP address, with bursts of up to twice the rate
+	AllocationRatePerSource int `json:"allocation_rate_per_source,omitempty"`
+}
+
+// Validate checks a quota configuration
+func (req *QuotaConfig) Validate() error {
+	if req.MaxAllocations < 0 {
+		return fmt.Errorf("invalid allocation quota: %d", req.MaxAllocations)
+	}
+	if req.MaxAllocationsPerSource < 0 {
+		return fmt.Errorf("invalid per-source allocation quota: %d", req.MaxAllocationsPerSource)
+	}
+	if req.AllocationRate < 0 {
+		return fmt.Errorf("invalid allocation rate: %d", req.AllocationRate)
+	}
+	if req.AllocationRatePerSource < 0 {
+		return fmt.Errorf("invalid per-source allocation rate: %d", req.AllocationRatePerSource)
+	}
+	return nil
+}
+
+// DeepCopy returns a copy of the quota configuration
+func (req *QuotaConfig) DeepCopy() *QuotaConfig {
+	if req == nil {
+		return nil
+	}
+	out := *req
+	return &out
+}
+
+// AmplificationConfig protects against amplification attacks: the requests answered without
+// authentication (Binding requests and the first Allocate request of a client, which is challenged
+// for credentials) received on UDP listeners from client sources holding no allocation, whose
+// address may hence be spoofed, are dropped over the response rates. Zero means no limit
+type AmplificationConfig struct {
+	// ResponseRate is the number of responses per second to unauthenticated requests sent by the
+	// gateway, with bursts of up to twice the rate
+	ResponseRate int `json:"response_rate,omitempty"`
+	// ResponseRatePerSource is the number of responses per second to unauthenticated requests
+	// sent to a client source IP address, with bursts of up to twice the rate
+	ResponseRatePerSource int `json:"response_rate_per_source,omitempty"`
+	// RequireCookie answers Binding requests only to the client sources holding an allocation,
+	// i.e., that have proven to receive the responses of the gateway by returning the nonce of
+	// an authentication challenge as a cookie. Other clients must allocate first and take their
+	// server-reflexive address from the allocation (default: false)
+	RequireCookie bool `json:"require_cookie,omitempty"`
+}
+
+// Validate checks an amplification protection configuration
+func (req *AmplificationConfig) Validate() error {
+	if req.ResponseRate < 0 {
+		return fmt.Errorf("invalid response rate: %d", req.ResponseRate)
+	}
+	if req.ResponseRatePerSource < 0 {
+		return fmt.Errorf("invalid per-source response rate: %d", req.ResponseRatePerSource)
+	}
+	return nil
+}
+
+// DeepCopy returns a copy of the amplification protection configuration
+func (req *AmplificationConfig) DeepCopy() *AmplificationConfig {
+	if req == nil {
+		return nil
+	}
+	out := *req
+	return &out
+}
+
+// MalformedConfig sets the defenses against malformed traffic. On stream listeners, only the first
+// message of a connection is checked for being a STUN or a ChannelData message, and the
+// connections sending a malformed message are closed
+type MalformedConfig struct {
+	// MaxMessageSize is the size in bytes of the largest STUN or ChannelData message accepted,
+	// zero means no limit beyond the buffers of the TURN server (default: 0)
+	MaxMessageSize int `json:"max_message_size,omitempty"`
+	// DropGarbage drops the packets that are neither STUN nor TURN ChannelData messages, or
+	// whose length does not match the packet, so that they are only counted if false (default:
+	// false)
+	DropGarbage bool `json:"drop_garbage,omitempty"`
+}
+
+// Validate checks a malformed traffic defense configuration
+func (req *MalformedConfig) Validate() error {
+	if req.MaxMessageSize < 0 || (req.MaxMessageSize > 0 && req.MaxMessageSize < 20) {
+		return fmt.Errorf("invalid max message size: %d", req.MaxMessageSize)
+	}
+	return nil
+}
+
+// DeepCopy returns a copy of the malformed traffic defense configuration
+func (req *MalformedConfig) DeepCopy() *MalformedConfig {
+	if req == nil {
+		return nil
+	}
+	out := *req
+	return &out
+}
+
+// RequestRateConfig limits the rate of the control-plane requests of the allocations, i.e., the
+// Refresh, CreatePermission and ChannelBind requests, per username the allocations were
+// authenticated with. Requests over the rate are dropped, and the clients retransmit them with a
+// backoff. Note that the clients sharing static credentials share the rate
+type RequestRateConfig struct {
+	// RatePerUser is the number of requests per second of a username, with bursts of up to
+	// twice the rate (default: 20)
+	RatePerUser int `json:"rate_per_user,omitempty"`
+}
+
+// Validate checks a request rate limit configuration and injects defaults
+func (req *RequestRateConfig) Validate() error {
+	if req.RatePerUser == 0 {
+		req.RatePerUser = DefaultRequestRatePerUser
+	}
+	if req.RatePerUser < 0 {
+		return fmt.Errorf("invalid request rate per user: %d", req.RatePerUser)
+	}
+	return nil
+}
+
+// DeepCopy returns a copy of the request rate limit configuration
+func (req *RequestRateConfig) DeepCopy() *RequestRateConfig {
+	if req == nil {
+		return nil
+	}
+	out := *req
+	return &out
+}
+
+// PeerPortConfig restricts the peer ports reachable via the relay transports. A peer port is
+// allowed if it falls into one of the allowed port ranges, or no allowed range is given, and into
+// none of the denied port ranges. Port ranges are single ports, e.g., "22", or inclusive ranges,
+// e.g., "10000-60000"
+type PeerPortConfig struct {
+	// Allow is the list of the peer port ranges allowed (default: all ports)
+	Allow []string `json:"allow,omitempty"`
+	// Deny is the list of the peer port ranges denied, taking precedence over Allow
+	Deny []string `json:"deny,omitempty"`
+}
+
+// Validate checks a peer port restriction configuration
+func (req *PeerPortConfig) Validate() error {
+	for _, r := range req.Allow {
+		if _, _, err := ParsePortRange(r); err != nil {
+			return fmt.Errorf("invalid allowed peer ports: %s", err.Error())
+		}
+	}
+	for _, r := range req.Deny {
+		if _, _, err := ParsePortRange(r); err != nil {
+			return fmt.Errorf("invalid denied peer ports: %s", err.Error())
+		}
+	}
+	sort.Strings(req.Allow)
+	sort.Strings(req.Deny)
+	return nil
+}
+
+// DeepCopy returns a copy of the peer port restriction configuration
+func (req *PeerPortConfig) DeepCopy() *PeerPortConfig {
+	if req == nil {
+		return nil
+	}
+	out := *req
+	out.Allow = append([]string(nil), req.Allow...)
+	out.Deny = append([]string(nil), req.Deny...)
+	return &out
+}
+
+// ReplicationConfig configures the replication of the allocation state between the replicas of the
+// gateway. Each replica periodically pushes the state of its UDP allocations, i.e., the client
+// address, the username, the relay port, the permissions and the channel bindings, to the admin
+// API of the other replicas, authenticated with the admin API token. If the packets of a client
+// of a failed replica reach another replica, e.g., because they share a public address, the
+// replica recreates the allocation with the same relay port before processing the packets, so
+// that the client can keep relaying without an ICE restart. The replicas must advertise the same
+// public relay address for the peers to reach the new allocation
+type ReplicationConfig struct {
+	// Peers lists the admin API URLs of the other replicas, e.g., "https://10.0.0.2:8443". The
+	// snapshots carry the bearer token of the admin API, so the peers must be reached over
+	// HTTPS, e.g., via a TLS-terminating proxy in front of the admin API
+	Peers []string `json:"peers"`
+	// Interval is the period in seconds the allocation state is pushed to the peers at
+	// (default: 5)
+	Interval int `json:"interval,omitempty"`
+}
+
+// Validate checks an allocation state replication configuration and injects defaults
+func (req *ReplicationConfig) Validate() error {
+	if req.Interval == 0 {
+		req.Interval = DefaultReplicationInterval
+	}
+	if req.Interval < 0 {
+		return fmt.Errorf("invalid replication interval: %d", req.Interval)
+	}
+	for _, p := range req.Peers {
+		u, err := url.Parse(p)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%s: not a valid replication peer URL", p)
+		}
+		if u.Scheme != "https" {
+			return fmt.Errorf("%s: replication peer URL must use https", p)
+		}
+	}
+	sort.Strings(req.Peers)
+	return nil
+}
+
+// DeepCopy returns a copy of the allocation state replication configuration
+func (req *ReplicationConfig) DeepCopy() *ReplicationConfig {
+	if req == nil {
+		return nil
+	}
+	out := *req
+	out.Peers = append([]string(nil), req.Peers...)
+	return &out
+}
+
+// ConsistentHashingConfig sets the hash ring of the replicas of the gateway. Each client is owned
+// by the replica its IP address hashes to with rendezvous hashing over the replica addresses, so
+// that adding or removing a replica only moves the clients of that replica. In "hint" mode the
+// Allocate requests of the clients owned by another replica are served and counted, and the owner
+// of a client can be queried from the admin API, e.g., to program the load balancer. In "redirect"
+// mode the authenticated Allocate requests of the clients owned by another replica are rejected
+// with a 300 (Try Alternate) error pointing to the owner in an ALTERNATE-SERVER attribute, on the
+// port of the listener the request was received on
+type ConsistentHashingConfig struct {
+	// Replicas lists the IP addresses of the replicas in the hash ring, each reachable by the
+	// clients directly on the same listener ports
+	Replicas []string `json:"replicas"`
+	// Self is the IP address of this replica, must be listed in the replicas
+	Self string `json:"self"`
+	// Mode is "hint" or "redirect" (default: hint)
+	Mode string `json:"mode,omitempty"`
+}
+
+// Validate checks a consistent hashing configuration and injects defaults
+func (req *ConsistentHashingConfig) Validate() error {
+	if req.Mode == "" {
+		req.Mode = DefaultConsistentHashingMode
+	}
+	if req.Mode != "hint" && req.Mode != "redirect" {
+		return fmt.Errorf("invalid consistent hashing mode: %q", req.Mode)
+	}
+	if len(req.Replicas) == 0 {
+		return fmt.Errorf("no replicas in the consistent hashing ring")
+	}
+	seen := map[string]bool{}
+	for _, r := range req.Replicas {
+		ip := net.ParseIP(r)
+		if ip == nil {
+			return fmt.Errorf("%s: not a valid replica IP address", r)
+		}
+		if seen[ip.String()] {
+			return fmt.Errorf("duplicate replica IP address: %s", r)
+		}
+		seen[ip.String()] = true
+	}
+	if self := net.ParseIP(req.Self); self == nil || !seen[self.String()] {
+		return fmt.Errorf("%q: self is not a replica IP address", req.Self)
+	}
+	sort.Strings(req.Replicas)
+	return nil
+}
+
+// DeepCopy returns a copy of the consistent hashing configuration
+func (req *ConsistentHashingConfig) DeepCopy() *ConsistentHashingConfig {
+	if req == nil {
+		return nil
+	}
+	out := *req
+	out.Replicas = append([]string(nil), req.Replicas...)
+	return &out
+}
+
+// StandbyConfig pairs the gateway with a hot-standby gateway. The gateways of the pair exchange
+// heartbeats over the admin API of each other, authenticated with the admin API token: the
+// heartbeats carry the state of the gateway (active or standby), whether the standby is ready to
+// take over, and, for a standby mirroring the config, the config of the active gateway whenever it
+// changes. The standby takes over the virtual IP once the heartbeats of the active gateway are
+// missing for the failover timeout, or right away when the active gateway releases the virtual IP
+// on shutdown or on request. A gateway that took over keeps the virtual IP when the other gateway
+// comes back, and if both gateways end up active, the gateway with the standby role steps down
+type StandbyConfig struct {
+	// Role is the role of the gateway in the pair: "active" or "standby"
+	Role string `json:"role"`
+	// Peer is the admin API URL of the other gateway of the pair, e.g., "http://10.0.0.2:8086"
+	Peer string `json:"peer"`
+	// VirtualIP is the virtual IP address of the pair with the prefix length, e.g.,
+	// "192.0.2.10/24", held by the active gateway (default: none, Linux only)
+	VirtualIP string `json:"virtual_ip,omitempty"`
+	// Interface is the network interface the virtual IP is added to, required with a virtual IP
+	Interface string `json:"interface,omitempty"`
+	// MirrorConfig makes the standby apply the config of the active gateway, except the name
+	// and the standby settings of the gateway (default: true)
+	MirrorConfig *bool `json:"mirror_config,omitempty"`
+	// ReplicateAllocations replicates the state of the UDP allocations to the other gateway of
+	// the pair, on top of the replicas in the replication settings (default: false)
+	ReplicateAllocations bool `json:"replicate_allocations,omitempty"`
+	// HeartbeatInterval is the period in seconds the heartbeats are sent at (default: 1)
+	HeartbeatInterval int `json:"heartbeat_interval,omitempty"`
+	// FailoverTimeout is the time in seconds without heartbeats after which the standby takes
+	// over (default: 3)
+	FailoverTimeout int `json:"failover_timeout,omitempty"`
+}
+
+// Validate checks a hot-standby configuration and injects defaults
+func (req *StandbyConfig) Validate() error {
+	if req.Role != "active" && req.Role != "standby" {
+		return fmt.Errorf("invalid standby role: %q", req.Role)
+	}
+	u, err := url.Parse(req.Peer)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%s: not a valid standby peer URL", req.Peer)
+	}
+	if req.ReplicateAllocations && u.Scheme != "https" {
+		return fmt.Errorf("%s: standby peer URL must use https to replicate the allocations",
+			req.Peer)
+	}
+	if req.VirtualIP != "" {
+		if _, _, err := net.ParseCIDR(req.VirtualIP); err != nil {
+			return fmt.Errorf("invalid virtual IP %q: %s", req.VirtualIP, err.Error())
+		}
+		if req.Interface == "" {
+			return fmt.Errorf("no interface for virtual IP %s", req.VirtualIP)
+		}
+	}
+	if req.HeartbeatInterval == 0 {
+		req.HeartbeatInterval = DefaultStandbyHeartbeatInterval
+	}
+	if req.FailoverTimeout == 0 {
+		req.FailoverTimeout = DefaultStandbyFailoverTimeout
+	}
+	if req.HeartbeatInterval < 0 {
+		return fmt.Errorf("invalid standby heartbeat interval: %d", req.HeartbeatInterval)
+	}
+	if req.FailoverTimeout <= req.HeartbeatInterval {
+		return fmt.Errorf("standby failover timeout %d must be longer than the heartbeat "+
+			"interval %d", req.FailoverTimeout, req.HeartbeatInterval)
+	}
+	return nil
+}
+
+// MirrorsConfig returns true unless the standby is set not to mirror the config of the active
+// gateway
+func (req *StandbyConfig) MirrorsConfig() bool {
+	return req.MirrorConfig == nil || *req.MirrorConfig
+}
+
+// DeepCopy returns a copy of the hot-standby configuration
+func (req *StandbyConfig) DeepCopy() *StandbyConfig {
+	if req == nil {
+		return nil
+	}
+	out := *req
+	if req.MirrorConfig != nil {
+		m := *req.MirrorConfig
+		out.MirrorConfig = &m
+	}
+	return &out
+}
+
+// AddressMappingConfig sets the address-mapping table of the relay transports. The packets the
+// clients send to the external address of a mapping are relayed to the internal address, and the
+// packets received from the internal address are relayed to the clients as coming from the
+// external address. With Hairpin set, the public address of each listener is mapped to the
+// address of the listener, so that the peers behind the gateway addressed with the public address
+// of the gateway are reached directly instead of via the NAT in front of the gateway
+type AddressMappingConfig struct {
+	// Hairpin maps the public address of each listener to the address of the listener
+	// (default: false)
+	Hairpin bool `json:"hairpin,omitempty"`
+	// Mappings lists the static address mappings
+	Mappings []AddressMapping `json:"mappings,omitempty"`
+}
+
+// AddressMapping maps an external peer address to an internal address. Addresses are IP addresses
+// with an optional port, e.g., "203.0.113.10" or "203.0.113.10:3478". The ports must be given for
+// both addresses or for neither: mappings without ports keep the peer port
+type AddressMapping struct {
+	// External is the peer address the clients use
+	External string `json:"external"`
+	// Internal is the address the peer is reached at
+	Internal string `json:"internal"`
+}
+
+// Validate checks an address-mapping configuration and sorts the mappings
+func (req *AddressMappingConfig) Validate() error {
+	seen := map[string]bool{}
+	for _, m := range req.Mappings {
+		ext, extPort, err := ParseMappedAddress(m.External)
+		if err != nil {
+			return fmt.Errorf("invalid external address in address mapping: %s", err.Error())
+		}
+		in, inPort, err := ParseMappedAddress(m.Internal)
+		if err != nil {
+			return fmt.Errorf("invalid internal address in address mapping: %s", err.Error())
+		}
+		if (extPort == 0) != (inPort == 0) {
+			return fmt.Errorf("address mapping %s -> %s: ports must be given for both "+
+				"addresses or for neither", m.External, m.Internal)
+		}
+		if (ext.To4() == nil) != (in.To4() == nil) {
+			return fmt.Errorf("address mapping %s -> %s: address families differ",
+				m.External, m.Internal)
+		}
+		key := (&net.UDPAddr{IP: ext, Port: extPort}).String()
+		if seen[key] {
+			return fmt.Errorf("duplicate external address in address mapping: %s", m.External)
+		}
+		seen[key] = true
+	}
+	sort.SliceStable(req.Mappings, func(i, j int) bool {
+		return req.Mappings[i].External < req.Mappings[j].External
+	})
+	return nil
+}
+
+// DeepCopy returns a copy of the address-mapping configuration
+func (req *AddressMappingConfig) DeepCopy() *AddressMappingConfig {
+	if req == nil {
+		return nil
+	}
+	out := *req
+	out.Mappings = append([]AddressMapping(nil), req.Mappings...)
+	return &out
+}
+
+// PolicyPluginConfig sets the policy plugin consulted on the allocation and permission decisions.
+// The plugin is served over gRPC at the address, or runs in process if the address is empty and a
+// plugin is installed with the embedding API. The plugin gets the full context of each request
+// and the decision of the built-in routing policy, and can allow or deny the request or keep the
+// built-in decision. Since the decisions are made on the packet path, a plugin failing to answer
+// within the timeout falls back to the failure policy
+type PolicyPluginConfig struct {
+	// Address is the gRPC address of the plugin, e.g., "127.0.0.1:9090" or
+	// "unix:///var/run/stunner/policy.sock" (default: the in-process plugin)
+	Address string `json:"address,omitempty"`
+	// Hooks lists the decisions the plugin is consulted on: "allocation" and "permission"
+	// (default: both)
+	Hooks []string `json:"hooks,omitempty"`
+	// Timeout is the time in milliseconds a decision of the plugin is waited for (default: 100)
+	Timeout int `json:"timeout,omitempty"`
+	// FailurePolicy is the decision if the plugin fails or times out: "builtin" keeps the
+	// decision of the built-in routing policy and "deny" refuses the request (default: builtin)
+	FailurePolicy string `json:"failure_policy,omitempty"`
+}
+
+// Validate checks a policy plugin configuration and injects defaults
+func (req *PolicyPluginConfig) Validate() error {
+	if len(req.Hooks) == 0 {
+		req.Hooks = []string{"allocation", "permission"}
+	}
+	seen := map[string]bool{}
+	for _, h := range req.Hooks {
+		if h != "allocation" && h != "permission" {
+			return fmt.Errorf("invalid policy plugin hook: %q", h)
+		}
+		if seen[h] {
+			return fmt.Errorf("duplicate policy plugin hook: %q", h)
+		}
+		seen[h] = true
+	}
+	sort.Strings(req.Hooks)
+	if req.Timeout == 0 {
+		req.Timeout = DefaultPolicyPluginTimeout
+	}
+	if req.Timeout < 0 {
+		return fmt.Errorf("invalid policy plugin timeout: %d", req.Timeout)
+	}
+	if req.FailurePolicy == "" {
+		req.FailurePolicy = DefaultPolicyPluginFailurePolicy
+	}
+	if req.FailurePolicy != "builtin" && req.FailurePolicy != "deny" {
+		return fmt.Errorf("invalid policy plugin failure policy: %q", req.FailurePolicy)
+	}
+	return nil
+}
+
+// HasHook returns true if the plugin is consulted on a kind of decisions
+func (req *PolicyPluginConfig) HasHook(hook string) bool {
+	for _, h := range req.Hooks {
+		if h == hook {
+			return true
+		}
+	}
+	return false
+}
+
+// DeepCopy returns a copy of the policy plugin configuration
+func (req *PolicyPluginConfig) DeepCopy() *PolicyPluginConfig {
+	if req == nil {
+		return nil
+	}
+	out := *req
+	out.Hooks = append([]string(nil), req.Hooks...)
+	return &out
+}
+
+// ParseMappedAddress parses an address of an address mapping: an IP address with an optional
+// port, IPv6 addresses with a port in brackets. Returns a zero port if none is given
+func ParseMappedAddress(addr string) (net.IP, int, error) {
+	if ip := net.ParseIP(addr); ip != nil {
+		return ip, 0, nil
+	}
+	host, p, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%q: not an IP address", addr)
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return nil, 0, fmt.Errorf("%q: not an IP address", addr)
+	}
+	port, err := strconv.Atoi(p)
+	if err != nil || port < 1 || port > 65535 {
+		return nil, 0, fmt.Errorf("%q: invalid port", addr)
+	}
+	return ip, port, nil
+}
+
+// ValidateLifetimes checks the permission, channel binding and maximum allocation lifetimes of the
+// gateway or a listener: lifetimes can only be set shorter than the defaults, zero means the
+// default
+func ValidateLifetimes(permission, channel, maxAllocation int) error {
+	if permission < 0 || permission > DefaultPermissionLifetime {
+		return fmt.Errorf("invalid permission lifetime: %d", permission)
+	}
+	if channel < 0 || channel > DefaultChannelLifetime {
+		return fmt.Errorf("invalid channel lifetime: %d", channel)
+	}
+	if maxAllocation < 0 || maxAllocation > DefaultMaxAllocationLifetime {
+		return fmt.Errorf("invalid max allocation lifetime: %d", maxAllocation)
+	}
+	return nil
+}
@@ -3,6 +3,7 @@ package v1alpha1
 import (
 	"fmt"
 	"reflect"
+	"sort"
 )
 
 // Auth defines the specification of the STUN/TURN authentication mechanism used by STUNner
@@ -11,45 +12,104 @@ type AuthConfig struct {
 	Type string `json:"type,omitempty"`
 	// Realm defines the STUN/TURN realm to be used for STUNner
 	Realm string `json:"realm,omitempty"`
+	// GenericRealm accepts requests under any realm: the long-term keys are derived with the
+	// REALM attribute of each request rather than with the configured realm, which is only
+	// announced in the authentication challenges, so that clients need not know the realm of
+	// the deployment (default: false)
+	GenericRealm bool `json:"generic_realm,omitempty"`
 	// Credentials specifies the authententication credentials: for "plaintext" at least the
 	// keys "username" and "password" must be set, for "longterm" the key "secret" will hold
 	// the shared authentication secret
 	Credentials map[string]string `json:"credentials"`
+	// SecretRefs maps credential keys to files holding the credential, e.g., "secret:
+	// /var/run/secrets/stunner/secret". References are resolved when loading the config file
+	SecretRefs map[string]string `json:"secret_refs,omitempty"`
+	// Tenants lists the credentials of the tenants: the clients of the listeners of a tenant are
+	// authenticated with the credentials of the tenant instead of the above ones. The realm is
+	// shared by all tenants
+	Tenants []TenantAuthConfig `json:"tenants,omitempty"`
 }
 
-// Validate checks a configuration and injects defaults
-func (req *AuthConfig) Validate() error {
+// TenantAuthConfig defines the STUN/TURN authentication of the clients of a tenant
+type TenantAuthConfig struct {
+	// Tenant is the name of the tenant
+	Tenant string `json:"tenant"`
+	// Type is the type of the STUN/TURN authentication mechanism ("plaintext" or "longterm")
+	Type string `json:"type,omitempty"`
+	// Credentials specifies the authententication credentials, the same way as for the gateway
+	Credentials map[string]string `json:"credentials"`
+}
+
+// Default injects the defaults into a configuration and sorts the tenants
+func (req *AuthConfig) Default() {
 	if req.Type == "" {
 		req.Type = DefaultAuthType
 	}
-	if _, err := NewAuthType(req.Type); err != nil {
-		return err
-	}
 	if req.Realm == "" {
 		req.Realm = DefaultRealm
 	}
+	for i := range req.Tenants {
+		if req.Tenants[i].Type == "" {
+			req.Tenants[i].Type = DefaultAuthType
+		}
+	}
+	sort.Slice(req.Tenants, func(i, j int) bool {
+		return req.Tenants[i].Tenant < req.Tenants[j].Tenant
+	})
+}
+
+// Validate checks a configuration and injects defaults
+func (req *AuthConfig) Validate() error {
+	req.Default()
+
+	if len(req.SecretRefs) > 0 {
+		return fmt.Errorf("unresolved secret references")
+	}
+
+	if err := validateCredentials(req.Type, req.Credentials); err != nil {
+		return err
+	}
+
+	seen := map[string]bool{}
+	for _, t := range req.Tenants {
+		if err := ValidateName(t.Tenant); err != nil {
+			return fmt.Errorf("invalid tenant name %q: %s", t.Tenant, err.Error())
+		}
+		if seen[t.Tenant] {
+			return fmt.Errorf("duplicate credentials for tenant %q", t.Tenant)
+		}
+		seen[t.Tenant] = true
+		if err := validateCredentials(t.Type, t.Credentials); err != nil {
+			return fmt.Errorf("tenant %q: %s", t.Tenant, err.Error())
+		}
+	}
+
+	return nil
+}
 
-	atype, err := NewAuthType(req.Type)
+// validateCredentials checks the credentials of an authentication type
+func validateCredentials(authType string, credentials map[string]string) error {
+	atype, err := NewAuthType(authType)
 	if err != nil {
 		return err
 	}
 
 	switch atype {
 	case AuthTypePlainText:
-		_, userFound := req.Credentials["username"]
-		_, passFound := req.Credentials["password"]
+		_, userFound := credentials["username"]
+		_, passFound := credentials["password"]
 		if !userFound || !passFound {
 			return fmt.Errorf("%s: empty username or password", atype.String())
 		}
 
 	case AuthTypeLongTerm:
-		_, secretFound := req.Credentials["secret"]
+		_, secretFound := credentials["secret"]
 		if !secretFound {
 			return fmt.Errorf("cannot handle auth config for type %s: invalid secret",
 				atype.String())
 		}
 	default:
-		return fmt.Errorf("invalid authentication type %q", req.Type)
+		return fmt.Errorf("invalid authentication type %q", authType)
 	}
 
 	return nil
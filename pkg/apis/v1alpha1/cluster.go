@@ -20,6 +20,14 @@ type ClusterConfig struct {
 	Type string `json:"type,omitempty"`
 	// Endpoints specifies the peers that can be reached via this cluster
 	Endpoints []string `json:"endpoints,omitempty"`
+	// AllowSensitivePeers permits relaying to the endpoints of the cluster in the sensitive
+	// address ranges: loopback, link-local, multicast and the cloud metadata services (e.g.,
+	// 169.254.169.254), which are denied by default so that a catch-all cluster cannot be used to
+	// reach the node or the cloud control plane (default: false)
+	AllowSensitivePeers bool `json:"allow_sensitive_peers,omitempty"`
+	// Tenant is the tenant the cluster belongs to: only the listeners of the same tenant can
+	// route to the cluster (default: no tenant)
+	Tenant string `json:"tenant,omitempty"`
 }
 
 // Validate checks a configuration and injects defaults
@@ -27,17 +35,31 @@ func (req *ClusterConfig) Validate() error {
 	if req.Name == "" {
 		return fmt.Errorf("missing name in cluster configuration: %s", req.String())
 	}
-	if req.Type == "" {
-		req.Type = DefaultClusterType
+	if err := ValidateName(req.Name); err != nil {
+		return fmt.Errorf("invalid cluster name %q: %s", req.Name, err.Error())
 	}
+	req.Default()
 	if _, err := NewClusterType(req.Type); err != nil {
 		return err
 	}
+	if req.Tenant != "" {
+		if err := ValidateName(req.Tenant); err != nil {
+			return fmt.Errorf("cluster %q: invalid tenant name %q: %s", req.Name, req.Tenant,
+				err.Error())
+		}
+	}
 
-	sort.Strings(req.Endpoints)
 	return nil
 }
 
+// Default injects the defaults into a configuration and sorts the endpoints
+func (req *ClusterConfig) Default() {
+	if req.Type == "" {
+		req.Type = DefaultClusterType
+	}
+	sort.Strings(req.Endpoints)
+}
+
 // Name returns the name of the object to be configured
 func (req *ClusterConfig) ConfigName() string {
 	return req.Name
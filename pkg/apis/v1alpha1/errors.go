@@ -5,4 +5,5 @@ import "errors"
 var (
 	ErrRestartRequired = errors.New("Server restart required")
 	ErrInvalidConf     = errors.New("Invalid configuration")
+	ErrRestartRefused  = errors.New("Server restart refused by the restart policy")
 )
@@ -0,0 +1,40 @@
+package v1alpha1
+
+import (
+	"strings"
+)
+
+// FIPSMinSecretLength is the minimum length of the shared secret of the "longterm" auth in FIPS
+// mode: the secret is the key of the HMAC-SHA1 generating the passwords, and HMAC keys shorter than
+// 112 bits are not approved
+const FIPSMinSecretLength = 14
+
+// ValidateFIPS checks that a configuration can be applied in FIPS mode: the "longterm" auth
+// requires a shared secret of at least FIPSMinSecretLength bytes, and DTLS listeners are refused
+// since the DTLS stack implements its own crypto, which cannot be restricted to FIPS-approved
+// algorithms. The certificates of the TLS listeners are checked by the dataplane when loaded.
+// Returns nil or a *ValidationError listing all problems found.
+func ValidateFIPS(c *StunnerConfig) error {
+	report := &ValidationError{}
+	validateFIPS(c, report)
+	if len(report.Errors) > 0 {
+		return report
+	}
+	return nil
+}
+
+func validateFIPS(c *StunnerConfig, report *ValidationError) {
+	if atype, err := NewAuthType(c.Auth.Type); err == nil && atype == AuthTypeLongTerm &&
+		len(c.Auth.Credentials["secret"]) < FIPSMinSecretLength {
+		report.add("auth", "", "FIPS mode requires a shared secret of at least %d bytes",
+			FIPSMinSecretLength)
+	}
+
+	for _, l := range c.Listeners {
+		if proto, err := NewListenerProtocol(l.Protocol); err == nil &&
+			proto == ListenerProtocolDTLS {
+			report.add("listener", l.Name, "%s listeners are not supported in FIPS mode",
+				strings.ToUpper(proto.String()))
+		}
+	}
+}
@@ -2,17 +2,22 @@ package v1alpha1
 
 import (
 	"fmt"
+	"net"
 	"reflect"
 	"sort"
+	"strconv"
+	"strings"
 )
 
 // ListenerConfig specifies a particular listener for the STUNner deamon
 type ListenerConfig struct {
 	// Name is the name of the listener
 	Name string `json:"name,omitempty"`
-	// Protocol is the transport protocol used by the listener ("UDP", "TCP", "TLS", "DTLS")
+	// Protocol is the transport protocol used by the listener ("UDP", "TCP", "TLS", "DTLS", "WS",
+	// "WSS")
 	Protocol string `json:"protocol,omitempty"`
-	// PublicAddr is the Internet-facing public IP address for the listener (ignored by STUNner)
+	// PublicAddr is the Internet-facing public IP address for the listener, used by the
+	// hairpin address mappings
 	PublicAddr string `json:"public_address,omitempty"`
 	// PublicPort is the Internet-facing public port for the listener (ignored by STUNner)
 	PublicPort int `json:"public_port,omitempty"`
@@ -26,12 +31,73 @@ type ListenerConfig struct {
 	// MaxRelayPort is the highest relay port assigned for the relay connections spawned by the
 	// listener
 	MaxRelayPort int `json:"max_relay_port,omitempty"`
-	// Cert is the TLS cert
+	// Cert is the path to the TLS cert file, e.g., on a mounted secret or cert-manager CSI
+	// volume. The file is watched and rotated certificates are served without a restart
 	Cert string `json:"cert,omitempty"`
-	// Key is the TLS key
+	// Key is the path to the TLS key file
 	Key string `json:"key,omitempty"`
+	// SDSSecret is the name of the secret holding the TLS cert/key pair at the secret discovery
+	// service (SDS) of the admin config, used instead of Cert and Key so that the certificate
+	// never appears in the config. New versions of the secret are served without a restart
+	SDSSecret string `json:"sds_secret,omitempty"`
+	// OCSPStapling makes a TLS or WSS listener staple an OCSP response for its certificate,
+	// fetched from the OCSP responder of the certificate and refreshed before it expires. The
+	// certificate file must contain the issuer certificate after the leaf
+	OCSPStapling bool `json:"ocsp_stapling,omitempty"`
+	// ClientCA is the path to the PEM bundle of the CA certificates a TLS, DTLS or WSS listener
+	// verifies client certificates against: if set, clients must present a certificate (mTLS)
+	ClientCA string `json:"client_ca,omitempty"`
+	// ClientCRL is the path to the certificate revocation list(s), PEM or DER, issued by the
+	// client CAs: client certificates revoked by the CRL are refused. The file is watched and
+	// reloaded on changes
+	ClientCRL string `json:"client_crl,omitempty"`
+	// RequireFingerprint drops the STUN messages with no valid FINGERPRINT attribute received on
+	// the listener (default: false)
+	RequireFingerprint bool `json:"require_fingerprint,omitempty"`
+	// StrictAttributes refuses the STUN messages with comprehension-required attributes unknown
+	// to the server: requests are answered with a 420 (Unknown Attribute) error, indications
+	// are dropped (default: false)
+	StrictAttributes bool `json:"strict_attributes,omitempty"`
+	// IndicationIntegrity drops the STUN indications, e.g., TURN Send indications, with no
+	// MESSAGE-INTEGRITY attribute valid for the long-term credentials of the USERNAME and REALM
+	// attributes of the indication. ChannelData messages are not affected (default: false)
+	IndicationIntegrity bool `json:"indication_integrity,omitempty"`
+	// PermissionLifetime overrides the permission lifetime of the gateway for the listener
+	// (default: the permission lifetime of the gateway)
+	PermissionLifetime int `json:"permission_lifetime,omitempty"`
+	// ChannelLifetime overrides the channel binding lifetime of the gateway for the listener
+	// (default: the channel lifetime of the gateway)
+	ChannelLifetime int `json:"channel_lifetime,omitempty"`
+	// MaxAllocationLifetime overrides the maximum allocation lifetime of the gateway for the
+	// listener (default: the maximum allocation lifetime of the gateway)
+	MaxAllocationLifetime int `json:"max_allocation_lifetime,omitempty"`
 	// Routes specifies the list of Routes allowed via a listener
 	Routes []string `json:"routes,omitempty"`
+	// Tenant is the tenant the listener belongs to: the listener can only route to the clusters
+	// of the same tenant, and its clients are authenticated with the credentials of the tenant,
+	// if any (default: no tenant)
+	Tenant string `json:"tenant,omitempty"`
+	// AllowedSourceCIDRs is the list of IP prefixes (or addresses) of the clients accepted by
+	// the listener: if set, packets and connections from other sources are dropped before any
+	// STUN/TURN processing (default: any source)
+	AllowedSourceCIDRs []string `json:"allowed_source_cidrs,omitempty"`
+	// DeniedSourceCIDRs is the list of IP prefixes (or addresses) of the clients refused by the
+	// listener, overriding AllowedSourceCIDRs
+	DeniedSourceCIDRs []string `json:"denied_source_cidrs,omitempty"`
+	// BindToDevice binds the listener and the relay sockets to a network interface (default: no
+	// binding). Not supported on every platform, see the docs for the platforms the socket
+	// options are available on
+	BindToDevice string `json:"bind_to_device,omitempty"`
+	// DSCP is the Differentiated Services Code Point set on the packets sent from the listener
+	// and the relay sockets, between 0 and 63 (default: 0, no marking)
+	DSCP int `json:"dscp,omitempty"`
+	// AlternateAddr is the alternate IP address of a UDP listener for the NAT behavior discovery
+	// of RFC 5780: the clients can request the Binding responses to be sent from the alternate
+	// address (default: none, no IP address change)
+	AlternateAddr string `json:"alternate_address,omitempty"`
+	// AlternatePort is the alternate port of a UDP listener for the NAT behavior discovery of
+	// RFC 5780, required to enable the discovery (default: 0, disabled)
+	AlternatePort int `json:"alternate_port,omitempty"`
 }
 
 // Validate checks a configuration and injects defaults
@@ -39,19 +105,149 @@ func (req *ListenerConfig) Validate() error {
 	if req.Name == "" {
 		return fmt.Errorf("missing name in listener configuration: %s", req.String())
 	}
-
-	if req.Protocol == "" {
-		req.Protocol = DefaultProtocol
+	if err := ValidateName(req.Name); err != nil {
+		return fmt.Errorf("invalid listener name %q: %s", req.Name, err.Error())
 	}
-	_, err := NewListenerProtocol(req.Protocol)
+
+	req.Default()
+	proto, err := NewListenerProtocol(req.Protocol)
 	if err != nil {
 		return err
 	}
 
+	for _, p := range []int{req.Port, req.MinRelayPort, req.MaxRelayPort} {
+		if p <= 0 || p > 65535 {
+			return fmt.Errorf("invalid port: %d", p)
+		}
+	}
+
+	if req.Tenant != "" {
+		if err := ValidateName(req.Tenant); err != nil {
+			return fmt.Errorf("listener %q: invalid tenant name %q: %s", req.Name, req.Tenant,
+				err.Error())
+		}
+	}
+
+	for _, c := range append(append([]string{}, req.AllowedSourceCIDRs...), req.DeniedSourceCIDRs...) {
+		if _, err := ParseSourceCIDR(c); err != nil {
+			return fmt.Errorf("listener %q: %s", req.Name, err.Error())
+		}
+	}
+
+	if req.DSCP < 0 || req.DSCP > 63 {
+		return fmt.Errorf("listener %q: invalid DSCP %d (must be between 0 and 63)", req.Name,
+			req.DSCP)
+	}
+
+	if err := req.validateAlternate(proto); err != nil {
+		return fmt.Errorf("listener %q: %s", req.Name, err.Error())
+	}
+
+	if req.OCSPStapling && proto != ListenerProtocolTLS && proto != ListenerProtocolWSS {
+		return fmt.Errorf("listener %q: OCSP stapling is not supported for protocol %s",
+			req.Name, proto.String())
+	}
+	if req.ClientCA != "" && proto != ListenerProtocolTLS && proto != ListenerProtocolDTLS &&
+		proto != ListenerProtocolWSS {
+		return fmt.Errorf("listener %q: client certificates are not supported for protocol %s",
+			req.Name, proto.String())
+	}
+	if req.SDSSecret != "" {
+		if proto != ListenerProtocolTLS && proto != ListenerProtocolDTLS &&
+			proto != ListenerProtocolWSS {
+			return fmt.Errorf("listener %q: SDS secrets are not supported for protocol %s",
+				req.Name, proto.String())
+		}
+		if req.Cert != "" || req.Key != "" {
+			return fmt.Errorf("listener %q: both a cert/key pair and an SDS secret are set",
+				req.Name)
+		}
+	}
+	if req.ClientCRL != "" && req.ClientCA == "" {
+		return fmt.Errorf("listener %q: client CRL requires a client CA", req.Name)
+	}
+	if err := ValidateLifetimes(req.PermissionLifetime, req.ChannelLifetime,
+		req.MaxAllocationLifetime); err != nil {
+		return fmt.Errorf("listener %q: %s", req.Name, err.Error())
+	}
+
+	return nil
+}
+
+// validateAlternate checks the alternate address of the NAT behavior discovery: the alternate
+// port is required, and the addresses of the listener must be specific IP addresses of the same
+// family, so that they can be advertised to the clients
+func (req *ListenerConfig) validateAlternate(proto ListenerProtocol) error {
+	if req.AlternateAddr == "" && req.AlternatePort == 0 {
+		return nil
+	}
+	if proto != ListenerProtocolUDP {
+		return fmt.Errorf("NAT behavior discovery is not supported for protocol %s",
+			proto.String())
+	}
+	if req.AlternatePort <= 0 || req.AlternatePort > 65535 || req.AlternatePort == req.Port {
+		return fmt.Errorf("invalid alternate port: %d", req.AlternatePort)
+	}
+	addr := net.ParseIP(req.Addr)
+	if addr == nil || addr.IsUnspecified() {
+		return fmt.Errorf("NAT behavior discovery requires a specific listener address, "+
+			"not %q", req.Addr)
+	}
+	if req.AlternateAddr != "" {
+		alt := net.ParseIP(req.AlternateAddr)
+		if alt == nil || alt.IsUnspecified() || alt.Equal(addr) ||
+			(alt.To4() == nil) != (addr.To4() == nil) {
+			return fmt.Errorf("invalid alternate address: %q", req.AlternateAddr)
+		}
+	}
+	return nil
+}
+
+// ParseSourceCIDR parses an entry of a source ACL: an IP prefix, or an IP address taken as a host
+// prefix
+func ParseSourceCIDR(c string) (*net.IPNet, error) {
+	if _, n, err := net.ParseCIDR(c); err == nil {
+		return n, nil
+	}
+	ip := net.ParseIP(c)
+	if ip == nil {
+		return nil, fmt.Errorf("invalid source CIDR %q", c)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)}, nil
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)}, nil
+}
+
+// ParsePortRange parses a port range: a single port, e.g., "22", or an inclusive range of ports,
+// e.g., "10000-60000"
+func ParsePortRange(r string) (int, int, error) {
+	lo, hi := r, r
+	if i := strings.IndexByte(r, '-'); i >= 0 {
+		lo, hi = r[:i], r[i+1:]
+	}
+	min, err := strconv.Atoi(strings.TrimSpace(lo))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid port range %q", r)
+	}
+	max, err := strconv.Atoi(strings.TrimSpace(hi))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid port range %q", r)
+	}
+	if min < 1 || max > 65535 || min > max {
+		return 0, 0, fmt.Errorf("invalid port range %q", r)
+	}
+	return min, max, nil
+}
+
+// Default injects the defaults into a configuration and sorts the routes and the source ACLs
+func (req *ListenerConfig) Default() {
+	if req.Protocol == "" {
+		req.Protocol = DefaultProtocol
+	}
 	if req.Addr == "" {
 		req.Addr = "0.0.0.0"
 	}
-
 	if req.Port == 0 {
 		req.Port = DefaultPort
 	}
@@ -61,14 +257,9 @@ func (req *ListenerConfig) Validate() error {
 	if req.MaxRelayPort == 0 {
 		req.MaxRelayPort = DefaultMaxRelayPort
 	}
-	for _, p := range []int{req.Port, req.MinRelayPort, req.MaxRelayPort} {
-		if p <= 0 || p > 65535 {
-			return fmt.Errorf("invalid port: %d", p)
-		}
-	}
-
 	sort.Strings(req.Routes)
-	return nil
+	sort.Strings(req.AllowedSourceCIDRs)
+	sort.Strings(req.DeniedSourceCIDRs)
 }
 
 // Name returns the name of the object to be configured
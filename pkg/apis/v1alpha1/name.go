@@ -0,0 +1,78 @@
+package v1alpha1
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync/atomic"
+)
+
+// NameValidator checks the name of a STUNner object, returning an error if the name is invalid
+type NameValidator func(name string) error
+
+// dns1123SubdomainRegexp matches DNS-1123 subdomains, as required for most Kubernetes resource
+// names
+var dns1123SubdomainRegexp = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?(\.[a-z0-9]([-a-z0-9]*[a-z0-9])?)*$`)
+
+// dns1123SubdomainMaxLen is the maximum length of a DNS-1123 subdomain
+const dns1123SubdomainMaxLen = 253
+
+// PermissiveNames accepts any non-empty name, e.g., the "namespace/gateway/listener" style names
+// generated by the STUNner gateway operator. This is the default.
+func PermissiveNames(name string) error {
+	if name == "" {
+		return fmt.Errorf("empty name")
+	}
+	return nil
+}
+
+// DNS1123SubdomainNames accepts only DNS-1123 subdomains: at most 253 characters, lowercase
+// alphanumerics, '-' and '.', starting and ending with an alphanumeric character. This is the
+// Kubernetes convention for object names.
+func DNS1123SubdomainNames(name string) error {
+	if err := PermissiveNames(name); err != nil {
+		return err
+	}
+	if len(name) > dns1123SubdomainMaxLen {
+		return fmt.Errorf("must be no more than %d characters", dns1123SubdomainMaxLen)
+	}
+	if !dns1123SubdomainRegexp.MatchString(name) {
+		return fmt.Errorf("must consist of lowercase alphanumeric characters, '-' or '.', and " +
+			"must start and end with an alphanumeric character")
+	}
+	return nil
+}
+
+// nameValidator holds the NameValidator in effect
+var nameValidator atomic.Value
+
+// SetNameValidator sets the validator applied to the names of the admin, listener and cluster
+// objects by all Validate() implementations, including those of the other API versions. A nil
+// validator restores the default, PermissiveNames.
+func SetNameValidator(v NameValidator) {
+	if v == nil {
+		v = PermissiveNames
+	}
+	nameValidator.Store(v)
+}
+
+// NewNameValidator returns the name validator for a validation mode: "permissive" or "dns1123"
+func NewNameValidator(mode string) (NameValidator, error) {
+	switch strings.ToLower(mode) {
+	case "permissive":
+		return PermissiveNames, nil
+	case "dns1123":
+		return DNS1123SubdomainNames, nil
+	default:
+		return nil, fmt.Errorf("unknown name validation mode: %q", mode)
+	}
+}
+
+// ValidateName checks an object name with the validator set by SetNameValidator
+func ValidateName(name string) error {
+	v, ok := nameValidator.Load().(NameValidator)
+	if !ok {
+		v = PermissiveNames
+	}
+	return v(name)
+}
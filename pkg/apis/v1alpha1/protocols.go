@@ -0,0 +1,26 @@
+package v1alpha1
+
+import (
+	"strings"
+)
+
+// ValidateInsecureProtocols checks that a configuration has no listeners with a plaintext
+// protocol, i.e., UDP, TCP or WS, as required if the AllowInsecureProtocols admin setting is false.
+// Returns nil or a *ValidationError listing all problems found.
+func ValidateInsecureProtocols(c *StunnerConfig) error {
+	report := &ValidationError{}
+	validateInsecureProtocols(c, report)
+	if len(report.Errors) > 0 {
+		return report
+	}
+	return nil
+}
+
+func validateInsecureProtocols(c *StunnerConfig, report *ValidationError) {
+	for _, l := range c.Listeners {
+		if proto, err := NewListenerProtocol(l.Protocol); err == nil && !proto.Encrypted() {
+			report.add("listener", l.Name, "insecure %s listeners are not allowed",
+				strings.ToUpper(proto.String()))
+		}
+	}
+}
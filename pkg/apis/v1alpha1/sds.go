@@ -0,0 +1,24 @@
+package v1alpha1
+
+// ValidateSecretDiscovery checks that the listeners with an SDS secret have a secret discovery
+// service to load the secret from. Returns nil or a *ValidationError listing all problems found.
+func ValidateSecretDiscovery(c *StunnerConfig) error {
+	report := &ValidationError{}
+	validateSecretDiscovery(c, report)
+	if len(report.Errors) > 0 {
+		return report
+	}
+	return nil
+}
+
+func validateSecretDiscovery(c *StunnerConfig, report *ValidationError) {
+	if c.Admin.SDSEndpoint != "" {
+		return
+	}
+	for _, l := range c.Listeners {
+		if l.SDSSecret != "" {
+			report.add("listener", l.Name, "SDS secret %q requires an SDS endpoint",
+				l.SDSSecret)
+		}
+	}
+}
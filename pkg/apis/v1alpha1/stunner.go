@@ -9,6 +9,11 @@ import (
 type StunnerConfig struct {
 	// ApiVersion is the version of the STUNner API implemented
 	ApiVersion string `json:"version"`
+	// Generation is the version of the configuration as set by the control plane. STUNner does
+	// not interpret the generation beyond reporting the generation of the running config in the
+	// logs, the metrics and the admin API, so that controllers can check whether a specific
+	// config version has been applied (default: 0, untracked)
+	Generation int64 `json:"generation,omitempty"`
 	// AdminConfig holds the administrative configuration
 	Admin AdminConfig `json:"admin,omitempty"`
 	// Auth defines the specification of the STUN/TURN authentication mechanism used by STUNner
@@ -27,6 +32,10 @@ func (req *StunnerConfig) Validate() error {
 		return fmt.Errorf("unsupported API version: %s", req.ApiVersion)
 	}
 
+	if req.Generation < 0 {
+		return fmt.Errorf("invalid config generation: %d", req.Generation)
+	}
+
 	// validate admin
 	if err := req.Admin.Validate(); err != nil {
 		return err
@@ -62,7 +71,30 @@ func (req *StunnerConfig) Validate() error {
 		return req.Clusters[i].Name < req.Clusters[j].Name
 	})
 
-	return nil
+	// tenant isolation: listeners can only route to the clusters of their own tenant
+	return ValidateTenants(req)
+}
+
+// Default injects the defaults into all objects of a configuration and sorts the listeners and
+// the clusters by name. Defaulting is idempotent and never fails, so it is safe to call on
+// invalid configurations, e.g., from an admission webhook before validation
+func (req *StunnerConfig) Default() {
+	req.Admin.Default()
+	req.Auth.Default()
+
+	for i := range req.Listeners {
+		req.Listeners[i].Default()
+	}
+	sort.Slice(req.Listeners, func(i, j int) bool {
+		return req.Listeners[i].Name < req.Listeners[j].Name
+	})
+
+	for i := range req.Clusters {
+		req.Clusters[i].Default()
+	}
+	sort.Slice(req.Clusters, func(i, j int) bool {
+		return req.Clusters[i].Name < req.Clusters[j].Name
+	})
 }
 
 // Name returns the name of the object to be configured
@@ -77,7 +109,7 @@ func (req *StunnerConfig) DeepEqual(conf Config) bool {
 		return false
 	}
 
-	if req.ApiVersion != other.ApiVersion {
+	if req.ApiVersion != other.ApiVersion || req.Generation != other.Generation {
 		return false
 	}
 	if !req.Admin.DeepEqual(&other.Admin) {
@@ -0,0 +1,128 @@
+package v1alpha1
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStunnerConfigDefault(t *testing.T) {
+	c := testConfig()
+	c.Default()
+	assert.Equal(t, DefaultStunnerName, c.Admin.Name, "admin name")
+	assert.Equal(t, DefaultLogLevel, c.Admin.LogLevel, "loglevel")
+	assert.Equal(t, DefaultAuthType, c.Auth.Type, "auth type")
+	assert.Equal(t, DefaultRealm, c.Auth.Realm, "realm")
+	assert.Equal(t, ListenerConfig{Name: "a", Protocol: "tcp", Addr: "0.0.0.0", Port: 1234,
+		MinRelayPort: DefaultMinRelayPort, MaxRelayPort: DefaultMaxRelayPort}, c.Listeners[0],
+		"listener a")
+	assert.Equal(t, ListenerConfig{Name: "b", Protocol: DefaultProtocol, Addr: "0.0.0.0",
+		Port: DefaultPort, MinRelayPort: DefaultMinRelayPort, MaxRelayPort: DefaultMaxRelayPort,
+		Routes: []string{"x", "y"}}, c.Listeners[1], "listener b")
+	assert.Equal(t, ClusterConfig{Name: "x", Type: DefaultClusterType,
+		Endpoints: []string{"1.1.1.1", "2.2.2.2"}}, c.Clusters[0], "cluster")
+
+	// defaulting is idempotent and validation injects the same defaults
+	d := testConfig()
+	d.Default()
+	d.Default()
+	assert.True(t, c.DeepEqual(&d), "idempotent")
+	assert.NoError(t, d.Validate(), "validate")
+	assert.True(t, c.DeepEqual(&d), "validation adds no more defaults")
+
+	// cross-reference validation catches the route to the nonexistent cluster
+	err := ValidateConfig(&d)
+	assert.Error(t, err, "cross-reference validation")
+	verr, ok := err.(*ValidationError)
+	assert.True(t, ok, "validation error")
+	assert.Len(t, verr.Errors, 1, "validation errors")
+}
+
+func testConfig() StunnerConfig {
+	return StunnerConfig{
+		ApiVersion: ApiVersion,
+		Auth:       AuthConfig{Credentials: map[string]string{"username": "user", "password": "pass"}},
+		Listeners: []ListenerConfig{
+			{Name: "b", Routes: []string{"y", "x"}},
+			{Name: "a", Protocol: "tcp", Port: 1234},
+		},
+		Clusters: []ClusterConfig{{Name: "x", Endpoints: []string{"2.2.2.2", "1.1.1.1"}}},
+	}
+}
+
+func TestStunnerConfigNameValidation(t *testing.T) {
+	t.Cleanup(func() { SetNameValidator(nil) })
+
+	config := func(admin, listener, cluster string) StunnerConfig {
+		c := testConfig()
+		c.Admin.Name = admin
+		c.Listeners[0].Name = listener
+		c.Clusters[0].Name = cluster
+		c.Listeners[0].Routes = []string{cluster}
+		return c
+	}
+
+	// permissive by default
+	c := config("", "default/gateway/udp-listener", "Media Servers")
+	assert.NoError(t, c.Validate(), "permissive names")
+
+	v, err := NewNameValidator("dns1123")
+	assert.NoError(t, err, "dns1123 mode")
+	SetNameValidator(v)
+	c = config("", "default/gateway/udp-listener", "media-servers")
+	assert.Error(t, c.Validate(), "invalid DNS-1123 listener name")
+	c = config("", "gateway.default.udp-listener", "Media Servers")
+	assert.Error(t, c.Validate(), "invalid DNS-1123 cluster name")
+	c = config("-stunnerd", "gateway.default.udp-listener", "media-servers")
+	assert.Error(t, c.Validate(), "invalid DNS-1123 admin name")
+	c = config("", "gateway.default.udp-listener", "media-servers")
+	assert.NoError(t, c.Validate(), "DNS-1123 names")
+
+	for _, name := range []string{"a", "a.b-c", "0abc"} {
+		assert.NoError(t, DNS1123SubdomainNames(name), "valid name %q", name)
+	}
+	for _, name := range []string{"", "A", "a/b", "a.", "-a", "a_b", strings.Repeat("a", 254)} {
+		assert.Error(t, DNS1123SubdomainNames(name), "invalid name %q", name)
+	}
+
+	_, err = NewNameValidator("strict")
+	assert.Error(t, err, "unknown mode")
+}
+
+func TestStunnerConfigTenants(t *testing.T) {
+	config := func(listenerTenant, clusterTenant string) StunnerConfig {
+		c := testConfig()
+		c.Listeners[0].Routes = []string{"x"}
+		c.Listeners[0].Tenant = listenerTenant
+		c.Clusters[0].Tenant = clusterTenant
+		return c
+	}
+
+	c := config("team-a", "team-a")
+	assert.NoError(t, c.Validate(), "same tenant")
+	c = config("", "")
+	assert.NoError(t, c.Validate(), "no tenant")
+	c = config("team-a", "team-b")
+	assert.EqualError(t, c.Validate(), `invalid configuration: listener "b": listener of `+
+		`tenant "team-a" cannot route to cluster "x" of tenant "team-b"`, "other tenant")
+	c = config("", "team-b")
+	assert.Error(t, c.Validate(), "shared listener routing to tenant cluster")
+	c = config("team-a", "")
+	assert.Error(t, c.Validate(), "tenant listener routing to shared cluster")
+
+	// tenant credentials
+	c = config("team-a", "team-a")
+	c.Auth.Tenants = []TenantAuthConfig{
+		{Tenant: "team-b", Type: "longterm", Credentials: map[string]string{"secret": "s"}},
+		{Tenant: "team-a", Credentials: map[string]string{"username": "a", "password": "p"}},
+	}
+	assert.NoError(t, c.Validate(), "tenant credentials")
+	assert.Equal(t, "team-a", c.Auth.Tenants[0].Tenant, "sorted")
+	assert.Equal(t, DefaultAuthType, c.Auth.Tenants[0].Type, "default type")
+
+	c.Auth.Tenants[1].Credentials = map[string]string{}
+	assert.Error(t, c.Validate(), "missing tenant secret")
+	c.Auth.Tenants[1] = c.Auth.Tenants[0]
+	assert.Error(t, c.Validate(), "duplicate tenant")
+}
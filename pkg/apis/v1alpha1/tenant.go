@@ -0,0 +1,28 @@
+package v1alpha1
+
+// ValidateTenants checks the isolation of the tenants: the listeners of a tenant can route only to
+// the clusters of the same tenant, and the listeners of no tenant only to the clusters of no
+// tenant. Returns nil or a *ValidationError listing all problems found.
+func ValidateTenants(c *StunnerConfig) error {
+	report := &ValidationError{}
+	validateTenants(c, report)
+	if len(report.Errors) > 0 {
+		return report
+	}
+	return nil
+}
+
+func validateTenants(c *StunnerConfig, report *ValidationError) {
+	tenants := map[string]string{}
+	for _, cl := range c.Clusters {
+		tenants[cl.Name] = cl.Tenant
+	}
+	for _, l := range c.Listeners {
+		for _, r := range l.Routes {
+			if t, ok := tenants[r]; ok && t != l.Tenant {
+				report.add("listener", l.Name, "listener of tenant %q cannot route to "+
+					"cluster %q of tenant %q", l.Tenant, r, t)
+			}
+		}
+	}
+}
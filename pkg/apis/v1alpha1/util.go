@@ -51,6 +51,8 @@ const (
 	ListenerProtocolTCP
 	ListenerProtocolTLS
 	ListenerProtocolDTLS
+	ListenerProtocolWS
+	ListenerProtocolWSS
 	ListenerProtocolUnknown
 )
 
@@ -59,6 +61,8 @@ const (
 	listenerProtocolTCPStr  = "tcp"
 	listenerProtocolTLSStr  = "tls"
 	listenerProtocolDTLSStr = "dtls"
+	listenerProtocolWSStr   = "ws"
+	listenerProtocolWSSStr  = "wss"
 )
 
 // NewListenerProtocol parses the protocol specification
@@ -72,6 +76,10 @@ func NewListenerProtocol(raw string) (ListenerProtocol, error) {
 		return ListenerProtocolTLS, nil
 	case listenerProtocolDTLSStr:
 		return ListenerProtocolDTLS, nil
+	case listenerProtocolWSStr:
+		return ListenerProtocolWS, nil
+	case listenerProtocolWSSStr:
+		return ListenerProtocolWSS, nil
 	default:
 		return ListenerProtocol(ListenerProtocolUnknown),
 			fmt.Errorf("unknown listener protocol: \"%s\"", raw)
@@ -89,11 +97,20 @@ func (l ListenerProtocol) String() string {
 		return listenerProtocolTLSStr
 	case ListenerProtocolDTLS:
 		return listenerProtocolDTLSStr
+	case ListenerProtocolWS:
+		return listenerProtocolWSStr
+	case ListenerProtocolWSS:
+		return listenerProtocolWSSStr
 	default:
 		return "<unknown>"
 	}
 }
 
+// Encrypted returns true if a listener protocol encrypts the client traffic
+func (l ListenerProtocol) Encrypted() bool {
+	return l == ListenerProtocolTLS || l == ListenerProtocolDTLS || l == ListenerProtocolWSS
+}
+
 // ClusterType specifies the cluster address resolution policy
 type ClusterType int
 
@@ -129,3 +146,123 @@ func (l ClusterType) String() string {
 		return "<unknown>"
 	}
 }
+
+// RestartPolicy specifies how to apply configuration changes that require a server restart
+type RestartPolicy int
+
+const (
+	RestartPolicyNever RestartPolicy = iota + 1
+	RestartPolicyGraceful
+	RestartPolicyImmediate
+	RestartPolicyUnknown
+)
+
+const (
+	restartPolicyNeverStr     = "never"
+	restartPolicyGracefulStr  = "graceful"
+	restartPolicyImmediateStr = "immediate"
+)
+
+// NewRestartPolicy parses the restart policy specification
+func NewRestartPolicy(raw string) (RestartPolicy, error) {
+	switch strings.ToLower(raw) {
+	case restartPolicyNeverStr:
+		return RestartPolicyNever, nil
+	case restartPolicyGracefulStr:
+		return RestartPolicyGraceful, nil
+	case restartPolicyImmediateStr:
+		return RestartPolicyImmediate, nil
+	default:
+		return RestartPolicyUnknown, fmt.Errorf("unknown restart policy: \"%s\"", raw)
+	}
+}
+
+// String returns a string representation for the restart policy
+func (p RestartPolicy) String() string {
+	switch p {
+	case RestartPolicyNever:
+		return restartPolicyNeverStr
+	case RestartPolicyGraceful:
+		return restartPolicyGracefulStr
+	case RestartPolicyImmediate:
+		return restartPolicyImmediateStr
+	default:
+		return "<unknown>"
+	}
+}
+
+// ResourceProfile specifies the resource ceilings of the gateway
+type ResourceProfile int
+
+const (
+	ResourceProfileDefault ResourceProfile = iota + 1
+	ResourceProfileLowMemory
+	ResourceProfileUnknown
+)
+
+const (
+	resourceProfileDefaultStr   = "default"
+	resourceProfileLowMemoryStr = "low-memory"
+)
+
+// NewResourceProfile parses the resource profile specification
+func NewResourceProfile(raw string) (ResourceProfile, error) {
+	switch strings.ToLower(raw) {
+	case resourceProfileDefaultStr:
+		return ResourceProfileDefault, nil
+	case resourceProfileLowMemoryStr:
+		return ResourceProfileLowMemory, nil
+	default:
+		return ResourceProfileUnknown, fmt.Errorf("unknown resource profile: \"%s\"", raw)
+	}
+}
+
+// String returns a string representation for the resource profile
+func (p ResourceProfile) String() string {
+	switch p {
+	case ResourceProfileDefault:
+		return resourceProfileDefaultStr
+	case ResourceProfileLowMemory:
+		return resourceProfileLowMemoryStr
+	default:
+		return "<unknown>"
+	}
+}
+
+// DefaultRoutePolicy specifies the peers reachable via listeners with no routes
+type DefaultRoutePolicy int
+
+const (
+	DefaultRouteDeny DefaultRoutePolicy = iota + 1
+	DefaultRouteAllow
+	DefaultRouteUnknown
+)
+
+const (
+	defaultRouteDenyStr  = "deny"
+	defaultRouteAllowStr = "allow"
+)
+
+// NewDefaultRoutePolicy parses the default route policy specification
+func NewDefaultRoutePolicy(raw string) (DefaultRoutePolicy, error) {
+	switch strings.ToLower(raw) {
+	case defaultRouteDenyStr:
+		return DefaultRouteDeny, nil
+	case defaultRouteAllowStr:
+		return DefaultRouteAllow, nil
+	default:
+		return DefaultRouteUnknown, fmt.Errorf("unknown default route policy: \"%s\"", raw)
+	}
+}
+
+// String returns a string representation for the default route policy
+func (p DefaultRoutePolicy) String() string {
+	switch p {
+	case DefaultRouteDeny:
+		return defaultRouteDenyStr
+	case DefaultRouteAllow:
+		return defaultRouteAllowStr
+	default:
+		return "<unknown>"
+	}
+}
@@ -0,0 +1,169 @@
+package v1alpha1
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+)
+
+// ConfigError describes a single problem found in a configuration
+type ConfigError struct {
+	// Kind is the kind of the offending object: "config", "admin", "auth", "listener" or
+	// "cluster"
+	Kind string `json:"kind"`
+	// Name is the name of the offending object, empty for singletons
+	Name string `json:"name,omitempty"`
+	// Message describes the problem
+	Message string `json:"message"`
+}
+
+// String stringifies the error
+func (e ConfigError) String() string {
+	if e.Name == "" {
+		return fmt.Sprintf("%s: %s", e.Kind, e.Message)
+	}
+	return fmt.Sprintf("%s %q: %s", e.Kind, e.Name, e.Message)
+}
+
+// ValidationError is a report of all the problems found in a configuration
+type ValidationError struct {
+	Errors []ConfigError `json:"errors"`
+}
+
+// Error returns the report as a single-line string
+func (e *ValidationError) Error() string {
+	errs := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		errs[i] = err.String()
+	}
+	return fmt.Sprintf("invalid configuration: %s", strings.Join(errs, "; "))
+}
+
+func (e *ValidationError) add(kind, name, format string, args ...interface{}) {
+	e.Errors = append(e.Errors, ConfigError{Kind: kind, Name: name,
+		Message: fmt.Sprintf(format, args...)})
+}
+
+// dnsNameRegexp matches DNS domain names (RFC 1123)
+var dnsNameRegexp = regexp.MustCompile(`^([a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)(\.[a-zA-Z0-9]([a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*\.?$`)
+
+// ValidateConfig checks a configuration. Beyond validating each object, it runs the
+// cross-reference checks that otherwise surface only when the dataplane applies the configuration:
+// unique object names and listener addresses, routes to existing clusters, TLS credentials for
+// encrypted listeners, endpoints that can be parsed (STATIC clusters) or resolved (STRICT_DNS
+// clusters), compliance with the FIPS mode if set (see ValidateFIPS) and the absence of plaintext
+// listeners if disallowed (see ValidateInsecureProtocols) and the isolation of the tenants (see
+// ValidateTenants). Returns nil or a
+// *ValidationError listing all problems found. Like Validate, it injects defaults into the
+// configuration.
+func ValidateConfig(c *StunnerConfig) error {
+	report := &ValidationError{}
+
+	if c.ApiVersion != ApiVersion {
+		report.add("config", "", "unsupported API version: %q", c.ApiVersion)
+	}
+
+	if err := c.Admin.Validate(); err != nil {
+		report.add("admin", "", "%s", err.Error())
+	}
+
+	if err := c.Auth.Validate(); err != nil {
+		report.add("auth", "", "%s", err.Error())
+	}
+
+	clusters := map[string]bool{}
+	for i := range c.Clusters {
+		cl := &c.Clusters[i]
+		if clusters[cl.Name] {
+			report.add("cluster", cl.Name, "duplicate cluster name")
+		}
+		clusters[cl.Name] = true
+
+		if err := cl.Validate(); err != nil {
+			report.add("cluster", cl.Name, "%s", err.Error())
+			continue
+		}
+
+		t, _ := NewClusterType(cl.Type)
+		for _, e := range cl.Endpoints {
+			switch t {
+			case ClusterTypeStatic:
+				if _, _, err := net.ParseCIDR(e); err != nil && net.ParseIP(e) == nil {
+					report.add("cluster", cl.Name, "invalid STATIC endpoint %q: not an "+
+						"IP address or subnet", e)
+				}
+			case ClusterTypeStrictDNS:
+				if net.ParseIP(e) != nil || !dnsNameRegexp.MatchString(e) {
+					report.add("cluster", cl.Name, "invalid STRICT_DNS endpoint %q: "+
+						"not a DNS domain name", e)
+				}
+			}
+		}
+	}
+
+	listeners, addrs := map[string]bool{}, map[string]string{}
+	for i := range c.Listeners {
+		l := &c.Listeners[i]
+		if err := l.Validate(); err != nil {
+			report.add("listener", l.Name, "%s", err.Error())
+			continue
+		}
+		if listeners[l.Name] {
+			report.add("listener", l.Name, "duplicate listener name")
+		}
+		listeners[l.Name] = true
+
+		if net.ParseIP(l.Addr) == nil {
+			report.add("listener", l.Name, "invalid address %q", l.Addr)
+		}
+
+		proto, _ := NewListenerProtocol(l.Protocol)
+		transport := "tcp"
+		if proto == ListenerProtocolUDP || proto == ListenerProtocolDTLS {
+			transport = "udp"
+		}
+		addr := fmt.Sprintf("%s/%s", transport, net.JoinHostPort(l.Addr, fmt.Sprint(l.Port)))
+		if other, ok := addrs[addr]; ok {
+			report.add("listener", l.Name, "address %s already used by listener %q", addr,
+				other)
+		}
+		addrs[addr] = l.Name
+
+		if l.MinRelayPort > l.MaxRelayPort {
+			report.add("listener", l.Name, "empty relay port range: %d-%d", l.MinRelayPort,
+				l.MaxRelayPort)
+		}
+
+		switch proto {
+		case ListenerProtocolTLS, ListenerProtocolDTLS,
+			ListenerProtocolWSS:
+			if l.SDSSecret == "" && (l.Cert == "" || l.Key == "") {
+				report.add("listener", l.Name, "%s listener requires a cert/key pair or "+
+					"an SDS secret", strings.ToUpper(proto.String()))
+			}
+		}
+
+		for _, r := range l.Routes {
+			if !clusters[r] {
+				report.add("listener", l.Name, "route to unknown cluster %q", r)
+			}
+		}
+	}
+
+	if c.Admin.FIPSMode {
+		validateFIPS(c, report)
+	}
+
+	if !c.Admin.InsecureProtocolsAllowed() {
+		validateInsecureProtocols(c, report)
+	}
+
+	validateTenants(c, report)
+	validateSecretDiscovery(c, report)
+
+	if len(report.Errors) > 0 {
+		return report
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+// Package auth generates the TURN credentials for the clients of a STUNner gateway from the auth
+// config of the gateway, so that application backends need not re-implement the credential
+// schemes. In the "plaintext" mode the clients use the static username and password of the
+// config. In the "longterm" mode the credentials are time-limited: the username is the expiry as
+// a UNIX timestamp and the password is the base64-encoded HMAC-SHA1 of the username keyed with
+// the shared secret of the config. The package also generates the iceServers snippets of the
+// WebRTC RTCConfiguration for the listeners of the gateway.
+//
+// The credentials are generated from a resolved config, i.e., with the secret references and the
+// encrypted credentials already resolved, like the configs returned by stunner.LoadConfig.
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha1" //nolint:gosec,gci
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/l7mp/stunner/pkg/apis/v1alpha1"
+)
+
+// DefaultTTL is the default lifetime of the time-limited credentials
+const DefaultTTL = 24 * time.Hour
+
+// Credentials are the TURN credentials of a client
+type Credentials struct {
+	// Username is the TURN username
+	Username string `json:"username"`
+	// Password is the TURN password
+	Password string `json:"password"`
+	// Expires is the expiry of time-limited credentials as a UNIX timestamp, zero for static
+	// credentials
+	Expires int64 `json:"expires,omitempty"`
+}
+
+// LongTermPassword returns the password of a time-limited username under a shared secret
+func LongTermPassword(secret, username string) string {
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write([]byte(username)) //nolint:errcheck
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// LongTermCredentials returns the time-limited credentials expiring at the given time under a
+// shared secret
+func LongTermCredentials(secret string, expiry time.Time) Credentials {
+	username := strconv.FormatInt(expiry.Unix(), 10)
+	return Credentials{
+		Username: username,
+		Password: LongTermPassword(secret, username),
+		Expires:  expiry.Unix(),
+	}
+}
+
+// StaticCredentials returns the static credentials of the plaintext mode
+func StaticCredentials(username, password string) Credentials {
+	return Credentials{Username: username, Password: password}
+}
+
+// Generate returns the credentials for the clients of a tenant under an auth config, the clients
+// of the listeners with no tenant set if the tenant is empty. Time-limited credentials expire
+// after the ttl, or after DefaultTTL if the ttl is not positive
+func Generate(conf *v1alpha1.AuthConfig, tenant string, ttl time.Duration) (Credentials, error) {
+	atype, creds := conf.Type, conf.Credentials
+	if tenant != "" {
+		found := false
+		for _, t := range conf.Tenants {
+			if t.Tenant == tenant {
+				atype, creds, found = t.Type, t.Credentials, true
+				break
+			}
+		}
+		if !found {
+			return Credentials{}, fmt.Errorf("no credentials for tenant %q", tenant)
+		}
+	}
+	if atype == "" {
+		atype = v1alpha1.DefaultAuthType
+	}
+
+	t, err := v1alpha1.NewAuthType(atype)
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	switch t {
+	case v1alpha1.AuthTypePlainText:
+		username, ok := creds["username"]
+		if !ok {
+			return Credentials{}, fmt.Errorf("cannot find username for %s authentication", atype)
+		}
+		password, ok := creds["password"]
+		if !ok {
+			return Credentials{}, fmt.Errorf("cannot find password for %s authentication", atype)
+		}
+		return StaticCredentials(username, password), nil
+
+	case v1alpha1.AuthTypeLongTerm:
+		secret, ok := creds["secret"]
+		if !ok {
+			return Credentials{}, fmt.Errorf("cannot find shared secret for %s authentication",
+				atype)
+		}
+		if ttl <= 0 {
+			ttl = DefaultTTL
+		}
+		return LongTermCredentials(secret, time.Now().Add(ttl)), nil
+
+	default:
+		return Credentials{}, fmt.Errorf("unknown authentication type %q", atype)
+	}
+}
@@ -0,0 +1,132 @@
+package auth_test
+
+import (
+	"encoding/json"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/pion/turn/v2"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/l7mp/stunner"
+	"github.com/l7mp/stunner/pkg/apis/v1alpha1"
+	"github.com/l7mp/stunner/pkg/auth"
+)
+
+func TestLongTermCredentials(t *testing.T) {
+	expiry := time.Unix(1700000000, 0)
+	c := auth.LongTermCredentials("my-secret", expiry)
+	assert.Equal(t, "1700000000", c.Username, "username")
+	assert.Equal(t, int64(1700000000), c.Expires, "expires")
+	assert.Equal(t, auth.LongTermPassword("my-secret", "1700000000"), c.Password, "password")
+
+	// the scheme of pion/turn
+	u, p, err := turn.GenerateLongTermCredentials("my-secret", time.Hour)
+	assert.NoError(t, err, "pion credentials")
+	assert.Equal(t, p, auth.LongTermPassword("my-secret", u), "compatible with pion/turn")
+}
+
+func TestGenerate(t *testing.T) {
+	conf := &v1alpha1.AuthConfig{
+		Credentials: map[string]string{"username": "user1", "password": "passwd1"},
+		Tenants: []v1alpha1.TenantAuthConfig{{
+			Tenant:      "team-a",
+			Type:        "longterm",
+			Credentials: map[string]string{"secret": "team-a-secret"},
+		}},
+	}
+
+	c, err := auth.Generate(conf, "", 0)
+	assert.NoError(t, err, "plaintext")
+	assert.Equal(t, auth.StaticCredentials("user1", "passwd1"), c, "static credentials")
+
+	c, err = auth.Generate(conf, "team-a", time.Hour)
+	assert.NoError(t, err, "longterm")
+	expiry, err := strconv.ParseInt(c.Username, 10, 64)
+	assert.NoError(t, err, "timestamp username")
+	assert.InDelta(t, time.Now().Add(time.Hour).Unix(), expiry, 5, "ttl")
+	assert.Equal(t, auth.LongTermPassword("team-a-secret", c.Username), c.Password, "password")
+
+	_, err = auth.Generate(conf, "team-b", 0)
+	assert.Error(t, err, "unknown tenant")
+	_, err = auth.Generate(&v1alpha1.AuthConfig{Type: "longterm"}, "", 0)
+	assert.Error(t, err, "no secret")
+	_, err = auth.Generate(&v1alpha1.AuthConfig{Type: "oauth"}, "", 0)
+	assert.Error(t, err, "unknown type")
+}
+
+// the credentials must be accepted by the gateway
+func TestGatewayCompatibility(t *testing.T) {
+	for _, conf := range []v1alpha1.AuthConfig{
+		{Type: "plaintext", Realm: "stunner.l7mp.io",
+			Credentials: map[string]string{"username": "user1", "password": "passwd1"}},
+		{Type: "longterm", Realm: "stunner.l7mp.io",
+			Credentials: map[string]string{"secret": "my-secret"}},
+	} {
+		s := stunner.NewStunner().WithOptions(stunner.Options{DryRun: true})
+		err := s.Reconcile(v1alpha1.StunnerConfig{
+			ApiVersion: v1alpha1.ApiVersion,
+			Auth:       conf,
+			Listeners:  []v1alpha1.ListenerConfig{{Name: "udp", Addr: "127.0.0.1"}},
+		})
+		assert.ErrorIs(t, err, v1alpha1.ErrRestartRequired, "reconcile")
+
+		c, err := auth.Generate(&conf, "", time.Hour)
+		assert.NoError(t, err, "generate")
+		key, ok := s.NewAuthHandler()(c.Username, conf.Realm,
+			&net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1234})
+		assert.True(t, ok, "auth handler")
+		assert.Equal(t, turn.GenerateAuthKey(c.Username, conf.Realm, c.Password), key,
+			"%s credentials accepted", conf.Type)
+		s.Close()
+	}
+}
+
+func TestICEConfiguration(t *testing.T) {
+	conf := &v1alpha1.StunnerConfig{
+		Auth: v1alpha1.AuthConfig{
+			Type:        "longterm",
+			Credentials: map[string]string{"secret": "my-secret"},
+		},
+		Listeners: []v1alpha1.ListenerConfig{
+			{Name: "udp", Protocol: "UDP", PublicAddr: "1.2.3.4", PublicPort: 3478, Addr: "0.0.0.0"},
+			{Name: "tls", Protocol: "TLS", Addr: "2001:db8::1", Port: 443},
+			{Name: "dtls", Protocol: "DTLS", PublicAddr: "turn.example.com", Port: 5349},
+			{Name: "wss", Protocol: "WSS", PublicAddr: "1.2.3.4", Port: 443},
+			{Name: "tcp", Protocol: "TCP", Addr: "0.0.0.0", Port: 3478},
+			{Name: "team-a", Protocol: "UDP", PublicAddr: "1.2.3.5", Tenant: "team-a"},
+		},
+	}
+
+	_, err := auth.ICEServerURL(&conf.Listeners[3])
+	assert.Error(t, err, "WSS listener")
+	_, err = auth.ICEServerURL(&conf.Listeners[4])
+	assert.Error(t, err, "wildcard address")
+
+	ice, err := auth.NewICEConfiguration(conf, "", time.Hour)
+	assert.NoError(t, err, "ICE configuration")
+	assert.Len(t, ice.ICEServers, 1, "ICE servers")
+	assert.Equal(t, []string{
+		"turn:1.2.3.4:3478?transport=udp",
+		"turns:[2001:db8::1]:443?transport=tcp",
+		"turns:turn.example.com:5349?transport=udp",
+	}, ice.ICEServers[0].URLs, "URLs")
+	assert.Equal(t, auth.LongTermPassword("my-secret", ice.ICEServers[0].Username),
+		ice.ICEServers[0].Credential, "credential")
+
+	b, err := json.Marshal(ice)
+	assert.NoError(t, err, "marshal")
+	assert.Contains(t, string(b), `"iceServers":[{"urls":["turn:1.2.3.4:3478?transport=udp"`, "JSON")
+	assert.Contains(t, string(b), `"iceTransportPolicy":"relay"`, "JSON")
+
+	_, err = auth.NewICEConfiguration(conf, "team-a", time.Hour)
+	assert.Error(t, err, "no credentials for the tenant")
+	conf.Auth.Tenants = []v1alpha1.TenantAuthConfig{{Tenant: "team-a", Type: "plaintext",
+		Credentials: map[string]string{"username": "a", "password": "b"}}}
+	ice, err = auth.NewICEConfiguration(conf, "team-a", time.Hour)
+	assert.NoError(t, err, "tenant ICE configuration")
+	assert.Equal(t, []string{"turn:1.2.3.5:3478?transport=udp"}, ice.ICEServers[0].URLs, "tenant URLs")
+	assert.Equal(t, "a", ice.ICEServers[0].Username, "tenant username")
+}
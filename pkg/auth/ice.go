@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/l7mp/stunner/pkg/apis/v1alpha1"
+)
+
+// ICEServer is an entry of the iceServers list of the WebRTC RTCConfiguration
+type ICEServer struct {
+	// URLs are the TURN URLs of the server
+	URLs []string `json:"urls"`
+	// Username is the TURN username
+	Username string `json:"username,omitempty"`
+	// Credential is the TURN password
+	Credential string `json:"credential,omitempty"`
+}
+
+// ICEConfiguration is the part of the WebRTC RTCConfiguration that sets the TURN servers
+type ICEConfiguration struct {
+	// ICEServers lists the TURN servers
+	ICEServers []ICEServer `json:"iceServers"`
+	// ICETransportPolicy is "relay" so that the clients use the relayed candidates only
+	ICETransportPolicy string `json:"iceTransportPolicy,omitempty"`
+}
+
+// ICEServerURL returns the TURN URL of a listener, at the public address and port of the
+// listener if set and at the address and port of the listener otherwise, e.g.,
+// "turn:1.2.3.4:3478?transport=udp". WS and WSS listeners have no TURN URL
+func ICEServerURL(l *v1alpha1.ListenerConfig) (string, error) {
+	raw := l.Protocol
+	if raw == "" {
+		raw = v1alpha1.DefaultProtocol
+	}
+	proto, err := v1alpha1.NewListenerProtocol(raw)
+	if err != nil {
+		return "", err
+	}
+
+	addr, port := l.PublicAddr, l.PublicPort
+	if addr == "" {
+		addr = l.Addr
+		if ip := net.ParseIP(addr); addr == "" || (ip != nil && ip.IsUnspecified()) {
+			return "", fmt.Errorf("no public address for listener %q", l.Name)
+		}
+	}
+	if port == 0 {
+		port = l.Port
+	}
+	if port == 0 {
+		port = v1alpha1.DefaultPort
+	}
+	hostport := net.JoinHostPort(addr, strconv.Itoa(port))
+
+	switch proto {
+	case v1alpha1.ListenerProtocolUDP:
+		return fmt.Sprintf("turn:%s?transport=udp", hostport), nil
+	case v1alpha1.ListenerProtocolTCP:
+		return fmt.Sprintf("turn:%s?transport=tcp", hostport), nil
+	case v1alpha1.ListenerProtocolTLS:
+		return fmt.Sprintf("turns:%s?transport=tcp", hostport), nil
+	case v1alpha1.ListenerProtocolDTLS:
+		return fmt.Sprintf("turns:%s?transport=udp", hostport), nil
+	default:
+		return "", fmt.Errorf("no TURN URL for %s listener %q", proto.String(), l.Name)
+	}
+}
+
+// NewICEConfiguration returns the ICE configuration for the clients of a tenant, with the TURN
+// URLs of the listeners of the tenant and the credentials generated for the tenant (see
+// Generate). The listeners with no TURN URL are skipped
+func NewICEConfiguration(conf *v1alpha1.StunnerConfig, tenant string, ttl time.Duration) (*ICEConfiguration, error) {
+	creds, err := Generate(&conf.Auth, tenant, ttl)
+	if err != nil {
+		return nil, err
+	}
+
+	server := ICEServer{URLs: []string{}, Username: creds.Username, Credential: creds.Password}
+	for i := range conf.Listeners {
+		l := &conf.Listeners[i]
+		if l.Tenant != tenant {
+			continue
+		}
+		if url, err := ICEServerURL(l); err == nil {
+			server.URLs = append(server.URLs, url)
+		}
+	}
+	if len(server.URLs) == 0 {
+		return nil, fmt.Errorf("no listeners with a TURN URL")
+	}
+
+	return &ICEConfiguration{ICEServers: []ICEServer{server}, ICETransportPolicy: "relay"}, nil
+}
@@ -0,0 +1,111 @@
+// Package cds implements the STUNner config discovery service (CDS): a gRPC streaming protocol
+// through which a control plane pushes listener and cluster resources to STUNner daemons.
+//
+// The protocol follows the xDS model. The daemon opens a bidirectional stream and sends a
+// DiscoveryRequest identifying itself. The control plane sends a DiscoveryResponse for each config
+// version, either a full config or an incremental update (upserted and removed listeners and
+// clusters). The daemon replies to each response with a DiscoveryRequest that ACKs the version (the
+// version is echoed back in VersionInfo) or NACKs it (VersionInfo holds the last accepted version
+// and ErrorDetail the reason).
+//
+// Messages are encoded as google.protobuf.Struct values, so the service needs no generated code.
+package cds
+
+import (
+	"sort"
+
+	"github.com/l7mp/stunner/pkg/apis/v1alpha1"
+)
+
+// ServiceName is the fully qualified name of the gRPC service
+const ServiceName = "stunner.cds.v1.ConfigDiscovery"
+
+// streamMethod is the full method name of the config stream
+const streamMethod = "/" + ServiceName + "/StreamConfig"
+
+// DiscoveryRequest is sent by the daemon: first to subscribe, then to ACK/NACK each response
+type DiscoveryRequest struct {
+	// Node is the name of the daemon
+	Node string `json:"node"`
+	// VersionInfo is the last config version accepted by the daemon
+	VersionInfo string `json:"version_info,omitempty"`
+	// ResponseNonce is the nonce of the response this request ACKs/NACKs
+	ResponseNonce string `json:"response_nonce,omitempty"`
+	// ErrorDetail is set if the response was rejected (NACK)
+	ErrorDetail string `json:"error_detail,omitempty"`
+}
+
+// DiscoveryResponse is sent by the control plane for each new config version
+type DiscoveryResponse struct {
+	// VersionInfo is the version of the config
+	VersionInfo string `json:"version_info"`
+	// Nonce identifies the response in the ACK/NACK
+	Nonce string `json:"nonce"`
+	// Incremental is set if the response updates the previous config, otherwise it holds the
+	// full config
+	Incremental bool `json:"incremental,omitempty"`
+	// Admin is the admin config, unchanged if nil in an incremental update
+	Admin *v1alpha1.AdminConfig `json:"admin,omitempty"`
+	// Auth is the auth config, unchanged if nil in an incremental update
+	Auth *v1alpha1.AuthConfig `json:"auth,omitempty"`
+	// Listeners are the listeners to add or update
+	Listeners []v1alpha1.ListenerConfig `json:"listeners,omitempty"`
+	// Clusters are the clusters to add or update
+	Clusters []v1alpha1.ClusterConfig `json:"clusters,omitempty"`
+	// RemovedListeners are the names of the listeners to remove (incremental updates only)
+	RemovedListeners []string `json:"removed_listeners,omitempty"`
+	// RemovedClusters are the names of the clusters to remove (incremental updates only)
+	RemovedClusters []string `json:"removed_clusters,omitempty"`
+}
+
+// Apply returns the config resulting from applying the response to the current config. The
+// current config is not modified and the result is not validated.
+func (r *DiscoveryResponse) Apply(current *v1alpha1.StunnerConfig) *v1alpha1.StunnerConfig {
+	c := &v1alpha1.StunnerConfig{ApiVersion: v1alpha1.ApiVersion}
+	if r.Incremental && current != nil {
+		c.Admin, c.Auth = current.Admin, current.Auth
+		c.Listeners = append(c.Listeners, current.Listeners...)
+		c.Clusters = append(c.Clusters, current.Clusters...)
+	}
+
+	if r.Admin != nil {
+		c.Admin = *r.Admin
+	}
+	if r.Auth != nil {
+		c.Auth = *r.Auth
+	}
+
+	listeners := map[string]v1alpha1.ListenerConfig{}
+	for _, l := range c.Listeners {
+		listeners[l.Name] = l
+	}
+	for _, n := range r.RemovedListeners {
+		delete(listeners, n)
+	}
+	for _, l := range r.Listeners {
+		listeners[l.Name] = l
+	}
+	c.Listeners = make([]v1alpha1.ListenerConfig, 0, len(listeners))
+	for _, l := range listeners {
+		c.Listeners = append(c.Listeners, l)
+	}
+	sort.Slice(c.Listeners, func(i, j int) bool { return c.Listeners[i].Name < c.Listeners[j].Name })
+
+	clusters := map[string]v1alpha1.ClusterConfig{}
+	for _, cl := range c.Clusters {
+		clusters[cl.Name] = cl
+	}
+	for _, n := range r.RemovedClusters {
+		delete(clusters, n)
+	}
+	for _, cl := range r.Clusters {
+		clusters[cl.Name] = cl
+	}
+	c.Clusters = make([]v1alpha1.ClusterConfig, 0, len(clusters))
+	for _, cl := range clusters {
+		c.Clusters = append(c.Clusters, cl)
+	}
+	sort.Slice(c.Clusters, func(i, j int) bool { return c.Clusters[i].Name < c.Clusters[j].Name })
+
+	return c
+}
@@ -0,0 +1,109 @@
+package cds
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/l7mp/stunner/internal/pbstruct"
+)
+
+var streamDesc = grpc.StreamDesc{
+	StreamName:    "StreamConfig",
+	ServerStreams: true,
+	ClientStreams: true,
+}
+
+// ClientStream is the daemon side of a config stream
+type ClientStream interface {
+	Send(*DiscoveryRequest) error
+	Recv() (*DiscoveryResponse, error)
+	CloseSend() error
+}
+
+type clientStream struct {
+	grpc.ClientStream
+}
+
+// StreamConfig opens a config stream on a client connection
+func StreamConfig(ctx context.Context, conn grpc.ClientConnInterface, opts ...grpc.CallOption) (ClientStream, error) {
+	s, err := conn.NewStream(ctx, &streamDesc, streamMethod, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &clientStream{s}, nil
+}
+
+func (s *clientStream) Send(req *DiscoveryRequest) error {
+	m, err := pbstruct.Encode(req)
+	if err != nil {
+		return err
+	}
+	return s.ClientStream.SendMsg(m)
+}
+
+func (s *clientStream) Recv() (*DiscoveryResponse, error) {
+	m := &structpb.Struct{}
+	if err := s.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	resp := &DiscoveryResponse{}
+	if err := pbstruct.Decode(m, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// ServerStream is the control plane side of a config stream
+type ServerStream interface {
+	Send(*DiscoveryResponse) error
+	Recv() (*DiscoveryRequest, error)
+	Context() context.Context
+}
+
+// Server is implemented by control planes serving configs
+type Server interface {
+	StreamConfig(ServerStream) error
+}
+
+type serverStream struct {
+	grpc.ServerStream
+}
+
+func (s *serverStream) Send(resp *DiscoveryResponse) error {
+	m, err := pbstruct.Encode(resp)
+	if err != nil {
+		return err
+	}
+	return s.ServerStream.SendMsg(m)
+}
+
+func (s *serverStream) Recv() (*DiscoveryRequest, error) {
+	m := &structpb.Struct{}
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	req := &DiscoveryRequest{}
+	if err := pbstruct.Decode(m, req); err != nil {
+		return nil, err
+	}
+	return req, nil
+}
+
+// RegisterServer registers a config discovery server with a gRPC server
+func RegisterServer(g *grpc.Server, srv Server) {
+	g.RegisterService(&grpc.ServiceDesc{
+		ServiceName: ServiceName,
+		HandlerType: (*Server)(nil),
+		Streams: []grpc.StreamDesc{{
+			StreamName: streamDesc.StreamName,
+			Handler: func(srv interface{}, stream grpc.ServerStream) error {
+				return srv.(Server).StreamConfig(&serverStream{stream})
+			},
+			ServerStreams: true,
+			ClientStreams: true,
+		}},
+		Metadata: "stunner/cds.proto",
+	}, srv)
+}
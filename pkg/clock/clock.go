@@ -0,0 +1,127 @@
+// Package clock abstracts the time source of STUNner, so that tests and simulation tooling can
+// fast-forward time deterministically instead of sleeping. The gateway reads the time from the
+// clock set with the embedding API when checking the expiry of the time-limited credentials,
+// tracking the lifetimes of the allocations, the permissions and the channel bindings, timing the
+// bans, metering the allocation quotas and the request rate and amplification limits, and
+// scheduling the refresh of the DNS resolver. The nonces and the allocation timers of the TURN
+// server itself are managed by pion/turn with the system clock and cannot be fast-forwarded.
+package clock
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock is a time source
+type Clock interface {
+	// Now returns the current time
+	Now() time.Time
+	// NewTicker returns a ticker firing every period
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker delivers the ticks of a clock
+type Ticker interface {
+	// C returns the channel the ticks are delivered on
+	C() <-chan time.Time
+	// Stop turns off the ticker
+	Stop()
+}
+
+// Real is the system clock
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) NewTicker(d time.Duration) Ticker { return &realTicker{time.NewTicker(d)} }
+
+type realTicker struct{ t *time.Ticker }
+
+func (t *realTicker) C() <-chan time.Time { return t.t.C }
+
+func (t *realTicker) Stop() { t.t.Stop() }
+
+// Fake is a manual clock: time stands still until advanced with Advance or Set, which fire the
+// tickers due in the meantime. The zero value is not usable, create fake clocks with NewFake
+type Fake struct {
+	lock    sync.Mutex
+	now     time.Time
+	tickers []*fakeTicker
+}
+
+// NewFake creates a fake clock set to the given time
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+// Now returns the time of the fake clock
+func (f *Fake) Now() time.Time {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.now
+}
+
+// NewTicker returns a ticker firing every period of the fake clock. As with time.Ticker, ticks
+// are dropped if the receiver falls behind
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for clock.Fake.NewTicker")
+	}
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	t := &fakeTicker{clock: f, period: d, next: f.now.Add(d), c: make(chan time.Time, 1)}
+	f.tickers = append(f.tickers, t)
+	return t
+}
+
+// Advance moves the fake clock forward, firing the tickers due
+func (f *Fake) Advance(d time.Duration) {
+	f.Set(f.Now().Add(d))
+}
+
+// Set moves the fake clock to the given time, firing the tickers due. The clock never goes back
+func (f *Fake) Set(now time.Time) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for {
+		// fire the ticks in order, so that the receivers see the time going forward
+		sort.Slice(f.tickers, func(i, j int) bool { return f.tickers[i].next.Before(f.tickers[j].next) })
+		if len(f.tickers) == 0 || f.tickers[0].next.After(now) {
+			break
+		}
+		t := f.tickers[0]
+		f.now = t.next
+		t.next = t.next.Add(t.period)
+		select {
+		case t.c <- f.now:
+		default:
+		}
+	}
+	if now.After(f.now) {
+		f.now = now
+	}
+}
+
+type fakeTicker struct {
+	clock  *Fake
+	period time.Duration
+	next   time.Time
+	c      chan time.Time
+}
+
+func (t *fakeTicker) C() <-chan time.Time { return t.c }
+
+func (t *fakeTicker) Stop() {
+	f := t.clock
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	for i, o := range f.tickers {
+		if o == t {
+			f.tickers = append(f.tickers[:i], f.tickers[i+1:]...)
+			return
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package clock_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/l7mp/stunner/pkg/clock"
+)
+
+func TestFake(t *testing.T) {
+	start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	f := clock.NewFake(start)
+	assert.Equal(t, start, f.Now(), "now")
+
+	fast := f.NewTicker(time.Second)
+	slow := f.NewTicker(5 * time.Second)
+	tick := func(tk clock.Ticker) (time.Time, bool) {
+		select {
+		case t := <-tk.C():
+			return t, true
+		default:
+			return time.Time{}, false
+		}
+	}
+
+	f.Advance(500 * time.Millisecond)
+	assert.Equal(t, start.Add(500*time.Millisecond), f.Now(), "advanced")
+	_, ok := tick(fast)
+	assert.False(t, ok, "not due")
+
+	f.Advance(500 * time.Millisecond)
+	tt, ok := tick(fast)
+	assert.True(t, ok, "due")
+	assert.Equal(t, start.Add(time.Second), tt, "tick time")
+
+	// ticks are dropped if the receiver falls behind
+	f.Advance(10 * time.Second)
+	assert.Equal(t, start.Add(11*time.Second), f.Now(), "advanced")
+	tt, ok = tick(fast)
+	assert.True(t, ok, "due")
+	assert.Equal(t, start.Add(2*time.Second), tt, "first pending tick")
+	_, ok = tick(fast)
+	assert.False(t, ok, "ticks dropped")
+	tt, ok = tick(slow)
+	assert.True(t, ok, "slow ticker due")
+	assert.Equal(t, start.Add(5*time.Second), tt, "slow tick time")
+
+	// the clock never goes back
+	f.Set(start)
+	assert.Equal(t, start.Add(11*time.Second), f.Now(), "not set back")
+
+	fast.Stop()
+	f.Advance(time.Minute)
+	_, ok = tick(fast)
+	assert.False(t, ok, "stopped")
+	_, ok = tick(slow)
+	assert.True(t, ok, "running")
+}
+
+func TestReal(t *testing.T) {
+	before := time.Now()
+	assert.False(t, clock.Real.Now().Before(before), "now")
+
+	tk := clock.Real.NewTicker(10 * time.Millisecond)
+	defer tk.Stop()
+	select {
+	case <-tk.C():
+	case <-time.After(time.Second):
+		assert.Fail(t, "no tick")
+	}
+}
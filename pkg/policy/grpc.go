@@ -0,0 +1,77 @@
+package policy
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/structpb"
+
+	"github.com/l7mp/stunner/internal/pbstruct"
+)
+
+// RegisterServer registers a plugin as the policy service of a gRPC server
+func RegisterServer(g *grpc.Server, p Plugin) {
+	desc := grpc.ServiceDesc{
+		ServiceName: ServiceName,
+		HandlerType: (*Plugin)(nil),
+		Metadata:    "stunner/policy.proto",
+		Methods: []grpc.MethodDesc{{
+			MethodName: "Decide",
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error,
+				interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+				in := &structpb.Struct{}
+				if err := dec(in); err != nil {
+					return nil, err
+				}
+				handler := func(ctx context.Context, in interface{}) (interface{}, error) {
+					req := &Request{}
+					if err := pbstruct.Decode(in.(*structpb.Struct), req); err != nil {
+						return nil, err
+					}
+					resp, err := srv.(Plugin).Decide(ctx, req)
+					if err != nil {
+						return nil, err
+					}
+					if resp == nil {
+						resp = &Response{}
+					}
+					return pbstruct.Encode(resp)
+				}
+				if interceptor == nil {
+					return handler(ctx, in)
+				}
+				info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + ServiceName + "/Decide"}
+				return interceptor(ctx, in, info, handler)
+			},
+		}},
+	}
+
+	g.RegisterService(&desc, p)
+}
+
+// Client is a plugin served over gRPC
+type Client struct {
+	conn grpc.ClientConnInterface
+}
+
+// NewClient creates a plugin client on a client connection
+func NewClient(conn grpc.ClientConnInterface) *Client {
+	return &Client{conn: conn}
+}
+
+// Decide asks the plugin for a decision
+func (c *Client) Decide(ctx context.Context, req *Request) (*Response, error) {
+	in, err := pbstruct.Encode(req)
+	if err != nil {
+		return nil, err
+	}
+	out := &structpb.Struct{}
+	if err := c.conn.Invoke(ctx, "/"+ServiceName+"/Decide", in, out); err != nil {
+		return nil, err
+	}
+	resp := &Response{}
+	if err := pbstruct.Decode(out, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
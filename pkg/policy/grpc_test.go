@@ -0,0 +1,58 @@
+package policy
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func dial(t *testing.T, p Plugin) *grpc.ClientConn {
+	l := bufconn.Listen(1 << 16)
+	g := grpc.NewServer()
+	RegisterServer(g, p)
+	go g.Serve(l) //nolint:errcheck
+	t.Cleanup(g.Stop)
+
+	conn, err := grpc.Dial("bufnet", grpc.WithInsecure(),
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return l.Dial() }))
+	assert.NoError(t, err, "dial")
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestGRPC(t *testing.T) {
+	var got *Request
+	conn := dial(t, PluginFunc(func(_ context.Context, req *Request) (*Response, error) {
+		got = req
+		if req.Username == "" {
+			return nil, nil
+		}
+		return &Response{Decision: Deny, Reason: "blocked",
+			Annotations: map[string]string{"rule": "r1"}}, nil
+	}))
+
+	req := &Request{Kind: KindPermission, Listener: "udp", Tenant: "team-a", Username: "user",
+		Client: "1.2.3.4:5678", Session: "s1", Peer: "10.0.0.1",
+		Clusters: []string{"media", "signal"}, Decision: Allow}
+	resp, err := NewClient(conn).Decide(context.Background(), req)
+	assert.NoError(t, err, "decide")
+	assert.Equal(t, req, got, "request round trip")
+	assert.Equal(t, &Response{Decision: Deny, Reason: "blocked",
+		Annotations: map[string]string{"rule": "r1"}}, resp, "response round trip")
+
+	// a nil response keeps the built-in decision
+	resp, err = NewClient(conn).Decide(context.Background(), &Request{Kind: KindAllocation})
+	assert.NoError(t, err, "decide")
+	assert.Equal(t, &Response{}, resp, "empty response")
+
+	// requests that do not decode are refused
+	in, err := structpb.NewStruct(map[string]interface{}{"clusters": "media"})
+	assert.NoError(t, err, "struct")
+	err = conn.Invoke(context.Background(), "/"+ServiceName+"/Decide", in, &structpb.Struct{})
+	assert.Error(t, err, "invalid request")
+}
@@ -0,0 +1,84 @@
+// Package policy defines the policy plugins of STUNner: site-specific routing and admission
+// policies consulted at allocation and permission time, without forking the routing code of the
+// gateway.
+//
+// The gateway asks the plugin for a decision on each new authenticated Allocate request and on
+// each permission request (CreatePermission and ChannelBind), with the full context of the
+// request: the listener, the tenant, the username, the client, the peer, the candidate clusters
+// and the decision of the built-in routing policy. The plugin can allow or deny the request, or
+// keep the built-in decision, and can annotate the decision with key-value pairs, which are
+// logged and shown in the decision log of the admin API.
+//
+// Plugins run out of process as a gRPC service, see RegisterServer, set in the policy_plugin
+// admin setting of the gateway, or in process with the embedding API. Messages are encoded as
+// google.protobuf.Struct values, so the service needs no generated code.
+package policy
+
+import "context"
+
+// ServiceName is the fully qualified name of the gRPC service
+const ServiceName = "stunner.policy.v1.Policy"
+
+const (
+	// KindAllocation is the kind of the decisions on the Allocate requests
+	KindAllocation = "allocation"
+	// KindPermission is the kind of the decisions on the permission requests
+	KindPermission = "permission"
+)
+
+const (
+	// Allow admits the request
+	Allow = "allow"
+	// Deny refuses the request
+	Deny = "deny"
+)
+
+// Request asks for a decision
+type Request struct {
+	// Kind is KindAllocation or KindPermission
+	Kind string `json:"kind"`
+	// Listener is the name of the listener the request was received on
+	Listener string `json:"listener"`
+	// Tenant is the tenant of the listener, if any
+	Tenant string `json:"tenant,omitempty"`
+	// Username is the TURN username of the client
+	Username string `json:"username,omitempty"`
+	// Realm is the realm of the request, if known
+	Realm string `json:"realm,omitempty"`
+	// Client is the transport address of the client
+	Client string `json:"client"`
+	// Session is the session ID of the allocation, for permissions
+	Session string `json:"session,omitempty"`
+	// Peer is the IP address of the peer, for permissions
+	Peer string `json:"peer,omitempty"`
+	// Clusters lists the candidate clusters: the clusters routed by the listener for
+	// allocations, and the clusters of the listener routing to the peer for permissions
+	Clusters []string `json:"clusters,omitempty"`
+	// Decision is the decision of the built-in routing policy, Allow or Deny
+	Decision string `json:"decision"`
+}
+
+// Response is the decision of the plugin
+type Response struct {
+	// Decision is Allow or Deny, empty to keep the built-in decision
+	Decision string `json:"decision,omitempty"`
+	// Reason explains the decision, for the logs
+	Reason string `json:"reason,omitempty"`
+	// Annotations are key-value pairs attached to the decision
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// Plugin makes the policy decisions. Decide is called concurrently and on the packet path, so it
+// must be fast: the gateway gives up after the timeout of the plugin
+type Plugin interface {
+	// Decide returns the decision on a request
+	Decide(context.Context, *Request) (*Response, error)
+}
+
+// PluginFunc is a function implementing the Plugin interface
+type PluginFunc func(context.Context, *Request) (*Response, error)
+
+// Decide calls the function
+func (f PluginFunc) Decide(ctx context.Context, req *Request) (*Response, error) {
+	return f(ctx, req)
+}
@@ -0,0 +1,88 @@
+package sds
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// serverFunc is a function implementing the Server interface
+type serverFunc func(ServerStream) error
+
+func (f serverFunc) StreamSecrets(s ServerStream) error { return f(s) }
+
+func dial(t *testing.T, srv Server) *grpc.ClientConn {
+	l := bufconn.Listen(1 << 16)
+	g := grpc.NewServer()
+	RegisterServer(g, srv)
+	go g.Serve(l) //nolint:errcheck
+	t.Cleanup(g.Stop)
+
+	conn, err := grpc.Dial("bufnet", grpc.WithInsecure(),
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return l.Dial() }))
+	assert.NoError(t, err, "dial")
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestGRPC(t *testing.T) {
+	resp := &DiscoveryResponse{VersionInfo: "v1", Nonce: "n1",
+		Secrets: []Secret{{Name: "tls", CertificateChain: "cert", PrivateKey: "key"}}}
+	reqs := make(chan *DiscoveryRequest, 2)
+	conn := dial(t, serverFunc(func(s ServerStream) error {
+		for i := 0; i < 2; i++ {
+			req, err := s.Recv()
+			if err != nil {
+				return err
+			}
+			reqs <- req
+			if i == 0 {
+				if err := s.Send(resp); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}))
+
+	s, err := StreamSecrets(context.Background(), conn)
+	assert.NoError(t, err, "stream")
+	sub := &DiscoveryRequest{Node: "stunner-1", ResourceNames: []string{"tls"}}
+	assert.NoError(t, s.Send(sub), "subscribe")
+	assert.Equal(t, sub, <-reqs, "subscription round trip")
+
+	got, err := s.Recv()
+	assert.NoError(t, err, "receive")
+	assert.Equal(t, resp, got, "response round trip")
+
+	nack := &DiscoveryRequest{Node: "stunner-1", ResourceNames: []string{"tls"},
+		ResponseNonce: "n1", ErrorDetail: "invalid"}
+	assert.NoError(t, s.Send(nack), "NACK")
+	assert.Equal(t, nack, <-reqs, "NACK round trip")
+	assert.NoError(t, s.CloseSend(), "close")
+}
+
+func TestGRPCInvalidResponse(t *testing.T) {
+	conn := dial(t, serverFunc(func(s ServerStream) error {
+		if _, err := s.Recv(); err != nil {
+			return err
+		}
+		// responses that do not decode are refused by the daemon
+		m, err := structpb.NewStruct(map[string]interface{}{"secrets": "tls"})
+		if err != nil {
+			return err
+		}
+		return s.(*serverStream).SendMsg(m)
+	}))
+
+	s, err := StreamSecrets(context.Background(), conn)
+	assert.NoError(t, err, "stream")
+	assert.NoError(t, s.Send(&DiscoveryRequest{Node: "stunner-1"}), "subscribe")
+	_, err = s.Recv()
+	assert.Error(t, err, "invalid response")
+}
@@ -0,0 +1,56 @@
+// Package sds implements the STUNner secret discovery service (SDS): a gRPC streaming protocol
+// through which a control plane, or a node agent, pushes the TLS certificates of the listeners to
+// STUNner daemons, so that the certificates and their private keys never appear in the STUNner
+// config itself.
+//
+// The protocol follows the SDS model of xDS. The daemon opens a bidirectional stream and sends a
+// DiscoveryRequest naming the secrets it needs. The server sends a DiscoveryResponse with the
+// current version of the requested secrets, and a new one each time a secret changes. The daemon
+// replies to each response with a DiscoveryRequest that ACKs the version (the version is echoed
+// back in VersionInfo) or NACKs it (VersionInfo holds the last accepted version and ErrorDetail the
+// reason). When the secrets needed by the daemon change, it sends a new DiscoveryRequest with the
+// new names.
+//
+// Messages are encoded as google.protobuf.Struct values, so the service needs no generated code.
+package sds
+
+// ServiceName is the fully qualified name of the gRPC service
+const ServiceName = "stunner.sds.v1.SecretDiscovery"
+
+// streamMethod is the full method name of the secret stream
+const streamMethod = "/" + ServiceName + "/StreamSecrets"
+
+// DiscoveryRequest is sent by the daemon: to subscribe to a set of secrets and to ACK/NACK each
+// response
+type DiscoveryRequest struct {
+	// Node is the name of the daemon
+	Node string `json:"node"`
+	// ResourceNames are the names of the secrets the daemon needs
+	ResourceNames []string `json:"resource_names,omitempty"`
+	// VersionInfo is the last version accepted by the daemon
+	VersionInfo string `json:"version_info,omitempty"`
+	// ResponseNonce is the nonce of the response this request ACKs/NACKs
+	ResponseNonce string `json:"response_nonce,omitempty"`
+	// ErrorDetail is set if the response was rejected (NACK)
+	ErrorDetail string `json:"error_detail,omitempty"`
+}
+
+// DiscoveryResponse is sent by the server with the current version of the requested secrets
+type DiscoveryResponse struct {
+	// VersionInfo is the version of the secrets
+	VersionInfo string `json:"version_info"`
+	// Nonce identifies the response in the ACK/NACK
+	Nonce string `json:"nonce"`
+	// Secrets are the secrets that changed, the secrets not listed are unchanged
+	Secrets []Secret `json:"secrets,omitempty"`
+}
+
+// Secret is a TLS certificate
+type Secret struct {
+	// Name is the name of the secret
+	Name string `json:"name"`
+	// CertificateChain is the PEM encoded certificate chain, the leaf certificate first
+	CertificateChain string `json:"certificate_chain"`
+	// PrivateKey is the PEM encoded private key of the leaf certificate
+	PrivateKey string `json:"private_key"`
+}
@@ -0,0 +1,228 @@
+// Package stunner is the embedding API of STUNner: it allows Go programs to run a STUNner
+// dataplane in-process, without the stunnerd daemon.
+//
+// The lifecycle of an embedded dataplane is New, followed by Reconcile to set the initial config,
+// Start to open the listeners, any number of Reconcile calls to update the running config, and
+// finally Shutdown:
+//
+//	s, err := stunner.New(stunner.WithLogger(os.Stderr))
+//	if err != nil { ... }
+//	if err := s.Reconcile(conf); err != nil { ... }
+//	if err := s.Start(); err != nil { ... }
+//	defer s.Shutdown(context.Background())
+//
+// All methods of Stunner are safe for concurrent use. Calls are serialized: a reconciliation never
+// runs concurrently with another reconciliation, a start or a shutdown, and each call observes the
+// effects of the calls completed before it. Config and Status return a consistent snapshot, waiting
+// for an ongoing Reconcile or Start to complete (note that a graceful restart may wait for the
+// drain timeout). The TURN server itself serves clients concurrently with all calls.
+package stunner
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	dataplane "github.com/l7mp/stunner"
+	"github.com/l7mp/stunner/internal/resolver"
+	"github.com/l7mp/stunner/pkg/apis/v1alpha1"
+	"github.com/l7mp/stunner/pkg/clock"
+	"github.com/l7mp/stunner/pkg/policy"
+)
+
+var (
+	// ErrNoConfig is returned by Start if no config has been reconciled yet
+	ErrNoConfig = errors.New("no config to start")
+	// ErrAlreadyStarted is returned by Start if the dataplane is already running
+	ErrAlreadyStarted = errors.New("already started")
+	// ErrShutdown is returned by all calls after Shutdown
+	ErrShutdown = errors.New("dataplane is shut down")
+)
+
+// Resolver is the DNS resolver used by the clusters of type STRICT_DNS
+type Resolver = resolver.DnsResolver
+
+// ObjectStatus is the runtime status of a dataplane object
+type ObjectStatus = dataplane.ObjectStatus
+
+// Option configures an embedded dataplane
+type Option func(*options)
+
+type options struct {
+	resolver Resolver
+	writer   io.Writer
+	registry prometheus.Registerer
+	plugin   policy.Plugin
+	clock    clock.Clock
+}
+
+// WithResolver sets the DNS resolver for the clusters of type STRICT_DNS. Default is to resolve
+// domain names periodically using the system resolver
+func WithResolver(r Resolver) Option {
+	return func(o *options) { o.resolver = r }
+}
+
+// WithLogger redirects the logs of the dataplane to the given writer. Default is stdout. The log
+// level is set in the admin section of the config
+func WithLogger(w io.Writer) Option {
+	return func(o *options) { o.writer = w }
+}
+
+// WithMetricsRegistry registers the dataplane metrics with the given Prometheus registry. Default
+// is the global Prometheus registry
+func WithMetricsRegistry(r prometheus.Registerer) Option {
+	return func(o *options) { o.registry = r }
+}
+
+// WithPolicyPlugin installs an in-process policy plugin, consulted on the allocation and permission
+// decisions if the policy_plugin admin setting of the config is set with no plugin address
+func WithPolicyPlugin(p policy.Plugin) Option {
+	return func(o *options) { o.plugin = p }
+}
+
+// WithClock sets the time source of the dataplane, e.g., a clock.Fake to fast-forward the expiry
+// of the credentials, the lifetimes tracked by the dataplane, the bans and the rate limits in
+// tests. The nonces and the allocation timers of pion/turn stay on the system clock. Default is
+// the system clock
+func WithClock(c clock.Clock) Option {
+	return func(o *options) { o.clock = c }
+}
+
+// Stunner is an embedded STUNner dataplane
+type Stunner struct {
+	lock     sync.Mutex
+	stunner  *dataplane.Stunner
+	config   *v1alpha1.StunnerConfig
+	started  bool
+	shutdown bool
+	done     chan struct{}
+}
+
+// New creates a new embedded dataplane. The dataplane does not open any listeners until Start is
+// called
+func New(opts ...Option) (*Stunner, error) {
+	o := options{}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	s := dataplane.NewStunner().WithOptions(dataplane.Options{
+		Resolver:        o.resolver,
+		LogWriter:       o.writer,
+		MetricsRegistry: o.registry,
+		PolicyPlugin:    o.plugin,
+		Clock:           o.clock,
+	})
+
+	return &Stunner{stunner: s, done: make(chan struct{})}, nil
+}
+
+// Reconcile validates a config and applies it to the dataplane. Before Start the config is only
+// stored, to be applied by Start. On a running dataplane the config is applied right away: changes
+// that require a restart of the TURN server are applied according to the restart policy of the
+// config (returning v1alpha1.ErrRestartRefused if the policy is "never"), and a failed
+// reconciliation is rolled back so the dataplane keeps running the previous config
+func (s *Stunner) Reconcile(conf v1alpha1.StunnerConfig) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.shutdown {
+		return ErrShutdown
+	}
+
+	if err := conf.Validate(); err != nil {
+		return err
+	}
+
+	if !s.started {
+		s.config = &conf
+		return nil
+	}
+
+	return s.reconcile(conf)
+}
+
+// Start applies the last reconciled config and opens the listeners
+func (s *Stunner) Start() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.shutdown {
+		return ErrShutdown
+	}
+	if s.started {
+		return ErrAlreadyStarted
+	}
+	if s.config == nil {
+		return ErrNoConfig
+	}
+
+	if err := s.reconcile(*s.config); err != nil {
+		return err
+	}
+	s.started = true
+	s.config = nil
+
+	return nil
+}
+
+// reconcile applies a config, a restart of the TURN server is not an error here
+func (s *Stunner) reconcile(conf v1alpha1.StunnerConfig) error {
+	if err := s.stunner.Reconcile(conf); err != nil && !errors.Is(err, v1alpha1.ErrRestartRequired) {
+		return err
+	}
+	return nil
+}
+
+// Shutdown stops the dataplane and closes all listeners and connections. If the context expires
+// before the shutdown completes then Shutdown returns the context error while the shutdown
+// continues in the background. Calling Shutdown more than once is safe, later calls wait for the
+// first shutdown to complete
+func (s *Stunner) Shutdown(ctx context.Context) error {
+	s.lock.Lock()
+	if !s.shutdown {
+		s.shutdown = true
+		go func() {
+			s.stunner.Close()
+			close(s.done)
+		}()
+	}
+	s.lock.Unlock()
+
+	select {
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Config returns the config the dataplane is running, or the config to be applied by Start if
+// the dataplane has not been started yet. Secrets are not redacted
+func (s *Stunner) Config() *v1alpha1.StunnerConfig {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if !s.started {
+		if s.config == nil {
+			return nil
+		}
+		c := *s.config
+		return &c
+	}
+	return s.stunner.GetConfig()
+}
+
+// Status returns the runtime status of the objects of the dataplane
+func (s *Stunner) Status() []ObjectStatus {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if !s.started || s.shutdown {
+		return []ObjectStatus{}
+	}
+	return s.stunner.GetStatus()
+}
@@ -0,0 +1,125 @@
+package stunner
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"sync"
+	"testing"
+
+	"github.com/pion/turn/v2"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/l7mp/stunner/pkg/apis/v1alpha1"
+)
+
+// syncBuffer is a bytes.Buffer that can be written concurrently
+type syncBuffer struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return b.buf.String()
+}
+
+func testConfig(port int) v1alpha1.StunnerConfig {
+	return v1alpha1.StunnerConfig{
+		ApiVersion: v1alpha1.ApiVersion,
+		Admin:      v1alpha1.AdminConfig{LogLevel: "all:INFO"},
+		Auth: v1alpha1.AuthConfig{
+			Type:        "plaintext",
+			Credentials: map[string]string{"username": "user1", "password": "passwd1"},
+		},
+		Listeners: []v1alpha1.ListenerConfig{{
+			Name:     "udp",
+			Protocol: "udp",
+			Addr:     "127.0.0.1",
+			Port:     port,
+		}},
+	}
+}
+
+func TestStunnerEmbedded(t *testing.T) {
+	logs := &syncBuffer{}
+	reg := prometheus.NewRegistry()
+	s, err := New(WithLogger(logs), WithMetricsRegistry(reg))
+	assert.NoError(t, err, "new")
+
+	assert.ErrorIs(t, s.Start(), ErrNoConfig, "start without config")
+
+	bad := testConfig(23478)
+	bad.Auth.Type = "dummy"
+	assert.Error(t, s.Reconcile(bad), "invalid config")
+
+	assert.NoError(t, s.Reconcile(testConfig(23478)), "reconcile before start")
+	assert.Equal(t, 23478, s.Config().Listeners[0].Port, "pending config")
+	assert.Len(t, s.Status(), 0, "not started")
+
+	assert.NoError(t, s.Start(), "start")
+	assert.ErrorIs(t, s.Start(), ErrAlreadyStarted, "start twice")
+	assert.Contains(t, logs.String(), "TURN server running", "logs redirected")
+
+	// metrics are registered with the custom registry
+	mfs, err := reg.Gather()
+	assert.NoError(t, err, "gather")
+	found := false
+	for _, mf := range mfs {
+		if mf.GetName() == "stunner_allocations_active" {
+			found = true
+		}
+	}
+	assert.True(t, found, "metrics registered")
+
+	allocate := func(port string) error {
+		conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+		assert.NoError(t, err, "client socket")
+		defer conn.Close()
+
+		client, err := turn.NewClient(&turn.ClientConfig{
+			STUNServerAddr: "127.0.0.1:" + port,
+			TURNServerAddr: "127.0.0.1:" + port,
+			Username:       "user1",
+			Password:       "passwd1",
+			Conn:           conn,
+		})
+		assert.NoError(t, err, "client")
+		defer client.Close()
+		assert.NoError(t, client.Listen(), "listen")
+
+		relay, err := client.Allocate()
+		if err != nil {
+			return err
+		}
+		return relay.Close()
+	}
+	assert.NoError(t, allocate("23478"), "allocate")
+
+	// reconcile while running, concurrently
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, s.Reconcile(testConfig(23479)), "reconcile")
+			assert.Len(t, s.Status(), 3, "status")
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 23479, s.Config().Listeners[0].Port, "running config")
+	assert.NoError(t, allocate("23479"), "allocate after restart")
+
+	assert.NoError(t, s.Shutdown(context.Background()), "shutdown")
+	assert.NoError(t, s.Shutdown(context.Background()), "shutdown twice")
+	assert.ErrorIs(t, s.Reconcile(testConfig(23478)), ErrShutdown, "reconcile after shutdown")
+	assert.ErrorIs(t, s.Start(), ErrShutdown, "start after shutdown")
+}
@@ -0,0 +1,119 @@
+package stunner
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/l7mp/stunner/internal/api"
+	"github.com/l7mp/stunner/internal/nat64"
+	"github.com/l7mp/stunner/internal/object"
+	"github.com/l7mp/stunner/internal/plugin"
+	"github.com/l7mp/stunner/pkg/policy"
+)
+
+// PolicyStatus is the response of the /api/v1/policy admin API
+type PolicyStatus struct {
+	// Address is the gRPC address of the plugin, empty for the in-process plugin
+	Address string `json:"address,omitempty"`
+	// Hooks lists the decisions the plugin is consulted on
+	Hooks []string `json:"hooks"`
+	// Decisions is the log of the last decisions made with the plugin, oldest first
+	Decisions []plugin.Decision `json:"decisions"`
+}
+
+// reconcilePolicyPlugin sets the policy plugin from the admin config, or disables the plugin if no
+// policy plugin is configured
+func (s *Stunner) reconcilePolicyPlugin() {
+	req := s.GetAdmin().PolicyPlugin
+	if req == nil {
+		_ = s.policy.SetConfig(nil)
+		return
+	}
+
+	if err := s.policy.SetConfig(&plugin.Config{
+		Address:       req.Address,
+		Hooks:         req.Hooks,
+		Timeout:       time.Duration(req.Timeout) * time.Millisecond,
+		FailurePolicy: req.FailurePolicy,
+	}); err != nil {
+		s.log.Errorf("policy plugin disabled: %s", err.Error())
+	}
+}
+
+// newAllocationRequest returns the request to ask the policy plugin about a new authenticated
+// Allocate request of a client of a listener, or nil for the clients with an allocation
+func (s *Stunner) newAllocationRequest(l *object.Listener, client net.Addr, username, realm string) *policy.Request {
+	if s.conntrack.ClientSessionID(l.Name, client) != "" {
+		return nil
+	}
+	clusters := []string{}
+	for _, r := range l.Routes {
+		if s.GetCluster(r) != nil {
+			clusters = append(clusters, r)
+		}
+	}
+	return &policy.Request{
+		Kind:     policy.KindAllocation,
+		Listener: l.Name,
+		Tenant:   l.Tenant,
+		Username: username,
+		Realm:    realm,
+		Client:   client.String(),
+		Clusters: clusters,
+		Decision: policy.Allow,
+	}
+}
+
+// newPermissionRequest returns the request to ask the policy plugin about a permission of a client
+// of a listener to a peer, with the clusters of the listener routing to the peer as candidates
+func (s *Stunner) newPermissionRequest(l *object.Listener, client net.Addr, peer net.IP, allowed bool) *policy.Request {
+	peers := append([]net.IP{peer}, s.addressMap.InternalIPs(peer)...)
+	if v4, ok := nat64.Extract(peer, s.GetAdmin().NAT64Prefix); ok {
+		peers = append(peers, v4)
+	}
+	clusters := []string{}
+	for _, r := range l.Routes {
+		c := s.GetCluster(r)
+		if c == nil {
+			continue
+		}
+		for _, ip := range peers {
+			if c.Route(ip) {
+				clusters = append(clusters, r)
+				break
+			}
+		}
+	}
+
+	decision := policy.Deny
+	if allowed {
+		decision = policy.Allow
+	}
+	return &policy.Request{
+		Kind:     policy.KindPermission,
+		Listener: l.Name,
+		Tenant:   l.Tenant,
+		Username: s.conntrack.ClientUsername(l.Name, client),
+		Client:   client.String(),
+		Session:  s.conntrack.ClientSessionID(l.Name, client),
+		Peer:     peer.String(),
+		Clusters: clusters,
+		Decision: decision,
+	}
+}
+
+// GET /api/v1/policy: show the policy plugin and the log of its last decisions
+func (s *Stunner) handlePolicy(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	ret := PolicyStatus{Hooks: []string{}, Decisions: s.policy.Decisions()}
+	if req := s.GetAdmin().PolicyPlugin; req != nil {
+		ret.Address, ret.Hooks = req.Address, req.Hooks
+	}
+
+	api.WriteJSON(w, http.StatusOK, ret)
+}
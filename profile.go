@@ -0,0 +1,65 @@
+package stunner
+
+import (
+	"runtime/debug"
+
+	"github.com/l7mp/stunner/internal/monitoring"
+	"github.com/l7mp/stunner/internal/profile"
+	"github.com/l7mp/stunner/internal/udp"
+	"github.com/l7mp/stunner/internal/util"
+)
+
+// resourceLimits returns the resource ceilings of the resource profile set in the admin config
+func (s *Stunner) resourceLimits() profile.Limits {
+	return profile.Get(s.GetAdmin().ResourceProfile)
+}
+
+// reconcileResourceProfile applies the ceilings of the resource profile set in the admin config.
+// The worker counts of the UDP listeners are capped when the listeners are started, and the
+// allocations by the quotas
+func (s *Stunner) reconcileResourceProfile() {
+	limits := s.resourceLimits()
+
+	util.DefaultBufferPool.SetMaxPooledSize(limits.MaxPooledBufferSize)
+	monitoring.SLO.SetRetention(limits.SLORetention)
+	s.audit.setLimit(profile.Cap(auditHistoryLen, limits.AuditHistoryLen))
+
+	// the GC target set by the user (GOGC) is restored when leaving the profile
+	if limits.GCPercent > 0 {
+		old := debug.SetGCPercent(limits.GCPercent)
+		if !s.gcPercentSaved {
+			s.gcPercent, s.gcPercentSaved = old, true
+		}
+	} else if s.gcPercentSaved {
+		debug.SetGCPercent(s.gcPercent)
+		s.gcPercentSaved = false
+	}
+
+	// the runtime collects more aggressively near the ceiling, so does the limit set by the
+	// user (GOMEMLIMIT), which is restored when leaving the profile
+	if limits.MemoryCeiling > 0 {
+		old := debug.SetMemoryLimit(limits.MemoryCeiling)
+		if !s.memoryLimitSaved {
+			s.memoryLimit, s.memoryLimitSaved = old, true
+		}
+	} else if s.memoryLimitSaved {
+		debug.SetMemoryLimit(s.memoryLimit)
+		s.memoryLimitSaved = false
+	}
+}
+
+// udpThreadNum returns the number of worker threads per UDP listener, capped by the resource
+// profile
+func (s *Stunner) udpThreadNum() int {
+	return profile.Cap(udp.NormalizeThreadNum(s.options.UDPListenerThreadNum),
+		s.resourceLimits().UDPThreadNum)
+}
+
+// udpBatchSize returns the batch size of the UDP listeners, capped by the resource profile, zero
+// disables batched I/O
+func (s *Stunner) udpBatchSize() int {
+	if s.options.UDPBatchSize <= 0 {
+		return 0
+	}
+	return profile.Cap(s.options.UDPBatchSize, s.resourceLimits().UDPBatchSize)
+}
@@ -0,0 +1,28 @@
+package stunner
+
+import (
+	"github.com/l7mp/stunner/internal/profile"
+	"github.com/l7mp/stunner/internal/quota"
+	"github.com/l7mp/stunner/pkg/apis/v1alpha1"
+)
+
+// reconcileQuota sets the allocation quotas from the admin config, or disables the quotas if none
+// is configured. The number of allocations is capped by the resource profile
+func (s *Stunner) reconcileQuota() {
+	maxAllocations := s.resourceLimits().MaxAllocations
+	req := s.GetAdmin().Quota
+	if req == nil {
+		if maxAllocations == 0 {
+			s.quota.SetConfig(nil)
+			return
+		}
+		req = &v1alpha1.QuotaConfig{}
+	}
+
+	s.quota.SetConfig(&quota.Config{
+		MaxAllocations:          profile.Cap(req.MaxAllocations, maxAllocations),
+		MaxAllocationsPerSource: req.MaxAllocationsPerSource,
+		Rate:                    req.AllocationRate,
+		RatePerSource:           req.AllocationRatePerSource,
+	})
+}
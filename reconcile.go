@@ -2,10 +2,20 @@ package stunner
 
 import (
 	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"time"
 
 	// "github.com/pion/logging"
 	// "github.com/pion/transport/vnet"
 
+	"github.com/l7mp/stunner/internal/conntrack"
+	"github.com/l7mp/stunner/internal/logger"
+	"github.com/l7mp/stunner/internal/manager"
+	"github.com/l7mp/stunner/internal/monitoring"
+	"github.com/l7mp/stunner/internal/tracing"
 	"github.com/l7mp/stunner/pkg/apis/v1alpha1"
 )
 
@@ -15,168 +25,621 @@ import (
 // reconciliation). Reconcile returns nil if is server restart was not requred,
 // v1alpha1.ErrRestartRequired to indicate that it performed a full shutdown-restart cycle to
 // reconcile the new config (unless DryRun is on), and an error if an error happened during
-// reconciliation, in which case it will rollback the last working configuration (unless
-// SuppressRollback is on)
+// reconciliation. Changes requiring a restart of a running server are applied according to the
+// restart policy of the new config, returning v1alpha1.ErrRestartRefused if the restart policy
+// is "never". Reconciliation is all-or-nothing: a failed reconciliation is reverted to the
+// configuration running before (unless SuppressRollback is on), and if even the revert fails then
+// the last working configuration, i.e., the last one that was reconciled successfully, is
+// reconciled from scratch, so a failed reconciliation never leaves a half-configured server behind.
+// Rollbacks are counted in the stunner_config_rollbacks_total metric. Reconcile is safe to call
+// concurrently, reconciliations are serialized. Applied configs are recorded in the audit trail
+// with the "library" source, use ReconcileFrom to record the actual source
 func (s *Stunner) Reconcile(req v1alpha1.StunnerConfig) error {
-	s.log.Debugf("reconciling STUNner for config: %#v ", req)
+	return s.ReconcileFrom(req, ConfigSource{Kind: ConfigSourceLibrary})
+}
 
-	rollback := s.GetConfig()
-	restart := false
+// updateConfig reconciles the running config modified by the given function, atomically with
+// respect to other reconciliations, and records the source of the change in the audit trail
+func (s *Stunner) updateConfig(src ConfigSource, update func(c *v1alpha1.StunnerConfig) error) error {
+	s.reconcileLock.Lock()
+	defer s.reconcileLock.Unlock()
 
-	// admin
-	adminState, err := s.adminManager.PrepareReconciliation([]v1alpha1.Config{&req.Admin})
-	if err != nil {
-		if err == v1alpha1.ErrRestartRequired {
-			restart = true
-		} else {
-			return fmt.Errorf("error preparing reconciliation for admin config: %s", err.Error())
-		}
+	c := s.GetConfig()
+	if err := update(c); err != nil {
+		return err
 	}
 
-	// auth
-	authState, err := s.authManager.PrepareReconciliation([]v1alpha1.Config{&req.Auth})
-	if err != nil {
-		if err == v1alpha1.ErrRestartRequired {
-			restart = true
-		} else {
-			return fmt.Errorf("error preparing reconciliation for auth config: %s", err.Error())
-		}
+	s.configSource = src
+	s.publishEvent(ConfigEventReceived, c, "")
+	return s.reconcile(*c, s.reconcileMode())
+}
+
+// reconcileMode returns the mode for regular reconciliations
+func (s *Stunner) reconcileMode() reconcileMode {
+	if s.options.SuppressRollback {
+		return reconcileNoRevert
 	}
+	return reconcileRevert
+}
 
-	// listener
+// reconcileMode controls how reconcile handles failures and restarts
+type reconcileMode int
+
+const (
+	// reconcileRevert reverts failed reconciliations
+	reconcileRevert reconcileMode = iota
+	// reconcileNoRevert leaves failed reconciliations as they are
+	reconcileNoRevert
+	// reconcileRollback is a rollback after a failed reconciliation: it is never reverted and it
+	// is not subject to the restart policy
+	reconcileRollback
+)
+
+// reconcileStep is the reconciliation of the objects of a single manager within a reconciliation
+// transaction
+type reconcileStep struct {
+	kind    string
+	manager manager.Manager
+	confs   []v1alpha1.Config
+	state   *manager.ReconciliationState
+}
+
+// reconcileSteps returns the reconciliation steps for a config, in the order of the commit
+func (s *Stunner) reconcileSteps(req *v1alpha1.StunnerConfig) []*reconcileStep {
 	lconf := make([]v1alpha1.Config, len(req.Listeners))
 	for i := range req.Listeners {
 		lconf[i] = &(req.Listeners[i])
 	}
-	listenerState, err := s.listenerManager.PrepareReconciliation(lconf)
-	if err != nil {
-		if err == v1alpha1.ErrRestartRequired {
-			restart = true
-		} else {
-			return fmt.Errorf("error preparing reconciliation for listener config: %s", err.Error())
-		}
-	}
-
-	// cluster
 	cconf := make([]v1alpha1.Config, len(req.Clusters))
 	for i := range req.Clusters {
 		cconf[i] = &(req.Clusters[i])
 	}
-	clusterState, err := s.clusterManager.PrepareReconciliation(cconf)
-	if err != nil {
-		if err == v1alpha1.ErrRestartRequired {
-			restart = true
-		} else {
-			return fmt.Errorf("error preparing reconciliation for cluster config: %s", err.Error())
+
+	return []*reconcileStep{
+		{kind: "admin", manager: s.adminManager, confs: []v1alpha1.Config{&req.Admin}},
+		{kind: "auth", manager: s.authManager, confs: []v1alpha1.Config{&req.Auth}},
+		{kind: "listener", manager: s.listenerManager, confs: lconf},
+		{kind: "cluster", manager: s.clusterManager, confs: cconf},
+	}
+}
+
+// checkConfig runs the checks of a new config that span several objects, and so cannot be done
+// by the object managers, and returns whether the config requires a restart on top of the
+// restarts required by the changed objects. Both the reconciliation and the dry-run plan run the
+// same checks, so that a plan never accepts a config the reconciliation refuses
+func (s *Stunner) checkConfig(req *v1alpha1.StunnerConfig) (bool, error) {
+	if err := s.checkFIPS(req); err != nil {
+		return false, fmt.Errorf("configuration refused in FIPS mode: %s", err.Error())
+	}
+	if !req.Admin.InsecureProtocolsAllowed() {
+		if err := v1alpha1.ValidateInsecureProtocols(req); err != nil {
+			return false, fmt.Errorf("configuration refused: %s", err.Error())
+		}
+	}
+	if err := v1alpha1.ValidateTenants(req); err != nil {
+		return false, fmt.Errorf("configuration refused: %s", err.Error())
+	}
+	if err := v1alpha1.ValidateSecretDiscovery(req); err != nil {
+		return false, fmt.Errorf("configuration refused: %s", err.Error())
+	}
+
+	// the listeners pick up the FIPS mode on restart
+	return req.Admin.FIPSMode != s.GetConfig().Admin.FIPSMode && len(req.Listeners) > 0, nil
+}
+
+// reconcile runs a reconciliation as a transaction: the new config is validated and the changes
+// are computed for all objects first, then the new objects are created without making them
+// visible, and finally the changes are committed object manager by object manager. If any step
+// fails then the steps already committed are reverted in reverse order, so that the running
+// config is never left in a mixed old/new state
+func (s *Stunner) reconcile(req v1alpha1.StunnerConfig, mode reconcileMode) error {
+	s.log.Debugf("reconciling STUNner for config: %#v ", req)
+
+	rollback := s.GetConfig()
+	restart := false
+
+	// rollbacks are internal, only report the outcome of the original reconciliation
+	event := func(t ConfigEventType, msg string) {
+		if mode != reconcileRollback {
+			s.publishEvent(t, &req, msg)
+		}
+	}
+
+	// prepare: validate and compute the jobs
+	steps := s.reconcileSteps(&req)
+	for _, step := range steps {
+		state, err := step.manager.PrepareReconciliation(step.confs)
+		if err != nil {
+			if err == v1alpha1.ErrRestartRequired {
+				restart = true
+			} else {
+				err = fmt.Errorf("error preparing reconciliation for %s config: %s",
+					step.kind, err.Error())
+				event(ConfigEventFailed, err.Error())
+				return err
+			}
 		}
+		step.state = state
+	}
+
+	checkRestart, err := s.checkConfig(&req)
+	if err != nil {
+		event(ConfigEventFailed, err.Error())
+		return err
 	}
+	restart = restart || checkRestart
 
 	s.log.Debugf("reconciliation preparation ready, restart required: %t", restart)
+	event(ConfigEventValidated, "")
+
+	// apply the restart policy of the new config if the server is already running
+	drain := false
+	if restart && mode != reconcileRollback && len(s.listenerManager.Keys()) > 0 {
+		switch policy, _ := v1alpha1.NewRestartPolicy(req.Admin.RestartPolicy); policy {
+		case v1alpha1.RestartPolicyNever:
+			s.log.Warnf("configuration change requires a server restart: refused by restart "+
+				"policy %q", policy.String())
+			s.restartRefused.Store(fmt.Sprintf("generation %d requires a restart refused by "+
+				"restart policy %q", req.Generation, policy.String()))
+			event(ConfigEventFailed, v1alpha1.ErrRestartRefused.Error())
+			return v1alpha1.ErrRestartRefused
+		case v1alpha1.RestartPolicyGraceful:
+			drain = true
+		}
+	}
+
+	// stage: create the new objects, nothing is visible yet
+	for i, step := range steps {
+		if err := step.manager.StageReconciliation(step.state); err != nil {
+			s.log.Errorf("could not stage %s config: %s", step.kind, err.Error())
+			for _, staged := range steps[:i] {
+				staged.manager.AbortReconciliation(staged.state)
+			}
+			err = fmt.Errorf("could not reconcile %s config: %w", step.kind, err)
+			event(ConfigEventFailed, err.Error())
+			return err
+		}
+	}
+
+	// take over the sockets of the process upgraded from and the sockets passed by socket
+	// activation before any port is bound
+	if !s.options.DryRun {
+		s.upgradeFrom()
+		s.activateSockets()
+	}
 
 	// a restart may be needed: close the running server while it still holds the actual
 	// configuration (i.e., before actually calling Reconcile on all objects)
+	if restart {
+		event(ConfigEventRestartRequired, "")
+	}
 	if restart && !s.options.DryRun {
+		if drain {
+			s.drain(time.Duration(req.Admin.DrainTimeout) * time.Second)
+		}
 		s.log.Debug("closing running server")
 		s.Stop()
 	}
 
-	// finish reconciliation
-	// admin
-	new, deleted, changed := 0, 0, 0
-	var errFinal error
+	// commit
+	committed, errFinal := s.commitReconciliation(steps, restart)
+	if errFinal == nil && restart && !s.options.DryRun {
+		s.log.Debugf("restarting for conf: %#v", req)
+		if err := s.Start(); err != nil {
+			s.log.Errorf("could not restart: %s", err.Error())
+			errFinal = err
+		}
+	}
 
-	err = s.adminManager.FinishReconciliation(adminState)
-	if err != nil {
-		s.log.Errorf("could not reconcile admin config: %s", err.Error())
-		errFinal = err
-		goto rollback
+	if errFinal == nil {
+		new, deleted, changed := 0, 0, 0
+		for _, step := range steps {
+			new += len(step.state.NewJobQueue)
+			changed += len(step.state.ChangedJobQueue)
+			deleted += len(step.state.DeletedJobQueue)
+		}
+		s.log.Infof("reconciliation ready: generation: %d, new objects: %d, changed objects: %d, "+
+			"deleted objects: %d", req.Generation, new, changed, deleted)
+
+		src := s.configSource
+		if mode == reconcileRollback {
+			src = ConfigSource{Kind: ConfigSourceRollback, Origin: s.configSource.String()}
+		}
+		s.commitConfig(rollback, req.Generation, src)
+		event(ConfigEventApplied, "")
+		if restart {
+			return v1alpha1.ErrRestartRequired
+		}
+		return nil
 	}
 
-	s.log.Infof("setting loglevel to %q", s.GetAdmin().LogLevel)
-	s.logger.SetLevel(s.GetAdmin().LogLevel)
+	if mode != reconcileRevert {
+		event(ConfigEventFailed, errFinal.Error())
+		return errFinal
+	}
 
-	new += len(adminState.NewJobQueue)
-	changed += len(adminState.ChangedJobQueue)
-	deleted += len(adminState.DeletedJobQueue)
+	s.log.Warnf("configuration rejected: %s, reverting reconciliation", errFinal.Error())
 
-	// auth
-	err = s.authManager.FinishReconciliation(authState)
-	if err != nil {
-		s.log.Errorf("could not reconcile auth config: %s", err.Error())
-		errFinal = err
-		goto rollback
+	// revert the committed steps in reverse order
+	var errRevert error
+	for i := committed - 1; i >= 0; i-- {
+		if err := steps[i].manager.RevertReconciliation(steps[i].state); err != nil {
+			s.log.Errorf("could not revert %s config: %s", steps[i].kind, err.Error())
+			if errRevert == nil {
+				errRevert = err
+			}
+		}
+	}
+	if errRevert == nil {
+		if err := s.reconcileAdminSubsystems(); err != nil {
+			s.log.Errorf("could not revert admin config: %s", err.Error())
+		}
+		if !restart {
+			errRevert = s.reconcileCertStores()
+		} else if !s.options.DryRun {
+			s.Stop()
+			errRevert = s.Start()
+		}
 	}
 
-	new += len(authState.NewJobQueue)
-	changed += len(authState.ChangedJobQueue)
-	deleted += len(authState.DeletedJobQueue)
+	if errRevert == nil {
+		monitoring.ConfigRollbackCounter.WithLabelValues("success").Inc()
+		event(ConfigEventFailed, fmt.Sprintf("%s, reverted to the previous configuration",
+			errFinal.Error()))
+		if restart {
+			return v1alpha1.ErrRestartRequired
+		}
+		return nil
+	}
 
-	// listener
-	err = s.listenerManager.FinishReconciliation(listenerState)
-	if err != nil {
-		s.log.Errorf("could not reconcile listener config: %s", err.Error())
-		errFinal = err
-		goto rollback
+	// the revert failed: fall back to reconciling the last-known-good config from scratch, or the
+	// config running before this reconciliation if nothing was reconciled successfully yet
+	if s.lastGoodConfig != nil {
+		rollback = s.lastGoodConfig
 	}
 
-	if len(s.listenerManager.Keys()) == 0 {
-		s.log.Warn("running with no listeners")
+	s.log.Warnf("could not revert reconciliation: %s, rolling back to last-known-good "+
+		"configuration", errRevert.Error())
+	s.log.Debugf("rolling back to configuration: %#v", rollback)
+
+	// never roll back a rollback
+	err = s.reconcile(*rollback, reconcileRollback)
+	if err != nil && err != v1alpha1.ErrRestartRequired {
+		monitoring.ConfigRollbackCounter.WithLabelValues("failure").Inc()
+		s.log.Errorf("could not roll back to last-known-good configuration: %s",
+			err.Error())
+		err = fmt.Errorf("reconciliation failed: %s, rollback failed: %w",
+			errFinal.Error(), err)
+		event(ConfigEventFailed, err.Error())
+		return err
 	}
 
-	new += len(listenerState.NewJobQueue)
-	changed += len(listenerState.ChangedJobQueue)
-	deleted += len(listenerState.DeletedJobQueue)
+	monitoring.ConfigRollbackCounter.WithLabelValues("success").Inc()
+	event(ConfigEventFailed, fmt.Sprintf("%s, rolled back to the last-known-good configuration",
+		errFinal.Error()))
+	return err
+}
 
-	// cluster
-	err = s.clusterManager.FinishReconciliation(clusterState)
-	if err != nil {
-		s.log.Errorf("could not reconcile cluster config: %s", err.Error())
-		errFinal = err
-		goto rollback
+// commitReconciliation commits the steps of a reconciliation in order and returns the number of
+// steps committed. On error the failed step leaves its objects unchanged and the remaining steps
+// are aborted, so the committed steps can be reverted to restore the config running before the
+// reconciliation
+func (s *Stunner) commitReconciliation(steps []*reconcileStep, restart bool) (int, error) {
+	for i, step := range steps {
+		err := step.manager.CommitReconciliation(step.state)
+		if err == nil {
+			err = s.afterCommit(step.kind, restart)
+			if err != nil {
+				// count the step so that it is reverted
+				i++
+			}
+		}
+
+		if err != nil {
+			s.log.Errorf("could not reconcile %s config: %s", step.kind, err.Error())
+			for _, rest := range steps[i:] {
+				rest.manager.AbortReconciliation(rest.state)
+			}
+			return i, err
+		}
 	}
 
-	if len(s.clusterManager.Keys()) == 0 {
-		s.log.Warn("running with no clusters: all traffic will be dropped")
+	return len(steps), nil
+}
+
+// reconcileAdminSubsystems applies the admin config to the subsystems living outside the objects,
+// e.g., after the admin config is committed or reverted. All subsystems are reconciled even if one
+// fails, the first error is returned
+func (s *Stunner) reconcileAdminSubsystems() error {
+	s.logger.SetLevel(s.GetAdmin().LogLevel)
+	s.logger.SetFormat(s.GetAdmin().LogFormat)
+	s.logger.SetThrottle(s.GetAdmin().LogThrottleInterval, s.GetAdmin().LogThrottleBurst)
+
+	var errFirst error
+	for _, f := range []func() error{s.reconcileLogFile, s.reconcileSyslog, s.reconcileAccessLog,
+		s.reconcileAuditLog} {
+		if err := f(); err != nil && errFirst == nil {
+			errFirst = err
+		}
 	}
 
-	new += len(clusterState.NewJobQueue)
-	changed += len(clusterState.ChangedJobQueue)
-	deleted += len(clusterState.DeletedJobQueue)
+	s.reconcileTracer()
+	s.reconcileMessageTrace()
+	s.reconcileBans()
+	s.reconcileResourceProfile()
+	s.reconcileQuota()
+	s.reconcileAmplification()
+	s.reconcileMalformed()
+	s.reconcileRequestRate()
+	s.reconcileReplication()
+	s.reconcileHashRing()
+	s.reconcileStandby()
+	s.reconcilePeerPorts()
+	s.reconcileAddressMapping()
+	s.reconcilePolicyPlugin()
+	s.reconcileSecretDiscovery()
+	s.reconcileNotifier()
+	s.reconcileLatencyProbe()
+	s.checkWatermarks()
+
+	return errFirst
+}
 
-	s.log.Infof("reconciliation ready: new objects: %d, changed objects: %d, deleted objects: %d",
-		new, changed, deleted)
+// afterCommit applies the changes that take effect outside the objects after a step is committed
+func (s *Stunner) afterCommit(kind string, restart bool) error {
+	switch kind {
+	case "admin":
+		s.log.Infof("setting loglevel to %q", s.GetAdmin().LogLevel)
+		if err := s.reconcileAdminSubsystems(); err != nil {
+			return err
+		}
+	case "listener":
+		if len(s.listenerManager.Keys()) == 0 {
+			s.log.Warn("running with no listeners")
+		}
+		// the TLS creds of running listeners are updated in place
+		if !restart {
+			if err := s.reconcileCertStores(); err != nil {
+				return fmt.Errorf("could not reconcile listener certificates: %s",
+					err.Error())
+			}
+		}
+		s.updateTenantMetrics()
+		s.reconcileAddressMapping()
+	case "cluster":
+		if len(s.clusterManager.Keys()) == 0 {
+			s.log.Warn("running with no clusters: all traffic will be dropped")
+		}
+		s.checkDNSHealth()
+	}
+	return nil
+}
 
-	if s.options.DryRun {
-		if restart {
-			return v1alpha1.ErrRestartRequired
+// reconcileLogFile redirects the logs to the log file set in the admin config, or back to the
+// default log writer if no log file is set
+func (s *Stunner) reconcileLogFile() error {
+	admin := s.GetAdmin()
+	maxSize := int64(admin.LogMaxSize) * 1024 * 1024
+
+	if s.logFile != nil && s.logFile.Path() == admin.LogFile {
+		s.logFile.SetLimits(maxSize, admin.LogMaxAge, admin.LogMaxBackups)
+		return nil
+	}
+
+	old := s.logFile
+	if admin.LogFile == "" {
+		s.logFile = nil
+		s.logger.SetWriter(s.defaultLogWriter())
+	} else {
+		w, err := logger.NewFileWriter(admin.LogFile, maxSize, admin.LogMaxAge,
+			admin.LogMaxBackups)
+		if err != nil {
+			return err
 		}
+		s.log.Infof("redirecting logs to file %q", admin.LogFile)
+		s.logFile = w
+		s.logger.SetWriter(w)
+	}
+
+	if old != nil {
+		old.Close()
+	}
+	return nil
+}
+
+// reconcileSyslog sends a copy of the logs to the syslog endpoint set in the admin config, or
+// stops sending the logs to syslog if no endpoint is set
+func (s *Stunner) reconcileSyslog() error {
+	admin := s.GetAdmin()
+	if s.syslog != nil && s.syslog.Endpoint() == admin.SyslogEndpoint &&
+		s.syslog.Facility() == admin.SyslogFacility {
 		return nil
 	}
 
-	// no dry-run
-	if restart {
-		s.log.Debugf("restarting for conf: %#v", req)
+	old := s.syslog
+	if admin.SyslogEndpoint == "" {
+		s.syslog = nil
+		s.logger.SetSyslog(nil)
+	} else {
+		w, err := logger.NewSyslogWriter(admin.SyslogEndpoint, admin.SyslogFacility)
+		if err != nil {
+			return err
+		}
+		s.log.Infof("sending logs to syslog endpoint %q", admin.SyslogEndpoint)
+		s.syslog = w
+		s.logger.SetSyslog(w)
+	}
 
-		if err := s.Start(); err != nil {
-			s.log.Errorf("could not restart: %s", err.Error())
-			if s.options.SuppressRollback {
-				return err
-			}
+	if old != nil {
+		old.Close()
+	}
+	return nil
+}
 
-			errFinal = err
-			goto rollback
+// reconcileAccessLog sets the access log of the conntrack table to the sink and the format set in
+// the admin config, or disables access logging if no sink is set
+func (s *Stunner) reconcileAccessLog() error {
+	admin := s.GetAdmin()
+	maxSize := int64(admin.LogMaxSize) * 1024 * 1024
+	if maxSize == 0 {
+		maxSize = int64(v1alpha1.DefaultLogMaxSize) * 1024 * 1024
+	}
+
+	// keep the access log file open if only the format or the rotation settings change
+	var w io.Writer
+	old := s.accessLogFile
+	switch admin.AccessLog {
+	case "":
+		s.conntrack.SetAccessLog(nil)
+		s.accessLogFile = nil
+	case "stdout":
+		w, s.accessLogFile = os.Stdout, nil
+	case "stderr":
+		w, s.accessLogFile = os.Stderr, nil
+	default:
+		if old != nil && old.Path() == admin.AccessLog {
+			old.SetLimits(maxSize, admin.LogMaxAge, admin.LogMaxBackups)
+			w, old = old, nil
+			break
+		}
+		f, err := logger.NewFileWriter(admin.AccessLog, maxSize, admin.LogMaxAge,
+			admin.LogMaxBackups)
+		if err != nil {
+			return fmt.Errorf("could not open access log: %w", err)
 		}
+		w, s.accessLogFile = f, f
+	}
 
-		return v1alpha1.ErrRestartRequired
+	if w != nil {
+		a, err := conntrack.NewAccessLog(w, admin.AccessLogFormat)
+		if err != nil {
+			return err
+		}
+		s.conntrack.SetAccessLog(a)
 	}
 
+	if old != nil {
+		old.Close()
+	}
 	return nil
+}
+
+// reconcileTracer sets the tracer of the conntrack table to export spans to the tracing endpoint
+// set in the admin config, or disables tracing if no endpoint is set
+func (s *Stunner) reconcileTracer() {
+	admin := s.GetAdmin()
+	old := s.conntrack.GetTracer()
+	if old != nil && old.Endpoint() == admin.TracingEndpoint && old.Service() == admin.Name &&
+		old.Ratio() == admin.TracingSampleRatio {
+		return
+	}
+
+	if admin.TracingEndpoint == "" {
+		s.conntrack.SetTracer(nil)
+	} else {
+		s.log.Infof("exporting traces to %s, sample ratio: %g", admin.TracingEndpoint,
+			admin.TracingSampleRatio)
+		s.conntrack.SetTracer(tracing.NewTracer(admin.TracingEndpoint, admin.Name,
+			admin.TracingSampleRatio, s.logger))
+	}
+
+	if old != nil {
+		old.Close()
+	}
+}
+
+// reconcileMessageTrace sets the STUN/TURN messages logged by the conntrack table to the message
+// trace set in the admin config, or disables message tracing
+func (s *Stunner) reconcileMessageTrace() {
+	conf := s.GetAdmin().MessageTrace
+	if conf == nil {
+		s.conntrack.SetMessageTrace(nil)
+		return
+	}
+
+	clients := []*net.IPNet{}
+	for _, c := range conf.Clients {
+		// already validated
+		if prefix, err := parsePrefix(c); err == nil {
+			clients = append(clients, prefix)
+		}
+	}
+	s.log.Debugf("tracing STUN messages: sample ratio: %g, usernames: %v, clients: %v",
+		conf.SampleRatio, conf.Usernames, conf.Clients)
+	s.conntrack.SetMessageTrace(conntrack.NewMessageTrace(conf.SampleRatio, conf.Usernames,
+		clients))
+}
+
+// ObjectChange describes the change a reconciliation would make to an object
+type ObjectChange struct {
+	// Kind is the kind of the object: "admin", "auth", "listener" or "cluster"
+	Kind string `json:"kind"`
+	// Name is the name of the object
+	Name string `json:"name"`
+	// Operation is the change to be made: "create", "update" or "delete"
+	Operation string `json:"operation"`
+	// Restart is true if the change forces a server restart
+	Restart bool `json:"restart"`
+}
+
+// ReconcilePlan reports the changes a reconciliation would make
+type ReconcilePlan struct {
+	// Changes lists the objects that would change, unchanged objects are omitted
+	Changes []ObjectChange `json:"changes"`
+	// RestartRequired is true if the reconciliation would restart the server
+	RestartRequired bool `json:"restart_required"`
+}
+
+// PlanReconcile computes which objects would change and which changes would force a server restart
+// if the running STUNner daemon were reconciled for the given configuration, without applying
+// anything. Returns an error if the configuration would be rejected.
+func (s *Stunner) PlanReconcile(req v1alpha1.StunnerConfig) (*ReconcilePlan, error) {
+	s.log.Debugf("planning reconciliation for config: %#v ", req)
+
+	plan := &ReconcilePlan{Changes: []ObjectChange{}}
+	for _, m := range s.reconcileSteps(&req) {
+		state, err := m.manager.PrepareReconciliation(m.confs)
+		if err != nil && err != v1alpha1.ErrRestartRequired {
+			return nil, fmt.Errorf("invalid %s config: %s", m.kind, err.Error())
+		}
+
+		for _, q := range []struct {
+			op   string
+			jobs []manager.ReconcileJob
+		}{
+			{"create", state.NewJobQueue},
+			{"update", state.ChangedJobQueue},
+			{"delete", state.DeletedJobQueue},
+		} {
+			changes := []ObjectChange{}
+			for _, j := range q.jobs {
+				name := j.Object.ObjectName()
+				if j.NewConfig != nil {
+					name = j.NewConfig.ConfigName()
+				}
+				restart := j.Object.Inspect(j.OldConfig, j.NewConfig)
+				changes = append(changes, ObjectChange{
+					Kind: m.kind, Name: name, Operation: q.op, Restart: restart})
+				plan.RestartRequired = plan.RestartRequired || restart
+			}
+			sort.Slice(changes, func(i, j int) bool { return changes[i].Name < changes[j].Name })
+			plan.Changes = append(plan.Changes, changes...)
+		}
+	}
 
-rollback:
-	if !s.options.SuppressRollback {
-		s.log.Infof("rolling back to previous configuration: %#v", rollback)
-		return s.Reconcile(*rollback)
+	restart, err := s.checkConfig(&req)
+	if err != nil {
+		return nil, err
+	}
+	if restart {
+		// the restart is forced by the change of the admin config
+		for i := range plan.Changes {
+			if plan.Changes[i].Kind == "admin" {
+				plan.Changes[i].Restart = true
+			}
+		}
+		plan.RestartRequired = true
 	}
 
-	return errFinal
+	return plan, nil
 }
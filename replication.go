@@ -0,0 +1,206 @@
+package stunner
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/l7mp/stunner/internal/api"
+	"github.com/l7mp/stunner/internal/monitoring"
+	"github.com/l7mp/stunner/internal/replication"
+	"github.com/l7mp/stunner/pkg/apis/v1alpha1"
+)
+
+const (
+	// replicationPushTimeout is the timeout for pushing the allocation state to a peer
+	replicationPushTimeout = 2 * time.Second
+	// replicationIdleInterval is the period the replication config is rechecked at when disabled
+	replicationIdleInterval = time.Second
+	// maxAPIReplicationRequestSize limits the size of the snapshots posted to the admin API
+	maxAPIReplicationRequestSize = 16 << 20
+)
+
+type replicationConfig struct {
+	token string
+	conf  *v1alpha1.ReplicationConfig
+}
+
+// replicator pushes the allocation state to the other replicas of the gateway
+type replicator struct {
+	// node identifies the replica: the replicas usually share the admin config, and so the name
+	node   string
+	config atomic.Value // replicationConfig
+}
+
+func newReplicator() *replicator {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "stunnerd"
+	}
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	r := &replicator{node: fmt.Sprintf("%s-%s", host, hex.EncodeToString(b))}
+	r.config.Store(replicationConfig{})
+	return r
+}
+
+// reconcileReplication enables the replication of the allocation state with the peers set in the
+// admin config and the other gateway of a hot-standby pair replicating the allocations, or
+// disables the replication and forgets the allocations of the other replicas if none is configured
+func (s *Stunner) reconcileReplication() {
+	admin := s.GetAdmin()
+	conf := admin.Replication.DeepCopy()
+	if sb := admin.Standby; sb != nil && sb.ReplicateAllocations {
+		if conf == nil {
+			conf = &v1alpha1.ReplicationConfig{Interval: v1alpha1.DefaultReplicationInterval}
+		}
+		conf.Peers = append(conf.Peers, sb.Peer)
+	}
+	s.replicator.config.Store(replicationConfig{token: admin.APIToken, conf: conf})
+
+	if conf == nil {
+		s.replication.SetConfig(nil)
+		return
+	}
+	s.replication.SetConfig(&replication.Config{Node: s.replicator.node})
+}
+
+// runReplication periodically pushes the state of the UDP allocations to the peers
+func (s *Stunner) runReplication() {
+	client := &http.Client{Timeout: replicationPushTimeout}
+	for {
+		interval := replicationIdleInterval
+		if c := s.replicator.config.Load().(replicationConfig); c.conf != nil {
+			s.pushReplicationState(client, c)
+			interval = time.Duration(c.conf.Interval) * time.Second
+		}
+
+		select {
+		case <-time.After(interval):
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// pushReplicationState pushes a snapshot of the UDP allocations to each peer
+func (s *Stunner) pushReplicationState(client *http.Client, c replicationConfig) {
+	body, err := json.Marshal(s.replicationSnapshot())
+	if err != nil {
+		s.log.Warnf("could not encode allocation state: %s", err.Error())
+		return
+	}
+
+	for _, peer := range c.conf.Peers {
+		if err := postReplicationState(client, peer, c.token, body); err != nil {
+			monitoring.ReplicationPushCounter.WithLabelValues("failed").Inc()
+			s.log.Debugf("could not push allocation state to replica %s: %s", peer, err.Error())
+			continue
+		}
+		monitoring.ReplicationPushCounter.WithLabelValues("success").Inc()
+	}
+}
+
+func postReplicationState(client *http.Client, peer, token string, body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), replicationPushTimeout)
+	defer cancel()
+	url := strings.TrimSuffix(peer, "/") + "/api/v1/replication"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	return nil
+}
+
+// replicationSnapshot returns the state of the allocations of the UDP listeners
+func (s *Stunner) replicationSnapshot() replication.Snapshot {
+	snapshot := replication.Snapshot{Node: s.replicator.node, Allocations: []replication.Allocation{}}
+	for _, f := range s.conntrack.Flows() {
+		l := s.GetListener(f.Listener)
+		if l == nil || l.Proto != v1alpha1.ListenerProtocolUDP || f.Client == "" || f.Expires == "" {
+			continue
+		}
+		_, p, err := net.SplitHostPort(f.Relay)
+		if err != nil {
+			continue
+		}
+		port, err := strconv.Atoi(p)
+		if err != nil {
+			continue
+		}
+		expires, err := time.ParseDuration(f.Expires)
+		if err != nil || expires < time.Second {
+			continue
+		}
+
+		a := replication.Allocation{Listener: f.Listener, Client: f.Client, Username: f.Username,
+			RelayPort: port, Lifetime: int(expires / time.Second)}
+		for _, p := range f.Permissions {
+			a.Permissions = append(a.Permissions, p.Peer)
+		}
+		for _, ch := range f.Channels {
+			a.Channels = append(a.Channels, replication.Channel{Number: ch.Number, Peer: ch.Peer})
+		}
+		snapshot.Allocations = append(snapshot.Allocations, a)
+	}
+	return snapshot
+}
+
+// GET /api/v1/replication: list the allocations of the other replicas that can be taken over
+// POST /api/v1/replication: replace the allocations of a replica with the posted snapshot
+func (s *Stunner) handleReplication(w http.ResponseWriter, r *http.Request) {
+	// the admin API server checks the token, but the allocations and the keys of the users must
+	// not be open to anyone when no token is set
+	if len(s.adminManager.Keys()) == 0 || s.GetAdmin().APIToken == "" {
+		api.WriteError(w, http.StatusForbidden, errors.New("replication requires an API token"))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		api.WriteJSON(w, http.StatusOK, s.replication.Allocations())
+	case http.MethodPost:
+		snapshot := replication.Snapshot{}
+		if err := json.NewDecoder(io.LimitReader(r.Body, maxAPIReplicationRequestSize)).Decode(&snapshot); err != nil {
+			api.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid snapshot: %s", err.Error()))
+			return
+		}
+		if snapshot.Node == "" {
+			api.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid snapshot: no node name"))
+			return
+		}
+		if !s.replication.Update(snapshot) {
+			api.WriteError(w, http.StatusConflict, fmt.Errorf("replication disabled"))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
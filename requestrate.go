@@ -0,0 +1,17 @@
+package stunner
+
+import (
+	"github.com/l7mp/stunner/internal/ratelimit"
+)
+
+// reconcileRequestRate sets the request rate limits from the admin config, or disables the limits
+// if none is configured
+func (s *Stunner) reconcileRequestRate() {
+	req := s.GetAdmin().RequestRate
+	if req == nil {
+		s.requestRate.SetConfig(nil)
+		return
+	}
+
+	s.requestRate.SetConfig(&ratelimit.Config{RatePerUser: req.RatePerUser})
+}
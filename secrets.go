@@ -0,0 +1,148 @@
+package stunner
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"filippo.io/age"
+
+	"github.com/l7mp/stunner/pkg/apis/v1alpha1"
+)
+
+const (
+	// AgeKeyEnv is the environment variable holding the age identity for decrypting secrets
+	AgeKeyEnv = "STUNNER_AGE_KEY"
+	// AgeKeyFileEnv is the environment variable holding the path of the age identity file
+	AgeKeyFileEnv = "STUNNER_AGE_KEY_FILE"
+)
+
+// encryptedValueRegexp matches age-encrypted config values of the form ENC[age,<base64>]
+var encryptedValueRegexp = regexp.MustCompile(`^ENC\[age,([A-Za-z0-9+/=]+)\]$`)
+
+// ResolveSecrets reads the credentials referenced in the secret_refs of the auth config from files,
+// relative paths taken from dir, and decrypts age-encrypted credentials of the form
+// ENC[age,<base64 ciphertext>] using the age identity set in $STUNNER_AGE_KEY or in the file at
+// $STUNNER_AGE_KEY_FILE. Resolved credentials replace the secret references and the encrypted
+// values in the config.
+func ResolveSecrets(c *v1alpha1.StunnerConfig, dir string) error {
+	if len(c.Auth.SecretRefs) > 0 && c.Auth.Credentials == nil {
+		c.Auth.Credentials = map[string]string{}
+	}
+	for k, file := range c.Auth.SecretRefs {
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(dir, file)
+		}
+		v, err := os.ReadFile(file)
+		if err != nil {
+			return fmt.Errorf("could not read credential %q: %s", k, err.Error())
+		}
+		// files written by editors or "echo" tend to end with a newline
+		c.Auth.Credentials[k] = strings.TrimRight(string(v), "\r\n")
+	}
+	c.Auth.SecretRefs = nil
+
+	var ids []age.Identity
+	if err := decryptCredentials(c.Auth.Credentials, "", &ids); err != nil {
+		return err
+	}
+	for _, t := range c.Auth.Tenants {
+		if err := decryptCredentials(t.Credentials, fmt.Sprintf(" of tenant %q", t.Tenant),
+			&ids); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// decryptCredentials decrypts the age-encrypted credentials in place, loading the age identities
+// on first use
+func decryptCredentials(creds map[string]string, owner string, ids *[]age.Identity) error {
+	for k, v := range creds {
+		m := encryptedValueRegexp.FindStringSubmatch(v)
+		if m == nil {
+			continue
+		}
+
+		if *ids == nil {
+			var err error
+			if *ids, err = ageIdentities(); err != nil {
+				return fmt.Errorf("could not decrypt credential %q%s: %s", k, owner, err.Error())
+			}
+		}
+
+		v, err := decryptSecret(m[1], *ids)
+		if err != nil {
+			return fmt.Errorf("could not decrypt credential %q%s: %s", k, owner, err.Error())
+		}
+		creds[k] = v
+	}
+
+	return nil
+}
+
+// EncryptSecret encrypts a credential for the given age recipients (public keys), in the form
+// expected by ResolveSecrets
+func EncryptSecret(value string, recipients ...string) (string, error) {
+	rs, err := age.ParseRecipients(strings.NewReader(strings.Join(recipients, "\n")))
+	if err != nil {
+		return "", err
+	}
+
+	buf := &bytes.Buffer{}
+	w, err := age.Encrypt(buf, rs...)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.WriteString(w, value); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("ENC[age,%s]", base64.StdEncoding.EncodeToString(buf.Bytes())), nil
+}
+
+func decryptSecret(ciphertext string, ids []age.Identity) (string, error) {
+	ct, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return "", err
+	}
+
+	r, err := age.Decrypt(bytes.NewReader(ct), ids...)
+	if err != nil {
+		return "", err
+	}
+
+	v, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+
+	return string(v), nil
+}
+
+// ageIdentities loads the age identities from the environment
+func ageIdentities() ([]age.Identity, error) {
+	key := os.Getenv(AgeKeyEnv)
+	if key == "" {
+		file := os.Getenv(AgeKeyFileEnv)
+		if file == "" {
+			return nil, fmt.Errorf("no age key: set $%s or $%s", AgeKeyEnv, AgeKeyFileEnv)
+		}
+		raw, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("could not read age key: %s", err.Error())
+		}
+		key = string(raw)
+	}
+
+	return age.ParseIdentities(strings.NewReader(key))
+}
@@ -0,0 +1,385 @@
+package stunner
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/pion/dtls/v2"
+	"github.com/pion/transport/vnet"
+	"github.com/pion/turn/v2"
+
+	"github.com/l7mp/stunner/internal/object"
+	"github.com/l7mp/stunner/internal/ws"
+	"github.com/l7mp/stunner/pkg/apis/v1alpha1"
+)
+
+// Types of the self-test checks
+const (
+	// SelfTestSTUNBinding is a STUN binding request sent to a listener over the loopback
+	SelfTestSTUNBinding = "stun-binding"
+	// SelfTestTURNAllocation is a TURN allocation made on a listener over the loopback
+	SelfTestTURNAllocation = "turn-allocation"
+	// SelfTestPeerRoute is a route lookup toward a cluster endpoint
+	SelfTestPeerRoute = "peer-route"
+	// SelfTestPeerPermission is a permission created toward a cluster endpoint via the
+	// allocation of a listener routing to the cluster
+	SelfTestPeerPermission = "peer-permission"
+	// SelfTestPeerRelay is traffic relayed to a peer via an allocation by the test client
+	SelfTestPeerRelay = "peer-relay"
+)
+
+// Results of the self-test checks
+const (
+	SelfTestPass = "pass"
+	SelfTestFail = "fail"
+	SelfTestSkip = "skip"
+)
+
+const (
+	// selfTestTimeout bounds the checks run against a listener
+	selfTestTimeout = 5 * time.Second
+	// selfTestEndpointSample is the number of endpoints probed per cluster
+	selfTestEndpointSample = 3
+	// selfTestProbePort is the port of the route lookups, no packets are sent to it
+	selfTestProbePort = 9
+	// selfTestCredentialLifetime is the lifetime of the longterm credentials of the self-test
+	selfTestCredentialLifetime = time.Minute
+)
+
+// SelfTestCheck is the result of a check run by the self-test
+type SelfTestCheck struct {
+	// Type is the type of the check: "stun-binding", "turn-allocation", "peer-route",
+	// "peer-permission" or "peer-relay"
+	Type string `json:"type"`
+	// Listener is the name of the listener the check was run against
+	Listener string `json:"listener,omitempty"`
+	// Cluster is the name of the cluster the endpoint was sampled from
+	Cluster string `json:"cluster,omitempty"`
+	// Target is the address the check was run against
+	Target string `json:"target,omitempty"`
+	// Result is "pass", "fail" or "skip"
+	Result string `json:"result"`
+	// Duration is the time the check took in seconds
+	Duration float64 `json:"duration"`
+	// Message describes the outcome of the check
+	Message string `json:"message,omitempty"`
+}
+
+// SelfTestReport is the report of a self-test
+type SelfTestReport struct {
+	// Time is the start time of the self-test
+	Time time.Time `json:"time"`
+	// Passed is true if none of the checks failed
+	Passed bool `json:"passed"`
+	// Checks lists the checks in the order they were run
+	Checks []SelfTestCheck `json:"checks"`
+}
+
+// selfTestSession is the TURN client and the allocation of the self-test on a listener
+type selfTestSession struct {
+	client      *turn.Client
+	conn, relay net.PacketConn
+}
+
+// close deletes the allocation and closes the client
+func (t *selfTestSession) close() {
+	if t.relay != nil {
+		t.relay.Close()
+	}
+	t.client.Close()
+	t.conn.Close()
+}
+
+func (r *SelfTestReport) add(c SelfTestCheck, start time.Time) {
+	c.Duration = time.Since(start).Seconds()
+	if c.Result == SelfTestFail {
+		r.Passed = false
+	}
+	r.Checks = append(r.Checks, c)
+}
+
+// SelfTest runs a connectivity self-test against the running gateway: it sends a STUN binding
+// request and makes a TURN allocation on each listener over the loopback, looks up the route
+// toward a sample of the endpoints of each cluster, and creates a permission toward the sampled
+// endpoints via the allocations of the listeners routing to the cluster. No packets are sent to
+// the endpoints.
+func (s *Stunner) SelfTest() *SelfTestReport {
+	report := &SelfTestReport{Time: time.Now(), Passed: true, Checks: []SelfTestCheck{}}
+	s.log.Infof("running self-test")
+
+	sessions := map[string]*selfTestSession{}
+	for _, name := range s.listenerManager.Keys() {
+		if t := s.selfTestListener(s.GetListener(name), report); t != nil {
+			sessions[name] = t
+		}
+	}
+	defer func() {
+		for _, t := range sessions {
+			t.close()
+		}
+	}()
+
+	for _, name := range s.clusterManager.Keys() {
+		c := s.GetCluster(name)
+		for _, ip := range sampleEndpoints(c, selfTestEndpointSample) {
+			s.selfTestEndpoint(c, ip, sessions, report)
+		}
+	}
+
+	s.log.Infof("self-test finished: passed: %t, checks: %d", report.Passed, len(report.Checks))
+	return report
+}
+
+// selfTestListener sends a STUN binding request and makes an allocation on a listener, and returns
+// the session holding the allocation if the allocation succeeds
+func (s *Stunner) selfTestListener(l *object.Listener, report *SelfTestReport) *selfTestSession {
+	addr := l.Addr
+	if addr.IsUnspecified() {
+		addr = net.IPv4(127, 0, 0, 1)
+		if l.Addr.To4() == nil {
+			addr = net.IPv6loopback
+		}
+	}
+	target := net.JoinHostPort(addr.String(), strconv.Itoa(l.Port))
+
+	start := time.Now()
+	binding := SelfTestCheck{Type: SelfTestSTUNBinding, Listener: l.Name, Target: target,
+		Result: SelfTestFail}
+	allocation := SelfTestCheck{Type: SelfTestTURNAllocation, Listener: l.Name, Target: target,
+		Result: SelfTestFail}
+
+	if s.server == nil {
+		binding.Message = "server not running"
+		allocation.Result, allocation.Message = SelfTestSkip, "server not running"
+		report.add(binding, start)
+		report.add(allocation, start)
+		return nil
+	}
+
+	t, err := s.newSelfTestSession(l, target)
+	if err != nil {
+		binding.Message = err.Error()
+		allocation.Result, allocation.Message = SelfTestSkip, "no connection to listener"
+		report.add(binding, start)
+		report.add(allocation, start)
+		return nil
+	}
+
+	// pending transactions fail when the client is closed
+	timer := time.AfterFunc(selfTestTimeout, t.client.Close)
+	defer timer.Stop()
+
+	if mapped, err := t.client.SendBindingRequest(); err != nil {
+		binding.Message = fmt.Sprintf("binding request failed: %s", err.Error())
+	} else {
+		binding.Result, binding.Message = SelfTestPass, fmt.Sprintf("mapped address: %s", mapped)
+	}
+	report.add(binding, start)
+
+	start = time.Now()
+	relay, err := t.client.Allocate()
+	if err != nil {
+		allocation.Message = fmt.Sprintf("allocation failed: %s", err.Error())
+		report.add(allocation, start)
+		t.close()
+		return nil
+	}
+	t.relay = relay
+	allocation.Result = SelfTestPass
+	allocation.Message = fmt.Sprintf("relay address: %s", relay.LocalAddr())
+	report.add(allocation, start)
+
+	return t
+}
+
+// newSelfTestSession creates a TURN client connected to a listener with the credentials of the
+// running auth config
+func (s *Stunner) newSelfTestSession(l *object.Listener, target string) (*selfTestSession, error) {
+	auth := s.GetAuth().ForTenant(l.Tenant)
+	username, password := auth.Username, auth.Password
+	if auth.Type == v1alpha1.AuthTypeLongTerm {
+		var err error
+		username, password, err = turn.GenerateLongTermCredentials(auth.Secret,
+			selfTestCredentialLifetime)
+		if err != nil {
+			return nil, fmt.Errorf("cannot generate credentials: %s", err.Error())
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), selfTestTimeout)
+	defer cancel()
+
+	// the certificate is not checked: the listener is tested, not the PKI
+	conn, err := dialListener(ctx, s.net, l.Proto, target, &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: true, //nolint:gosec
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := turn.NewClient(&turn.ClientConfig{
+		STUNServerAddr: target,
+		TURNServerAddr: target,
+		Conn:           conn,
+		Username:       username,
+		Password:       password,
+		Realm:          auth.Realm,
+		Net:            s.net,
+		LoggerFactory:  s.logger,
+	})
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("cannot create TURN client: %s", err.Error())
+	}
+	if err := client.Listen(); err != nil {
+		client.Close()
+		conn.Close()
+		return nil, fmt.Errorf("cannot listen on TURN client: %s", err.Error())
+	}
+
+	return &selfTestSession{client: client, conn: conn}, nil
+}
+
+// dialListener connects to a listener at the target address and returns the connection of a TURN
+// client, the TLS config is used on the TLS, DTLS and WSS listeners
+func dialListener(ctx context.Context, n *vnet.Net, proto v1alpha1.ListenerProtocol, target string, tlsConf *tls.Config) (net.PacketConn, error) {
+	switch proto {
+	case v1alpha1.ListenerProtocolUDP:
+		local := "0.0.0.0:0"
+		if host, _, err := net.SplitHostPort(target); err == nil {
+			if ip := net.ParseIP(host); ip != nil && ip.To4() == nil {
+				local = "[::]:0"
+			}
+		}
+		c, err := n.ListenPacket("udp", local)
+		if err != nil {
+			return nil, fmt.Errorf("cannot create client socket: %s", err.Error())
+		}
+		return c, nil
+	case v1alpha1.ListenerProtocolTCP, v1alpha1.ListenerProtocolTLS:
+		c, err := n.Dial("tcp", target)
+		if err != nil {
+			return nil, fmt.Errorf("cannot connect to listener: %s", err.Error())
+		}
+		if proto == v1alpha1.ListenerProtocolTLS {
+			t := tls.Client(c, tlsConf)
+			if err := t.HandshakeContext(ctx); err != nil {
+				c.Close()
+				return nil, fmt.Errorf("TLS handshake failed: %s", err.Error())
+			}
+			c = t
+		}
+		return turn.NewSTUNConn(c), nil
+	case v1alpha1.ListenerProtocolDTLS:
+		c, err := n.Dial("udp", target)
+		if err != nil {
+			return nil, fmt.Errorf("cannot connect to listener: %s", err.Error())
+		}
+		d, err := dtls.ClientWithContext(ctx, c, &dtls.Config{
+			ServerName:         tlsConf.ServerName,
+			RootCAs:            tlsConf.RootCAs,
+			InsecureSkipVerify: tlsConf.InsecureSkipVerify, //nolint:gosec
+		})
+		if err != nil {
+			c.Close()
+			return nil, fmt.Errorf("DTLS handshake failed: %s", err.Error())
+		}
+		return turn.NewSTUNConn(d), nil
+	case v1alpha1.ListenerProtocolWS, v1alpha1.ListenerProtocolWSS:
+		c, err := ws.Dial(proto.String()+"://"+target+"/", tlsConf)
+		if err != nil {
+			return nil, fmt.Errorf("cannot connect to listener: %s", err.Error())
+		}
+		return turn.NewSTUNConn(c), nil
+	}
+	return nil, fmt.Errorf("unknown listener protocol %q", proto.String())
+}
+
+// sampleEndpoints returns the addresses to probe in a cluster: the first address of the endpoint
+// prefixes of static clusters and the resolved addresses of strict DNS clusters, at most n of them
+func sampleEndpoints(c *object.Cluster, n int) []net.IP {
+	ips := []net.IP{}
+	switch c.Type {
+	case v1alpha1.ClusterTypeStatic:
+		for _, e := range c.Endpoints {
+			ones, bits := e.Mask.Size()
+			if ones == 0 {
+				// a default route, no meaningful address to probe
+				continue
+			}
+			ip := make(net.IP, len(e.IP))
+			copy(ip, e.IP)
+			if ones < bits {
+				ip[len(ip)-1]++
+			}
+			ips = append(ips, ip)
+		}
+	case v1alpha1.ClusterTypeStrictDNS:
+		for _, d := range c.Domains {
+			if addrs, err := c.Resolver.Lookup(d); err == nil {
+				ips = append(ips, addrs...)
+			}
+		}
+	}
+
+	if len(ips) > n {
+		ips = ips[:n]
+	}
+	return ips
+}
+
+// selfTestEndpoint looks up the route toward a cluster endpoint and creates a permission toward
+// it via the allocation of each listener routing to the cluster
+func (s *Stunner) selfTestEndpoint(c *object.Cluster, ip net.IP, sessions map[string]*selfTestSession, report *SelfTestReport) {
+	target := net.JoinHostPort(ip.String(), strconv.Itoa(selfTestProbePort))
+
+	start := time.Now()
+	route := SelfTestCheck{Type: SelfTestPeerRoute, Cluster: c.Name, Target: ip.String(),
+		Result: SelfTestPass}
+	// connecting a UDP socket looks up the route without sending any packet
+	if conn, err := s.net.Dial("udp", target); err != nil {
+		route.Result, route.Message = SelfTestFail, fmt.Sprintf("no route: %s", err.Error())
+	} else {
+		route.Message = fmt.Sprintf("local address: %s", conn.LocalAddr())
+		conn.Close()
+	}
+	report.add(route, start)
+
+	for _, name := range s.listenerManager.Keys() {
+		if !routesTo(s.GetListener(name), c.Name) {
+			continue
+		}
+
+		start := time.Now()
+		perm := SelfTestCheck{Type: SelfTestPeerPermission, Listener: name, Cluster: c.Name,
+			Target: ip.String(), Result: SelfTestPass}
+		t, ok := sessions[name]
+		if !ok {
+			perm.Result, perm.Message = SelfTestSkip, "no allocation on listener"
+			report.add(perm, start)
+			continue
+		}
+
+		timer := time.AfterFunc(selfTestTimeout, t.client.Close)
+		if err := t.client.CreatePermission(&net.UDPAddr{IP: ip, Port: selfTestProbePort}); err != nil {
+			perm.Result = SelfTestFail
+			perm.Message = fmt.Sprintf("permission denied: %s", err.Error())
+		}
+		timer.Stop()
+		report.add(perm, start)
+	}
+}
+
+func routesTo(l *object.Listener, cluster string) bool {
+	for _, r := range l.Routes {
+		if r == cluster {
+			return true
+		}
+	}
+	return false
+}
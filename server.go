@@ -1,9 +1,14 @@
 package stunner
 
 import (
+	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"net"
+	"sync/atomic"
+	"time"
 
 	// "strings"
 
@@ -13,59 +18,123 @@ import (
 
 	// "github.com/pion/transport/vnet"
 
+	"github.com/l7mp/stunner/internal/certs"
+	"github.com/l7mp/stunner/internal/discovery"
+	"github.com/l7mp/stunner/internal/icmp"
+	"github.com/l7mp/stunner/internal/lifetime"
+	"github.com/l7mp/stunner/internal/monitoring"
+	"github.com/l7mp/stunner/internal/nat64"
+	"github.com/l7mp/stunner/internal/object"
+	"github.com/l7mp/stunner/internal/strict"
+	"github.com/l7mp/stunner/internal/stunmsg"
+	"github.com/l7mp/stunner/internal/udp"
+	"github.com/l7mp/stunner/internal/ws"
 	"github.com/l7mp/stunner/pkg/apis/v1alpha1"
+	"github.com/l7mp/stunner/pkg/policy"
 )
 
 // Start starts the STUNner server and starts listining on all requested server sockets
-func (s *Stunner) Start() error {
+func (s *Stunner) Start() (err error) {
 	s.log.Infof("STUNner server (re)starting with API version %q", s.version)
 
+	// report the listener that could not be set up to the notifier
+	failed := ""
+	defer func() {
+		if err != nil && failed != "" {
+			s.notify(OperationalEvent{Type: v1alpha1.NotifierEventListenerFailed,
+				Listener: failed, Message: err.Error()})
+		}
+	}()
+
 	auth := s.GetAuth()
 
 	// start listeners
 	var pconn []turn.PacketConnConfig
 	var conn []turn.ListenerConfig
+	s.sockets, s.socketKeys = map[string][]socketFile{}, map[string]string{}
+	s.natDiscovery = map[string]*discovery.Server{}
 
 	listeners := s.listenerManager.Keys()
 	for _, name := range listeners {
+		failed = name
 		l := s.GetListener(name)
+		l.SetRestartPending("")
 
-		relay := &turn.RelayAddressGeneratorPortRange{
-			RelayAddress: l.Addr,
-			Address:      l.Addr.String(),
-			MinPort:      uint16(l.MinPort),
-			MaxPort:      uint16(l.MaxPort),
-			Net:          l.Net,
+		// the relay transports are batched too, right on top of the plain sockets of the listener
+		var relay turn.RelayAddressGenerator = l.NewRelayAddressGenerator()
+		if batchSize := s.udpBatchSize(); batchSize > 0 {
+			relay = udp.NewRelayAddressGenerator(relay, l.Name, batchSize, s.logger)
 		}
+		relay = s.newDrainingRelayAddressGenerator(s.conntrack.NewRelayAddressGenerator(
+			s.addressMap.NewRelayAddressGenerator(s.peerPorts.NewRelayAddressGenerator(
+				nat64.NewRelayAddressGenerator(icmp.NewRelayAddressGenerator(
+					s.replication.NewRelayAddressGenerator(relay, l.Name),
+					s.handleICMPError),
+					func() *net.IPNet { return s.GetAdmin().NAT64Prefix }), l.Name),
+				l.Name), l.Name), l)
 
 		addr := fmt.Sprintf("%s:%d", l.Addr.String(), l.Port)
 
 		switch l.Proto {
 		case v1alpha1.ListenerProtocolUDP:
 			s.log.Debugf("setting up UDP listener at %s", addr)
-			udpListener, err := l.Net.ListenPacket("udp", addr)
+			udpListeners, err := s.listenPacket(l, addr, s.udpThreadNum())
 			if err != nil {
-				return fmt.Errorf("failed to create UDP listener at %s: %s",
-					addr, err)
+				return fmt.Errorf("failed to create UDP listener at %s: %s", addr, err)
 			}
 
-			l.Conn = turn.PacketConnConfig{
-				PacketConn:            udpListener,
-				RelayAddressGenerator: relay,
-				PermissionHandler:     s.NewPermissionHandler(l),
+			if l.AlternatePort != 0 {
+				d, err := discovery.NewServer(discovery.Config{
+					Listener: l.Name,
+					Addr:     l.Addr,
+					Port:     l.Port,
+					AltAddr:  net.ParseIP(l.AlternateAddr),
+					AltPort:  l.AlternatePort,
+				}, s.listenAlternate(l), s.logger)
+				if err != nil {
+					for _, c := range udpListeners {
+						c.Close()
+					}
+					return fmt.Errorf("failed to set up NAT behavior discovery for UDP "+
+						"listener at %s: %s", addr, err)
+				}
+				s.natDiscovery[l.Name] = d
+			}
+
+			// each worker socket gets its own read loop and allocation table in the TURN
+			// server, the kernel hashes client flows to workers
+			workers := make([]turn.PacketConnConfig, len(udpListeners))
+			for i, udpListener := range udpListeners {
+				if batchSize := s.udpBatchSize(); batchSize > 0 {
+					udpListener = udp.NewBatchConn(udpListener, l.Name, batchSize, s.logger)
+				}
+
+				if s.options.UDPListenerCPUAffinity && !l.Net.IsVirtual() {
+					udpListener = udp.NewPinnedConn(udpListener, i, s.logger)
+				}
+
+				// the sockets handed over on upgrade are switched below the protocol filters
+				udpListener = s.handover.NewPacketConn(udpListener, socketKey(l.Proto, addr), i)
+
+				workers[i] = turn.PacketConnConfig{
+					PacketConn:            s.newPacketConn(udpListener, l),
+					RelayAddressGenerator: relay,
+					PermissionHandler:     s.NewPermissionHandler(l),
+				}
 			}
+			l.Conn = workers
 
-			pconn = append(pconn, l.Conn.(turn.PacketConnConfig))
+			pconn = append(pconn, workers...)
 
 			// cannot test this on vnet, no Listen/ListenTCP in vnet.Net
 		case v1alpha1.ListenerProtocolTCP:
 			s.log.Debugf("setting up TCP listener at %s", addr)
-			tcpListener, err := net.Listen("tcp", addr)
+			tcpListener, err := s.listenStream(l, addr)
 			if err != nil {
 				return fmt.Errorf("failed to create TCP listener at %s: %s", addr, err)
 			}
 			l.Conn = turn.ListenerConfig{
-				Listener:              tcpListener,
+				Listener:              s.newListener(tcpListener, l),
 				RelayAddressGenerator: relay,
 				PermissionHandler:     s.NewPermissionHandler(l),
 			}
@@ -75,21 +144,24 @@ func (s *Stunner) Start() error {
 			// cannot test this on vnet, no TLS in vnet.Net
 		case v1alpha1.ListenerProtocolTLS:
 			s.log.Debugf("setting up TLS/TCP listener at %s", addr)
-			cer, errTls := tls.LoadX509KeyPair(l.Cert, l.Key)
+			store, errTls := s.newCertStore(l, nil)
 			if errTls != nil {
 				return fmt.Errorf("cannot load cert/key pair for creating TLS listener at %s: %s",
 					addr, errTls)
 			}
 
-			tlsListener, err := tls.Listen("tcp", addr, &tls.Config{
-				MinVersion:   tls.VersionTLS12,
-				Certificates: []tls.Certificate{cer},
-			})
+			tlsConf, err := s.newTLSConfig(l, store)
+			if err != nil {
+				return fmt.Errorf("cannot set up TLS listener at %s: %s", addr, err)
+			}
+
+			// rotated certificates take effect on the next handshake
+			tcpListener, err := s.listenStream(l, addr)
 			if err != nil {
 				return fmt.Errorf("failed to create TLS listener at %s: %s", addr, err)
 			}
 			l.Conn = turn.ListenerConfig{
-				Listener:              tlsListener,
+				Listener:              s.newListener(tls.NewListener(tcpListener, tlsConf), l),
 				RelayAddressGenerator: relay,
 				PermissionHandler:     s.NewPermissionHandler(l),
 			}
@@ -100,24 +172,78 @@ func (s *Stunner) Start() error {
 		case v1alpha1.ListenerProtocolDTLS:
 			s.log.Debugf("setting up DTLS/UDP listener at %s", addr)
 
-			cer, errTls := tls.LoadX509KeyPair(l.Cert, l.Key)
+			// pion/dtls cannot swap certificates on a running listener
+			name, listener := l.Name, l
+			store, errTls := s.newCertStore(l, func(*tls.Certificate) {
+				s.log.Warnf("certificate rotated for DTLS listener %q: the new certificate "+
+					"takes effect on the next restart", name)
+				listener.SetRestartPending("certificate rotated")
+			})
 			if errTls != nil {
 				return fmt.Errorf("cannot load cert/key pair for creating DTLS listener at %s: %s",
 					addr, errTls)
 			}
+			cer := *store.Certificate()
+			dtlsConf := &dtls.Config{
+				Certificates: []tls.Certificate{cer},
+				// ExtendedMasterSecret: dtls.RequireExtendedMasterSecret,
+			}
+
+			clientCAs, verify, err := s.newClientAuth(l)
+			if err != nil {
+				return fmt.Errorf("cannot set up DTLS listener at %s: %s", addr, err)
+			}
+			if clientCAs != nil {
+				dtlsConf.ClientAuth = dtls.RequireAndVerifyClientCert
+				dtlsConf.ClientCAs = clientCAs
+				dtlsConf.VerifyPeerCertificate = verify
+			}
+
+			// dtls.Listen opens its own socket: only the relay sockets get the socket options
+			if !l.SocketOptions().IsZero() {
+				s.log.Warnf("listener %s: socket options are not supported for DTLS listeners, "+
+					"applying to the relay sockets only", l.Name)
+			}
 
 			// for some reason dtls.Listen requires a UDPAddr and not an addr string
 			udpAddr := &net.UDPAddr{IP: l.Addr, Port: l.Port}
-			dtlsListener, err := dtls.Listen("udp", udpAddr, &dtls.Config{
-				Certificates: []tls.Certificate{cer},
-				// ExtendedMasterSecret: dtls.RequireExtendedMasterSecret,
-			})
+			dtlsListener, err := dtls.Listen("udp", udpAddr, dtlsConf)
 			if err != nil {
 				return fmt.Errorf("failed to create DTLS listener at %s: %s", addr, err)
 			}
 
 			l.Conn = turn.ListenerConfig{
-				Listener:              dtlsListener,
+				Listener:              s.newListener(dtlsListener, l),
+				RelayAddressGenerator: relay,
+				PermissionHandler:     s.NewPermissionHandler(l),
+			}
+
+			conn = append(conn, l.Conn.(turn.ListenerConfig))
+
+			// cannot test this on vnet, no Listen/ListenTCP in vnet.Net
+		case v1alpha1.ListenerProtocolWS, v1alpha1.ListenerProtocolWSS:
+			s.log.Debugf("setting up %s listener at %s", l.Proto.String(), addr)
+			var tlsConf *tls.Config
+			if l.Proto == v1alpha1.ListenerProtocolWSS {
+				store, errTls := s.newCertStore(l, nil)
+				if errTls != nil {
+					return fmt.Errorf("cannot load cert/key pair for creating WSS listener at %s: %s",
+						addr, errTls)
+				}
+				conf, err := s.newTLSConfig(l, store)
+				if err != nil {
+					return fmt.Errorf("cannot set up WSS listener at %s: %s", addr, err)
+				}
+				tlsConf = conf
+			}
+
+			tcpListener, err := s.listenStream(l, addr)
+			if err != nil {
+				return fmt.Errorf("failed to create %s listener at %s: %s", l.Proto.String(),
+					addr, err)
+			}
+			l.Conn = turn.ListenerConfig{
+				Listener:              s.newListener(ws.NewListener(tcpListener, tlsConf, s.logger), l),
 				RelayAddressGenerator: relay,
 				PermissionHandler:     s.NewPermissionHandler(l),
 			}
@@ -129,6 +255,9 @@ func (s *Stunner) Start() error {
 		}
 	}
 
+	failed = ""
+	s.finishUpgrade()
+
 	// start the DNS resolver threads
 	if s.resolver == nil {
 		s.resolver.Start()
@@ -161,7 +290,336 @@ func (s *Stunner) Stop() {
 	s.log.Info("stopping the STUNner server")
 
 	if s.server != nil {
+		// the allocations are closed in the background
+		s.conntrack.Terminating()
 		s.server.Close()
 	}
 	s.server = nil
+	atomic.StoreInt32(&s.draining, 0)
+
+	for _, c := range s.certStores {
+		c.Close()
+	}
+	s.certStores = nil
+	for _, c := range s.crls {
+		c.Close()
+	}
+	s.crls = nil
+	for _, d := range s.natDiscovery {
+		d.Close()
+	}
+	s.natDiscovery = nil
+}
+
+// drainPollInterval is the period of checking the active allocations while draining
+const drainPollInterval = 100 * time.Millisecond
+
+// errServerDraining is returned for allocation requests while the server is draining
+var errServerDraining = errors.New("server is draining for restart")
+
+// drain stops accepting new allocations and waits until the active allocations are closed or the
+// timeout expires
+func (s *Stunner) drain(timeout time.Duration) {
+	if s.server == nil {
+		return
+	}
+
+	atomic.StoreInt32(&s.draining, 1)
+	s.log.Infof("draining %d active allocations before restart, timeout: %s",
+		s.server.AllocationCount(), timeout)
+
+	deadline := time.Now().Add(timeout)
+	for s.server.AllocationCount() > 0 && time.Now().Before(deadline) {
+		time.Sleep(drainPollInterval)
+	}
+
+	if n := s.server.AllocationCount(); n > 0 {
+		s.log.Warnf("drain timeout expired, terminating %d active allocations", n)
+	}
+}
+
+// drainListeners makes the listeners refuse new allocations and waits until the active
+// allocations of the listeners are closed or the timeout expires, and returns the number of
+// allocations still active. The listeners keep refusing new allocations until updated or deleted
+func (s *Stunner) drainListeners(ctx context.Context, ls []*object.Listener, timeout time.Duration) (int, error) {
+	active := func() int {
+		n := 0
+		for _, l := range ls {
+			n += s.conntrack.ListenerLen(l.Name)
+		}
+		return n
+	}
+
+	for _, l := range ls {
+		l.SetDraining(true)
+		s.log.Infof("draining listener %q: %d active allocations, timeout: %s", l.Name,
+			s.conntrack.ListenerLen(l.Name), timeout)
+	}
+
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+	for active() > 0 {
+		select {
+		case <-ticker.C:
+		case <-deadline.C:
+			return active(), nil
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+
+	return 0, nil
+}
+
+// errListenerDraining is returned for allocation requests on a draining listener
+var errListenerDraining = errors.New("listener is draining")
+
+// drainingRelayAddressGenerator refuses new allocations while the server or the listener is
+// draining
+type drainingRelayAddressGenerator struct {
+	turn.RelayAddressGenerator
+	draining *int32
+	listener *object.Listener
+}
+
+// newPacketConn wraps the socket of a packet listener: the packets of the sources refused by the
+// source ACL of the listener and of the banned sources are dropped, the allocations of the clients
+// of other replicas of the hash ring are counted or redirected, the replicated allocations of the
+// other replicas are taken over, the malformed packets, the unauthenticated requests over the
+// amplification limits and the messages failing the STUN message checks of the listener are
+// dropped, the lifetime requested for the allocations is cut to the maximum of the listener, the
+// Binding requests are answered by the NAT behavior discovery server of the listener, if any, the
+// requests over the request rate of the user are dropped, the rest are tracked in the conntrack
+// table, the Allocate requests over a quota or denied by the policy plugin are rejected, and the
+// clients of the listeners of a tenant are recorded with the tenant
+func (s *Stunner) newPacketConn(conn net.PacketConn, l *object.Listener) net.PacketConn {
+	key := s.keyFunc(l)
+	conn = s.bans.NewPacketConn(l.NewACLPacketConn(conn), l.Name)
+	conn = s.hashRing.NewPacketConn(conn, l.Name, l.Port, key)
+	conn = s.replication.NewPacketConn(conn, l.Name, key,
+		func(client net.Addr) bool { return s.conntrack.ClientSessionID(l.Name, client) != "" })
+	conn = s.malformed.NewPacketConn(conn, l.Name)
+	conn = s.amplification.NewPacketConn(conn, l.Name, key)
+	conn = s.newLifetimeClamper(l).NewPacketConn(s.newStrictChecker(l).NewPacketConn(conn))
+	if d, ok := s.natDiscovery[l.Name]; ok {
+		conn = d.NewPacketConn(conn)
+	}
+	conn = s.requestRate.NewPacketConn(conn, l.Name)
+	conn = s.quota.NewPacketConn(s.conntrack.NewPacketConn(conn, l.Name), l.Name)
+	conn = s.policy.NewPacketConn(conn, key, func(client net.Addr, username, realm string) *policy.Request {
+		return s.newAllocationRequest(l, client, username, realm)
+	})
+	return s.tenants.NewPacketConn(conn, l.Tenant)
+}
+
+// newListener wraps the socket of a stream listener: the connections of the sources refused by the
+// source ACL of the listener and of the banned sources and the connections starting with a
+// malformed message are closed, the messages failing the STUN message checks of the listener are
+// dropped, the lifetime requested for the allocations is cut to the maximum of the listener, the
+// requests over the request rate of the user are dropped, the rest are tracked in the conntrack
+// table, the Allocate requests over a quota or denied by the policy plugin are rejected, and the
+// clients of the listeners of a tenant are recorded with the tenant
+func (s *Stunner) newListener(ln net.Listener, l *object.Listener) net.Listener {
+	ln = s.malformed.NewListener(s.bans.NewListener(l.NewACLListener(ln), l.Name), l.Name)
+	ln = s.newStrictChecker(l).NewListener(ln)
+	ln = s.requestRate.NewListener(s.newLifetimeClamper(l).NewListener(ln), l.Name)
+	ln = s.quota.NewListener(s.conntrack.NewListener(ln, l.Name), l.Name)
+	ln = s.policy.NewListener(ln, s.keyFunc(l), func(client net.Addr, username, realm string) *policy.Request {
+		return s.newAllocationRequest(l, client, username, realm)
+	})
+	return s.tenants.NewListener(ln, l.Tenant)
+}
+
+// keyFunc returns the long-term keys of the running auth config of the tenant of a listener, for
+// the socket filters checking the integrity of the messages
+func (s *Stunner) keyFunc(l *object.Listener) stunmsg.KeyFunc {
+	return func(username, realm string, _ net.Addr) ([]byte, bool) {
+		key, err := s.authKey(s.GetAuth().ForTenant(l.Tenant), username, realm)
+		return key, err == nil
+	}
+}
+
+// newStrictChecker creates the STUN message checker of a listener, checking the integrity of the
+// indications with the keys of the running auth config of the tenant of the listener
+func (s *Stunner) newStrictChecker(l *object.Listener) *strict.Checker {
+	return strict.NewChecker(l.Name, l.StrictPolicy, s.keyFunc(l), s.logger)
+}
+
+// newLifetimeClamper creates the allocation lifetime clamper of a listener, recomputing the
+// integrity of the rewritten requests with the keys of the running auth config of the tenant of
+// the listener
+func (s *Stunner) newLifetimeClamper(l *object.Listener) *lifetime.Clamper {
+	return lifetime.NewClamper(l.Name, func() lifetime.Policy { return s.lifetimes(l.Name) },
+		s.keyFunc(l), s.logger)
+}
+
+// lifetimes returns the lifetimes of a listener: the lifetimes set for the listener override the
+// lifetimes of the gateway
+func (s *Stunner) lifetimes(listener string) lifetime.Policy {
+	p := lifetime.Policy{}
+	if a, found := s.adminManager.Get(v1alpha1.DefaultAdminName); found {
+		admin := a.(*object.Admin)
+		p = lifetime.Policy{
+			Permission:    admin.PermissionLifetime,
+			Channel:       admin.ChannelLifetime,
+			MaxAllocation: admin.MaxAllocationLifetime,
+		}
+	}
+	if l := s.GetListener(listener); l != nil {
+		lp := l.LifetimePolicy()
+		if lp.Permission > 0 {
+			p.Permission = lp.Permission
+		}
+		if lp.Channel > 0 {
+			p.Channel = lp.Channel
+		}
+		if lp.MaxAllocation > 0 {
+			p.MaxAllocation = lp.MaxAllocation
+		}
+	}
+	return p
+}
+
+func (s *Stunner) newDrainingRelayAddressGenerator(gen turn.RelayAddressGenerator, l *object.Listener) turn.RelayAddressGenerator {
+	return &drainingRelayAddressGenerator{RelayAddressGenerator: gen, draining: &s.draining, listener: l}
+}
+
+func (g *drainingRelayAddressGenerator) check() error {
+	if atomic.LoadInt32(g.draining) == 1 {
+		return errServerDraining
+	}
+	if g.listener.IsDraining() {
+		return errListenerDraining
+	}
+	return nil
+}
+
+func (g *drainingRelayAddressGenerator) AllocatePacketConn(network string, requestedPort int) (net.PacketConn, net.Addr, error) {
+	if err := g.check(); err != nil {
+		return nil, nil, err
+	}
+	return g.RelayAddressGenerator.AllocatePacketConn(network, requestedPort)
+}
+
+func (g *drainingRelayAddressGenerator) AllocateConn(network string, requestedPort int) (net.Conn, net.Addr, error) {
+	if err := g.check(); err != nil {
+		return nil, nil, err
+	}
+	return g.RelayAddressGenerator.AllocateConn(network, requestedPort)
+}
+
+// newCertStore loads the certificate of a TLS/DTLS listener, from the cert/key files or the SDS
+// secret of the listener, and starts watching it for rotation
+func (s *Stunner) newCertStore(l *object.Listener, onRotate func(*tls.Certificate)) (*certs.Store, error) {
+	var c *certs.Store
+	var err error
+	if l.SDSSecret != "" {
+		if s.sds == nil {
+			return nil, fmt.Errorf("no SDS endpoint for secret %q", l.SDSSecret)
+		}
+		c, err = certs.NewSecretStore(l.Name, l.SDSSecret, s.sds, s.options.CertReloadInterval,
+			onRotate, s.logger)
+	} else {
+		c, err = certs.NewStore(l.Name, l.Cert, l.Key, s.options.CertReloadInterval, onRotate,
+			s.logger)
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.certStores = append(s.certStores, c)
+	return c, nil
+}
+
+// newClientAuth loads the client CAs of a TLS/DTLS listener, and the CRL issued by the client CAs
+// if set, returning the CA pool to verify the client certificates against and the revocation
+// check. Returns a nil pool if the listener does not require client certificates
+func (s *Stunner) newClientAuth(l *object.Listener) (*x509.CertPool, func([][]byte, [][]*x509.Certificate) error, error) {
+	if l.ClientCA == "" {
+		return nil, nil, nil
+	}
+	pool, cas, err := certs.LoadCertPool(l.ClientCA)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot load client CA: %s", err)
+	}
+	if l.ClientCRL == "" {
+		return pool, nil, nil
+	}
+	crl, err := certs.NewCRL(l.Name, l.ClientCRL, cas, s.options.CertReloadInterval, s.logger)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot load client CRL: %s", err)
+	}
+	s.crls = append(s.crls, crl)
+	return pool, crl.VerifyPeerCertificate, nil
+}
+
+// reconcileCertStores points the certificate stores of the running listeners to the current
+// cert/key files or SDS secrets of the listeners, so that credential updates take effect without a
+// restart
+func (s *Stunner) reconcileCertStores() error {
+	for _, c := range s.certStores {
+		l := s.GetListener(c.Name())
+		if l == nil {
+			continue
+		}
+		if l.SDSSecret != "" {
+			if s.sds == nil {
+				return fmt.Errorf("no SDS endpoint for secret %q of listener %q",
+					l.SDSSecret, l.Name)
+			}
+			if err := c.SetSecret(s.sds, l.SDSSecret); err != nil {
+				return fmt.Errorf("cannot load SDS secret for listener %q: %s", l.Name, err)
+			}
+			continue
+		}
+		if err := c.SetFiles(l.Cert, l.Key); err != nil {
+			return fmt.Errorf("cannot load cert/key pair for listener %q: %s", l.Name, err)
+		}
+	}
+	return nil
+}
+
+// reconcileSecretDiscovery connects to the secret discovery service of the admin config, the
+// certificate stores are switched to the new client when the listeners are reconciled
+func (s *Stunner) reconcileSecretDiscovery() {
+	addr := s.GetAdmin().SDSEndpoint
+	if s.sds != nil && s.sds.Addr() == addr {
+		return
+	}
+	if s.sds != nil {
+		s.sds.Close()
+		s.sds = nil
+	}
+	if addr != "" {
+		s.log.Infof("secret discovery service at %s", addr)
+		s.sds = certs.NewSecretClient(addr, s.GetAdmin().Name, s.logger)
+	}
+}
+
+// handleICMPError forwards the ICMP errors received on relay transports to the clients
+func (s *Stunner) handleICMPError(relay net.Addr, e *icmp.Error) {
+	session := s.conntrack.SessionID(relay)
+	monitoring.AddWithSessionExemplar(monitoring.ICMPErrorCounter.WithLabelValues(e.Reason()),
+		session)
+
+	peer := e.Peer
+	// the client may know the peer by its NAT64 address
+	if v6, ok := nat64.Synthesize(peer.IP, s.GetAdmin().NAT64Prefix); ok &&
+		s.conntrack.NotifyICMP(relay, &net.UDPAddr{IP: v6, Port: peer.Port}, e.Type, e.Code, e.Info) == nil {
+		return
+	}
+
+	// or by the external address of an address mapping
+	for _, ext := range s.addressMap.ExternalAddrs(peer) {
+		if s.conntrack.NotifyICMP(relay, ext, e.Type, e.Code, e.Info) == nil {
+			return
+		}
+	}
+
+	if err := s.conntrack.NotifyICMP(relay, peer, e.Type, e.Code, e.Info); err != nil {
+		s.log.Debugf("dropping %s on relay %s, session %s: %s", e.String(), relay, session,
+			err.Error())
+	}
 }
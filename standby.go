@@ -0,0 +1,519 @@
+package stunner
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/l7mp/stunner/internal/api"
+	"github.com/l7mp/stunner/internal/monitoring"
+	"github.com/l7mp/stunner/internal/standby"
+	"github.com/l7mp/stunner/internal/vip"
+	"github.com/l7mp/stunner/pkg/apis/v1alpha1"
+)
+
+const (
+	// standbyHeartbeatTimeout is the timeout for a heartbeat exchange with the peer
+	standbyHeartbeatTimeout = time.Second
+	// standbyIdleInterval is the period the standby config is rechecked at when disabled
+	standbyIdleInterval = time.Second
+	// maxAPIStandbyRequestSize limits the size of the heartbeats posted to the admin API
+	maxAPIStandbyRequestSize = 16 << 20
+	// standbyMACHeader carries the HMAC of the heartbeat keyed with the API token
+	standbyMACHeader = "X-Stunner-Heartbeat-Mac"
+	// standbyMaxHeartbeatAge is the maximum age of a heartbeat, bounding the replays
+	standbyMaxHeartbeatAge = 30 * time.Second
+)
+
+// StandbyStatus is the response of the /api/v1/standby admin API
+type StandbyStatus struct {
+	// Node identifies the gateway
+	Node string `json:"node"`
+	// Role is the role of the gateway in the pair: active or standby
+	Role string `json:"role"`
+	// State is the state of the gateway: active or standby
+	State string `json:"state"`
+	// Ready is true if the gateway is ready to take over
+	Ready bool `json:"ready"`
+	// VirtualIP is the virtual IP held by the gateway, if any
+	VirtualIP string `json:"virtual_ip,omitempty"`
+	// Peer is the admin API URL of the other gateway
+	Peer string `json:"peer"`
+	// PeerState is the state of the other gateway in its last heartbeat, if any
+	PeerState string `json:"peer_state,omitempty"`
+	// PeerReady is true if the other gateway was ready to take over in its last heartbeat
+	PeerReady bool `json:"peer_ready"`
+	// LastHeartbeat is the time of the last heartbeat of the other gateway, if any
+	LastHeartbeat string `json:"last_heartbeat,omitempty"`
+}
+
+type standbyConfig struct {
+	token string
+	conf  *v1alpha1.StandbyConfig
+}
+
+// hotStandby is the state of the gateway in a hot-standby pair
+type hotStandby struct {
+	config atomic.Value // standbyConfig
+	pair   *standby.Pair
+	// vip and vipIface are the virtual IP held and its interface, if any
+	vip      *net.IPNet
+	vipIface string
+	lock     sync.Mutex
+}
+
+func newHotStandby() *hotStandby {
+	sb := &hotStandby{}
+	sb.config.Store(standbyConfig{})
+	return sb
+}
+
+// getPair returns the state machine of the pair, or nil if disabled
+func (sb *hotStandby) getPair() *standby.Pair {
+	sb.lock.Lock()
+	defer sb.lock.Unlock()
+	return sb.pair
+}
+
+// reconcileStandby sets up the hot-standby pair from the admin config: a new state machine is
+// created, in standby state, only if the pair is enabled or the role changes. If disabled, the
+// virtual IP is released
+func (s *Stunner) reconcileStandby() {
+	admin := s.GetAdmin()
+	conf := admin.Standby.DeepCopy()
+	s.standby.config.Store(standbyConfig{token: admin.APIToken, conf: conf})
+
+	s.standby.lock.Lock()
+	if conf == nil {
+		s.standby.pair = nil
+	} else {
+		timeout := time.Duration(conf.FailoverTimeout) * time.Second
+		if s.standby.pair == nil || s.standby.pair.Role() != conf.Role {
+			s.standby.pair = standby.NewPair(conf.Role, timeout, time.Now())
+			s.log.Infof("hot standby enabled: role: %s, peer: %s", conf.Role, conf.Peer)
+		} else {
+			s.standby.pair.SetTimeout(timeout)
+		}
+		if !conf.MirrorsConfig() {
+			s.standby.pair.SetReady(true)
+		}
+	}
+	pair := s.standby.pair
+	s.standby.lock.Unlock()
+
+	active := pair != nil && pair.State() == standby.Active
+	s.setVirtualIP(active)
+	monitoring.StandbyActiveGauge.Set(boolToFloat(active))
+	if pair == nil {
+		monitoring.StandbyPeerReadyGauge.Set(0)
+	}
+}
+
+// runStandby periodically exchanges heartbeats with the other gateway of the pair and checks the
+// failover timeout
+func (s *Stunner) runStandby() {
+	client := &http.Client{Timeout: standbyHeartbeatTimeout}
+	for {
+		interval := standbyIdleInterval
+		if c := s.standby.config.Load().(standbyConfig); c.conf != nil {
+			interval = time.Duration(c.conf.HeartbeatInterval) * time.Second
+		}
+
+		select {
+		case <-time.After(interval):
+		case <-s.done:
+			return
+		}
+
+		if c := s.standby.config.Load().(standbyConfig); c.conf != nil {
+			s.exchangeStandbyHeartbeat(client, c)
+		}
+	}
+}
+
+// exchangeStandbyHeartbeat sends a heartbeat to the peer and processes its response, mirroring the
+// config of an active peer if needed, then checks the failover timeout
+func (s *Stunner) exchangeStandbyHeartbeat(client *http.Client, c standbyConfig) {
+	pair := s.standby.getPair()
+	if pair == nil {
+		return
+	}
+
+	hb := s.standbyHeartbeat(pair, c.conf)
+	res, err := postStandbyHeartbeat(client, c.conf.Peer, c.token, hb)
+	if err != nil {
+		s.log.Debugf("hot standby: no heartbeat from peer %s: %s", c.conf.Peer, err.Error())
+	} else {
+		if res.Config != nil && hb.WantConfig {
+			s.mirrorStandbyConfig(res.Config, c.conf.Peer)
+		}
+		s.receiveStandbyHeartbeat(pair, c.conf, res)
+	}
+
+	s.applyStandbyAction(pair.Tick(time.Now()))
+}
+
+// standbyHeartbeat returns the heartbeat of the gateway
+func (s *Stunner) standbyHeartbeat(pair *standby.Pair, conf *v1alpha1.StandbyConfig) standby.Heartbeat {
+	state := pair.State()
+	return standby.Heartbeat{
+		Node:       s.replicator.node,
+		Role:       conf.Role,
+		State:      state,
+		Ready:      pair.Ready(),
+		Release:    pair.Releasing(),
+		ConfigHash: standbyConfigHash(s.GetConfig()),
+		WantConfig: conf.Role == standby.Standby && state == standby.Standby && conf.MirrorsConfig(),
+	}
+}
+
+// receiveStandbyHeartbeat processes a heartbeat of the peer: a standby mirroring the config is
+// ready once its config is the config of the active peer
+func (s *Stunner) receiveStandbyHeartbeat(pair *standby.Pair, conf *v1alpha1.StandbyConfig, hb *standby.Heartbeat) {
+	if conf.MirrorsConfig() && hb.State == standby.Active {
+		pair.SetReady(hb.ConfigHash == standbyConfigHash(s.GetConfig()))
+	}
+	monitoring.StandbyPeerReadyGauge.Set(boolToFloat(hb.Ready))
+	s.applyStandbyAction(pair.Receive(*hb, time.Now()))
+}
+
+// mirrorStandbyConfig applies the config of the active peer, keeping the name and the standby
+// settings of the gateway
+func (s *Stunner) mirrorStandbyConfig(c *v1alpha1.StunnerConfig, peer string) {
+	admin := s.GetAdmin()
+	c.Admin.Name = admin.Name
+	c.Admin.Standby = admin.Standby.DeepCopy()
+	restoreStandbySecrets(c, s.GetConfig())
+
+	s.log.Infof("hot standby: mirroring config of active peer %s", peer)
+	err := s.ReconcileFrom(*c, ConfigSource{Kind: ConfigSourceStandby, Origin: peer})
+	if err != nil && err != v1alpha1.ErrRestartRequired {
+		s.log.Errorf("hot standby: could not mirror config of active peer %s: %s", peer,
+			err.Error())
+	}
+}
+
+// applyStandbyAction takes over or releases the virtual IP on a state change of the gateway
+func (s *Stunner) applyStandbyAction(a standby.Action) {
+	switch {
+	case a.TakeOver != "":
+		s.log.Infof("hot standby: taking over as the active gateway (reason: %s)", a.TakeOver)
+		monitoring.StandbyTakeoverCounter.WithLabelValues(a.TakeOver).Inc()
+		monitoring.StandbyActiveGauge.Set(1)
+		s.setVirtualIP(true)
+	case a.StepDown:
+		s.log.Warn("hot standby: peer is active, stepping down to standby")
+		monitoring.StandbyActiveGauge.Set(0)
+		s.setVirtualIP(false)
+	}
+}
+
+// setVirtualIP adds the virtual IP of the pair to its interface if the gateway is active, and
+// removes the virtual IP held otherwise, or if the virtual IP was changed
+func (s *Stunner) setVirtualIP(active bool) {
+	sb := s.standby
+	sb.lock.Lock()
+	defer sb.lock.Unlock()
+
+	var want *net.IPNet
+	iface := ""
+	if c := sb.config.Load().(standbyConfig); active && c.conf != nil && c.conf.VirtualIP != "" {
+		ip, n, err := net.ParseCIDR(c.conf.VirtualIP)
+		if err == nil {
+			n.IP = ip
+			want, iface = n, c.conf.Interface
+		}
+	}
+
+	if sb.vip != nil && (want == nil || sb.vip.String() != want.String() || sb.vipIface != iface) {
+		if err := vip.Remove(sb.vipIface, sb.vip); err != nil {
+			s.log.Errorf("hot standby: could not remove virtual IP %s from %s: %s", sb.vip,
+				sb.vipIface, err.Error())
+		} else {
+			s.log.Infof("hot standby: removed virtual IP %s from %s", sb.vip, sb.vipIface)
+		}
+		sb.vip, sb.vipIface = nil, ""
+	}
+
+	if want != nil && sb.vip == nil {
+		if err := vip.Add(iface, want); err != nil {
+			s.log.Errorf("hot standby: could not add virtual IP %s to %s: %s", want, iface,
+				err.Error())
+			return
+		}
+		s.log.Infof("hot standby: added virtual IP %s to %s", want, iface)
+		sb.vip, sb.vipIface = want, iface
+	}
+}
+
+// closeStandby releases the virtual IP on shutdown and asks the peer to take over
+func (s *Stunner) closeStandby() {
+	c := s.standby.config.Load().(standbyConfig)
+	pair := s.standby.getPair()
+	if pair == nil || !pair.Release() {
+		return
+	}
+
+	s.log.Info("hot standby: releasing virtual IP on shutdown")
+	monitoring.StandbyActiveGauge.Set(0)
+	s.setVirtualIP(false)
+	client := &http.Client{Timeout: standbyHeartbeatTimeout}
+	if _, err := postStandbyHeartbeat(client, c.conf.Peer, c.token,
+		s.standbyHeartbeat(pair, c.conf)); err != nil {
+		s.log.Warnf("hot standby: could not ask peer %s to take over: %s", c.conf.Peer,
+			err.Error())
+	}
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// restoreStandbySecrets fills in the secrets redacted from the config of the active peer with the
+// secrets of the local config: the credentials of the gateway and the tenants, the API token and
+// the notifier headers are never sent over the wire, and so must be set on both gateways
+func restoreStandbySecrets(c, local *v1alpha1.StunnerConfig) {
+	c.Auth.Credentials = restoreCredentials(c.Auth.Credentials, local.Auth.Credentials)
+	for i, t := range c.Auth.Tenants {
+		var own map[string]string
+		for _, lt := range local.Auth.Tenants {
+			if lt.Tenant == t.Tenant {
+				own = lt.Credentials
+			}
+		}
+		c.Auth.Tenants[i].Credentials = restoreCredentials(t.Credentials, own)
+	}
+	if c.Admin.APIToken == redacted {
+		c.Admin.APIToken = local.Admin.APIToken
+	}
+	if n := c.Admin.Notifier; n != nil {
+		var own map[string]string
+		if local.Admin.Notifier != nil {
+			own = local.Admin.Notifier.Headers
+		}
+		n.Headers = restoreCredentials(n.Headers, own)
+	}
+}
+
+// restoreCredentials replaces the redacted values with the local values of the same keys, and
+// drops the redacted values with no local value
+func restoreCredentials(c, own map[string]string) map[string]string {
+	for k, v := range c {
+		if v != redacted {
+			continue
+		}
+		if o, ok := own[k]; ok {
+			c[k] = o
+		} else {
+			delete(c, k)
+		}
+	}
+	return c
+}
+
+// signStandbyHeartbeat stamps a heartbeat with the current time and encodes it, returning the
+// encoded heartbeat and its HMAC keyed with the API token
+func signStandbyHeartbeat(token string, hb standby.Heartbeat) ([]byte, string, error) {
+	hb.Time = time.Now().Unix()
+	body, err := json.Marshal(hb)
+	if err != nil {
+		return nil, "", err
+	}
+	return body, standbyMAC(token, body), nil
+}
+
+// verifyStandbyHeartbeat checks the HMAC and the age of an encoded heartbeat and decodes it
+func verifyStandbyHeartbeat(token, mac string, body []byte) (*standby.Heartbeat, error) {
+	if token == "" {
+		return nil, errors.New("no API token")
+	}
+	if !hmac.Equal([]byte(mac), []byte(standbyMAC(token, body))) {
+		return nil, errors.New("invalid heartbeat MAC")
+	}
+	hb := &standby.Heartbeat{}
+	if err := json.Unmarshal(body, hb); err != nil {
+		return nil, fmt.Errorf("invalid heartbeat: %s", err.Error())
+	}
+	if age := time.Since(time.Unix(hb.Time, 0)); age > standbyMaxHeartbeatAge ||
+		age < -standbyMaxHeartbeatAge {
+		return nil, fmt.Errorf("stale heartbeat: sent %s ago", age.Truncate(time.Second))
+	}
+	return hb, nil
+}
+
+// standbyMAC returns the HMAC-SHA256 of an encoded heartbeat keyed with the API token
+func standbyMAC(token string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(token))
+	_, _ = mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func postStandbyHeartbeat(client *http.Client, peer, token string, hb standby.Heartbeat) (*standby.Heartbeat, error) {
+	body, mac, err := signStandbyHeartbeat(token, hb)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), standbyHeartbeatTimeout)
+	defer cancel()
+	url := strings.TrimSuffix(peer, "/") + "/api/v1/standby"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set(standbyMACHeader, mac)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status: %s", resp.Status)
+	}
+	res, err := io.ReadAll(io.LimitReader(resp.Body, maxAPIStandbyRequestSize))
+	if err != nil {
+		return nil, err
+	}
+	return verifyStandbyHeartbeat(token, resp.Header.Get(standbyMACHeader), res)
+}
+
+// standbyConfigHash returns the hash of a config without the name and the standby settings of the
+// gateway and with the secrets redacted, as mirrored by the standby
+func standbyConfigHash(c *v1alpha1.StunnerConfig) string {
+	admin := c.Admin
+	admin.Name, admin.Standby = "", nil
+	m := *c
+	m.Admin = admin
+	RedactConfig(&m)
+	b, err := json.Marshal(&m)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:16])
+}
+
+// standbyStatus returns the status of the gateway in the pair
+func (s *Stunner) standbyStatus(pair *standby.Pair, conf *v1alpha1.StandbyConfig) StandbyStatus {
+	st := StandbyStatus{Node: s.replicator.node, Role: conf.Role, State: pair.State(),
+		Ready: pair.Ready(), Peer: conf.Peer}
+	s.standby.lock.Lock()
+	if s.standby.vip != nil {
+		st.VirtualIP = s.standby.vip.String()
+	}
+	s.standby.lock.Unlock()
+	if hb, last := pair.Peer(); !last.IsZero() {
+		st.PeerState, st.PeerReady = hb.State, hb.Ready
+		st.LastHeartbeat = last.Format(time.RFC3339)
+	}
+	return st
+}
+
+// GET /api/v1/standby: show the state of the gateway and its peer in the hot-standby pair
+// POST /api/v1/standby: process the heartbeat of the peer and respond with the heartbeat of the
+// gateway, along with the config of an active gateway if asked for and changed, with the secrets
+// redacted. The heartbeats are authenticated with an HMAC keyed with the API token
+func (s *Stunner) handleStandby(w http.ResponseWriter, r *http.Request) {
+	c := s.standby.config.Load().(standbyConfig)
+	pair := s.standby.getPair()
+	if pair == nil {
+		api.WriteError(w, http.StatusConflict, fmt.Errorf("hot standby disabled"))
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		api.WriteJSON(w, http.StatusOK, s.standbyStatus(pair, c.conf))
+	case http.MethodPost:
+		// the admin API server checks the token, but the heartbeats must not be accepted
+		// from anyone when no token is set
+		if c.token == "" {
+			api.WriteError(w, http.StatusForbidden, errors.New("hot standby requires an API token"))
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(r.Body, maxAPIStandbyRequestSize))
+		if err != nil {
+			api.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid heartbeat: %s", err.Error()))
+			return
+		}
+		hb, err := verifyStandbyHeartbeat(c.token, r.Header.Get(standbyMACHeader), body)
+		if err != nil {
+			s.log.Warnf("hot standby: refusing heartbeat: %s", err.Error())
+			api.WriteError(w, http.StatusUnauthorized, err)
+			return
+		}
+		if hb.Node == "" || (hb.State != standby.Active && hb.State != standby.Standby) {
+			api.WriteError(w, http.StatusBadRequest, fmt.Errorf("invalid heartbeat: no node or state"))
+			return
+		}
+		s.receiveStandbyHeartbeat(pair, c.conf, hb)
+
+		res := s.standbyHeartbeat(pair, c.conf)
+		if hb.WantConfig && res.State == standby.Active && hb.ConfigHash != res.ConfigHash {
+			res.Config = s.GetConfig()
+			RedactConfig(res.Config)
+		}
+		body, mac, err := signStandbyHeartbeat(c.token, res)
+		if err != nil {
+			api.WriteError(w, http.StatusInternalServerError, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set(standbyMACHeader, mac)
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(body)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// POST /api/v1/standby/release: release the virtual IP of the active gateway and ask the peer to
+// take over, refused unless the peer is ready
+func (s *Stunner) handleStandbyRelease(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	c := s.standby.config.Load().(standbyConfig)
+	pair := s.standby.getPair()
+	if pair == nil {
+		api.WriteError(w, http.StatusConflict, fmt.Errorf("hot standby disabled"))
+		return
+	}
+	if pair.State() != standby.Active {
+		api.WriteError(w, http.StatusConflict, fmt.Errorf("gateway is not active"))
+		return
+	}
+	if hb, _ := pair.Peer(); !hb.Ready {
+		api.WriteError(w, http.StatusConflict, fmt.Errorf("peer is not ready to take over"))
+		return
+	}
+
+	if pair.Release() {
+		s.log.Info("hot standby: releasing virtual IP on request")
+		monitoring.StandbyActiveGauge.Set(0)
+		s.setVirtualIP(false)
+		s.exchangeStandbyHeartbeat(&http.Client{Timeout: standbyHeartbeatTimeout}, c)
+	}
+	api.WriteJSON(w, http.StatusOK, s.standbyStatus(pair, c.conf))
+}
@@ -0,0 +1,180 @@
+package stunner
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/l7mp/stunner/internal/object"
+	"github.com/l7mp/stunner/pkg/apis/v1alpha1"
+)
+
+// ObjectStatus is the runtime status of a STUNner object
+type ObjectStatus = object.ObjectStatus
+
+// Condition is an aspect of the status of an object or the gateway
+type Condition = object.Condition
+
+// RunningConfig is the effective configuration of the running daemon with the status of each object
+type RunningConfig struct {
+	// Config is the running config, with secrets redacted
+	Config *v1alpha1.StunnerConfig `json:"config"`
+	// Status lists the status of the running objects
+	Status []ObjectStatus `json:"status"`
+}
+
+// GatewayStatus is the aggregated status of the running daemon
+type GatewayStatus struct {
+	// Ready is true if all objects are ready, same as the Ready condition
+	Ready bool `json:"ready"`
+	// Conditions are the Ready, Degraded and RestartPending conditions of the gateway
+	Conditions []Condition `json:"conditions"`
+	// Objects lists the status of the running objects
+	Objects []ObjectStatus `json:"objects"`
+}
+
+// redacted is the placeholder for secrets in the running configuration
+const redacted = "<redacted>"
+
+// GetStatus returns the runtime status of all objects of the running STUNner daemon
+func (s *Stunner) GetStatus() []ObjectStatus {
+	status := []ObjectStatus{}
+
+	if len(s.adminManager.Keys()) > 0 {
+		status = append(status, s.GetAdmin().Status())
+	}
+
+	if len(s.authManager.Keys()) > 0 {
+		status = append(status, s.GetAuth().Status())
+	}
+
+	for _, name := range s.listenerManager.Keys() {
+		status = append(status, s.GetListener(name).Status())
+	}
+
+	for _, name := range s.clusterManager.Keys() {
+		status = append(status, s.GetCluster(name).Status())
+	}
+
+	return status
+}
+
+// GetGatewayStatus aggregates the status of the objects into the status of the gateway: the gateway
+// is ready if all objects are ready, degraded if any object is degraded or not ready, and has a
+// restart pending if any object does or a restart was refused by the restart policy
+func (s *Stunner) GetGatewayStatus() *GatewayStatus {
+	objects := s.GetStatus()
+
+	notReady, degraded, pending := []string{}, []string{}, []string{}
+	for _, o := range objects {
+		name := fmt.Sprintf("%s/%s", o.Kind, o.Name)
+		if !o.Ready {
+			notReady = append(notReady, name)
+		}
+		if o.Condition(object.ConditionDegraded).Status {
+			degraded = append(degraded, name)
+		}
+		if o.Condition(object.ConditionRestartPending).Status {
+			pending = append(pending, name)
+		}
+	}
+
+	ready := Condition{Status: true, Reason: "AsExpected"}
+	if len(objects) == 0 {
+		ready = Condition{Reason: "NotConfigured", Message: "no configuration"}
+	} else if len(notReady) > 0 {
+		ready = Condition{Reason: "ObjectsNotReady",
+			Message: fmt.Sprintf("objects not ready: %s", strings.Join(notReady, ", "))}
+	}
+
+	deg := Condition{Reason: "AsExpected"}
+	if len(notReady)+len(degraded) > 0 {
+		deg = Condition{Status: true, Reason: "ObjectsDegraded",
+			Message: fmt.Sprintf("objects degraded or not ready: %s",
+				strings.Join(append(notReady, degraded...), ", "))}
+	}
+
+	// resource usage above the watermarks degrades the gateway, and makes it not ready if
+	// requested so that new clients are steered to other instances
+	if exceeded := s.watermarks.Exceeded(); len(exceeded) > 0 {
+		msg := fmt.Sprintf("watermarks exceeded: %s", strings.Join(exceeded, ", "))
+		if !deg.Status {
+			deg = Condition{Status: true, Reason: "WatermarkExceeded", Message: msg}
+		}
+		if w := s.GetAdmin().Watermarks; ready.Status && w != nil && w.DegradeReadiness {
+			ready = Condition{Reason: "WatermarkExceeded", Message: msg}
+		}
+	}
+
+	// failing DNS resolution of a STRICT_DNS cluster degrades the gateway, and makes it not ready
+	// if requested
+	if failing := s.dnsHealth.Failing(); len(failing) > 0 {
+		msg := fmt.Sprintf("DNS resolution failing: %s", strings.Join(failing, "; "))
+		if !deg.Status {
+			deg = Condition{Status: true, Reason: "DNSResolutionFailing", Message: msg}
+		}
+		if d := s.GetAdmin().DNSHealth; ready.Status && d != nil && d.DegradeReadiness {
+			ready = Condition{Reason: "DNSResolutionFailing", Message: msg}
+		}
+	}
+
+	restart := Condition{Reason: "AsExpected"}
+	if refused, ok := s.restartRefused.Load().(string); ok && refused != "" {
+		restart = Condition{Status: true, Reason: "RestartRefused", Message: refused}
+	} else if len(pending) > 0 {
+		restart = Condition{Status: true, Reason: "RestartRequired",
+			Message: fmt.Sprintf("objects pending restart: %s", strings.Join(pending, ", "))}
+	}
+
+	st := object.NewObjectStatus("gateway", "", ready, deg, restart)
+	return &GatewayStatus{Ready: st.Ready, Conditions: st.Conditions, Objects: objects}
+}
+
+// GetRunningConfig returns the effective configuration of the running daemon with secrets
+// redacted, plus the status of each object
+func (s *Stunner) GetRunningConfig() *RunningConfig {
+	c := s.GetConfig()
+	RedactConfig(c)
+
+	return &RunningConfig{Config: c, Status: s.GetStatus()}
+}
+
+// RedactConfig replaces the secrets in a config, i.e., the password and the shared secret among
+// the credentials of the gateway and the tenants, the admin API token and the notifier headers,
+// with a placeholder. The credentials maps, the tenant list and the notifier config are copied
+func RedactConfig(c *v1alpha1.StunnerConfig) {
+	c.Auth.Credentials = redactCredentials(c.Auth.Credentials)
+	if len(c.Auth.Tenants) > 0 {
+		tenants := make([]v1alpha1.TenantAuthConfig, len(c.Auth.Tenants))
+		for i, t := range c.Auth.Tenants {
+			t.Credentials = redactCredentials(t.Credentials)
+			tenants[i] = t
+		}
+		c.Auth.Tenants = tenants
+	}
+	if c.Admin.APIToken != "" {
+		c.Admin.APIToken = redacted
+	}
+	if c.Admin.Notifier != nil {
+		n := c.Admin.Notifier.DeepCopy()
+		for k := range n.Headers {
+			n.Headers[k] = redacted
+		}
+		c.Admin.Notifier = n
+	}
+}
+
+// redactCredentials returns a copy of the credentials with the password and the shared secret
+// redacted
+func redactCredentials(c map[string]string) map[string]string {
+	if len(c) == 0 {
+		return c
+	}
+	creds := make(map[string]string, len(c))
+	for k, v := range c {
+		if k == "password" || k == "secret" {
+			v = redacted
+		}
+		creds[k] = v
+	}
+	return creds
+}
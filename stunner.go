@@ -3,18 +3,43 @@ package stunner
 
 import (
 	"fmt"
+	"io"
+	"net"
+	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/pion/logging"
 	"github.com/pion/transport/vnet"
 	"github.com/pion/turn/v2"
-
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/l7mp/stunner/internal/addrmap"
+	"github.com/l7mp/stunner/internal/amplification"
+	"github.com/l7mp/stunner/internal/api"
+	"github.com/l7mp/stunner/internal/ban"
+	"github.com/l7mp/stunner/internal/certs"
+	"github.com/l7mp/stunner/internal/conntrack"
+	"github.com/l7mp/stunner/internal/discovery"
+	"github.com/l7mp/stunner/internal/handover"
+	"github.com/l7mp/stunner/internal/hashring"
 	"github.com/l7mp/stunner/internal/logger"
+	"github.com/l7mp/stunner/internal/malformed"
 	"github.com/l7mp/stunner/internal/manager"
 	"github.com/l7mp/stunner/internal/monitoring"
 	"github.com/l7mp/stunner/internal/object"
+	"github.com/l7mp/stunner/internal/peerport"
+	"github.com/l7mp/stunner/internal/plugin"
+	"github.com/l7mp/stunner/internal/quota"
+	"github.com/l7mp/stunner/internal/ratelimit"
+	"github.com/l7mp/stunner/internal/replication"
 	"github.com/l7mp/stunner/internal/resolver"
+	"github.com/l7mp/stunner/internal/tenant"
 	"github.com/l7mp/stunner/pkg/apis/v1alpha1"
+	"github.com/l7mp/stunner/pkg/clock"
+	"github.com/l7mp/stunner/pkg/policy"
 )
 
 const DefaultLogLevel = "all:WARN"
@@ -35,8 +60,60 @@ type Options struct {
 	// Resolver swaps the internal DNS resolver with a custom implementation (used mostly for
 	// testing)
 	Resolver resolver.DnsResolver
+	// UDPBatchSize enables batched UDP I/O (recvmmsg/sendmmsg and UDP GSO, where available) on
+	// UDP listeners and relay transports, reading and writing up to the given number of datagrams
+	// per syscall. Default is 0, which disables batching. Ignored on non-Linux platforms and over
+	// vnet
+	UDPBatchSize int
+	// UDPListenerThreadNum is the number of worker threads per UDP listener. Each worker gets a
+	// separate socket bound to the listener address with SO_REUSEPORT, along with its own read
+	// loop and allocation table, and the kernel hashes client flows to workers. Default is 0,
+	// which runs a single worker; -1 runs one worker per CPU. Ignored on non-Linux platforms
+	// and over vnet
+	UDPListenerThreadNum int
+	// UDPListenerCPUAffinity pins the n-th worker of each UDP listener to the n-th CPU. Ignored
+	// on non-Linux platforms and over vnet
+	UDPListenerCPUAffinity bool
+	// CertReloadInterval is the period for checking the certificate files of TLS/DTLS listeners
+	// for rotation. Default is 0, which checks every 10 seconds; negative values
+	// disable certificate rotation
+	CertReloadInterval time.Duration
+	// StrictConfig rejects config files, remote configs and config plan requests with unknown
+	// fields, like misspelled keys, instead of ignoring them. Default is false
+	StrictConfig bool
+	// ConntrackDumpInterval, if nonzero, makes STUNner periodically dump the connection
+	// tracking table to the log
+	ConntrackDumpInterval time.Duration
+	// AdminSocket, if set, serves the admin API on a local Unix socket at the given path, with
+	// no bearer token required. Access is limited to the owner of the process by the permissions
+	// of the socket file
+	AdminSocket string
+	// UpgradeSocket, if set, makes STUNner hand over its listening sockets to a new process
+	// connecting to a local Unix socket at the given path: the new process serves the new
+	// clients, while STUNner drains its allocations and then closes the HandedOver channel
+	UpgradeSocket string
+	// UpgradeFrom, if set, makes STUNner take over the listening sockets of the process serving
+	// upgrades at the given path on the first start, instead of creating them. If no process
+	// runs there, the sockets are created as usual
+	UpgradeFrom string
+	// SocketActivation makes STUNner use the listening sockets passed by systemd socket
+	// activation for the listeners at the address of the sockets, instead of creating them
+	SocketActivation bool
+	// PolicyPlugin is the in-process policy plugin, consulted on the allocation and permission
+	// decisions if the policy_plugin admin setting is set with no plugin address
+	PolicyPlugin policy.Plugin
+	// Clock is the time source for the expiry of the time-limited credentials, the lifetimes of
+	// the allocations, the permissions and the channel bindings tracked by STUNner, the bans, the
+	// allocation quotas, the request rate and amplification limits and the refresh of the DNS
+	// resolver, so that tests and simulations can fast-forward time. Default is the system clock
+	Clock clock.Clock
 	// MonitoringFrontend serves Prometheus metrics data.
 	MonitoringFrontend monitoring.Frontend
+	// MetricsRegistry is the Prometheus registry to register the STUNner metrics with. Default is
+	// the global Prometheus registry
+	MetricsRegistry prometheus.Registerer
+	// LogWriter redirects the logs of STUNner and each of its sub-objects. Default is stdout
+	LogWriter io.Writer
 	// VNet will switch STUNner into testing mode, using a vnet.Net instance to run STUNner
 	// over an emulated data-plane
 	Net *vnet.Net
@@ -48,11 +125,65 @@ type Stunner struct {
 	adminManager, authManager, listenerManager, clusterManager manager.Manager
 	resolver                                                   resolver.DnsResolver
 	logger                                                     *logger.LoggerFactory
+	logFile                                                    *logger.FileWriter
+	accessLogFile                                              *logger.FileWriter
+	auditLogFile                                               *logger.FileWriter
+	syslog                                                     *logger.SyslogWriter
 	log                                                        logging.LeveledLogger
 	server                                                     *turn.Server
 	monitoringFrontend                                         monitoring.Frontend
+	metricsRegistry                                            prometheus.Registerer
+	apiServer                                                  api.Server
+	conntrack                                                  *conntrack.Table
+	certStores                                                 []*certs.Store
+	crls                                                       []*certs.CRL
+	sds                                                        *certs.SecretClient
+	reconcileLock                                              sync.Mutex
+	generation                                                 int64
+	draining                                                   int32
+	lastGoodConfig                                             *v1alpha1.StunnerConfig
+	lastDiff                                                   atomic.Value
+	configSource                                               ConfigSource
+	audit                                                      *auditTrail
+	restartRefused                                             atomic.Value // string
+	events                                                     *eventBroker
+	notifier                                                   *notifier
+	watermarks                                                 *watermarks
+	dnsHealth                                                  *dnsHealth
+	latencyProber                                              *latencyProber
+	bans                                                       *ban.Table
+	quota                                                      *quota.Limiter
+	amplification                                              *amplification.Limiter
+	malformed                                                  *malformed.Filter
+	requestRate                                                *ratelimit.Limiter
+	peerPorts                                                  *peerport.Filter
+	addressMap                                                 *addrmap.Table
+	policy                                                     *plugin.Engine
+	clock                                                      clock.Clock
+	tenants                                                    *tenant.Table
+	replication                                                *replication.Table
+	replicator                                                 *replicator
+	hashRing                                                   *hashring.Ring
+	standby                                                    *hotStandby
+	gcPercent                                                  int
+	gcPercentSaved                                             bool
+	memoryLimit                                                int64
+	memoryLimitSaved                                           bool
+	handover                                                   *handover.Mux
+	sockets                                                    map[string][]socketFile
+	socketKeys                                                 map[string]string // UDP listeners
+	natDiscovery                                               map[string]*discovery.Server
+	inherited                                                  *handover.State
+	upgradeConn                                                *net.UnixConn
+	upgradeListener                                            *net.UnixListener
+	upgradeStarted                                             bool
+	activated                                                  []activatedSocket
+	socketsActivated                                           bool
+	upgrading                                                  int32
+	handedOver                                                 chan struct{}
 	net                                                        *vnet.Net
 	options                                                    Options
+	done                                                       chan struct{}
 }
 
 // NewStunner creates a new empty STUNner deamon. Call Reconcile to reconcile the daemon for the given configuration
@@ -60,6 +191,7 @@ func NewStunner() *Stunner {
 	loggerFactory := logger.NewLoggerFactory(DefaultLogLevel)
 	r := resolver.NewDnsResolver("dns-resolver", loggerFactory)
 	mf := monitoring.NewFrontend("", false, loggerFactory)
+	as := api.NewServer("", false, loggerFactory)
 	vnet := vnet.NewNet(nil)
 
 	s := Stunner{
@@ -67,7 +199,7 @@ func NewStunner() *Stunner {
 		logger:  loggerFactory,
 		log:     loggerFactory.NewLogger("stunner"),
 		adminManager: manager.NewManager("admin-manager",
-			object.NewAdminFactory(mf, loggerFactory), loggerFactory),
+			object.NewAdminFactory(mf, as, loggerFactory), loggerFactory),
 		authManager: manager.NewManager("auth-manager",
 			object.NewAuthFactory(loggerFactory), loggerFactory),
 		listenerManager: manager.NewManager("listener-manager",
@@ -76,20 +208,66 @@ func NewStunner() *Stunner {
 			object.NewClusterFactory(r, loggerFactory), loggerFactory),
 		resolver:           r,
 		monitoringFrontend: mf,
+		metricsRegistry:    prometheus.DefaultRegisterer,
+		apiServer:          as,
+		conntrack:          conntrack.NewTable(loggerFactory),
+		bans:               ban.NewTable(loggerFactory),
+		tenants:            tenant.NewTable(loggerFactory),
+		replication:        replication.NewTable(loggerFactory),
+		replicator:         newReplicator(),
+		standby:            newHotStandby(),
+		hashRing:           hashring.NewRing(loggerFactory),
+		events:             newEventBroker(),
+		audit:              newAuditTrail(),
+		notifier:           newNotifier(),
+		watermarks:         newWatermarks(),
+		dnsHealth:          newDNSHealth(),
+		latencyProber:      newLatencyProber(),
+		handover:           handover.NewMux(loggerFactory),
+		sockets:            map[string][]socketFile{},
+		socketKeys:         map[string]string{},
+		handedOver:         make(chan struct{}),
 		net:                vnet,
 		options:            Options{},
+		done:               make(chan struct{}),
 	}
+	s.quota = quota.NewLimiter(s.conntrack, loggerFactory)
+	s.amplification = amplification.NewLimiter(s.conntrack, loggerFactory)
+	s.malformed = malformed.NewFilter(s.reportMalformed, loggerFactory)
+	s.requestRate = ratelimit.NewLimiter(s.conntrack, loggerFactory)
+	s.peerPorts = peerport.NewFilter(loggerFactory)
+	s.addressMap = addrmap.NewTable(loggerFactory)
+	s.policy = plugin.NewEngine(loggerFactory)
+	s.clock = clock.Real
+	s.conntrack.SetLifetimes(s.lifetimes)
+
+	s.registerAPIHandlers()
 
 	// start monitoring
-	monitoring.RegisterMetrics(s.log,
+	s.registerMetrics()
+
+	// subscribe right away so that no event is missed
+	ch, cancel := s.SubscribeEvents()
+	go s.runEventWebhook(ch, cancel)
+	go s.runNotifier()
+	go s.runWatermarks()
+	go s.runTenantMetrics()
+	go s.runReplication()
+	go s.runStandby()
+	go s.runDNSHealth()
+	go s.runBans()
+
+	return &s
+}
+
+func (s *Stunner) registerMetrics() {
+	monitoring.RegisterMetrics(s.metricsRegistry, s.log,
 		func() float64 {
 			if s.server != nil {
 				return float64(s.server.AllocationCount())
 			}
 			return 0.0
 		})
-
-	return &s
 }
 
 // WithOptions will take into effect the options passed in
@@ -100,6 +278,16 @@ func (s *Stunner) WithOptions(options Options) *Stunner {
 		s.logger.SetLevel(options.LogLevel)
 	}
 
+	if options.LogWriter != nil {
+		s.logger.SetWriter(options.LogWriter)
+	}
+
+	if options.MetricsRegistry != nil {
+		monitoring.UnregisterMetrics(s.metricsRegistry, s.log)
+		s.metricsRegistry = options.MetricsRegistry
+		s.registerMetrics()
+	}
+
 	if options.Net != nil {
 		s.log.Warn("vnet is enabled")
 		s.net = options.Net
@@ -107,28 +295,69 @@ func (s *Stunner) WithOptions(options Options) *Stunner {
 			object.NewListenerFactory(options.Net, s.logger), s.logger)
 	}
 
-	if options.Resolver != nil {
-		s.resolver = options.Resolver
+	if options.PolicyPlugin != nil {
+		s.policy.SetPlugin(options.PolicyPlugin)
+	}
+
+	if options.Clock != nil {
+		s.clock = options.Clock
+		s.conntrack.SetClock(options.Clock)
+		s.bans.SetClock(options.Clock)
+		s.quota.SetClock(options.Clock)
+		s.amplification.SetClock(options.Clock)
+		s.requestRate.SetClock(options.Clock)
+		s.malformed.SetClock(options.Clock)
+	}
+
+	// the resolver is swapped once all the options it depends on are set, closing the old one so
+	// that the background resolutions of its domains are stopped
+	if options.Resolver != nil || options.Clock != nil {
+		s.resolver.Close()
+		if options.Resolver != nil {
+			s.resolver = options.Resolver
+		} else {
+			s.resolver = resolver.NewDnsResolverWithClock("dns-resolver", options.Clock, s.logger)
+		}
 		s.clusterManager = manager.NewManager("cluster-manager",
-			object.NewClusterFactory(options.Resolver, s.logger), s.logger)
+			object.NewClusterFactory(s.resolver, s.logger), s.logger)
 	}
 
 	// monitoring
 	if options.DryRun {
 		ep := s.monitoringFrontend.GetEndpoint()
 		s.monitoringFrontend = monitoring.NewFrontend(ep, options.DryRun, s.logger)
+		s.apiServer = api.NewServer(s.apiServer.GetEndpoint(), options.DryRun, s.logger)
+		s.registerAPIHandlers()
 		s.adminManager = manager.NewManager("admin-manager",
-			object.NewAdminFactory(s.monitoringFrontend, s.logger), s.logger)
+			object.NewAdminFactory(s.monitoringFrontend, s.apiServer, s.logger), s.logger)
 	}
 	if options.MonitoringFrontend != nil {
 		s.monitoringFrontend = options.MonitoringFrontend
 		s.adminManager = manager.NewManager("admin-manager",
-			object.NewAdminFactory(options.MonitoringFrontend, s.logger), s.logger)
+			object.NewAdminFactory(options.MonitoringFrontend, s.apiServer, s.logger), s.logger)
+	}
+
+	if options.AdminSocket != "" {
+		if err := s.apiServer.ReconcileSocket(options.AdminSocket); err != nil {
+			s.log.Warnf("cannot serve admin API on local socket: %s", err.Error())
+		}
+	}
+
+	if options.ConntrackDumpInterval > 0 {
+		go s.runConntrackDump(options.ConntrackDumpInterval)
 	}
 
 	return s
 }
 
+// defaultLogWriter returns the writer to log to when no log file is set
+func (s *Stunner) defaultLogWriter() io.Writer {
+	if s.options.LogWriter != nil {
+		return s.options.LogWriter
+	}
+	return os.Stdout
+}
+
 // GetVersion returns the STUNner API version
 func (s *Stunner) GetVersion() string {
 	return s.version
@@ -175,6 +404,11 @@ func (s *Stunner) GetCluster(name string) *object.Cluster {
 	return l.(*object.Cluster)
 }
 
+// GetConntrack returns the connection tracking table of the running daemon
+func (s *Stunner) GetConntrack() *conntrack.Table {
+	return s.conntrack
+}
+
 // GetLogger returns the logger factory of the running daemon, useful for creating a sub-logger
 func (s *Stunner) GetLogger() logging.LoggerFactory {
 	return s.logger
@@ -202,6 +436,9 @@ func (s *Stunner) String() string {
 func (s *Stunner) Close() {
 	s.log.Info("closing STUNner")
 
+	// hand the virtual IP over to the standby before the listeners go down
+	s.closeStandby()
+
 	// stop the server
 	s.Stop()
 
@@ -233,8 +470,61 @@ func (s *Stunner) Close() {
 	}
 
 	// shutdown monitoring
-	monitoring.UnregisterMetrics(s.log)
+	monitoring.UnregisterMetrics(s.metricsRegistry, s.log)
 	s.monitoringFrontend.Stop()
+	s.apiServer.Stop()
+	_ = s.apiServer.ReconcileSocket("")
+	s.closeUpgrade()
+	s.closeActivatedSockets()
+	s.policy.Close()
+	if s.sds != nil {
+		s.sds.Close()
+		s.sds = nil
+	}
+
+	close(s.done)
 
 	s.resolver.Close()
+
+	if s.logFile != nil {
+		s.logger.SetWriter(s.defaultLogWriter())
+		s.logFile.Close()
+		s.logFile = nil
+	}
+	if s.syslog != nil {
+		s.logger.SetSyslog(nil)
+		s.syslog.Close()
+		s.syslog = nil
+	}
+
+	s.conntrack.SetAccessLog(nil)
+	s.conntrack.SetObserver(nil)
+	s.conntrack.StopCaptures()
+	if tr := s.conntrack.GetTracer(); tr != nil {
+		s.conntrack.SetTracer(nil)
+		tr.Close()
+	}
+	if s.accessLogFile != nil {
+		s.accessLogFile.Close()
+		s.accessLogFile = nil
+	}
+	s.audit.setWriter(nil)
+	if s.auditLogFile != nil {
+		s.auditLogFile.Close()
+		s.auditLogFile = nil
+	}
+}
+
+func (s *Stunner) runConntrackDump(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.conntrack.Dump()
+		case <-s.done:
+			return
+		}
+	}
 }
@@ -23,6 +23,8 @@ import (
 	"github.com/stretchr/testify/assert"
 
 	"github.com/l7mp/stunner/internal/logger"
+	"github.com/l7mp/stunner/internal/resolver"
+	"github.com/l7mp/stunner/internal/ws"
 
 	"github.com/l7mp/stunner/pkg/apis/v1alpha1"
 )
@@ -159,6 +161,17 @@ func stunnerEchoTest(conf echoTestConfig) {
 
 					time.Sleep(100 * time.Millisecond)
 				}
+
+				// ensure the flow is tracked
+				flows := conf.stunner.GetConntrack().Flows()
+				assert.Len(t, flows, 1, "conntrack flow count")
+				if len(flows) == 1 {
+					assert.Equal(t, conn.LocalAddr().String(), flows[0].Relay, "conntrack relay")
+					assert.NotEmpty(t, flows[0].Client, "conntrack client")
+					assert.Equal(t, conf.user, flows[0].Username, "conntrack username")
+					assert.Equal(t, uint64(8), flows[0].TxPackets, "conntrack tx packets")
+					assert.Equal(t, uint64(8), flows[0].RxPackets, "conntrack rx packets")
+				}
 			} else {
 				// should fail
 				_, err = conn.WriteTo([]byte("Hello"), echoConn.LocalAddr())
@@ -474,8 +487,9 @@ func TestStunnerServerLocalhost(t *testing.T) {
 				Routes:   []string{"allow-any"},
 			}},
 			Clusters: []v1alpha1.ClusterConfig{{
-				Name:      "allow-any",
-				Endpoints: []string{"0.0.0.0/0"},
+				Name:                "allow-any",
+				Endpoints:           []string{"0.0.0.0/0"},
+				AllowSensitivePeers: true,
 			}},
 		},
 		// udp, longterm
@@ -498,8 +512,9 @@ func TestStunnerServerLocalhost(t *testing.T) {
 				Routes:   []string{"allow-any"},
 			}},
 			Clusters: []v1alpha1.ClusterConfig{{
-				Name:      "allow-any",
-				Endpoints: []string{"0.0.0.0/0"},
+				Name:                "allow-any",
+				Endpoints:           []string{"0.0.0.0/0"},
+				AllowSensitivePeers: true,
 			}},
 		},
 		// tcp, plaintext
@@ -523,8 +538,9 @@ func TestStunnerServerLocalhost(t *testing.T) {
 				Routes:   []string{"allow-any"},
 			}},
 			Clusters: []v1alpha1.ClusterConfig{{
-				Name:      "allow-any",
-				Endpoints: []string{"0.0.0.0/0"},
+				Name:                "allow-any",
+				Endpoints:           []string{"0.0.0.0/0"},
+				AllowSensitivePeers: true,
 			}},
 		},
 		// tcp, longterm
@@ -547,8 +563,9 @@ func TestStunnerServerLocalhost(t *testing.T) {
 				Routes:   []string{"allow-any"},
 			}},
 			Clusters: []v1alpha1.ClusterConfig{{
-				Name:      "allow-any",
-				Endpoints: []string{"0.0.0.0/0"},
+				Name:                "allow-any",
+				Endpoints:           []string{"0.0.0.0/0"},
+				AllowSensitivePeers: true,
 			}},
 		},
 		// tls, plaintext
@@ -574,8 +591,62 @@ func TestStunnerServerLocalhost(t *testing.T) {
 				Routes:   []string{"allow-any"},
 			}},
 			Clusters: []v1alpha1.ClusterConfig{{
-				Name:      "allow-any",
-				Endpoints: []string{"0.0.0.0/0"},
+				Name:                "allow-any",
+				Endpoints:           []string{"0.0.0.0/0"},
+				AllowSensitivePeers: true,
+			}},
+		},
+		// ws, plaintext
+		{
+			ApiVersion: "v1alpha1",
+			Admin: v1alpha1.AdminConfig{
+				LogLevel: stunnerTestLoglevel,
+			},
+			Auth: v1alpha1.AuthConfig{
+				Type: "plaintext",
+				Credentials: map[string]string{
+					"username": "user1",
+					"password": "passwd1",
+				},
+			},
+			Listeners: []v1alpha1.ListenerConfig{{
+				Name:     "ws",
+				Protocol: "ws",
+				Addr:     "127.0.0.1",
+				Port:     23478,
+				Routes:   []string{"allow-any"},
+			}},
+			Clusters: []v1alpha1.ClusterConfig{{
+				Name:                "allow-any",
+				Endpoints:           []string{"0.0.0.0/0"},
+				AllowSensitivePeers: true,
+			}},
+		},
+		// wss, longterm
+		{
+			ApiVersion: "v1alpha1",
+			Admin: v1alpha1.AdminConfig{
+				LogLevel: stunnerTestLoglevel,
+			},
+			Auth: v1alpha1.AuthConfig{
+				Type: "longterm",
+				Credentials: map[string]string{
+					"secret": "my-secret",
+				},
+			},
+			Listeners: []v1alpha1.ListenerConfig{{
+				Name:     "wss",
+				Protocol: "wss",
+				Addr:     "127.0.0.1",
+				Port:     23478,
+				Cert:     certFile.Name(),
+				Key:      keyFile.Name(),
+				Routes:   []string{"allow-any"},
+			}},
+			Clusters: []v1alpha1.ClusterConfig{{
+				Name:                "allow-any",
+				Endpoints:           []string{"0.0.0.0/0"},
+				AllowSensitivePeers: true,
 			}},
 		},
 		// tls, longterm
@@ -600,8 +671,9 @@ func TestStunnerServerLocalhost(t *testing.T) {
 				Routes:   []string{"allow-any"},
 			}},
 			Clusters: []v1alpha1.ClusterConfig{{
-				Name:      "allow-any",
-				Endpoints: []string{"0.0.0.0/0"},
+				Name:                "allow-any",
+				Endpoints:           []string{"0.0.0.0/0"},
+				AllowSensitivePeers: true,
 			}},
 		},
 		// dtls, plaintext
@@ -627,8 +699,9 @@ func TestStunnerServerLocalhost(t *testing.T) {
 				Routes:   []string{"allow-any"},
 			}},
 			Clusters: []v1alpha1.ClusterConfig{{
-				Name:      "allow-any",
-				Endpoints: []string{"0.0.0.0/0"},
+				Name:                "allow-any",
+				Endpoints:           []string{"0.0.0.0/0"},
+				AllowSensitivePeers: true,
 			}},
 		},
 		// // dtls, longterm
@@ -669,8 +742,10 @@ func TestStunnerServerLocalhost(t *testing.T) {
 
 			log.Debug("creating a stunnerd")
 			stunner := NewStunner().WithOptions(Options{
-				LogLevel:         stunnerTestLoglevel,
-				SuppressRollback: true,
+				LogLevel:               stunnerTestLoglevel,
+				SuppressRollback:       true,
+				UDPListenerThreadNum:   4,
+				UDPListenerCPUAffinity: true,
 			})
 
 			log.Debug("starting stunnerd")
@@ -710,6 +785,17 @@ func TestStunnerServerLocalhost(t *testing.T) {
 				})
 				assert.NoError(t, err, "cannot create TLS client socket")
 				lconn = turn.NewSTUNConn(conn)
+			case "ws":
+				conn, cErr := ws.Dial("ws://"+stunnerAddr+"/", nil)
+				assert.NoError(t, cErr, "cannot create WebSocket client socket")
+				lconn = turn.NewSTUNConn(conn)
+			case "wss":
+				conn, cErr := ws.Dial("wss://"+stunnerAddr+"/", &tls.Config{
+					MinVersion:         tls.VersionTLS12,
+					InsecureSkipVerify: true,
+				})
+				assert.NoError(t, cErr, "cannot create secure WebSocket client socket")
+				lconn = turn.NewSTUNConn(conn)
 			case "dtls":
 				cert, err := tls.LoadX509KeyPair(certFile.Name(), keyFile.Name())
 				assert.NoError(t, err, "cannot create certificate for DTLS client socket")
@@ -735,3 +821,29 @@ func TestStunnerServerLocalhost(t *testing.T) {
 		})
 	}
 }
+
+// closeCountingResolver counts the calls to Close
+type closeCountingResolver struct {
+	resolver.DnsResolver
+	closed int
+}
+
+func (r *closeCountingResolver) Close() { r.closed++ }
+
+func TestStunnerWithOptionsResolver(t *testing.T) {
+	loggerFactory := logger.NewLoggerFactory(stunnerTestLoglevel)
+	r1 := &closeCountingResolver{DnsResolver: resolver.NewMockResolver(nil, loggerFactory)}
+	r2 := &closeCountingResolver{DnsResolver: resolver.NewMockResolver(nil, loggerFactory)}
+
+	stunner := NewStunner().WithOptions(Options{LogLevel: stunnerTestLoglevel, Resolver: r1})
+	assert.Equal(t, 0, r1.closed, "resolver in use")
+
+	// a replaced resolver is closed
+	stunner.WithOptions(Options{Resolver: r2})
+	assert.Equal(t, 1, r1.closed, "replaced resolver closed")
+	assert.Equal(t, 0, r2.closed, "resolver in use")
+
+	stunner.Close()
+	assert.Equal(t, 1, r1.closed, "replaced resolver closed once")
+	assert.Equal(t, 1, r2.closed, "resolver closed")
+}
@@ -0,0 +1,45 @@
+package stunner
+
+import (
+	"time"
+
+	"github.com/l7mp/stunner/internal/monitoring"
+)
+
+// tenantMetricsInterval is the period the per-tenant metrics are updated at
+const tenantMetricsInterval = 10 * time.Second
+
+// runTenantMetrics updates the per-tenant metrics periodically
+func (s *Stunner) runTenantMetrics() {
+	ticker := time.NewTicker(tenantMetricsInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.updateTenantMetrics()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// updateTenantMetrics exports the tenant of each listener and the number of active allocations on
+// the listeners of each tenant
+func (s *Stunner) updateTenantMetrics() {
+	allocs := map[string]int{}
+	monitoring.ListenerTenantInfo.Reset()
+	for _, name := range s.listenerManager.Keys() {
+		l := s.GetListener(name)
+		if l == nil || l.Tenant == "" {
+			continue
+		}
+		monitoring.ListenerTenantInfo.WithLabelValues(l.Name, l.Tenant).Set(1)
+		allocs[l.Tenant] += s.conntrack.ListenerLen(l.Name)
+	}
+
+	monitoring.TenantAllocationsGauge.Reset()
+	for tenant, n := range allocs {
+		monitoring.TenantAllocationsGauge.WithLabelValues(tenant).Set(float64(n))
+	}
+}
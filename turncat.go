@@ -13,7 +13,9 @@ import (
 	"github.com/pion/logging"
 	"github.com/pion/turn/v2"
 
+	"github.com/l7mp/stunner/internal/sockopt"
 	"github.com/l7mp/stunner/internal/util"
+	"github.com/l7mp/stunner/internal/ws"
 	"github.com/l7mp/stunner/pkg/apis/v1alpha1"
 )
 
@@ -115,7 +117,7 @@ func NewTurncat(config *TurncatConfig) (*Turncat, error) {
 	// per-client connections will connect back to the client
 	log.Tracef("Setting up listener connection on %s", config.ListenerAddr)
 	var listenerConn interface{}
-	listenerConf := &net.ListenConfig{Control: reuseAddr}
+	listenerConf := &net.ListenConfig{Control: sockopt.Options{ReuseAddr: true}.Control}
 
 	switch listener.Protocol {
 	case "file":
@@ -266,6 +268,16 @@ func (t *Turncat) newConnection(clientConn net.Conn) (*connection, error) {
 				clientAddr.Network(), clientAddr.String(), err)
 		}
 		turnConn = turn.NewSTUNConn(conn)
+	case "ws", "wss":
+		c, err := ws.Dial(t.serverProto+"://"+t.serverAddr.String()+"/", &tls.Config{
+			MinVersion:         tls.VersionTLS12,
+			InsecureSkipVerify: t.insecure,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("cannot allocate TURN/WebSocket socket for client %s:%s: %s",
+				clientAddr.Network(), clientAddr.String(), err)
+		}
+		turnConn = turn.NewSTUNConn(c)
 	default:
 		return nil, fmt.Errorf("unknown TURN server protocol %s for client %s:%s",
 			t.serverAddr.Network(), clientAddr.Network(), clientAddr.String())
@@ -436,7 +448,7 @@ func (t *Turncat) runListenPacket() {
 
 			// create per-client connection, connect back to client, then call runConnection
 			t.log.Tracef("connnecting back to client %s", caddr)
-			dialer := &net.Dialer{LocalAddr: t.listenerAddr, Control: reuseAddr}
+			dialer := &net.Dialer{LocalAddr: t.listenerAddr, Control: sockopt.Options{ReuseAddr: true}.Control}
 			clientConn, clientErr := dialer.Dial(clientAddr.Network(), clientAddr.String())
 			if clientErr != nil {
 				t.log.Warnf("cannot connect back to client %s:%s: %s",
@@ -136,8 +136,9 @@ func TestTurncatPlaintext(t *testing.T) {
 			Routes:   []string{"allow-any"},
 		}},
 		Clusters: []v1alpha1.ClusterConfig{{
-			Name:      "allow-any",
-			Endpoints: []string{"0.0.0.0/0"},
+			Name:                "allow-any",
+			Endpoints:           []string{"0.0.0.0/0"},
+			AllowSensitivePeers: true,
 		}},
 	})
 
@@ -255,8 +256,9 @@ func TestTurncatLongterm(t *testing.T) {
 			Routes:   []string{"allow-any"},
 		}},
 		Clusters: []v1alpha1.ClusterConfig{{
-			Name:      "allow-any",
-			Endpoints: []string{"0.0.0.0/0"},
+			Name:                "allow-any",
+			Endpoints:           []string{"0.0.0.0/0"},
+			AllowSensitivePeers: true,
 		}},
 	})
 	assert.ErrorContains(t, err, "restart", "starting server")
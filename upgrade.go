@@ -0,0 +1,359 @@
+package stunner
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/l7mp/stunner/internal/discovery"
+	"github.com/l7mp/stunner/internal/handover"
+	"github.com/l7mp/stunner/internal/object"
+	"github.com/l7mp/stunner/internal/sockopt"
+	"github.com/l7mp/stunner/internal/udp"
+	"github.com/l7mp/stunner/pkg/apis/v1alpha1"
+)
+
+// socketFile is a socket that can be handed over to another process
+type socketFile interface {
+	File() (*os.File, error)
+}
+
+// socketKey identifies the socket of a listener across processes
+func socketKey(proto v1alpha1.ListenerProtocol, addr string) string {
+	return proto.String() + "/" + addr
+}
+
+// HandedOver returns a channel that is closed once the listening sockets were handed over to a
+// new process and the allocations of the server were drained: the process should exit then
+func (s *Stunner) HandedOver() <-chan struct{} {
+	return s.handedOver
+}
+
+// listenPacket creates the worker sockets of a UDP listener, or takes over the sockets of the
+// process STUNner is upgraded from or the socket passed by socket activation
+func (s *Stunner) listenPacket(l *object.Listener, addr string, threadNum int) ([]net.PacketConn, error) {
+	conns, err := s.openPacket(l, addr, threadNum)
+	if err != nil {
+		return nil, err
+	}
+	s.socketKeys[l.Name] = socketKey(l.Proto, addr)
+	return conns, nil
+}
+
+// listenAlternate returns the function opening the alternate sockets of the NAT behavior
+// discovery of a UDP listener, the sockets are handed over on upgrade with the listener sockets
+func (s *Stunner) listenAlternate(l *object.Listener) discovery.ListenFunc {
+	return func(addr string) (net.PacketConn, error) {
+		conns, err := s.openPacket(l, addr, 1)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range conns[1:] {
+			c.Close()
+		}
+		return conns[0], nil
+	}
+}
+
+// openPacket creates or takes over UDP sockets at an address and records them for handover
+func (s *Stunner) openPacket(l *object.Listener, addr string, threadNum int) ([]net.PacketConn, error) {
+	key := socketKey(l.Proto, addr)
+	var conns []net.PacketConn
+	if fs := s.takeOver(key); fs != nil {
+		var err error
+		for _, f := range fs {
+			if err == nil {
+				var c net.PacketConn
+				if c, err = net.FilePacketConn(f); err == nil {
+					conns = append(conns, c)
+				}
+			}
+			f.Close()
+		}
+		if err != nil {
+			for _, c := range conns {
+				c.Close()
+			}
+			return nil, fmt.Errorf("cannot take over socket: %w", err)
+		}
+		s.log.Infof("took over %d UDP socket(s) at %s", len(conns), addr)
+	} else if f := s.activatedSocket("udp", addr); f != nil {
+		c, err := net.FilePacketConn(f)
+		if err != nil {
+			return nil, fmt.Errorf("cannot use socket from socket activation: %w", err)
+		}
+		s.log.Infof("using UDP socket at %s from socket activation", addr)
+		conns = []net.PacketConn{c}
+	} else if !l.Net.IsVirtual() {
+		cs, err := udp.ListenWorkers("udp", addr, threadNum, s.socketOptions(l))
+		if err != nil {
+			return nil, err
+		}
+		conns = cs
+	} else {
+		c, err := l.Net.ListenPacket("udp", addr)
+		if err != nil {
+			return nil, err
+		}
+		conns = []net.PacketConn{c}
+	}
+
+	for _, c := range conns {
+		s.recordSocket(key, c)
+	}
+	return conns, nil
+}
+
+// listenStream creates the TCP socket of a TCP, TLS, WS or WSS listener, or takes over the socket
+// of the process STUNner is upgraded from or the socket passed by socket activation
+func (s *Stunner) listenStream(l *object.Listener, addr string) (net.Listener, error) {
+	key := socketKey(l.Proto, addr)
+	var ln net.Listener
+	if fs := s.takeOver(key); fs != nil {
+		var err error
+		ln, err = net.FileListener(fs[0])
+		for _, f := range fs {
+			f.Close()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot take over socket: %w", err)
+		}
+		s.log.Infof("took over %s socket at %s", l.Proto.String(), addr)
+	} else if f := s.activatedSocket("tcp", addr); f != nil {
+		var err error
+		if ln, err = net.FileListener(f); err != nil {
+			return nil, fmt.Errorf("cannot use socket from socket activation: %w", err)
+		}
+		s.log.Infof("using TCP socket at %s from socket activation", addr)
+	} else {
+		var err error
+		lc := net.ListenConfig{Control: s.socketOptions(l).Control}
+		if ln, err = lc.Listen(context.Background(), "tcp", addr); err != nil {
+			return nil, err
+		}
+	}
+
+	s.recordSocket(key, ln)
+	return s.handover.NewListener(ln, key), nil
+}
+
+// socketOptions returns the socket options of a listener, warning about the options not supported
+// on the platform
+func (s *Stunner) socketOptions(l *object.Listener) sockopt.Options {
+	opts := l.SocketOptions()
+	if u := opts.Unsupported(); len(u) > 0 {
+		s.log.Warnf("listener %s: socket options not supported on %s, ignoring: %s", l.Name,
+			runtime.GOOS, strings.Join(u, ", "))
+	}
+	return opts
+}
+
+// recordSocket records a socket to be handed over on upgrade
+func (s *Stunner) recordSocket(key string, sock interface{}) {
+	if f, ok := sock.(socketFile); ok {
+		s.sockets[key] = append(s.sockets[key], f)
+	}
+}
+
+// takeOver returns the sockets taken over from the old process for a listener, if any
+func (s *Stunner) takeOver(key string) []*os.File {
+	if s.inherited == nil {
+		return nil
+	}
+	fs, ok := s.inherited.Sockets[key]
+	if !ok {
+		return nil
+	}
+	delete(s.inherited.Sockets, key)
+	return fs
+}
+
+// upgradeFrom takes over the listening sockets of the process at the upgrade socket on the first
+// reconciliation, before the API and metrics endpoints are bound. If no process runs there, the
+// sockets are created as usual
+func (s *Stunner) upgradeFrom() {
+	path := s.options.UpgradeFrom
+	if path == "" || s.upgradeStarted {
+		return
+	}
+	s.upgradeStarted = true
+
+	conn, err := handover.Dial(path)
+	if err != nil {
+		s.log.Infof("no process to upgrade from at %s, creating sockets", path)
+		return
+	}
+	state, err := handover.Receive(conn)
+	if err != nil {
+		conn.Close()
+		s.log.Warnf("cannot take over sockets from %s: %s", path, err.Error())
+		return
+	}
+
+	n := 0
+	for _, fs := range state.Sockets {
+		n += len(fs)
+	}
+	s.log.Infof("upgrading from process at %s: taking over %d sockets", path, n)
+	s.inherited, s.upgradeConn = state, conn
+}
+
+// finishUpgrade closes the sockets taken over but not used by any listener and starts forwarding
+// the packets of the clients of the old process to the old process
+func (s *Stunner) finishUpgrade() {
+	if s.inherited != nil {
+		for key := range s.inherited.Sockets {
+			s.log.Infof("closing socket taken over for %s: no such listener", key)
+		}
+		s.inherited.Close()
+		s.handover.Forward(s.upgradeConn, s.inherited.Clients)
+		s.inherited, s.upgradeConn = nil, nil
+	}
+	s.serveUpgrade()
+}
+
+// serveUpgrade serves the upgrade socket new processes take over the listening sockets from
+func (s *Stunner) serveUpgrade() {
+	path := s.options.UpgradeSocket
+	if path == "" || s.upgradeListener != nil || atomic.LoadInt32(&s.upgrading) == 1 {
+		return
+	}
+
+	l, err := handover.Listen(path)
+	if err != nil {
+		s.log.Errorf("cannot serve upgrades: %s", err.Error())
+		return
+	}
+	s.upgradeListener = l
+	s.log.Infof("serving upgrades on local socket %s", path)
+
+	go func() {
+		for {
+			conn, err := l.AcceptUnix()
+			if err != nil {
+				return
+			}
+			if s.handOver(conn) {
+				return
+			}
+		}
+	}()
+}
+
+// handOver hands over the listening sockets to a new process and drains the allocations, and
+// returns true if the sockets were handed over
+func (s *Stunner) handOver(conn *net.UnixConn) bool {
+	s.reconcileLock.Lock()
+	defer s.reconcileLock.Unlock()
+
+	if s.server == nil {
+		s.log.Warn("refusing upgrade: server not running")
+		handover.Refuse(conn, "server not running") //nolint:errcheck
+		conn.Close()
+		return false
+	}
+
+	// pion/dtls cannot run on a socket taken over
+	for _, name := range s.listenerManager.Keys() {
+		if l := s.GetListener(name); l.Proto == v1alpha1.ListenerProtocolDTLS {
+			s.log.Warnf("refusing upgrade: DTLS listener %q cannot be handed over", name)
+			handover.Refuse(conn, fmt.Sprintf("DTLS listener %q cannot be handed over", //nolint:errcheck
+				name))
+			conn.Close()
+			return false
+		}
+	}
+
+	state := &handover.State{Sockets: map[string][]*os.File{}, Clients: map[string][]string{}}
+	for key, socks := range s.sockets {
+		for _, sock := range socks {
+			f, err := sock.File()
+			if err != nil {
+				state.Close()
+				s.log.Errorf("refusing upgrade: cannot hand over socket %s: %s", key, err.Error())
+				handover.Refuse(conn, err.Error()) //nolint:errcheck
+				conn.Close()
+				return false
+			}
+			state.Sockets[key] = append(state.Sockets[key], f)
+		}
+	}
+	for _, f := range s.conntrack.Flows() {
+		if key, ok := s.socketKeys[f.Listener]; ok && f.Client != "" {
+			state.Clients[key] = append(state.Clients[key], f.Client)
+		}
+	}
+
+	err := handover.Send(conn, state)
+	state.Close()
+	if err != nil {
+		s.log.Errorf("upgrade failed: %s", err.Error())
+		conn.Close()
+		return false
+	}
+
+	// the new process creates its own upgrade socket and binds the API and metrics endpoints
+	// once committed
+	atomic.StoreInt32(&s.upgrading, 1)
+	atomic.StoreInt32(&s.draining, 1)
+	s.upgradeListener.Close()
+	s.upgradeListener = nil
+	s.monitoringFrontend.Stop()
+	s.apiServer.Stop()
+	if err := handover.Commit(conn); err != nil {
+		s.log.Errorf("cannot commit upgrade: %s", err.Error())
+	}
+
+	s.log.Infof("listening sockets handed over to new process, draining %d active allocations",
+		s.server.AllocationCount())
+	s.handover.Detach(conn)
+	go s.drainHandedOver(s.GetAdmin().DrainTimeout)
+
+	return true
+}
+
+// drainHandedOver waits until the allocations are closed or the timeout expires once the sockets
+// were handed over
+func (s *Stunner) drainHandedOver(timeout time.Duration) {
+	active := func() int {
+		s.reconcileLock.Lock()
+		defer s.reconcileLock.Unlock()
+		if s.server == nil {
+			return 0
+		}
+		return s.server.AllocationCount()
+	}
+
+	deadline := time.Now().Add(timeout)
+	for active() > 0 && time.Now().Before(deadline) {
+		time.Sleep(drainPollInterval)
+	}
+
+	if n := active(); n > 0 {
+		s.log.Warnf("drain timeout expired, terminating %d active allocations", n)
+	}
+	s.log.Info("upgrade finished")
+	close(s.handedOver)
+}
+
+// closeUpgrade stops serving upgrades
+func (s *Stunner) closeUpgrade() {
+	if s.upgradeListener != nil {
+		s.upgradeListener.Close()
+		s.upgradeListener = nil
+	}
+	if s.inherited != nil {
+		s.inherited.Close()
+		s.inherited = nil
+	}
+	if s.upgradeConn != nil {
+		s.upgradeConn.Close()
+		s.upgradeConn = nil
+	}
+	s.handover.Close()
+}
@@ -7,7 +7,6 @@ import (
 	"os"
 	"strconv"
 	"strings"
-	"syscall"
 	"time"
 )
 
@@ -112,7 +111,7 @@ func ParseUri(uri string) (*StunnerUri, error) {
 			return nil, err
 		}
 		s.Addr = a
-	case "tcp", "tcp4", "tcp6", "tls":
+	case "tcp", "tcp4", "tcp6", "tls", "ws", "wss":
 		a, err := net.ResolveTCPAddr("tcp", s.Address+":"+u.Port())
 		if err != nil {
 			return nil, err
@@ -136,10 +135,3 @@ func ParseUri(uri string) (*StunnerUri, error) {
 
 	return &s, nil
 }
-
-func reuseAddr(network, address string, conn syscall.RawConn) error {
-	return conn.Control(func(descriptor uintptr) {
-		_ = syscall.SetsockoptInt(int(descriptor), syscall.SOL_SOCKET, syscall.SO_REUSEADDR, 1)
-		// syscall.SetsockoptInt(int(descriptor), syscall.SOL_SOCKET, syscall.SO_REUSEPORT, 1)
-	})
-}
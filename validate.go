@@ -0,0 +1,40 @@
+package stunner
+
+import (
+	"github.com/l7mp/stunner/pkg/apis/v1alpha1"
+)
+
+// ConfigError describes a single problem found in a configuration
+type ConfigError = v1alpha1.ConfigError
+
+// ValidationError is a report of all the problems found in a configuration
+type ValidationError = v1alpha1.ValidationError
+
+// ValidateConfig checks a configuration without applying it, see v1alpha1.ValidateConfig
+func ValidateConfig(c *v1alpha1.StunnerConfig) error {
+	return v1alpha1.ValidateConfig(c)
+}
+
+// CheckConfig loads a config file and validates it with ValidateConfig. Returns the configuration
+// or an error, which is a *ValidationError if the file could be parsed but the configuration is
+// invalid.
+func CheckConfig(file string) (*v1alpha1.StunnerConfig, error) {
+	return checkConfig(LoadConfig(file))
+}
+
+// CheckConfigStrict is like CheckConfig but fails on unknown fields, see LoadConfigStrict
+func CheckConfigStrict(file string) (*v1alpha1.StunnerConfig, error) {
+	return checkConfig(LoadConfigStrict(file))
+}
+
+func checkConfig(c *v1alpha1.StunnerConfig, err error) (*v1alpha1.StunnerConfig, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ValidateConfig(c); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
@@ -0,0 +1,324 @@
+package stunner
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"io"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/l7mp/stunner/pkg/apis/v1alpha1"
+	"github.com/l7mp/stunner/pkg/cds"
+)
+
+const (
+	// configWatchDebounce is the time to wait for a burst of file events to settle before
+	// reloading the config, editors and the kubelet tend to write files in multiple steps
+	configWatchDebounce = 250 * time.Millisecond
+	// configPollPeriod is the period for polling the config file, either as a fallback when
+	// file notifications are unavailable or as a safety net for missed events
+	configPollPeriod = 5 * time.Second
+)
+
+// WatchConfig watches a config file and sends each new valid configuration to the channel,
+// until the context is cancelled. The directory of the file is watched rather than the file
+// itself, so that updates by atomic rename or symlink swap (e.g., Kubernetes ConfigMap volumes)
+// are caught too, and the file is polled periodically in case notifications are missed or
+// unavailable. Events are debounced, invalid configs are logged and skipped, and a config is
+// sent only if the file content has changed since the last one sent. The first valid config is
+// sent as soon as it becomes available.
+func (s *Stunner) WatchConfig(ctx context.Context, file string, ch chan<- *v1alpha1.StunnerConfig) {
+	log := s.logger.NewLogger("config-watcher")
+
+	var last []byte
+	reload := func() {
+		// the hash covers the included files too
+		c, hash, err := loadConfig(file, s.options.StrictConfig)
+		if hash == nil {
+			log.Debugf("cannot read config file %q: %s", file, err.Error())
+			return
+		}
+		if bytes.Equal(hash, last) {
+			return
+		}
+		if err != nil {
+			log.Warnf("could not load config file %q: %s", file, err.Error())
+			return
+		}
+		if err := c.Validate(); err != nil {
+			log.Warnf("ignoring invalid config file %q: %s", file, err.Error())
+			return
+		}
+
+		last = hash
+		log.Infof("new config available in file %q", file)
+
+		select {
+		case ch <- c:
+		case <-ctx.Done():
+		}
+	}
+
+	var events <-chan fsnotify.Event
+	var errs <-chan error
+	watcher, err := fsnotify.NewWatcher()
+	if err == nil {
+		if err = watcher.Add(filepath.Dir(file)); err == nil {
+			events, errs = watcher.Events, watcher.Errors
+		} else {
+			watcher.Close()
+		}
+	}
+	if err != nil {
+		log.Warnf("cannot watch config file %q, falling back to polling every %s: %s", file,
+			configPollPeriod, err.Error())
+	}
+
+	go func() {
+		if events != nil {
+			defer watcher.Close()
+		}
+
+		poll := time.NewTicker(configPollPeriod)
+		defer poll.Stop()
+
+		debounce := time.NewTimer(configWatchDebounce)
+		defer debounce.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+
+			case <-poll.C:
+				reload()
+
+			case <-debounce.C:
+				reload()
+
+			case e := <-events:
+				// the kubelet updates ConfigMap volumes through a "..data" symlink, so
+				// events on other files in the directory are relevant too
+				log.Tracef("received watcher event: %s", e.String())
+				if !debounce.Stop() {
+					select {
+					case <-debounce.C:
+					default:
+					}
+				}
+				debounce.Reset(configWatchDebounce)
+
+			case err := <-errs:
+				log.Debugf("config watcher error: %s", err.Error())
+			}
+		}
+	}()
+}
+
+// maxRemoteConfigSize limits the size of configs fetched from a remote source
+const maxRemoteConfigSize = 4 << 20
+
+// WatchConfigURL periodically fetches the config from an HTTP(S) URL and sends each new valid
+// configuration to the channel, until the context is cancelled. Requests are conditional
+// (If-None-Match with the last ETag), so an unchanged config costs a 304 response. The tlsConf,
+// if not nil, is used for HTTPS, e.g., to set a custom CA or a client certificate for mTLS.
+func (s *Stunner) WatchConfigURL(ctx context.Context, url string, period time.Duration, tlsConf *tls.Config, ch chan<- *v1alpha1.StunnerConfig) {
+	log := s.logger.NewLogger("config-watcher")
+
+	if period <= 0 {
+		period = configPollPeriod
+	}
+	client := &http.Client{
+		Timeout:   period,
+		Transport: &http.Transport{TLSClientConfig: tlsConf, Proxy: http.ProxyFromEnvironment},
+	}
+
+	var etag string
+	var last []byte
+	fetch := func() {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			log.Warnf("invalid config URL %q: %s", url, err.Error())
+			return
+		}
+		req.Header.Set("Accept", "application/json, application/yaml")
+		if etag != "" {
+			req.Header.Set("If-None-Match", etag)
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			log.Warnf("could not fetch config from %q: %s", url, err.Error())
+			return
+		}
+		defer resp.Body.Close()
+
+		switch resp.StatusCode {
+		case http.StatusNotModified:
+			log.Tracef("config at %q not modified", url)
+			return
+		case http.StatusOK:
+		default:
+			log.Warnf("could not fetch config from %q: HTTP status %s", url, resp.Status)
+			return
+		}
+
+		raw, err := io.ReadAll(io.LimitReader(resp.Body, maxRemoteConfigSize))
+		if err != nil {
+			log.Warnf("could not read config from %q: %s", url, err.Error())
+			return
+		}
+		// remember the ETag even for invalid configs to avoid reparsing them
+		etag = resp.Header.Get("ETag")
+
+		hash := sha256.Sum256(raw)
+		if bytes.Equal(hash[:], last) {
+			return
+		}
+
+		c, err := parseConfig(raw, s.options.StrictConfig)
+		if err != nil {
+			log.Warnf("could not parse config from %q: %s", url, err.Error())
+			return
+		}
+		if err := ResolveSecrets(c, ""); err != nil {
+			log.Warnf("could not resolve secrets in config from %q: %s", url, err.Error())
+			return
+		}
+		if err := c.Validate(); err != nil {
+			log.Warnf("ignoring invalid config from %q: %s", url, err.Error())
+			return
+		}
+
+		last = hash[:]
+		log.Infof("new config available at %q", url)
+
+		select {
+		case ch <- c:
+		case <-ctx.Done():
+		}
+	}
+
+	go func() {
+		ticker := time.NewTicker(period)
+		defer ticker.Stop()
+
+		fetch()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				fetch()
+			}
+		}
+	}()
+}
+
+const (
+	// cdsMinBackoff and cdsMaxBackoff bound the delay between reconnection attempts to the
+	// config discovery server
+	cdsMinBackoff = 1 * time.Second
+	cdsMaxBackoff = 30 * time.Second
+)
+
+// WatchConfigCDS subscribes to the config discovery service (CDS) of a control plane at addr and
+// sends each new valid configuration to the channel, until the context is cancelled. Full and
+// incremental updates are applied to the last accepted config and the result is validated: valid
+// configs are ACKed, invalid ones are NACKed with the reason and skipped. The node name identifies
+// the daemon to the control plane. The stream is reestablished with exponential backoff if it
+// fails. The tlsConf, if not nil, is used to secure the connection, otherwise the connection is
+// unencrypted.
+func (s *Stunner) WatchConfigCDS(ctx context.Context, addr, node string, tlsConf *tls.Config, ch chan<- *v1alpha1.StunnerConfig) {
+	log := s.logger.NewLogger("config-watcher")
+
+	creds := grpc.WithInsecure()
+	if tlsConf != nil {
+		creds = grpc.WithTransportCredentials(credentials.NewTLS(tlsConf))
+	}
+
+	var current *v1alpha1.StunnerConfig
+	version := ""
+	stream := func() error {
+		conn, err := grpc.DialContext(ctx, addr, creds)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		client, err := cds.StreamConfig(ctx, conn)
+		if err != nil {
+			return err
+		}
+		defer client.CloseSend() //nolint:errcheck
+
+		if err := client.Send(&cds.DiscoveryRequest{Node: node, VersionInfo: version}); err != nil {
+			return err
+		}
+
+		for {
+			resp, err := client.Recv()
+			if err != nil {
+				return err
+			}
+			log.Tracef("received config version %q from CDS server %q", resp.VersionInfo, addr)
+
+			c := resp.Apply(current)
+			if err := c.Validate(); err != nil {
+				log.Warnf("rejecting invalid config version %q from CDS server %q: %s",
+					resp.VersionInfo, addr, err.Error())
+				if err := client.Send(&cds.DiscoveryRequest{Node: node, VersionInfo: version,
+					ResponseNonce: resp.Nonce, ErrorDetail: err.Error()}); err != nil {
+					return err
+				}
+				continue
+			}
+
+			current, version = c, resp.VersionInfo
+			log.Infof("new config version %q available from CDS server %q", version, addr)
+
+			// the receiver may modify the config, send a copy
+			update := *c
+			update.Listeners = append([]v1alpha1.ListenerConfig{}, c.Listeners...)
+			update.Clusters = append([]v1alpha1.ClusterConfig{}, c.Clusters...)
+			select {
+			case ch <- &update:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+
+			if err := client.Send(&cds.DiscoveryRequest{Node: node, VersionInfo: version,
+				ResponseNonce: resp.Nonce}); err != nil {
+				return err
+			}
+		}
+	}
+
+	go func() {
+		backoff := cdsMinBackoff
+		for {
+			err := stream()
+			if ctx.Err() != nil {
+				return
+			}
+			log.Warnf("config stream to CDS server %q failed, retrying in %s: %s", addr,
+				backoff, err.Error())
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(backoff):
+			}
+			if backoff *= 2; backoff > cdsMaxBackoff {
+				backoff = cdsMaxBackoff
+			}
+		}
+	}()
+}
@@ -0,0 +1,128 @@
+package stunner
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/l7mp/stunner/internal/monitoring"
+)
+
+// Resources tracked against the watermarks
+const (
+	ResourceRelayPorts      = "relay-ports"
+	ResourceFileDescriptors = "file-descriptors"
+	ResourceAllocations     = "allocations"
+)
+
+// watermarkCheckInterval is the period the resource usage is checked at
+const watermarkCheckInterval = 10 * time.Second
+
+// watermarks holds the resources whose usage is above the watermark
+type watermarks struct {
+	lock     sync.Mutex
+	exceeded map[string]string // "<resource>[/<listener>]" -> usage
+}
+
+func newWatermarks() *watermarks {
+	return &watermarks{exceeded: map[string]string{}}
+}
+
+// Exceeded returns the descriptions of the resources whose usage is above the watermark, sorted
+func (w *watermarks) Exceeded() []string {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	ret := make([]string, 0, len(w.exceeded))
+	for _, usage := range w.exceeded {
+		ret = append(ret, usage)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// runWatermarks checks the resource usage periodically
+func (s *Stunner) runWatermarks() {
+	ticker := time.NewTicker(watermarkCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.checkWatermarks()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// checkWatermarks checks the usage of the relay ports of each listener, of the file descriptors
+// and of the allocations against the watermarks set in the admin config, and logs a warning when
+// a watermark is crossed
+func (s *Stunner) checkWatermarks() {
+	monitoring.ResourceUsageGauge.Reset()
+	monitoring.WatermarkExceededGauge.Reset()
+
+	exceeded := map[string]string{}
+	if len(s.adminManager.Keys()) > 0 && s.GetAdmin().Watermarks != nil {
+		conf := s.GetAdmin().Watermarks
+		check := func(resource, listener string, usage, watermark float64, desc string) {
+			monitoring.ResourceUsageGauge.WithLabelValues(resource, listener).Set(usage)
+			over := 0.0
+			if usage >= watermark {
+				key := resource
+				if listener != "" {
+					key += "/" + listener
+				}
+				exceeded[key] = desc
+				over = 1.0
+			}
+			monitoring.WatermarkExceededGauge.WithLabelValues(resource, listener).Set(over)
+		}
+
+		allocs := 0
+		for _, name := range s.listenerManager.Keys() {
+			n := s.conntrack.ListenerLen(name)
+			allocs += n
+			min, max := s.GetListener(name).RelayPortRange()
+			ports := max - min + 1
+			if ports <= 0 {
+				continue
+			}
+			ratio := float64(n) / float64(ports)
+			check(ResourceRelayPorts, name, ratio, float64(conf.RelayPorts)/100,
+				fmt.Sprintf("relay ports of listener %s: %d/%d in use (watermark: %d%%)",
+					name, n, ports, conf.RelayPorts))
+		}
+
+		if used, limit, ok := openFiles(); ok {
+			ratio := float64(used) / float64(limit)
+			check(ResourceFileDescriptors, "", ratio, float64(conf.FileDescriptors)/100,
+				fmt.Sprintf("file descriptors: %d/%d open (watermark: %d%%)", used, limit,
+					conf.FileDescriptors))
+		}
+
+		if conf.Allocations > 0 {
+			check(ResourceAllocations, "", float64(allocs), float64(conf.Allocations),
+				fmt.Sprintf("allocations: %d active (watermark: %d)", allocs,
+					conf.Allocations))
+		}
+	}
+
+	w := s.watermarks
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	for key, desc := range exceeded {
+		if _, ok := w.exceeded[key]; !ok {
+			s.log.Warnf("resource watermark exceeded: %s", desc)
+		}
+	}
+	for key := range w.exceeded {
+		if _, ok := exceeded[key]; !ok {
+			s.log.Infof("resource usage back below watermark: %s", key)
+		}
+	}
+	w.exceeded = exceeded
+}
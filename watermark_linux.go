@@ -0,0 +1,25 @@
+//go:build linux
+// +build linux
+
+package stunner
+
+import (
+	"os"
+	"syscall"
+)
+
+// openFiles returns the number of open file descriptors of the daemon and the soft limit
+func openFiles() (int, int, bool) {
+	var limit syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &limit); err != nil ||
+		limit.Cur == 0 || limit.Cur > 1<<31 {
+		// no limit
+		return 0, 0, false
+	}
+	fds, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		return 0, 0, false
+	}
+	// the descriptor of the directory being read is listed too
+	return len(fds) - 1, int(limit.Cur), true
+}